@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/client"
+	"github.com/example/genai-foundation-demo/pkg/eval"
+)
+
+// Exit codes of the eval subcommand
+const (
+	exitPassed     = 0
+	exitRegression = 1
+	exitUsage      = 2
+)
+
+// evalCommand runs a regression dataset against the service and compares the
+// report with a stored baseline, returning the process exit code: non-zero
+// when a metric regressed beyond its threshold, so it can gate releases.
+func evalCommand(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: genai-cli eval -dataset <file.jsonl> [-baseline <report.json>] [flags]")
+		fs.PrintDefaults()
+	}
+	httpAddr := fs.String("addr", "http://localhost:8080", "HTTP base URL of the service")
+	grpcAddr := fs.String("grpc", "", "gRPC address of the service, e.g. localhost:50051 (overrides -addr)")
+	useTLS := fs.Bool("tls", false, "use TLS for the gRPC connection")
+	apiKey := fs.String("api-key", os.Getenv("GENAI_API_KEY"), "API key sent as X-API-Key (default $GENAI_API_KEY)")
+	datasetPath := fs.String("dataset", "", "JSONL dataset of prompts and expected properties (required)")
+	mode := fs.String("mode", "chat", "default chat mode: chat, tool, agent or doc")
+	template := fs.String("template", "", "server-side prompt template applied to every request")
+	templateVersion := fs.Int("template-version", 0, "pin the prompt template version (0 uses the active one)")
+	seed := fs.Int("seed", -1, "seed sent with every request for reproducible replies (negative: none)")
+	judge := fs.Bool("judge", true, "score responses with the judge model")
+	judgeModel := fs.String("judge-model", "", "judge model (default: the service's judge model)")
+	criteria := fs.String("criteria", "", "comma-separated default criteria (default: helpfulness,groundedness,tone)")
+	rag := fs.Bool("rag", false, "RAG mode: run items in doc mode and measure recall@k, context precision and faithfulness")
+	baselinePath := fs.String("baseline", "", "baseline report to compare against")
+	updateBaseline := fs.Bool("update-baseline", false, "write this run's report to -baseline instead of comparing")
+	scoreThreshold := fs.Float64("score-threshold", 0.2, "allowed drop of mean judge scores (1-5 scale)")
+	passThreshold := fs.Float64("pass-threshold", 0.05, "allowed drop of the pass rate (0-1)")
+	retrievalThreshold := fs.Float64("retrieval-threshold", 0.05, "allowed drop of recall@k and context precision (0-1)")
+	minPassRate := fs.Float64("min-pass-rate", 0, "fail when the pass rate is below this (0-1), with or without a baseline")
+	label := fs.String("label", "", "name of the run recorded in the report")
+	out := fs.String("out", "eval-report.json", "path of the JSON report")
+	concurrency := fs.Int("concurrency", 4, "number of items run in parallel")
+	timeout := fs.Duration("timeout", 2*time.Minute, "timeout per item (chat and judge calls)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *datasetPath == "" {
+		log.Print("❌ -dataset is required")
+		return exitUsage
+	}
+	if *updateBaseline && *baselinePath == "" {
+		log.Print("❌ -update-baseline requires -baseline")
+		return exitUsage
+	}
+	if _, ok := eval.ParseMode(*mode); !ok {
+		log.Printf("❌ Unknown mode %q", *mode)
+		return exitUsage
+	}
+	items, err := eval.LoadDataset(*datasetPath)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return exitUsage
+	}
+
+	// Load the baseline up front so a typo doesn't waste a full run
+	var baseline *eval.Report
+	if *baselinePath != "" && !*updateBaseline {
+		baseline, err = eval.LoadReport(*baselinePath)
+		if err != nil {
+			log.Printf("❌ Failed to load baseline: %v", err)
+			return exitUsage
+		}
+	}
+
+	opts := []client.Option{client.WithAPIKey(*apiKey), client.WithPriority(genaidemo.Priority_PRIORITY_LOW)}
+	c, target, err := connect(*httpAddr, *grpcAddr, *useTLS, opts)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return exitUsage
+	}
+	defer c.Close()
+
+	runner := &eval.Runner{
+		Client:          c,
+		Mode:            *mode,
+		Template:        *template,
+		TemplateVersion: int32(*templateVersion),
+		Judge:           *judge,
+		RAG:             *rag,
+		JudgeModel:      *judgeModel,
+		Timeout:         *timeout,
+		Concurrency:     *concurrency,
+		Progress: func(r *eval.Result) {
+			switch {
+			case r.Error != "":
+				log.Printf("   ❌ %s: %s", r.ID, r.Error)
+			case !r.Passed:
+				log.Printf("   ❌ %s: %s", r.ID, failedChecks(r))
+			default:
+				log.Printf("   ✅ %s", r.ID)
+			}
+		},
+	}
+	if *criteria != "" {
+		runner.Criteria = strings.Split(*criteria, ",")
+	}
+	if *seed >= 0 {
+		s := int32(*seed)
+		runner.Seed = &s
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("🧪 Running %d items from %s against %s", len(items), *datasetPath, target)
+	report := runner.Run(ctx, items)
+	report.Label = *label
+	report.Dataset = *datasetPath
+	if ctx.Err() != nil {
+		log.Print("❌ Interrupted")
+		return exitUsage
+	}
+
+	if err := report.Write(*out); err != nil {
+		log.Printf("❌ Failed to write report: %v", err)
+		return exitUsage
+	}
+	fmt.Printf("\n📊 %d/%d items passed (%.1f%%)", report.Passed, report.Items, 100*report.PassRate)
+	if len(report.Criteria) > 0 {
+		fmt.Printf(", mean judge score %.2f", report.Overall)
+	}
+	fmt.Printf(" — report written to %s\n", *out)
+	for _, criterion := range slices.Sorted(maps.Keys(report.Criteria)) {
+		fmt.Printf("   %-16s %.2f\n", criterion, report.Criteria[criterion])
+	}
+	if report.Cost > 0 {
+		fmt.Printf("💰 Estimated cost %.6f\n", report.Cost)
+	}
+	if report.RAG != nil && report.RAG.Items > 0 {
+		fmt.Printf("📚 recall@k %.3f, context precision %.3f", report.RAG.RecallAtK, report.RAG.ContextPrecision)
+		if report.RAG.Faithfulness > 0 {
+			fmt.Printf(", faithfulness %.2f", report.RAG.Faithfulness)
+		}
+		fmt.Println()
+	}
+
+	if *updateBaseline {
+		if err := report.Write(*baselinePath); err != nil {
+			log.Printf("❌ Failed to write baseline: %v", err)
+			return exitUsage
+		}
+		fmt.Printf("📌 Baseline updated: %s\n", *baselinePath)
+		return exitPassed
+	}
+
+	code := exitPassed
+	if report.PassRate < *minPassRate {
+		fmt.Printf("❌ Pass rate %.3f is below the minimum %.3f\n", report.PassRate, *minPassRate)
+		code = exitRegression
+	}
+	if baseline == nil {
+		return code
+	}
+
+	for _, id := range eval.NewFailures(baseline, report) {
+		fmt.Printf("   ⚠️ %s passed in the baseline and fails now\n", id)
+	}
+	regressions := eval.Compare(baseline, report, eval.Thresholds{
+		Score:     *scoreThreshold,
+		PassRate:  *passThreshold,
+		Retrieval: *retrievalThreshold,
+	})
+	for _, r := range regressions {
+		fmt.Printf("❌ Regression: %s\n", r)
+	}
+	if len(regressions) > 0 {
+		return exitRegression
+	}
+	if code == exitPassed {
+		fmt.Printf("✅ No regressions against %s\n", *baselinePath)
+	}
+	return code
+}
+
+// failedChecks describes the failed expectations of a result
+func failedChecks(r *eval.Result) string {
+	var failed []string
+	for _, c := range r.Checks {
+		if !c.Passed {
+			failed = append(failed, c.Name+" "+c.Detail)
+		}
+	}
+	if len(failed) == 0 {
+		return "failed"
+	}
+	return strings.Join(failed, "; ")
+}
@@ -0,0 +1,262 @@
+// Command genai-cli is an interactive chat client for the GenAI service,
+// useful for demos and for smoke-testing deployments. The eval subcommand
+// runs a regression dataset and compares it with a baseline report.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/client"
+)
+
+// modes maps the names accepted by -mode and /mode to chat modes
+var modes = map[string]genaidemo.Mode{
+	"chat":  genaidemo.Mode_MODE_CHAT,
+	"tool":  genaidemo.Mode_MODE_TOOL,
+	"agent": genaidemo.Mode_MODE_AGENT,
+	"doc":   genaidemo.Mode_MODE_DOC,
+}
+
+const helpText = `Commands:
+  /mode [chat|tool|agent|doc]  Show or switch the chat mode
+  /system <prompt>             Start a new session with a system prompt
+  /new                         Start a new session
+  /history                     Show the messages of the current session
+  /save <file>                 Save the current session to a JSON file
+  /load <file>                 Load a session from a JSON file
+  /usage                       Toggle the token usage line after each reply
+  /help                        Show this help
+  /quit                        Exit
+Anything else is sent as a user message.`
+
+// session is the conversation kept on the client; the service is stateless
+// and receives the whole history with every request
+type session struct {
+	Mode     string               `json:"mode"`
+	Messages []*genaidemo.Message `json:"messages"`
+}
+
+type repl struct {
+	client    *client.Client
+	session   session
+	showUsage bool
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(evalCommand(os.Args[2:]))
+	}
+
+	httpAddr := flag.String("addr", "http://localhost:8080", "HTTP base URL of the service")
+	grpcAddr := flag.String("grpc", "", "gRPC address of the service, e.g. localhost:50051 (overrides -addr)")
+	useTLS := flag.Bool("tls", false, "use TLS for the gRPC connection")
+	apiKey := flag.String("api-key", os.Getenv("GENAI_API_KEY"), "API key sent as X-API-Key (default $GENAI_API_KEY)")
+	mode := flag.String("mode", "chat", "chat mode: chat, tool, agent or doc")
+	priority := flag.String("priority", "", "request priority: low, normal or high")
+	flag.Parse()
+
+	if _, ok := modes[*mode]; !ok {
+		log.Fatalf("❌ Unknown mode %q", *mode)
+	}
+
+	opts := []client.Option{client.WithAPIKey(*apiKey)}
+	if *priority != "" {
+		p, ok := genaidemo.Priority_value["PRIORITY_"+strings.ToUpper(*priority)]
+		if !ok {
+			log.Fatalf("❌ Unknown priority %q", *priority)
+		}
+		opts = append(opts, client.WithPriority(genaidemo.Priority(p)))
+	}
+
+	c, target, err := connect(*httpAddr, *grpcAddr, *useTLS, opts)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer c.Close()
+	fmt.Printf("Connected to %s\n", target)
+	fmt.Println("Type /help for commands.")
+
+	r := &repl{client: c, session: session{Mode: *mode}, showUsage: true}
+	r.run()
+}
+
+// connect creates a client for the gRPC address when set, else for the HTTP
+// base URL, and describes the target
+func connect(httpAddr, grpcAddr string, useTLS bool, opts []client.Option) (*client.Client, string, error) {
+	if grpcAddr == "" {
+		return client.NewHTTP(httpAddr, opts...), httpAddr + " (HTTP)", nil
+	}
+	if !useTLS {
+		opts = append(opts, client.WithInsecure())
+	}
+	c, err := client.Dial(grpcAddr, opts...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", grpcAddr, err)
+	}
+	return c, grpcAddr + " (gRPC)", nil
+}
+
+func (r *repl) run() {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Printf("%s> ", r.session.Mode)
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			if quit := r.command(line); quit {
+				return
+			}
+			continue
+		}
+		r.send(line)
+	}
+}
+
+// command runs a slash command and reports whether the REPL should exit
+func (r *repl) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/quit", "/exit":
+		return true
+	case "/help":
+		fmt.Println(helpText)
+	case "/mode":
+		if arg == "" {
+			fmt.Println(r.session.Mode)
+			break
+		}
+		if _, ok := modes[arg]; !ok {
+			fmt.Printf("Unknown mode %q\n", arg)
+			break
+		}
+		r.session.Mode = arg
+	case "/new":
+		r.session.Messages = nil
+		fmt.Println("Started a new session.")
+	case "/system":
+		if arg == "" {
+			fmt.Println("Usage: /system <prompt>")
+			break
+		}
+		r.session.Messages = []*genaidemo.Message{{Role: genaidemo.Role_ROLE_SYSTEM, Content: arg}}
+		fmt.Println("Started a new session with a system prompt.")
+	case "/history":
+		for _, msg := range r.session.Messages {
+			fmt.Printf("[%s] %s\n", strings.TrimPrefix(msg.GetRole().String(), "ROLE_"), msg.GetContent())
+		}
+	case "/save":
+		if err := r.save(arg); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			break
+		}
+		fmt.Printf("Saved %d messages to %s\n", len(r.session.Messages), arg)
+	case "/load":
+		if err := r.load(arg); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			break
+		}
+		fmt.Printf("Loaded %d messages from %s\n", len(r.session.Messages), arg)
+	case "/usage":
+		r.showUsage = !r.showUsage
+		fmt.Printf("Token usage display: %v\n", r.showUsage)
+	default:
+		fmt.Printf("Unknown command %s, type /help\n", name)
+	}
+	return false
+}
+
+// send adds a user message to the session and streams the reply. The user
+// message is dropped again when the request fails so the session stays valid.
+func (r *repl) send(text string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r.session.Messages = append(r.session.Messages, &genaidemo.Message{Role: genaidemo.Role_ROLE_USER, Content: text})
+	req := &genaidemo.ChatRequest{Messages: r.session.Messages}
+
+	var reply strings.Builder
+	var usage *genaidemo.TokenUsage
+	var cost *genaidemo.Cost
+	for chunk, err := range r.client.Stream(ctx, modes[r.session.Mode], req) {
+		if err != nil {
+			r.session.Messages = r.session.Messages[:len(r.session.Messages)-1]
+			if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+				fmt.Println("\n(interrupted)")
+				return
+			}
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+		fmt.Print(chunk.GetContent())
+		reply.WriteString(chunk.GetContent())
+		if chunk.GetTokenUsage() != nil {
+			usage = chunk.GetTokenUsage()
+		}
+		if chunk.GetEstimatedCost() != nil {
+			cost = chunk.GetEstimatedCost()
+		}
+	}
+	fmt.Println()
+
+	r.session.Messages = append(r.session.Messages, &genaidemo.Message{Role: genaidemo.Role_ROLE_ASSISTANT, Content: reply.String()})
+
+	if r.showUsage && usage != nil {
+		line := fmt.Sprintf("tokens: %d in / %d out / %d total", usage.GetInputTokenNum(), usage.GetOutputTokenNum(), usage.GetTotalTokenNum())
+		if usage.GetContextTokenNum() > 0 {
+			line += fmt.Sprintf(" (%d from documents)", usage.GetContextTokenNum())
+		}
+		if cost != nil {
+			line += fmt.Sprintf(", cost: %.6f %s", cost.GetAmount(), cost.GetCurrency())
+		}
+		fmt.Printf("  [%s]\n", line)
+	}
+}
+
+func (r *repl) save(path string) error {
+	if path == "" {
+		return errors.New("usage: /save <file>")
+	}
+	data, err := json.MarshalIndent(r.session, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (r *repl) load(path string) error {
+	if path == "" {
+		return errors.New("usage: /load <file>")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to decode session: %w", err)
+	}
+	if _, ok := modes[s.Mode]; !ok {
+		s.Mode = r.session.Mode
+	}
+	r.session = s
+	return nil
+}
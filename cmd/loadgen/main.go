@@ -0,0 +1,223 @@
+// Command loadgen fires concurrent chat requests at the GenAI service and
+// reports latency percentiles, throughput, error rates and token/cost totals
+// for capacity planning.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/client"
+	"google.golang.org/grpc/status"
+)
+
+// modes maps the names accepted by -mode to chat modes
+var modes = map[string]genaidemo.Mode{
+	"chat":  genaidemo.Mode_MODE_CHAT,
+	"tool":  genaidemo.Mode_MODE_TOOL,
+	"agent": genaidemo.Mode_MODE_AGENT,
+	"doc":   genaidemo.Mode_MODE_DOC,
+}
+
+// result is the outcome of a single request
+type result struct {
+	latency time.Duration
+	err     error
+	resp    *genaidemo.ChatResponse
+}
+
+func main() {
+	httpAddr := flag.String("addr", "http://localhost:8080", "HTTP base URL of the service")
+	grpcAddr := flag.String("grpc", "", "gRPC address of the service, e.g. localhost:50051 (overrides -addr)")
+	useTLS := flag.Bool("tls", false, "use TLS for the gRPC connection")
+	apiKey := flag.String("api-key", os.Getenv("GENAI_API_KEY"), "API key sent as X-API-Key (default $GENAI_API_KEY)")
+	mode := flag.String("mode", "chat", "chat mode: chat, tool, agent or doc")
+	priority := flag.String("priority", "", "request priority: low, normal or high")
+	prompt := flag.String("prompt", "Say hello in one short sentence.", "user message sent with every request")
+	maxTokens := flag.Int("max-tokens", 0, "max tokens per response (0 uses the service default)")
+	concurrency := flag.Int("concurrency", 10, "number of concurrent workers")
+	requests := flag.Int("requests", 100, "total number of requests (ignored when -duration is set)")
+	duration := flag.Duration("duration", 0, "run for this long instead of a fixed number of requests")
+	timeout := flag.Duration("timeout", 2*time.Minute, "per-request timeout")
+	bypassCache := flag.Bool("bypass-cache", true, "skip the response cache so every request reaches the model")
+	flag.Parse()
+
+	chatMode, ok := modes[*mode]
+	if !ok {
+		log.Fatalf("❌ Unknown mode %q", *mode)
+	}
+
+	// Retries would hide overload from the measurement, so every request is
+	// sent exactly once
+	opts := []client.Option{client.WithAPIKey(*apiKey), client.WithRetry(1, 0)}
+	if *priority != "" {
+		p, ok := genaidemo.Priority_value["PRIORITY_"+strings.ToUpper(*priority)]
+		if !ok {
+			log.Fatalf("❌ Unknown priority %q", *priority)
+		}
+		opts = append(opts, client.WithPriority(genaidemo.Priority(p)))
+	}
+
+	var c *client.Client
+	target := *httpAddr
+	if *grpcAddr != "" {
+		if !*useTLS {
+			opts = append(opts, client.WithInsecure())
+		}
+		var err error
+		c, err = client.Dial(*grpcAddr, opts...)
+		if err != nil {
+			log.Fatalf("❌ Failed to connect to %s: %v", *grpcAddr, err)
+		}
+		defer c.Close()
+		target = *grpcAddr + " (gRPC)"
+	} else {
+		c = client.NewHTTP(*httpAddr, opts...)
+	}
+
+	req := &genaidemo.ChatRequest{
+		Messages:    []*genaidemo.Message{{Role: genaidemo.Role_ROLE_USER, Content: *prompt}},
+		BypassCache: bypassCache,
+	}
+	if *maxTokens > 0 {
+		n := int32(*maxTokens)
+		req.MaxTokens = &n
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+		log.Printf("🚀 Load testing %s: %s mode, %d workers for %v", target, *mode, *concurrency, *duration)
+	} else {
+		log.Printf("🚀 Load testing %s: %s mode, %d workers, %d requests", target, *mode, *concurrency, *requests)
+	}
+
+	// Work tokens; with -duration the feeder runs until the context expires
+	work := make(chan struct{})
+	go func() {
+		defer close(work)
+		for i := 0; *duration > 0 || i < *requests; i++ {
+			select {
+			case work <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				// Requests in flight when -duration expires still finish
+				reqCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), *timeout)
+				start := time.Now()
+				resp, err := c.Send(reqCtx, chatMode, req)
+				cancel()
+				results <- result{latency: time.Since(start), err: err, resp: resp}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	var all []result
+	for r := range results {
+		all = append(all, r)
+		if len(all)%100 == 0 {
+			log.Printf("   %d requests done", len(all))
+		}
+	}
+	report(all, time.Since(start))
+}
+
+// report prints the summary of a run
+func report(results []result, elapsed time.Duration) {
+	var latencies []time.Duration
+	errorsByCode := make(map[string]int)
+	var inputTokens, outputTokens, totalTokens int64
+	var cost float64
+	currency := ""
+
+	for _, r := range results {
+		if r.err != nil {
+			errorsByCode[status.Code(r.err).String()]++
+			continue
+		}
+		latencies = append(latencies, r.latency)
+		if usage := r.resp.GetTokenUsage(); usage != nil {
+			inputTokens += int64(usage.GetInputTokenNum())
+			outputTokens += int64(usage.GetOutputTokenNum())
+			totalTokens += int64(usage.GetTotalTokenNum())
+		}
+		if c := r.resp.GetEstimatedCost(); c != nil {
+			cost += c.GetAmount()
+			currency = c.GetCurrency()
+		}
+	}
+	slices.Sort(latencies)
+
+	total := len(results)
+	failed := total - len(latencies)
+	fmt.Println()
+	fmt.Println("📊 Load test results")
+	fmt.Printf("  Requests:    %d in %v\n", total, elapsed.Round(time.Millisecond))
+	fmt.Printf("  Throughput:  %.2f req/s\n", float64(total)/elapsed.Seconds())
+	fmt.Printf("  Succeeded:   %d\n", len(latencies))
+	fmt.Printf("  Failed:      %d (%.1f%%)\n", failed, percent(failed, total))
+
+	codes := make([]string, 0, len(errorsByCode))
+	for code := range errorsByCode {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("    %-20s %d\n", code, errorsByCode[code])
+	}
+
+	if len(latencies) > 0 {
+		fmt.Println("  Latency (successful requests):")
+		for _, p := range []float64{50, 90, 95, 99} {
+			fmt.Printf("    p%-4g %v\n", p, percentile(latencies, p).Round(time.Millisecond))
+		}
+		fmt.Printf("    max   %v\n", latencies[len(latencies)-1].Round(time.Millisecond))
+	}
+
+	fmt.Printf("  Tokens:      %d in / %d out / %d total\n", inputTokens, outputTokens, totalTokens)
+	if currency != "" {
+		fmt.Printf("  Est. cost:   %.6f %s\n", cost, currency)
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies (nearest rank)
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(idx, 0)]
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
@@ -0,0 +1,154 @@
+#!/usr/bin/env python3
+"""
+Document Access Control
+Chunks carry the groups and roles allowed to retrieve them, and queries of
+the Go service name the caller's groups and roles so only documents shared
+with them, or public ones, are returned. A chunk's access is stored as:
+
+- acl_groups and acl_roles, comma-separated lists for display
+- acl_group:<name> and acl_role:<name> set to true, matched by queries
+- acl_public, true when the chunk names no group or role
+
+Run directly to change the access of stored documents, or to mark chunks
+ingested before access control as public:
+
+    python acl.py set --source upload://handbook.pdf --groups hr,managers
+    python acl.py backfill
+"""
+
+import argparse
+import re
+import sys
+from typing import Dict, List, Optional
+
+import chromadb
+
+GROUPS_KEY = "acl_groups"
+ROLES_KEY = "acl_roles"
+PUBLIC_KEY = "acl_public"
+GROUP_PREFIX = "acl_group:"
+ROLE_PREFIX = "acl_role:"
+
+# Group and role names, as accepted by the Go service
+NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_.@-]{1,64}$")
+
+# Chunks read at a time when changing stored access
+BATCH_SIZE = 1000
+
+
+def parse_names(value: Optional[str]) -> List[str]:
+    """Parse a comma-separated list of group or role names"""
+    names = sorted({name.strip() for name in (value or "").split(",") if name.strip()})
+    for name in names:
+        if not NAME_PATTERN.match(name):
+            raise ValueError(f"Invalid group or role '{name}'")
+    return names
+
+
+def acl_metadata(groups: Optional[str], roles: Optional[str], previous: Optional[Dict] = None) -> Dict:
+    """Return the chunk metadata sharing a document with groups and roles,
+    public when there are none. Flags of the previous metadata that no
+    longer apply are set to false, as updates merge metadata."""
+    group_names, role_names = parse_names(groups), parse_names(roles)
+    metadata = {
+        key: False for key, value in (previous or {}).items()
+        if key.startswith((GROUP_PREFIX, ROLE_PREFIX)) and value is True
+    }
+    metadata.update({GROUP_PREFIX + name: True for name in group_names})
+    metadata.update({ROLE_PREFIX + name: True for name in role_names})
+    metadata[GROUPS_KEY] = ",".join(group_names)
+    metadata[ROLES_KEY] = ",".join(role_names)
+    metadata[PUBLIC_KEY] = not group_names and not role_names
+    return metadata
+
+
+def same_access(a: Dict, b: Dict) -> bool:
+    """Report whether two chunks are shared with the same groups and roles,
+    counting chunks without access metadata as public"""
+    return all((a or {}).get(key, "") == (b or {}).get(key, "") for key in (GROUPS_KEY, ROLES_KEY))
+
+
+def access_filter(groups: List[str], roles: List[str]) -> Dict:
+    """Return the where clause matching the chunks a caller with groups and
+    roles may retrieve"""
+    conditions = [{PUBLIC_KEY: True}]
+    conditions += [{GROUP_PREFIX + name: True} for name in groups if NAME_PATTERN.match(name)]
+    conditions += [{ROLE_PREFIX + name: True} for name in roles if NAME_PATTERN.match(name)]
+    return conditions[0] if len(conditions) == 1 else {"$or": conditions}
+
+
+def update_access(collection, where: Optional[Dict], groups: Optional[str], roles: Optional[str],
+                  missing_only: bool = False) -> int:
+    """Set the access of the chunks matching where, only of those without
+    any when missing_only, and return how many changed"""
+    updated = 0
+    offset = 0
+    while True:
+        batch = collection.get(where=where, include=["metadatas"], limit=BATCH_SIZE, offset=offset)
+        if not batch["ids"]:
+            break
+        offset += len(batch["ids"])
+        ids, metadatas = [], []
+        for chunk_id, metadata in zip(batch["ids"], batch["metadatas"]):
+            if missing_only and PUBLIC_KEY in (metadata or {}):
+                continue
+            ids.append(chunk_id)
+            metadatas.append(acl_metadata(groups, roles, metadata))
+        if ids:
+            collection.update(ids=ids, metadatas=metadatas)
+            updated += len(ids)
+    return updated
+
+
+def main():
+    # pdf_embedder imports this module for acl_metadata
+    from pdf_embedder import DEFAULT_COLLECTION, tenant_collection
+
+    parser = argparse.ArgumentParser(description="Change the access of documents stored in ChromaDB")
+    parser.add_argument("command", choices=("set", "backfill"),
+                       help="Share a document with groups and roles (set), or give chunks without "
+                            "access metadata the given access, public by default (backfill)")
+    parser.add_argument("--db-path", "-d", default="./chroma_db",
+                       help="ChromaDB storage path (default: ./chroma_db)")
+    parser.add_argument("--tenant", "-t",
+                       help="Change documents in the collection of this tenant")
+    parser.add_argument("--tenants-file",
+                       help="The service's TENANTS_FILE, to look up the tenant's collection")
+    parser.add_argument("--collection", "-c",
+                       help=f"Collection name without a tenant (default: {DEFAULT_COLLECTION})")
+    parser.add_argument("--source",
+                       help="Source of the document to change, as stored in its chunks' metadata")
+    parser.add_argument("--groups", default="",
+                       help="Comma-separated groups allowed to retrieve the chunks")
+    parser.add_argument("--roles", default="",
+                       help="Comma-separated roles allowed to retrieve the chunks")
+    args = parser.parse_args()
+
+    if args.command == "set" and not args.source:
+        parser.error("set needs --source")
+    if args.tenant:
+        if args.collection:
+            parser.error("--collection cannot be combined with --tenant")
+        collection_name = tenant_collection(args.tenant, args.tenants_file)
+    else:
+        collection_name = args.collection or DEFAULT_COLLECTION
+    try:
+        parse_names(args.groups)
+        parse_names(args.roles)
+    except ValueError as e:
+        parser.error(str(e))
+
+    try:
+        client = chromadb.PersistentClient(path=args.db_path)
+        collection = client.get_collection(name=collection_name)
+        where = {"source": args.source} if args.source else None
+        updated = update_access(collection, where, args.groups, args.roles, args.command == "backfill")
+        access = ", ".join(filter(None, [args.groups, args.roles])) or "public"
+        print(f"Set the access of {updated} chunks to {access}")
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,99 @@
+#!/usr/bin/env python3
+"""
+Bucket Ingestion Connector for ChromaDB
+Syncs the documents under a GCS or S3 bucket prefix into ChromaDB. Objects
+are re-embedded when their generation (GCS) or ETag (S3) changes, and their
+chunks removed when they are deleted from the bucket.
+"""
+
+import argparse
+import sys
+from pathlib import PurePosixPath
+from typing import Iterator
+from urllib.parse import urlparse
+
+from connector_common import (Document, SyncState, add_embedder_args, create_embedder, extract_text,
+                              is_supported, run, sync_documents)
+
+
+class GCSBucket:
+    """Objects of a Google Cloud Storage bucket, read with the application
+    default credentials"""
+
+    def __init__(self, bucket: str, prefix: str):
+        from google.cloud import storage
+        self.client = storage.Client()
+        self.bucket = self.client.bucket(bucket)
+        self.prefix = prefix
+
+    def list(self) -> Iterator[Document]:
+        for blob in self.client.list_blobs(self.bucket, prefix=self.prefix):
+            yield (f"gs://{self.bucket.name}/{blob.name}", str(blob.generation),
+                   {"filename": PurePosixPath(blob.name).name, "content_type": blob.content_type or ""})
+
+    def download(self, name: str) -> bytes:
+        return self.bucket.blob(name).download_as_bytes()
+
+
+class S3Bucket:
+    """Objects of an Amazon S3 bucket, read with the default AWS credentials"""
+
+    def __init__(self, bucket: str, prefix: str):
+        import boto3
+        self.client = boto3.client("s3")
+        self.bucket = bucket
+        self.prefix = prefix
+
+    def list(self) -> Iterator[Document]:
+        paginator = self.client.get_paginator("list_objects_v2")
+        for page in paginator.paginate(Bucket=self.bucket, Prefix=self.prefix):
+            for obj in page.get("Contents", []):
+                yield (f"s3://{self.bucket}/{obj['Key']}", obj["ETag"].strip('"'),
+                       {"filename": PurePosixPath(obj["Key"]).name})
+
+    def download(self, name: str) -> bytes:
+        return self.client.get_object(Bucket=self.bucket, Key=name)["Body"].read()
+
+
+def open_bucket(uri: str):
+    """Open a gs://bucket/prefix or s3://bucket/prefix URI"""
+    parsed = urlparse(uri)
+    if not parsed.netloc:
+        raise ValueError(f"Invalid bucket URI '{uri}'")
+    prefix = parsed.path.lstrip("/")
+    if parsed.scheme == "gs":
+        return GCSBucket(parsed.netloc, prefix)
+    if parsed.scheme == "s3":
+        return S3Bucket(parsed.netloc, prefix)
+    raise ValueError(f"Unsupported bucket URI '{uri}', use gs:// or s3://")
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Sync a GCS or S3 bucket prefix into ChromaDB")
+    parser.add_argument("uri", help="Bucket prefix to sync, gs://bucket/prefix or s3://bucket/prefix")
+    add_embedder_args(parser)
+    args = parser.parse_args()
+
+    try:
+        bucket = open_bucket(args.uri)
+        embedder = create_embedder(parser, args)
+        state = SyncState.for_source(args, embedder, args.uri)
+
+        def objects() -> Iterator[Document]:
+            for source, version, metadata in bucket.list():
+                if is_supported(embedder, source):
+                    yield source, version, metadata
+
+        def fetch_text(source: str) -> str:
+            name = urlparse(source).path.lstrip("/")
+            return extract_text(embedder, name, bucket.download(name))
+
+        print(f"Syncing {args.uri} into collection '{embedder.collection_name}'")
+        run(lambda: sync_documents(embedder, state, objects(), fetch_text), args.interval)
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
@@ -5,40 +5,119 @@ Starts ChromaDB as a service with HTTP API access
 """
 
 import os
+import re
 import sys
+import hmac
+import json
+import sqlite3
+import threading
 import argparse
 import signal
 from pathlib import Path
 import chromadb
 from chromadb.config import Settings
 import uvicorn
-from fastapi import FastAPI, HTTPException
+from fastapi import Depends, FastAPI, Header, HTTPException, Request
 from fastapi.middleware.cors import CORSMiddleware
+from starlette.concurrency import run_in_threadpool
 from pydantic import BaseModel
-from typing import List, Optional, Dict, Any
+from typing import List, Optional, Dict, Any, Tuple
 import logging
 
+from acl import GROUPS_KEY, ROLES_KEY, access_filter, acl_metadata
+from query_cache import DEFAULT_SIZE, DEFAULT_TTL, QueryCache, query_key
+from pdf_embedder import DEFAULT_MODEL, VERTEX_MODEL_PREFIX, PDFEmbedder, load_embedding_model, tenant_collection
+from reduction import EmbeddingReducer
+from scheduler import Scheduler, load_jobs
+
+# Largest document accepted by POST /documents, as the Go service's default
+MAX_UPLOAD_BYTES = 512 << 20
+# Sentences highlighted per query result
+MAX_HIGHLIGHTS = 2
+# Sentences end at sentence punctuation or line breaks, as in the Go
+# service's keyword highlights
+SENTENCE_PATTERN = re.compile(r"[^.!?。！？\n]+[.!?。！？]*")
+# Chunks read at a time when computing collection statistics
+STATS_BATCH_SIZE = 1000
+
 # Configure logging
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
 
+class DocumentAccess(BaseModel):
+    groups: Optional[List[str]] = None
+    roles: Optional[List[str]] = None
+
 class QueryRequest(BaseModel):
     query: str
     n_results: int = 5
     include_metadata: bool = True
+    collection: Optional[str] = None
+    where: Optional[Dict[str, str]] = None
+    # Embedding of the query by the caller, with the collection's model
+    query_embedding: Optional[List[float]] = None
+    # Groups and roles of the caller, which only retrieves public documents
+    # and those shared with them; every document when unset
+    access: Optional[DocumentAccess] = None
+    # Return the sentences of each document most similar to the query
+    highlights: bool = False
+
+class DocumentChunksRequest(BaseModel):
+    # Source of the document, as returned when it was ingested
+    source: str
+    collection: Optional[str] = None
+    tenant: Optional[str] = None
+    # Groups and roles of the caller, as for queries
+    access: Optional[DocumentAccess] = None
+
+class CollectionRequest(BaseModel):
+    # Embedding model of the collection if it is created, the service's
+    # --embedding-model by default
+    embedding_model: Optional[str] = None
 
 class QueryResponse(BaseModel):
     documents: List[str]
     metadatas: Optional[List[Dict[str, Any]]] = None
     distances: Optional[List[float]] = None
     ids: List[str]
+    # Spans of each document, start and end in characters
+    highlights: Optional[List[List[Dict[str, Any]]]] = None
+
+def sentence_spans(text: str) -> List[tuple]:
+    """Return the start and end offsets of the sentences of text, without
+    surrounding whitespace"""
+    spans = []
+    for match in SENTENCE_PATTERN.finditer(text):
+        sentence = match.group()
+        start = match.start() + len(sentence) - len(sentence.lstrip())
+        end = match.end() - len(sentence) + len(sentence.rstrip())
+        if end > start:
+            spans.append((start, end))
+    return spans
 
 class ChromaDBService:
-    def __init__(self, db_path: str = "./chroma_db", collection_name: str = "pdf_documents"):
+    def __init__(self, db_path: str = "./chroma_db", collection_name: str = "pdf_documents",
+                 query_cache_ttl: float = DEFAULT_TTL, query_cache_size: int = DEFAULT_SIZE,
+                 embedding_model: str = DEFAULT_MODEL, bootstrap: bool = True,
+                 tenant_collections: Optional[List[str]] = None):
         self.db_path = Path(db_path)
         self.collection_name = collection_name
+        # Missing configured collections are created empty with the
+        # embedding model, instead of failing queries
+        self.embedding_model = embedding_model
+        self.bootstrap = bootstrap
+        self.tenant_collections = tenant_collections or []
         self.client = None
         self.collection = None
+        # Models of the collections embedded with another than Chroma's
+        # default, to embed their queries with
+        self.models = {}
+        # Dimension reductions of collections by id and reduction
+        self.reducers = {}
+        # Embedders of uploaded documents per collection and tenant
+        self.embedders = {}
+        self.ingest_lock = threading.Lock()
+        self.query_cache = QueryCache(query_cache_ttl, query_cache_size)
         
     def initialize(self):
         """Initialize ChromaDB client and collection"""
@@ -52,37 +131,374 @@ class ChromaDBService:
                 count = self.collection.count()
                 logger.info(f"Connected to collection '{self.collection_name}' with {count} documents")
             except Exception as e:
-                logger.warning(f"Collection '{self.collection_name}' not found: {e}")
-                self.collection = None
+                if not self.bootstrap:
+                    logger.warning(f"Collection '{self.collection_name}' not found: {e}")
+                    self.collection = None
+                else:
+                    self.collection, _ = self.bootstrap_collection(self.collection_name)
+            if self.bootstrap:
+                for name in self.tenant_collections:
+                    self.bootstrap_collection(name)
                 
         except Exception as e:
             logger.error(f"Failed to initialize ChromaDB: {e}")
             raise
     
-    def query_documents(self, query: str, n_results: int = 5, include_metadata: bool = True) -> Dict:
-        """Query the document collection"""
-        if not self.collection:
-            raise HTTPException(status_code=404, detail="No collection available. Please embed some documents first.")
+    def refresh(self):
+        """Reconnect to the default collection, which a job may have created
+        or replaced"""
+        try:
+            self.collection = self.client.get_collection(name=self.collection_name)
+        except Exception:
+            self.collection = None
+        self.embedders = {}
+        self.reducers = {}
+        self.query_cache.invalidate()
+    
+    def bootstrap_collection(self, name: str, model_name: Optional[str] = None) -> Tuple[Any, bool]:
+        """Return a collection and whether it was created: collections that
+        don't exist are created empty, with the metadata the embedder gives
+        new collections so their chunks and queries are embedded with the
+        model"""
+        try:
+            return self.client.get_collection(name=name), False
+        except Exception:
+            pass
+        model_name = model_name or self.embedding_model
+        embedder = PDFEmbedder(db_path=str(self.db_path), collection_name=name, model_name=model_name)
+        collection = self.client.get_or_create_collection(name=name, metadata=embedder.collection_metadata())
+        logger.info(f"Created collection '{name}' embedded with {model_name}")
+        return collection, True
+    
+    def model(self, name: str):
+        """Return an embedding model, loaded once"""
+        if name not in self.models:
+            logger.info(f"Loading embedding model {name}")
+            self.models[name] = load_embedding_model(name)
+        return self.models[name]
+    
+    def reducer(self, target) -> Optional[EmbeddingReducer]:
+        """Return the dimension reduction of a collection, loaded once"""
+        key = (str(target.id), (target.metadata or {}).get("embedding_reduction"))
+        if key not in self.reducers:
+            self.reducers[key] = EmbeddingReducer.for_collection(target, self.db_path)
+        return self.reducers[key]
+    
+    def collection_spec(self, target) -> Dict:
+        """Return the embedding model and dimension of a collection. The
+        dimension of collections embedded before it was declared is read from
+        a stored chunk, and is None while they are empty. Reduced collections
+        also report their reduction and the dimension of the model's
+        embeddings, which queries may be sent in."""
+        metadata = target.metadata or {}
+        dimension = metadata.get("embedding_dimension")
+        if dimension is None:
+            first = target.get(include=["embeddings"], limit=1)
+            if first["ids"]:
+                dimension = len(first["embeddings"][0])
+        return {
+            "name": target.name,
+            "embedding_model": metadata.get("embedding_model", DEFAULT_MODEL),
+            "embedding_dimension": dimension,
+            "embedding_reduction": metadata.get("embedding_reduction"),
+            "model_dimension": metadata.get("model_dimension"),
+        }
+    
+    def query_embedding(self, target, query: str) -> Optional[List[float]]:
+        """Embed a query with the collection's model and reduction, None when
+        Chroma's default embedding function matches them"""
+        model_name = (target.metadata or {}).get("embedding_model", DEFAULT_MODEL)
+        reducer = self.reducer(target)
+        if model_name == DEFAULT_MODEL and not reducer:
+            return None
+        embeddings = self.model(model_name).encode([query], normalize_embeddings=True)
+        if reducer:
+            embeddings = reducer.reduce(embeddings)
+        return embeddings[0].tolist()
+    
+    def embedder_for(self, collection: str, tenant: Optional[str]) -> PDFEmbedder:
+        """Return the embedder of uploads to a collection, embedding with
+        the collection's model"""
+        key = (collection, tenant)
+        if key not in self.embedders:
+            try:
+                existing = self.client.get_collection(name=collection)
+                model_name = (existing.metadata or {}).get("embedding_model", DEFAULT_MODEL)
+            except Exception:
+                model_name = self.embedding_model
+            embedder = PDFEmbedder(db_path=str(self.db_path), collection_name=collection, tenant=tenant,
+                                   model_name=model_name)
+            embedder.initialize_chromadb()
+            embedder.model = self.model(model_name)
+            embedder.initialize_ocr()
+            self.embedders[key] = embedder
+        return self.embedders[key]
+    
+    def ingest_document(self, data: bytes, filename: str, collection: Optional[str], tenant: Optional[str],
+                        metadata: Dict[str, str]) -> Dict:
+        """Extract, chunk and embed an uploaded document, replacing the
+        previous upload of the same file name"""
+        from connector_common import extract_text, is_supported, source_id
+        
+        with self.ingest_lock:
+            embedder = self.embedder_for(collection or self.collection_name, tenant)
+            if not is_supported(embedder, filename):
+                raise HTTPException(status_code=400, detail=f"Unsupported file type '{Path(filename).suffix}'")
+            text = extract_text(embedder, filename, data)
+            if not text:
+                raise HTTPException(status_code=400, detail="No text could be extracted")
+            
+            # Uploads are tenant scoped, even when tenants share a collection
+            source = f"upload://{tenant}/{filename}" if tenant else f"upload://{filename}"
+            embedder.delete_source(source)
+            if not embedder.embed_text(text, source_id(source), source, {**metadata, "filename": filename}):
+                raise HTTPException(status_code=500, detail="Failed to store the document")
+            chunks = len(embedder.collection.get(where={"source": source}, include=[])["ids"])
+        self.query_cache.invalidate(embedder.collection_name)
+        
+        if embedder.collection_name == self.collection_name and not self.collection:
+            self.collection = embedder.collection
+        logger.info(f"Ingested {filename} into '{embedder.collection_name}' ({chunks} chunks)")
+        return {"source": source, "chunks": chunks}
+    
+    def get_query_collection(self, name: Optional[str]):
+        """Return the default collection, or the named one"""
+        if not name or name == self.collection_name:
+            # The default collection may have been deleted since startup
+            if not self.collection and self.bootstrap:
+                self.collection, _ = self.bootstrap_collection(self.collection_name)
+            if not self.collection:
+                raise HTTPException(status_code=404, detail="No collection available. Please embed some documents first.")
+            return self.collection
+        try:
+            return self.client.get_collection(name=name)
+        except Exception:
+            raise HTTPException(status_code=404, detail=f"Collection '{name}' not found")
+
+    def query_documents(self, query: str, n_results: int = 5, include_metadata: bool = True,
+                        collection: Optional[str] = None, where: Optional[Dict[str, str]] = None,
+                        query_embedding: Optional[List[float]] = None,
+                        access: Optional[DocumentAccess] = None, highlights: bool = False) -> Dict:
+        """Query the document collection, keeping documents whose metadata
+        matches where and, given an access, that are shared with it. The
+        query is embedded with the collection's model, unless the caller
+        did."""
+        target = self.get_query_collection(collection)
+        # Only plain equalities are accepted, operators can't widen the query
+        if any(key.startswith("$") for key in (where or {})):
+            raise HTTPException(status_code=400, detail="Filter keys cannot be operators")
+        
+        # Embeddings of another dimension can't be compared with the collection's
+        embedding = query_embedding or self.query_embedding(target, query)
+        if embedding:
+            spec = self.collection_spec(target)
+            # Callers embedding with the model send full embeddings, reduced here
+            if query_embedding and spec["model_dimension"] and len(embedding) == spec["model_dimension"]:
+                embedding = self.reducer(target).reduce([embedding])[0].tolist()
+            if spec["embedding_dimension"] and len(embedding) != spec["embedding_dimension"]:
+                raise HTTPException(status_code=400, detail=(
+                    f"Query embedding has {len(embedding)} dimensions, collection '{target.name}' "
+                    f"is embedded with {spec['embedding_model']} ({spec['embedding_dimension']} dimensions)"))
         
         try:
             include = ["documents", "distances", "metadatas"] if include_metadata else ["documents", "distances"]
             
-            results = self.collection.query(
-                query_texts=[query],
+            # Chroma takes a single field per condition, several are combined with $and
+            conditions = [{key: value} for key, value in (where or {}).items()]
+            if access is not None:
+                conditions.append(access_filter(access.groups or [], access.roles or []))
+            where_clause = None
+            if len(conditions) == 1:
+                where_clause = conditions[0]
+            elif conditions:
+                where_clause = {"$and": conditions}
+            
+            key = None
+            if self.query_cache.enabled:
+                key = query_key(target.id, query, embedding, n_results=n_results, where=where_clause,
+                                include=include, highlights=highlights)
+                cached = self.query_cache.get(key)
+                if cached is not None:
+                    return cached
+            
+            results = target.query(
+                query_texts=None if embedding else [query],
+                query_embeddings=[embedding] if embedding else None,
                 n_results=n_results,
+                where=where_clause,
                 include=include
             )
             
-            return {
-                "documents": results["documents"][0] if results["documents"] else [],
+            documents = results["documents"][0] if results["documents"] else []
+            result = {
+                "documents": documents,
                 "metadatas": results["metadatas"][0] if include_metadata and results.get("metadatas") else None,
                 "distances": results["distances"][0] if results["distances"] else [],
-                "ids": results["ids"][0] if results["ids"] else []
+                "ids": results["ids"][0] if results["ids"] else [],
+                "highlights": self.highlight_spans(target, query, documents) if highlights else None
             }
+            if key:
+                self.query_cache.put(key, target.name, result)
+            return result
         except Exception as e:
             logger.error(f"Query failed: {e}")
             raise HTTPException(status_code=500, detail=f"Query failed: {str(e)}")
     
+    def document_chunks(self, source: str, collection: Optional[str] = None, tenant: Optional[str] = None,
+                        access: Optional[DocumentAccess] = None) -> Dict:
+        """Return the chunks of a document in order, if it is shared with
+        the access"""
+        target = self.get_query_collection(collection)
+        conditions = [{"source": source}]
+        if tenant:
+            conditions.append({"tenant": tenant})
+        if access is not None:
+            conditions.append(access_filter(access.groups or [], access.roles or []))
+        where = conditions[0] if len(conditions) == 1 else {"$and": conditions}
+        
+        results = target.get(where=where, include=["documents", "metadatas"])
+        if not results["ids"]:
+            raise HTTPException(status_code=404, detail=f"Document '{source}' not found")
+        chunks = sorted(zip(results["metadatas"], results["documents"]),
+                        key=lambda chunk: (chunk[0] or {}).get("chunk_index", 0))
+        return {"source": source, "chunks": [document for _, document in chunks]}
+    
+    def highlight_spans(self, target, query: str, documents: List[str]) -> Optional[List[List[Dict]]]:
+        """Return the sentences of each document most similar to the query,
+        by embedding them with the collection's model. None for collections
+        of Vertex AI models, which the Go service highlights by keywords
+        rather than paying for an embedding per sentence."""
+        model_name = (target.metadata or {}).get("embedding_model", DEFAULT_MODEL)
+        if model_name.startswith(VERTEX_MODEL_PREFIX):
+            return None
+        spans = [sentence_spans(document) for document in documents]
+        sentences = [document[start:end] for document, document_spans in zip(documents, spans)
+                     for start, end in document_spans]
+        if not sentences:
+            return [[] for _ in documents]
+        try:
+            embeddings = self.model(model_name).encode([query] + sentences, normalize_embeddings=True)
+        except Exception as e:
+            logger.warning(f"Highlighting failed: {e}")
+            return None
+        scores = embeddings[1:] @ embeddings[0]
+        
+        highlights = []
+        offset = 0
+        for document_spans in spans:
+            ranked = sorted(((float(scores[offset + i]), start, end) for i, (start, end) in enumerate(document_spans)),
+                            reverse=True)
+            highlights.append([{"start": start, "end": end, "score": round(max(score, 0.0), 4)}
+                               for score, start, end in ranked[:MAX_HIGHLIGHTS]])
+            offset += len(document_spans)
+        return highlights
+    
+    def collection_stats(self, name: str, tenant: Optional[str] = None) -> Dict:
+        """Count the documents and chunks of a collection, only those of a
+        tenant when given, with its embedding model and dimension, the time
+        of its last sync or ingestion and the size of its vector index"""
+        target = self.get_query_collection(name)
+        where = {"tenant": tenant} if tenant else None
+        
+        sources = set()
+        chunks = 0
+        last_sync = 0
+        offset = 0
+        while True:
+            batch = target.get(where=where, include=["metadatas"], limit=STATS_BATCH_SIZE, offset=offset)
+            if not batch["ids"]:
+                break
+            for metadata in batch["metadatas"]:
+                metadata = metadata or {}
+                sources.add(metadata.get("source"))
+                last_sync = max(last_sync, int(metadata.get("embedded_at", 0)))
+            chunks += len(batch["ids"])
+            offset += len(batch["ids"])
+        
+        dimension = 0
+        if chunks:
+            first = target.get(where=where, include=["embeddings"], limit=1)
+            dimension = len(first["embeddings"][0])
+        # Connector syncs that found nothing new still count, but their state
+        # files cover the whole collection, so they are left out for tenants
+        if not tenant:
+            for state_file in (self.db_path / "sync_state").glob(f"{target.name}_*.json"):
+                last_sync = max(last_sync, int(state_file.stat().st_mtime))
+        
+        return {
+            "collection": target.name,
+            "document_count": len(sources - {None}),
+            "chunk_count": chunks,
+            "embedding_model": (target.metadata or {}).get("embedding_model", DEFAULT_MODEL),
+            "embedding_dimension": dimension,
+            "last_sync_at": last_sync or None,
+            "storage_bytes": self.storage_bytes(target),
+        }
+    
+    def collection_freshness(self, name: str) -> Dict:
+        """Report when a collection last changed, by its newest chunk or the
+        last completed connector sync, and the last sync of each connector
+        keeping its state next to the database"""
+        target = self.get_query_collection(name)
+        connectors = self.connector_syncs(target)
+        last_updated = max([self.last_embedded_at(target)] + [c["last_success_at"] or 0 for c in connectors])
+        return {
+            "collection": target.name,
+            "last_updated_at": last_updated or None,
+            "connectors": connectors,
+        }
+    
+    def last_embedded_at(self, target) -> int:
+        """Return when the newest chunk of a collection was embedded, read
+        from chroma.sqlite3 instead of every chunk's metadata"""
+        try:
+            with sqlite3.connect(f"file:{self.db_path / 'chroma.sqlite3'}?mode=ro", uri=True) as conn:
+                row = conn.execute(
+                    "SELECT MAX(m.int_value) FROM embedding_metadata m "
+                    "JOIN embeddings e ON e.id = m.id JOIN segments s ON s.id = e.segment_id "
+                    "WHERE s.collection = ? AND m.key = 'embedded_at'", (str(target.id),)).fetchone()
+        except sqlite3.Error as e:
+            logger.warning(f"Could not read the chunks of '{target.name}': {e}")
+            return 0
+        return int(row[0] or 0)
+    
+    def connector_syncs(self, target) -> List[Dict]:
+        """Return the last sync of each connector syncing into a collection"""
+        syncs = []
+        for state_file in sorted((self.db_path / "sync_state").glob(f"{target.name}_*.json")):
+            try:
+                with open(state_file) as f:
+                    state = json.load(f)
+            except (OSError, ValueError) as e:
+                logger.warning(f"Could not read {state_file}: {e}")
+                continue
+            last_sync = state.get("last_sync") or {}
+            syncs.append({
+                "source": state.get("source") or state_file.stem,
+                "status": last_sync.get("status", "unknown"),
+                "last_sync_at": last_sync.get("finished_at"),
+                # State files written before syncs recorded their outcome
+                # were last saved by a sync
+                "last_success_at": state.get("last_success_at") if last_sync else int(state_file.stat().st_mtime),
+                "error": last_sync.get("error"),
+                "document_count": len(state.get("documents", {})),
+            })
+        return syncs
+    
+    def storage_bytes(self, target) -> int:
+        """Return the size of a collection's vector index files. Documents and
+        metadata of every collection share chroma.sqlite3, which isn't
+        counted."""
+        try:
+            with sqlite3.connect(f"file:{self.db_path / 'chroma.sqlite3'}?mode=ro", uri=True) as conn:
+                segments = [row[0] for row in conn.execute("SELECT id FROM segments WHERE collection = ?",
+                                                           (str(target.id),))]
+        except sqlite3.Error as e:
+            logger.warning(f"Could not read the segments of '{target.name}': {e}")
+            return 0
+        return sum(path.stat().st_size for segment in segments
+                   for path in (self.db_path / segment).rglob("*") if path.is_file())
+    
     def get_stats(self) -> Dict:
         """Get collection statistics"""
         if not self.collection:
@@ -94,13 +510,17 @@ class ChromaDBService:
                 "status": "ready",
                 "collection_name": self.collection_name,
                 "document_count": count,
-                "db_path": str(self.db_path)
+                "db_path": str(self.db_path),
+                "query_cache": self.query_cache.stats(),
+                "freshness": self.collection_freshness(self.collection_name)
             }
         except Exception as e:
             return {"status": "error", "message": str(e)}
 
 # Global service instance
 service = None
+# Scheduler of the sync and re-embedding jobs, when a jobs file is given
+scheduler = None
 
 # FastAPI app
 app = FastAPI(
@@ -125,7 +545,28 @@ async def startup_event():
     # Initialize service here
     if service:
         logger.info("Service already initialized")
-    
+    if scheduler:
+        scheduler.start()
+
+@app.on_event("shutdown")
+async def shutdown_event():
+    if scheduler:
+        scheduler.stop()
+
+def require_admin(authorization: Optional[str] = Header(None)):
+    """Admin endpoints take the ADMIN_TOKEN as a bearer token, and are
+    disabled without one"""
+    token = os.getenv("ADMIN_TOKEN")
+    if not token:
+        raise HTTPException(status_code=403, detail="Admin endpoints are disabled, set ADMIN_TOKEN")
+    if not authorization or not hmac.compare_digest(authorization, f"Bearer {token}"):
+        raise HTTPException(status_code=401, detail="Invalid admin token")
+
+def get_scheduler() -> Scheduler:
+    if not scheduler:
+        raise HTTPException(status_code=404, detail="No jobs are scheduled, start the service with --jobs-file")
+    return scheduler
+
 @app.get("/")
 async def root():
     return {"message": "ChromaDB Document Search API", "status": "running"}
@@ -148,6 +589,53 @@ async def get_stats():
     
     return service.get_stats()
 
+@app.get("/collections/{name}")
+async def collection_spec(name: str):
+    """Get the embedding model and dimension a collection declares"""
+    global service
+    if not service or not service.client:
+        raise HTTPException(status_code=503, detail="Service not initialized")
+    
+    return service.collection_spec(service.get_query_collection(name))
+
+@app.put("/collections/{name}")
+async def create_collection(name: str, request: Optional[CollectionRequest] = None):
+    """Create a collection unless it exists, returning its embedding model
+    and dimension and whether it was created"""
+    global service
+    if not service or not service.client:
+        raise HTTPException(status_code=503, detail="Service not initialized")
+    
+    model_name = request.embedding_model if request else None
+    try:
+        target, created = await run_in_threadpool(service.bootstrap_collection, name, model_name)
+    except Exception as e:
+        raise HTTPException(status_code=400, detail=f"Cannot create collection '{name}': {e}")
+    spec = service.collection_spec(target)
+    if model_name and spec["embedding_model"] != model_name:
+        raise HTTPException(status_code=409, detail=f"Collection '{name}' is embedded with {spec['embedding_model']}")
+    return {**spec, "created": created}
+
+@app.get("/collections/{name}/stats")
+async def collection_stats(name: str, tenant: Optional[str] = None):
+    """Get the statistics of a collection, only counting a tenant's documents
+    when given"""
+    global service
+    if not service or not service.client:
+        raise HTTPException(status_code=503, detail="Service not initialized")
+    
+    # Reading every chunk's metadata is kept off the event loop
+    return await run_in_threadpool(service.collection_stats, name, tenant)
+
+@app.get("/collections/{name}/freshness")
+async def collection_freshness(name: str):
+    """Get when a collection last changed and the status of its connectors"""
+    global service
+    if not service or not service.client:
+        raise HTTPException(status_code=503, detail="Service not initialized")
+    
+    return await run_in_threadpool(service.collection_freshness, name)
+
 @app.post("/query", response_model=QueryResponse)
 async def query_documents(request: QueryRequest):
     """Query documents in the collection"""
@@ -158,11 +646,59 @@ async def query_documents(request: QueryRequest):
     result = service.query_documents(
         query=request.query,
         n_results=request.n_results,
-        include_metadata=request.include_metadata
+        include_metadata=request.include_metadata,
+        collection=request.collection,
+        where=request.where,
+        query_embedding=request.query_embedding,
+        access=request.access,
+        highlights=request.highlights
     )
     
     return QueryResponse(**result)
 
+@app.post("/documents/chunks")
+async def document_chunks(request: DocumentChunksRequest):
+    """Get the chunks of a document in order, e.g. for the Go service to
+    summarize it"""
+    global service
+    if not service or not service.client:
+        raise HTTPException(status_code=503, detail="Service not initialized")
+    
+    return await run_in_threadpool(service.document_chunks, request.source, request.collection,
+                                   request.tenant, request.access)
+
+@app.post("/documents")
+async def ingest_document(request: Request, filename: str, collection: Optional[str] = None,
+                          tenant: Optional[str] = None, metadata: Optional[str] = None):
+    """Ingest the document in the request body, e.g. a PDF uploaded through
+    the Go service. Its extension selects how text is extracted."""
+    global service
+    if not service or not service.client:
+        raise HTTPException(status_code=503, detail="Service not initialized")
+    if not filename or Path(filename).name != filename:
+        raise HTTPException(status_code=400, detail="filename must be a file name without a directory")
+    try:
+        extra = json.loads(metadata) if metadata else {}
+    except ValueError:
+        extra = None
+    if not isinstance(extra, dict) or not all(isinstance(v, str) for v in extra.values()):
+        raise HTTPException(status_code=400, detail="metadata must be a JSON object of strings")
+    try:
+        acl_metadata(extra.get(GROUPS_KEY), extra.get(ROLES_KEY))
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    
+    data = bytearray()
+    async for chunk in request.stream():
+        data.extend(chunk)
+        if len(data) > MAX_UPLOAD_BYTES:
+            raise HTTPException(status_code=413, detail=f"Document exceeds {MAX_UPLOAD_BYTES} bytes")
+    if not data:
+        raise HTTPException(status_code=400, detail="Document is empty")
+    
+    # Extraction and embedding are CPU bound, kept off the event loop
+    return await run_in_threadpool(service.ingest_document, bytes(data), filename, collection, tenant, extra)
+
 @app.get("/collections")
 async def list_collections():
     """List available collections"""
@@ -176,6 +712,29 @@ async def list_collections():
     except Exception as e:
         raise HTTPException(status_code=500, detail=f"Failed to list collections: {str(e)}")
 
+@app.get("/admin/jobs", dependencies=[Depends(require_admin)])
+async def list_jobs():
+    """List the scheduled jobs with their state, next and last run"""
+    return {"jobs": get_scheduler().status()}
+
+@app.get("/admin/jobs/{name}", dependencies=[Depends(require_admin)])
+async def job_history(name: str):
+    """Get a job's recent runs, latest first, with the end of their output"""
+    jobs = get_scheduler()
+    if name not in jobs.jobs:
+        raise HTTPException(status_code=404, detail=f"Job '{name}' not found")
+    return {"name": name, "runs": jobs.runs(name)}
+
+@app.post("/admin/jobs/{name}/run", status_code=202, dependencies=[Depends(require_admin)])
+async def run_job(name: str):
+    """Run a job now, after the one running if any"""
+    jobs = get_scheduler()
+    if name not in jobs.jobs:
+        raise HTTPException(status_code=404, detail=f"Job '{name}' not found")
+    if not jobs.trigger(name):
+        raise HTTPException(status_code=409, detail=f"Job '{name}' is already queued or running")
+    return {"name": name, "state": "queued"}
+
 def signal_handler(sig, frame):
     """Handle shutdown signals"""
     logger.info("Shutting down ChromaDB service...")
@@ -193,6 +752,18 @@ def main():
                        help="Port to bind to (default: 8000)")
     parser.add_argument("--reload", action="store_true",
                        help="Enable auto-reload for development")
+    parser.add_argument("--jobs-file",
+                       help="JSON file of sync and re-embedding jobs to run on schedule (see scheduler.py)")
+    parser.add_argument("--query-cache-ttl", type=float, default=DEFAULT_TTL,
+                       help=f"Seconds query results are cached, 0 disables the cache (default: {DEFAULT_TTL:g})")
+    parser.add_argument("--query-cache-size", type=int, default=DEFAULT_SIZE,
+                       help=f"Query results cached at most (default: {DEFAULT_SIZE})")
+    parser.add_argument("--embedding-model", default=DEFAULT_MODEL,
+                       help=f"Embedding model of the collections the service creates (default: {DEFAULT_MODEL})")
+    parser.add_argument("--tenants-file",
+                       help="The Go service's TENANTS_FILE, to also create the collections of its tenants")
+    parser.add_argument("--no-bootstrap", action="store_true",
+                       help="Don't create missing collections, queries to them fail until documents are embedded")
     
     args = parser.parse_args()
     
@@ -202,15 +773,32 @@ def main():
     
     # Initialize service
     global service
-    service = ChromaDBService(args.db_path, args.collection)
-    
     try:
+        tenant_collections = []
+        if args.tenants_file:
+            with open(args.tenants_file) as f:
+                tenant_collections = [tenant_collection(tenant, args.tenants_file) for tenant in json.load(f)]
+        service = ChromaDBService(args.db_path, args.collection, args.query_cache_ttl, args.query_cache_size,
+                                  embedding_model=args.embedding_model, bootstrap=not args.no_bootstrap,
+                                  tenant_collections=tenant_collections)
         service.initialize()
         logger.info("ChromaDB service initialized successfully")
     except Exception as e:
         logger.error(f"Failed to initialize service: {e}")
         sys.exit(1)
     
+    # Jobs run in the background while serving, their history is kept with
+    # the database
+    global scheduler
+    if args.jobs_file:
+        try:
+            scheduler = Scheduler(load_jobs(args.jobs_file), Path(args.db_path) / "scheduler_history.json",
+                                  on_finish=lambda run: service.refresh())
+        except Exception as e:
+            logger.error(f"Failed to load jobs: {e}")
+            sys.exit(1)
+        logger.info(f"Loaded {len(scheduler.jobs)} jobs from {args.jobs_file}")
+    
     # Start the server
     logger.info(f"Starting server on {args.host}:{args.port}")
     logger.info(f"API documentation available at: http://{args.host}:{args.port}/docs")
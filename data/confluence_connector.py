@@ -0,0 +1,97 @@
+#!/usr/bin/env python3
+"""
+Confluence Connector for ChromaDB
+Syncs the pages of Confluence spaces into ChromaDB as markdown, keeping their
+place in the page tree as metadata. Pages are re-embedded when their version
+changes and removed when they are deleted or archived.
+"""
+
+import argparse
+import os
+import sys
+from typing import Dict, Iterator, List
+
+import requests
+
+from connector_common import (Document, SyncState, add_embedder_args, create_embedder,
+                              html_to_markdown, request_json, run, sync_documents)
+
+
+class ConfluenceSpaces:
+    """The current pages of Confluence spaces, through the REST API.
+
+    Confluence Cloud authenticates with CONFLUENCE_USER and
+    CONFLUENCE_API_TOKEN, Data Center with a personal access token in
+    CONFLUENCE_TOKEN."""
+
+    def __init__(self, base_url: str, spaces: List[str]):
+        self.base_url = base_url.rstrip("/")
+        self.spaces = spaces
+        self.session = requests.Session()
+        if os.getenv("CONFLUENCE_TOKEN"):
+            self.session.headers["Authorization"] = f"Bearer {os.environ['CONFLUENCE_TOKEN']}"
+        elif os.getenv("CONFLUENCE_USER") and os.getenv("CONFLUENCE_API_TOKEN"):
+            self.session.auth = (os.environ["CONFLUENCE_USER"], os.environ["CONFLUENCE_API_TOKEN"])
+        else:
+            raise ValueError("Set CONFLUENCE_USER and CONFLUENCE_API_TOKEN, or CONFLUENCE_TOKEN")
+
+    def list(self) -> Iterator[Document]:
+        """List the pages with their version, without their body, so only
+        changed pages are downloaded"""
+        for space in self.spaces:
+            url = f"{self.base_url}/rest/api/content"
+            params = {"spaceKey": space, "type": "page", "status": "current", "limit": 50,
+                      "expand": "version,ancestors"}
+            while url:
+                page = request_json(self.session, "GET", url, params=params)
+                for content in page.get("results", []):
+                    source = f"{self.base_url}/pages/{content['id']}"
+                    yield source, str(content["version"]["number"]), self.metadata(space, content)
+                next_link = page.get("_links", {}).get("next")
+                # The next link carries the query, relative to the site
+                url = f"{self.base_url}{next_link[next_link.find('/rest/'):]}" if next_link else None
+                params = None
+
+    def metadata(self, space: str, content: Dict) -> Dict:
+        """Return the page's place in the space's page tree and its origin"""
+        ancestors = content.get("ancestors", [])
+        return {
+            "space": space,
+            "title": content["title"],
+            "page_id": content["id"],
+            "parent_id": ancestors[-1]["id"] if ancestors else "",
+            "path": " / ".join([a["title"] for a in ancestors] + [content["title"]]),
+            "depth": len(ancestors),
+            "modified_time": content["version"].get("when", ""),
+            "url": self.base_url + content.get("_links", {}).get("webui", ""),
+        }
+
+    def fetch_text(self, source: str) -> str:
+        """Return a page as markdown under its title"""
+        page_id = source.rsplit("/", 1)[1]
+        content = request_json(self.session, "GET", f"{self.base_url}/rest/api/content/{page_id}",
+                               params={"expand": "body.storage"})
+        return f"# {content['title']}\n\n{html_to_markdown(content['body']['storage']['value'])}"
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Sync Confluence spaces into ChromaDB")
+    parser.add_argument("base_url", help="Confluence URL, e.g. https://example.atlassian.net/wiki")
+    parser.add_argument("spaces", nargs="+", help="Keys of the spaces to sync")
+    add_embedder_args(parser)
+    args = parser.parse_args()
+
+    try:
+        confluence = ConfluenceSpaces(args.base_url, args.spaces)
+        embedder = create_embedder(parser, args)
+        state = SyncState.for_source(args, embedder, f"{confluence.base_url}:{','.join(sorted(args.spaces))}")
+
+        print(f"Syncing Confluence spaces {', '.join(args.spaces)} into collection '{embedder.collection_name}'")
+        run(lambda: sync_documents(embedder, state, confluence.list(), confluence.fetch_text), args.interval)
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
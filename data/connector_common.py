@@ -0,0 +1,466 @@
+#!/usr/bin/env python3
+"""
+Shared pieces of the ingestion connectors: the embedder options, change
+tracking between syncs, text extraction and the sync schedule
+"""
+
+import hashlib
+import html as htmllib
+import io
+import json
+import re
+import signal
+import time
+from html.parser import HTMLParser
+from pathlib import Path
+from typing import Callable, Dict, Iterable, Optional, Tuple
+
+from ocr import IMAGE_EXTENSIONS, OCR_ENGINES, OCR_MODES
+from acl import acl_metadata
+from pdf_embedder import (DEDUP_MODES, DEFAULT_COLLECTION, DEFAULT_DEDUP_THRESHOLD, DEFAULT_MODEL,
+                          PDFEmbedder, tenant_collection)
+
+# Extensions of the files connectors can extract text from
+TEXT_EXTENSIONS = {".txt", ".md", ".markdown", ".csv", ".json"}
+HTML_EXTENSIONS = {".html", ".htm"}
+SUPPORTED_EXTENSIONS = {".pdf"} | TEXT_EXTENSIONS | HTML_EXTENSIONS
+
+
+def add_embedder_args(parser):
+    """Add the options selecting where documents are embedded"""
+    parser.add_argument("--db-path", "-d", default="./chroma_db",
+                       help="ChromaDB storage path (default: ./chroma_db)")
+    parser.add_argument("--tenant", "-t",
+                       help="Ingest for this tenant, into its collection and tagged with its id")
+    parser.add_argument("--tenants-file",
+                       help="The service's TENANTS_FILE, to look up the tenant's collection")
+    parser.add_argument("--collection", "-c",
+                       help=f"Collection name without a tenant (default: {DEFAULT_COLLECTION})")
+    parser.add_argument("--dedup", choices=DEDUP_MODES, default="skip",
+                       help="Chunks duplicating stored ones: store anyway (off), drop (skip), or drop and "
+                            "record their source on the stored chunk (merge) (default: skip)")
+    parser.add_argument("--ocr", choices=OCR_MODES, default="auto",
+                       help="OCR pages without a text layer and images (auto), every PDF page (force), "
+                            "or nothing (off) (default: auto)")
+    parser.add_argument("--ocr-engine", choices=OCR_ENGINES, default="tesseract",
+                       help="Local tesseract, or the Google Cloud Vision API (default: tesseract)")
+    parser.add_argument("--ocr-lang", default="",
+                       help="OCR language, e.g. eng+deu for tesseract or de for Vision (default: English or auto-detected)")
+    parser.add_argument("--dedup-threshold", type=float, default=DEFAULT_DEDUP_THRESHOLD,
+                       help=f"Cosine similarity of near-duplicate chunks (default: {DEFAULT_DEDUP_THRESHOLD})")
+    parser.add_argument("--model", default=DEFAULT_MODEL,
+                       help=f"Sentence-transformers embedding model of the collection (default: {DEFAULT_MODEL})")
+    parser.add_argument("--acl-groups", default="",
+                       help="Comma-separated groups allowed to retrieve the documents (default: public)")
+    parser.add_argument("--acl-roles", default="",
+                       help="Comma-separated roles allowed to retrieve the documents (default: public)")
+    parser.add_argument("--state-file",
+                       help="Where the sync state is kept (default: under --db-path)")
+    parser.add_argument("--interval", type=int, default=0,
+                       help="Sync every N seconds instead of once")
+
+
+def create_embedder(parser, args) -> PDFEmbedder:
+    """Create and initialize the embedder selected by the options"""
+    # A tenant's documents always go to its own collection
+    if args.tenant:
+        if args.collection:
+            parser.error("--collection cannot be combined with --tenant")
+        collection_name = tenant_collection(args.tenant, args.tenants_file)
+    else:
+        collection_name = args.collection or DEFAULT_COLLECTION
+    try:
+        acl_metadata(args.acl_groups, args.acl_roles)
+    except ValueError as e:
+        parser.error(str(e))
+
+    embedder = PDFEmbedder(db_path=args.db_path, collection_name=collection_name, tenant=args.tenant,
+                           dedup=args.dedup, dedup_threshold=args.dedup_threshold,
+                           ocr_mode=args.ocr, ocr_engine=args.ocr_engine, ocr_lang=args.ocr_lang,
+                           model_name=args.model, acl_groups=args.acl_groups, acl_roles=args.acl_roles)
+    embedder.initialize_chromadb()
+    embedder.initialize_model()
+    embedder.initialize_ocr()
+    return embedder
+
+
+def source_id(source: str) -> str:
+    """Return a short stable id for a document, used as its chunk id prefix"""
+    return hashlib.sha1(source.encode()).hexdigest()[:16]
+
+
+class SyncState:
+    """The version of every document embedded by a connector, so a sync only
+    re-embeds new and changed documents and removes deleted ones, and the
+    outcome of its last sync"""
+
+    def __init__(self, path: Path, source: Optional[str] = None):
+        self.path = path
+        self.source = source
+        self.documents: Dict[str, Dict] = {}
+        # cursor resumes incremental syncs, e.g. a change feed token; extra
+        # holds whatever else a connector needs between syncs
+        self.cursor: Optional[str] = None
+        self.extra: Dict = {}
+        # last_sync is the outcome of the latest sync and last_success_at the
+        # time one last completed, reported by the service as the freshness
+        # of the collection
+        self.last_sync: Optional[Dict] = None
+        self.last_success_at: Optional[int] = None
+        if path.exists():
+            with open(path) as f:
+                state = json.load(f)
+            self.source = self.source or state.get("source")
+            self.documents = state.get("documents", {})
+            self.cursor = state.get("cursor")
+            self.extra = state.get("extra", {})
+            self.last_sync = state.get("last_sync")
+            self.last_success_at = state.get("last_success_at")
+
+    @classmethod
+    def for_source(cls, args, embedder: PDFEmbedder, source: str) -> "SyncState":
+        """Return the state of a source synced into the embedder's collection"""
+        if args.state_file:
+            return cls(Path(args.state_file), source)
+        name = f"{embedder.collection_name}_{source_id(source)}.json"
+        return cls(Path(args.db_path) / "sync_state" / name, source)
+
+    def record_sync(self, started_at: int, stats: Dict[str, int], error: Optional[str] = None):
+        """Record the outcome of a sync: failed when it stopped with an error,
+        partial when some documents failed, succeeded otherwise"""
+        finished_at = int(time.time())
+        status = "failed" if error else "partial" if stats["failed"] else "succeeded"
+        self.last_sync = {"status": status, "started_at": started_at, "finished_at": finished_at, "stats": stats}
+        if error:
+            self.last_sync["error"] = error
+        else:
+            self.last_success_at = finished_at
+        self.save()
+
+    def save(self):
+        self.path.parent.mkdir(parents=True, exist_ok=True)
+        tmp = self.path.with_suffix(".tmp")
+        with open(tmp, "w") as f:
+            json.dump({"source": self.source, "documents": self.documents, "cursor": self.cursor,
+                       "extra": self.extra, "last_sync": self.last_sync,
+                       "last_success_at": self.last_success_at}, f, indent=2)
+        tmp.replace(self.path)
+
+
+class _TextExtractor(HTMLParser):
+    def __init__(self):
+        super().__init__()
+        self.parts = []
+        self.skip = 0
+
+    def handle_starttag(self, tag, attrs):
+        if tag in ("script", "style"):
+            self.skip += 1
+
+    def handle_endtag(self, tag):
+        if tag in ("script", "style") and self.skip:
+            self.skip -= 1
+
+    def handle_data(self, data):
+        if not self.skip and data.strip():
+            self.parts.append(data.strip())
+
+
+def html_to_text(html: str) -> str:
+    """Return the visible text of an HTML document"""
+    parser = _TextExtractor()
+    parser.feed(html)
+    return "\n".join(parser.parts)
+
+
+class _MarkdownConverter(HTMLParser):
+    """Converts the common HTML elements to markdown, keeping the text of
+    unknown ones"""
+
+    BLOCKS = {"p", "div", "table", "blockquote"}
+
+    def __init__(self):
+        super().__init__()
+        self.out = []
+        self.lists = []
+        self.href = None
+        self.skip = 0
+        self.pre = 0
+
+    def handle_starttag(self, tag, attrs):
+        if tag in ("script", "style"):
+            self.skip += 1
+        elif tag in ("h1", "h2", "h3", "h4", "h5", "h6"):
+            self.out.append("\n\n" + "#" * int(tag[1]) + " ")
+        elif tag in ("ul", "ol"):
+            self.lists.append([tag, 0])
+        elif tag == "li":
+            indent = "  " * max(len(self.lists) - 1, 0)
+            if self.lists and self.lists[-1][0] == "ol":
+                self.lists[-1][1] += 1
+                self.out.append(f"\n{indent}{self.lists[-1][1]}. ")
+            else:
+                self.out.append(f"\n{indent}- ")
+        elif tag in ("strong", "b"):
+            self.out.append("**")
+        elif tag in ("em", "i"):
+            self.out.append("_")
+        elif tag == "code" and not self.pre:
+            self.out.append("`")
+        elif tag == "pre":
+            self.pre += 1
+            self.out.append("\n\n```\n")
+        elif tag == "a":
+            self.href = dict(attrs).get("href")
+            self.out.append("[")
+        elif tag in ("td", "th"):
+            self.out.append("| ")
+        elif tag in self.BLOCKS:
+            self.out.append("\n\n")
+        elif tag in ("br", "tr"):
+            self.out.append("\n")
+
+    def handle_endtag(self, tag):
+        if tag in ("script", "style"):
+            self.skip = max(self.skip - 1, 0)
+        elif tag in ("ul", "ol") and self.lists:
+            self.lists.pop()
+            self.out.append("\n")
+        elif tag in ("strong", "b"):
+            self.out.append("**")
+        elif tag in ("em", "i"):
+            self.out.append("_")
+        elif tag == "code" and not self.pre:
+            self.out.append("`")
+        elif tag == "pre":
+            self.pre = max(self.pre - 1, 0)
+            self.out.append("\n```\n")
+        elif tag == "a":
+            self.out.append(f"]({self.href})" if self.href else "]")
+            self.href = None
+        elif tag in ("td", "th"):
+            self.out.append(" ")
+        elif tag == "tr":
+            self.out.append("|")
+
+    def handle_data(self, data):
+        if self.skip:
+            return
+        self.out.append(data if self.pre else data.replace("\n", " "))
+
+    def unknown_decl(self, data):
+        # Confluence code macros keep their code in CDATA sections
+        if data.startswith("CDATA["):
+            self.out.append(f"\n```\n{data[len('CDATA['):]}\n```\n")
+
+
+# Page elements around the main content of web pages
+BOILERPLATE_TAGS = {"nav", "header", "footer", "aside", "form", "noscript", "script", "style", "svg", "template"}
+MAIN_CONTENT_PATTERN = re.compile(r"<(main|article)[\s>]|role=[\"']main[\"']", re.IGNORECASE)
+
+
+class _ContentFilter(HTMLParser):
+    """Re-emits the main content of a web page: the main or article element
+    when there is one, without navigation and other boilerplate"""
+
+    def __init__(self, scoped: bool):
+        super().__init__()
+        self.scoped = scoped
+        self.out = []
+        self.title = []
+        self.in_title = False
+        self.main = 0
+        self.skip = 0
+
+    def emitting(self) -> bool:
+        return not self.skip and (not self.scoped or self.main > 0)
+
+    def handle_starttag(self, tag, attrs):
+        if tag == "title":
+            self.in_title = True
+        if tag in BOILERPLATE_TAGS:
+            self.skip += 1
+        if tag in ("main", "article") or dict(attrs).get("role") == "main":
+            self.main += 1
+        if self.emitting():
+            self.out.append(self.get_starttag_text())
+
+    def handle_endtag(self, tag):
+        if tag == "title":
+            self.in_title = False
+        if self.emitting():
+            self.out.append(f"</{tag}>")
+        if tag in BOILERPLATE_TAGS:
+            self.skip = max(self.skip - 1, 0)
+        if tag in ("main", "article"):
+            self.main = max(self.main - 1, 0)
+
+    def handle_data(self, data):
+        if self.in_title:
+            self.title.append(data)
+        elif self.emitting():
+            self.out.append(htmllib.escape(data))
+
+
+def extract_main_content(html: str) -> Tuple[str, str]:
+    """Return the title of a web page and its main content as markdown"""
+    parser = _ContentFilter(bool(MAIN_CONTENT_PATTERN.search(html)))
+    parser.feed(html)
+    title = " ".join("".join(parser.title).split())
+    return title, html_to_markdown("".join(parser.out))
+
+
+def html_to_markdown(html: str) -> str:
+    """Convert an HTML document, e.g. a Confluence page, to markdown"""
+    parser = _MarkdownConverter()
+    parser.feed(html)
+    text = "".join(parser.out)
+    # Collapse the blank lines left by nested blocks
+    lines = [line.rstrip() for line in text.split("\n")]
+    markdown, blank = [], False
+    for line in lines:
+        if not line.strip():
+            blank = bool(markdown)
+            continue
+        if blank:
+            markdown.append("")
+        markdown.append(line)
+        blank = False
+    return "\n".join(markdown)
+
+
+def is_supported(embedder: PDFEmbedder, name: str) -> bool:
+    """Report whether text can be extracted from a file by its extension.
+    Images are only supported with OCR."""
+    suffix = Path(name).suffix.lower()
+    return suffix in SUPPORTED_EXTENSIONS or (embedder.ocr is not None and suffix in IMAGE_EXTENSIONS)
+
+
+def extract_text(embedder: PDFEmbedder, name: str, data: bytes) -> str:
+    """Extract the text of a downloaded file by its extension"""
+    suffix = Path(name).suffix.lower()
+    if suffix == ".pdf":
+        return embedder.extract_text_from_pdf(io.BytesIO(data))
+    if suffix in IMAGE_EXTENSIONS:
+        return embedder.extract_text_from_image(data, name)
+    text = data.decode("utf-8", errors="replace")
+    if suffix in HTML_EXTENSIONS:
+        return html_to_text(text)
+    return text.strip()
+
+
+# A document listed by a connector: its source URI, its version (generation,
+# etag or modified time) and the metadata stored with its chunks
+Document = Tuple[str, str, Dict]
+
+
+def sync_documents(embedder: PDFEmbedder, state: SyncState, documents: Iterable[Document],
+                   fetch_text: Callable[[str], str], complete: bool = True) -> Dict[str, int]:
+    """Embed new and changed documents and, when the listing is complete,
+    remove the chunks of documents no longer listed. The outcome is recorded
+    in the state, also when listing or embedding fails."""
+    started_at = int(time.time())
+    stats = {"added": 0, "updated": 0, "unchanged": 0, "deleted": 0, "failed": 0}
+    try:
+        apply_changes(embedder, state, documents, fetch_text, complete, stats)
+    except Exception as e:
+        state.record_sync(started_at, stats, str(e))
+        raise
+    state.record_sync(started_at, stats)
+    return stats
+
+
+def apply_changes(embedder: PDFEmbedder, state: SyncState, documents: Iterable[Document],
+                  fetch_text: Callable[[str], str], complete: bool, stats: Dict[str, int]):
+    seen = set()
+
+    for source, version, metadata in documents:
+        seen.add(source)
+        known = state.documents.get(source)
+        if known and known["version"] == version:
+            stats["unchanged"] += 1
+            continue
+
+        print(f"Processing: {source}")
+        try:
+            text = fetch_text(source)
+            if known:
+                embedder.delete_source(source)
+            if not text:
+                print(f"Warning: No text extracted from {source}")
+                stats["failed"] += 1
+                state.documents.pop(source, None)
+                continue
+            if not embedder.embed_text(text, source_id(source), source, {**metadata, "version": version}):
+                stats["failed"] += 1
+                continue
+        except Exception as e:
+            print(f"Error processing {source}: {e}")
+            stats["failed"] += 1
+            continue
+
+        stats["updated" if known else "added"] += 1
+        state.documents[source] = {"version": version}
+        # Save as we go, so an interrupted sync doesn't start over
+        state.save()
+
+    if complete:
+        for source in list(state.documents):
+            if source not in seen:
+                delete_document(embedder, state, source)
+                stats["deleted"] += 1
+
+
+def delete_document(embedder: PDFEmbedder, state: SyncState, source: str):
+    """Remove a document that was deleted at its source"""
+    print(f"Removing: {source}")
+    embedder.delete_source(source)
+    state.documents.pop(source, None)
+
+
+def print_stats(stats: Dict[str, int]):
+    print("\n" + "="*50)
+    print("SYNC COMPLETE")
+    print("="*50)
+    for name, count in stats.items():
+        print(f"{name.capitalize()}: {count}")
+
+
+def request_json(session, method: str, url: str, attempts: int = 5, **kwargs) -> Dict:
+    """Send an API request with a requests session, retrying rate-limited
+    and failed requests after the Retry-After delay or with backoff"""
+    for attempt in range(attempts):
+        response = session.request(method, url, timeout=30, **kwargs)
+        if response.status_code != 429 and response.status_code < 500:
+            response.raise_for_status()
+            return response.json()
+        if attempt == attempts - 1:
+            response.raise_for_status()
+        delay = response.headers.get("Retry-After")
+        time.sleep(float(delay) if delay and delay.isdigit() else 2 ** attempt)
+    return {}
+
+
+def run(sync: Callable[[], Dict[str, int]], interval: int):
+    """Sync once, or every interval seconds until interrupted. A failed
+    scheduled sync is retried at the next interval."""
+    if interval <= 0:
+        print_stats(sync())
+        return
+
+    stopping = False
+
+    def stop(signum, frame):
+        nonlocal stopping
+        stopping = True
+
+    signal.signal(signal.SIGTERM, stop)
+    signal.signal(signal.SIGINT, stop)
+    while not stopping:
+        try:
+            print_stats(sync())
+        except Exception as e:
+            print(f"Sync failed: {e}")
+        deadline = time.monotonic() + interval
+        while not stopping and time.monotonic() < deadline:
+            time.sleep(1)
@@ -0,0 +1,260 @@
+#!/usr/bin/env python3
+"""
+Google Drive Connector for ChromaDB
+Syncs the Docs, Slides, PDFs and text files of shared Drive folders into
+ChromaDB. The first sync lists the folders; later syncs only fetch what the
+Drive changes API reports since the previous one.
+"""
+
+import argparse
+import sys
+from datetime import datetime
+from typing import Dict, Iterator, List, Set
+
+from connector_common import (Document, SyncState, add_embedder_args, create_embedder, delete_document,
+                              extract_text, is_supported, run, sync_documents)
+
+SCOPES = ["https://www.googleapis.com/auth/drive.readonly"]
+
+FOLDER_MIME_TYPE = "application/vnd.google-apps.folder"
+# Google documents are exported as plain text; other files are downloaded
+EXPORT_MIME_TYPES = {
+    "application/vnd.google-apps.document": "text/plain",
+    "application/vnd.google-apps.presentation": "text/plain",
+}
+
+FILE_FIELDS = "id, name, mimeType, version, modifiedTime, owners(emailAddress), webViewLink, parents, trashed"
+
+
+def credentials(args):
+    """Return credentials from a service account key, an OAuth client with a
+    cached token, or else the application default credentials"""
+    if args.service_account:
+        from google.oauth2 import service_account
+        return service_account.Credentials.from_service_account_file(args.service_account, scopes=SCOPES)
+
+    if args.oauth_client:
+        from google.auth.transport.requests import Request
+        from google.oauth2.credentials import Credentials
+        from google_auth_oauthlib.flow import InstalledAppFlow
+        creds = None
+        try:
+            creds = Credentials.from_authorized_user_file(args.token_file, SCOPES)
+        except (FileNotFoundError, ValueError):
+            pass
+        if creds and creds.expired and creds.refresh_token:
+            creds.refresh(Request())
+        elif not creds or not creds.valid:
+            # Interactive consent on first use, the token is cached after
+            creds = InstalledAppFlow.from_client_secrets_file(args.oauth_client, SCOPES).run_local_server(port=0)
+        with open(args.token_file, "w") as f:
+            f.write(creds.to_json())
+        return creds
+
+    import google.auth
+    creds, _ = google.auth.default(scopes=SCOPES)
+    return creds
+
+
+class DriveFolders:
+    """The files of Drive folders and their subfolders, shared drives
+    included"""
+
+    def __init__(self, creds, folder_ids: List[str]):
+        from googleapiclient.discovery import build
+        service = build("drive", "v3", credentials=creds, cache_discovery=False)
+        self.files = service.files()
+        self.changes = service.changes()
+        self.folder_ids = folder_ids
+
+    def _pages(self, request_fn, **kwargs) -> Iterator[Dict]:
+        page_token = None
+        while True:
+            page = request_fn(pageToken=page_token, supportsAllDrives=True, **kwargs).execute()
+            yield page
+            page_token = page.get("nextPageToken")
+            if not page_token:
+                return
+
+    def list_tree(self, folders: Set[str]) -> Iterator[Dict]:
+        """Yield the files under the folders, adding subfolders to folders"""
+        pending = list(self.folder_ids)
+        folders.update(pending)
+        while pending:
+            folder = pending.pop()
+            for page in self._pages(self.files.list, includeItemsFromAllDrives=True,
+                                    q=f"'{folder}' in parents and trashed = false",
+                                    fields=f"nextPageToken, files({FILE_FIELDS})"):
+                for file in page.get("files", []):
+                    if file["mimeType"] == FOLDER_MIME_TYPE:
+                        if file["id"] not in folders:
+                            folders.add(file["id"])
+                            pending.append(file["id"])
+                    else:
+                        yield file
+
+    def start_token(self) -> str:
+        return self.changes.getStartPageToken(supportsAllDrives=True).execute()["startPageToken"]
+
+    def list_changes(self, token: str):
+        """Return the changes since token and the token to resume from"""
+        changes = []
+        page_token = token
+        while page_token:
+            page = self.changes.list(pageToken=page_token, supportsAllDrives=True,
+                                     includeItemsFromAllDrives=True, includeRemoved=True,
+                                     fields=f"nextPageToken, newStartPageToken, changes(fileId, removed, file({FILE_FIELDS}))").execute()
+            changes.extend(page.get("changes", []))
+            if "newStartPageToken" in page:
+                return changes, page["newStartPageToken"]
+            page_token = page.get("nextPageToken")
+        return changes, token
+
+    def fetch(self, file_id: str, mime_type: str) -> bytes:
+        export = EXPORT_MIME_TYPES.get(mime_type)
+        if export:
+            return self.files.export(fileId=file_id, mimeType=export).execute()
+        return self.files.get_media(fileId=file_id, supportsAllDrives=True).execute()
+
+
+def source_of(file_id: str) -> str:
+    return f"gdrive://{file_id}"
+
+
+def is_supported_file(embedder, file: Dict) -> bool:
+    if file["mimeType"] in EXPORT_MIME_TYPES or file["mimeType"] == "application/pdf":
+        return True
+    return is_supported(embedder, file["name"])
+
+
+def file_metadata(file: Dict) -> Dict:
+    """Return the metadata stored with a file's chunks, usable as retrieval
+    filters, e.g. {"owner": "alice@example.com"}"""
+    modified = datetime.fromisoformat(file["modifiedTime"].replace("Z", "+00:00"))
+    owners = file.get("owners") or [{}]
+    return {
+        "filename": file["name"],
+        "mime_type": file["mimeType"],
+        "owner": owners[0].get("emailAddress", ""),
+        "modified_time": file["modifiedTime"],
+        "modified_ts": int(modified.timestamp()),
+        "url": file.get("webViewLink", ""),
+    }
+
+
+def document_of(file: Dict) -> Document:
+    return source_of(file["id"]), str(file["version"]), file_metadata(file)
+
+
+class DriveSync:
+    """Full and incremental syncs of Drive folders into a collection"""
+
+    def __init__(self, drive: DriveFolders, embedder, state: SyncState):
+        self.drive = drive
+        self.embedder = embedder
+        self.state = state
+        # The files being synced by id, to know how to fetch them
+        self.files: Dict[str, Dict] = {}
+
+    def fetch_text(self, source: str) -> str:
+        file = self.files[source[len("gdrive://"):]]
+        data = self.drive.fetch(file["id"], file["mimeType"])
+        # Exports are plain text, downloads are extracted by file name
+        if file["mimeType"] in EXPORT_MIME_TYPES:
+            name = "export.txt"
+        elif file["mimeType"] == "application/pdf":
+            name = "file.pdf"
+        else:
+            name = file["name"]
+        return extract_text(self.embedder, name, data)
+
+    def documents(self, files) -> Iterator[Document]:
+        for file in files:
+            if is_supported_file(self.embedder, file):
+                self.files[file["id"]] = file
+                yield document_of(file)
+
+    def sync(self) -> Dict[str, int]:
+        self.files = {}
+        if self.state.cursor is None:
+            return self.full_sync()
+        return self.incremental_sync()
+
+    def full_sync(self) -> Dict[str, int]:
+        print("Listing Drive folders for a full sync")
+        # Taken before listing, so changes made during the listing are not
+        # missed by the next sync
+        token = self.drive.start_token()
+        folders: Set[str] = set()
+        stats = sync_documents(self.embedder, self.state, self.documents(self.drive.list_tree(folders)),
+                               self.fetch_text)
+        self.state.extra["folders"] = sorted(folders)
+        self.state.cursor = token
+        self.state.save()
+        return stats
+
+    def incremental_sync(self) -> Dict[str, int]:
+        changes, token = self.drive.list_changes(self.state.cursor)
+        folders = set(self.state.extra.get("folders", self.drive.folder_ids))
+
+        # Track new subfolders first, the files in them may come earlier in
+        # the changes
+        for change in changes:
+            file = change.get("file")
+            if file and file["mimeType"] == FOLDER_MIME_TYPE and not file.get("trashed") \
+                    and folders.intersection(file.get("parents", [])):
+                folders.add(file["id"])
+
+        changed, removed = [], []
+        for change in changes:
+            file = change.get("file")
+            if file and file["mimeType"] == FOLDER_MIME_TYPE:
+                continue
+            if change.get("removed") or not file or file.get("trashed") \
+                    or not folders.intersection(file.get("parents", [])):
+                # Deleted, trashed or moved out of the synced folders
+                removed.append(source_of(change["fileId"]))
+            else:
+                changed.append(file)
+
+        stats = sync_documents(self.embedder, self.state, self.documents(changed), self.fetch_text, complete=False)
+        for source in removed:
+            if source in self.state.documents:
+                delete_document(self.embedder, self.state, source)
+                stats["deleted"] += 1
+
+        self.state.extra["folders"] = sorted(folders)
+        self.state.cursor = token
+        self.state.save()
+        return stats
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Sync Google Drive folders into ChromaDB")
+    parser.add_argument("folders", nargs="+", help="Ids of the Drive folders to sync")
+    parser.add_argument("--service-account", help="Service account key file, the folders must be shared with it")
+    parser.add_argument("--oauth-client", help="OAuth client secrets file, to sync as a user")
+    parser.add_argument("--token-file", default="drive_token.json",
+                       help="Where the user's OAuth token is cached (default: drive_token.json)")
+    parser.add_argument("--full", action="store_true", help="List the folders again instead of reading changes")
+    add_embedder_args(parser)
+    args = parser.parse_args()
+    if args.service_account and args.oauth_client:
+        parser.error("--service-account cannot be combined with --oauth-client")
+
+    try:
+        drive = DriveFolders(credentials(args), args.folders)
+        embedder = create_embedder(parser, args)
+        state = SyncState.for_source(args, embedder, "gdrive:" + ",".join(sorted(args.folders)))
+        if args.full:
+            state.cursor = None
+
+        print(f"Syncing Drive folders {', '.join(args.folders)} into collection '{embedder.collection_name}'")
+        run(DriveSync(drive, embedder, state).sync, args.interval)
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
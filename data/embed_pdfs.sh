@@ -29,6 +29,9 @@ source .venv/bin/activate
 SOURCE_DIR="source"
 DB_PATH="./chroma_db"
 RESET_FLAG=""
+TENANT_ARGS=()
+DEDUP_ARGS=()
+OCR_ARGS=()
 
 # Parse command line arguments
 while [[ $# -gt 0 ]]; do
@@ -45,12 +48,37 @@ while [[ $# -gt 0 ]]; do
             RESET_FLAG="--reset"
             shift
             ;;
+        --tenant|-t)
+            TENANT_ARGS+=(--tenant "$2")
+            shift 2
+            ;;
+        --tenants-file)
+            TENANT_ARGS+=(--tenants-file "$2")
+            shift 2
+            ;;
+        --dedup)
+            DEDUP_ARGS+=(--dedup "$2")
+            shift 2
+            ;;
+        --ocr)
+            OCR_ARGS+=(--ocr "$2")
+            shift 2
+            ;;
+        --ocr-engine)
+            OCR_ARGS+=(--ocr-engine "$2")
+            shift 2
+            ;;
         --help|-h)
             echo "Usage: $0 [OPTIONS]"
             echo "Options:"
             echo "  --source, -s DIR      Source directory containing PDF files (default: source)"
             echo "  --db-path, -d PATH    ChromaDB storage path (default: ./chroma_db)"
             echo "  --reset, -r           Reset the database before processing"
+            echo "  --tenant, -t ID       Ingest into the tenant's collection, tagged with its id"
+            echo "  --tenants-file FILE   The service's TENANTS_FILE, to look up the tenant's collection"
+            echo "  --dedup MODE          Duplicate chunks: off, skip or merge (default: skip)"
+            echo "  --ocr MODE            OCR of scanned pages and images: off, auto or force (default: auto)"
+            echo "  --ocr-engine ENGINE   tesseract or vision (default: tesseract)"
             echo "  --help, -h            Show this help message"
             exit 0
             ;;
@@ -99,6 +127,9 @@ echo ""
 python pdf_embedder.py \
     --source "$SOURCE_DIR" \
     --db-path "$DB_PATH" \
+    "${TENANT_ARGS[@]}" \
+    "${DEDUP_ARGS[@]}" \
+    "${OCR_ARGS[@]}" \
     $RESET_FLAG
 
 echo ""
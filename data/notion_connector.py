@@ -0,0 +1,188 @@
+#!/usr/bin/env python3
+"""
+Notion Connector for ChromaDB
+Syncs the pages of Notion databases, and of pages with their subpages, into
+ChromaDB as markdown, keeping their place in the hierarchy as metadata. Pages
+are re-embedded when edited and removed when deleted or archived.
+"""
+
+import argparse
+import os
+import sys
+from typing import Dict, Iterator, List, Optional
+
+import requests
+
+from connector_common import (Document, SyncState, add_embedder_args, create_embedder,
+                              request_json, run, sync_documents)
+
+NOTION_API = "https://api.notion.com/v1"
+NOTION_VERSION = "2022-06-28"
+
+# Markdown prefixes of the text blocks
+BLOCK_PREFIXES = {
+    "paragraph": "",
+    "heading_1": "# ",
+    "heading_2": "## ",
+    "heading_3": "### ",
+    "bulleted_list_item": "- ",
+    "numbered_list_item": "1. ",
+    "quote": "> ",
+    "callout": "> ",
+    "toggle": "- ",
+}
+
+
+def rich_text_to_markdown(rich_text: List[Dict]) -> str:
+    parts = []
+    for item in rich_text:
+        text = item.get("plain_text", "")
+        annotations = item.get("annotations", {})
+        if annotations.get("code"):
+            text = f"`{text}`"
+        if annotations.get("bold"):
+            text = f"**{text}**"
+        if annotations.get("italic"):
+            text = f"_{text}_"
+        if item.get("href"):
+            text = f"[{text}]({item['href']})"
+        parts.append(text)
+    return "".join(parts)
+
+
+def page_title(page: Dict) -> str:
+    """Return the title of a page, held by its title property"""
+    for prop in page.get("properties", {}).values():
+        if prop.get("type") == "title":
+            return "".join(t.get("plain_text", "") for t in prop["title"])
+    return "Untitled"
+
+
+class NotionWorkspace:
+    """Notion pages shared with the integration whose token is in
+    NOTION_TOKEN"""
+
+    def __init__(self, databases: List[str], pages: List[str]):
+        token = os.getenv("NOTION_TOKEN")
+        if not token:
+            raise ValueError("Set NOTION_TOKEN to the token of an integration the pages are shared with")
+        self.databases = databases
+        self.pages = pages
+        self.session = requests.Session()
+        self.session.headers.update({"Authorization": f"Bearer {token}", "Notion-Version": NOTION_VERSION})
+
+    def api(self, method: str, path: str, **kwargs) -> Dict:
+        return request_json(self.session, method, f"{NOTION_API}{path}", **kwargs)
+
+    def paginate(self, method: str, path: str, body: Optional[Dict] = None) -> Iterator[Dict]:
+        cursor = None
+        while True:
+            if method == "POST":
+                page = self.api("POST", path, json={**(body or {}), **({"start_cursor": cursor} if cursor else {})})
+            else:
+                page = self.api("GET", path, params={"page_size": 100, **({"start_cursor": cursor} if cursor else {})})
+            yield from page.get("results", [])
+            if not page.get("has_more"):
+                return
+            cursor = page["next_cursor"]
+
+    def list(self) -> Iterator[Document]:
+        """List the pages with their last edit time; subpages are found in
+        their parent's blocks"""
+        for database_id in self.databases:
+            database = self.api("GET", f"/databases/{database_id}")
+            database_title = "".join(t.get("plain_text", "") for t in database.get("title", []))
+            for page in self.paginate("POST", f"/databases/{database_id}/query"):
+                if not page.get("archived"):
+                    yield from self.page_tree(page, [database_title], database_id)
+        for page_id in self.pages:
+            page = self.api("GET", f"/pages/{page_id}")
+            if not page.get("archived"):
+                yield from self.page_tree(page, [], "")
+
+    def page_tree(self, page: Dict, ancestors: List[str], database_id: str) -> Iterator[Document]:
+        """Yield a page and its subpages"""
+        title = page_title(page)
+        parent = page.get("parent", {})
+        yield (f"notion://{page['id']}", page["last_edited_time"], {
+            "title": title,
+            "page_id": page["id"],
+            "parent_id": parent.get("page_id") or parent.get("database_id") or "",
+            "database_id": database_id,
+            "path": " / ".join(ancestors + [title]),
+            "depth": len(ancestors),
+            "modified_time": page["last_edited_time"],
+            "url": page.get("url", ""),
+        })
+        for child_id in self.child_pages(page["id"]):
+            child = self.api("GET", f"/pages/{child_id}")
+            if not child.get("archived"):
+                yield from self.page_tree(child, ancestors + [title], database_id)
+
+    def child_pages(self, block_id: str) -> Iterator[str]:
+        """Yield the ids of the subpages of a page, nested blocks included"""
+        for block in self.paginate("GET", f"/blocks/{block_id}/children"):
+            if block["type"] == "child_page":
+                yield block["id"]
+            elif block.get("has_children"):
+                yield from self.child_pages(block["id"])
+
+    def blocks_to_markdown(self, block_id: str, depth: int = 0) -> List[str]:
+        lines = []
+        indent = "  " * depth
+        for block in self.paginate("GET", f"/blocks/{block_id}/children"):
+            kind = block["type"]
+            content = block.get(kind, {})
+            if kind in BLOCK_PREFIXES:
+                lines.append(indent + BLOCK_PREFIXES[kind] + rich_text_to_markdown(content.get("rich_text", [])))
+            elif kind == "to_do":
+                check = "x" if content.get("checked") else " "
+                lines.append(f"{indent}- [{check}] {rich_text_to_markdown(content.get('rich_text', []))}")
+            elif kind == "code":
+                code = "".join(t.get("plain_text", "") for t in content.get("rich_text", []))
+                lines.append(f"{indent}```{content.get('language', '')}\n{code}\n{indent}```")
+            elif kind == "table_row":
+                cells = [rich_text_to_markdown(cell) for cell in content.get("cells", [])]
+                lines.append(f"{indent}| " + " | ".join(cells) + " |")
+            elif kind == "divider":
+                lines.append(f"{indent}---")
+            elif kind == "child_page":
+                # Subpages are documents of their own
+                continue
+            if block.get("has_children") and kind != "child_page":
+                lines.extend(self.blocks_to_markdown(block["id"], depth + 1 if kind != "table" else depth))
+        return lines
+
+    def fetch_text(self, source: str) -> str:
+        """Return a page as markdown under its title"""
+        page_id = source[len("notion://"):]
+        page = self.api("GET", f"/pages/{page_id}")
+        body = "\n".join(self.blocks_to_markdown(page_id))
+        return f"# {page_title(page)}\n\n{body}"
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Sync Notion databases and pages into ChromaDB")
+    parser.add_argument("--database", action="append", default=[], dest="databases",
+                       help="Id of a database whose pages are synced (repeatable)")
+    parser.add_argument("--page", action="append", default=[], dest="pages",
+                       help="Id of a page synced with its subpages (repeatable)")
+    add_embedder_args(parser)
+    args = parser.parse_args()
+    if not args.databases and not args.pages:
+        parser.error("at least one --database or --page is required")
+
+    try:
+        notion = NotionWorkspace(args.databases, args.pages)
+        embedder = create_embedder(parser, args)
+        state = SyncState.for_source(args, embedder, "notion:" + ",".join(sorted(args.databases + args.pages)))
+
+        print(f"Syncing Notion into collection '{embedder.collection_name}'")
+        run(lambda: sync_documents(embedder, state, notion.list(), notion.fetch_text), args.interval)
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
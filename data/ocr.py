@@ -0,0 +1,102 @@
+#!/usr/bin/env python3
+"""
+OCR for scanned PDF pages and images, with tesseract or the Google Cloud
+Vision API. Each recognized page comes with a confidence between 0 and 1.
+"""
+
+import io
+from typing import List, Optional, Tuple
+
+# OCR modes: never, for pages without a text layer, or for every page
+OCR_MODES = ("off", "auto", "force")
+OCR_ENGINES = ("tesseract", "vision")
+IMAGE_EXTENSIONS = {".png", ".jpg", ".jpeg", ".tif", ".tiff", ".gif", ".bmp", ".webp"}
+# Resolution scanned PDF pages are rendered at for recognition
+PDF_RENDER_DPI = 300
+# Pages with fewer extracted characters are taken for scans in auto mode
+MIN_TEXT_LAYER_CHARS = 20
+
+
+class TesseractOCR:
+    """Local OCR with tesseract, through pytesseract"""
+
+    name = "tesseract"
+
+    def __init__(self, lang: str = "eng"):
+        import pytesseract
+        self.pytesseract = pytesseract
+        self.lang = lang
+        # Fails early when the tesseract binary is missing
+        pytesseract.get_tesseract_version()
+
+    def recognize(self, image) -> Tuple[str, float]:
+        """Return the text of an image and the mean confidence of its words"""
+        data = self.pytesseract.image_to_data(image, lang=self.lang, output_type=self.pytesseract.Output.DICT)
+        lines = {}
+        confidences = []
+        for i, word in enumerate(data["text"]):
+            confidence = float(data["conf"][i])
+            if confidence < 0 or not word.strip():
+                continue
+            confidences.append(confidence / 100)
+            key = (data["block_num"][i], data["par_num"][i], data["line_num"][i])
+            lines.setdefault(key, []).append(word)
+        text = "\n".join(" ".join(words) for _, words in sorted(lines.items()))
+        return text, sum(confidences) / len(confidences) if confidences else 0.0
+
+
+class VisionOCR:
+    """OCR with the Google Cloud Vision API, using the application default
+    credentials"""
+
+    name = "vision"
+
+    def __init__(self, lang: str = ""):
+        from google.cloud import vision
+        self.vision = vision
+        self.client = vision.ImageAnnotatorClient()
+        self.context = vision.ImageContext(language_hints=[lang]) if lang else None
+
+    def recognize(self, image) -> Tuple[str, float]:
+        """Return the text of an image and the confidence of its page"""
+        buffer = io.BytesIO()
+        image.save(buffer, format="PNG")
+        response = self.client.document_text_detection(image=self.vision.Image(content=buffer.getvalue()),
+                                                       image_context=self.context)
+        if response.error.message:
+            raise RuntimeError(f"Vision API: {response.error.message}")
+        annotation = response.full_text_annotation
+        pages = list(annotation.pages)
+        confidence = sum(page.confidence for page in pages) / len(pages) if pages else 0.0
+        return annotation.text, confidence
+
+
+def create_ocr(mode: str, engine: str, lang: str = ""):
+    """Return the OCR engine of the options, None when OCR is off. In auto
+    mode a missing engine only disables OCR."""
+    if mode == "off":
+        return None
+    try:
+        if engine == "vision":
+            return VisionOCR(lang)
+        return TesseractOCR(lang or "eng")
+    except Exception as e:
+        if mode == "force":
+            raise
+        print(f"Warning: OCR disabled, {engine} is not available: {e}")
+        return None
+
+
+def image_pages(data: bytes) -> List:
+    """Open an image file, e.g. a scan or a screenshot attachment. Each frame
+    of a multi-page TIFF is a page."""
+    from PIL import Image, ImageSequence
+    image = Image.open(io.BytesIO(data))
+    return [frame.convert("RGB") for frame in ImageSequence.Iterator(image)]
+
+
+def render_pdf_page(data: bytes, page_number: int) -> Optional[object]:
+    """Render a PDF page (1-based) to an image, with pdf2image and poppler"""
+    from pdf2image import convert_from_bytes
+    images = convert_from_bytes(data, dpi=PDF_RENDER_DPI, first_page=page_number, last_page=page_number)
+    return images[0] if images else None
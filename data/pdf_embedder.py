@@ -5,68 +5,265 @@ Processes all PDF files in the source directory and embeds them into ChromaDB
 """
 
 import os
+import io
+import re
 import sys
+import json
+import time
+import hashlib
 from pathlib import Path
 import PyPDF2
 import chromadb
 from chromadb.config import Settings
 from sentence_transformers import SentenceTransformer
 import argparse
-from typing import List, Dict
+from typing import List, Dict, Optional, Tuple
+
+from acl import GROUPS_KEY, ROLES_KEY, acl_metadata, same_access
+from reduction import EmbeddingReducer, parse_reduction
+from ocr import (IMAGE_EXTENSIONS, MIN_TEXT_LAYER_CHARS, OCR_ENGINES, OCR_MODES, create_ocr,
+                 image_pages, render_pdf_page)
+
+DEFAULT_COLLECTION = "pdf_documents"
+# Collections record the model they were embedded with, those without were
+# embedded with the default
+DEFAULT_MODEL = "all-MiniLM-L6-v2"
+# Models of the Vertex AI embedding API are named with this prefix, e.g.
+# vertex:text-embedding-005; other names are sentence-transformers models
+VERTEX_MODEL_PREFIX = "vertex:"
+
+# What to do with a chunk duplicating one already stored: keep it anyway,
+# skip it, or skip it and record its source on the stored chunk
+DEDUP_MODES = ("off", "skip", "merge")
+# Cosine similarity above which chunks are near-duplicates
+DEFAULT_DEDUP_THRESHOLD = 0.95
+
+def content_hash(text: str) -> str:
+    """Hash a chunk ignoring case and whitespace, so trivially reformatted
+    copies match"""
+    normalized = re.sub(r"\s+", " ", text).strip().lower()
+    return hashlib.sha256(normalized.encode()).hexdigest()
+
+# A page of extracted text: its start and end offsets in the text, its
+# number, and its OCR confidence when it was recognized from an image
+PageSpan = Tuple[int, int, int, Optional[float]]
+
+class ExtractedText(str):
+    """Text extracted from a document, with the spans of its pages so chunks
+    can carry their page numbers and OCR confidence"""
+    pages: List[PageSpan] = []
+
+    @classmethod
+    def from_pages(cls, pages: List[Tuple[int, str, Optional[float]]]) -> "ExtractedText":
+        """Join (number, text, confidence) pages under page markers"""
+        text, spans = "", []
+        for number, page_text, confidence in pages:
+            if not page_text.strip():
+                continue
+            text += f"\n--- Page {number} ---\n"
+            spans.append((len(text), len(text) + len(page_text), number, confidence))
+            text += page_text
+        lead = len(text) - len(text.lstrip())
+        extracted = cls(text.strip())
+        extracted.pages = [(start - lead, end - lead, number, confidence) for start, end, number, confidence in spans]
+        return extracted
+
+def tenant_collection(tenant: str, tenants_file: Optional[str] = None) -> str:
+    """Return the collection of a tenant: the one configured in the service's
+    TENANTS_FILE, or tenant_<id> as the service defaults to"""
+    if tenants_file:
+        with open(tenants_file) as f:
+            tenants = json.load(f)
+        if tenant not in tenants:
+            raise ValueError(f"Tenant '{tenant}' is not in {tenants_file}")
+        collection = tenants[tenant].get("collection")
+        if collection:
+            return collection
+    return f"tenant_{tenant}"
+
+class VertexEmbeddingModel:
+    """A Vertex AI text embedding model behind the encode() of
+    sentence-transformers, using the application default credentials and the
+    GOOGLE_CLOUD_PROJECT and GOOGLE_CLOUD_REGION of the environment"""
+
+    # Texts per request, within the API's limit of 20k tokens per request
+    batch_size = 32
+
+    def __init__(self, name: str):
+        import vertexai
+        from vertexai.language_models import TextEmbeddingModel
+        vertexai.init(project=os.getenv("GOOGLE_CLOUD_PROJECT"), location=os.getenv("GOOGLE_CLOUD_REGION"))
+        self.model = TextEmbeddingModel.from_pretrained(name)
+
+    def encode(self, texts: List[str], normalize_embeddings: bool = False):
+        import numpy as np
+        vectors = []
+        for start in range(0, len(texts), self.batch_size):
+            vectors.extend(e.values for e in self.model.get_embeddings(list(texts[start:start + self.batch_size])))
+        embeddings = np.array(vectors, dtype=np.float32)
+        if normalize_embeddings:
+            norms = np.linalg.norm(embeddings, axis=1, keepdims=True)
+            embeddings = embeddings / np.where(norms == 0, 1, norms)
+        return embeddings
+
+
+def load_embedding_model(name: str):
+    """Load a sentence-transformers model, or a Vertex AI one when its name
+    starts with vertex:"""
+    if name.startswith(VERTEX_MODEL_PREFIX):
+        return VertexEmbeddingModel(name[len(VERTEX_MODEL_PREFIX):])
+    return SentenceTransformer(name)
+
+
+def declare_dimension(collection, dimension: int, model_dimension: Optional[int] = None):
+    """Record the embedding dimension of a collection on its first chunks, and
+    refuse embeddings of another dimension. Reduced collections also record
+    the dimension of the model's embeddings, which queries may be sent in."""
+    metadata = dict(collection.metadata or {})
+    declared = metadata.get("embedding_dimension")
+    if declared is None:
+        # Distance settings can't be changed, so they aren't passed back
+        metadata = {key: value for key, value in metadata.items() if not key.startswith("hnsw:")}
+        metadata["embedding_dimension"] = dimension
+        if model_dimension:
+            metadata["model_dimension"] = model_dimension
+        collection.modify(metadata=metadata)
+    elif declared != dimension:
+        raise ValueError(f"Collection '{collection.name}' declares {declared} dimensions, "
+                         f"the embeddings have {dimension}")
+
 
 class PDFEmbedder:
-    def __init__(self, source_dir: str = "source", db_path: str = "./chroma_db"):
+    def __init__(self, source_dir: str = "source", db_path: str = "./chroma_db",
+                 collection_name: str = DEFAULT_COLLECTION, tenant: Optional[str] = None,
+                 dedup: str = "skip", dedup_threshold: float = DEFAULT_DEDUP_THRESHOLD,
+                 ocr_mode: str = "auto", ocr_engine: str = "tesseract", ocr_lang: str = "",
+                 model_name: str = DEFAULT_MODEL, reduction: Optional[str] = None,
+                 acl_groups: str = "", acl_roles: str = ""):
         self.source_dir = Path(source_dir)
         self.db_path = Path(db_path)
+        self.collection_name = collection_name
+        # Chunks are tagged with their tenant, the service only retrieves a
+        # tenant's own chunks
+        self.tenant = tenant
+        self.dedup = dedup
+        self.dedup_threshold = dedup_threshold
+        self.ocr_mode = ocr_mode
+        self.ocr_engine = ocr_engine
+        self.ocr_lang = ocr_lang
+        self.ocr = None
+        self.model_name = model_name
+        # Reduction of a new collection, "none" for full embeddings; None
+        # takes that of the collection
+        self.reduction = reduction
+        self.reducer = None
+        # Groups and roles allowed to retrieve the documents, public when
+        # empty; documents can override them with acl_groups and acl_roles
+        # metadata
+        self.acl_groups = acl_groups
+        self.acl_roles = acl_roles
         self.client = None
         self.collection = None
         self.model = None
         
+    def collection_metadata(self) -> Dict:
+        metadata = {"description": "PDF document embeddings", "embedding_model": self.model_name}
+        if self.reduction and self.reduction != "none":
+            metadata["embedding_reduction"] = self.reduction
+        return metadata
+        
     def initialize_chromadb(self):
         """Initialize ChromaDB client and collection"""
         print("Initializing ChromaDB...")
         self.client = chromadb.PersistentClient(path=str(self.db_path))
         
         # Create or get collection
-        self.collection = self.client.get_or_create_collection(
-            name="pdf_documents",
-            metadata={"description": "PDF document embeddings"}
-        )
-        print(f"ChromaDB initialized at: {self.db_path}")
+        try:
+            self.collection = self.client.get_collection(name=self.collection_name)
+        except Exception:
+            # Principal components are fitted on a collection's chunks
+            if self.reduction and self.reduction != "none" and parse_reduction(self.reduction)[0] == "pca":
+                raise ValueError(f"Collection '{self.collection_name}' has no chunks to fit {self.reduction} on, "
+                                 f"embed without --reduce and then run reembed.py --reduce {self.reduction}")
+            self.collection = self.client.create_collection(
+                name=self.collection_name,
+                metadata=self.collection_metadata()
+            )
+        # Embeddings of different models can't be compared
+        collection_model = (self.collection.metadata or {}).get("embedding_model", DEFAULT_MODEL)
+        if collection_model != self.model_name:
+            raise ValueError(f"Collection '{self.collection_name}' is embedded with {collection_model}, "
+                             f"re-embed it with reembed.py to switch to {self.model_name}")
+        collection_reduction = (self.collection.metadata or {}).get("embedding_reduction") or "none"
+        if self.reduction and self.reduction != collection_reduction:
+            raise ValueError(f"Collection '{self.collection_name}' is reduced with {collection_reduction}, "
+                             f"re-embed it with reembed.py --reduce {self.reduction} to switch")
+        self.reducer = EmbeddingReducer.for_collection(self.collection, self.db_path)
+        print(f"ChromaDB initialized at: {self.db_path} (collection '{self.collection_name}')")
         
     def initialize_model(self):
         """Initialize sentence transformer model"""
-        print("Loading embedding model...")
-        self.model = SentenceTransformer('all-MiniLM-L6-v2')
+        print(f"Loading embedding model {self.model_name}...")
+        self.model = load_embedding_model(self.model_name)
         print("Embedding model loaded successfully")
+    
+    def initialize_ocr(self):
+        """Initialize the OCR engine for scanned pages and images"""
+        self.ocr = create_ocr(self.ocr_mode, self.ocr_engine, self.ocr_lang)
+        if self.ocr:
+            print(f"OCR enabled with {self.ocr.name} ({self.ocr_mode})")
         
-    def extract_text_from_pdf(self, pdf_path: Path) -> str:
-        """Extract text content from PDF file"""
-        text = ""
+    def extract_text_from_pdf(self, pdf_path) -> str:
+        """Extract text content from a PDF file path or binary file object.
+        Pages without a text layer are recognized with OCR when enabled."""
+        pages = []
         try:
-            with open(pdf_path, 'rb') as file:
-                pdf_reader = PyPDF2.PdfReader(file)
-                for page_num, page in enumerate(pdf_reader.pages):
+            if isinstance(pdf_path, (str, Path)):
+                with open(pdf_path, 'rb') as file:
+                    return self.extract_text_from_pdf(file)
+            data = pdf_path.read()
+            pdf_reader = PyPDF2.PdfReader(io.BytesIO(data))
+            for page_num, page in enumerate(pdf_reader.pages):
+                page_text = ""
+                try:
+                    page_text = page.extract_text() or ""
+                except Exception as e:
+                    print(f"Warning: Could not extract text from page {page_num + 1} of {pdf_path}: {e}")
+                confidence = None
+                if self.ocr and (self.ocr_mode == "force" or len(page_text.strip()) < MIN_TEXT_LAYER_CHARS):
                     try:
-                        page_text = page.extract_text()
-                        if page_text.strip():
-                            text += f"\n--- Page {page_num + 1} ---\n"
-                            text += page_text
+                        page_text, confidence = self.ocr.recognize(render_pdf_page(data, page_num + 1))
                     except Exception as e:
-                        print(f"Warning: Could not extract text from page {page_num + 1} of {pdf_path}: {e}")
-                        continue
+                        print(f"Warning: OCR failed on page {page_num + 1} of {pdf_path}: {e}")
+                pages.append((page_num + 1, page_text, confidence))
         except Exception as e:
             print(f"Error reading PDF {pdf_path}: {e}")
             return ""
         
-        return text.strip()
+        return ExtractedText.from_pages(pages)
+    
+    def extract_text_from_image(self, data: bytes, name: str = "") -> str:
+        """Recognize the text of an image with OCR, each frame a page"""
+        if not self.ocr:
+            print(f"Warning: OCR is disabled, skipping image {name}")
+            return ""
+        try:
+            return ExtractedText.from_pages([(number, *self.ocr.recognize(image))
+                                             for number, image in enumerate(image_pages(data), 1)])
+        except Exception as e:
+            print(f"Error recognizing image {name}: {e}")
+            return ""
     
     def chunk_text(self, text: str, chunk_size: int = 1000, overlap: int = 100) -> List[str]:
         """Split text into overlapping chunks"""
+        return [text[start:end].strip() for start, end in self.chunk_spans(text, chunk_size, overlap)]
+    
+    def chunk_spans(self, text: str, chunk_size: int = 1000, overlap: int = 100) -> List[Tuple[int, int]]:
+        """Return the start and end offsets of overlapping chunks of text"""
         if len(text) <= chunk_size:
-            return [text]
+            return [(0, len(text))]
         
-        chunks = []
+        spans = []
         start = 0
         
         while start < len(text):
@@ -81,59 +278,189 @@ class PDFEmbedder:
                         end = i + 1
                         break
             
-            chunk = text[start:end].strip()
-            if chunk:
-                chunks.append(chunk)
+            if text[start:end].strip():
+                spans.append((start, end))
             
             start = end - overlap
             if start >= len(text):
                 break
                 
-        return chunks
+        return spans
     
     def embed_pdf(self, pdf_path: Path) -> bool:
         """Process and embed a single PDF file"""
         print(f"Processing: {pdf_path.name}")
         
         # Extract text
-        text = self.extract_text_from_pdf(pdf_path)
+        if pdf_path.suffix.lower() in IMAGE_EXTENSIONS:
+            text = self.extract_text_from_image(pdf_path.read_bytes(), pdf_path.name)
+        else:
+            text = self.extract_text_from_pdf(pdf_path)
         if not text:
             print(f"Warning: No text extracted from {pdf_path.name}")
             return False
         
+        return self.embed_text(text, pdf_path.stem, str(pdf_path), {"filename": pdf_path.name})
+    
+    def embed_text(self, text: str, id_prefix: str, source: str,
+                   extra_metadata: Optional[Dict] = None) -> bool:
+        """Chunk, embed and store the text of one document. Chunks are tagged
+        with their source so they can be replaced when the document changes."""
         # Split into chunks
-        chunks = self.chunk_text(text)
+        spans = self.chunk_spans(text)
+        chunks = [text[start:end].strip() for start, end in spans]
         print(f"  Split into {len(chunks)} chunks")
         
-        # Generate embeddings
-        embeddings = self.model.encode(chunks)
+        # Generate embeddings, normalized so similarity follows from distance
+        embeddings = self.model.encode(chunks, normalize_embeddings=True)
+        model_dimension = None
+        if self.reducer:
+            model_dimension = len(embeddings[0])
+            embeddings = self.reducer.reduce(embeddings)
         
         # Prepare metadata and IDs
-        ids = [f"{pdf_path.stem}_chunk_{i}" for i in range(len(chunks))]
+        ids = [f"{id_prefix}_chunk_{i}" for i in range(len(chunks))]
+        embedded_at = int(time.time())
         metadatas = [
             {
-                "source": str(pdf_path),
-                "filename": pdf_path.name,
+                **(extra_metadata or {}),
+                "source": source,
                 "chunk_index": i,
-                "total_chunks": len(chunks)
+                "total_chunks": len(chunks),
+                "content_hash": content_hash(chunks[i]),
+                "also_in": "",
+                "embedded_at": embedded_at
             }
             for i in range(len(chunks))
         ]
+        acl = acl_metadata((extra_metadata or {}).get(GROUPS_KEY, self.acl_groups),
+                           (extra_metadata or {}).get(ROLES_KEY, self.acl_roles))
+        for metadata in metadatas:
+            metadata.update(acl)
+        if self.tenant:
+            for metadata in metadatas:
+                metadata["tenant"] = self.tenant
+        for metadata, span in zip(metadatas, spans):
+            metadata.update(self.page_metadata(getattr(text, "pages", []), span))
         
-        # Add to ChromaDB
         try:
-            self.collection.add(
-                embeddings=embeddings.tolist(),
-                documents=chunks,
-                metadatas=metadatas,
-                ids=ids
+            declare_dimension(self.collection, len(embeddings[0]), model_dimension)
+            keep = self.deduplicate(ids, chunks, embeddings, metadatas, source)
+            if not keep:
+                print("  Every chunk duplicates stored content, nothing to add")
+                return True
+            
+            # Add to ChromaDB
+            self.collection.upsert(
+                embeddings=[embeddings[i].tolist() for i in keep],
+                documents=[chunks[i] for i in keep],
+                metadatas=[metadatas[i] for i in keep],
+                ids=[ids[i] for i in keep]
             )
-            print(f"  Successfully embedded {len(chunks)} chunks")
+            print(f"  Successfully embedded {len(keep)} chunks")
             return True
         except Exception as e:
             print(f"Error adding to ChromaDB: {e}")
             return False
     
+    @staticmethod
+    def page_metadata(pages: List[PageSpan], span: Tuple[int, int]) -> Dict:
+        """Return the pages a chunk spans and, when some were recognized with
+        OCR, the lowest confidence among them"""
+        overlapping = [page for page in pages if page[0] < span[1] and page[1] > span[0]]
+        if not overlapping:
+            return {}
+        metadata = {"page_start": overlapping[0][2], "page_end": overlapping[-1][2]}
+        confidences = [page[3] for page in overlapping if page[3] is not None]
+        if confidences:
+            metadata["ocr"] = True
+            metadata["ocr_confidence"] = round(min(confidences), 3)
+        return metadata
+    
+    def find_duplicate(self, chunk_id: str, hash_: str, embedding) -> Optional[Dict]:
+        """Return the id and metadata of a stored chunk with the same content
+        hash or an embedding within the similarity threshold"""
+        found = self.collection.get(where={"content_hash": hash_}, include=["metadatas"], limit=2)
+        for other_id, metadata in zip(found["ids"], found["metadatas"]):
+            if other_id != chunk_id:
+                return {"id": other_id, "metadata": metadata}
+        
+        if self.collection.count() == 0:
+            return None
+        near = self.collection.query(query_embeddings=[embedding.tolist()], n_results=2,
+                                     include=["metadatas", "distances"])
+        for other_id, metadata, distance in zip(near["ids"][0], near["metadatas"][0], near["distances"][0]):
+            # Squared L2 distance of unit vectors: cos = 1 - d / 2
+            if other_id != chunk_id and 1 - distance / 2 >= self.dedup_threshold:
+                return {"id": other_id, "metadata": metadata}
+        return None
+    
+    def deduplicate(self, ids: List[str], chunks: List[str], embeddings, metadatas: List[Dict],
+                    source: str) -> List[int]:
+        """Return the indexes of the chunks to store, dropping those that
+        duplicate stored chunks or earlier chunks of the same document. In
+        merge mode, the stored chunk records the duplicate's source."""
+        if self.dedup == "off":
+            return list(range(len(chunks)))
+        
+        keep = []
+        seen_hashes = set()
+        skipped = 0
+        for i in range(len(chunks)):
+            hash_ = metadatas[i]["content_hash"]
+            duplicate_in_batch = hash_ in seen_hashes or any(
+                float(embeddings[i] @ embeddings[j]) >= self.dedup_threshold for j in keep)
+            if duplicate_in_batch:
+                skipped += 1
+                continue
+            
+            # Chunks are only shared between documents with the same access,
+            # so dropping one never hides content from its readers
+            duplicate = self.find_duplicate(ids[i], hash_, embeddings[i])
+            if duplicate is not None and not same_access(duplicate["metadata"], metadatas[i]):
+                duplicate = None
+            if duplicate is None:
+                keep.append(i)
+                seen_hashes.add(hash_)
+                continue
+            
+            skipped += 1
+            if self.dedup == "merge" and duplicate["metadata"].get("source") != source:
+                metadata = dict(duplicate["metadata"])
+                sources = [s for s in metadata.get("also_in", "").split("\n") if s]
+                if source not in sources:
+                    metadata["also_in"] = "\n".join(sources + [source])
+                    self.collection.update(ids=[duplicate["id"]], metadatas=[metadata])
+        
+        if skipped:
+            action = "merged" if self.dedup == "merge" else "skipped"
+            print(f"  {action.capitalize()} {skipped} duplicate chunks")
+        return keep
+    
+    def delete_source(self, source: str):
+        """Remove every chunk of a document from the collection. Chunks other
+        documents were merged into are handed over to the first of them
+        instead, and the document is dropped from the chunks it was merged
+        into."""
+        owned = self.collection.get(where={"source": source}, include=["metadatas", "documents", "embeddings"])
+        for chunk_id, metadata, document, embedding in zip(owned["ids"], owned["metadatas"],
+                                                           owned["documents"], owned["embeddings"]):
+            sources = [s for s in metadata.get("also_in", "").split("\n") if s]
+            if sources:
+                # A new id, so re-ingesting this document doesn't overwrite it
+                new_owner = hashlib.sha1(sources[0].encode()).hexdigest()[:8]
+                self.collection.add(ids=[f"{chunk_id}_{new_owner}"], documents=[document],
+                                    embeddings=[list(embedding)], metadatas=[
+                    {**metadata, "source": sources[0], "also_in": "\n".join(sources[1:])}])
+        self.collection.delete(where={"source": source})
+        
+        merged = self.collection.get(where={"also_in": {"$ne": ""}}, include=["metadatas"])
+        for chunk_id, metadata in zip(merged["ids"], merged["metadatas"]):
+            sources = [s for s in metadata.get("also_in", "").split("\n") if s]
+            if source in sources:
+                sources.remove(source)
+                self.collection.update(ids=[chunk_id], metadatas=[{**metadata, "also_in": "\n".join(sources)}])
+    
     def process_all_pdfs(self) -> Dict[str, int]:
         """Process all PDF files in the source directory"""
         if not self.source_dir.exists():
@@ -141,6 +468,9 @@ class PDFEmbedder:
             return {"processed": 0, "failed": 0}
         
         pdf_files = list(self.source_dir.glob("*.pdf"))
+        # Scanned pages saved as images are ingested with OCR
+        if self.ocr:
+            pdf_files += [f for f in self.source_dir.iterdir() if f.suffix.lower() in IMAGE_EXTENSIONS]
         if not pdf_files:
             print(f"No PDF files found in {self.source_dir}")
             return {"processed": 0, "failed": 0}
@@ -182,25 +512,70 @@ def main():
                        help="ChromaDB storage path (default: ./chroma_db)")
     parser.add_argument("--reset", "-r", action="store_true",
                        help="Reset the database before processing")
+    parser.add_argument("--tenant", "-t",
+                       help="Ingest for this tenant, into its collection and tagged with its id")
+    parser.add_argument("--tenants-file",
+                       help="The service's TENANTS_FILE, to look up the tenant's collection")
+    parser.add_argument("--collection", "-c",
+                       help=f"Collection name without a tenant (default: {DEFAULT_COLLECTION})")
+    parser.add_argument("--dedup", choices=DEDUP_MODES, default="skip",
+                       help="Chunks duplicating stored ones: store anyway (off), drop (skip), or drop and "
+                            "record their source on the stored chunk (merge) (default: skip)")
+    parser.add_argument("--ocr", choices=OCR_MODES, default="auto",
+                       help="OCR pages without a text layer and images (auto), every PDF page (force), "
+                            "or nothing (off) (default: auto)")
+    parser.add_argument("--ocr-engine", choices=OCR_ENGINES, default="tesseract",
+                       help="Local tesseract, or the Google Cloud Vision API (default: tesseract)")
+    parser.add_argument("--ocr-lang", default="",
+                       help="OCR language, e.g. eng+deu for tesseract or de for Vision (default: English or auto-detected)")
+    parser.add_argument("--dedup-threshold", type=float, default=DEFAULT_DEDUP_THRESHOLD,
+                       help=f"Cosine similarity of near-duplicate chunks (default: {DEFAULT_DEDUP_THRESHOLD})")
+    parser.add_argument("--model", default=DEFAULT_MODEL,
+                       help=f"Sentence-transformers embedding model of the collection (default: {DEFAULT_MODEL})")
+    parser.add_argument("--acl-groups", default="",
+                       help="Comma-separated groups allowed to retrieve the documents (default: public)")
+    parser.add_argument("--acl-roles", default="",
+                       help="Comma-separated roles allowed to retrieve the documents (default: public)")
+    parser.add_argument("--reduce",
+                       help="Store embeddings of a new collection reduced to fewer dimensions, e.g. truncate:256 "
+                            "for Matryoshka models, or none (default: the collection's reduction)")
     
     args = parser.parse_args()
+    try:
+        if args.reduce and args.reduce != "none":
+            parse_reduction(args.reduce)
+        acl_metadata(args.acl_groups, args.acl_roles)
+    except ValueError as e:
+        parser.error(str(e))
+    
+    # A tenant's documents always go to its own collection
+    if args.tenant:
+        if args.collection:
+            parser.error("--collection cannot be combined with --tenant")
+        collection_name = tenant_collection(args.tenant, args.tenants_file)
+    else:
+        collection_name = args.collection or DEFAULT_COLLECTION
     
     # Initialize embedder
-    embedder = PDFEmbedder(args.source, args.db_path)
+    embedder = PDFEmbedder(args.source, args.db_path, collection_name, args.tenant,
+                           args.dedup, args.dedup_threshold, args.ocr, args.ocr_engine, args.ocr_lang,
+                           args.model, args.reduce, args.acl_groups, args.acl_roles)
     
     try:
         # Initialize components
         embedder.initialize_chromadb()
         embedder.initialize_model()
+        embedder.initialize_ocr()
         
         # Reset database if requested
         if args.reset:
             print("Resetting database...")
             embedder.collection.delete()
             embedder.collection = embedder.client.get_or_create_collection(
-                name="pdf_documents",
-                metadata={"description": "PDF document embeddings"}
+                name=embedder.collection_name,
+                metadata=embedder.collection_metadata()
             )
+            embedder.reducer = EmbeddingReducer.for_collection(embedder.collection, embedder.db_path)
             print("Database reset complete")
         
         # Process PDFs
@@ -0,0 +1,96 @@
+#!/usr/bin/env python3
+"""
+Query Result Cache
+Keeps the results of vector store queries for a short time, so repeated and
+popular questions skip the vector search. Results are keyed by the normalized
+query embedding, or the normalized query text when Chroma embeds the query
+itself, together with the collection and the filters. Ingesting through the
+service drops the entries of the collection; changes made by other processes,
+e.g. connectors, show once entries expire.
+"""
+
+import hashlib
+import json
+import math
+import threading
+import time
+from collections import OrderedDict
+from typing import Dict, List, Optional
+
+DEFAULT_TTL = 60.0
+DEFAULT_SIZE = 1000
+# Decimals of the normalized embedding in keys, so float noise doesn't
+# split entries
+EMBEDDING_DECIMALS = 4
+
+
+def query_key(collection_id, query: str, embedding: Optional[List[float]], **params) -> str:
+    """Hash a query of a collection with its embedding, or its text ignoring
+    case and whitespace, and the other query parameters"""
+    h = hashlib.sha256(str(collection_id).encode())
+    if embedding:
+        norm = math.sqrt(sum(v * v for v in embedding)) or 1.0
+        h.update(b"embedding:" + ",".join(f"{v / norm:.{EMBEDDING_DECIMALS}f}" for v in embedding).encode())
+    else:
+        h.update(b"text:" + " ".join(query.lower().split()).encode())
+    h.update(json.dumps(params, sort_keys=True, default=str).encode())
+    return h.hexdigest()
+
+
+class QueryCache:
+    """An LRU cache of query results with a time to live, disabled when the
+    TTL or size is 0"""
+
+    def __init__(self, ttl: float = DEFAULT_TTL, max_size: int = DEFAULT_SIZE):
+        self.ttl = ttl
+        self.max_size = max_size
+        # key -> (expiry, collection name, result), least recently used first
+        self.entries: OrderedDict = OrderedDict()
+        self.lock = threading.Lock()
+        self.hits = 0
+        self.misses = 0
+        self.evictions = 0
+
+    @property
+    def enabled(self) -> bool:
+        return self.ttl > 0 and self.max_size > 0
+
+    def get(self, key: str) -> Optional[Dict]:
+        with self.lock:
+            entry = self.entries.get(key)
+            if entry is None or entry[0] < time.monotonic():
+                if entry is not None:
+                    del self.entries[key]
+                self.misses += 1
+                return None
+            self.entries.move_to_end(key)
+            self.hits += 1
+            return entry[2]
+
+    def put(self, key: str, collection: str, result: Dict):
+        with self.lock:
+            self.entries[key] = (time.monotonic() + self.ttl, collection, result)
+            self.entries.move_to_end(key)
+            while len(self.entries) > self.max_size:
+                self.entries.popitem(last=False)
+                self.evictions += 1
+
+    def invalidate(self, collection: Optional[str] = None):
+        """Drop the entries of a collection, or every entry"""
+        with self.lock:
+            if collection is None:
+                self.entries.clear()
+                return
+            for key in [key for key, entry in self.entries.items() if entry[1] == collection]:
+                del self.entries[key]
+
+    def stats(self) -> Dict:
+        with self.lock:
+            return {
+                "enabled": self.enabled,
+                "ttl_seconds": self.ttl,
+                "entries": len(self.entries),
+                "hits": self.hits,
+                "misses": self.misses,
+                "evictions": self.evictions,
+            }
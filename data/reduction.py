@@ -0,0 +1,96 @@
+#!/usr/bin/env python3
+"""
+Embedding Dimension Reduction
+Collections can store their embeddings reduced to fewer dimensions, trading a
+little recall for smaller indexes and faster queries on large corpora. A
+collection declares its reduction as embedding_reduction in its metadata:
+
+- truncate:<n> keeps the first n dimensions, for Matryoshka models (e.g.
+  nomic-embed-text-v1.5 or vertex:text-embedding-005) whose embedding
+  prefixes are embeddings themselves
+- pca:<n> projects onto the n principal components of the collection's
+  chunks, fitted by reembed.py and stored next to the database
+
+Reduced embeddings are normalized again, and queries are reduced the same way.
+"""
+
+from pathlib import Path
+from typing import Optional, Tuple
+
+import numpy as np
+
+REDUCTION_METHODS = ("truncate", "pca")
+# Chunks the principal components are fitted on
+PCA_SAMPLE_SIZE = 10000
+# Directory of the database holding fitted components, per collection id so
+# they follow the collection when reembed.py renames it
+COMPONENTS_DIR = "reductions"
+
+
+def parse_reduction(spec: str) -> Tuple[str, int]:
+    """Split a reduction such as truncate:256 into its method and dimensions"""
+    method, _, dimensions = spec.partition(":")
+    if method not in REDUCTION_METHODS or not dimensions.isdigit() or int(dimensions) == 0:
+        raise ValueError(f"Invalid reduction '{spec}', expected truncate:<dimensions> or pca:<dimensions>")
+    return method, int(dimensions)
+
+
+def components_path(db_path, collection) -> Path:
+    return Path(db_path) / COMPONENTS_DIR / f"{collection.id}.npz"
+
+
+class EmbeddingReducer:
+    def __init__(self, spec: str, mean: Optional[np.ndarray] = None, components: Optional[np.ndarray] = None):
+        self.spec = spec
+        self.method, self.dimensions = parse_reduction(spec)
+        self.mean = mean
+        self.components = components
+
+    @classmethod
+    def for_collection(cls, collection, db_path) -> Optional["EmbeddingReducer"]:
+        """Return the reducer a collection declares, None when it stores
+        embeddings as the model returns them"""
+        spec = (collection.metadata or {}).get("embedding_reduction")
+        if not spec:
+            return None
+        reducer = cls(spec)
+        if reducer.method == "pca":
+            path = components_path(db_path, collection)
+            if not path.exists():
+                raise ValueError(f"Collection '{collection.name}' declares {spec} but {path} is missing, "
+                                 f"fit it again with reembed.py --reduce {spec}")
+            with np.load(path) as fitted:
+                reducer.mean, reducer.components = fitted["mean"], fitted["components"]
+        return reducer
+
+    @classmethod
+    def fit_pca(cls, embeddings: np.ndarray, dimensions: int) -> "EmbeddingReducer":
+        """Fit the principal components of a sample of full embeddings"""
+        if len(embeddings) < dimensions:
+            raise ValueError(f"pca:{dimensions} needs at least {dimensions} chunks to fit, "
+                             f"the collection has {len(embeddings)}")
+        embeddings = np.asarray(embeddings, dtype=np.float32)
+        if dimensions >= embeddings.shape[1]:
+            raise ValueError(f"pca:{dimensions} does not reduce {embeddings.shape[1]}-dimensional embeddings")
+        mean = embeddings.mean(axis=0)
+        _, _, vt = np.linalg.svd(embeddings - mean, full_matrices=False)
+        return cls(f"pca:{dimensions}", mean, vt[:dimensions])
+
+    def save(self, path: Path):
+        path.parent.mkdir(parents=True, exist_ok=True)
+        np.savez(path, mean=self.mean, components=self.components)
+
+    def reduce(self, embeddings) -> np.ndarray:
+        """Reduce a batch of full embeddings and normalize them again"""
+        embeddings = np.asarray(embeddings, dtype=np.float32)
+        if self.method == "truncate":
+            if self.dimensions >= embeddings.shape[1]:
+                raise ValueError(f"{self.spec} does not reduce {embeddings.shape[1]}-dimensional embeddings")
+            reduced = embeddings[:, :self.dimensions]
+        else:
+            if embeddings.shape[1] != len(self.mean):
+                raise ValueError(f"{self.spec} was fitted on {len(self.mean)}-dimensional embeddings, "
+                                 f"got {embeddings.shape[1]}")
+            reduced = (embeddings - self.mean) @ self.components.T
+        norms = np.linalg.norm(reduced, axis=1, keepdims=True)
+        return reduced / np.where(norms == 0, 1, norms)
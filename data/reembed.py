@@ -0,0 +1,164 @@
+#!/usr/bin/env python3
+"""
+Re-embedding Job for ChromaDB
+Re-embeds the stored chunks of a collection with another embedding model, e.g.
+after switching to a better one. Chunks keep their ids, text and metadata, so
+connector sync state stays valid and sources don't need to be fetched again.
+The new embeddings are built in a staging collection that replaces the
+collection once complete; a failed run leaves the collection as it was.
+It also changes a collection's dimension reduction (see reduction.py), fitting
+principal components on the collection's chunks for pca reductions.
+"""
+
+import argparse
+import sys
+import time
+from typing import Dict, Optional
+
+import chromadb
+from pdf_embedder import (DEFAULT_COLLECTION, DEFAULT_MODEL, declare_dimension, load_embedding_model,
+                          tenant_collection)
+from reduction import PCA_SAMPLE_SIZE, EmbeddingReducer, components_path, parse_reduction
+
+DEFAULT_BATCH_SIZE = 256
+STAGING_SUFFIX = "_reembed"
+
+
+def fit_reduction(collection, model, dimensions: int, batch_size: int) -> EmbeddingReducer:
+    """Fit principal components on the embeddings of batches of chunks spread
+    over the collection"""
+    total = collection.count()
+    batches = max(1, min(total, PCA_SAMPLE_SIZE) // batch_size)
+    stride = max(batch_size, total // batches)
+    sample = []
+    for offset in range(0, total, stride)[:batches]:
+        batch = collection.get(include=["documents"], limit=batch_size, offset=offset)
+        sample.extend(model.encode(batch["documents"], normalize_embeddings=True))
+    print(f"  Fitted pca:{dimensions} on {len(sample)} chunks")
+    return EmbeddingReducer.fit_pca(sample, dimensions)
+
+
+def reembed_collection(client, name: str, model_name: str, batch_size: int = DEFAULT_BATCH_SIZE,
+                       force: bool = False, reduction: Optional[str] = None,
+                       db_path: str = "./chroma_db") -> Dict[str, int]:
+    """Re-embed every chunk of a collection with a model and swap the result
+    in under the collection's name. The collection keeps its reduction unless
+    another one, or none, is given."""
+    collection = client.get_collection(name=name)
+    metadata = dict(collection.metadata or {})
+    current = metadata.get("embedding_model", DEFAULT_MODEL)
+    current_reduction = metadata.get("embedding_reduction") or "none"
+    reduction = reduction or current_reduction
+    total = collection.count()
+    if current == model_name and reduction == current_reduction and not force:
+        print(f"Collection '{name}' is already embedded with {model_name} ({reduction} reduction)")
+        return {"chunks": total, "reembedded": 0}
+
+    print(f"Re-embedding {total} chunks of '{name}' from {current} to {model_name} ({reduction} reduction)")
+    model = load_embedding_model(model_name)
+
+    # A staging collection left over by an interrupted run is rebuilt
+    staging_name = name + STAGING_SUFFIX
+    try:
+        client.delete_collection(name=staging_name)
+    except Exception:
+        pass
+    # The new model may have another dimension, declared by its first batch
+    for key in ("embedding_dimension", "model_dimension", "embedding_reduction"):
+        metadata.pop(key, None)
+    if reduction != "none":
+        metadata["embedding_reduction"] = reduction
+    staging = client.create_collection(name=staging_name, metadata={**metadata, "embedding_model": model_name})
+
+    reembedded = 0
+    started = time.monotonic()
+    try:
+        reducer = None
+        if reduction != "none":
+            method, dimensions = parse_reduction(reduction)
+            if method == "pca":
+                reducer = fit_reduction(collection, model, dimensions, batch_size)
+                reducer.save(components_path(db_path, staging))
+            else:
+                reducer = EmbeddingReducer(reduction)
+        for offset in range(0, total, batch_size):
+            batch = collection.get(include=["documents", "metadatas"], limit=batch_size, offset=offset)
+            if not batch["ids"]:
+                break
+            embeddings = model.encode(batch["documents"], normalize_embeddings=True)
+            model_dimension = None
+            if reducer:
+                model_dimension = len(embeddings[0])
+                embeddings = reducer.reduce(embeddings)
+            declare_dimension(staging, len(embeddings[0]), model_dimension)
+            staging.add(
+                ids=batch["ids"],
+                documents=batch["documents"],
+                metadatas=batch["metadatas"],
+                embeddings=[embedding.tolist() for embedding in embeddings]
+            )
+            reembedded += len(batch["ids"])
+            print(f"  {reembedded}/{total} chunks ({time.monotonic() - started:.0f}s)")
+    except Exception:
+        components_path(db_path, staging).unlink(missing_ok=True)
+        client.delete_collection(name=staging_name)
+        raise
+
+    # Chunks added by a sync while re-embedding would be lost in the swap
+    if collection.count() != total:
+        components_path(db_path, staging).unlink(missing_ok=True)
+        client.delete_collection(name=staging_name)
+        raise RuntimeError(f"Collection '{name}' changed while re-embedding, run again when no sync is running")
+
+    client.delete_collection(name=name)
+    staging.modify(name=name)
+    components_path(db_path, collection).unlink(missing_ok=True)
+    print(f"Collection '{name}' now embedded with {model_name} ({reduction} reduction)")
+    return {"chunks": total, "reembedded": reembedded}
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Re-embed a ChromaDB collection with another model")
+    parser.add_argument("--db-path", "-d", default="./chroma_db",
+                       help="ChromaDB storage path (default: ./chroma_db)")
+    parser.add_argument("--tenant", "-t",
+                       help="Re-embed the collection of this tenant")
+    parser.add_argument("--tenants-file",
+                       help="The service's TENANTS_FILE, to look up the tenant's collection")
+    parser.add_argument("--collection", "-c",
+                       help=f"Collection name without a tenant (default: {DEFAULT_COLLECTION})")
+    parser.add_argument("--model", "-m", default=DEFAULT_MODEL,
+                       help=f"Sentence-transformers model to embed with (default: {DEFAULT_MODEL})")
+    parser.add_argument("--batch-size", type=int, default=DEFAULT_BATCH_SIZE,
+                       help=f"Chunks embedded at a time (default: {DEFAULT_BATCH_SIZE})")
+    parser.add_argument("--force", action="store_true",
+                       help="Re-embed even when the collection already uses the model")
+    parser.add_argument("--reduce",
+                       help="Reduce the stored embeddings, e.g. truncate:256 for Matryoshka models or pca:128, "
+                            "or none for full embeddings (default: the collection's reduction)")
+    args = parser.parse_args()
+    if args.reduce and args.reduce != "none":
+        try:
+            parse_reduction(args.reduce)
+        except ValueError as e:
+            parser.error(str(e))
+
+    if args.tenant:
+        if args.collection:
+            parser.error("--collection cannot be combined with --tenant")
+        collection_name = tenant_collection(args.tenant, args.tenants_file)
+    else:
+        collection_name = args.collection or DEFAULT_COLLECTION
+
+    try:
+        client = chromadb.PersistentClient(path=args.db_path)
+        stats = reembed_collection(client, collection_name, args.model, args.batch_size, args.force,
+                                   args.reduce, args.db_path)
+        print(f"Re-embedded {stats['reembedded']} of {stats['chunks']} chunks")
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
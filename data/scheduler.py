@@ -0,0 +1,316 @@
+#!/usr/bin/env python3
+"""
+Job Scheduler
+Runs connector syncs and re-embedding jobs on cron schedules, in the ChromaDB
+service or on its own, keeping the status and recent runs of every job.
+
+Jobs are configured in a JSON file mapping job names to a schedule and the
+command of a script of this directory:
+
+    {
+      "handbook": {"schedule": "*/30 * * * *",
+                   "command": ["bucket_connector.py", "gs://docs/handbook"]},
+      "reembed": {"schedule": "0 3 * * 0", "enabled": false,
+                  "command": ["reembed.py", "--model", "all-mpnet-base-v2"]}
+    }
+"""
+
+import argparse
+import json
+import signal
+import subprocess
+import sys
+import threading
+from collections import deque
+from datetime import datetime, timedelta
+from pathlib import Path
+from typing import Callable, Dict, List, Optional, Set, Tuple
+
+SCRIPT_DIR = Path(__file__).resolve().parent
+# Runs kept per job
+DEFAULT_HISTORY = 20
+# Output kept per run, from its end
+OUTPUT_TAIL_CHARS = 4000
+
+CRON_ALIASES = {
+    "@hourly": "0 * * * *",
+    "@daily": "0 0 * * *",
+    "@weekly": "0 0 * * 0",
+    "@monthly": "0 0 1 * *",
+}
+
+
+def parse_cron_field(field: str, low: int, high: int) -> Set[int]:
+    """Parse a cron field: *, a value, a range a-b, a step */n or a-b/n, or a
+    comma-separated list of those"""
+    values = set()
+    for part in field.split(","):
+        step = 1
+        if "/" in part:
+            part, step_text = part.split("/", 1)
+            step = int(step_text)
+            if step < 1:
+                raise ValueError(f"Invalid step in '{field}'")
+        if part == "*":
+            start, end = low, high
+        elif "-" in part:
+            start, end = map(int, part.split("-", 1))
+        else:
+            start = int(part)
+            end = high if step > 1 else start
+        if start < low or end > high or start > end:
+            raise ValueError(f"'{field}' is out of range {low}-{high}")
+        values.update(range(start, end + 1, step))
+    return values
+
+
+class CronSchedule:
+    """A five-field cron expression (minute hour day month weekday), in the
+    local time of the host. As in cron, a day matches either its day of month
+    or its weekday when both are restricted."""
+
+    def __init__(self, expression: str):
+        self.expression = expression
+        fields = CRON_ALIASES.get(expression.strip(), expression).split()
+        if len(fields) != 5:
+            raise ValueError(f"Cron expression '{expression}' needs 5 fields")
+        self.minutes = parse_cron_field(fields[0], 0, 59)
+        self.hours = parse_cron_field(fields[1], 0, 23)
+        self.days = parse_cron_field(fields[2], 1, 31)
+        self.months = parse_cron_field(fields[3], 1, 12)
+        # Sunday is 0 or 7
+        self.weekdays = {day % 7 for day in parse_cron_field(fields[4], 0, 7)}
+        self.any_day = fields[2] == "*"
+        self.any_weekday = fields[4] == "*"
+
+    def day_matches(self, moment: datetime) -> bool:
+        in_days = moment.day in self.days
+        in_weekdays = (moment.isoweekday() % 7) in self.weekdays
+        if self.any_day or self.any_weekday:
+            return in_days and in_weekdays
+        return in_days or in_weekdays
+
+    def next_after(self, moment: datetime) -> datetime:
+        """Return the first matching minute after a moment"""
+        candidate = moment.replace(second=0, microsecond=0) + timedelta(minutes=1)
+        limit = candidate + timedelta(days=366 * 5)
+        while candidate < limit:
+            if candidate.month not in self.months:
+                month = candidate.month % 12 + 1
+                candidate = candidate.replace(year=candidate.year + (month == 1), month=month, day=1, hour=0, minute=0)
+            elif not self.day_matches(candidate):
+                candidate = (candidate + timedelta(days=1)).replace(hour=0, minute=0)
+            elif candidate.hour not in self.hours:
+                candidate = (candidate + timedelta(hours=1)).replace(minute=0)
+            elif candidate.minute not in self.minutes:
+                candidate += timedelta(minutes=1)
+            else:
+                return candidate
+        raise ValueError(f"Cron expression '{self.expression}' never matches")
+
+
+class Job:
+    """A scheduled command, a script of this directory with its arguments"""
+
+    def __init__(self, name: str, config: Dict):
+        self.name = name
+        self.schedule = CronSchedule(config["schedule"])
+        self.command: List[str] = config["command"]
+        script = self.command[0] if self.command else ""
+        if Path(script).name != script or not (SCRIPT_DIR / script).is_file():
+            raise ValueError(f"Job '{name}' must run a script of {SCRIPT_DIR}")
+        self.enabled = config.get("enabled", True)
+        # Seconds after which a run is stopped, 0 for no limit
+        self.timeout = config.get("timeout", 0)
+        self.next_run: Optional[datetime] = None
+
+    def argv(self) -> List[str]:
+        return [sys.executable, str(SCRIPT_DIR / self.command[0]), *self.command[1:]]
+
+
+def load_jobs(path: str) -> Dict[str, Job]:
+    with open(path) as f:
+        config = json.load(f)
+    return {name: Job(name, job) for name, job in config.items()}
+
+
+class Scheduler:
+    """Runs due jobs one at a time, so jobs never write to a collection
+    concurrently. Jobs that came due while another ran start after it, once."""
+
+    def __init__(self, jobs: Dict[str, Job], history_path: Optional[Path] = None,
+                 on_finish: Optional[Callable[[Dict], None]] = None):
+        self.jobs = jobs
+        self.history_path = history_path
+        self.on_finish = on_finish
+        self.history: Dict[str, deque] = {name: deque(maxlen=DEFAULT_HISTORY) for name in jobs}
+        # Names and triggers of the jobs waiting to run
+        self.queue: List[Tuple[str, str]] = []
+        self.running: Optional[Dict] = None
+        self.process: Optional[subprocess.Popen] = None
+        self.lock = threading.Lock()
+        self.wake = threading.Event()
+        self.stopping = False
+        self.thread: Optional[threading.Thread] = None
+        self.load_history()
+
+    def load_history(self):
+        if not self.history_path or not self.history_path.exists():
+            return
+        with open(self.history_path) as f:
+            for name, runs in json.load(f).items():
+                if name in self.history:
+                    self.history[name].extend(runs)
+
+    def save_history(self):
+        if not self.history_path:
+            return
+        self.history_path.parent.mkdir(parents=True, exist_ok=True)
+        tmp = self.history_path.with_suffix(".tmp")
+        with open(tmp, "w") as f:
+            json.dump({name: list(runs) for name, runs in self.history.items()}, f, indent=2)
+        tmp.replace(self.history_path)
+
+    def start(self):
+        now = datetime.now()
+        for job in self.jobs.values():
+            job.next_run = job.schedule.next_after(now) if job.enabled else None
+        self.thread = threading.Thread(target=self.loop, name="scheduler", daemon=True)
+        self.thread.start()
+        print(f"Scheduler started with {len(self.jobs)} jobs")
+
+    def stop(self):
+        """Stop scheduling and terminate the running job"""
+        self.stopping = True
+        self.wake.set()
+        with self.lock:
+            if self.process:
+                self.process.terminate()
+        if self.thread:
+            self.thread.join(timeout=30)
+
+    def pending(self, name: str) -> bool:
+        return any(queued == name for queued, _ in self.queue) or bool(self.running and self.running["job"] == name)
+
+    def trigger(self, name: str) -> bool:
+        """Queue a job to run now, unless it is already queued or running"""
+        with self.lock:
+            if self.pending(name):
+                return False
+            self.queue.append((name, "manual"))
+        self.wake.set()
+        return True
+
+    def status(self) -> List[Dict]:
+        """Return the schedule and state of every job with its last run"""
+        with self.lock:
+            queued = [name for name, _ in self.queue]
+            return [{
+                "name": job.name,
+                "schedule": job.schedule.expression,
+                "command": job.command,
+                "enabled": job.enabled,
+                "next_run": job.next_run.isoformat(timespec="seconds") if job.next_run else None,
+                "state": ("running" if self.running and self.running["job"] == job.name
+                          else "queued" if job.name in queued else "idle"),
+                "last_run": dict(self.history[job.name][-1]) if self.history[job.name] else None,
+            } for job in self.jobs.values()]
+
+    def runs(self, name: str) -> List[Dict]:
+        """Return the recent runs of a job, latest first"""
+        with self.lock:
+            return [dict(run) for run in reversed(self.history[name])]
+
+    def loop(self):
+        while not self.stopping:
+            now = datetime.now()
+            with self.lock:
+                for job in self.jobs.values():
+                    if job.next_run and job.next_run <= now:
+                        job.next_run = job.schedule.next_after(now)
+                        if not self.pending(job.name):
+                            self.queue.append((job.name, "schedule"))
+                queued = self.queue.pop(0) if self.queue else None
+            if queued:
+                self.run(self.jobs[queued[0]], queued[1])
+                continue
+            self.wake.wait(timeout=self.seconds_to_next_run(now))
+            self.wake.clear()
+
+    def seconds_to_next_run(self, now: datetime) -> float:
+        upcoming = [job.next_run for job in self.jobs.values() if job.next_run]
+        if not upcoming:
+            return 60
+        return max(0.0, min(60.0, (min(upcoming) - now).total_seconds()))
+
+    def run(self, job: Job, trigger: str):
+        started = datetime.now()
+        record = {"job": job.name, "trigger": trigger, "started": started.isoformat(timespec="seconds"),
+                  "finished": None, "duration_seconds": None, "status": "running", "exit_code": None,
+                  "output": ""}
+        with self.lock:
+            self.running = record
+            self.history[job.name].append(record)
+        print(f"Running job '{job.name}' ({trigger})")
+
+        try:
+            with self.lock:
+                self.process = subprocess.Popen(job.argv(), cwd=SCRIPT_DIR, stdout=subprocess.PIPE,
+                                                stderr=subprocess.STDOUT, text=True)
+            output, _ = self.process.communicate(timeout=job.timeout or None)
+            record["exit_code"] = self.process.returncode
+            record["status"] = "succeeded" if self.process.returncode == 0 else "failed"
+        except subprocess.TimeoutExpired:
+            self.process.kill()
+            output, _ = self.process.communicate()
+            record["status"] = "timed_out"
+        except Exception as e:
+            output = str(e)
+            record["status"] = "failed"
+
+        finished = datetime.now()
+        record["output"] = (output or "")[-OUTPUT_TAIL_CHARS:]
+        record["finished"] = finished.isoformat(timespec="seconds")
+        record["duration_seconds"] = round((finished - started).total_seconds(), 1)
+        with self.lock:
+            self.running = None
+            self.process = None
+            self.save_history()
+        print(f"Job '{job.name}' {record['status']} in {record['duration_seconds']}s")
+        if self.on_finish:
+            try:
+                self.on_finish(record)
+            except Exception as e:
+                print(f"Warning: After job '{job.name}': {e}")
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Run connector syncs and re-embedding jobs on cron schedules")
+    parser.add_argument("jobs_file", help="JSON file of the jobs and their schedules")
+    parser.add_argument("--history-file",
+                       help="Where the recent runs of the jobs are kept (default: not kept across restarts)")
+    parser.add_argument("--run", action="append", default=[], dest="run_now",
+                       help="Run this job right away (repeatable)")
+    args = parser.parse_args()
+
+    try:
+        scheduler = Scheduler(load_jobs(args.jobs_file), Path(args.history_file) if args.history_file else None)
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+    for name in args.run_now:
+        if name not in scheduler.jobs:
+            parser.error(f"unknown job '{name}'")
+        scheduler.trigger(name)
+
+    stopped = threading.Event()
+    signal.signal(signal.SIGTERM, lambda signum, frame: stopped.set())
+    signal.signal(signal.SIGINT, lambda signum, frame: stopped.set())
+    scheduler.start()
+    while not stopped.wait(timeout=1):
+        pass
+    scheduler.stop()
+
+
+if __name__ == "__main__":
+    main()
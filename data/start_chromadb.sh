@@ -31,6 +31,8 @@ COLLECTION="pdf_documents"
 HOST="0.0.0.0"
 PORT="8000"
 RELOAD_FLAG=""
+JOBS_ARGS=()
+SERVICE_ARGS=()
 
 # Parse command line arguments
 while [[ $# -gt 0 ]]; do
@@ -55,6 +57,18 @@ while [[ $# -gt 0 ]]; do
             RELOAD_FLAG="--reload"
             shift
             ;;
+        --jobs-file)
+            JOBS_ARGS+=(--jobs-file "$2")
+            shift 2
+            ;;
+        --query-cache-ttl|--query-cache-size|--embedding-model|--tenants-file)
+            SERVICE_ARGS+=("$1" "$2")
+            shift 2
+            ;;
+        --no-bootstrap)
+            SERVICE_ARGS+=("$1")
+            shift
+            ;;
         --help|-h)
             echo "Usage: $0 [OPTIONS]"
             echo "Options:"
@@ -63,6 +77,12 @@ while [[ $# -gt 0 ]]; do
             echo "  --host HOST           Host to bind to (default: 0.0.0.0)"
             echo "  --port, -p PORT       Port to bind to (default: 8000)"
             echo "  --reload              Enable auto-reload for development"
+            echo "  --jobs-file FILE      Run the sync and re-embedding jobs of FILE on schedule"
+            echo "  --query-cache-ttl SEC Seconds query results are cached, 0 disables (default: 60)"
+            echo "  --query-cache-size N  Query results cached at most (default: 1000)"
+            echo "  --embedding-model M   Embedding model of the collections created on startup"
+            echo "  --tenants-file FILE   Also create the collections of the Go service's tenants"
+            echo "  --no-bootstrap        Don't create missing collections"
             echo "  --help, -h            Show this help message"
             exit 0
             ;;
@@ -75,8 +95,8 @@ done
 
 # Check if ChromaDB exists
 if [ ! -d "$DB_PATH" ]; then
-    echo -e "${YELLOW}Warning: ChromaDB not found at $DB_PATH${NC}"
-    echo -e "${YELLOW}Make sure to embed some PDFs first using: python pdf_embedder.py${NC}"
+    echo -e "${YELLOW}Warning: ChromaDB not found at $DB_PATH, starting with an empty collection${NC}"
+    echo -e "${YELLOW}Embed some PDFs using: python pdf_embedder.py${NC}"
 fi
 
 echo -e "${GREEN}Starting ChromaDB service...${NC}"
@@ -97,4 +117,6 @@ python chromadb_service.py \
     --collection "$COLLECTION" \
     --host "$HOST" \
     --port "$PORT" \
+    "${JOBS_ARGS[@]}" \
+    "${SERVICE_ARGS[@]}" \
     $RELOAD_FLAG
\ No newline at end of file
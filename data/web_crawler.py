@@ -0,0 +1,238 @@
+#!/usr/bin/env python3
+"""
+Web Crawler for ChromaDB
+Crawls a public site from seed URLs, within a depth and a domain allowlist and
+following robots.txt, and ingests the main content of its pages, e.g. so
+ChatWithDoc can answer questions about a documentation site.
+"""
+
+import argparse
+import hashlib
+import io
+import sys
+import time
+from collections import deque
+from datetime import datetime, timezone
+from html.parser import HTMLParser
+from typing import Dict, Iterator, List, Optional, Set
+from urllib.parse import urldefrag, urljoin, urlparse
+from urllib.robotparser import RobotFileParser
+
+import requests
+
+from connector_common import (Document, SyncState, add_embedder_args, create_embedder,
+                              extract_main_content, run, sync_documents)
+
+DEFAULT_USER_AGENT = "genai-demo-crawler/1.0"
+
+
+class PageLinks(HTMLParser):
+    """Collects the links, canonical URL and robots directives of a page"""
+
+    def __init__(self):
+        super().__init__()
+        self.links: List[str] = []
+        self.canonical: Optional[str] = None
+        self.robots = ""
+
+    def handle_starttag(self, tag, attrs):
+        attrs = dict(attrs)
+        if tag == "a" and attrs.get("href"):
+            if "nofollow" not in (attrs.get("rel") or ""):
+                self.links.append(attrs["href"])
+        elif tag == "link" and attrs.get("rel") == "canonical" and attrs.get("href"):
+            self.canonical = attrs["href"]
+        elif tag == "meta" and (attrs.get("name") or "").lower() == "robots":
+            self.robots = (attrs.get("content") or "").lower()
+
+
+def normalize_url(url: str) -> Optional[str]:
+    """Return an absolute http(s) URL without its fragment, None otherwise"""
+    url, _ = urldefrag(url)
+    parsed = urlparse(url)
+    if parsed.scheme not in ("http", "https") or not parsed.netloc:
+        return None
+    return parsed._replace(netloc=parsed.netloc.lower(), path=parsed.path or "/").geturl()
+
+
+class PoliteSession:
+    """Fetches pages as robots.txt allows, spacing the requests to each site"""
+
+    def __init__(self, user_agent: str, delay: float):
+        self.user_agent = user_agent
+        self.delay = delay
+        self.session = requests.Session()
+        self.session.headers["User-Agent"] = user_agent
+        self.robots: Dict[str, RobotFileParser] = {}
+        self.last_fetch: Dict[str, float] = {}
+
+    def robots_for(self, url: str) -> RobotFileParser:
+        """Return the robots.txt rules of the URL's site. A missing
+        robots.txt allows everything, an unreachable one nothing."""
+        parsed = urlparse(url)
+        site = f"{parsed.scheme}://{parsed.netloc}"
+        if site not in self.robots:
+            rules = RobotFileParser(site + "/robots.txt")
+            try:
+                response = self.session.get(site + "/robots.txt", timeout=15)
+                if response.status_code >= 500:
+                    rules.disallow_all = True
+                elif response.status_code >= 400:
+                    rules.allow_all = True
+                else:
+                    rules.parse(response.text.splitlines())
+            except requests.RequestException:
+                rules.disallow_all = True
+            self.robots[site] = rules
+        return self.robots[site]
+
+    def wait_politely(self, url: str):
+        """Space requests to a site by the delay, or its robots.txt crawl
+        delay when longer"""
+        host = urlparse(url).netloc
+        delay = max(self.delay, float(self.robots_for(url).crawl_delay(self.user_agent) or 0))
+        elapsed = time.monotonic() - self.last_fetch.get(host, 0)
+        if elapsed < delay:
+            time.sleep(delay - elapsed)
+        self.last_fetch[host] = time.monotonic()
+
+    def allowed(self, url: str) -> bool:
+        return self.robots_for(url).can_fetch(self.user_agent, url)
+
+    def get(self, url: str) -> requests.Response:
+        self.wait_politely(url)
+        return self.session.get(url, timeout=30)
+
+
+class Crawler:
+    """A breadth-first crawl of the pages reachable from seed URLs"""
+
+    def __init__(self, embedder, state: SyncState, seeds: List[str], depth: int, domains: Set[str],
+                 max_pages: int, session: PoliteSession):
+        self.embedder = embedder
+        self.state = state
+        self.seeds = seeds
+        self.depth = depth
+        self.domains = domains
+        self.max_pages = max_pages
+        self.session = session
+        # Extracted text of the pages of the current crawl
+        self.texts: Dict[str, str] = {}
+
+    def allowed_domain(self, url: str) -> bool:
+        host = urlparse(url).hostname or ""
+        return any(host == d or host.endswith("." + d) for d in self.domains)
+
+    def crawl(self) -> Iterator[Document]:
+        self.texts = {}
+        queue = deque((url, 0) for url in filter(None, map(normalize_url, self.seeds)))
+        visited: Set[str] = set()
+        fetched = 0
+
+        while queue and fetched < self.max_pages:
+            url, depth = queue.popleft()
+            if url in visited or not self.allowed_domain(url):
+                continue
+            visited.add(url)
+            if not self.session.allowed(url):
+                print(f"Skipping {url}: disallowed by robots.txt")
+                continue
+
+            fetched += 1
+            try:
+                response = self.session.get(url)
+            except requests.RequestException as e:
+                print(f"Warning: Could not fetch {url}: {e}")
+                yield from self.keep(url)
+                continue
+            if response.status_code >= 500 or response.status_code == 429:
+                print(f"Warning: {url} returned {response.status_code}")
+                yield from self.keep(url)
+                continue
+            if response.status_code >= 400:
+                continue
+
+            final_url = normalize_url(response.url) or url
+            content_type = response.headers.get("Content-Type", "")
+            if "application/pdf" in content_type:
+                text = self.embedder.extract_text_from_pdf(io.BytesIO(response.content))
+                yield from self.page(final_url, text, final_url.rsplit("/", 1)[-1], depth)
+                continue
+            if "html" not in content_type:
+                continue
+
+            links = PageLinks()
+            links.feed(response.text)
+            if depth < self.depth and "nofollow" not in links.robots:
+                for href in links.links:
+                    link = normalize_url(urljoin(final_url, href))
+                    if link and link not in visited:
+                        queue.append((link, depth + 1))
+            if "noindex" in links.robots:
+                continue
+
+            # Pages reachable under several URLs are ingested once
+            source = normalize_url(urljoin(final_url, links.canonical)) if links.canonical else final_url
+            if not source or not self.allowed_domain(source) or source in self.texts:
+                continue
+            title, text = extract_main_content(response.text)
+            yield from self.page(source, text, title, depth)
+
+    def page(self, url: str, text: str, title: str, depth: int) -> Iterator[Document]:
+        if not text:
+            return
+        if title:
+            text = f"# {title}\n\n{text}"
+        self.texts[url] = text
+        # Pages are versioned by their content, so unchanged pages aren't
+        # re-embedded
+        version = hashlib.sha256(text.encode()).hexdigest()[:16]
+        yield url, version, {
+            "title": title,
+            "url": url,
+            "domain": urlparse(url).hostname or "",
+            "depth": depth,
+            "fetched_time": datetime.now(timezone.utc).isoformat(timespec="seconds"),
+        }
+
+    def keep(self, url: str) -> Iterator[Document]:
+        """Keep the chunks of a known page that failed to load this time"""
+        known = self.state.documents.get(url)
+        if known:
+            yield url, known["version"], {}
+
+    def sync(self) -> Dict[str, int]:
+        return sync_documents(self.embedder, self.state, self.crawl(), self.texts.__getitem__)
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Crawl a web site into ChromaDB")
+    parser.add_argument("seeds", nargs="+", help="URLs the crawl starts from")
+    parser.add_argument("--depth", type=int, default=2, help="Links followed from the seeds (default: 2)")
+    parser.add_argument("--allow-domain", action="append", dest="domains",
+                       help="Domain the crawl may visit, with its subdomains (repeatable, default: the seeds' domains)")
+    parser.add_argument("--max-pages", type=int, default=500, help="Pages fetched per crawl (default: 500)")
+    parser.add_argument("--delay", type=float, default=1.0,
+                       help="Seconds between requests to a site, unless robots.txt asks for more (default: 1)")
+    parser.add_argument("--user-agent", default=DEFAULT_USER_AGENT,
+                       help=f"User agent sent and matched against robots.txt (default: {DEFAULT_USER_AGENT})")
+    add_embedder_args(parser)
+    args = parser.parse_args()
+
+    domains = set(args.domains or [urlparse(seed).hostname for seed in args.seeds if urlparse(seed).hostname])
+    try:
+        embedder = create_embedder(parser, args)
+        state = SyncState.for_source(args, embedder, "crawl:" + ",".join(sorted(args.seeds)))
+        crawler = Crawler(embedder, state, args.seeds, args.depth, domains, args.max_pages,
+                          PoliteSession(args.user_agent, args.delay))
+
+        print(f"Crawling {', '.join(args.seeds)} (depth {args.depth}, domains {', '.join(sorted(domains))}) "
+              f"into collection '{embedder.collection_name}'")
+        run(crawler.sync, args.interval)
+    except Exception as e:
+        print(f"Fatal error: {e}")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
@@ -62,6 +62,7 @@ class ChatApp {
             
             // Add assistant response
             this.addMessage('assistant', response.content);
+            this.addUsage(response);
             
         } catch (error) {
             this.hideTypingIndicator();
@@ -131,7 +132,8 @@ class ChatApp {
     }
     
     async callHTTPAPI(endpoint, requestData) {
-        const baseURL = 'http://localhost:8080';
+        // Served by the service at /ui: same origin. Opened as a file: local service.
+        const baseURL = window.location.protocol === 'file:' ? 'http://localhost:8080' : '';
         const url = baseURL + endpoint;
         
         console.log(`🌐 Calling API: ${url}`);
@@ -189,6 +191,66 @@ class ChatApp {
         this.scrollToBottom();
     }
     
+    addUsage(response) {
+        const usage = response.token_usage;
+        const modeLabels = {
+            MODE_CHAT: 'Chat',
+            MODE_TOOL: 'Tool',
+            MODE_AGENT: 'Agent',
+            MODE_DOC: 'RAG',
+        };
+        
+        const usageDiv = document.createElement('div');
+        usageDiv.className = 'usage';
+        
+        if (response.mode) {
+            const badge = document.createElement('span');
+            badge.className = 'badge';
+            badge.textContent = modeLabels[response.mode] || response.mode;
+            usageDiv.appendChild(badge);
+        }
+        if (response.degraded_reason) {
+            const badge = document.createElement('span');
+            badge.className = 'badge degraded';
+            badge.textContent = response.degraded_reason.replace(/_/g, ' ');
+            usageDiv.appendChild(badge);
+        }
+        if (response.finish_reason === 'FINISH_REASON_MAX_TOKENS') {
+            const badge = document.createElement('span');
+            badge.className = 'badge degraded';
+            badge.textContent = 'truncated';
+            usageDiv.appendChild(badge);
+        }
+        if (response.safety_block) {
+            const badge = document.createElement('span');
+            badge.className = 'badge degraded';
+            badge.textContent = 'blocked';
+            badge.title = [response.safety_block.reason, ...(response.safety_block.categories || [])].join(' ');
+            usageDiv.appendChild(badge);
+        }
+        for (const warning of response.warnings || []) {
+            const badge = document.createElement('span');
+            badge.className = 'badge degraded';
+            badge.textContent = warning.replace(/_/g, ' ');
+            usageDiv.appendChild(badge);
+        }
+        
+        if (usage) {
+            let text = `${usage.input_tokens} in / ${usage.output_tokens} out tokens`;
+            if (usage.context_tokens) {
+                text += ` (${usage.context_tokens} from documents)`;
+            }
+            if (response.estimated_cost) {
+                text += ` · ~${response.estimated_cost.amount.toFixed(6)} ${response.estimated_cost.currency}`;
+            }
+            usageDiv.appendChild(document.createTextNode(text));
+        }
+        if (!usageDiv.hasChildNodes()) return;
+        
+        this.messagesContainer.appendChild(usageDiv);
+        this.scrollToBottom();
+    }
+    
     showTypingIndicator(mode = 'chat') {
         this.isLoading = true;
         
@@ -0,0 +1,10 @@
+// Package frontend embeds the static web chat UI so the service can serve it
+// at /ui without shipping the files separately.
+package frontend
+
+import "embed"
+
+// Files holds index.html and chat.js
+//
+//go:embed index.html chat.js
+var Files embed.FS
@@ -0,0 +1,335 @@
+// Package client 是 genai-foundation-demo 聊天服务的 Go SDK，
+// 通过 gRPC 或 HTTP 提供类型化的方法，并统一处理认证和重试。
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"iter"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 服务端识别的请求头 (gRPC metadata 中为小写)
+const (
+	apiKeyHeader        = "X-API-Key"
+	tenantHeader        = "X-Tenant-ID"
+	userIDHeader        = "X-User-ID"
+	priorityHeader      = "X-Priority"
+	authorizationHeader = "Authorization"
+	requestIDHeader     = "X-Request-ID"
+)
+
+// transport 抽象 gRPC 和 HTTP 两种调用方式，错误统一为 gRPC status
+type transport interface {
+	chat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error)
+	submitChat(ctx context.Context, req *genaidemo.SubmitChatRequest) (*genaidemo.Job, error)
+	getJob(ctx context.Context, jobID string) (*genaidemo.Job, error)
+	evaluate(ctx context.Context, req *genaidemo.EvaluateResponseRequest) (*genaidemo.EvaluateResponseResponse, error)
+	getUserMemory(ctx context.Context) (*genaidemo.UserMemory, error)
+	deleteUserMemory(ctx context.Context, factID string) (*genaidemo.DeleteUserMemoryResponse, error)
+}
+
+// Client 聊天服务客户端，可被多个 goroutine 并发使用
+type Client struct {
+	transport transport
+	opts      options
+	// closer 关闭由 Dial 创建的连接
+	closer io.Closer
+}
+
+type options struct {
+	apiKey         string
+	bearerToken    string
+	tenant         string
+	userID         string
+	priority       genaidemo.Priority
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	timeout        time.Duration
+	tlsConfig      *tls.Config
+	insecure       bool
+	httpClient     *http.Client
+}
+
+// Option 配置客户端
+type Option func(*options)
+
+// WithAPIKey 设置随每个请求发送的 API key
+func WithAPIKey(key string) Option {
+	return func(o *options) { o.apiKey = key }
+}
+
+// WithBearerToken 设置随每个请求发送的 Bearer token (例如 JWT)，
+// 以 Authorization 头发送
+func WithBearerToken(token string) Option {
+	return func(o *options) { o.bearerToken = token }
+}
+
+// WithTenant 设置请求所属的租户，以 X-Tenant-ID 头发送。
+// API key 已绑定租户时可以省略，指定其他租户会被拒绝
+func WithTenant(id string) Option {
+	return func(o *options) { o.tenant = id }
+}
+
+// WithUserID 设置请求代表的终端用户，以 X-User-ID 头发送。服务端启用用户记忆时
+// 按用户记住对话中的事实并注入后续请求
+func WithUserID(id string) Option {
+	return func(o *options) { o.userID = id }
+}
+
+// WithTimeout 设置单次调用 (每次重试单独计时) 的超时，调用方 context
+// 已有更早的截止时间时以其为准
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithTLS 设置 TLS 配置，Dial 默认使用系统根证书
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) { o.tlsConfig = cfg }
+}
+
+// WithInsecure 让 Dial 使用不加密的连接，仅用于本地开发
+func WithInsecure() Option {
+	return func(o *options) { o.insecure = true }
+}
+
+// WithPriority 设置请求的默认优先级，请求本身设置的优先级优先
+func WithPriority(p genaidemo.Priority) Option {
+	return func(o *options) { o.priority = p }
+}
+
+// WithRetry 设置服务暂时不可用时的最大尝试次数和初始退避时间，maxAttempts 为 1 时不重试
+func WithRetry(maxAttempts int, initialBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxAttempts = max(maxAttempts, 1)
+		o.initialBackoff = initialBackoff
+	}
+}
+
+// WithHTTPClient 设置 HTTP 传输使用的 http.Client
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.httpClient = c }
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		maxAttempts:    3,
+		initialBackoff: 500 * time.Millisecond,
+		maxBackoff:     10 * time.Second,
+		httpClient:     http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tlsConfig != nil && o.httpClient == http.DefaultClient {
+		o.httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: o.tlsConfig,
+		}}
+	}
+	return o
+}
+
+// NewHTTP 创建通过 HTTP JSON API 访问服务的客户端，baseURL 例如 "http://localhost:8080"
+func NewHTTP(baseURL string, opts ...Option) *Client {
+	o := newOptions(opts)
+	return &Client{
+		transport: newHTTPTransport(baseURL, o),
+		opts:      o,
+	}
+}
+
+// NewGRPC 创建通过 gRPC 访问服务的客户端，conn 的生命周期由调用方管理
+func NewGRPC(conn grpc.ClientConnInterface, opts ...Option) *Client {
+	o := newOptions(opts)
+	return &Client{
+		transport: newGRPCTransport(conn, o),
+		opts:      o,
+	}
+}
+
+// Close 关闭由 Dial 创建的连接，NewHTTP/NewGRPC 创建的客户端无需关闭
+func (c *Client) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// Chat 普通聊天
+func (c *Client) Chat(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return c.chat(ctx, genaidemo.Mode_MODE_CHAT, req)
+}
+
+// ChatWithTool 使用工具 (网络搜索、计算器) 的聊天
+func (c *Client) ChatWithTool(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return c.chat(ctx, genaidemo.Mode_MODE_TOOL, req)
+}
+
+// ChatWithAgent 智能体模式聊天
+func (c *Client) ChatWithAgent(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return c.chat(ctx, genaidemo.Mode_MODE_AGENT, req)
+}
+
+// ChatWithDoc 基于文档检索 (RAG) 的聊天
+func (c *Client) ChatWithDoc(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return c.chat(ctx, genaidemo.Mode_MODE_DOC, req)
+}
+
+// Send 以指定模式发送聊天请求，适用于运行时选择模式的调用方
+func (c *Client) Send(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return c.chat(ctx, mode, req)
+}
+
+// Stream 以迭代器形式返回指定模式的响应片段。服务端目前不支持流式输出，
+// 因此迭代器只产生一个完整响应；服务端支持流式后调用方代码无需修改。
+func (c *Client) Stream(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) iter.Seq2[*genaidemo.ChatResponse, error] {
+	return func(yield func(*genaidemo.ChatResponse, error) bool) {
+		yield(c.chat(ctx, mode, req))
+	}
+}
+
+// SubmitChat 提交异步聊天任务，返回排队中的任务
+func (c *Client) SubmitChat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.Job, error) {
+	var job *genaidemo.Job
+	err := c.do(ctx, func(ctx context.Context) error {
+		var err error
+		job, err = c.transport.submitChat(ctx, &genaidemo.SubmitChatRequest{
+			Mode:    mode,
+			Request: req,
+		})
+		return err
+	})
+	return job, err
+}
+
+// EvaluateResponse 使用评审模型对回复打分 (LLM-as-judge)
+func (c *Client) EvaluateResponse(ctx context.Context, req *genaidemo.EvaluateResponseRequest) (*genaidemo.EvaluateResponseResponse, error) {
+	var resp *genaidemo.EvaluateResponseResponse
+	err := c.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.transport.evaluate(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// GetUserMemory 返回服务端记住的关于 WithUserID 用户的事实
+func (c *Client) GetUserMemory(ctx context.Context) (*genaidemo.UserMemory, error) {
+	var memory *genaidemo.UserMemory
+	err := c.do(ctx, func(ctx context.Context) error {
+		var err error
+		memory, err = c.transport.getUserMemory(ctx)
+		return err
+	})
+	return memory, err
+}
+
+// DeleteUserMemory 删除 WithUserID 用户的一条事实，factID 为空时删除全部，返回删除的条数
+func (c *Client) DeleteUserMemory(ctx context.Context, factID string) (int32, error) {
+	var resp *genaidemo.DeleteUserMemoryResponse
+	err := c.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.transport.deleteUserMemory(ctx, factID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Deleted, nil
+}
+
+// GetJob 查询异步任务的状态和结果
+func (c *Client) GetJob(ctx context.Context, jobID string) (*genaidemo.Job, error) {
+	var job *genaidemo.Job
+	err := c.do(ctx, func(ctx context.Context) error {
+		var err error
+		job, err = c.transport.getJob(ctx, jobID)
+		return err
+	})
+	return job, err
+}
+
+func (c *Client) chat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	var resp *genaidemo.ChatResponse
+	err := c.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.transport.chat(ctx, mode, req)
+		return err
+	})
+	return resp, err
+}
+
+// do 在服务暂时不可用 (过载、熔断) 时按抖动指数退避重试，优先使用服务端的 Retry-After 提示
+func (c *Client) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := c.opts.initialBackoff
+	var err error
+	for attempt := 1; attempt <= c.opts.maxAttempts; attempt++ {
+		err = c.attempt(ctx, fn)
+		if err == nil || !isRetryable(err) || attempt == c.opts.maxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+		if hint := retryAfter(err); hint > 0 {
+			wait = hint
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, c.opts.maxBackoff)
+	}
+	return err
+}
+
+// attempt 按配置的单次超时执行一次调用
+func (c *Client) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if c.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
+
+// isRetryable 只重试服务端明确表示暂时不可用的错误
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrorReason 返回错误的原因 (例如 RATE_LIMITED、PROVIDER_ERROR)，gRPC 和 HTTP 传输均适用，没有时为空
+func ErrorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+// RequestID 返回失败请求的服务端请求 ID，便于与服务端日志关联，没有时为空
+func RequestID(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RequestInfo); ok {
+			return info.GetRequestId()
+		}
+	}
+	return ""
+}
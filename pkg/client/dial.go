@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Dial 创建带 TLS、认证、单次调用超时和重试的 gRPC 客户端，返回的客户端需要 Close。
+// 默认使用系统根证书的 TLS，WithInsecure 用于本地开发。
+func Dial(target string, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+	conn, err := DialConn(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 认证、超时和重试已由连接的凭据和拦截器处理，客户端不再重复
+	transportOpts := o
+	transportOpts.apiKey = ""
+	transportOpts.bearerToken = ""
+	clientOpts := o
+	clientOpts.maxAttempts = 1
+	clientOpts.timeout = 0
+
+	return &Client{
+		transport: newGRPCTransport(conn, transportOpts),
+		opts:      clientOpts,
+		closer:    conn,
+	}, nil
+}
+
+// DialConn 创建已配置 TLS、认证凭据、单次调用超时和重试拦截器的 gRPC 连接，
+// 供直接使用生成的 ChatServiceClient 的调用方使用
+func DialConn(target string, opts ...Option) (*grpc.ClientConn, error) {
+	o := newOptions(opts)
+
+	var transportCreds credentials.TransportCredentials
+	switch {
+	case o.insecure:
+		transportCreds = insecure.NewCredentials()
+	case o.tlsConfig != nil:
+		transportCreds = credentials.NewTLS(o.tlsConfig)
+	default:
+		transportCreds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(transportCreds),
+		grpc.WithChainUnaryInterceptor(
+			retryInterceptor(o),
+			timeoutInterceptor(o.timeout),
+		),
+	}
+	if o.apiKey != "" || o.bearerToken != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&callCredentials{
+			apiKey:      o.apiKey,
+			bearerToken: o.bearerToken,
+			secure:      !o.insecure,
+		}))
+	}
+
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC connection to %s: %w", target, err)
+	}
+	return conn, nil
+}
+
+// callCredentials 以 metadata 发送 API key 和 Bearer token
+type callCredentials struct {
+	apiKey      string
+	bearerToken string
+	secure      bool
+}
+
+func (c *callCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := make(map[string]string, 2)
+	if c.apiKey != "" {
+		md[strings.ToLower(apiKeyHeader)] = c.apiKey
+	}
+	if c.bearerToken != "" {
+		md[strings.ToLower(authorizationHeader)] = "Bearer " + c.bearerToken
+	}
+	return md, nil
+}
+
+// RequireTransportSecurity 除非显式使用 WithInsecure，否则凭据只通过 TLS 发送
+func (c *callCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// timeoutInterceptor 为没有更早截止时间的调用设置超时，每次重试单独计时
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// retryInterceptor 在服务暂时不可用时按抖动指数退避重试，规则与 Client 相同
+func retryInterceptor(o options) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		backoff := o.initialBackoff
+		var err error
+		for attempt := 1; attempt <= o.maxAttempts; attempt++ {
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || !isRetryable(err) || attempt == o.maxAttempts || ctx.Err() != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(time.Duration(rand.Int64N(int64(backoff) + 1))):
+			}
+			backoff = min(backoff*2, o.maxBackoff)
+		}
+		return err
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcTransport 通过生成的 gRPC 客户端调用服务
+type grpcTransport struct {
+	client genaidemo.ChatServiceClient
+	opts   options
+}
+
+func newGRPCTransport(conn grpc.ClientConnInterface, opts options) *grpcTransport {
+	return &grpcTransport{
+		client: genaidemo.NewChatServiceClient(conn),
+		opts:   opts,
+	}
+}
+
+// outgoing 附加认证、租户、用户和优先级 metadata
+func (t *grpcTransport) outgoing(ctx context.Context) context.Context {
+	if t.opts.apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(apiKeyHeader), t.opts.apiKey)
+	}
+	if t.opts.tenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(tenantHeader), t.opts.tenant)
+	}
+	if t.opts.userID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(userIDHeader), t.opts.userID)
+	}
+	if t.opts.bearerToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(authorizationHeader), "Bearer "+t.opts.bearerToken)
+	}
+	if t.opts.priority != genaidemo.Priority_PRIORITY_UNSPECIFIED {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(priorityHeader), t.opts.priority.String())
+	}
+	return ctx
+}
+
+func (t *grpcTransport) chat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	ctx = t.outgoing(ctx)
+	switch mode {
+	case genaidemo.Mode_MODE_CHAT:
+		return t.client.Chat(ctx, req)
+	case genaidemo.Mode_MODE_TOOL:
+		return t.client.ChatWithTool(ctx, req)
+	case genaidemo.Mode_MODE_AGENT:
+		return t.client.ChatWithAgent(ctx, req)
+	case genaidemo.Mode_MODE_DOC:
+		return t.client.ChatWithDoc(ctx, req)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported mode: %s", mode)
+	}
+}
+
+func (t *grpcTransport) submitChat(ctx context.Context, req *genaidemo.SubmitChatRequest) (*genaidemo.Job, error) {
+	return t.client.SubmitChat(t.outgoing(ctx), req)
+}
+
+func (t *grpcTransport) getJob(ctx context.Context, jobID string) (*genaidemo.Job, error) {
+	return t.client.GetJob(t.outgoing(ctx), &genaidemo.GetJobRequest{JobId: jobID})
+}
+
+func (t *grpcTransport) evaluate(ctx context.Context, req *genaidemo.EvaluateResponseRequest) (*genaidemo.EvaluateResponseResponse, error) {
+	return t.client.EvaluateResponse(t.outgoing(ctx), req)
+}
+
+func (t *grpcTransport) getUserMemory(ctx context.Context) (*genaidemo.UserMemory, error) {
+	return t.client.GetUserMemory(t.outgoing(ctx), &genaidemo.GetUserMemoryRequest{})
+}
+
+func (t *grpcTransport) deleteUserMemory(ctx context.Context, factID string) (*genaidemo.DeleteUserMemoryResponse, error) {
+	return t.client.DeleteUserMemory(t.outgoing(ctx), &genaidemo.DeleteUserMemoryRequest{FactId: factID})
+}
@@ -0,0 +1,643 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// 各聊天模式对应的 HTTP 路径
+var chatPaths = map[genaidemo.Mode]string{
+	genaidemo.Mode_MODE_CHAT:  "/api/chat",
+	genaidemo.Mode_MODE_TOOL:  "/api/chat-with-tool",
+	genaidemo.Mode_MODE_AGENT: "/api/chat-with-agent",
+	genaidemo.Mode_MODE_DOC:   "/api/chat-with-doc",
+}
+
+// httpTransport 通过服务的 HTTP JSON API 调用服务
+type httpTransport struct {
+	baseURL string
+	opts    options
+}
+
+func newHTTPTransport(baseURL string, opts options) *httpTransport {
+	return &httpTransport{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		opts:    opts,
+	}
+}
+
+// HTTP API 的 JSON 结构，与服务端 HTTPChatRequest 等类型保持一致
+type httpMessage struct {
+	Role    string     `json:"role"`
+	Content string     `json:"content"`
+	Audio   *httpAudio `json:"audio,omitempty"`
+}
+
+type httpAudio struct {
+	Data     []byte `json:"data"`
+	MimeType string `json:"mime_type"`
+}
+
+type httpChatRequest struct {
+	Messages         []httpMessage     `json:"messages"`
+	Temperature      *float32          `json:"temperature,omitempty"`
+	MaxTokens        *int32            `json:"max_tokens,omitempty"`
+	AudioResponse    *bool             `json:"audio_response,omitempty"`
+	CallbackURL      *string           `json:"callback_url,omitempty"`
+	Template         *string           `json:"template,omitempty"`
+	TemplateVersion  *int32            `json:"template_version,omitempty"`
+	Persona          *string           `json:"persona,omitempty"`
+	FewShot          *bool             `json:"few_shot,omitempty"`
+	Memory           *bool             `json:"memory,omitempty"`
+	KnowledgeGraph   *bool             `json:"knowledge_graph,omitempty"`
+	SessionID        *string           `json:"session_id,omitempty"`
+	ResponseLanguage *string           `json:"response_language,omitempty"`
+	Variables        map[string]string `json:"variables,omitempty"`
+	BypassCache      *bool             `json:"bypass_cache,omitempty"`
+	Priority         string            `json:"priority,omitempty"`
+	Retrieval        *httpRetrieval    `json:"retrieval,omitempty"`
+	TopP             *float32          `json:"top_p,omitempty"`
+	TopK             *int32            `json:"top_k,omitempty"`
+	StopSequences    []string          `json:"stop_sequences,omitempty"`
+	PresencePenalty  *float32          `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32          `json:"frequency_penalty,omitempty"`
+	CandidateCount   *int32            `json:"candidate_count,omitempty"`
+	Seed             *int32            `json:"seed,omitempty"`
+	N                *int32            `json:"n,omitempty"`
+	Logprobs         *bool             `json:"logprobs,omitempty"`
+	TopLogprobs      *int32            `json:"top_logprobs,omitempty"`
+
+	SafetySettings      []*genaidemo.SafetySetting `json:"safety_settings,omitempty"`
+	ProviderCredentials *httpProviderCredentials   `json:"provider_credentials,omitempty"`
+}
+
+type httpProviderCredentials struct {
+	APIKey          string `json:"api_key,omitempty"`
+	Project         string `json:"project,omitempty"`
+	Location        string `json:"location,omitempty"`
+	CredentialsJSON string `json:"credentials_json,omitempty"`
+}
+
+type httpRetrieval struct {
+	TopK              *int32            `json:"top_k,omitempty"`
+	MinRelevance      *float64          `json:"min_relevance,omitempty"`
+	Collection        *string           `json:"collection,omitempty"`
+	Filter            map[string]string `json:"filter,omitempty"`
+	IncludeChunks     *bool             `json:"include_chunks,omitempty"`
+	IncludeHighlights *bool             `json:"include_highlights,omitempty"`
+}
+
+type httpSubmitChatRequest struct {
+	httpChatRequest
+	Mode string `json:"mode"`
+}
+
+type httpChatResponse struct {
+	Content        string                 `json:"content"`
+	Audio          *httpAudio             `json:"audio,omitempty"`
+	TokenUsage     *httpTokenUsage        `json:"token_usage,omitempty"`
+	EstimatedCost  *httpCost              `json:"estimated_cost,omitempty"`
+	PromptTemplate *httpTemplateRef       `json:"prompt_template,omitempty"`
+	Variant        string                 `json:"variant,omitempty"`
+	Rollout        string                 `json:"rollout,omitempty"`
+	Sources        []*httpSource          `json:"sources,omitempty"`
+	Language       string                 `json:"language,omitempty"`
+	Mode           string                 `json:"mode,omitempty"`
+	DegradedReason *string                `json:"degraded_reason,omitempty"`
+	Warnings       []string               `json:"warnings,omitempty"`
+	Candidates     []string               `json:"candidates,omitempty"`
+	Seed           *int32                 `json:"seed,omitempty"`
+	Model          string                 `json:"model,omitempty"`
+	ModelVersion   string                 `json:"model_version,omitempty"`
+	FinishReason   string                 `json:"finish_reason,omitempty"`
+	SafetyBlock    *genaidemo.SafetyBlock `json:"safety_block,omitempty"`
+}
+
+type httpSource struct {
+	ID         string                 `json:"id"`
+	Filename   string                 `json:"filename"`
+	Relevance  float64                `json:"relevance"`
+	Content    string                 `json:"content"`
+	Highlights []*genaidemo.Highlight `json:"highlights,omitempty"`
+}
+
+type httpTemplateRef struct {
+	Name    string `json:"name"`
+	Version int32  `json:"version"`
+}
+
+type httpTokenUsage struct {
+	InputTokens   int32 `json:"input_tokens"`
+	OutputTokens  int32 `json:"output_tokens"`
+	TotalTokens   int32 `json:"total_tokens"`
+	ContextTokens int32 `json:"context_tokens,omitempty"`
+	CachedTokens  int32 `json:"cached_tokens,omitempty"`
+}
+
+type httpCost struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+type httpEvaluateRequest struct {
+	Messages   []httpMessage `json:"messages"`
+	Response   string        `json:"response"`
+	Documents  []string      `json:"documents,omitempty"`
+	Criteria   []string      `json:"criteria,omitempty"`
+	JudgeModel *string       `json:"judge_model,omitempty"`
+}
+
+type httpCriterionScore struct {
+	Criterion string `json:"criterion"`
+	Score     int32  `json:"score"`
+	Reasoning string `json:"reasoning"`
+}
+
+type httpEvaluateResponse struct {
+	Scores        []*httpCriterionScore `json:"scores"`
+	OverallScore  float64               `json:"overall_score"`
+	JudgeModel    string                `json:"judge_model"`
+	TokenUsage    *httpTokenUsage       `json:"token_usage,omitempty"`
+	EstimatedCost *httpCost             `json:"estimated_cost,omitempty"`
+}
+
+type httpJobResponse struct {
+	JobID      string            `json:"job_id"`
+	Status     string            `json:"status"`
+	Response   *httpChatResponse `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  int64             `json:"created_at"`
+	StartedAt  int64             `json:"started_at,omitempty"`
+	FinishedAt int64             `json:"finished_at,omitempty"`
+}
+
+func (t *httpTransport) chat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	path, ok := chatPaths[mode]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported mode: %s", mode)
+	}
+
+	var resp httpChatResponse
+	if err := t.do(ctx, http.MethodPost, path, toHTTPChatRequest(req), &resp); err != nil {
+		return nil, err
+	}
+	return fromHTTPChatResponse(&resp), nil
+}
+
+func (t *httpTransport) submitChat(ctx context.Context, req *genaidemo.SubmitChatRequest) (*genaidemo.Job, error) {
+	body := httpSubmitChatRequest{
+		httpChatRequest: *toHTTPChatRequest(req.GetRequest()),
+		Mode:            req.GetMode().String(),
+	}
+
+	var resp httpJobResponse
+	if err := t.do(ctx, http.MethodPost, "/api/jobs", &body, &resp); err != nil {
+		return nil, err
+	}
+	return fromHTTPJobResponse(&resp), nil
+}
+
+func (t *httpTransport) getJob(ctx context.Context, jobID string) (*genaidemo.Job, error) {
+	var resp httpJobResponse
+	if err := t.do(ctx, http.MethodGet, "/api/jobs/"+url.PathEscape(jobID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return fromHTTPJobResponse(&resp), nil
+}
+
+func (t *httpTransport) evaluate(ctx context.Context, req *genaidemo.EvaluateResponseRequest) (*genaidemo.EvaluateResponseResponse, error) {
+	body := httpEvaluateRequest{
+		Messages:   toHTTPMessages(req.GetMessages()),
+		Response:   req.GetResponse(),
+		Documents:  req.GetDocuments(),
+		Criteria:   req.GetCriteria(),
+		JudgeModel: req.JudgeModel,
+	}
+
+	var resp httpEvaluateResponse
+	if err := t.do(ctx, http.MethodPost, "/api/evaluate", &body, &resp); err != nil {
+		return nil, err
+	}
+
+	out := &genaidemo.EvaluateResponseResponse{
+		Scores:       make([]*genaidemo.CriterionScore, len(resp.Scores)),
+		OverallScore: resp.OverallScore,
+		JudgeModel:   resp.JudgeModel,
+	}
+	for i, s := range resp.Scores {
+		out.Scores[i] = &genaidemo.CriterionScore{
+			Criterion: s.Criterion,
+			Score:     s.Score,
+			Reasoning: s.Reasoning,
+		}
+	}
+	if resp.TokenUsage != nil {
+		out.TokenUsage = &genaidemo.TokenUsage{
+			InputTokenNum:  resp.TokenUsage.InputTokens,
+			OutputTokenNum: resp.TokenUsage.OutputTokens,
+			TotalTokenNum:  resp.TokenUsage.TotalTokens,
+		}
+	}
+	if resp.EstimatedCost != nil {
+		out.EstimatedCost = &genaidemo.Cost{
+			Currency: resp.EstimatedCost.Currency,
+			Amount:   resp.EstimatedCost.Amount,
+		}
+	}
+	return out, nil
+}
+
+type httpUserFact struct {
+	ID        string `json:"id"`
+	Category  string `json:"category"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type httpUserMemory struct {
+	UserID string          `json:"user_id"`
+	Facts  []*httpUserFact `json:"facts"`
+}
+
+type httpDeleteUserMemoryResponse struct {
+	Deleted int32 `json:"deleted"`
+}
+
+func (t *httpTransport) getUserMemory(ctx context.Context) (*genaidemo.UserMemory, error) {
+	var resp httpUserMemory
+	if err := t.do(ctx, http.MethodGet, "/api/memory", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	out := &genaidemo.UserMemory{
+		UserId: resp.UserID,
+		Facts:  make([]*genaidemo.UserFact, len(resp.Facts)),
+	}
+	for i, f := range resp.Facts {
+		out.Facts[i] = &genaidemo.UserFact{
+			Id:        f.ID,
+			Category:  f.Category,
+			Content:   f.Content,
+			CreatedAt: f.CreatedAt,
+			UpdatedAt: f.UpdatedAt,
+		}
+	}
+	return out, nil
+}
+
+func (t *httpTransport) deleteUserMemory(ctx context.Context, factID string) (*genaidemo.DeleteUserMemoryResponse, error) {
+	path := "/api/memory"
+	if factID != "" {
+		path += "/facts/" + url.PathEscape(factID)
+	}
+	var resp httpDeleteUserMemoryResponse
+	if err := t.do(ctx, http.MethodDelete, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &genaidemo.DeleteUserMemoryResponse{Deleted: resp.Deleted}, nil
+}
+
+// do 发送请求并解码 JSON 响应，非 2xx 响应转换为对应的 gRPC status 错误
+func (t *httpTransport) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "failed to encode request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, body)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to create request: %v", err)
+	}
+	if in != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if t.opts.apiKey != "" {
+		httpReq.Header.Set(apiKeyHeader, t.opts.apiKey)
+	}
+	if t.opts.tenant != "" {
+		httpReq.Header.Set(tenantHeader, t.opts.tenant)
+	}
+	if t.opts.userID != "" {
+		httpReq.Header.Set(userIDHeader, t.opts.userID)
+	}
+	if t.opts.bearerToken != "" {
+		httpReq.Header.Set(authorizationHeader, "Bearer "+t.opts.bearerToken)
+	}
+	if t.opts.priority != genaidemo.Priority_PRIORITY_UNSPECIFIED {
+		httpReq.Header.Set(priorityHeader, t.opts.priority.String())
+	}
+
+	httpResp, err := t.opts.httpClient.Do(httpReq)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		return status.Errorf(codes.Unavailable, "request failed: %v", err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to read response: %v", err)
+	}
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		return newHTTPError(httpResp, data)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return status.Errorf(codes.Internal, "failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// 服务端错误响应体中 ErrorInfo 的 domain
+const errorDomain = "genai-foundation-demo"
+
+// httpError 是 HTTP 传输的错误，携带对应的 gRPC status、错误原因、请求 ID 和服务端的 Retry-After 提示
+type httpError struct {
+	code       codes.Code
+	message    string
+	reason     string
+	metadata   map[string]string
+	requestID  string
+	retryAfter time.Duration
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", e.code, e.message)
+}
+
+// GRPCStatus 使 status.Code 能识别 HTTP 错误，并附带与 gRPC 传输相同的 ErrorInfo、RetryInfo 和 RequestInfo
+func (e *httpError) GRPCStatus() *status.Status {
+	s := status.New(e.code, e.message)
+	var details []protoadapt.MessageV1
+	if e.reason != "" {
+		details = append(details, &errdetails.ErrorInfo{Reason: e.reason, Domain: errorDomain, Metadata: e.metadata})
+	}
+	if e.retryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.retryAfter)})
+	}
+	if e.requestID != "" {
+		details = append(details, &errdetails.RequestInfo{RequestId: e.requestID})
+	}
+	if detailed, err := s.WithDetails(details...); err == nil {
+		return detailed
+	}
+	return s
+}
+
+func newHTTPError(resp *http.Response, body []byte) *httpError {
+	e := &httpError{
+		code:    codeFromHTTPStatus(resp.StatusCode),
+		message: strings.TrimSpace(string(body)),
+	}
+
+	// 服务端错误响应为 {"error": "...", "code": "UNAVAILABLE", "reason": "...", ...}，部分错误为纯文本
+	var errResp struct {
+		Error             string            `json:"error"`
+		Code              string            `json:"code"`
+		Reason            string            `json:"reason"`
+		RequestID         string            `json:"request_id"`
+		RetryAfterSeconds int               `json:"retry_after_seconds"`
+		ProviderError     string            `json:"provider_error"`
+		Metadata          map[string]string `json:"metadata"`
+	}
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		e.message = errResp.Error
+		e.reason = errResp.Reason
+		e.requestID = errResp.RequestID
+		e.metadata = errResp.Metadata
+		if errResp.ProviderError != "" {
+			if e.metadata == nil {
+				e.metadata = make(map[string]string)
+			}
+			e.metadata["provider_error"] = errResp.ProviderError
+		}
+		// code 比 HTTP 状态码更精确 (例如 504 对应 DEADLINE_EXCEEDED)
+		var code codes.Code
+		if errResp.Code != "" && code.UnmarshalJSON([]byte(strconv.Quote(errResp.Code))) == nil {
+			e.code = code
+		}
+		if errResp.RetryAfterSeconds > 0 {
+			e.retryAfter = time.Duration(errResp.RetryAfterSeconds) * time.Second
+		}
+	}
+	if e.message == "" {
+		e.message = resp.Status
+	}
+	if e.requestID == "" {
+		e.requestID = resp.Header.Get(requestIDHeader)
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// codeFromHTTPStatus 是服务端 httpStatusFromError 的逆映射
+func codeFromHTTPStatus(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	case http.StatusNotImplemented, http.StatusMethodNotAllowed:
+		return codes.Unimplemented
+	case http.StatusInternalServerError:
+		return codes.Internal
+	default:
+		return codes.Unknown
+	}
+}
+
+// retryAfter 返回服务端建议的重试等待时间 (HTTP 的 Retry-After 或 gRPC 的 RetryInfo)，没有提示时为 0
+func retryAfter(err error) time.Duration {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return httpErr.retryAfter
+	}
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	return 0
+}
+
+func toHTTPChatRequest(req *genaidemo.ChatRequest) *httpChatRequest {
+	out := &httpChatRequest{
+		Messages:         toHTTPMessages(req.GetMessages()),
+		Temperature:      req.Temperature,
+		MaxTokens:        req.MaxTokens,
+		AudioResponse:    req.AudioResponse,
+		CallbackURL:      req.CallbackUrl,
+		Template:         req.Template,
+		TemplateVersion:  req.TemplateVersion,
+		Persona:          req.Persona,
+		FewShot:          req.FewShot,
+		Memory:           req.Memory,
+		KnowledgeGraph:   req.KnowledgeGraph,
+		SessionID:        req.SessionId,
+		ResponseLanguage: req.ResponseLanguage,
+		Variables:        req.GetVariables(),
+		BypassCache:      req.BypassCache,
+		TopP:             req.TopP,
+		TopK:             req.TopK,
+		StopSequences:    req.GetStopSequences(),
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		CandidateCount:   req.CandidateCount,
+		Seed:             req.Seed,
+		N:                req.N,
+		Logprobs:         req.Logprobs,
+		TopLogprobs:      req.TopLogprobs,
+		SafetySettings:   req.GetSafetySettings(),
+	}
+	if req.GetPriority() != genaidemo.Priority_PRIORITY_UNSPECIFIED {
+		out.Priority = req.GetPriority().String()
+	}
+	if opts := req.GetRetrieval(); opts != nil {
+		out.Retrieval = &httpRetrieval{
+			TopK:              opts.TopK,
+			MinRelevance:      opts.MinRelevance,
+			Collection:        opts.Collection,
+			Filter:            opts.GetFilter(),
+			IncludeChunks:     opts.IncludeChunks,
+			IncludeHighlights: opts.IncludeHighlights,
+		}
+	}
+	if creds := req.GetProviderCredentials(); creds != nil {
+		out.ProviderCredentials = &httpProviderCredentials{
+			APIKey:          creds.GetApiKey(),
+			Project:         creds.GetProject(),
+			Location:        creds.GetLocation(),
+			CredentialsJSON: creds.GetCredentialsJson(),
+		}
+	}
+	return out
+}
+
+func toHTTPMessages(messages []*genaidemo.Message) []httpMessage {
+	out := make([]httpMessage, 0, len(messages))
+	for _, msg := range messages {
+		out = append(out, httpMessage{
+			Role:    msg.GetRole().String(),
+			Content: msg.GetContent(),
+			Audio:   toHTTPAudio(msg.GetAudio()),
+		})
+	}
+	return out
+}
+
+func toHTTPAudio(audio *genaidemo.Audio) *httpAudio {
+	if audio == nil {
+		return nil
+	}
+	return &httpAudio{Data: audio.GetData(), MimeType: audio.GetMimeType()}
+}
+
+func fromHTTPAudio(audio *httpAudio) *genaidemo.Audio {
+	if audio == nil {
+		return nil
+	}
+	return &genaidemo.Audio{Data: audio.Data, MimeType: audio.MimeType}
+}
+
+func fromHTTPChatResponse(resp *httpChatResponse) *genaidemo.ChatResponse {
+	out := &genaidemo.ChatResponse{
+		Content: resp.Content,
+		Audio:   fromHTTPAudio(resp.Audio),
+	}
+	if resp.TokenUsage != nil {
+		out.TokenUsage = &genaidemo.TokenUsage{
+			InputTokenNum:   resp.TokenUsage.InputTokens,
+			OutputTokenNum:  resp.TokenUsage.OutputTokens,
+			TotalTokenNum:   resp.TokenUsage.TotalTokens,
+			ContextTokenNum: resp.TokenUsage.ContextTokens,
+			CachedTokenNum:  resp.TokenUsage.CachedTokens,
+		}
+	}
+	if resp.EstimatedCost != nil {
+		out.EstimatedCost = &genaidemo.Cost{
+			Currency: resp.EstimatedCost.Currency,
+			Amount:   resp.EstimatedCost.Amount,
+		}
+	}
+	if resp.PromptTemplate != nil {
+		out.PromptTemplate = &genaidemo.PromptTemplateRef{
+			Name:    resp.PromptTemplate.Name,
+			Version: resp.PromptTemplate.Version,
+		}
+	}
+	out.Variant = resp.Variant
+	out.Rollout = resp.Rollout
+	out.Language = resp.Language
+	out.Mode = genaidemo.Mode(genaidemo.Mode_value[resp.Mode])
+	out.DegradedReason = resp.DegradedReason
+	out.Warnings = resp.Warnings
+	out.Candidates = resp.Candidates
+	out.Seed = resp.Seed
+	out.Model = resp.Model
+	out.ModelVersion = resp.ModelVersion
+	out.FinishReason = genaidemo.FinishReason(genaidemo.FinishReason_value[resp.FinishReason])
+	out.SafetyBlock = resp.SafetyBlock
+	for _, source := range resp.Sources {
+		out.Sources = append(out.Sources, &genaidemo.RetrievedDocument{
+			Id:         source.ID,
+			Filename:   source.Filename,
+			Relevance:  source.Relevance,
+			Content:    source.Content,
+			Highlights: source.Highlights,
+		})
+	}
+	return out
+}
+
+func fromHTTPJobResponse(resp *httpJobResponse) *genaidemo.Job {
+	out := &genaidemo.Job{
+		JobId:      resp.JobID,
+		Status:     genaidemo.JobStatus(genaidemo.JobStatus_value[resp.Status]),
+		Error:      resp.Error,
+		CreatedAt:  resp.CreatedAt,
+		StartedAt:  resp.StartedAt,
+		FinishedAt: resp.FinishedAt,
+	}
+	if resp.Response != nil {
+		out.Response = fromHTTPChatResponse(resp.Response)
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+// Package eval 对 GenAI 服务运行提示词数据集：检查回复是否满足预期属性、
+// 使用评审模型打分，并与基线报告比较以发现回归。
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// modes 数据集和命令行中可用的聊天模式名称
+var modes = map[string]genaidemo.Mode{
+	"chat":  genaidemo.Mode_MODE_CHAT,
+	"tool":  genaidemo.Mode_MODE_TOOL,
+	"agent": genaidemo.Mode_MODE_AGENT,
+	"doc":   genaidemo.Mode_MODE_DOC,
+}
+
+// ParseMode 将 chat、tool、agent、doc 转换为聊天模式
+func ParseMode(name string) (genaidemo.Mode, bool) {
+	mode, ok := modes[name]
+	return mode, ok
+}
+
+// Item 数据集 (JSONL) 中的一行。prompt 和 messages 至少设置一个，
+// mode 和 criteria 未设置时使用 Runner 的默认值
+type Item struct {
+	ID        string        `json:"id"`
+	Prompt    string        `json:"prompt,omitempty"`
+	Messages  []Message     `json:"messages,omitempty"`
+	Mode      string        `json:"mode,omitempty"`
+	Documents []string      `json:"documents,omitempty"`
+	Criteria  []string      `json:"criteria,omitempty"`
+	Expect    *Expectations `json:"expect,omitempty"`
+	// RelevantDocuments 与查询相关的文档标注 (文档 id 或文件名)，用于 RAG 检索指标
+	RelevantDocuments []string `json:"relevant_documents,omitempty"`
+}
+
+// Message 对话中的一条消息，role 为 ROLE_USER、ROLE_ASSISTANT 或 ROLE_SYSTEM
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Expectations 回复应满足的属性，未设置的属性不检查
+type Expectations struct {
+	// Contains 回复必须包含的文本 (不区分大小写)
+	Contains []string `json:"contains,omitempty"`
+	// NotContains 回复不得包含的文本 (不区分大小写)
+	NotContains []string `json:"not_contains,omitempty"`
+	// Matches 回复必须匹配的正则表达式
+	Matches string `json:"matches,omitempty"`
+	// MaxOutputTokens 输出 token 数上限
+	MaxOutputTokens int32 `json:"max_output_tokens,omitempty"`
+	// MaxLatencyMs 聊天请求耗时上限 (毫秒)
+	MaxLatencyMs int64 `json:"max_latency_ms,omitempty"`
+	// MinScores 评审得分下限，键为评估维度，"overall" 表示平均分
+	MinScores map[string]float64 `json:"min_scores,omitempty"`
+
+	matches *regexp.Regexp
+}
+
+// LoadDataset 读取 JSONL 数据集，跳过空行
+func LoadDataset(path string) ([]*Item, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dataset: %w", err)
+	}
+	defer f.Close()
+
+	var items []*Item
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var item Item
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, fmt.Errorf("dataset line %d: %w", line, err)
+		}
+		if err := item.validate(); err != nil {
+			return nil, fmt.Errorf("dataset line %d: %w", line, err)
+		}
+		if item.ID == "" {
+			item.ID = fmt.Sprintf("line-%d", line)
+		}
+		// 与基线比较时按 id 对应数据项
+		if ids[item.ID] {
+			return nil, fmt.Errorf("dataset line %d: duplicate id %q", line, item.ID)
+		}
+		ids[item.ID] = true
+		items = append(items, &item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dataset: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("dataset %s is empty", path)
+	}
+	return items, nil
+}
+
+// validate 检查数据项并编译正则表达式
+func (item *Item) validate() error {
+	if item.Prompt == "" && len(item.Messages) == 0 {
+		return fmt.Errorf("prompt or messages must be set")
+	}
+	if item.Mode != "" {
+		if _, ok := modes[item.Mode]; !ok {
+			return fmt.Errorf("unknown mode %q", item.Mode)
+		}
+	}
+	if item.Expect != nil && item.Expect.Matches != "" {
+		re, err := regexp.Compile(item.Expect.Matches)
+		if err != nil {
+			return fmt.Errorf("invalid matches pattern: %w", err)
+		}
+		item.Expect.matches = re
+	}
+	return nil
+}
+
+// conversation 返回发送给服务的消息，prompt 作为最后一条用户消息
+func (item *Item) conversation() []*genaidemo.Message {
+	messages := make([]*genaidemo.Message, 0, len(item.Messages)+1)
+	for _, m := range item.Messages {
+		messages = append(messages, &genaidemo.Message{
+			Role:    genaidemo.Role(genaidemo.Role_value[m.Role]),
+			Content: m.Content,
+		})
+	}
+	if item.Prompt != "" {
+		messages = append(messages, &genaidemo.Message{Role: genaidemo.Role_ROLE_USER, Content: item.Prompt})
+	}
+	return messages
+}
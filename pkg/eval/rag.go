@@ -0,0 +1,111 @@
+package eval
+
+import (
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// faithfulnessCriterion 评审模型判断回复是否忠实于检索到的文档
+const faithfulnessCriterion = "faithfulness"
+
+// Retrieval 一个数据项的检索指标，只在数据项标注了相关文档时计算
+type Retrieval struct {
+	// Retrieved 检索到的文档 (id，缺失时为文件名)，按相关度排序
+	Retrieved []string `json:"retrieved"`
+	// K 检索到的文档数
+	K int `json:"k"`
+	// RecallAtK 标注的相关文档中被检索到的比例
+	RecallAtK float64 `json:"recall_at_k"`
+	// ContextPrecision 检索结果的排序精度：每个相关文档所在位置的 precision@i 的平均值，
+	// 相关文档排得越靠前越高，没有检索到相关文档时为 0
+	ContextPrecision float64 `json:"context_precision"`
+}
+
+// RAGSummary 一次运行的 RAG 指标平均值
+type RAGSummary struct {
+	// Items 计算了检索指标的数据项数
+	Items            int     `json:"items"`
+	RecallAtK        float64 `json:"recall_at_k"`
+	ContextPrecision float64 `json:"context_precision"`
+	// Faithfulness 评审模型给出的忠实度平均分 (1-5)，未评审时为 0
+	Faithfulness float64 `json:"faithfulness"`
+}
+
+// measureRetrieval 将检索结果与标注的相关文档比较。文档按 id 或文件名匹配
+func measureRetrieval(sources []*genaidemo.RetrievedDocument, relevant []string) *Retrieval {
+	labels := make(map[string]bool, len(relevant))
+	for _, label := range relevant {
+		labels[strings.ToLower(label)] = true
+	}
+
+	r := &Retrieval{K: len(sources)}
+	found := make(map[string]bool)
+	hits := 0
+	var precisionSum float64
+	for i, source := range sources {
+		r.Retrieved = append(r.Retrieved, sourceName(source))
+
+		label := ""
+		for _, key := range []string{source.GetId(), source.GetFilename()} {
+			if key != "" && labels[strings.ToLower(key)] {
+				label = strings.ToLower(key)
+				break
+			}
+		}
+		if label == "" {
+			continue
+		}
+		// 同一文件的多个分块都算相关，但召回率按标注计数
+		found[label] = true
+		hits++
+		precisionSum += float64(hits) / float64(i+1)
+	}
+
+	if len(labels) > 0 {
+		r.RecallAtK = float64(len(found)) / float64(len(labels))
+	}
+	if hits > 0 {
+		r.ContextPrecision = precisionSum / float64(hits)
+	}
+	return r
+}
+
+// sourceName 返回检索文档在报告中的名称
+func sourceName(source *genaidemo.RetrievedDocument) string {
+	if source.GetId() != "" {
+		return source.GetId()
+	}
+	return source.GetFilename()
+}
+
+// summarizeRAG 计算 RAG 指标的平均值，没有数据项计算检索指标时返回 nil
+func summarizeRAG(results []*Result) *RAGSummary {
+	s := &RAGSummary{}
+	faithful := 0
+	for _, res := range results {
+		if res.Error != "" {
+			continue
+		}
+		if res.Retrieval != nil {
+			s.Items++
+			s.RecallAtK += res.Retrieval.RecallAtK
+			s.ContextPrecision += res.Retrieval.ContextPrecision
+		}
+		if score, ok := res.score(faithfulnessCriterion); ok {
+			faithful++
+			s.Faithfulness += score
+		}
+	}
+	if s.Items == 0 && faithful == 0 {
+		return nil
+	}
+	if s.Items > 0 {
+		s.RecallAtK /= float64(s.Items)
+		s.ContextPrecision /= float64(s.Items)
+	}
+	if faithful > 0 {
+		s.Faithfulness /= float64(faithful)
+	}
+	return s
+}
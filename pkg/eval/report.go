@@ -0,0 +1,210 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Result 一个数据项的运行结果
+type Result struct {
+	ID             string   `json:"id"`
+	Mode           string   `json:"mode"`
+	Prompt         string   `json:"prompt"`
+	Response       string   `json:"response,omitempty"`
+	PromptTemplate string   `json:"prompt_template,omitempty"`
+	Variant        string   `json:"variant,omitempty"`
+	Scores         []*Score `json:"scores,omitempty"`
+	Overall        float64  `json:"overall,omitempty"`
+	// Retrieval 检索指标，只在 RAG 模式且数据项标注了相关文档时设置
+	Retrieval *Retrieval `json:"retrieval,omitempty"`
+	Checks    []*Check   `json:"checks,omitempty"`
+	// Passed 请求成功且所有预期属性都满足
+	Passed       bool    `json:"passed"`
+	OutputTokens int32   `json:"output_tokens"`
+	LatencyMs    int64   `json:"latency_ms"`
+	Cost         float64 `json:"cost"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// Score 评审模型在一个维度上的打分
+type Score struct {
+	Criterion string `json:"criterion"`
+	Score     int32  `json:"score"`
+	Reasoning string `json:"reasoning"`
+}
+
+// Check 一个预期属性的检查结果
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// score 返回某个维度的得分，"overall" 返回平均分
+func (r *Result) score(criterion string) (float64, bool) {
+	if criterion == "overall" {
+		return r.Overall, len(r.Scores) > 0
+	}
+	for _, s := range r.Scores {
+		if strings.EqualFold(s.Criterion, criterion) {
+			return float64(s.Score), true
+		}
+	}
+	return 0, false
+}
+
+// Report 一次运行的报告，也用作回归比较的基线
+type Report struct {
+	Label           string    `json:"label,omitempty"`
+	Dataset         string    `json:"dataset"`
+	StartedAt       time.Time `json:"started_at"`
+	Duration        string    `json:"duration"`
+	Template        string    `json:"template,omitempty"`
+	TemplateVersion int32     `json:"template_version,omitempty"`
+	Seed            *int32    `json:"seed,omitempty"`
+	JudgeModel      string    `json:"judge_model,omitempty"`
+	Items           int       `json:"items"`
+	Passed          int       `json:"passed"`
+	Failed          int       `json:"failed"`
+	PassRate        float64   `json:"pass_rate"`
+	// Overall 和 Criteria 是评审得分的平均值，未评审时为 0 和空
+	Overall  float64            `json:"overall"`
+	Criteria map[string]float64 `json:"criteria"`
+	// RAG 检索和忠实度指标的平均值，RAG 模式之外为空
+	RAG     *RAGSummary `json:"rag,omitempty"`
+	Cost    float64     `json:"cost"`
+	Results []*Result   `json:"results"`
+}
+
+// Summarize 汇总各数据项的结果。评审平均分只统计成功完成的数据项
+func Summarize(results []*Result) *Report {
+	r := &Report{
+		Items:    len(results),
+		Criteria: make(map[string]float64),
+		Results:  results,
+	}
+
+	counts := make(map[string]int)
+	scored := 0
+	for _, res := range results {
+		r.Cost += res.Cost
+		if res.Passed {
+			r.Passed++
+		} else {
+			r.Failed++
+		}
+		if res.Error != "" || len(res.Scores) == 0 {
+			continue
+		}
+		scored++
+		r.Overall += res.Overall
+		for _, s := range res.Scores {
+			r.Criteria[s.Criterion] += float64(s.Score)
+			counts[s.Criterion]++
+		}
+	}
+	if r.Items > 0 {
+		r.PassRate = float64(r.Passed) / float64(r.Items)
+	}
+	if scored > 0 {
+		r.Overall /= float64(scored)
+	}
+	for criterion, total := range r.Criteria {
+		r.Criteria[criterion] = total / float64(counts[criterion])
+	}
+	r.RAG = summarizeRAG(results)
+	return r
+}
+
+// Write 将报告写为缩进的 JSON 文件
+func (r *Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadReport 读取之前写入的报告，例如作为基线
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// Thresholds 判定回归时允许的下降幅度
+type Thresholds struct {
+	// Score 评审平均分 (1-5 分制) 允许下降的分数
+	Score float64
+	// PassRate 通过率允许下降的比例 (0-1)
+	PassRate float64
+	// Retrieval recall@k 和 context precision 允许下降的比例 (0-1)
+	Retrieval float64
+}
+
+// Regression 超出阈值的指标下降
+type Regression struct {
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s dropped from %.3f to %.3f", r.Metric, r.Baseline, r.Current)
+}
+
+// Compare 将本次报告与基线比较，返回超出阈值的回归。
+// 只比较两份报告都有的指标，例如未评审的运行不比较得分
+func Compare(baseline, current *Report, t Thresholds) []Regression {
+	var regressions []Regression
+	compare := func(metric string, base, cur, threshold float64) {
+		if base-cur > threshold {
+			regressions = append(regressions, Regression{Metric: metric, Baseline: base, Current: cur})
+		}
+	}
+
+	compare("pass_rate", baseline.PassRate, current.PassRate, t.PassRate)
+	if len(baseline.Criteria) > 0 && len(current.Criteria) > 0 {
+		compare("overall", baseline.Overall, current.Overall, t.Score)
+	}
+	criteria := make([]string, 0, len(baseline.Criteria))
+	for criterion := range baseline.Criteria {
+		criteria = append(criteria, criterion)
+	}
+	slices.Sort(criteria)
+	for _, criterion := range criteria {
+		if cur, ok := current.Criteria[criterion]; ok {
+			compare(criterion, baseline.Criteria[criterion], cur, t.Score)
+		}
+	}
+	if baseline.RAG != nil && current.RAG != nil && baseline.RAG.Items > 0 && current.RAG.Items > 0 {
+		compare("recall_at_k", baseline.RAG.RecallAtK, current.RAG.RecallAtK, t.Retrieval)
+		compare("context_precision", baseline.RAG.ContextPrecision, current.RAG.ContextPrecision, t.Retrieval)
+	}
+	return regressions
+}
+
+// NewFailures 返回基线中通过、本次失败的数据项 id
+func NewFailures(baseline, current *Report) []string {
+	passed := make(map[string]bool, len(baseline.Results))
+	for _, res := range baseline.Results {
+		passed[res.ID] = res.Passed
+	}
+	var ids []string
+	for _, res := range current.Results {
+		if passed[res.ID] && !res.Passed {
+			ids = append(ids, res.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,246 @@
+package eval
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/client"
+)
+
+// Runner 将数据集发送到服务并检查每个回复
+type Runner struct {
+	Client *client.Client
+	// Mode 数据项未指定模式时使用的模式名称，默认 chat
+	Mode string
+	// Template 和 TemplateVersion 应用于每个请求的服务端提示词模板
+	Template        string
+	TemplateVersion int32
+	// Seed 随每个请求发送，使支持的 provider 生成可复现的回复，为 nil 时不发送
+	Seed *int32
+	// Judge 为 true 时使用 EvaluateResponse 为回复打分
+	Judge      bool
+	JudgeModel string
+	// Criteria 数据项未指定评估维度时使用的维度，为空时使用服务端默认值
+	Criteria []string
+	// RAG 为 true 时默认使用 doc 模式，计算检索指标 (recall@k、context precision)，
+	// 并在评审时以检索到的文档评估 faithfulness
+	RAG bool
+	// Timeout 每个数据项 (聊天和评审调用) 的超时，0 表示不限制
+	Timeout time.Duration
+	// Concurrency 并发处理的数据项数量，默认 1
+	Concurrency int
+	// Progress 每完成一个数据项时调用，可以为 nil
+	Progress func(*Result)
+}
+
+// Run 处理所有数据项并返回报告。ctx 取消后未开始的数据项不会出现在报告中
+func (r *Runner) Run(ctx context.Context, items []*Item) *Report {
+	start := time.Now()
+	results := make([]*Result, len(items))
+
+	var mu sync.Mutex
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range max(r.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				result := r.runItem(ctx, items[i])
+				results[i] = result
+				if r.Progress != nil {
+					mu.Lock()
+					r.Progress(result)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var done []*Result
+	for _, result := range results {
+		if result != nil {
+			done = append(done, result)
+		}
+	}
+	report := Summarize(done)
+	report.StartedAt = start
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	report.Template = r.Template
+	report.TemplateVersion = r.TemplateVersion
+	report.Seed = r.Seed
+	report.JudgeModel = r.JudgeModel
+	return report
+}
+
+// runItem 发送一个数据项，按需评审并检查预期属性
+func (r *Runner) runItem(ctx context.Context, item *Item) *Result {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	defaultMode := cmp.Or(r.Mode, "chat")
+	if r.RAG {
+		defaultMode = "doc"
+	}
+	modeName := cmp.Or(item.Mode, defaultMode)
+	messages := item.conversation()
+	result := &Result{
+		ID:     item.ID,
+		Mode:   modeName,
+		Prompt: messages[len(messages)-1].Content,
+	}
+	mode, ok := ParseMode(modeName)
+	if !ok {
+		result.Error = fmt.Sprintf("unknown mode %q", modeName)
+		return result
+	}
+
+	// 缓存的回复会掩盖被测试的改动
+	bypassCache := true
+	req := &genaidemo.ChatRequest{
+		Messages:    messages,
+		BypassCache: &bypassCache,
+	}
+	if r.Template != "" {
+		req.Template = &r.Template
+	}
+	if r.TemplateVersion > 0 {
+		req.TemplateVersion = &r.TemplateVersion
+	}
+	req.Seed = r.Seed
+
+	start := time.Now()
+	resp, err := r.Client.Send(ctx, mode, req)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = "chat: " + err.Error()
+		return result
+	}
+	result.Response = resp.GetContent()
+	result.OutputTokens = resp.GetTokenUsage().GetOutputTokenNum()
+	result.Variant = resp.GetVariant()
+	result.Cost = resp.GetEstimatedCost().GetAmount()
+	if ref := resp.GetPromptTemplate(); ref != nil {
+		result.PromptTemplate = fmt.Sprintf("%s@v%d", ref.GetName(), ref.GetVersion())
+	}
+	if r.RAG && len(item.RelevantDocuments) > 0 {
+		result.Retrieval = measureRetrieval(resp.GetSources(), item.RelevantDocuments)
+	}
+
+	if r.Judge {
+		if err := r.judge(ctx, item, messages, resp.GetSources(), result); err != nil {
+			result.Error = "evaluate: " + err.Error()
+			return result
+		}
+	}
+
+	result.Checks = check(item.Expect, result)
+	result.Passed = true
+	for _, c := range result.Checks {
+		result.Passed = result.Passed && c.Passed
+	}
+	return result
+}
+
+// judge 使用评审模型为回复打分。RAG 模式下数据项未提供文档时，
+// 以检索到的文档为依据，并加入 faithfulness 维度
+func (r *Runner) judge(ctx context.Context, item *Item, messages []*genaidemo.Message, sources []*genaidemo.RetrievedDocument, result *Result) error {
+	req := &genaidemo.EvaluateResponseRequest{
+		Messages:  messages,
+		Response:  result.Response,
+		Documents: item.Documents,
+		Criteria:  item.Criteria,
+	}
+	if len(req.Criteria) == 0 {
+		req.Criteria = r.Criteria
+	}
+	if r.RAG {
+		if len(req.Documents) == 0 {
+			for _, source := range sources {
+				req.Documents = append(req.Documents, source.GetContent())
+			}
+		}
+		if len(req.Criteria) == 0 {
+			req.Criteria = []string{faithfulnessCriterion}
+		} else if !slices.Contains(req.Criteria, faithfulnessCriterion) {
+			req.Criteria = append(slices.Clone(req.Criteria), faithfulnessCriterion)
+		}
+	}
+	if r.JudgeModel != "" {
+		req.JudgeModel = &r.JudgeModel
+	}
+
+	evaluation, err := r.Client.EvaluateResponse(ctx, req)
+	if err != nil {
+		return err
+	}
+	for _, s := range evaluation.GetScores() {
+		result.Scores = append(result.Scores, &Score{
+			Criterion: s.GetCriterion(),
+			Score:     s.GetScore(),
+			Reasoning: s.GetReasoning(),
+		})
+	}
+	result.Overall = evaluation.GetOverallScore()
+	result.Cost += evaluation.GetEstimatedCost().GetAmount()
+	return nil
+}
+
+// check 检查回复是否满足预期属性
+func check(expect *Expectations, result *Result) []*Check {
+	if expect == nil {
+		return nil
+	}
+
+	var checks []*Check
+	add := func(name string, passed bool, detail string) {
+		checks = append(checks, &Check{Name: name, Passed: passed, Detail: detail})
+	}
+
+	response := strings.ToLower(result.Response)
+	for _, s := range expect.Contains {
+		add("contains", strings.Contains(response, strings.ToLower(s)), fmt.Sprintf("%q", s))
+	}
+	for _, s := range expect.NotContains {
+		add("not_contains", !strings.Contains(response, strings.ToLower(s)), fmt.Sprintf("%q", s))
+	}
+	if expect.matches != nil {
+		add("matches", expect.matches.MatchString(result.Response), expect.Matches)
+	}
+	if expect.MaxOutputTokens > 0 {
+		add("max_output_tokens", result.OutputTokens <= expect.MaxOutputTokens,
+			fmt.Sprintf("%d <= %d", result.OutputTokens, expect.MaxOutputTokens))
+	}
+	if expect.MaxLatencyMs > 0 {
+		add("max_latency_ms", result.LatencyMs <= expect.MaxLatencyMs,
+			fmt.Sprintf("%d <= %d", result.LatencyMs, expect.MaxLatencyMs))
+	}
+	for _, criterion := range slices.Sorted(maps.Keys(expect.MinScores)) {
+		threshold := expect.MinScores[criterion]
+		score, ok := result.score(criterion)
+		if !ok {
+			add("min_score", false, fmt.Sprintf("%s not scored", criterion))
+			continue
+		}
+		add("min_score", score >= threshold, fmt.Sprintf("%s %.2f >= %.2f", criterion, score, threshold))
+	}
+	return checks
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 对话分析的标签取值。不在列表中的主题记为 TopicOther
+const (
+	TopicOther = "other"
+
+	SentimentPositive = "positive"
+	SentimentNeutral  = "neutral"
+	SentimentNegative = "negative"
+
+	ResolutionResolved   = "resolved"
+	ResolutionPartial    = "partial"
+	ResolutionUnresolved = "unresolved"
+)
+
+// analyticsPrompt 对话分类的系统提示词，%s 为可选主题列表，要求只输出 JSON
+const analyticsPrompt = `You classify conversations between users and an AI assistant for product analytics.
+For each numbered conversation, give:
+- topic: what the user asks about, exactly one of: %s
+- sentiment: the user's sentiment, one of positive, neutral, negative
+- resolution: whether the reply answers the request, one of resolved, partial, unresolved
+Respond with JSON only, without any other text, in the form:
+{"labels": [{"index": 1, "topic": "<topic>", "sentiment": "<sentiment>", "resolution": "<resolution>"}]}`
+
+// ConversationSample 待分类的一次对话：用户的最后一条消息和助手的回复
+type ConversationSample struct {
+	Query string
+	Reply string
+}
+
+// ConversationLabels 对话的分类结果，未能分类时各字段为空
+type ConversationLabels struct {
+	Topic      string `json:"topic"`
+	Sentiment  string `json:"sentiment"`
+	Resolution string `json:"resolution"`
+}
+
+// ClassifyConversations 在一次调用中按主题、情绪和解决情况对多个对话分类。
+// 返回与 samples 一一对应的标签，以及分类调用的 token 用量。
+func (p *Processor) ClassifyConversations(ctx context.Context, samples []ConversationSample, topics []string) ([]ConversationLabels, *TokenUsage, error) {
+	if len(samples) == 0 {
+		return nil, nil, status.Error(codes.InvalidArgument, "samples cannot be empty")
+	}
+
+	var b strings.Builder
+	for i, s := range samples {
+		fmt.Fprintf(&b, "=== CONVERSATION %d ===\nUser: %s\nAssistant: %s\n\n", i+1, s.Query, s.Reply)
+	}
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: fmt.Sprintf(analyticsPrompt, strings.Join(append(slices.Clone(topics), TopicOther), ", "))},
+		{Role: genaidemo.Role_ROLE_USER, Content: b.String()},
+	}
+
+	// 直接转换消息，避免对话内容中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return nil, nil, fmt.Errorf("classification call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, nil, status.Error(codes.Internal, "no response from classification")
+	}
+
+	labels, err := parseConversationLabels(resp.Choices[0].Content, len(samples), topics)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "invalid classification response: %v", err)
+	}
+	return labels, p.ResponseUsage(messages, resp), nil
+}
+
+// parseConversationLabels 解析分类调用的 JSON 输出 (容忍 markdown 代码块)。
+// 缺少的对话标签为空，未知的取值替换为 other、neutral 和 partial。
+func parseConversationLabels(content string, n int, topics []string) ([]ConversationLabels, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in %q", content)
+	}
+
+	var out struct {
+		Labels []struct {
+			Index int `json:"index"`
+			ConversationLabels
+		} `json:"labels"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &out); err != nil {
+		return nil, err
+	}
+
+	labels := make([]ConversationLabels, n)
+	for _, l := range out.Labels {
+		if l.Index < 1 || l.Index > n {
+			continue
+		}
+		topic := strings.ToLower(strings.TrimSpace(l.Topic))
+		if i := slices.IndexFunc(topics, func(t string) bool { return strings.EqualFold(t, topic) }); i >= 0 {
+			topic = topics[i]
+		} else {
+			topic = TopicOther
+		}
+		sentiment := strings.ToLower(strings.TrimSpace(l.Sentiment))
+		if !slices.Contains([]string{SentimentPositive, SentimentNeutral, SentimentNegative}, sentiment) {
+			sentiment = SentimentNeutral
+		}
+		resolution := strings.ToLower(strings.TrimSpace(l.Resolution))
+		if !slices.Contains([]string{ResolutionResolved, ResolutionPartial, ResolutionUnresolved}, resolution) {
+			resolution = ResolutionPartial
+		}
+		labels[l.Index-1] = ConversationLabels{Topic: topic, Sentiment: sentiment, Resolution: resolution}
+	}
+	return labels, nil
+}
@@ -0,0 +1,36 @@
+package llm
+
+// ModelCapabilities 模型支持的功能
+type ModelCapabilities struct {
+	// Tools 支持函数调用
+	Tools bool `json:"tools"`
+	// Vision 支持图片输入
+	Vision bool `json:"vision"`
+	// Streaming 支持流式生成
+	Streaming bool `json:"streaming"`
+	// Embeddings 嵌入模型，只能生成嵌入向量而不能生成内容
+	Embeddings bool `json:"embeddings"`
+	// ContextWindow 输入 token 上限
+	ContextWindow int `json:"context_window"`
+}
+
+// modelCapabilities Vertex AI 模型的功能，按前缀匹配模型名 (例如 "gemini-1.5-flash-002")
+var modelCapabilities = map[string]ModelCapabilities{
+	"gemini-1.0-pro":        {Tools: true, Streaming: true, ContextWindow: 32760},
+	"gemini-1.0-pro-vision": {Vision: true, Streaming: true, ContextWindow: 12288},
+	"gemini-1.5-flash":      {Tools: true, Vision: true, Streaming: true, ContextWindow: 1048576},
+	"gemini-1.5-pro":        {Tools: true, Vision: true, Streaming: true, ContextWindow: 2097152},
+	"gemini-2.0-flash":      {Tools: true, Vision: true, Streaming: true, ContextWindow: 1048576},
+	"gemini-2.5-flash":      {Tools: true, Vision: true, Streaming: true, ContextWindow: 1048576},
+	"gemini-2.5-pro":        {Tools: true, Vision: true, Streaming: true, ContextWindow: 1048576},
+
+	"textembedding-gecko":          {Embeddings: true, ContextWindow: 3072},
+	"text-embedding-":              {Embeddings: true, ContextWindow: 2048},
+	"text-multilingual-embedding-": {Embeddings: true, ContextWindow: 2048},
+	"gemini-embedding-":            {Embeddings: true, ContextWindow: 2048},
+}
+
+// Capabilities 返回模型支持的功能，未知模型返回 false
+func Capabilities(model string) (ModelCapabilities, bool) {
+	return lookupModel(modelCapabilities, model)
+}
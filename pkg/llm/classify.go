@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// classifyPrompt 文本分类的系统提示词，%s 为标签列表，要求只输出 JSON
+const classifyPrompt = `You classify texts into exactly one of the labels below.
+%s
+Pick the label that fits the text best, and your confidence that it is the right one, from 0 to 1.
+Respond with JSON only, without any other text, in the form:
+{"label": "<label>", "confidence": <confidence>}`
+
+// ClassLabel 分类标签，Description 和 Examples (属于该标签的示例文本) 可选
+type ClassLabel struct {
+	Name        string
+	Description string
+	Examples    []string
+}
+
+// Classification 分类结果，Label 总是 labels 中的一个
+type Classification struct {
+	Label string
+	// Confidence 是模型给出的 0 到 1 之间的置信度
+	Confidence float64
+}
+
+// ClassifyText 将文本归入 labels 中的一个标签，返回分类结果以及分类调用的 token 用量
+func (p *Processor) ClassifyText(ctx context.Context, text string, labels []ClassLabel) (*Classification, *TokenUsage, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil, status.Error(codes.InvalidArgument, "text cannot be empty")
+	}
+	if len(labels) < 2 {
+		return nil, nil, status.Error(codes.InvalidArgument, "at least two labels are required")
+	}
+
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: fmt.Sprintf(classifyPrompt, buildLabelList(labels))},
+		{Role: genaidemo.Role_ROLE_USER, Content: "=== TEXT ===\n" + text},
+	}
+
+	// 直接转换消息，避免文本中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return nil, nil, fmt.Errorf("classification call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, nil, status.Error(codes.Internal, "no response from classification")
+	}
+
+	classification, err := parseClassification(resp.Choices[0].Content, labels)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "invalid classification response: %v", err)
+	}
+	return classification, p.ResponseUsage(messages, resp), nil
+}
+
+// buildLabelList 列出标签及其说明和示例文本
+func buildLabelList(labels []ClassLabel) string {
+	var b strings.Builder
+	b.WriteString("=== LABELS ===\n")
+	for _, l := range labels {
+		b.WriteString("- " + l.Name)
+		if l.Description != "" {
+			b.WriteString(": " + l.Description)
+		}
+		b.WriteString("\n")
+		for _, example := range l.Examples {
+			fmt.Fprintf(&b, "  Example: %q\n", example)
+		}
+	}
+	return b.String()
+}
+
+// parseClassification 解析分类调用的 JSON 输出 (容忍 markdown 代码块)。
+// 标签不区分大小写地匹配，置信度限制在 0 到 1 之间
+func parseClassification(content string, labels []ClassLabel) (*Classification, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in %q", content)
+	}
+
+	var out struct {
+		Label      string  `json:"label"`
+		Confidence float64 `json:"confidence"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &out); err != nil {
+		return nil, err
+	}
+
+	label := strings.TrimSpace(out.Label)
+	for _, l := range labels {
+		if strings.EqualFold(l.Name, label) {
+			return &Classification{Label: l.Name, Confidence: min(max(out.Confidence, 0), 1)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown label %q", out.Label)
+}
@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultContextWindow 未知模型使用的保守上下文窗口
+const defaultContextWindow = 32768
+
+// ContextWindow 返回模型的输入 token 上限
+func ContextWindow(model string) int {
+	if caps, ok := Capabilities(model); ok {
+		return caps.ContextWindow
+	}
+	return defaultContextWindow
+}
+
+// lookupModel 按最长前缀匹配模型名，使带版本后缀的模型名也能找到配置
+func lookupModel[T any](table map[string]T, model string) (T, bool) {
+	var (
+		best  string
+		value T
+		found bool
+	)
+	for prefix, v := range table {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, value, found = prefix, v, true
+		}
+	}
+	return value, found
+}
+
+// TruncationStrategy 输入超出上限时的处理方式
+type TruncationStrategy string
+
+const (
+	// TruncationReject 直接拒绝请求
+	TruncationReject TruncationStrategy = "reject"
+	// TruncationDropOldest 保留系统消息和最后一条消息，从最早的对话开始丢弃
+	TruncationDropOldest TruncationStrategy = "drop_oldest"
+)
+
+// ParseTruncationStrategy 解析截断策略，未知值返回 false
+func ParseTruncationStrategy(s string) (TruncationStrategy, bool) {
+	switch strategy := TruncationStrategy(s); strategy {
+	case TruncationReject, TruncationDropOldest:
+		return strategy, true
+	default:
+		return "", false
+	}
+}
+
+// FitMessages 校验消息的 token 总数不超过 limit，超出时按 strategy 拒绝或裁剪。
+// 返回的切片可能与输入相同。
+func FitMessages(model string, messages []*genaidemo.Message, limit int, strategy TruncationStrategy) ([]*genaidemo.Message, error) {
+	total := CountMessageTokens(model, messages)
+	if total <= limit {
+		return messages, nil
+	}
+
+	if strategy != TruncationDropOldest {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"input of %d tokens exceeds the %d token limit of model %s", total, limit, model)
+	}
+
+	// 系统消息和最后一条消息始终保留，其余消息从最早的开始丢弃
+	last := len(messages) - 1
+	dropped := make([]bool, len(messages))
+	for i := 0; i < last && total > limit; i++ {
+		if messages[i].Role == genaidemo.Role_ROLE_SYSTEM {
+			continue
+		}
+		total -= tokensPerMessage + CountTokens(model, messages[i].Content)
+		dropped[i] = true
+	}
+	if total > limit {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"input of %d tokens exceeds the %d token limit of model %s even after dropping earlier messages", total, limit, model)
+	}
+
+	trimmed := make([]*genaidemo.Message, 0, len(messages))
+	for i, msg := range messages {
+		if !dropped[i] {
+			trimmed = append(trimmed, msg)
+		}
+	}
+	return trimmed, nil
+}
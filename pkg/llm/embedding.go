@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// EmbeddingClient 定义嵌入客户端接口
+type EmbeddingClient interface {
+	CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ProgressFunc 报告嵌入进度，done 为已完成的文本数
+type ProgressFunc func(done, total int)
+
+// BatchEmbedder 将大量文本拆分为符合模型限制的批次，并由有限数量的 worker 并发生成嵌入
+type BatchEmbedder struct {
+	client    EmbeddingClient
+	batchSize int
+	workers   int
+}
+
+// NewBatchEmbedder 创建批量嵌入器，batchSize 和 workers 至少为 1
+func NewBatchEmbedder(client EmbeddingClient, batchSize, workers int) *BatchEmbedder {
+	return &BatchEmbedder{
+		client:    client,
+		batchSize: max(batchSize, 1),
+		workers:   max(workers, 1),
+	}
+}
+
+// Embed 为所有文本生成嵌入，结果顺序与输入一致。任一批次失败时取消其余批次并返回错误。
+// progress 可以为 nil，会在每个批次完成后被调用 (可能来自不同 goroutine)。
+func (b *BatchEmbedder) Embed(ctx context.Context, texts []string, progress ProgressFunc) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	var done atomic.Int64
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.workers)
+	for start := 0; start < len(texts); start += b.batchSize {
+		end := min(start+b.batchSize, len(texts))
+		g.Go(func() error {
+			batch, err := b.client.CreateEmbedding(ctx, texts[start:end])
+			if err != nil {
+				return err
+			}
+			if len(batch) != end-start {
+				return status.Errorf(codes.Internal, "expected %d embeddings, got %d", end-start, len(batch))
+			}
+			// 每个批次写入不重叠的区间，无需加锁
+			copy(embeddings[start:end], batch)
+
+			n := done.Add(int64(end - start))
+			if progress != nil {
+				progress(int(n), len(texts))
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return embeddings, nil
+}
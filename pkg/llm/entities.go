@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 会话实体的长度限制，超出的实体或属性被丢弃
+const (
+	maxEntityNameLength      = 100
+	maxEntityAttributeLength = 200
+	MaxEntityAttributes      = 10 // 每个实体保留的属性数上限
+)
+
+// entityPrompt 提取会话实体的系统提示词，要求只输出 JSON
+const entityPrompt = `You keep track of the entities a conversation refers to, so it can continue after earlier messages are forgotten.
+From the latest exchange, extract the specific entities mentioned: people, tickets, orders, products and SKUs, accounts, files, systems and the like,
+with the attributes the exchange states about them, e.g.
+{"name": "INC-4821", "type": "ticket", "attributes": {"status": "escalated", "assignee": "Maria Chen"}}.
+Use the identifier or full name as the name, the same way as the known entities when it is one of them.
+Only report attributes that are new or changed compared to the known entities. Skip generic concepts.
+Respond with JSON only, without any other text, in the form:
+{"entities": [{"name": "<name>", "type": "<type>", "attributes": {"<attribute>": "<value>"}}]}`
+
+// SessionEntity 会话中提到的实体及其最新的属性
+type SessionEntity struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// ExtractSessionEntities 从最近一轮对话 (最后一条用户消息和回复) 中提取实体及其属性，
+// known 是会话中已经记录的实体。返回新的或有变化的实体，以及提取调用的 token 用量。
+func (p *Processor) ExtractSessionEntities(ctx context.Context, conversation []*genaidemo.Message, reply string, known []SessionEntity) ([]SessionEntity, *TokenUsage, error) {
+	var latest string
+	for i := len(conversation) - 1; i >= 0; i-- {
+		if conversation[i].Role == genaidemo.Role_ROLE_USER {
+			latest = conversation[i].Content
+			break
+		}
+	}
+	if strings.TrimSpace(latest) == "" {
+		return nil, nil, status.Error(codes.InvalidArgument, "conversation has no user message")
+	}
+
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: entityPrompt},
+		{Role: genaidemo.Role_ROLE_USER, Content: buildEntityInput(latest, reply, known)},
+	}
+
+	// 直接转换消息，避免对话内容中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return nil, nil, fmt.Errorf("entity extraction call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, nil, status.Error(codes.Internal, "no response from entity extraction")
+	}
+
+	entities, err := parseSessionEntities(resp.Choices[0].Content)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "invalid entity extraction response: %v", err)
+	}
+	return entities, p.ResponseUsage(messages, resp), nil
+}
+
+// buildEntityInput 将已知实体和最近一轮对话整理为提取调用的输入
+func buildEntityInput(latest, reply string, known []SessionEntity) string {
+	var b strings.Builder
+	if len(known) > 0 {
+		b.WriteString("=== KNOWN ENTITIES ===\n")
+		for _, e := range known {
+			data, _ := json.Marshal(e)
+			b.Write(data)
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("=== LATEST USER MESSAGE ===\n")
+	b.WriteString(latest)
+	b.WriteString("\n\n=== ASSISTANT REPLY ===\n")
+	b.WriteString(reply)
+	return b.String()
+}
+
+// parseSessionEntities 解析提取调用的 JSON 输出 (容忍 markdown 代码块)，
+// 丢弃空的和过长的实体与属性
+func parseSessionEntities(content string) ([]SessionEntity, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in %q", content)
+	}
+
+	var out struct {
+		Entities []SessionEntity `json:"entities"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &out); err != nil {
+		return nil, err
+	}
+
+	entities := make([]SessionEntity, 0, len(out.Entities))
+	for _, e := range out.Entities {
+		e.Name = strings.TrimSpace(e.Name)
+		e.Type = strings.ToLower(strings.TrimSpace(e.Type))
+		if e.Name == "" || len([]rune(e.Name)) > maxEntityNameLength || len([]rune(e.Type)) > maxEntityNameLength {
+			continue
+		}
+		attributes := make(map[string]string, len(e.Attributes))
+		for key, value := range e.Attributes {
+			key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+			if key == "" || value == "" || len([]rune(key)) > maxEntityNameLength || len([]rune(value)) > maxEntityAttributeLength {
+				continue
+			}
+			if len(attributes) < MaxEntityAttributes {
+				attributes[key] = value
+			}
+		}
+		e.Attributes = attributes
+		entities = append(entities, e)
+	}
+	return entities, nil
+}
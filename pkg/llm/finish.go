@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// GenerationInfo 中 provider 返回的安全评级字段 (Gemini/VertexAI)
+const generationInfoSafety = "safety"
+
+// GenerationInfo 中 provider 返回的模型版本字段: Gemini 的 model_version 和 OpenAI 的 system_fingerprint
+var generationInfoModelVersion = []string{"model_version", "ModelVersion", "system_fingerprint"}
+
+// FinishReasonFromChoice 将 provider 的停止原因归一化，例如 Gemini 的 FinishReasonStop、
+// OpenAI 的 length 和 Anthropic 的 end_turn。Gemini 调用工具时也报告 STOP，此时返回 TOOL_CALLS
+func FinishReasonFromChoice(choice *llms.ContentChoice) genaidemo.FinishReason {
+	if choice == nil {
+		return genaidemo.FinishReason_FINISH_REASON_UNSPECIFIED
+	}
+
+	reason := strings.ToLower(choice.StopReason)
+	reason = strings.NewReplacer("_", "", "-", "", " ", "").Replace(reason)
+	reason = strings.TrimPrefix(reason, "finishreason")
+	switch reason {
+	case "", "stop", "endturn", "stopsequence":
+		if len(choice.ToolCalls) > 0 {
+			return genaidemo.FinishReason_FINISH_REASON_TOOL_CALLS
+		}
+		if reason == "" {
+			return genaidemo.FinishReason_FINISH_REASON_UNSPECIFIED
+		}
+		return genaidemo.FinishReason_FINISH_REASON_STOP
+	case "maxtokens", "length":
+		return genaidemo.FinishReason_FINISH_REASON_MAX_TOKENS
+	case "safety", "contentfilter", "blocklist", "prohibitedcontent", "spii":
+		return genaidemo.FinishReason_FINISH_REASON_SAFETY
+	case "recitation":
+		return genaidemo.FinishReason_FINISH_REASON_RECITATION
+	case "toolcalls", "tooluse", "functioncall":
+		return genaidemo.FinishReason_FINISH_REASON_TOOL_CALLS
+	default:
+		return genaidemo.FinishReason_FINISH_REASON_OTHER
+	}
+}
+
+// ModelVersionFromChoice 返回 provider 报告的生成回复的确切模型版本，未报告时为空
+func ModelVersionFromChoice(choice *llms.ContentChoice) string {
+	if choice == nil {
+		return ""
+	}
+	for _, key := range generationInfoModelVersion {
+		if version, ok := choice.GenerationInfo[key].(string); ok && version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+// SafetyRatingsFromChoice 读取 provider 返回的安全评级。不同 genai SDK 的评级类型不同，
+// 因此按字段名 (Category、Probability、Blocked) 读取，也支持录制回放后的 JSON 对象
+func SafetyRatingsFromChoice(choice *llms.ContentChoice) []*genaidemo.SafetyRating {
+	if choice == nil || choice.GenerationInfo == nil {
+		return nil
+	}
+	ratings := reflect.ValueOf(choice.GenerationInfo[generationInfoSafety])
+	if ratings.Kind() != reflect.Slice {
+		return nil
+	}
+
+	var out []*genaidemo.SafetyRating
+	for i := range ratings.Len() {
+		rating := indirect(ratings.Index(i))
+		category, ok := ratingField(rating, "Category")
+		if !ok {
+			continue
+		}
+		result := &genaidemo.SafetyRating{Category: enumName(fmt.Sprint(category), "HARM_CATEGORY_")}
+		if probability, ok := ratingField(rating, "Probability"); ok {
+			result.Probability = enumName(fmt.Sprint(probability), "HARM_PROBABILITY_")
+		}
+		if blocked, ok := ratingField(rating, "Blocked"); ok {
+			result.Blocked, _ = blocked.(bool)
+		}
+		out = append(out, result)
+	}
+	return out
+}
+
+// indirect 解开指针和接口
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// ratingField 读取结构体字段或 map 的键，map 的键也可以是小写
+func ratingField(v reflect.Value, name string) (any, bool) {
+	switch v.Kind() {
+	case reflect.Struct:
+		field := v.FieldByName(name)
+		if !field.IsValid() || !field.CanInterface() {
+			return nil, false
+		}
+		return field.Interface(), true
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		for _, key := range []string{name, strings.ToLower(name)} {
+			if value := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())); value.IsValid() {
+				return indirect(value).Interface(), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// enumName 将枚举名统一为去掉前缀的大写下划线形式，
+// 例如 HarmCategoryDangerousContent 和 HARM_CATEGORY_DANGEROUS_CONTENT 都变为 DANGEROUS_CONTENT
+func enumName(name, prefix string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return strings.TrimPrefix(b.String(), prefix)
+}
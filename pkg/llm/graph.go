@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GraphEntityTypes 知识图谱实体的类型，未知类型记为 concept
+var GraphEntityTypes = []string{"person", "organization", "project", "product", "technology", "decision", "event", "concept"}
+
+// maxGraphNameLength 实体名和关系谓词的最大字符数，更长的被丢弃
+const maxGraphNameLength = 100
+
+// graphPrompt 提取实体和关系的系统提示词，要求只输出 JSON
+const graphPrompt = `You build the knowledge graph of a team's AI assistant.
+From the text, extract the entities it names and the relations between them that will still be worth knowing later:
+decisions, ownership, dependencies, responsibilities, statuses and other lasting facts, e.g.
+{"subject": "Project Atlas", "predicate": "decided to use", "object": "PostgreSQL", "date": "2026-09-12"}.
+Entity types are person, organization, project, product, technology, decision, event or concept.
+Name entities the way the text does, without articles. Write predicates as short lower case verb phrases.
+Set date (YYYY-MM-DD) only when the text says when the relation happened or was decided, resolving relative dates against today.
+Skip greetings, questions, hypotheticals and general knowledge not specific to the text.
+Respond with JSON only, without any other text, in the form:
+{"entities": [{"name": "<name>", "type": "<type>"}], "relations": [{"subject": "<entity>", "predicate": "<predicate>", "object": "<entity>", "date": "<date>"}]}`
+
+// GraphEntity 文本中提到的实体
+type GraphEntity struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// GraphRelation 两个实体之间的关系，Date 为文本给出的发生日期 (可为空)
+type GraphRelation struct {
+	Subject   string `json:"subject"`
+	Predicate string `json:"predicate"`
+	Object    string `json:"object"`
+	Date      string `json:"date,omitempty"`
+}
+
+// GraphExtraction 从一段文本中提取的实体和关系
+type GraphExtraction struct {
+	Entities  []GraphEntity   `json:"entities"`
+	Relations []GraphRelation `json:"relations"`
+}
+
+// ExtractGraph 从对话或文档文本中提取实体和关系，相对日期按 now 解析。
+// 返回提取结果以及提取调用的 token 用量。
+func (p *Processor) ExtractGraph(ctx context.Context, text string, now time.Time) (*GraphExtraction, *TokenUsage, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil, status.Error(codes.InvalidArgument, "text cannot be empty")
+	}
+
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: graphPrompt},
+		{Role: genaidemo.Role_ROLE_USER, Content: fmt.Sprintf("=== TODAY ===\n%s\n\n=== TEXT ===\n%s", now.Format(time.DateOnly), text)},
+	}
+
+	// 直接转换消息，避免文本中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return nil, nil, fmt.Errorf("graph extraction call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, nil, status.Error(codes.Internal, "no response from graph extraction")
+	}
+
+	graph, err := parseGraphExtraction(resp.Choices[0].Content)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "invalid graph extraction response: %v", err)
+	}
+	return graph, p.ResponseUsage(messages, resp), nil
+}
+
+// parseGraphExtraction 解析提取调用的 JSON 输出 (容忍 markdown 代码块)，
+// 丢弃不完整的、过长的关系和无效的日期
+func parseGraphExtraction(content string) (*GraphExtraction, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in %q", content)
+	}
+
+	var out GraphExtraction
+	if err := json.Unmarshal([]byte(content[start:end+1]), &out); err != nil {
+		return nil, err
+	}
+
+	graph := &GraphExtraction{}
+	for _, e := range out.Entities {
+		e.Name = strings.TrimSpace(e.Name)
+		e.Type = strings.ToLower(strings.TrimSpace(e.Type))
+		if !validGraphName(e.Name) {
+			continue
+		}
+		if !slices.Contains(GraphEntityTypes, e.Type) {
+			e.Type = "concept"
+		}
+		graph.Entities = append(graph.Entities, e)
+	}
+	for _, r := range out.Relations {
+		r.Subject = strings.TrimSpace(r.Subject)
+		r.Predicate = strings.ToLower(strings.TrimSpace(r.Predicate))
+		r.Object = strings.TrimSpace(r.Object)
+		if !validGraphName(r.Subject) || !validGraphName(r.Predicate) || !validGraphName(r.Object) {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, r.Date); err != nil {
+			r.Date = ""
+		}
+		graph.Relations = append(graph.Relations, r)
+	}
+	return graph, nil
+}
+
+// validGraphName 判断实体名或谓词是否非空且不过长
+func validGraphName(name string) bool {
+	return name != "" && len([]rune(name)) <= maxGraphNameLength
+}
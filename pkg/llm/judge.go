@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultEvaluationCriteria 未指定评估维度时使用的维度
+var DefaultEvaluationCriteria = []string{"helpfulness", "groundedness", "tone"}
+
+// evaluationRubrics 内置评估维度的评分说明，其他维度由评审模型按名称理解
+var evaluationRubrics = map[string]string{
+	"helpfulness":  "Does the response address the user's request completely and usefully?",
+	"groundedness": "Is every claim supported by the provided documents (or, without documents, by the conversation and well-established facts), with nothing made up?",
+	"tone":         "Is the response polite, clear and appropriate for the conversation?",
+	"faithfulness": "Is every statement in the response supported by the provided documents? Score 1 if it contradicts them or relies mostly on outside information, 5 if everything it states can be traced to them.",
+}
+
+// 评分范围
+const (
+	MinEvaluationScore = 1
+	MaxEvaluationScore = 5
+)
+
+// judgePrompt 评审模型的系统提示词，要求只输出 JSON
+const judgePrompt = `You are an impartial judge evaluating the response of an AI assistant.
+Score the response on each of the following criteria from %d (very poor) to %d (excellent):
+%s
+Respond with JSON only, without any other text, in the form:
+{"scores": [{"criterion": "<criterion>", "score": <score>, "reasoning": "<one or two sentences>"}]}`
+
+// CriterionScore 单个评估维度的得分
+type CriterionScore struct {
+	Criterion string `json:"criterion"`
+	Score     int    `json:"score"`
+	Reasoning string `json:"reasoning"`
+}
+
+// Evaluation 评审结果
+type Evaluation struct {
+	Scores     []CriterionScore
+	TokenUsage *TokenUsage
+}
+
+// OverallScore 返回各维度得分的平均值
+func (e *Evaluation) OverallScore() float64 {
+	if len(e.Scores) == 0 {
+		return 0
+	}
+	var total int
+	for _, s := range e.Scores {
+		total += s.Score
+	}
+	return float64(total) / float64(len(e.Scores))
+}
+
+// EvaluateResponse 使用评审模型 (LLM-as-judge) 对一次回复按各维度打分。
+// conversation 是产生回复的对话，documents 是回复应当依据的检索文档，可以为空。
+func (p *Processor) EvaluateResponse(ctx context.Context, conversation []*genaidemo.Message, response string, documents, criteria []string) (*Evaluation, error) {
+	if len(conversation) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "conversation cannot be empty")
+	}
+	if strings.TrimSpace(response) == "" {
+		return nil, status.Error(codes.InvalidArgument, "response cannot be empty")
+	}
+	if len(criteria) == 0 {
+		criteria = DefaultEvaluationCriteria
+	}
+
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: buildJudgePrompt(criteria)},
+		{Role: genaidemo.Role_ROLE_USER, Content: buildJudgeInput(conversation, response, documents)},
+	}
+
+	// 直接转换消息，避免对话内容中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return nil, fmt.Errorf("judge call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, status.Error(codes.Internal, "no response from judge")
+	}
+
+	scores, err := parseJudgeScores(resp.Choices[0].Content, criteria)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid judge response: %v", err)
+	}
+
+	return &Evaluation{
+		Scores:     scores,
+		TokenUsage: p.ResponseUsage(messages, resp),
+	}, nil
+}
+
+// buildJudgePrompt 列出各评估维度及其评分说明
+func buildJudgePrompt(criteria []string) string {
+	var b strings.Builder
+	for _, c := range criteria {
+		if rubric, ok := evaluationRubrics[c]; ok {
+			fmt.Fprintf(&b, "- %s: %s\n", c, rubric)
+		} else {
+			fmt.Fprintf(&b, "- %s\n", c)
+		}
+	}
+	return fmt.Sprintf(judgePrompt, MinEvaluationScore, MaxEvaluationScore, b.String())
+}
+
+// buildJudgeInput 将对话、文档和待评估回复整理为评审模型的输入
+func buildJudgeInput(conversation []*genaidemo.Message, response string, documents []string) string {
+	var b strings.Builder
+	b.WriteString("=== CONVERSATION ===\n")
+	for _, msg := range conversation {
+		role := strings.ToLower(strings.TrimPrefix(msg.Role.String(), "ROLE_"))
+		fmt.Fprintf(&b, "[%s]\n%s\n\n", role, msg.Content)
+	}
+	if len(documents) > 0 {
+		b.WriteString("=== DOCUMENTS ===\n")
+		for i, doc := range documents {
+			fmt.Fprintf(&b, "--- Document %d ---\n%s\n\n", i+1, doc)
+		}
+	}
+	b.WriteString("=== RESPONSE TO EVALUATE ===\n")
+	b.WriteString(response)
+	return b.String()
+}
+
+// parseJudgeScores 解析评审模型的 JSON 输出 (容忍 markdown 代码块)，
+// 并检查每个维度都有范围内的得分
+func parseJudgeScores(content string, criteria []string) ([]CriterionScore, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in %q", content)
+	}
+
+	var out struct {
+		Scores []CriterionScore `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &out); err != nil {
+		return nil, err
+	}
+
+	byCriterion := make(map[string]CriterionScore, len(out.Scores))
+	for _, s := range out.Scores {
+		byCriterion[strings.ToLower(strings.TrimSpace(s.Criterion))] = s
+	}
+
+	scores := make([]CriterionScore, len(criteria))
+	for i, c := range criteria {
+		s, ok := byCriterion[strings.ToLower(c)]
+		if !ok {
+			return nil, fmt.Errorf("missing score for %q", c)
+		}
+		if s.Score < MinEvaluationScore || s.Score > MaxEvaluationScore {
+			return nil, fmt.Errorf("score %d for %q out of range", s.Score, c)
+		}
+		s.Criterion = c
+		scores[i] = s
+	}
+	return scores, nil
+}
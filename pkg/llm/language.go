@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// languageNames 支持识别和命名的语言 (ISO 639-1 代码到英文名称)
+var languageNames = map[string]string{
+	"ar": "Arabic",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"th": "Thai",
+	"zh": "Chinese",
+}
+
+// scriptLanguages 文字系统可以直接确定的语言。日文同时使用汉字，由假名判断
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	code  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopwords 拉丁字母语言的高频虚词，用于区分使用相同字母的语言
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "what", "how", "of", "to", "in", "you", "can", "does", "with", "for", "this", "please"},
+	"fr": {"le", "la", "les", "est", "et", "des", "une", "que", "pour", "dans", "vous", "quel", "quelle", "comment", "avec", "sur"},
+	"de": {"der", "die", "das", "und", "ist", "ein", "eine", "nicht", "wie", "was", "ich", "mit", "für", "auf", "sie", "bitte"},
+	"es": {"el", "los", "las", "es", "y", "una", "que", "para", "por", "cómo", "qué", "con", "del", "puedo", "cuál", "está"},
+	"it": {"il", "lo", "gli", "è", "e", "una", "che", "per", "come", "cosa", "con", "della", "sono", "non", "quale", "posso"},
+	"pt": {"o", "os", "as", "é", "e", "uma", "que", "para", "como", "com", "não", "do", "da", "qual", "posso", "você"},
+	"nl": {"de", "het", "een", "en", "is", "van", "niet", "wat", "hoe", "met", "voor", "ik", "zijn", "op", "je", "kan"},
+}
+
+// languageTag 简化的 BCP 47 语言标签，例如 "fr" 或 "pt-BR"
+var languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// DetectLanguage 根据文字系统和高频虚词推测文本的语言，返回 ISO 639-1 代码；
+// 文本太短或无法判断时返回空字符串
+func DetectLanguage(text string) string {
+	scripts := make(map[string]int)
+	letters, latin := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scripts[s.code]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// 含有假名的文本是日文，即使汉字更多
+	if scripts["ja"] > 0 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for code, count := range scripts {
+		if count > bestCount || (count == bestCount && code < best) {
+			best, bestCount = code, count
+		}
+	}
+	// 以非拉丁文字为主的文本 (混有英文术语也可以) 按文字系统判断
+	if bestCount*3 >= letters {
+		return best
+	}
+	if latin == 0 {
+		return ""
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	scores := make(map[string]int)
+	for _, w := range words {
+		for code, list := range stopwords {
+			for _, s := range list {
+				if w == s {
+					scores[code]++
+					break
+				}
+			}
+		}
+	}
+	best, bestScore := "", 0
+	for code, score := range scores {
+		if score > bestScore || (score == bestScore && code < best) {
+			best, bestScore = code, score
+		}
+	}
+	return best
+}
+
+// NormalizeLanguage 将语言标签或英文名称 (例如 "fr"、"pt-BR"、"French") 规范化为语言标签
+func NormalizeLanguage(language string) (string, bool) {
+	language = strings.TrimSpace(language)
+	for code, name := range languageNames {
+		if strings.EqualFold(language, name) {
+			return code, true
+		}
+	}
+	if !languageTag.MatchString(language) {
+		return "", false
+	}
+	base, region, ok := strings.Cut(language, "-")
+	if !ok {
+		return strings.ToLower(base), true
+	}
+	// 两个字母的地区代码按惯例大写，例如 "pt-br" 规范化为 "pt-BR"
+	if len(region) == 2 {
+		region = strings.ToUpper(region)
+	}
+	return strings.ToLower(base) + "-" + region, true
+}
+
+// LanguageName 返回语言标签的英文名称，例如 "pt-BR" 返回 "Portuguese (BR)"；
+// 未知语言返回标签本身
+func LanguageName(tag string) string {
+	base, region, _ := strings.Cut(tag, "-")
+	name, ok := languageNames[strings.ToLower(base)]
+	if !ok {
+		return tag
+	}
+	if region != "" {
+		return name + " (" + region + ")"
+	}
+	return name
+}
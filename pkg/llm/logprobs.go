@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"fmt"
+	"reflect"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// GenerationInfo 中 provider 返回的 token 对数概率字段
+const generationInfoLogprobs = "logprobs"
+
+// 请求对数概率的 CallOptions.Metadata 键。langchaingo 没有对应的调用选项，
+// 支持的 provider 从 Metadata 读取，不支持的忽略
+const (
+	MetadataLogprobs    = "logprobs"
+	MetadataTopLogprobs = "top_logprobs"
+)
+
+// LogprobsFromChoice 读取 provider 返回的回复 token 对数概率，未返回时为空。
+// 与安全评级一样按字段名读取，支持 Token/Logprob/TopLogprobs (OpenAI 风格)、
+// Token/LogProbability/TopCandidates (Gemini) 以及录制回放后的 JSON 对象
+func LogprobsFromChoice(choice *llms.ContentChoice) []*genaidemo.TokenLogprob {
+	if choice == nil || choice.GenerationInfo == nil {
+		return nil
+	}
+	tokens := indirect(reflect.ValueOf(choice.GenerationInfo[generationInfoLogprobs]))
+	if tokens.Kind() != reflect.Slice {
+		return nil
+	}
+
+	var out []*genaidemo.TokenLogprob
+	for i := range tokens.Len() {
+		token := indirect(tokens.Index(i))
+		logprob, ok := tokenLogprob(token)
+		if !ok {
+			continue
+		}
+		if top, ok := logprobField(token, "TopLogprobs", "top_logprobs", "TopCandidates", "top_candidates"); ok {
+			alternatives := indirect(reflect.ValueOf(top))
+			if alternatives.Kind() == reflect.Slice {
+				for j := range alternatives.Len() {
+					if alternative, ok := tokenLogprob(indirect(alternatives.Index(j))); ok {
+						logprob.TopLogprobs = append(logprob.TopLogprobs, &genaidemo.TopLogprob{
+							Token:   alternative.Token,
+							Logprob: alternative.Logprob,
+						})
+					}
+				}
+			}
+		}
+		out = append(out, logprob)
+	}
+	return out
+}
+
+// tokenLogprob 读取一个 token 及其对数概率
+func tokenLogprob(v reflect.Value) (*genaidemo.TokenLogprob, bool) {
+	token, ok := logprobField(v, "Token", "token")
+	if !ok {
+		return nil, false
+	}
+	value, ok := logprobField(v, "Logprob", "logprob", "LogProbability", "log_probability")
+	if !ok {
+		return nil, false
+	}
+	logprob := indirect(reflect.ValueOf(value))
+	if !logprob.CanFloat() {
+		return nil, false
+	}
+	return &genaidemo.TokenLogprob{Token: fmt.Sprint(token), Logprob: logprob.Float()}, true
+}
+
+// logprobField 读取第一个存在的字段或键
+func logprobField(v reflect.Value, names ...string) (any, bool) {
+	for _, name := range names {
+		if value, ok := ratingField(v, name); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 用户事实的类别。name、role 和 language 每个用户只保留一条，新的事实替换旧的
+const (
+	UserFactName       = "name"
+	UserFactRole       = "role"
+	UserFactLanguage   = "language"
+	UserFactProject    = "project"
+	UserFactPreference = "preference"
+)
+
+// UserFactCategories 可提取的用户事实类别
+var UserFactCategories = []string{UserFactName, UserFactRole, UserFactLanguage, UserFactProject, UserFactPreference}
+
+// maxUserFactLength 单条事实的最大字符数，更长的事实被丢弃
+const maxUserFactLength = 200
+
+// memoryPrompt 提取用户事实的系统提示词，要求只输出 JSON
+const memoryPrompt = `You maintain the long-term memory of an AI assistant about its user.
+From the user's latest message, extract facts about the user that will still be true in future conversations:
+- name: the user's name
+- role: the user's job, role or expertise
+- language: the language the user prefers to be answered in
+- project: a project or long-running task the user is working on
+- preference: how the user likes to be answered, or other lasting preferences
+Only extract what the user states about themselves, never guesses, one-off requests or facts about other people.
+Skip facts already known unless they changed. Write each fact as a short phrase, e.g. "Data engineer at a logistics company".
+Respond with JSON only, without any other text, in the form:
+{"facts": [{"category": "<category>", "content": "<fact>"}]}`
+
+// UserFact 从对话中提取的用户的稳定事实或偏好
+type UserFact struct {
+	Category string `json:"category"`
+	Content  string `json:"content"`
+}
+
+// ExtractUserFacts 从对话最后一条用户消息中提取用户事实，known 是已经记住的事实。
+// 返回新的或变化了的事实，以及提取调用的 token 用量。
+func (p *Processor) ExtractUserFacts(ctx context.Context, conversation []*genaidemo.Message, known []UserFact) ([]UserFact, *TokenUsage, error) {
+	var latest string
+	for i := len(conversation) - 1; i >= 0; i-- {
+		if conversation[i].Role == genaidemo.Role_ROLE_USER {
+			latest = conversation[i].Content
+			break
+		}
+	}
+	if strings.TrimSpace(latest) == "" {
+		return nil, nil, status.Error(codes.InvalidArgument, "conversation has no user message")
+	}
+
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: memoryPrompt},
+		{Role: genaidemo.Role_ROLE_USER, Content: buildMemoryInput(latest, known)},
+	}
+
+	// 直接转换消息，避免对话内容中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return nil, nil, fmt.Errorf("memory extraction call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, nil, status.Error(codes.Internal, "no response from memory extraction")
+	}
+
+	facts, err := parseUserFacts(resp.Choices[0].Content)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "invalid memory extraction response: %v", err)
+	}
+	return facts, p.ResponseUsage(messages, resp), nil
+}
+
+// buildMemoryInput 将已知事实和用户消息整理为提取调用的输入
+func buildMemoryInput(latest string, known []UserFact) string {
+	var b strings.Builder
+	if len(known) > 0 {
+		b.WriteString("=== KNOWN FACTS ===\n")
+		for _, f := range known {
+			fmt.Fprintf(&b, "- %s: %s\n", f.Category, f.Content)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("=== LATEST USER MESSAGE ===\n")
+	b.WriteString(latest)
+	return b.String()
+}
+
+// parseUserFacts 解析提取调用的 JSON 输出 (容忍 markdown 代码块)，
+// 丢弃未知类别、空的和过长的事实
+func parseUserFacts(content string) ([]UserFact, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in %q", content)
+	}
+
+	var out struct {
+		Facts []UserFact `json:"facts"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &out); err != nil {
+		return nil, err
+	}
+
+	facts := make([]UserFact, 0, len(out.Facts))
+	for _, f := range out.Facts {
+		f.Category = strings.ToLower(strings.TrimSpace(f.Category))
+		f.Content = strings.TrimSpace(f.Content)
+		if !slices.Contains(UserFactCategories, f.Category) || f.Content == "" || len([]rune(f.Content)) > maxUserFactLength {
+			continue
+		}
+		facts = append(facts, f)
+	}
+	return facts, nil
+}
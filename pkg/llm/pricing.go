@@ -0,0 +1,47 @@
+package llm
+
+// PricingCurrency 价格表使用的货币
+const PricingCurrency = "USD"
+
+// ModelPrice 模型每百万 token 的价格
+type ModelPrice struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+	// CachedInputPerMillion 是由上下文缓存提供的输入 token 的价格，为 0 时按输入价格计算
+	CachedInputPerMillion float64
+}
+
+// modelPrices Vertex AI 公开价格 (<=128k 上下文)，按前缀匹配模型名。
+// 缓存的输入 token 按输入价格的 25% 计费 (不含缓存存储费用)
+var modelPrices = map[string]ModelPrice{
+	"gemini-1.0-pro":   {InputPerMillion: 0.50, OutputPerMillion: 1.50},
+	"gemini-1.5-flash": {InputPerMillion: 0.075, OutputPerMillion: 0.30, CachedInputPerMillion: 0.01875},
+	"gemini-1.5-pro":   {InputPerMillion: 1.25, OutputPerMillion: 5.00, CachedInputPerMillion: 0.3125},
+	"gemini-2.0-flash": {InputPerMillion: 0.10, OutputPerMillion: 0.40, CachedInputPerMillion: 0.025},
+	"gemini-2.5-flash": {InputPerMillion: 0.30, OutputPerMillion: 2.50, CachedInputPerMillion: 0.075},
+	"gemini-2.5-pro":   {InputPerMillion: 1.25, OutputPerMillion: 10.00, CachedInputPerMillion: 0.3125},
+}
+
+// EstimateCost 根据价格表计算一次调用的费用，未知模型返回 false
+func EstimateCost(model string, inputTokens, outputTokens int64) (float64, bool) {
+	cost, _, ok := EstimateCostWithCache(model, inputTokens, 0, outputTokens)
+	return cost, ok
+}
+
+// EstimateCostWithCache 计算输入 token 中 cachedTokens 个由上下文缓存提供的调用的费用，
+// savings 是与全部按输入价格计费相比节省的费用，未知模型返回 false
+func EstimateCostWithCache(model string, inputTokens, cachedTokens, outputTokens int64) (cost, savings float64, ok bool) {
+	price, ok := lookupModel(modelPrices, model)
+	if !ok {
+		return 0, 0, false
+	}
+	cachedPrice := price.InputPerMillion
+	if price.CachedInputPerMillion > 0 {
+		cachedPrice = price.CachedInputPerMillion
+	}
+	cost = float64(inputTokens-cachedTokens)*price.InputPerMillion/1e6 +
+		float64(cachedTokens)*cachedPrice/1e6 +
+		float64(outputTokens)*price.OutputPerMillion/1e6
+	savings = float64(cachedTokens) * (price.InputPerMillion - cachedPrice) / 1e6
+	return cost, savings, true
+}
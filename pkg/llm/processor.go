@@ -2,6 +2,8 @@ package llm
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
 	"bitbucket.dentsplysirona.com/mirrors/langchaingo/prompts"
@@ -13,6 +15,7 @@ import (
 // Processor 封装 LLM 处理逻辑
 type Processor struct {
 	client Client
+	model  string
 }
 
 // Client 定义 LLM 客户端接口
@@ -20,10 +23,11 @@ type Client interface {
 	GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error)
 }
 
-// NewProcessor 创建新的 LLM 处理器
-func NewProcessor(client Client) *Processor {
+// NewProcessor 创建新的 LLM 处理器，model 用于选择估算 token 用量的分词器
+func NewProcessor(client Client, model string) *Processor {
 	return &Processor{
 		client: client,
+		model:  model,
 	}
 }
 
@@ -31,6 +35,19 @@ func NewProcessor(client Client) *Processor {
 type ProcessResult struct {
 	Content    string
 	TokenUsage *TokenUsage
+	// FinishReason 和 SafetyRatings 来自 provider 的响应
+	FinishReason  genaidemo.FinishReason
+	SafetyRatings []*genaidemo.SafetyRating
+	// Latency 是 LLM 调用耗时 (包括重试)
+	Latency time.Duration
+	// Candidates 是请求多个候选时 Content 之外的候选回复
+	Candidates []string
+	// Logprobs 是 provider 返回的回复 token 对数概率
+	Logprobs []*genaidemo.TokenLogprob
+	// ModelVersion 是 provider 报告的确切模型版本
+	ModelVersion string
+	// SafetyBlock 在 provider 拦截回复时设置，此时 Content 为空
+	SafetyBlock *genaidemo.SafetyBlock
 }
 
 // ProcessMessages 处理消息并生成响应
@@ -46,6 +63,8 @@ func (p *Processor) ProcessMessages(ctx context.Context, messages []*genaidemo.M
 	if maxTokens != nil {
 		options = append(options, llms.WithMaxTokens(int(*maxTokens)))
 	}
+	options = append(options, SamplingCallOptions(ctx)...)
+	options = append(options, SafetyCallOptions(ctx)...)
 
 	// 使用 prompts 格式化和调用 LLM
 	result, err := chatPrompt.FormatPrompt(map[string]any{})
@@ -66,9 +85,11 @@ func (p *Processor) ProcessMessages(ctx context.Context, messages []*genaidemo.M
 	}
 
 	// 调用 LLM
+	start := time.Now()
 	resp, err := p.client.GenerateContent(ctx, llmMessages, options...)
+	latency := time.Since(start)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "LLM call failed: %v", err)
+		return nil, fmt.Errorf("LLM call failed: %w", err)
 	}
 
 	// 提取响应
@@ -77,16 +98,31 @@ func (p *Processor) ProcessMessages(ctx context.Context, messages []*genaidemo.M
 	}
 
 	choice := resp.Choices[0]
-	if choice.Content == "" {
+	// 被安全设置拦截的回复作为结果返回，由调用方报告拦截原因
+	block := SafetyBlockFromChoice(choice)
+	if choice.Content == "" && block == nil {
 		return nil, status.Error(codes.Internal, "empty response from LLM")
 	}
 
-	// 估算 token 使用情况
-	tokenUsage := EstimateTokenUsage(messages, choice.Content)
+	tokenUsage := p.ResponseUsage(messages, resp)
+
+	var candidates []string
+	for _, candidate := range resp.Choices[1:] {
+		if candidate.Content != "" {
+			candidates = append(candidates, candidate.Content)
+		}
+	}
 
 	return &ProcessResult{
-		Content:    choice.Content,
-		TokenUsage: tokenUsage,
+		Content:       choice.Content,
+		TokenUsage:    tokenUsage,
+		FinishReason:  FinishReasonFromChoice(choice),
+		SafetyRatings: SafetyRatingsFromChoice(choice),
+		Latency:       latency,
+		Candidates:    candidates,
+		Logprobs:      LogprobsFromChoice(choice),
+		ModelVersion:  ModelVersionFromChoice(choice),
+		SafetyBlock:   block,
 	}, nil
 }
 
@@ -140,22 +176,14 @@ type TokenUsage struct {
 	InputTokens  int32
 	OutputTokens int32
 	TotalTokens  int32
+	// CachedTokens 是 InputTokens 中由上下文缓存提供的部分
+	CachedTokens int32
 }
 
-// EstimateTokens 估算消息的 token 数量
-func EstimateTokens(messages []*genaidemo.Message) int {
-	totalTokens := 0
-	for _, msg := range messages {
-		// 简单估算: 每4个字符约等于1个token
-		totalTokens += len(msg.Content) / 4
-	}
-	return totalTokens
-}
-
-// EstimateTokenUsage 估算 token 使用情况
-func EstimateTokenUsage(messages []*genaidemo.Message, responseContent string) *TokenUsage {
-	inputTokens := EstimateTokens(messages)
-	outputTokens := len(responseContent) / 4
+// EstimateTokenUsage 在 provider 未返回用量时使用模型对应的分词器估算 token 使用情况
+func EstimateTokenUsage(model string, messages []*genaidemo.Message, responseContent string) *TokenUsage {
+	inputTokens := CountMessageTokens(model, messages)
+	outputTokens := CountTokens(model, responseContent)
 	
 	return &TokenUsage{
 		InputTokens:  int32(inputTokens),
@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"context"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// MetadataSafetySettings 是请求安全设置的 CallOptions.Metadata 键，值为
+// 危害类别到拦截阈值的 map[string]string，例如 {"DANGEROUS_CONTENT": "BLOCK_ONLY_HIGH"}
+const MetadataSafetySettings = "safety_settings"
+
+type safetySettingsKey struct{}
+
+// WithSafetySettings 使 ctx 上的生成调用使用给定的安全设置
+func WithSafetySettings(ctx context.Context, settings map[string]string) context.Context {
+	return context.WithValue(ctx, safetySettingsKey{}, settings)
+}
+
+// SafetyCallOptions 返回 ctx 中安全设置对应的调用选项
+func SafetyCallOptions(ctx context.Context) []llms.CallOption {
+	settings, _ := ctx.Value(safetySettingsKey{}).(map[string]string)
+	if len(settings) == 0 {
+		return nil
+	}
+	return []llms.CallOption{withMetadata(MetadataSafetySettings, settings)}
+}
+
+// SafetyBlockFromChoice 返回被 provider 拦截的回复的拦截原因 (例如 SAFETY、RECITATION)
+// 和触发拦截的危害类别，回复未被拦截时为 nil
+func SafetyBlockFromChoice(choice *llms.ContentChoice) *genaidemo.SafetyBlock {
+	if choice == nil || choice.Content != "" || len(choice.ToolCalls) > 0 {
+		return nil
+	}
+	reason := FinishReasonFromChoice(choice)
+	switch reason {
+	case genaidemo.FinishReason_FINISH_REASON_SAFETY, genaidemo.FinishReason_FINISH_REASON_RECITATION:
+	default:
+		return nil
+	}
+
+	block := &genaidemo.SafetyBlock{Reason: strings.TrimPrefix(reason.String(), "FINISH_REASON_")}
+	for _, rating := range SafetyRatingsFromChoice(choice) {
+		if rating.Blocked {
+			block.Categories = append(block.Categories, rating.Category)
+		}
+	}
+	return block
+}
@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"context"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+)
+
+// SamplingOptions 请求的采样参数，未设置的字段使用 provider 的默认值
+type SamplingOptions struct {
+	TopP             *float32
+	TopK             *int32
+	StopSequences    []string
+	PresencePenalty  *float32
+	FrequencyPenalty *float32
+	// CandidateCount 大于 1 时 provider 生成多个候选回复，第一个作为回复内容
+	CandidateCount *int32
+	// Seed 使支持的 provider 对相同请求生成相同的回复
+	Seed *int32
+	// Logprobs 请求回复 token 的对数概率，TopLogprobs 为每个位置返回的候选 token 数
+	Logprobs    bool
+	TopLogprobs int32
+}
+
+type samplingOptionsKey struct{}
+
+// WithSamplingOptions 使 ctx 上的生成调用使用请求的采样参数
+func WithSamplingOptions(ctx context.Context, opts *SamplingOptions) context.Context {
+	return context.WithValue(ctx, samplingOptionsKey{}, opts)
+}
+
+// SamplingCallOptions 返回 ctx 中采样参数对应的调用选项，评估和转写等内部调用不使用
+func SamplingCallOptions(ctx context.Context) []llms.CallOption {
+	opts, _ := ctx.Value(samplingOptionsKey{}).(*SamplingOptions)
+	if opts == nil {
+		return nil
+	}
+
+	var options []llms.CallOption
+	if opts.TopP != nil {
+		options = append(options, llms.WithTopP(float64(*opts.TopP)))
+	}
+	if opts.TopK != nil {
+		options = append(options, llms.WithTopK(int(*opts.TopK)))
+	}
+	if len(opts.StopSequences) > 0 {
+		options = append(options, llms.WithStopWords(opts.StopSequences))
+	}
+	if opts.PresencePenalty != nil {
+		options = append(options, llms.WithPresencePenalty(float64(*opts.PresencePenalty)))
+	}
+	if opts.FrequencyPenalty != nil {
+		options = append(options, llms.WithFrequencyPenalty(float64(*opts.FrequencyPenalty)))
+	}
+	if opts.CandidateCount != nil {
+		options = append(options, llms.WithCandidateCount(int(*opts.CandidateCount)))
+	}
+	if opts.Seed != nil {
+		options = append(options, llms.WithSeed(int(*opts.Seed)))
+	}
+	if opts.Logprobs {
+		options = append(options, withMetadata(MetadataLogprobs, true), withMetadata(MetadataTopLogprobs, int(opts.TopLogprobs)))
+	}
+	return options
+}
+
+// withMetadata 设置 CallOptions.Metadata 的一个键，保留其他选项设置的键
+func withMetadata(key string, value any) llms.CallOption {
+	return func(o *llms.CallOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]any)
+		}
+		o.Metadata[key] = value
+	}
+}
@@ -0,0 +1,273 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxSummaryRounds 合并阶段的最大轮数，每轮将分段摘要再次分组摘要，
+	// 超出时输入过长无法摘要
+	MaxSummaryRounds = 3
+	// summaryWorkers 分段摘要的并发调用数
+	summaryWorkers = 4
+	// summaryOutputReserve 为每次调用的提示词和输出预留的 token 数
+	summaryOutputReserve = 2048
+)
+
+// SummaryLength 摘要的长度
+type SummaryLength string
+
+const (
+	SummaryShort  SummaryLength = "short"
+	SummaryMedium SummaryLength = "medium"
+	SummaryLong   SummaryLength = "long"
+)
+
+// SummaryStyle 摘要的风格
+type SummaryStyle string
+
+const (
+	// SummaryParagraph 连贯的段落
+	SummaryParagraph SummaryStyle = "paragraph"
+	// SummaryBullets 要点列表
+	SummaryBullets SummaryStyle = "bullets"
+	// SummaryExecutive 先给出结论和建议的管理层摘要
+	SummaryExecutive SummaryStyle = "executive"
+)
+
+// summaryLengths 各长度对应的提示词
+var summaryLengths = map[SummaryLength]string{
+	SummaryShort:  "Keep it very short: 2-3 sentences, or at most 3 bullet points.",
+	SummaryMedium: "Keep it concise: one paragraph of 5-8 sentences, or at most 8 bullet points.",
+	SummaryLong:   "Be thorough: several paragraphs, or up to 15 bullet points, covering every main section.",
+}
+
+// summaryStyles 各风格对应的提示词
+var summaryStyles = map[SummaryStyle]string{
+	SummaryParagraph: "Write flowing prose paragraphs.",
+	SummaryBullets:   `Write a markdown bullet list ("- "), one key point per bullet.`,
+	SummaryExecutive: "Write an executive summary: start with the main conclusion, then the key findings, then any recommended actions or open questions.",
+}
+
+// summaryPrompt 生成最终摘要的系统提示词
+const summaryPrompt = `You summarize documents.
+Summarize the text below faithfully: keep the key facts, figures, names and conclusions, and never add information that is not in the text.
+Write in the language of the text. Respond with the summary only, without a title or introduction.
+%s
+%s`
+
+// partialSummaryPrompt 分段摘要的系统提示词，保留合并阶段需要的细节
+const partialSummaryPrompt = `You summarize one part of a longer document; the parts' summaries are combined into a summary of the whole document later.
+Summarize the text below faithfully as dense notes: keep the key facts, figures, names, dates and conclusions, and never add information that is not in the text.
+Write in the language of the text. Respond with the notes only.`
+
+// SummaryOptions 摘要的长度、风格和单次调用的输入上限
+type SummaryOptions struct {
+	Length SummaryLength
+	Style  SummaryStyle
+	// MaxInputTokens 单次调用的输入 token 上限，通常是模型的上下文窗口。
+	// 超出的输入先分段摘要 (map)，再合并分段摘要 (reduce)
+	MaxInputTokens int
+}
+
+// SummaryResult 是摘要结果
+type SummaryResult struct {
+	Summary string
+	// Parts 是分段摘要的段数，输入可一次摘要时为 1
+	Parts int
+	// Calls 是模型调用次数
+	Calls int
+	// Usage 是所有调用的 token 用量之和
+	Usage *TokenUsage
+}
+
+// Summarize 按 opts 的长度和风格摘要文本。texts 是按顺序排列的文本段，
+// 例如文档的各个文档块；合起来超出单次调用上限时按上限重新分组，
+// 每组分别摘要后再合并，必要时逐轮合并直到可以一次摘要
+func (p *Processor) Summarize(ctx context.Context, texts []string, opts SummaryOptions) (*SummaryResult, error) {
+	lengthPrompt, ok := summaryLengths[opts.Length]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown summary length %q", opts.Length)
+	}
+	stylePrompt, ok := summaryStyles[opts.Style]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown summary style %q", opts.Style)
+	}
+	budget := opts.MaxInputTokens - summaryOutputReserve
+	if budget <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "input limit of %d tokens is too small to summarize", opts.MaxInputTokens)
+	}
+
+	result := &SummaryResult{Usage: &TokenUsage{}}
+	parts := p.groupTexts(texts, budget)
+	if len(parts) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "text cannot be empty")
+	}
+	result.Parts = len(parts)
+
+	for round := 0; len(parts) > 1; round++ {
+		if round == MaxSummaryRounds {
+			return nil, status.Errorf(codes.InvalidArgument, "text is too long to summarize: %d parts remain after %d rounds", len(parts), round)
+		}
+		summaries, err := p.summarizeParts(ctx, parts, result)
+		if err != nil {
+			return nil, err
+		}
+		parts = p.groupTexts(summaries, budget)
+	}
+
+	summary, usage, err := p.summarizeText(ctx, fmt.Sprintf(summaryPrompt, stylePrompt, lengthPrompt), parts[0])
+	if err != nil {
+		return nil, err
+	}
+	result.Summary = summary
+	result.Calls++
+	result.Usage.Add(usage)
+	return result, nil
+}
+
+// summarizeParts 并发摘要各段，结果顺序与输入一致
+func (p *Processor) summarizeParts(ctx context.Context, parts []string, result *SummaryResult) ([]string, error) {
+	summaries := make([]string, len(parts))
+	var mu sync.Mutex
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(summaryWorkers)
+	for i, part := range parts {
+		g.Go(func() error {
+			summary, usage, err := p.summarizeText(ctx, partialSummaryPrompt, part)
+			if err != nil {
+				return err
+			}
+			summaries[i] = summary
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Calls++
+			result.Usage.Add(usage)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// summarizeText 以 prompt 为系统提示词摘要一段文本
+func (p *Processor) summarizeText(ctx context.Context, prompt, text string) (string, *TokenUsage, error) {
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: prompt},
+		{Role: genaidemo.Role_ROLE_USER, Content: text},
+	}
+
+	// 直接转换消息，避免文本中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return "", nil, fmt.Errorf("summary call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", nil, status.Error(codes.Internal, "no response from summarization")
+	}
+
+	summary := strings.TrimSpace(resp.Choices[0].Content)
+	if summary == "" {
+		return "", nil, status.Error(codes.Internal, "empty summary")
+	}
+	return summary, p.ResponseUsage(messages, resp), nil
+}
+
+// groupTexts 将文本段按顺序合并为不超过 budget 个 token 的组，
+// 单个超出 budget 的文本段先被拆分。空白的文本段被忽略
+func (p *Processor) groupTexts(texts []string, budget int) []string {
+	var (
+		groups  []string
+		current strings.Builder
+		tokens  int
+	)
+	for _, text := range texts {
+		for _, piece := range p.splitText(strings.TrimSpace(text), budget, textSeparators) {
+			n := CountTokens(p.model, piece)
+			if current.Len() > 0 && tokens+n > budget {
+				groups = append(groups, current.String())
+				current.Reset()
+				tokens = 0
+			}
+			if current.Len() > 0 {
+				current.WriteString("\n\n")
+			}
+			current.WriteString(piece)
+			tokens += n
+		}
+	}
+	if current.Len() > 0 {
+		groups = append(groups, current.String())
+	}
+	return groups
+}
+
+// textSeparators 拆分超长文本时依次尝试的分隔符：段落、行、句子、词
+var textSeparators = []string{"\n\n", "\n", ". ", "。", " "}
+
+// splitText 将文本拆分为不超过 budget 个 token 的片段，依次尝试 separators 中的
+// 分隔符，都不可行时按字符对半拆分
+func (p *Processor) splitText(text string, budget int, separators []string) []string {
+	if text == "" {
+		return nil
+	}
+	if CountTokens(p.model, text) <= budget {
+		return []string{text}
+	}
+
+	for i, sep := range separators {
+		pieces := strings.SplitAfter(text, sep)
+		if len(pieces) < 2 {
+			continue
+		}
+		var (
+			out     []string
+			current string
+			tokens  int
+		)
+		// 只有单个片段就超出 budget 时才用后面的分隔符继续拆分
+		flush := func() {
+			if current = strings.TrimSpace(current); current == "" {
+				return
+			}
+			if tokens > budget {
+				out = append(out, p.splitText(current, budget, separators[i+1:])...)
+			} else {
+				out = append(out, current)
+			}
+		}
+		// 相邻的片段合并到接近 budget，避免产生大量很短的片段
+		for _, piece := range pieces {
+			n := CountTokens(p.model, piece)
+			if current != "" && tokens+n > budget {
+				flush()
+				current, tokens = "", 0
+			}
+			current += piece
+			tokens += n
+		}
+		flush()
+		return out
+	}
+
+	runes := []rune(text)
+	if len(runes) < 2 {
+		return []string{text}
+	}
+	half := len(runes) / 2
+	return append(p.splitText(string(runes[:half]), budget, nil), p.splitText(string(runes[half:]), budget, nil)...)
+}
@@ -0,0 +1,33 @@
+package llm
+
+import (
+	"text/template"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/prompts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ValidateTemplate 校验模板语法 (Go template 格式，例如 "Hello {{.name}}")
+func ValidateTemplate(tmpl string) error {
+	if _, err := template.New("prompt").Parse(tmpl); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid template: %v", err)
+	}
+	return nil
+}
+
+// RenderTemplate 使用 prompts 包渲染模板，缺失的变量会返回错误
+func RenderTemplate(tmpl string, variables map[string]string) (string, error) {
+	inputVariables := make([]string, 0, len(variables))
+	values := make(map[string]any, len(variables))
+	for name, value := range variables {
+		inputVariables = append(inputVariables, name)
+		values[name] = value
+	}
+
+	rendered, err := prompts.NewPromptTemplate(tmpl, inputVariables).Format(values)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "failed to render template: %v", err)
+	}
+	return rendered, nil
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaxTitleLength 会话标题的最大字符数，超出的部分被截断
+const MaxTitleLength = 80
+
+// titlePrompt 生成会话标题的系统提示词，要求只输出标题
+const titlePrompt = `You name conversations for the history sidebar of a chat application.
+Write a short title of at most 6 words for the conversation below, in the language of the user's message,
+e.g. "Reset a forgotten VPN password" or "Quarterly sales report summary".
+Respond with the title only, without quotes, punctuation at the end or any other text.`
+
+// GenerateTitle 根据会话的第一轮对话 (用户消息和回复) 生成简短的标题，
+// 返回标题以及生成调用的 token 用量
+func (p *Processor) GenerateTitle(ctx context.Context, query, reply string) (string, *TokenUsage, error) {
+	if strings.TrimSpace(query) == "" {
+		return "", nil, status.Error(codes.InvalidArgument, "query cannot be empty")
+	}
+
+	messages := []*genaidemo.Message{
+		{Role: genaidemo.Role_ROLE_SYSTEM, Content: titlePrompt},
+		{Role: genaidemo.Role_ROLE_USER, Content: "=== USER ===\n" + query + "\n\n=== ASSISTANT ===\n" + reply},
+	}
+
+	// 直接转换消息，避免对话内容中的花括号被当作模板变量
+	resp, err := p.client.GenerateContent(ctx, ConvertToLangchainMessages(messages), llms.WithTemperature(0))
+	if err != nil {
+		return "", nil, fmt.Errorf("title call failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", nil, status.Error(codes.Internal, "no response from title generation")
+	}
+
+	title := parseTitle(resp.Choices[0].Content)
+	if title == "" {
+		return "", nil, status.Error(codes.Internal, "empty title")
+	}
+	return title, p.ResponseUsage(messages, resp), nil
+}
+
+// parseTitle 取输出的第一行非空文本，去掉引号、markdown 标记和结尾的标点，
+// 并截断到 MaxTitleLength 个字符
+func parseTitle(content string) string {
+	var title string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			title = line
+			break
+		}
+	}
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(title, " \t\"'`*#“”「」")
+	title = strings.TrimRight(title, ".。!！")
+	if runes := []rune(title); len(runes) > MaxTitleLength {
+		title = strings.TrimSpace(string(runes[:MaxTitleLength]))
+	}
+	return title
+}
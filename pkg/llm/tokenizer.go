@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Gemini 使用的 SentencePiece 词表没有 Go 实现，使用词表规模相近的 cl100k_base 近似，
+// 对中文等非英文文本比按字符数估算准确得多
+const defaultEncoding = tiktoken.MODEL_CL100K_BASE
+
+// 每条消息的角色和分隔符额外占用的 token 数
+const tokensPerMessage = 4
+
+// 使用随程序打包的词表，避免首次统计时在持有 encodingsMu 的情况下下载词表，
+// 在出口受限的环境中阻塞所有请求
+func init() {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*tiktoken.Tiktoken)
+)
+
+// CountTokens 使用与模型匹配的分词器统计 token 数。分词器不可用时 (例如打包的词表中没有该编码)
+// 退回到按字符估算。
+func CountTokens(model, text string) int {
+	if text == "" {
+		return 0
+	}
+
+	enc := encodingForModel(model)
+	if enc == nil {
+		return approximateTokens(text)
+	}
+	return len(enc.Encode(text, nil, nil))
+}
+
+// CountMessageTokens 统计一组消息的 token 数，包含每条消息的格式开销
+func CountMessageTokens(model string, messages []*genaidemo.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += tokensPerMessage + CountTokens(model, msg.Content)
+	}
+	return total
+}
+
+// encodingForModel 返回模型对应的编码，结果按编码名缓存；加载失败时返回 nil
+func encodingForModel(model string) *tiktoken.Tiktoken {
+	name := defaultEncoding
+	if !strings.HasPrefix(model, "gemini") {
+		if encName, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+			name = encName
+		}
+	}
+
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if enc, ok := encodings[name]; ok {
+		return enc
+	}
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		log.Printf("⚠️ [llm] Tokenizer %s unavailable, falling back to approximate token counts: %v", name, err)
+	}
+	// 失败时也缓存 nil，避免每次请求都重新尝试加载词表
+	encodings[name] = enc
+	return enc
+}
+
+// approximateTokens 按字符估算 token 数: 英文约 4 字符 1 个 token，其他文字约 1 字符 1 个 token
+func approximateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
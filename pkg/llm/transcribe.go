@@ -0,0 +1,45 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// transcribePrompt 指示模型只输出音频的逐字转写
+const transcribePrompt = "Transcribe the spoken content of this audio verbatim. Respond with the transcription text only, without any commentary."
+
+// TranscribeAudio 使用多模态模型将音频转写为文本
+func (p *Processor) TranscribeAudio(ctx context.Context, data []byte, mimeType string) (string, error) {
+	if len(data) == 0 {
+		return "", status.Error(codes.InvalidArgument, "audio data cannot be empty")
+	}
+	if !strings.HasPrefix(mimeType, "audio/") {
+		return "", status.Errorf(codes.InvalidArgument, "unsupported audio mime type: %q", mimeType)
+	}
+
+	llmMessages := []llms.MessageContent{
+		{
+			Role: llms.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{
+				llms.BinaryPart(mimeType, data),
+				llms.TextPart(transcribePrompt),
+			},
+		},
+	}
+
+	resp, err := p.client.GenerateContent(ctx, llmMessages, llms.WithTemperature(0))
+	if err != nil {
+		return "", fmt.Errorf("audio transcription failed: %w", err)
+	}
+
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Content) == "" {
+		return "", status.Error(codes.Internal, "empty transcription from LLM")
+	}
+
+	return strings.TrimSpace(resp.Choices[0].Content), nil
+}
@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// GenerationInfo 中 provider 返回的 token 用量字段 (Gemini/VertexAI)
+const (
+	generationInfoInputTokens  = "input_tokens"
+	generationInfoOutputTokens = "output_tokens"
+	generationInfoTotalTokens  = "total_tokens"
+	generationInfoCachedTokens = "cached_tokens"
+)
+
+// TokenUsageFromChoice 从 provider 返回的 GenerationInfo 中读取实际 token 用量，
+// 未返回用量时 ok 为 false
+func TokenUsageFromChoice(choice *llms.ContentChoice) (usage *TokenUsage, ok bool) {
+	if choice == nil || choice.GenerationInfo == nil {
+		return nil, false
+	}
+
+	input, hasInput := toInt32(choice.GenerationInfo[generationInfoInputTokens])
+	output, hasOutput := toInt32(choice.GenerationInfo[generationInfoOutputTokens])
+	if !hasInput && !hasOutput {
+		return nil, false
+	}
+
+	total, hasTotal := toInt32(choice.GenerationInfo[generationInfoTotalTokens])
+	if !hasTotal {
+		total = input + output
+	}
+
+	cached, _ := toInt32(choice.GenerationInfo[generationInfoCachedTokens])
+
+	return &TokenUsage{
+		InputTokens:  input,
+		OutputTokens: output,
+		TotalTokens:  total,
+		CachedTokens: cached,
+	}, true
+}
+
+// ResponseUsage 返回一次 LLM 调用的 token 用量，优先使用 provider 返回的实际用量，
+// 缺失时按模型分词器估算 (输出包含文本内容和工具调用参数)
+func (p *Processor) ResponseUsage(messages []*genaidemo.Message, resp *llms.ContentResponse) *TokenUsage {
+	if resp == nil || len(resp.Choices) == 0 {
+		return EstimateTokenUsage(p.model, messages, "")
+	}
+
+	choice := resp.Choices[0]
+	if usage, ok := TokenUsageFromChoice(choice); ok {
+		return usage
+	}
+
+	output := choice.Content
+	for _, toolCall := range choice.ToolCalls {
+		if toolCall.FunctionCall != nil {
+			output += toolCall.FunctionCall.Name + toolCall.FunctionCall.Arguments
+		}
+	}
+	return EstimateTokenUsage(p.model, messages, output)
+}
+
+// Add 累加另一次调用的 token 用量
+func (u *TokenUsage) Add(other *TokenUsage) {
+	if other == nil {
+		return
+	}
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.TotalTokens += other.TotalTokens
+	u.CachedTokens += other.CachedTokens
+}
+
+// toInt32 转换 GenerationInfo 中的数值，不同 provider 使用的类型不同
+func toInt32(v any) (int32, bool) {
+	switch n := v.(type) {
+	case int32:
+		return n, true
+	case int:
+		return int32(n), true
+	case int64:
+		return int32(n), true
+	case float64:
+		return int32(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// userGroupsHeader and userRolesHeader name the end user's groups and roles
+// on requests of delegating keys, comma separated. Lower cased, they are the
+// gRPC metadata keys.
+const (
+	userGroupsHeader = "X-User-Groups"
+	userRolesHeader  = "X-User-Roles"
+)
+
+// principalNamePattern matches group and role names, which are stored in
+// chunk metadata keys and comma-separated lists
+var principalNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.@-]{1,64}$`)
+
+// principalConfig is the document access of one API key in the ACL file
+type principalConfig struct {
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// AllDocuments lets the key retrieve every document, e.g. for admin tools
+	AllDocuments bool `json:"all_documents,omitempty"`
+	// Delegate lets the key act for end users it authenticated itself, named
+	// with the X-User-Groups and X-User-Roles headers. Requests without them
+	// get the key's own access.
+	Delegate bool `json:"delegate,omitempty"`
+}
+
+// documentAccess is what a caller may retrieve: public documents, and those
+// shared with one of its groups or roles
+type documentAccess struct {
+	Groups []string `json:"groups"`
+	Roles  []string `json:"roles"`
+}
+
+// fingerprint identifies the access in cache keys, empty for callers who may
+// retrieve every document
+func (a *documentAccess) fingerprint() string {
+	if a == nil {
+		return ""
+	}
+	return "groups=" + strings.Join(a.Groups, ",") + ";roles=" + strings.Join(a.Roles, ",")
+}
+
+// aclRegistry resolves the document access of callers from their API key
+type aclRegistry struct {
+	// principals is nil when access control is disabled
+	principals map[string]*principalConfig
+}
+
+// newACLRegistry loads the ACL file, a JSON object mapping API key ids to
+// their access, e.g.
+//
+//	{"key_ab12cd34ef56ab78": {"groups": ["engineering"], "roles": ["manager"]}, "key_0011223344556677": {"delegate": true}}
+//
+// Keys missing from the file only retrieve public documents. An empty path
+// disables access control.
+func newACLRegistry(path string) (*aclRegistry, error) {
+	r := &aclRegistry{}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL file: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.principals); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL file: %w", err)
+	}
+	if r.principals == nil {
+		r.principals = make(map[string]*principalConfig)
+	}
+	for keyID, p := range r.principals {
+		if p == nil {
+			return nil, fmt.Errorf("ACL file: %s has no access", keyID)
+		}
+		for _, name := range slices.Concat(p.Groups, p.Roles) {
+			if !principalNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("ACL file: %s: invalid group or role %q", keyID, name)
+			}
+		}
+	}
+	log.Printf("🔐 Document access control configured for %d API keys", len(r.principals))
+	return r, nil
+}
+
+// resolve returns the access of a caller with the given API key id and
+// delegated user groups and roles, nil when it may retrieve every document.
+// Naming a user with a key that can't delegate is PermissionDenied.
+func (r *aclRegistry) resolve(keyID, groups, roles string) (*documentAccess, error) {
+	if r.principals == nil {
+		return nil, nil
+	}
+	p := r.principals[keyID]
+	if groups != "" || roles != "" {
+		if p == nil || !p.Delegate {
+			return nil, errorWithReason(codes.PermissionDenied, reasonDelegationDenied, map[string]string{"key_id": keyID},
+				fmt.Sprintf("%s may not act for end users", keyID))
+		}
+		userGroups, err := parsePrincipalNames(groups)
+		if err != nil {
+			return nil, err
+		}
+		userRoles, err := parsePrincipalNames(roles)
+		if err != nil {
+			return nil, err
+		}
+		return &documentAccess{Groups: userGroups, Roles: userRoles}, nil
+	}
+	if p == nil {
+		return &documentAccess{}, nil
+	}
+	if p.AllDocuments {
+		return nil, nil
+	}
+	return &documentAccess{Groups: slices.Sorted(slices.Values(p.Groups)), Roles: slices.Sorted(slices.Values(p.Roles))}, nil
+}
+
+// parsePrincipalNames parses a comma-separated header of group or role names
+// into a sorted list
+func parsePrincipalNames(header string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(header, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !principalNamePattern.MatchString(name) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid group or role %q", name)
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// userFromMetadata returns the end user's groups and roles named in the
+// x-user-groups and x-user-roles gRPC metadata
+func userFromMetadata(ctx context.Context) (groups, roles string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return strings.Join(md.Get(userGroupsHeader), ","), strings.Join(md.Get(userRolesHeader), ",")
+}
+
+type documentAccessKey struct{}
+
+// withDocumentAccess attaches the caller's document access to the context
+func withDocumentAccess(ctx context.Context, access *documentAccess) context.Context {
+	return context.WithValue(ctx, documentAccessKey{}, access)
+}
+
+// documentAccessFromContext returns the caller's document access, nil when it
+// may retrieve every document
+func documentAccessFromContext(ctx context.Context) *documentAccess {
+	access, _ := ctx.Value(documentAccessKey{}).(*documentAccess)
+	return access
+}
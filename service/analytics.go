@@ -0,0 +1,312 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// analyticsMetrics counts recorded and classified conversations, failed
+// classification calls and their tokens, exported under /api/metrics
+var analyticsMetrics = expvar.NewMap("analytics")
+
+// conversationRecord is a served conversation kept for analytics: the last
+// user message and the reply, truncated, with the labels of the analytics
+// job once classified
+type conversationRecord struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+	Mode   string    `json:"mode"`
+	Query  string    `json:"query"`
+	Reply  string    `json:"reply"`
+	// Intent is the intent classified for the request, empty when unknown
+	Intent string `json:"intent,omitempty"`
+	// Labels are empty until the conversation is classified
+	Labels llm.ConversationLabels `json:"labels"`
+}
+
+// classifier labels a batch of conversations with one of topics
+type classifier func(ctx context.Context, samples []llm.ConversationSample, topics []string) ([]llm.ConversationLabels, *llm.TokenUsage, error)
+
+// conversationAnalytics keeps the conversations served for the retention
+// period and classifies them in the background by topic, sentiment and
+// resolution, optionally persisting them to a JSON file after every run so
+// they survive restarts. Records are immutable once stored; labelling
+// replaces them.
+type conversationAnalytics struct {
+	mu        sync.Mutex
+	records   []*conversationRecord
+	path      string
+	interval  time.Duration
+	retention time.Duration
+	topics    []string
+	classify  classifier
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newConversationAnalytics creates the analytics store, loading existing
+// records from path when it is set, and starts the analytics job classifying
+// new conversations every interval. A zero interval disables analytics.
+func newConversationAnalytics(path string, interval, retention time.Duration, topics []string, classify classifier) (*conversationAnalytics, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &conversationAnalytics{
+		path:      path,
+		interval:  interval,
+		retention: retention,
+		topics:    topics,
+		classify:  classify,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if !a.enabled() {
+		return a, nil
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			cancel()
+			return nil, fmt.Errorf("failed to read analytics: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &a.records); err != nil {
+				cancel()
+				return nil, fmt.Errorf("failed to parse analytics: %w", err)
+			}
+			log.Printf("📈 Loaded %d conversations from %s", len(a.records), path)
+		}
+	}
+
+	a.wg.Add(1)
+	go a.run()
+	return a, nil
+}
+
+// enabled reports whether served conversations are recorded and classified
+func (a *conversationAnalytics) enabled() bool {
+	return a.interval > 0
+}
+
+// record keeps a served conversation for the next analytics run
+func (a *conversationAnalytics) record(ctx context.Context, mode genaidemo.Mode, messages []*genaidemo.Message, reply string) {
+	if !a.enabled() {
+		return
+	}
+	var query string
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == genaidemo.Role_ROLE_USER {
+			query = messages[i].Content
+			break
+		}
+	}
+	if query == "" {
+		return
+	}
+	id, err := newJobID()
+	if err != nil {
+		return
+	}
+
+	r := &conversationRecord{
+		ID:     id,
+		Time:   time.Now(),
+		Tenant: tenantID(ctx),
+		Mode:   mode.String(),
+		Query:  truncateUTF8(query, MaxAnalyticsTextBytes),
+		Reply:  truncateUTF8(reply, MaxAnalyticsTextBytes),
+	}
+	if intent := intentFromContext(ctx); intent != nil {
+		r.Intent = intent.Name
+	}
+	a.mu.Lock()
+	a.records = append(a.records, r)
+	if len(a.records) > MaxAnalyticsConversations {
+		a.records = slices.Clone(a.records[len(a.records)-MaxAnalyticsConversations:])
+	}
+	a.mu.Unlock()
+	analyticsMetrics.Add("recorded", 1)
+}
+
+// run is the analytics job: every interval it classifies the conversations
+// recorded since the last run, until the store is closed
+func (a *conversationAnalytics) run() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			a.classifyPending(a.ctx)
+		}
+	}
+}
+
+// classifyPending drops the records past retention, classifies the
+// unlabelled ones in batches of AnalyticsBatchSize and saves the store.
+// Conversations whose batch failed are retried on the next run.
+func (a *conversationAnalytics) classifyPending(ctx context.Context) {
+	a.mu.Lock()
+	cutoff := time.Now().Add(-a.retention)
+	a.records = slices.DeleteFunc(slices.Clone(a.records), func(r *conversationRecord) bool {
+		return r.Time.Before(cutoff)
+	})
+	var pending []*conversationRecord
+	for _, r := range a.records {
+		if r.Labels.Topic == "" {
+			pending = append(pending, r)
+		}
+	}
+	a.mu.Unlock()
+
+	classified := 0
+	for batch := range slices.Chunk(pending, AnalyticsBatchSize) {
+		if ctx.Err() != nil {
+			break
+		}
+		samples := make([]llm.ConversationSample, len(batch))
+		for i, r := range batch {
+			samples[i] = llm.ConversationSample{Query: r.Query, Reply: r.Reply}
+		}
+		labels, usage, err := a.classify(ctx, samples, a.topics)
+		if err != nil {
+			analyticsMetrics.Add("errors", 1)
+			log.Printf("⚠️ [analytics] Failed to classify %d conversations: %v", len(batch), err)
+			continue
+		}
+		if usage != nil {
+			analyticsMetrics.Add("input_tokens", int64(usage.InputTokens))
+			analyticsMetrics.Add("output_tokens", int64(usage.OutputTokens))
+		}
+
+		labelled := make(map[string]llm.ConversationLabels, len(batch))
+		for i, r := range batch {
+			if labels[i].Topic != "" {
+				labelled[r.ID] = labels[i]
+			}
+		}
+		a.mu.Lock()
+		records := slices.Clone(a.records)
+		for i, r := range records {
+			if l, ok := labelled[r.ID]; ok {
+				updated := *r
+				updated.Labels = l
+				records[i] = &updated
+			}
+		}
+		a.records = records
+		a.mu.Unlock()
+		classified += len(labelled)
+	}
+	analyticsMetrics.Add("classified", int64(classified))
+	if classified > 0 {
+		log.Printf("📈 [analytics] Classified %d of %d conversations", classified, len(pending))
+	}
+
+	if err := a.save(); err != nil {
+		log.Printf("⚠️ [analytics] Failed to save conversations: %v", err)
+	}
+}
+
+// aggregate sums up the conversations of a tenant served within [from, to);
+// zero times leave the range open
+func (a *conversationAnalytics) aggregate(tenant string, from, to time.Time) *genaidemo.ConversationAnalytics {
+	a.mu.Lock()
+	records := a.records
+	a.mu.Unlock()
+
+	out := &genaidemo.ConversationAnalytics{
+		Modes:       make(map[string]int64),
+		Sentiments:  make(map[string]int64),
+		Resolutions: make(map[string]int64),
+		Intents:     make(map[string]int64),
+	}
+	topics := make(map[string]*genaidemo.TopicAnalytics)
+	for _, r := range records {
+		if r.Tenant != tenant || (!from.IsZero() && r.Time.Before(from)) || (!to.IsZero() && !r.Time.Before(to)) {
+			continue
+		}
+		out.Conversations++
+		out.Modes[r.Mode]++
+		if r.Intent != "" {
+			out.Intents[r.Intent]++
+		}
+		if r.Labels.Topic == "" {
+			continue
+		}
+		out.Classified++
+		out.Sentiments[r.Labels.Sentiment]++
+		out.Resolutions[r.Labels.Resolution]++
+
+		t, ok := topics[r.Labels.Topic]
+		if !ok {
+			t = &genaidemo.TopicAnalytics{
+				Topic:       r.Labels.Topic,
+				Modes:       make(map[string]int64),
+				Sentiments:  make(map[string]int64),
+				Resolutions: make(map[string]int64),
+			}
+			topics[r.Labels.Topic] = t
+		}
+		t.Conversations++
+		t.Modes[r.Mode]++
+		t.Sentiments[r.Labels.Sentiment]++
+		t.Resolutions[r.Labels.Resolution]++
+	}
+
+	for _, t := range topics {
+		out.Topics = append(out.Topics, t)
+	}
+	slices.SortFunc(out.Topics, func(a, b *genaidemo.TopicAnalytics) int {
+		return cmp.Or(cmp.Compare(b.Conversations, a.Conversations), strings.Compare(a.Topic, b.Topic))
+	})
+	return out
+}
+
+// close stops the analytics job and saves the conversations recorded since
+// its last run
+func (a *conversationAnalytics) close() {
+	a.cancel()
+	a.wg.Wait()
+	if !a.enabled() {
+		return
+	}
+	if err := a.save(); err != nil {
+		log.Printf("⚠️ [analytics] Failed to save conversations: %v", err)
+	}
+}
+
+// save writes all records to the store file
+func (a *conversationAnalytics) save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	data, err := json.Marshal(a.records)
+	a.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal analytics: %w", err)
+	}
+	if err := writeFileAtomic(a.path, data); err != nil {
+		return fmt.Errorf("failed to save analytics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Budget policies applied once an API key has spent its monthly budget
+const (
+	// budgetPolicyReject rejects further requests with ResourceExhausted
+	budgetPolicyReject = "reject"
+	// budgetPolicyDowngrade serves further requests with a cheaper model
+	budgetPolicyDowngrade = "downgrade"
+)
+
+// budgetEnforcer limits the monthly spend of each API key, based on the cost
+// recorded by the usage tracker
+type budgetEnforcer struct {
+	usage          *usageTracker
+	defaultBudget  float64
+	budgets        map[string]float64
+	policy         string
+	downgradeModel string
+}
+
+// newBudgetEnforcer creates a budget enforcer. Keys without an entry in
+// budgets use defaultBudget; a zero budget means unlimited.
+func newBudgetEnforcer(usage *usageTracker, defaultBudget float64, budgets map[string]float64, policy, downgradeModel string) *budgetEnforcer {
+	if defaultBudget > 0 || len(budgets) > 0 {
+		log.Printf("💰 Monthly budgets enabled (default %.2f, %d per-key budgets, policy %s)", defaultBudget, len(budgets), policy)
+	}
+	return &budgetEnforcer{
+		usage:          usage,
+		defaultBudget:  defaultBudget,
+		budgets:        budgets,
+		policy:         policy,
+		downgradeModel: downgradeModel,
+	}
+}
+
+// budgetFor returns the monthly budget of an API key, false when unlimited
+func (b *budgetEnforcer) budgetFor(keyID string) (float64, bool) {
+	budget, ok := b.budgets[keyID]
+	if !ok {
+		budget = b.defaultBudget
+	}
+	return budget, budget > 0
+}
+
+// remaining returns the monthly budget of an API key and what is left of it
+// in the current calendar month (UTC)
+func (b *budgetEnforcer) remaining(keyID string) (budget, remaining float64, ok bool) {
+	budget, ok = b.budgetFor(keyID)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return budget, max(budget-b.monthlySpend(keyID), 0), true
+}
+
+// monthlySpend returns the cost recorded under a usage key in the current
+// calendar month (UTC). With Redis, that is the spend of all replicas; this
+// process's own spend is still a lower bound when Redis missed some of it.
+func (b *budgetEnforcer) monthlySpend(usageKey string) float64 {
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var spent float64
+	for _, record := range b.usage.query(usageKey, monthStart, time.Time{}) {
+		spent += record.Cost
+	}
+	if shared, ok := b.usage.sharedMonthlySpend(usageKey); ok {
+		spent = max(spent, shared)
+	}
+	return spent
+}
+
+// checkTenant returns ResourceExhausted once a tenant has spent its monthly
+// budget. Tenant budgets always reject, whatever the key budget policy.
+func (b *budgetEnforcer) checkTenant(t *tenant) error {
+	if t == nil || t.monthlyBudget <= 0 {
+		return nil
+	}
+	if b.monthlySpend(t.usageKey()) < t.monthlyBudget {
+		return nil
+	}
+	return errorWithReason(codes.ResourceExhausted, reasonBudgetExceeded, map[string]string{"tenant": t.id},
+		fmt.Sprintf("monthly budget of %.2f exhausted for tenant %s", t.monthlyBudget, t.id))
+}
+
+// modelFor returns the model to serve the API key with: the given model while
+// budget remains, otherwise the downgrade model or a ResourceExhausted error
+// depending on the policy
+func (b *budgetEnforcer) modelFor(keyID, model string) (string, error) {
+	budget, remaining, ok := b.remaining(keyID)
+	if !ok || remaining > 0 {
+		return model, nil
+	}
+
+	if b.policy == budgetPolicyDowngrade && b.downgradeModel != "" {
+		log.Printf("💰 [budgetEnforcer] %s exhausted its monthly budget, downgrading to %s", keyID, b.downgradeModel)
+		return b.downgradeModel, nil
+	}
+	return "", errorWithReason(codes.ResourceExhausted, reasonBudgetExceeded, map[string]string{"key_id": keyID},
+		fmt.Sprintf("monthly budget of %.2f exhausted for %s", budget, keyID))
+}
+
+type modelOverrideKey struct{}
+
+// withModelOverride makes provider calls made with ctx use the given model
+func withModelOverride(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelOverrideKey{}, model)
+}
+
+// modelFromContext returns the model override of ctx, or def when unset
+func modelFromContext(ctx context.Context, def string) string {
+	if model, ok := ctx.Value(modelOverrideKey{}).(string); ok {
+		return model
+	}
+	return def
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+)
+
+// capabilityRegistry is the capability matrix of the models the service may
+// call: the built-in one of pkg/llm, with models added or replaced by the
+// model capabilities file
+type capabilityRegistry struct {
+	models map[string]llm.ModelCapabilities
+}
+
+// newCapabilityRegistry loads the model capabilities file, a JSON object
+// mapping model names to their capabilities, e.g.
+//
+//	{"my-tuned-gemini": {"tools": true, "streaming": true, "context_window": 32768}}
+//
+// Models in the file replace the built-in entries of the same name. An empty
+// path uses the built-in matrix only.
+func newCapabilityRegistry(path string) (*capabilityRegistry, error) {
+	r := &capabilityRegistry{models: make(map[string]llm.ModelCapabilities)}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model capabilities file: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.models); err != nil {
+		return nil, fmt.Errorf("failed to parse model capabilities file: %w", err)
+	}
+	for model, caps := range r.models {
+		if caps.ContextWindow < 0 {
+			return nil, fmt.Errorf("model capabilities file: %s: context_window must not be negative", model)
+		}
+		log.Printf("🧩 Capabilities of %s: %+v", model, caps)
+	}
+	return r, nil
+}
+
+// lookup returns the capabilities of a model, and false when it is unknown
+func (r *capabilityRegistry) lookup(model string) (llm.ModelCapabilities, bool) {
+	if caps, ok := r.models[model]; ok {
+		return caps, true
+	}
+	return llm.Capabilities(model)
+}
+
+// contextWindow returns the input token limit of a model, a conservative
+// default when it is unknown
+func (r *capabilityRegistry) contextWindow(model string) int {
+	if caps, ok := r.models[model]; ok && caps.ContextWindow > 0 {
+		return caps.ContextWindow
+	}
+	return llm.ContextWindow(model)
+}
+
+// checkConfigured validates the configured models at startup: generation
+// models must not be embedding models and the embedding model must be one.
+// Unknown models are assumed to be configured correctly.
+func (r *capabilityRegistry) checkConfigured(generationModels []string, embeddingModel string) error {
+	for _, model := range generationModels {
+		if caps, ok := r.lookup(model); ok && caps.Embeddings {
+			return fmt.Errorf("model %s is an embedding model and can't generate content", model)
+		}
+	}
+	if caps, ok := r.lookup(embeddingModel); ok && !caps.Embeddings {
+		return fmt.Errorf("embedding model %s is not an embedding model", embeddingModel)
+	}
+	return nil
+}
+
+// adapt checks a request to an endpoint against the capabilities of the
+// model serving it. Requests the model can't serve fail with
+// INVALID_ARGUMENT rather than a provider error; ChatWithTool requests to
+// models without function calling are answered without tools instead.
+func (r *capabilityRegistry) adapt(ctx context.Context, mode genaidemo.Mode, model string) (context.Context, error) {
+	caps, ok := r.lookup(model)
+	if !ok {
+		return ctx, nil
+	}
+	if caps.Embeddings {
+		return nil, errorWithReason(codes.InvalidArgument, reasonModelUnsupported,
+			map[string]string{"model": model, "feature": "generation"},
+			fmt.Sprintf("model %s is an embedding model and can't generate content", model))
+	}
+	if mode == genaidemo.Mode_MODE_TOOL && !caps.Tools {
+		log.Printf("🧩 [%s] Model %s doesn't support function calling, answering without tools", mode, model)
+		return withToolsDisabled(ctx), nil
+	}
+	return ctx, nil
+}
+
+type toolsDisabledKey struct{}
+
+// withToolsDisabled makes ChatWithTool answer without offering tools
+func withToolsDisabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, toolsDisabledKey{}, true)
+}
+
+// toolsDisabled reports whether ChatWithTool must answer without tools
+func toolsDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(toolsDisabledKey{}).(bool)
+	return disabled
+}
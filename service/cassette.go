@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cassette 模式
+const (
+	cassetteModeRecord = "record"
+	cassetteModeReplay = "replay"
+)
+
+// cassetteLLM 录制/回放 LLM 调用的 IVertexAI 包装器。录制模式下调用真实 provider
+// 并把请求和响应写入 cassette 文件；回放模式下按请求内容从文件返回录制的响应，
+// 不访问 provider，未录制的请求返回错误。用于对 ChatWithDoc/ChatWithTool 做
+// 不依赖模型随机性的 golden 测试。
+type cassetteLLM struct {
+	inner IVertexAI
+	mode  string
+	path  string
+
+	mu           sync.Mutex
+	interactions map[string]*cassetteInteraction
+}
+
+// cassetteFile cassette 文件格式
+type cassetteFile struct {
+	Interactions []*cassetteInteraction `json:"interactions"`
+}
+
+// cassetteInteraction 一次录制的调用，Request 为可读的请求摘要，Key 为其哈希
+type cassetteInteraction struct {
+	Kind       string                `json:"kind"`
+	Key        string                `json:"key"`
+	Request    string                `json:"request"`
+	Response   *llms.ContentResponse `json:"response,omitempty"`
+	Text       string                `json:"text,omitempty"`
+	Embeddings [][]float32           `json:"embeddings,omitempty"`
+	// ToolCalls 按 choice 保存 Response 中的工具调用。llms.ToolCall 的 JSON
+	// 反序列化会丢失 FunctionCall，回放时从这里恢复
+	ToolCalls [][]cassetteToolCall `json:"tool_calls,omitempty"`
+}
+
+// cassetteToolCall 一次录制的工具调用
+type cassetteToolCall struct {
+	ID           string             `json:"id"`
+	Type         string             `json:"type"`
+	FunctionCall *llms.FunctionCall `json:"function,omitempty"`
+}
+
+// recordToolCalls 把 Response 中的工具调用保存到 ToolCalls
+func (in *cassetteInteraction) recordToolCalls() {
+	in.ToolCalls = nil
+	if in.Response == nil || !slices.ContainsFunc(in.Response.Choices, func(c *llms.ContentChoice) bool { return len(c.ToolCalls) > 0 }) {
+		return
+	}
+	in.ToolCalls = make([][]cassetteToolCall, len(in.Response.Choices))
+	for i, choice := range in.Response.Choices {
+		for _, tc := range choice.ToolCalls {
+			in.ToolCalls[i] = append(in.ToolCalls[i], cassetteToolCall{ID: tc.ID, Type: tc.Type, FunctionCall: tc.FunctionCall})
+		}
+	}
+}
+
+// restoreToolCalls 用 ToolCalls 恢复从文件读取的 Response 中的工具调用
+func (in *cassetteInteraction) restoreToolCalls() {
+	if in.Response == nil {
+		return
+	}
+	for i, choice := range in.Response.Choices {
+		if i >= len(in.ToolCalls) {
+			break
+		}
+		choice.ToolCalls = make([]llms.ToolCall, len(in.ToolCalls[i]))
+		for j, tc := range in.ToolCalls[i] {
+			choice.ToolCalls[j] = llms.ToolCall{ID: tc.ID, Type: tc.Type, FunctionCall: tc.FunctionCall}
+		}
+	}
+}
+
+// newCassetteLLM 创建 cassette 包装器。回放模式下 inner 可以为 nil，
+// 录制模式下会加载已有文件并追加新的调用
+func newCassetteLLM(inner IVertexAI, mode, path string) (*cassetteLLM, error) {
+	if mode != cassetteModeRecord && mode != cassetteModeReplay {
+		return nil, fmt.Errorf("invalid cassette mode %q, expected record or replay", mode)
+	}
+	if mode == cassetteModeRecord && inner == nil {
+		return nil, errors.New("cassette record mode requires a provider")
+	}
+
+	c := &cassetteLLM{
+		inner:        inner,
+		mode:         mode,
+		path:         path,
+		interactions: make(map[string]*cassetteInteraction),
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && mode == cassetteModeRecord:
+		// 首次录制，文件稍后创建
+	case err != nil:
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	default:
+		var file cassetteFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette: %w", err)
+		}
+		for _, in := range file.Interactions {
+			in.restoreToolCalls()
+			c.interactions[in.Key] = in
+		}
+	}
+
+	log.Printf("📼 [cassetteLLM] Cassette %s opened in %s mode (%d interactions)", path, mode, len(c.interactions))
+	return c, nil
+}
+
+// GenerateContent 录制或回放一次生成调用
+func (c *cassetteLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	request := describeGenerateRequest(messages, options)
+	in, err := c.interact(ctx, "generate", request, func(in *cassetteInteraction) error {
+		var err error
+		in.Response, err = c.inner.GenerateContent(ctx, messages, options...)
+		in.recordToolCalls()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return in.Response, nil
+}
+
+// Call 录制或回放一次简单文本生成
+func (c *cassetteLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	request := describeGenerateRequest([]llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)}, options)
+	in, err := c.interact(ctx, "call", request, func(in *cassetteInteraction) error {
+		var err error
+		in.Text, err = c.inner.Call(ctx, prompt, options...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return in.Text, nil
+}
+
+// CreateEmbedding 录制或回放一次嵌入调用
+func (c *cassetteLLM) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	request := strings.Join(texts, "\n---\n")
+	in, err := c.interact(ctx, "embedding", request, func(in *cassetteInteraction) error {
+		var err error
+		in.Embeddings, err = c.inner.CreateEmbedding(ctx, texts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return in.Embeddings, nil
+}
+
+// interact 在回放模式下查找录制的调用，在录制模式下执行 call 并保存结果。
+// provider 返回的错误不录制，回放时同一请求会报告未录制。
+func (c *cassetteLLM) interact(ctx context.Context, kind, request string, call func(*cassetteInteraction) error) (*cassetteInteraction, error) {
+	sum := sha256.Sum256([]byte(kind + "\n" + request))
+	key := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	recorded, ok := c.interactions[key]
+	c.mu.Unlock()
+	if ok {
+		return recorded, nil
+	}
+	if c.mode == cassetteModeReplay {
+		return nil, status.Errorf(codes.FailedPrecondition, "cassette %s has no recorded %s call for request %s", c.path, kind, key[:12])
+	}
+
+	in := &cassetteInteraction{Kind: kind, Key: key, Request: request}
+	if err := call(in); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions[key] = in
+	if err := c.saveLocked(); err != nil {
+		log.Printf("⚠️ [cassetteLLM] Failed to save cassette %s: %v", c.path, err)
+	}
+	return in, nil
+}
+
+// saveLocked 把所有调用写回 cassette 文件，按录制顺序无关的 key 排序以便比较差异。
+// 调用方必须持有 c.mu
+func (c *cassetteLLM) saveLocked() error {
+	file := cassetteFile{Interactions: make([]*cassetteInteraction, 0, len(c.interactions))}
+	for _, in := range c.interactions {
+		file.Interactions = append(file.Interactions, in)
+	}
+	slices.SortFunc(file.Interactions, func(a, b *cassetteInteraction) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// describeGenerateRequest 生成请求的可读摘要，包含影响回复的消息和调用选项，
+// 二进制内容只记录其哈希
+func describeGenerateRequest(messages []llms.MessageContent, options []llms.CallOption) string {
+	var opts llms.CallOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "model=%s temperature=%g max_tokens=%d", opts.Model, opts.Temperature, opts.MaxTokens)
+	// 采样参数只在设置时记录，已录制的 cassette 仍然匹配
+	if opts.TopP != 0 {
+		fmt.Fprintf(&b, " top_p=%g", opts.TopP)
+	}
+	if opts.TopK != 0 {
+		fmt.Fprintf(&b, " top_k=%d", opts.TopK)
+	}
+	for _, stop := range opts.StopWords {
+		fmt.Fprintf(&b, " stop=%q", stop)
+	}
+	if opts.PresencePenalty != 0 {
+		fmt.Fprintf(&b, " presence_penalty=%g", opts.PresencePenalty)
+	}
+	if opts.FrequencyPenalty != 0 {
+		fmt.Fprintf(&b, " frequency_penalty=%g", opts.FrequencyPenalty)
+	}
+	if opts.CandidateCount > 1 {
+		fmt.Fprintf(&b, " candidate_count=%d", opts.CandidateCount)
+	}
+	if opts.Seed != 0 {
+		fmt.Fprintf(&b, " seed=%d", opts.Seed)
+	}
+	if logprobs, _ := opts.Metadata[llm.MetadataLogprobs].(bool); logprobs {
+		fmt.Fprintf(&b, " logprobs=%v", opts.Metadata[llm.MetadataTopLogprobs])
+	}
+	if settings, _ := opts.Metadata[llm.MetadataSafetySettings].(map[string]string); len(settings) > 0 {
+		for _, category := range slices.Sorted(maps.Keys(settings)) {
+			fmt.Fprintf(&b, " safety=%s:%s", category, settings[category])
+		}
+	}
+	for _, tool := range opts.Tools {
+		if tool.Function != nil {
+			fmt.Fprintf(&b, " tool=%s", tool.Function.Name)
+		}
+	}
+
+	for _, msg := range messages {
+		for _, part := range msg.Parts {
+			fmt.Fprintf(&b, "\n[%s] ", msg.Role)
+			switch p := part.(type) {
+			case llms.TextContent:
+				b.WriteString(p.Text)
+			case llms.BinaryContent:
+				sum := sha256.Sum256(p.Data)
+				fmt.Fprintf(&b, "binary %s sha256:%s", p.MIMEType, hex.EncodeToString(sum[:]))
+			case llms.ImageURLContent:
+				fmt.Fprintf(&b, "image %s", p.URL)
+			case llms.ToolCall:
+				if p.FunctionCall != nil {
+					fmt.Fprintf(&b, "tool_call %s(%s)", p.FunctionCall.Name, p.FunctionCall.Arguments)
+				}
+			case llms.ToolCallResponse:
+				fmt.Fprintf(&b, "tool_response %s: %s", p.Name, p.Content)
+			default:
+				fmt.Fprintf(&b, "%T %v", p, p)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestCassetteReplay replays testdata/chat_cassette.json, recorded against
+// the handbook document and the canned weather search below. Re-record it
+// with LLM_CASSETTE_MODE=record after changing the prompts these requests
+// send.
+func TestCassetteReplay(t *testing.T) {
+	h, err := newTestHarness(func(cfg *serviceConfig) {
+		cfg.llmCassette = "testdata/chat_cassette.json"
+		cfg.llmCassetteMode = cassetteModeReplay
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.search.setResult("weather berlin", "Sunny, 21°C")
+	h.docs.add("doc-1", "handbook.pdf", "Employees get 25 vacation days per year.")
+
+	tests := []struct {
+		name        string
+		mode        genaidemo.Mode
+		input       string
+		wantContent string
+		wantTokens  int32
+		wantCode    codes.Code
+	}{
+		{"doc", genaidemo.Mode_MODE_DOC, "How many vacation days do employees get?", "According to the handbook, employees get 25 vacation days per year.", 128, codes.OK},
+		{"tool", genaidemo.Mode_MODE_TOOL, "What is the weather in Berlin?", "\n\nTool Results:\nSunny, 21°C", 28, codes.OK},
+		// Unrecorded requests fail like an unavailable provider
+		{"unrecorded", genaidemo.Mode_MODE_CHAT, "Tell me a joke", "", 0, codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := h.client(client.WithRetry(1, 0)).Send(context.Background(), tt.mode, &genaidemo.ChatRequest{
+				Messages: []*genaidemo.Message{{Role: genaidemo.Role_ROLE_USER, Content: tt.input}},
+			})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v (%v), want %v", got, err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if resp.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", resp.Content, tt.wantContent)
+			}
+			if resp.TokenUsage.GetTotalTokenNum() != tt.wantTokens {
+				t.Errorf("total tokens = %d, want %d", resp.TokenUsage.GetTotalTokenNum(), tt.wantTokens)
+			}
+		})
+	}
+	if !slices.Contains(h.search.queries(), "weather berlin") {
+		t.Errorf("searched %v, want the recorded tool call's query", h.search.queries())
+	}
+}
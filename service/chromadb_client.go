@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChromaDBClient ChromaDB 查询服务客户端，所有请求共享同一个连接池
+type ChromaDBClient struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+
+	// specs 缓存各集合声明的嵌入模型，重新嵌入后最迟 collectionSpecTTL 生效
+	specsMu sync.Mutex
+	specs   map[string]cachedCollectionSpec
+
+	// freshness 缓存各集合的新鲜度，ChatWithDoc 检查过期时不必每次请求都查询
+	freshnessMu sync.Mutex
+	freshness   map[string]cachedCollectionFreshness
+}
+
+const (
+	// collectionSpecTTL 集合嵌入模型的缓存时间
+	collectionSpecTTL = time.Minute
+	// collectionFreshnessTTL 集合新鲜度的缓存时间
+	collectionFreshnessTTL = time.Minute
+)
+
+// ChromaDBCollectionSpec 集合声明的嵌入模型和维度。维度为 0 表示集合还没有内容
+type ChromaDBCollectionSpec struct {
+	Name               string `json:"name"`
+	EmbeddingModel     string `json:"embedding_model"`
+	EmbeddingDimension int    `json:"embedding_dimension"`
+	// EmbeddingReduction 降维方式，如 truncate:256 或 pca:128，为空表示存储完整向量
+	EmbeddingReduction string `json:"embedding_reduction,omitempty"`
+	// ModelDimension 降维前模型输出的维度，查询向量可按此维度发送，由 ChromaDB 服务降维
+	ModelDimension int `json:"model_dimension,omitempty"`
+}
+
+type cachedCollectionSpec struct {
+	spec      *ChromaDBCollectionSpec
+	expiresAt time.Time
+}
+
+// NewChromaDBClientFromConfig 从配置创建 ChromaDB 客户端
+func NewChromaDBClientFromConfig(cfg *serviceConfig) *ChromaDBClient {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        cfg.chromaMaxIdleConns,
+		MaxIdleConnsPerHost: cfg.chromaMaxIdleConns,
+		MaxConnsPerHost:     cfg.chromaMaxConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+
+	return &ChromaDBClient{
+		baseURL: strings.TrimRight(cfg.chromaURL, "/"),
+		// 超时由每个请求的 context 控制，而不是 http.Client.Timeout
+		httpClient: &http.Client{Transport: transport},
+		timeout:    cfg.retrievalTimeout,
+		specs:      make(map[string]cachedCollectionSpec),
+		freshness:  make(map[string]cachedCollectionFreshness),
+	}
+}
+
+// Query 在 ChromaDB 中检索与查询相关的文档，可指定集合和元数据过滤条件。
+// 有租户的请求总是限定在租户自己的集合和文档内，与调用方传入的条件无关
+func (c *ChromaDBClient) Query(ctx context.Context, reqBody ChromaDBQueryRequest) (*ChromaDBQueryResponse, error) {
+	reqBody = scopeQuery(ctx, reqBody)
+	tenanted := tenantFromContext(ctx) != nil
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/query", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ChromaDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// 读完响应体，使连接可以被复用
+		io.Copy(io.Discard, resp.Body)
+		// 租户的集合尚未导入任何文档时没有可检索的内容
+		if resp.StatusCode == http.StatusNotFound && tenanted {
+			return &ChromaDBQueryResponse{}, nil
+		}
+		// 请求指定的集合不存在是调用方的错误，不触发降级和熔断
+		if resp.StatusCode == http.StatusNotFound && reqBody.Collection != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown retrieval collection %q", reqBody.Collection)
+		}
+		return nil, fmt.Errorf("ChromaDB query failed with status: %d", resp.StatusCode)
+	}
+
+	var queryResp ChromaDBQueryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&queryResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &queryResp, nil
+}
+
+// Stats 获取集合统计信息，服务未初始化集合时返回错误
+func (c *ChromaDBClient) Stats(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/stats", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ChromaDB stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("ChromaDB stats failed with status: %d", resp.StatusCode)
+	}
+
+	var stats map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return stats, nil
+}
+
+// ChromaDBIngestRequest 描述要导入的文档，文件扩展名决定文本提取方式
+type ChromaDBIngestRequest struct {
+	Filename    string
+	ContentType string
+	// Collection 为空时导入服务的默认集合
+	Collection string
+	// Tenant 非空时文档块带有租户标记
+	Tenant   string
+	Metadata map[string]string
+}
+
+// ChromaDBIngestResponse 是导入结果：文档块的 source 和块数
+type ChromaDBIngestResponse struct {
+	Source string `json:"source"`
+	Chunks int    `json:"chunks"`
+}
+
+// Ingest 上传文档内容并由 ChromaDB 服务提取、分块和向量化。同名文档会被替换。
+// 有租户的请求总是导入租户自己的集合。导入耗时与文档大小相关，只受调用方 context 限制
+func (c *ChromaDBClient) Ingest(ctx context.Context, doc ChromaDBIngestRequest, body io.Reader) (*ChromaDBIngestResponse, error) {
+	doc = scopeIngest(ctx, doc)
+
+	query := url.Values{"filename": {doc.Filename}}
+	if doc.Collection != "" {
+		query.Set("collection", doc.Collection)
+	}
+	if doc.Tenant != "" {
+		query.Set("tenant", doc.Tenant)
+	}
+	if len(doc.Metadata) > 0 {
+		metadata, err := json.Marshal(doc.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		query.Set("metadata", string(metadata))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/documents?"+query.Encode(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", cmp.Or(doc.ContentType, "application/octet-stream"))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload document to ChromaDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// 文档无法导入 (不支持的格式、没有文本) 是调用方的错误
+		var detail struct {
+			Detail string `json:"detail"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&detail)
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge {
+			return nil, status.Errorf(codes.InvalidArgument, "document %q cannot be ingested: %s", doc.Filename, detail.Detail)
+		}
+		return nil, fmt.Errorf("ChromaDB ingestion failed with status: %d", resp.StatusCode)
+	}
+
+	var ingestResp ChromaDBIngestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ingestResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &ingestResp, nil
+}
+
+// ChromaDBDocumentRequest 指定要读取的文档，Source 是导入时返回的 source
+type ChromaDBDocumentRequest struct {
+	Source string `json:"source"`
+	// Collection 为空时读取服务的默认集合
+	Collection string `json:"collection,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	// Access 限定调用方可见的文档，为 nil 时可读取所有文档
+	Access *documentAccess `json:"access,omitempty"`
+}
+
+// DocumentChunks 按顺序返回文档的所有文档块。有租户的请求只能读取租户自己导入的文档，
+// 文档不存在或调用方无权查看时返回 NotFound
+func (c *ChromaDBClient) DocumentChunks(ctx context.Context, doc ChromaDBDocumentRequest) ([]string, error) {
+	doc.Access = documentAccessFromContext(ctx)
+	if t := tenantFromContext(ctx); t != nil {
+		doc.Collection = t.collection
+		doc.Tenant = t.id
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/documents/chunks", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document from ChromaDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		// 集合不存在时文档同样不存在
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, status.Errorf(codes.NotFound, "document %q not found", doc.Source)
+		}
+		return nil, fmt.Errorf("ChromaDB document read failed with status: %d", resp.StatusCode)
+	}
+
+	var docResp struct {
+		Chunks []string `json:"chunks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&docResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return docResp.Chunks, nil
+}
+
+// CollectionSpec 获取集合声明的嵌入模型和维度，结果缓存 collectionSpecTTL。
+// 集合不存在时返回 NotFound，同样会被缓存
+func (c *ChromaDBClient) CollectionSpec(ctx context.Context, name string) (*ChromaDBCollectionSpec, error) {
+	c.specsMu.Lock()
+	cached, ok := c.specs[name]
+	c.specsMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		if cached.spec == nil {
+			return nil, status.Errorf(codes.NotFound, "collection %q not found", name)
+		}
+		return cached.spec, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/collections/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ChromaDB collection: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var spec *ChromaDBCollectionSpec
+	switch resp.StatusCode {
+	case http.StatusOK:
+		spec = &ChromaDBCollectionSpec{}
+		if err := json.NewDecoder(resp.Body).Decode(spec); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+	case http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+	default:
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("ChromaDB collection lookup failed with status: %d", resp.StatusCode)
+	}
+
+	c.specsMu.Lock()
+	c.specs[name] = cachedCollectionSpec{spec: spec, expiresAt: time.Now().Add(collectionSpecTTL)}
+	c.specsMu.Unlock()
+	if spec == nil {
+		return nil, status.Errorf(codes.NotFound, "collection %q not found", name)
+	}
+	return spec, nil
+}
+
+// EnsureCollection 确保集合存在，不存在时由 ChromaDB 服务以其 --embedding-model
+// 创建空集合。返回集合声明的嵌入模型和维度，以及集合是否为新创建的
+func (c *ChromaDBClient) EnsureCollection(ctx context.Context, name string) (*ChromaDBCollectionSpec, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.baseURL+"/collections/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create ChromaDB collection: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, false, fmt.Errorf("ChromaDB collection creation failed with status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		ChromaDBCollectionSpec
+		Created bool `json:"created"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	// 替换缓存中可能存在的 NotFound
+	spec := &result.ChromaDBCollectionSpec
+	c.specsMu.Lock()
+	c.specs[name] = cachedCollectionSpec{spec: spec, expiresAt: time.Now().Add(collectionSpecTTL)}
+	c.specsMu.Unlock()
+	return spec, result.Created, nil
+}
+
+// ChromaDBCollectionStats 集合的统计信息。有租户的请求只统计租户自己的文档，
+// StorageBytes 始终是整个集合的向量索引大小
+type ChromaDBCollectionStats struct {
+	Collection         string `json:"collection"`
+	DocumentCount      int    `json:"document_count"`
+	ChunkCount         int    `json:"chunk_count"`
+	EmbeddingModel     string `json:"embedding_model"`
+	EmbeddingDimension int    `json:"embedding_dimension"`
+	// LastSyncAt 最近一次同步或导入的 Unix 时间，未知时为 0
+	LastSyncAt   int64 `json:"last_sync_at,omitempty"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+// CollectionStats 获取集合的统计信息，集合不存在时返回 NotFound。
+// 统计需要遍历集合的元数据，耗时与集合大小相关，只受调用方 context 限制
+func (c *ChromaDBClient) CollectionStats(ctx context.Context, name string) (*ChromaDBCollectionStats, error) {
+	query := url.Values{}
+	if id := tenantID(ctx); id != "" {
+		query.Set("tenant", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/collections/"+url.PathEscape(name)+"/stats?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ChromaDB collection stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, status.Errorf(codes.NotFound, "collection %q not found", name)
+		}
+		return nil, fmt.Errorf("ChromaDB collection stats failed with status: %d", resp.StatusCode)
+	}
+
+	var stats ChromaDBCollectionStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &stats, nil
+}
+
+// ChromaDBCollectionFreshness 集合的新鲜度：最近一次更新的时间，以及同步到该集合的
+// 各连接器最近一次同步的结果
+type ChromaDBCollectionFreshness struct {
+	Collection string `json:"collection"`
+	// LastUpdatedAt 最新文档块的嵌入时间或连接器最近一次完成同步的 Unix 时间，未知时为 0
+	LastUpdatedAt int64                   `json:"last_updated_at,omitempty"`
+	Connectors    []ChromaDBConnectorSync `json:"connectors"`
+	// Stale 由 Go 服务按 INDEX_STALENESS_THRESHOLD 判断，ChromaDB 服务不返回
+	Stale bool `json:"stale"`
+}
+
+// ChromaDBConnectorSync 连接器最近一次同步的结果
+type ChromaDBConnectorSync struct {
+	Source string `json:"source"`
+	// Status 为 succeeded、partial (部分文档失败)、failed 或 unknown (旧版本的同步状态)
+	Status        string `json:"status"`
+	LastSyncAt    int64  `json:"last_sync_at,omitempty"`
+	LastSuccessAt int64  `json:"last_success_at,omitempty"`
+	Error         string `json:"error,omitempty"`
+	DocumentCount int    `json:"document_count"`
+}
+
+type cachedCollectionFreshness struct {
+	freshness *ChromaDBCollectionFreshness
+	expiresAt time.Time
+}
+
+// CollectionFreshness 获取集合最近一次更新的时间和连接器的同步状态，结果缓存
+// collectionFreshnessTTL。集合不存在时返回 NotFound
+func (c *ChromaDBClient) CollectionFreshness(ctx context.Context, name string) (*ChromaDBCollectionFreshness, error) {
+	c.freshnessMu.Lock()
+	cached, ok := c.freshness[name]
+	c.freshnessMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.freshness, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/collections/"+url.PathEscape(name)+"/freshness", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ChromaDB collection freshness: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, status.Errorf(codes.NotFound, "collection %q not found", name)
+		}
+		return nil, fmt.Errorf("ChromaDB collection freshness failed with status: %d", resp.StatusCode)
+	}
+
+	var freshness ChromaDBCollectionFreshness
+	if err := json.NewDecoder(resp.Body).Decode(&freshness); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	c.freshnessMu.Lock()
+	c.freshness[name] = cachedCollectionFreshness{freshness: &freshness, expiresAt: time.Now().Add(collectionFreshnessTTL)}
+	c.freshnessMu.Unlock()
+	return &freshness, nil
+}
+
+// Close 关闭空闲连接
+func (c *ChromaDBClient) Close() {
+	c.httpClient.CloseIdleConnections()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// breakerState is the state of a circuit breaker
+type breakerState int
+
+const (
+	// breakerClosed lets all calls through
+	breakerClosed breakerState = iota
+	// breakerOpen rejects calls until the open timeout has passed
+	breakerOpen
+	// breakerHalfOpen lets a single probe call through to test recovery
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreakerStatus is a snapshot of a breaker for health reporting
+type circuitBreakerStatus struct {
+	Name                string `json:"name"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// circuitBreaker stops calling a failing dependency after repeated failures,
+// so callers fail fast into their fallback path instead of waiting on timeouts
+type circuitBreaker struct {
+	name        string
+	threshold   int
+	openTimeout time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker creates a breaker that opens after threshold consecutive
+// failures and probes again after openTimeout
+func newCircuitBreaker(name string, threshold int, openTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		name:        name,
+		threshold:   max(threshold, 1),
+		openTimeout: openTimeout,
+	}
+}
+
+// newCircuitBreakerFromConfig creates a breaker with the configured thresholds
+func newCircuitBreakerFromConfig(name string, cfg *serviceConfig) *circuitBreaker {
+	return newCircuitBreaker(name, cfg.breakerFailureThreshold, cfg.breakerOpenTimeout)
+}
+
+// execute runs fn if the breaker allows it and records the outcome
+func (b *circuitBreaker) execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn(ctx)
+	b.record(ctx, err)
+	return err
+}
+
+// allow reports whether a call may proceed, returning Unavailable when open
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return errorWithReason(codes.Unavailable, reasonDependencyUnavailable, map[string]string{"dependency": b.name},
+				fmt.Sprintf("%s circuit breaker is open", b.name))
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		log.Printf("🔌 [circuitBreaker] %s half-open, probing", b.name)
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errorWithReason(codes.Unavailable, reasonDependencyUnavailable, map[string]string{"dependency": b.name},
+				fmt.Sprintf("%s circuit breaker is half-open", b.name))
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of a call. Calls cancelled by
+// the caller and invalid requests don't count as dependency failures.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err != nil && (ctx.Err() != nil || status.Code(err) == codes.InvalidArgument) {
+		return
+	}
+
+	if err == nil {
+		if b.state != breakerClosed {
+			log.Printf("🔌 [circuitBreaker] %s closed, dependency recovered", b.name)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			log.Printf("🔌 [circuitBreaker] %s opened after %d consecutive failures: %v", b.name, b.failures, err)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// snapshot returns the current breaker status
+func (b *circuitBreaker) snapshot() circuitBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return circuitBreakerStatus{
+		Name:                b.name,
+		State:               b.state.String(),
+		ConsecutiveFailures: b.failures,
+	}
+}
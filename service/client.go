@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
 	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms/googleai"
 	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms/googleai/vertex"
+	"github.com/example/genai-foundation-demo/pkg/llm"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -40,7 +43,41 @@ type IVertexAI interface {
 
 // VertexAIClient VertexAI 客户端包装器
 type VertexAIClient struct {
-	client IVertexAI
+	client   IVertexAI
+	retry    retryPolicy
+	breaker  *circuitBreaker
+	throttle *adaptiveThrottle
+	// byo 缓存使用调用方自带凭据的客户端，为 nil 时不支持自带凭据 (模拟或回放模式)
+	byo *providerPool
+
+	// embeddingModel 是 client 的嵌入模型。集合声明其他模型时由
+	// newEmbeddingClient 为每个模型创建客户端，为 nil 时 (模拟或回放模式) 使用 client
+	embeddingModel     string
+	newEmbeddingClient func(model string) (IVertexAI, error)
+	embeddingMu        sync.Mutex
+	embeddingClients   map[string]IVertexAI
+
+	// googleai 对所有危害类别只支持一个客户端级的拦截阈值。请求设置了安全设置时
+	// 由 newSafetyClient 为其中最严格的阈值创建客户端，为 nil 时 (模拟或回放模式)
+	// provider 从调用选项读取安全设置
+	newSafetyClient func(threshold googleai.HarmBlockThreshold) (IVertexAI, error)
+	safetyMu        sync.Mutex
+	safetyClients   map[googleai.HarmBlockThreshold]IVertexAI
+
+	// contextCache 使重复出现的长前缀从 provider 的缓存内容继续生成，为 nil 时禁用
+	contextCache *contextCache
+
+	// 单次调用超时，每次重试单独计时
+	generationTimeout time.Duration
+	embeddingTimeout  time.Duration
+}
+
+// harmBlockThresholds 安全设置的拦截阈值对应的 googleai 阈值
+var harmBlockThresholds = map[string]googleai.HarmBlockThreshold{
+	"BLOCK_LOW_AND_ABOVE":    googleai.HarmBlockLowAndAbove,
+	"BLOCK_MEDIUM_AND_ABOVE": googleai.HarmBlockMediumAndAbove,
+	"BLOCK_ONLY_HIGH":        googleai.HarmBlockOnlyHigh,
+	"BLOCK_NONE":             googleai.HarmBlockNone,
 }
 
 // withGlobalEndPoint 设置全局端点选项
@@ -51,7 +88,8 @@ func withGlobalEndPoint(endpoint string) googleai.Option {
 }
 
 // NewVertexAIClient 创建新的 VertexAI 客户端
-func NewVertexAIClient(modelParams VertexAIModelParams, chatParams VertexAIChatParams) (*VertexAIClient, error) {
+// NewVertexAIClient 创建 VertexAI 客户端，extra 为附加的选项 (例如拦截阈值)
+func NewVertexAIClient(modelParams VertexAIModelParams, chatParams VertexAIChatParams, extra ...googleai.Option) (*VertexAIClient, error) {
 	ctx := context.Background()
 
 	// 构建 VertexAI 选项
@@ -68,6 +106,7 @@ func NewVertexAIClient(modelParams VertexAIModelParams, chatParams VertexAIChatP
 	if modelParams.Location == GlobalRegion {
 		opts = append(opts, withGlobalEndPoint(GlobalEndpoint))
 	}
+	opts = append(opts, extra...)
 
 	// 创建 VertexAI 客户端
 	client, err := vertex.New(ctx, opts...)
@@ -75,14 +114,182 @@ func NewVertexAIClient(modelParams VertexAIModelParams, chatParams VertexAIChatP
 		return nil, status.Errorf(codes.Internal, "Vertex AI client creation failed: %v", err)
 	}
 
-	return &VertexAIClient{client: client}, nil
+	return newVertexAIClientWith(client), nil
+}
+
+// newProviderClient 使用调用方自带的凭据创建客户端: API key 访问 Gemini API，
+// 服务账号密钥访问其 Vertex AI 项目，费用均计入调用方的账号
+func newProviderClient(modelParams VertexAIModelParams, chatParams VertexAIChatParams, creds *providerCredentials) (IVertexAI, error) {
+	ctx := context.Background()
+
+	opts := []googleai.Option{
+		googleai.WithDefaultModel(modelParams.LLMName),
+		googleai.WithDefaultTemperature(chatParams.Temperature),
+		googleai.WithDefaultMaxTokens(chatParams.MaxToken),
+	}
+	if creds.apiKey != "" {
+		opts = append(opts, googleai.WithAPIKey(creds.apiKey))
+		return googleai.New(ctx, opts...)
+	}
+
+	location := creds.location
+	if location == "" {
+		location = modelParams.Location
+	}
+	opts = append(opts,
+		googleai.WithCloudProject(creds.project),
+		googleai.WithCloudLocation(location),
+		googleai.WithDefaultEmbeddingModel(modelParams.EmbeddingModelName),
+		googleai.WithCredentialsJSON(creds.credentialsJSON),
+	)
+	if location == GlobalRegion {
+		opts = append(opts, withGlobalEndPoint(GlobalEndpoint))
+	}
+	return vertex.New(ctx, opts...)
+}
+
+// clientFor 返回处理本次调用的客户端。请求或租户自带凭据时使用其账号的客户端，
+// byo 为 true，调用结束后需调用 release
+func (v *VertexAIClient) clientFor(ctx context.Context) (client IVertexAI, byo bool, release func(), err error) {
+	creds := providerCredentialsFromContext(ctx)
+	if creds == nil {
+		return v.client, false, func() {}, nil
+	}
+	if v.byo == nil {
+		return nil, false, nil, status.Error(codes.FailedPrecondition, "provider credentials are not supported by the configured LLM provider")
+	}
+	client, release, err = v.byo.acquire(creds)
+	return client, true, release, err
+}
+
+// embeddingClientFor 返回使用 ctx 中嵌入模型的客户端。声明了其他模型的集合总是
+// 用服务自己的账号嵌入查询，与调用方自带的凭据无关，byo 为 false
+func (v *VertexAIClient) embeddingClientFor(ctx context.Context) (client IVertexAI, byo bool, release func(), err error) {
+	model := embeddingModelFromContext(ctx)
+	if model == "" || model == v.embeddingModel {
+		return v.clientFor(ctx)
+	}
+	if v.newEmbeddingClient == nil {
+		return v.client, false, func() {}, nil
+	}
+
+	v.embeddingMu.Lock()
+	defer v.embeddingMu.Unlock()
+	client, ok := v.embeddingClients[model]
+	if !ok {
+		if client, err = v.newEmbeddingClient(model); err != nil {
+			return nil, false, nil, status.Errorf(codes.Internal, "Vertex AI embedding client for %s creation failed: %v", model, err)
+		}
+		if v.embeddingClients == nil {
+			v.embeddingClients = make(map[string]IVertexAI)
+		}
+		v.embeddingClients[model] = client
+	}
+	return client, false, func() {}, nil
 }
 
-// GenerateContent 生成内容
+// generationClientFor 返回处理本次生成调用的客户端。调用选项带有安全设置时使用
+// 其中最严格阈值的客户端；调用方自带凭据的调用不支持安全设置，使用 provider 的默认阈值
+func (v *VertexAIClient) generationClientFor(ctx context.Context, options []llms.CallOption) (client IVertexAI, byo bool, release func(), err error) {
+	client, byo, release, err = v.clientFor(ctx)
+	if err != nil || byo || v.newSafetyClient == nil {
+		return client, byo, release, err
+	}
+
+	var opts llms.CallOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+	// 从缓存内容继续的调用和原生函数调用由 Vertex AI SDK 按类别应用安全设置
+	_, cached := opts.Metadata[metadataCachedContent]
+	_, native := opts.Metadata[metadataNativeFunctionCalling]
+	if cached || native {
+		return client, byo, release, nil
+	}
+	settings, _ := opts.Metadata[llm.MetadataSafetySettings].(map[string]string)
+	strictest, ok := strictestSafetyThreshold(settings)
+	if !ok {
+		return client, byo, release, nil
+	}
+	threshold := harmBlockThresholds[strictest]
+
+	v.safetyMu.Lock()
+	defer v.safetyMu.Unlock()
+	client, ok = v.safetyClients[threshold]
+	if !ok {
+		if client, err = v.newSafetyClient(threshold); err != nil {
+			return nil, false, nil, status.Errorf(codes.Internal, "Vertex AI client for %s creation failed: %v", strictest, err)
+		}
+		if v.safetyClients == nil {
+			v.safetyClients = make(map[googleai.HarmBlockThreshold]IVertexAI)
+		}
+		v.safetyClients[threshold] = client
+	}
+	return client, false, release, nil
+}
+
+// newVertexAIClientWith 用默认的重试、熔断和限流策略包装任意 IVertexAI 实现
+func newVertexAIClientWith(client IVertexAI) *VertexAIClient {
+	return &VertexAIClient{
+		client:   client,
+		retry:    defaultRetryPolicy,
+		breaker:  newCircuitBreaker("vertex_ai", DefaultBreakerFailureThreshold, DefaultBreakerOpenTimeout),
+		throttle: newAdaptiveThrottle("vertex_ai", DefaultProviderMaxConcurrency),
+
+		generationTimeout: DefaultGenerationTimeout,
+		embeddingTimeout:  DefaultEmbeddingTimeout,
+	}
+}
+
+// GenerateContent 生成内容，遇到限流或服务暂不可用时自动重试
 func (v *VertexAIClient) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
-	content, err := v.client.GenerateContent(ctx, messages, options...)
+	// 按请求覆盖模型 (例如预算用尽后降级到更便宜的模型)
+	if model := modelFromContext(ctx, ""); model != "" {
+		options = append(options, llms.WithModel(model))
+	}
+
+	// 重复出现的长前缀从缓存内容继续生成。缓存内容属于服务自己的账号，
+	// 调用方自带凭据的调用不使用
+	if v.contextCache != nil && providerCredentialsFromContext(ctx) == nil {
+		messages, options = v.contextCache.apply(ctx, messages, options)
+	}
+
+	client, byo, release, err := v.generationClientFor(ctx, options)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Vertex AI generate content failed: %v", err)
+		return nil, err
+	}
+	defer release()
+
+	// 熔断器打开时直接失败，不再等待超时。调用方自带凭据的调用不经过共享的
+	// 熔断器和限流器，其故障和配额与服务自己的账号无关
+	if !byo {
+		if err := v.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	var content *llms.ContentResponse
+	err = v.retry.do(ctx, "GenerateContent", func(ctx context.Context) error {
+		if !byo {
+			if err := v.throttle.acquire(ctx); err != nil {
+				return err
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, v.generationTimeout)
+		defer cancel()
+
+		var err error
+		content, err = client.GenerateContent(ctx, messages, options...)
+		if !byo {
+			v.throttle.release(err)
+		}
+		return err
+	})
+	if !byo {
+		v.breaker.record(ctx, err)
+	}
+	if err != nil {
+		return nil, &providerError{op: "generate content", err: err, callerCredentials: byo}
 	}
 
 	if content == nil || len(content.Choices) < 1 {
@@ -94,19 +301,57 @@ func (v *VertexAIClient) GenerateContent(ctx context.Context, messages []llms.Me
 
 // Call 调用 VertexAI 进行简单文本生成
 func (v *VertexAIClient) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
-	response, err := v.client.Call(ctx, prompt, options...)
+	client, byo, release, err := v.clientFor(ctx)
 	if err != nil {
-		return "", status.Errorf(codes.Internal, "Vertex AI call failed: %v", err)
+		return "", err
+	}
+	defer release()
+
+	response, err := client.Call(ctx, prompt, options...)
+	if err != nil {
+		return "", &providerError{op: "call", err: err, callerCredentials: byo}
 	}
 
 	return response, nil
 }
 
-// CreateEmbedding 创建文本嵌入
+// CreateEmbedding 创建文本嵌入，遇到限流或服务暂不可用时自动重试。
+// 使用 ctx 中的嵌入模型 (见 withEmbeddingModel)，未设置时使用默认模型
 func (v *VertexAIClient) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
-	embeddings, err := v.client.CreateEmbedding(ctx, texts)
+	client, byo, release, err := v.embeddingClientFor(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Vertex AI create embedding failed: %v", err)
+		return nil, err
+	}
+	defer release()
+
+	if !byo {
+		if err := v.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	var embeddings [][]float32
+	err = v.retry.do(ctx, "CreateEmbedding", func(ctx context.Context) error {
+		if !byo {
+			if err := v.throttle.acquire(ctx); err != nil {
+				return err
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, v.embeddingTimeout)
+		defer cancel()
+
+		var err error
+		embeddings, err = client.CreateEmbedding(ctx, texts)
+		if !byo {
+			v.throttle.release(err)
+		}
+		return err
+	})
+	if !byo {
+		v.breaker.record(ctx, err)
+	}
+	if err != nil {
+		return nil, &providerError{op: "create embedding", err: err, callerCredentials: byo}
 	}
 
 	return embeddings, nil
@@ -117,7 +362,7 @@ func NewVertexAIClientFromConfig(cfg *serviceConfig) (*VertexAIClient, error) {
 	modelParams := VertexAIModelParams{
 		Project:            cfg.projectID,
 		LLMName:            cfg.modelName,
-		EmbeddingModelName: "textembedding-gecko@latest", // 默认嵌入模型
+		EmbeddingModelName: cfg.embeddingModel,
 		Location:           cfg.location,
 	}
 
@@ -126,7 +371,85 @@ func NewVertexAIClientFromConfig(cfg *serviceConfig) (*VertexAIClient, error) {
 		MaxToken:    2048, // 默认最大token数
 	}
 
-	return NewVertexAIClient(modelParams, chatParams)
+	var provider IVertexAI
+	var byo *providerPool
+	var newEmbeddingClient func(model string) (IVertexAI, error)
+	var newSafetyClient func(threshold googleai.HarmBlockThreshold) (IVertexAI, error)
+	switch {
+	case cfg.llmCassette != "" && cfg.llmCassetteMode == cassetteModeReplay:
+		// 回放模式只读取 cassette，不创建 provider
+	case cfg.llmProvider == llmProviderMock:
+		// 模拟 provider，不访问 VertexAI
+		mock, err := newMockLLM(cfg.modelName, cfg.mockLLMScript)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Mock LLM creation failed: %v", err)
+		}
+		provider = mock
+	default:
+		vertexClient, err := NewVertexAIClient(modelParams, chatParams)
+		if err != nil {
+			return nil, err
+		}
+		provider = vertexClient.client
+		// 启用上下文缓存或原生函数调用时由 Vertex AI SDK 处理 langchaingo 不支持的调用
+		if cfg.contextCacheMinTokens > 0 || cfg.nativeFunctionCalling {
+			sdk, err := newVertexSDKLLM(provider, modelParams, chatParams)
+			if err != nil {
+				return nil, err
+			}
+			provider = sdk
+		}
+		// 调用方自带凭据时按凭据创建各自的客户端
+		byo = newProviderPool(MaxProviderClients, func(creds *providerCredentials) (IVertexAI, error) {
+			return newProviderClient(modelParams, chatParams, creds)
+		})
+		// 集合声明的其他嵌入模型各用一个客户端
+		newEmbeddingClient = func(model string) (IVertexAI, error) {
+			params := modelParams
+			params.EmbeddingModelName = model
+			embeddingClient, err := NewVertexAIClient(params, chatParams)
+			if err != nil {
+				return nil, err
+			}
+			return embeddingClient.client, nil
+		}
+		// 安全设置的每个拦截阈值各用一个客户端
+		newSafetyClient = func(threshold googleai.HarmBlockThreshold) (IVertexAI, error) {
+			safetyClient, err := NewVertexAIClient(modelParams, chatParams, googleai.WithHarmThreshold(threshold))
+			if err != nil {
+				return nil, err
+			}
+			return safetyClient.client, nil
+		}
+	}
+
+	// 录制/回放 LLM 调用。所有调用都需经过 cassette，集合声明的嵌入模型和安全设置的阈值因此不生效
+	if cfg.llmCassette != "" {
+		newEmbeddingClient = nil
+		newSafetyClient = nil
+		cassette, err := newCassetteLLM(provider, cfg.llmCassetteMode, cfg.llmCassette)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Cassette creation failed: %v", err)
+		}
+		provider = cassette
+	}
+
+	client := newVertexAIClientWith(provider)
+	client.retry = newRetryPolicyFromConfig(cfg)
+	client.breaker = newCircuitBreakerFromConfig("vertex_ai", cfg)
+	client.throttle = newAdaptiveThrottle("vertex_ai", cfg.providerMaxConcurrency)
+	client.byo = byo
+	client.embeddingModel = cfg.embeddingModel
+	client.newEmbeddingClient = newEmbeddingClient
+	client.newSafetyClient = newSafetyClient
+	// 上下文缓存需要 provider 支持 (VertexAI 或模拟 provider，录制回放时不支持)
+	if cacher, ok := provider.(contextCacheProvider); ok && cfg.contextCacheMinTokens > 0 {
+		client.contextCache = newContextCache(cacher, cfg.modelName, cfg.contextCacheMinTokens, cfg.contextCacheTTL)
+	}
+	client.generationTimeout = cfg.generationTimeout
+	client.embeddingTimeout = cfg.embeddingTimeout
+
+	return client, nil
 }
 
 // UpdateWithVertexAI 更新聊天服务以使用 VertexAI 客户端
@@ -137,8 +460,9 @@ func (s *chatService) UpdateWithVertexAI(vertexClient *VertexAIClient) {
 // GetVertexAIStats 获取 VertexAI 客户端统计信息
 func (v *VertexAIClient) GetVertexAIStats() map[string]interface{} {
 	return map[string]interface{}{
-		"client_type": "VertexAI",
-		"status":      "connected",
+		"client_type":     "VertexAI",
+		"status":          "connected",
+		"circuit_breaker": v.breaker.snapshot(),
 	}
 }
 
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescingMetrics counts provider calls and the requests that shared them,
+// exported under /api/metrics
+var coalescingMetrics = expvar.NewMap("request_coalescing")
+
+// requestCoalescer collapses identical concurrent requests into a single
+// provider call whose result is fanned out to every waiting caller
+type requestCoalescer struct {
+	enabled bool
+	group   singleflight.Group
+
+	mu    sync.Mutex
+	calls map[string]*sharedCall
+}
+
+// sharedCall is the context of an in-flight shared call and the number of
+// callers still waiting on it
+type sharedCall struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// newRequestCoalescer creates a coalescer; when disabled every call runs
+func newRequestCoalescer(enabled bool) *requestCoalescer {
+	return &requestCoalescer{enabled: enabled, calls: make(map[string]*sharedCall)}
+}
+
+// do runs fn once per key among concurrent callers. The shared call is
+// detached from any single caller's cancellation so one client disconnecting
+// doesn't fail the others; each caller still stops waiting when its own ctx
+// is done, and the shared call is cancelled once every caller has gone.
+func (c *requestCoalescer) do(ctx context.Context, key string, fn func(ctx context.Context) (*ChatResult, error)) (*ChatResult, error) {
+	if !c.enabled {
+		return fn(ctx)
+	}
+
+	call := c.join(ctx, key)
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		coalescingMetrics.Add("calls", 1)
+		return fn(call.ctx)
+	})
+
+	select {
+	case <-ctx.Done():
+		if c.leave(key, call) {
+			coalescingMetrics.Add("cancelled", 1)
+			log.Printf("🚫 [requestCoalescer] All callers gone, cancelled request %.12s", key)
+		}
+		return nil, ctx.Err()
+	case res := <-ch:
+		c.leave(key, call)
+		if res.Shared {
+			coalescingMetrics.Add("shared", 1)
+			log.Printf("🔗 [requestCoalescer] Shared in-flight result for request %.12s", key)
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*ChatResult), nil
+	}
+}
+
+// join registers the caller as waiting on the shared call of key, creating
+// it on the first caller
+func (c *requestCoalescer) join(ctx context.Context, key string) *sharedCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call, ok := c.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &sharedCall{ctx: callCtx, cancel: cancel}
+		c.calls[key] = call
+	}
+	call.waiters++
+	return call
+}
+
+// leave unregisters a caller, cancelling the shared call when it was the last
+// one. It reports whether it was the last caller.
+func (c *requestCoalescer) leave(key string, call *sharedCall) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return false
+	}
+	if c.calls[key] == call {
+		delete(c.calls, key)
+		// A cancelled call must not be shared with later callers
+		c.group.Forget(key)
+	}
+	call.cancel()
+	return true
+}
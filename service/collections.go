@@ -0,0 +1,72 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// vertexEmbeddingPrefix marks collections embedded with a Vertex AI model,
+// e.g. "vertex:text-embedding-004". Other collections use a local
+// sentence-transformers model, which only the ChromaDB service can run.
+const vertexEmbeddingPrefix = "vertex:"
+
+type embeddingModelKey struct{}
+
+// withEmbeddingModel makes embedding calls made with ctx use the given model
+func withEmbeddingModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, embeddingModelKey{}, model)
+}
+
+// embeddingModelFromContext returns the embedding model of ctx, or "" when unset
+func embeddingModelFromContext(ctx context.Context) string {
+	model, _ := ctx.Value(embeddingModelKey{}).(string)
+	return model
+}
+
+// embedQuery embeds query with the model the collection declares. It returns
+// nil when the ChromaDB service should embed the query itself: the collection
+// uses a local model, or its spec is unavailable.
+func (s *chatService) embedQuery(ctx context.Context, collection, query string) ([]float32, error) {
+	spec, err := s.chromaClient.CollectionSpec(ctx, collection)
+	if err != nil {
+		return nil, nil
+	}
+	model, ok := strings.CutPrefix(spec.EmbeddingModel, vertexEmbeddingPrefix)
+	if !ok {
+		return nil, nil
+	}
+
+	embeddings, err := s.vertexClient.CreateEmbedding(withEmbeddingModel(ctx, model), []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) == 0 {
+		return nil, fmt.Errorf("no embedding returned for query")
+	}
+	// Reduced collections take the model's full embedding and reduce it the
+	// way their chunks were
+	embedding := embeddings[0]
+	if dimension := cmp.Or(spec.ModelDimension, spec.EmbeddingDimension); dimension > 0 && len(embedding) != dimension {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"collection %s declares %d-dimensional embeddings but %s returns %d",
+			collection, dimension, spec.EmbeddingModel, len(embedding))
+	}
+
+	// Chunks are stored normalized, so the query must be too for cosine distance
+	var norm float64
+	for _, v := range embedding {
+		norm += float64(v) * float64(v)
+	}
+	if norm = math.Sqrt(norm); norm > 0 {
+		for i, v := range embedding {
+			embedding[i] = float32(float64(v) / norm)
+		}
+	}
+	return embedding, nil
+}
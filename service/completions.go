@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// ChatCompletion is one of the completions of a request with n above 1
+type ChatCompletion struct {
+	Content      string
+	TokenUsage   *TokenUsageInfo
+	FinishReason genaidemo.FinishReason
+	Logprobs     []*genaidemo.TokenLogprob
+}
+
+// runCompletions runs a request through the output guardrails once, or n
+// times in parallel when it asks for n completions. The result of the first
+// completion that succeeded is the reply, with the others as candidates and
+// the token usage of every call; completions failing or rejected by the
+// guardrails are dropped unless they all are.
+func (h *Handler) runCompletions(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest, chat chatFunc) (*ChatResult, error) {
+	n := int(req.GetN())
+	if n <= 1 {
+		return h.guardrails.run(ctx, mode, req, chat)
+	}
+
+	results := make([]*ChatResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = h.guardrails.run(ctx, mode, req, chat)
+		}()
+	}
+	wg.Wait()
+
+	var result *ChatResult
+	var usage TokenUsageInfo
+	var completions []*ChatCompletion
+	for i, r := range results {
+		if errs[i] != nil {
+			log.Printf("⚠️ [%s] Completion %d of %d failed: %v", mode, i+1, n, errs[i])
+			continue
+		}
+		addTokenUsage(&usage, r.TokenUsage)
+		completions = append(completions, &ChatCompletion{
+			Content:      r.Content,
+			TokenUsage:   r.TokenUsage,
+			FinishReason: r.FinishReason,
+			Logprobs:     r.Logprobs,
+		})
+		if result == nil {
+			result = r
+		} else {
+			result.Candidates = append(result.Candidates, r.Content)
+		}
+	}
+	if result == nil {
+		return nil, errs[0]
+	}
+	result.TokenUsage = &usage
+	result.Completions = completions
+	return result, nil
+}
+
+// toTokenUsage converts the token usage of a completion
+func toTokenUsage(usage *TokenUsageInfo) *genaidemo.TokenUsage {
+	if usage == nil {
+		return nil
+	}
+	return &genaidemo.TokenUsage{
+		InputTokenNum:   usage.InputTokens,
+		OutputTokenNum:  usage.OutputTokens,
+		TotalTokenNum:   usage.TotalTokens,
+		ContextTokenNum: usage.ContextTokens,
+		CachedTokenNum:  usage.CachedTokens,
+	}
+}
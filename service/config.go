@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // VertexAI 配置常量
 // 请根据你的实际情况修改这些配置
 const (
@@ -16,6 +18,215 @@ const (
 	// - "gemini-1.5-pro"    (功能最强、但成本较高)  
 	// - "gemini-1.0-pro"    (稳定版本)
 	DefaultModelName = "gemini-1.5-flash"
+
+	// LLM provider: vertex 或 mock (确定性的模拟回复，无需 GCP 凭据)
+	DefaultLLMProvider = "vertex"
+
+	// LLM 调用录制/回放 (LLM_CASSETTE 设置 cassette 文件后生效): record 或 replay
+	DefaultLLMCassetteMode = "replay"
+
+	// Text-to-Speech 语音配置
+	// 语音名称留空时由服务根据语言自动选择
+	DefaultTTSLanguageCode = "en-US"
+	DefaultTTSVoiceName    = ""
+
+	// 异步任务配置
+	DefaultJobWorkers   = 4                // 并发执行任务的 worker 数量
+	DefaultJobQueueSize = 100              // 排队任务上限，超出时拒绝提交
+	DefaultJobTimeout   = 10 * time.Minute // 单个任务最长执行时间
+	DefaultJobRetention = time.Hour        // 已完成任务结果保留时间
+
+	// Webhook 回调配置 (签名密钥通过 WEBHOOK_SECRET 环境变量设置)
+	DefaultWebhookMaxAttempts = 5                // 最大投递次数
+	DefaultWebhookTimeout     = 10 * time.Second // 单次投递超时
+
+	// 事件总线配置 (EVENT_BUS 为 pubsub、kafka 或 log 时发布结构化事件)
+	DefaultEventBufferSize    = 1000        // 待发布事件的缓冲区大小，满时丢弃新事件
+	DefaultEventBatchSize     = 100         // 单次发布的最大事件数
+	DefaultEventFlushInterval = time.Second // 未满一批时的发布间隔
+
+	// Few-shot 示例配置 (示例通过 /api/examples 管理，FEW_SHOT_EXAMPLES_FILE 持久化)
+	DefaultFewShotTokenBudget = 1000 // 每个请求注入示例的 token 上限，0 表示不注入
+
+	// 用户记忆配置 (请求通过 X-User-ID 指定用户，USER_MEMORY_FILE 持久化)
+	DefaultMemoryTokenBudget       = 0                // 每个请求注入用户事实的 token 上限，0 表示禁用记忆
+	DefaultMemoryExtractionTimeout = 30 * time.Second // 对话后提取用户事实的超时
+	MaxUserFacts                   = 50               // 每个用户保留的事实数上限，超出时丢弃最久未更新的
+
+	// 知识图谱配置 (从对话和文本文档中提取实体和关系，KNOWLEDGE_GRAPH_FILE 持久化)
+	DefaultGraphTokenBudget       = 0                // ChatWithDoc 每个请求注入关系的 token 上限，0 表示禁用知识图谱
+	DefaultGraphExtractionTimeout = 60 * time.Second // 提取实体和关系的超时
+	MaxGraphHops                  = 2                // 从问题提到的实体出发遍历的最大跳数
+	MaxGraphRelations             = 5000             // 每个租户保留的关系数上限，超出时丢弃最久未学到的
+	MaxGraphDocumentBytes         = 64 << 10         // 从文本文档提取时读取的最大字节数
+
+	// 会话实体记忆配置 (请求通过 session_id 指定会话，仅保存在内存中)
+	DefaultSessionEntityTokenBudget = 0              // 每个请求注入实体摘要的 token 上限，0 表示禁用会话实体记忆
+	DefaultSessionEntityTTL         = 24 * time.Hour // 会话闲置多久后丢弃其实体
+	MaxSessionEntities              = 30             // 每个会话保留的实体数上限，超出时丢弃最久未更新的
+	MaxEntitySessions               = 10000          // 同时保留的会话数上限，超出时丢弃最久未使用的
+
+	// 会话列表配置 (按 session_id 记录会话，SESSION_TITLE_MODEL 为会话生成标题，SESSIONS_FILE 持久化)
+	DefaultSessionRetention = 30 * 24 * time.Hour // 会话闲置多久后从列表中删除
+	MaxSessionsPerUser      = 200                 // 每个用户保留的会话数上限，超出时丢弃最久未使用的
+	MaxSessionPreviewLength = 80                  // 标题生成前以第一条用户消息作为标题时的最大字符数
+
+	// 对话分析配置 (记录的对话由后台任务按主题、情绪和解决情况分类，ANALYTICS_FILE 持久化)
+	DefaultAnalyticsInterval  = 0                   // 分析任务的运行间隔，0 表示禁用对话分析
+	DefaultAnalyticsRetention = 30 * 24 * time.Hour // 对话记录的保留时长
+	AnalyticsBatchSize        = 20                  // 每次分类调用包含的对话数
+	MaxAnalyticsConversations = 100000              // 保留的对话数上限，超出时丢弃最早的
+	MaxAnalyticsTextBytes     = 4096                // 记录的用户消息和回复的最大字节数
+	// 对话分类的主题 (逗号分隔)，无法归入的对话记为 other
+	DefaultAnalyticsTopics = "how-to,troubleshooting,product questions,documents,writing,coding,data analysis"
+
+	// 回复反馈配置 (按请求 ID 对回复点赞、点踩、评分和评论，FEEDBACK_FILE 持久化)
+	DefaultFeedbackWindow    = 0      // 回复发出后可以提交反馈的时长，0 表示禁用反馈
+	MaxFeedbackReplies       = 10000  // 在内存中保留的可反馈回复数上限，超出时丢弃最早的
+	MaxFeedbackRecords       = 100000 // 保留的反馈数上限，超出时丢弃最早提交的
+	MaxFeedbackRating        = 5      // 评分的最大值，最小值为 1
+	MaxFeedbackCommentLength = 2000   // 评论的最大字符数
+
+	// 微调数据集导出配置 (从反馈记录导出 JSONL，文件写入 EXPORT_DIR)
+	DefaultExportRetention = 24 * time.Hour // 导出文件的保留时长，过期后删除
+	MaxActiveExports       = 2              // 同时运行的导出任务数上限
+
+	// 摘要配置 (/api/summarize，超出上下文窗口的输入分段摘要后再合并，SUMMARY_MODEL 指定摘要模型)
+	MaxSummarizeTextLength = 4 << 20 // 待摘要文本的最大字节数
+
+	// 文本分类配置 (/api/classify，CLASSIFY_MODEL 指定分类模型)
+	MaxClassifyTextLength = 32 << 10 // 待分类文本的最大字节数
+	MaxClassifyLabels     = 50       // 每次分类的标签数上限
+	MaxClassifyExamples   = 10       // 每个标签的示例文本数上限
+
+	// 自动路由配置 (/api/chat/auto，启发式规则无法确定时由 AUTO_ROUTER_MODEL 分类)
+	DefaultAutoRouterMinConfidence = 0.6 // 路由模型的置信度低于此值时使用启发式规则的猜测
+
+	// 意图分类配置 (INTENT_CLASSIFIERS 依次尝试的分类器，model 分类器使用 INTENT_MODEL)
+	DefaultIntentMinConfidence = 0.6 // 意图模型的置信度低于此值时交给下一个分类器
+
+	// VertexAI 调用重试配置 (仅对 429/503/超时等可重试错误生效)
+	DefaultRetryMaxAttempts    = 3                      // 最大尝试次数 (含首次调用)
+	DefaultRetryInitialBackoff = 500 * time.Millisecond // 首次重试前的最大等待时间
+	DefaultRetryMaxBackoff     = 10 * time.Second       // 重试等待时间上限
+
+	// 熔断器配置 (VertexAI、ChromaDB、工具调用各自独立)
+	DefaultBreakerFailureThreshold = 5                // 连续失败多少次后熔断
+	DefaultBreakerOpenTimeout      = 30 * time.Second // 熔断后多久尝试恢复
+
+	// 响应缓存配置 (完全相同的请求直接返回缓存结果，TTL 为 0 时禁用)
+	DefaultResponseCacheTTL  = 5 * time.Minute
+	DefaultResponseCacheSize = 1000 // 最多缓存的响应数量
+
+	// 上下文缓存配置 (重复出现的长前缀缓存在 provider 端，其 token 按折扣计费)
+	DefaultContextCacheMinTokens = 0         // 缓存前缀至少比已缓存的部分多出的 token 数，0 表示禁用
+	DefaultContextCacheTTL       = time.Hour // 缓存内容在 provider 端保留的时间
+	MaxContextCachePrefixes      = 100000    // 记录的已出现前缀数上限
+
+	// 原生函数调用配置 (ChatWithTool 直接使用 Gemini 的函数调用协议，工具结果回传给模型)
+	DefaultNativeFunctionCalling = false
+	MaxFunctionCallingRounds     = 5 // 一次请求最多的函数调用轮数
+
+	// 合并并发的相同请求，只调用一次模型
+	DefaultCoalesceRequests = true
+
+	// ChromaDB 查询服务配置
+	DefaultChromaDBURL          = "http://localhost:8000"
+	DefaultChromaDBMaxIdleConns = 32 // 连接池保留的空闲连接数
+	DefaultChromaDBMaxConns     = 64 // 最大并发连接数
+
+	// ChatWithDoc 的默认集合，需与 ChromaDB 服务的 --collection 一致
+	DefaultChromaDBCollection = "pdf_documents"
+	// 启动时默认集合不存在则由 ChromaDB 服务创建空集合
+	DefaultChromaDBBootstrap = true
+
+	// 集合超过多久未更新时 ChatWithDoc 的回复带 stale_index 警告，0 表示不检查
+	DefaultIndexStalenessThreshold = 0
+
+	// ChatWithDoc 检索配置，可通过请求的 retrieval 字段按请求调整
+	DefaultRetrievalTopK = 3  // 默认检索的文档数
+	MaxRetrievalTopK     = 20 // 请求可指定的最大文档数
+
+	// 请求采样参数的上限 (与 Gemini 一致)
+	MaxStopSequences  = 5 // 最多的停止序列数
+	MaxCandidateCount = 8 // 最多的候选回复数
+	MaxCompletions    = 8 // 请求 n 的上限，每个补全单独调用
+	MaxTopLogprobs    = 5 // 每个 token 最多返回的候选 token 数
+
+	// 各阶段超时配置
+	DefaultGenerationTimeout = 60 * time.Second // 单次 LLM 生成调用 (每次重试单独计时)
+	DefaultEmbeddingTimeout  = 15 * time.Second // 单次嵌入调用 (每次重试单独计时)
+	DefaultRetrievalTimeout  = 10 * time.Second // 单次 ChromaDB 检索
+	DefaultToolTimeout       = 15 * time.Second // 单次工具调用，可通过 TOOL_TIMEOUTS 按工具覆盖
+
+	// 输入 token 上限配置
+	DefaultInputTokenLimit = 0        // 0 表示使用模型的上下文窗口
+	DefaultInputTruncation = "reject" // 超出上限时的处理: reject 或 drop_oldest
+
+	// 用量统计配置
+	DefaultUsageRetention = 35 * 24 * time.Hour // 按 API key 统计的用量保留时间
+
+	// 预算配置
+	DefaultMonthlyBudget = 0.0      // 每个 API key 的月度预算 (USD)，0 表示不限制
+	DefaultBudgetPolicy  = "reject" // 预算用尽后的处理: reject 或 downgrade
+
+	// 默认的 Vertex AI 嵌入模型，集合可在元数据中声明自己的模型
+	DefaultEmbeddingModel = "textembedding-gecko@latest"
+
+	// 批量嵌入配置
+	DefaultEmbeddingBatchSize = 250 // 单次嵌入请求的文本数上限 (Vertex AI 限制)
+	DefaultEmbeddingWorkers   = 4   // 并发嵌入请求数
+
+	// 自适应限流配置
+	DefaultProviderMaxConcurrency = 16 // 对 VertexAI 的最大并发调用数，遇到 429 时自动减半并逐步恢复
+	MaxProviderClients            = 64 // 为调用方自带凭据保留的客户端数，超出时关闭最久未用的
+
+	// 启动预热配置
+	DefaultWarmUpOnStartup = false            // 启动时预热模型与 ChromaDB 连接
+	DefaultWarmUpTimeout   = 30 * time.Second // 单次预热尝试的超时
+
+	// ChatWithDoc 的系统提示词 (Go template)，{{.documents}} 为检索到的文档，{{.query}} 为用户问题，
+	// {{.language}} 为回复语言 (所有模式的系统提示词都可以使用)。
+	// 可通过 DOC_SYSTEM_PROMPT 覆盖，其他模式默认没有系统提示词
+	DefaultDocSystemPrompt = "You are a helpful AI assistant with access to relevant documents. " +
+		"Use the following document excerpts to help answer the user's question:\n\n" +
+		"=== RELEVANT DOCUMENTS ==={{.documents}}\n\n=== END DOCUMENTS ===\n\n" +
+		"When answering, reference specific information from the documents when relevant. " +
+		"If the documents don't contain information to answer the question, say so clearly. " +
+		"Answer in {{.language}}, even when the documents are written in another language."
+
+	// 过载保护配置
+	DefaultMaxConcurrentRequests = 32               // 同时进行的模型调用上限，0 表示不限制
+	DefaultShedQueueThreshold    = 16               // 排队请求数超过该值时拒绝低优先级请求
+	DefaultShedLatencyP95        = 30 * time.Second // 最近调用的 p95 延迟超过该值时拒绝低优先级请求
+
+	// HTTP 中间件与 gRPC 拦截器配置
+	DefaultRequestTimeout = 2 * time.Minute // 单个请求的超时，0 表示不限制
+	DefaultRateLimitRPS   = 0               // 每个 API Key 每秒允许的请求数，0 表示不限流
+	DefaultRateLimitBurst = 20              // 每个 API Key 允许的突发请求数
+
+	// 文档上传配置
+	DefaultMaxDocumentBytes = 512 << 20        // 单个上传文档的大小上限
+	DefaultUploadTimeout    = 30 * time.Minute // 上传与导入一个文档的超时，替代 REQUEST_TIMEOUT，0 表示不限制
+	DefaultUploadExpiry     = 24 * time.Hour   // 可续传上传的保留时间，过期后未完成的上传被删除
+
+	// Redis 配置 (地址通过 REDIS_URL 环境变量设置，用于多副本共享限流与预算计数)
+	DefaultRedisTimeout  = 100 * time.Millisecond // 单条 Redis 命令的超时，超时后退回进程内计数
+	DefaultRedisPoolSize = 16                     // 空闲连接池大小
+
+	// 依赖健康检查配置
+	DefaultHealthCheckTimeout  = 5 * time.Second  // 单次依赖探测的超时
+	DefaultHealthCheckInterval = 30 * time.Second // 后台探测间隔，也是 /api/health?deep=true 结果的缓存时间
+
+	// 优雅退出配置
+	DefaultShutdownDrainDelay = 5 * time.Second  // 收到退出信号后 /readyz 返回 503、继续服务的时间，让负载均衡摘除实例
+	DefaultShutdownTimeout    = 30 * time.Second // 等待进行中请求完成的最长时间
+	DefaultDrainRetryAfter    = 10 * time.Second // 维护排空期间拒绝新请求时建议客户端等待的时间
+
+	// 默认监听地址，可通过 HTTP_LISTEN_ADDRS/GRPC_LISTEN_ADDRS 指定多个地址 (逗号分隔)，
+	// unix:<路径> 表示 Unix domain socket (例如 sidecar 部署)
+	DefaultHTTPListenAddr = ":8080"
+	DefaultGRPCListenAddr = ":50051"
 )
 
 // 模型配置说明
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// contextCacheMetrics counts generation calls served from cached content,
+// created handles and failures, exported under /api/metrics
+var contextCacheMetrics = expvar.NewMap("context_cache")
+
+// metadataCachedContent is the CallOptions.Metadata key naming the cached
+// content a generation call continues from
+const metadataCachedContent = "cached_content"
+
+// contextCacheExpiryMargin is how long before their expiry handles stop being
+// used, so calls don't continue from content the provider already dropped
+const contextCacheExpiryMargin = time.Minute
+
+// contextCacheProvider is implemented by providers that can cache a prefix of
+// messages and continue generation calls from it
+type contextCacheProvider interface {
+	// CreateCachedContent caches messages for the model during ttl and
+	// returns the name of the cached content
+	CreateCachedContent(ctx context.Context, model string, messages []llms.MessageContent, ttl time.Duration) (string, error)
+}
+
+// contextCacheHandle is a prefix of messages cached by the provider
+type contextCacheHandle struct {
+	name      string
+	tokens    int
+	expiresAt time.Time
+}
+
+// contextCache caches long message prefixes that recur across requests, such
+// as system prompts, retrieved documents and conversation histories, with the
+// provider, and has generation calls continue from them so their tokens are
+// billed at the cached rate. A prefix is cached the second time it is seen,
+// when it has at least minTokens tokens more than the longest prefix already
+// cached: the turns of a conversation keep reusing its handle, and cache their
+// history again each time it grew by minTokens.
+type contextCache struct {
+	provider  contextCacheProvider
+	model     string
+	minTokens int
+	ttl       time.Duration
+
+	mu        sync.Mutex
+	handles   map[string]*contextCacheHandle
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// newContextCache creates a context cache for the provider, whose default
+// model is model
+func newContextCache(provider contextCacheProvider, model string, minTokens int, ttl time.Duration) *contextCache {
+	log.Printf("🗄️ Context cache enabled for prefixes of at least %d tokens, kept for %v", minTokens, ttl)
+	return &contextCache{
+		provider:  provider,
+		model:     model,
+		minTokens: minTokens,
+		ttl:       ttl,
+		handles:   make(map[string]*contextCacheHandle),
+		seen:      make(map[string]time.Time),
+		lastPrune: time.Now(),
+	}
+}
+
+// apply returns the messages and options of a generation call continuing
+// from the longest cached prefix of messages, caching a longer one first when
+// it recurs, or messages and options unchanged
+func (c *contextCache) apply(ctx context.Context, messages []llms.MessageContent, options []llms.CallOption) ([]llms.MessageContent, []llms.CallOption) {
+	var opts llms.CallOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+	// Cached content carries the system instruction and can't be combined
+	// with tools, and only text is cached
+	if len(opts.Tools) > 0 || len(messages) < 2 {
+		return messages, options
+	}
+	model := cmp.Or(opts.Model, c.model)
+
+	// keys[k] and tokens[k] identify and size the prefix of k messages.
+	// Prefixes must hold every system message and be followed by a user
+	// message, which the call then starts with.
+	keys := make([]string, len(messages)+1)
+	tokens := make([]int, len(messages)+1)
+	first := 1
+	h := sha256.New()
+	writeString(h, model)
+	for i, msg := range messages {
+		if msg.Role == llms.ChatMessageTypeSystem {
+			first = i + 1
+		}
+		writeString(h, string(msg.Role))
+		tokens[i+1] = tokens[i]
+		for _, part := range msg.Parts {
+			text, ok := part.(llms.TextContent)
+			if !ok {
+				return messages, options
+			}
+			writeString(h, text.Text)
+			tokens[i+1] += llm.CountTokens(model, text.Text)
+		}
+		keys[i+1] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if now.Sub(c.lastPrune) > time.Minute || len(c.seen) > MaxContextCachePrefixes {
+		c.pruneLocked(now)
+	}
+	var handle *contextCacheHandle
+	cached, create := 0, 0
+	for k := len(messages) - 1; k >= first; k-- {
+		if messages[k].Role != llms.ChatMessageTypeHuman {
+			continue
+		}
+		if found, ok := c.handles[keys[k]]; ok && handle == nil && now.Before(found.expiresAt) {
+			handle, cached = found, k
+		}
+		if _, ok := c.seen[keys[k]]; ok && create == 0 {
+			create = k
+		}
+	}
+	for k := first; k <= len(messages); k++ {
+		c.seen[keys[k]] = now
+	}
+	c.mu.Unlock()
+
+	if create > cached && tokens[create]-tokens[cached] >= c.minTokens {
+		name, err := c.provider.CreateCachedContent(ctx, model, messages[:create], c.ttl)
+		if err != nil {
+			contextCacheMetrics.Add("errors", 1)
+			log.Printf("⚠️ [contextCache] Failed to cache %d messages (%d tokens): %v", create, tokens[create], err)
+		} else {
+			contextCacheMetrics.Add("created", 1)
+			log.Printf("🗄️ [contextCache] Cached %d messages (%d tokens) as %s", create, tokens[create], name)
+			handle = &contextCacheHandle{name: name, tokens: tokens[create], expiresAt: now.Add(c.ttl - contextCacheExpiryMargin)}
+			cached = create
+			c.mu.Lock()
+			c.handles[keys[create]] = handle
+			c.mu.Unlock()
+		}
+	}
+	if handle == nil {
+		return messages, options
+	}
+
+	contextCacheMetrics.Add("hits", 1)
+	contextCacheMetrics.Add("cached_tokens", int64(handle.tokens))
+	return messages[cached:], append(options, withCachedContent(handle.name))
+}
+
+// pruneLocked drops expired handles and prefixes not seen within the TTL,
+// and all prefixes when there are still too many. Must be called with c.mu
+// held.
+func (c *contextCache) pruneLocked(now time.Time) {
+	for key, handle := range c.handles {
+		if !now.Before(handle.expiresAt) {
+			delete(c.handles, key)
+		}
+	}
+	for key, seen := range c.seen {
+		if now.Sub(seen) > c.ttl {
+			delete(c.seen, key)
+		}
+	}
+	if len(c.seen) > MaxContextCachePrefixes {
+		clear(c.seen)
+	}
+	c.lastPrune = now
+}
+
+// withCachedContent makes a generation call continue from cached content,
+// keeping the metadata set by other options
+func withCachedContent(name string) llms.CallOption {
+	return func(o *llms.CallOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]any)
+		}
+		o.Metadata[metadataCachedContent] = name
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportMetrics counts dataset exports, their failures, and the examples
+// exported and PII redacted, exported under /api/metrics
+var exportMetrics = expvar.NewMap("dataset_exports")
+
+// exportJanitorInterval is how often expired dataset exports are removed
+const exportJanitorInterval = 10 * time.Minute
+
+// datasetExport is a fine-tuning dataset exported from the feedback of a
+// tenant, written to <id>.jsonl in the export directory
+type datasetExport struct {
+	id         string
+	keyID      string
+	tenant     string
+	request    *genaidemo.CreateDatasetExportRequest
+	status     genaidemo.JobStatus
+	examples   int64
+	redactions int64
+	size       int64
+	err        string
+	createdAt  time.Time
+	finishedAt time.Time
+}
+
+// exportStore runs dataset exports in the background, at most
+// MaxActiveExports at once, and keeps their files for the retention period.
+// Exports are kept in memory only; files left by a previous run are removed.
+type exportStore struct {
+	dir       string
+	retention time.Duration
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	exports map[string]*datasetExport
+}
+
+// newExportStore creates an export store writing to dir, the system temp
+// directory when empty
+func newExportStore(dir string, retention time.Duration) (*exportStore, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, "exports")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range stale {
+		os.Remove(path)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &exportStore{
+		dir:       dir,
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+		exports:   make(map[string]*datasetExport),
+	}
+	s.wg.Add(1)
+	go s.janitor()
+	return s, nil
+}
+
+// path returns the file of an export
+func (s *exportStore) path(id string) string {
+	return filepath.Join(s.dir, id+".jsonl")
+}
+
+// create starts exporting the given feedback records of the caller's tenant
+// that match the request and returns the running export
+func (s *exportStore) create(ctx context.Context, req *genaidemo.CreateDatasetExportRequest, records []*feedbackRecord) (*genaidemo.DatasetExport, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate export id: %v", err)
+	}
+	e := &datasetExport{
+		id:        id,
+		keyID:     apiKeyIDFromContext(ctx),
+		tenant:    tenantID(ctx),
+		request:   req,
+		status:    genaidemo.JobStatus_JOB_STATUS_RUNNING,
+		createdAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := 0
+	for _, other := range s.exports {
+		if other.status == genaidemo.JobStatus_JOB_STATUS_RUNNING {
+			active++
+		}
+	}
+	if active >= MaxActiveExports {
+		return nil, status.Error(codes.ResourceExhausted, "too many dataset exports running, try again later")
+	}
+	s.exports[id] = e
+
+	s.wg.Add(1)
+	go s.run(e, records)
+	log.Printf("📤 [exports] Export %s started (%s, %d feedback records)", id, req.Format, len(records))
+	exportMetrics.Add("started", 1)
+	return e.toProto(), nil
+}
+
+// get returns an export of the caller's tenant and API key
+func (s *exportStore) get(ctx context.Context, id string) (*genaidemo.DatasetExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.lookup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return e.toProto(), nil
+}
+
+// open opens the file of a finished export of the caller's tenant and API key
+func (s *exportStore) open(ctx context.Context, id string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.lookup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.status != genaidemo.JobStatus_JOB_STATUS_SUCCEEDED {
+		return nil, status.Errorf(codes.FailedPrecondition, "export %s is %s", id, e.status)
+	}
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to open export: %v", err)
+	}
+	return f, nil
+}
+
+// lookup returns an export, hiding the ones of other tenants and API keys.
+// Callers must hold the lock.
+func (s *exportStore) lookup(ctx context.Context, id string) (*datasetExport, error) {
+	e := s.exports[id]
+	if e == nil || e.tenant != tenantID(ctx) || e.keyID != apiKeyIDFromContext(ctx) {
+		return nil, status.Errorf(codes.NotFound, "export %s not found", id)
+	}
+	return e, nil
+}
+
+// run writes the matching records to the export file, one fine-tuning
+// example per line with PII redacted
+func (s *exportStore) run(e *datasetExport, records []*feedbackRecord) {
+	defer s.wg.Done()
+
+	examples, redactions, size, err := s.write(e.id, e.request, records)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.finishedAt = time.Now()
+	if err != nil {
+		os.Remove(s.path(e.id))
+		e.status = genaidemo.JobStatus_JOB_STATUS_FAILED
+		e.err = err.Error()
+		exportMetrics.Add("errors", 1)
+		log.Printf("❌ [exports] Export %s failed: %v", e.id, err)
+		return
+	}
+	e.status = genaidemo.JobStatus_JOB_STATUS_SUCCEEDED
+	e.examples, e.redactions, e.size = examples, redactions, size
+	exportMetrics.Add("examples", examples)
+	exportMetrics.Add("redactions", redactions)
+	log.Printf("✅ [exports] Export %s finished: %d examples, %d redactions", e.id, examples, redactions)
+}
+
+// write writes the export file and returns the number of examples and
+// redactions and the file size
+func (s *exportStore) write(id string, req *genaidemo.CreateDatasetExportRequest, records []*feedbackRecord) (int64, int64, int64, error) {
+	f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	var examples, redactions int64
+	for _, r := range records {
+		if s.ctx.Err() != nil {
+			return 0, 0, 0, fmt.Errorf("export canceled: %w", s.ctx.Err())
+		}
+		if !exportMatches(req, r) {
+			continue
+		}
+		query, n := redactPII(r.Query)
+		reply, m := redactPII(r.Reply)
+		if err := enc.Encode(fineTuningExample(req.Format, query, reply)); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to write export: %w", err)
+		}
+		examples++
+		redactions += int64(n + m)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to write export: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to write export: %w", err)
+	}
+	return examples, redactions, info.Size(), nil
+}
+
+// janitor removes expired exports and their files until the store is closed
+func (s *exportStore) janitor() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(exportJanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for id, e := range s.exports {
+				if e.status != genaidemo.JobStatus_JOB_STATUS_RUNNING && now.Sub(e.finishedAt) > s.retention {
+					delete(s.exports, id)
+					os.Remove(s.path(id))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// close cancels running exports and waits for them
+func (s *exportStore) close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// toProto converts the export into its API representation. Callers must
+// hold the lock.
+func (e *datasetExport) toProto() *genaidemo.DatasetExport {
+	pb := &genaidemo.DatasetExport{
+		Id:         e.id,
+		Status:     e.status,
+		Format:     e.request.Format,
+		Examples:   e.examples,
+		Redactions: e.redactions,
+		Size:       e.size,
+		Error:      e.err,
+		CreatedAt:  e.createdAt.Unix(),
+	}
+	if !e.finishedAt.IsZero() {
+		pb.FinishedAt = e.finishedAt.Unix()
+	}
+	return pb
+}
+
+// exportMatches reports whether a feedback record passes the filters of an
+// export request
+func exportMatches(req *genaidemo.CreateDatasetExportRequest, r *feedbackRecord) bool {
+	switch {
+	case r.Query == "" || r.Reply == "":
+		return false
+	case len(req.Modes) > 0 && !slices.Contains(req.Modes, genaidemo.Mode(genaidemo.Mode_value[r.Mode])):
+		return false
+	case req.Thumb != genaidemo.Thumb_THUMB_UNSPECIFIED && r.Thumb != req.Thumb.String():
+		return false
+	case req.MinRating > 0 && r.Rating < req.MinRating:
+		return false
+	case req.StartTime > 0 && r.SubmittedAt.Before(time.Unix(req.StartTime, 0)):
+		return false
+	case req.EndTime > 0 && !r.SubmittedAt.Before(time.Unix(req.EndTime, 0)):
+		return false
+	}
+	return true
+}
+
+// openAIMessage and geminiContent are the turns of the OpenAI chat and the
+// Gemini (Vertex AI supervised tuning) fine-tuning formats
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+// fineTuningExample returns a single-turn example in the given format
+func fineTuningExample(format genaidemo.ExportFormat, query, reply string) any {
+	if format == genaidemo.ExportFormat_EXPORT_FORMAT_GEMINI {
+		return map[string][]geminiContent{"contents": {
+			{Role: "user", Parts: []geminiPart{{Text: query}}},
+			{Role: "model", Parts: []geminiPart{{Text: reply}}},
+		}}
+	}
+	return map[string][]openAIMessage{"messages": {
+		{Role: "user", Content: query},
+		{Role: "assistant", Content: reply},
+	}}
+}
+
+// validateDatasetExport rejects export requests without a format or with
+// invalid filters
+func validateDatasetExport(req *genaidemo.CreateDatasetExportRequest) error {
+	switch {
+	case req.Format == genaidemo.ExportFormat_EXPORT_FORMAT_UNSPECIFIED || genaidemo.ExportFormat_name[int32(req.Format)] == "":
+		return status.Error(codes.InvalidArgument, "format must be EXPORT_FORMAT_OPENAI or EXPORT_FORMAT_GEMINI")
+	case genaidemo.Thumb_name[int32(req.Thumb)] == "":
+		return status.Error(codes.InvalidArgument, "invalid thumb")
+	case req.MinRating < 0 || req.MinRating > MaxFeedbackRating:
+		return status.Errorf(codes.InvalidArgument, "min_rating must be between 0 and %d", MaxFeedbackRating)
+	case req.StartTime > 0 && req.EndTime > 0 && req.StartTime >= req.EndTime:
+		return status.Error(codes.InvalidArgument, "start time must be before end time")
+	}
+	for _, mode := range req.Modes {
+		if mode == genaidemo.Mode_MODE_UNKNOWN || genaidemo.Mode_name[int32(mode)] == "" {
+			return status.Errorf(codes.InvalidArgument, "unsupported mode: %s", mode)
+		}
+	}
+	return nil
+}
+
+// CreateDatasetExport handles the CreateDatasetExport gRPC method. Exports
+// contain user conversations, so they require an admin API key.
+func (h *Handler) CreateDatasetExport(ctx context.Context, req *genaidemo.CreateDatasetExportRequest) (*genaidemo.DatasetExport, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if !h.feedback.enabled() {
+		return nil, status.Error(codes.Unavailable, "feedback is not configured")
+	}
+	if err := validateDatasetExport(req); err != nil {
+		return nil, err
+	}
+	return h.exports.create(ctx, req, h.feedback.list(tenantID(ctx)))
+}
+
+// GetDatasetExport handles the GetDatasetExport gRPC method
+func (h *Handler) GetDatasetExport(ctx context.Context, req *genaidemo.GetDatasetExportRequest) (*genaidemo.DatasetExport, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "export id cannot be empty")
+	}
+	return h.exports.get(ctx, req.Id)
+}
+
+// OpenDatasetExport opens the JSONL file of a finished dataset export
+func (h *Handler) OpenDatasetExport(ctx context.Context, id string) (*os.File, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return h.exports.open(ctx, id)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// uploadMetrics counts uploaded documents and bytes, and rejected uploads,
+// exported under /api/metrics
+var uploadMetrics = expvar.NewMap("uploads")
+
+// uploadDocumentMethod is the gRPC method of document uploads, which are
+// bounded by the upload timeout instead of the request timeout
+const uploadDocumentMethod = "/genaidemo.ChatService/UploadDocument"
+
+// uploadProgressInterval is the number of bytes received between progress
+// messages
+const uploadProgressInterval = 4 << 20
+
+// UploadDocument handles the UploadDocument gRPC method. The document is
+// spooled to a temporary file as its chunks arrive, checked against the size
+// and checksum of its info, then ingested through the ChromaDB service.
+func (h *Handler) UploadDocument(stream genaidemo.ChatService_UploadDocumentServer) error {
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "upload is empty")
+	}
+	if err != nil {
+		return err
+	}
+	info := first.GetInfo()
+	if info == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the document info")
+	}
+	if err := h.checkDocumentInfo(info); err != nil {
+		uploadMetrics.Add("rejected", 1)
+		return err
+	}
+	if err := h.tenants.checkCollection(tenantFromContext(ctx), info.Collection); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(h.uploadDir, "upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create upload file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	received, err := h.receiveDocument(stream, info, file)
+	if err != nil {
+		uploadMetrics.Add("rejected", 1)
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind upload file: %w", err)
+	}
+
+	if err := stream.Send(&genaidemo.UploadDocumentResponse{
+		Stage:         genaidemo.UploadStage_UPLOAD_STAGE_INGESTING,
+		ReceivedBytes: received,
+		TotalBytes:    received,
+	}); err != nil {
+		return err
+	}
+	result, err := h.service.IngestDocument(ctx, ChromaDBIngestRequest{
+		Filename:    info.Filename,
+		ContentType: info.MimeType,
+		Collection:  info.Collection,
+		Metadata:    info.Metadata,
+	}, file)
+	if err != nil {
+		return err
+	}
+	if h.graph.enabled() {
+		if text := graphDocumentText(info.Filename, info.MimeType, file); text != "" {
+			h.learnGraph(ctx, info.Filename, text)
+		}
+	}
+
+	uploadMetrics.Add("documents", 1)
+	uploadMetrics.Add("bytes", received)
+	return stream.Send(&genaidemo.UploadDocumentResponse{
+		Stage:         genaidemo.UploadStage_UPLOAD_STAGE_DONE,
+		ReceivedBytes: received,
+		TotalBytes:    received,
+		Source:        result.Source,
+		Chunks:        int32(result.Chunks),
+	})
+}
+
+// checkDocumentInfo validates the info of an upload before any content is
+// received, so oversized documents are rejected right away
+func (h *Handler) checkDocumentInfo(info *genaidemo.DocumentInfo) error {
+	if info.Filename == "" || info.Filename != filepath.Base(info.Filename) || strings.ContainsAny(info.Filename, `/\`) {
+		return status.Error(codes.InvalidArgument, "filename must be a file name without a directory")
+	}
+	if info.Size < 0 || info.Size > h.maxDocumentBytes {
+		return status.Errorf(codes.InvalidArgument, "document size must be at most %d bytes", h.maxDocumentBytes)
+	}
+	if info.Sha256 != "" {
+		if sum, err := hex.DecodeString(info.Sha256); err != nil || len(sum) != sha256.Size {
+			return status.Error(codes.InvalidArgument, "sha256 must be a hex SHA-256 digest")
+		}
+	}
+	return nil
+}
+
+// receiveDocument writes the chunks of an upload to file, reporting progress
+// every uploadProgressInterval bytes, and returns the document size
+func (h *Handler) receiveDocument(stream genaidemo.ChatService_UploadDocumentServer, info *genaidemo.DocumentInfo, file *os.File) (int64, error) {
+	hash := sha256.New()
+	out := io.MultiWriter(file, hash)
+	var received, reported int64
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return received, err
+		}
+		if msg.GetInfo() != nil {
+			return received, status.Error(codes.InvalidArgument, "the document info must only be sent first")
+		}
+
+		chunk := msg.GetChunk()
+		received += int64(len(chunk))
+		if received > h.maxDocumentBytes {
+			return received, status.Errorf(codes.InvalidArgument, "document exceeds %d bytes", h.maxDocumentBytes)
+		}
+		if _, err := out.Write(chunk); err != nil {
+			return received, fmt.Errorf("failed to write upload file: %w", err)
+		}
+
+		if received-reported >= uploadProgressInterval {
+			reported = received
+			if err := stream.Send(&genaidemo.UploadDocumentResponse{
+				Stage:         genaidemo.UploadStage_UPLOAD_STAGE_RECEIVING,
+				ReceivedBytes: received,
+				TotalBytes:    info.Size,
+			}); err != nil {
+				return received, err
+			}
+		}
+	}
+
+	if received == 0 {
+		return 0, status.Error(codes.InvalidArgument, "document is empty")
+	}
+	if info.Size > 0 && received != info.Size {
+		return received, status.Errorf(codes.InvalidArgument, "received %d bytes, expected %d", received, info.Size)
+	}
+	if info.Sha256 != "" && !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), info.Sha256) {
+		return received, status.Error(codes.DataLoss, "document checksum does not match sha256")
+	}
+	log.Printf("📄 Received %s (%d bytes)", info.Filename, received)
+	return received, nil
+}
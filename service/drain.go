@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// drainMethod is the gRPC method of the drain operation, which keeps being
+// served while the instance drains
+const drainMethod = "/genaidemo.ChatService/Drain"
+
+// Drain handles the Drain gRPC method. It puts the instance in maintenance:
+// readiness turns false, new API requests are rejected with Unavailable, and
+// the process exits once in-flight requests and jobs have finished.
+func (h *Handler) Drain(ctx context.Context, req *genaidemo.DrainRequest) (*genaidemo.DrainResponse, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	h.drainOnce.Do(func() {
+		log.Printf("🚧 Drain requested by %s, rejecting new requests", apiKeyIDFromContext(ctx))
+		h.maintenance.Store(true)
+		h.draining.Store(true)
+		close(h.drainRequested)
+	})
+	return &genaidemo.DrainResponse{ActiveJobs: int32(h.jobs.active())}, nil
+}
+
+// drained is closed once the Drain operation was called
+func (h *Handler) drained() <-chan struct{} {
+	return h.drainRequested
+}
+
+// checkAccepting returns Unavailable with a retry delay while the instance
+// is in maintenance, so clients retry on another instance
+func (h *Handler) checkAccepting() error {
+	if !h.maintenance.Load() {
+		return nil
+	}
+	return statusWithDetails(status.New(codes.Unavailable, "service is draining, retry on another instance"),
+		errorInfo(reasonDraining, nil), retryInfo(DefaultDrainRetryAfter)).Err()
+}
+
+// drainMiddleware rejects API requests with 503 and a Retry-After header
+// while the instance is in maintenance
+func drainMiddleware(handler *Handler) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := handler.checkAccepting(); err != nil {
+				sendError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// drainingRPC reports whether a gRPC method is rejected while draining: the
+// API methods except Drain itself, but not the health service
+func drainingRPC(method string) bool {
+	return strings.HasPrefix(method, "/genaidemo.") && method != drainMethod
+}
+
+// drainUnaryInterceptor rejects API calls while the instance is in maintenance
+func drainUnaryInterceptor(handler *Handler) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if drainingRPC(info.FullMethod) {
+			if err := handler.checkAccepting(); err != nil {
+				return nil, err
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// drainStreamInterceptor rejects API streams while the instance is in
+// maintenance
+func drainStreamInterceptor(handler *Handler) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, next grpc.StreamHandler) error {
+		if drainingRPC(info.FullMethod) {
+			if err := handler.checkAccepting(); err != nil {
+				return err
+			}
+		}
+		return next(srv, ss)
+	}
+}
+
+type HTTPDrainResponse struct {
+	Draining   bool  `json:"draining"`
+	ActiveJobs int32 `json:"active_jobs"`
+}
+
+// drainHTTPHandler serves POST /api/admin/drain
+func drainHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp, err := handler.Drain(r.Context(), &genaidemo.DrainRequest{})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(&HTTPDrainResponse{Draining: true, ActiveJobs: resp.ActiveJobs})
+	}
+}
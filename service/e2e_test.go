@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/client"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// API keys of the end-to-end harness: two regular keys and an admin key
+const (
+	userKey  = "e2e-user-key"
+	otherKey = "e2e-other-key"
+	adminKey = "e2e-admin-key"
+)
+
+// e2eTransports are the APIs the SDK-covered endpoints are exercised over
+var e2eTransports = []struct {
+	name   string
+	client func(h *testHarness, opts ...client.Option) *client.Client
+}{
+	{"http", (*testHarness).client},
+	{"grpc", (*testHarness).grpcClient},
+}
+
+// newE2EHarness starts a harness with the scripted mock LLM, the e2e API
+// keys, a canned weather search and a handbook document. configure functions
+// may adjust the configuration further.
+func newE2EHarness(t *testing.T, configure ...func(cfg *serviceConfig)) *testHarness {
+	t.Helper()
+	h, err := newTestHarness(append([]func(cfg *serviceConfig){func(cfg *serviceConfig) {
+		cfg.mockLLMScript = "testdata/e2e_script.json"
+		cfg.apiKeys = map[string]bool{apiKeyID(userKey): true, apiKeyID(otherKey): true, apiKeyID(adminKey): true}
+		cfg.adminKeys = map[string]bool{apiKeyID(adminKey): true}
+	}}, configure...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+
+	h.search.setResult("weather berlin", "Sunny, 21°C")
+	h.docs.add("doc-1", "handbook.pdf", "Employees get 25 vacation days per year.")
+	return h
+}
+
+// grpcContext returns a context carrying key as the x-api-key metadata
+func grpcContext(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), strings.ToLower(apiKeyHeader), key)
+}
+
+// doJSON sends in as JSON to the harness HTTP API with key, decodes a
+// successful response into out and returns the HTTP status code
+func (h *testHarness) doJSON(t *testing.T, key, method, path string, in, out any) int {
+	t.Helper()
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, h.server.URL+path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiKeyHeader, key)
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 && out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func userMessage(content string) []*genaidemo.Message {
+	return []*genaidemo.Message{{Role: genaidemo.Role_ROLE_USER, Content: content}}
+}
+
+func TestE2EChat(t *testing.T) {
+	h := newE2EHarness(t)
+
+	tests := []struct {
+		name        string
+		mode        genaidemo.Mode
+		input       string
+		wantContent string
+		wantSource  string
+	}{
+		{"chat", genaidemo.Mode_MODE_CHAT, "Hello there", "Mock response to: Hello there", ""},
+		{"tool", genaidemo.Mode_MODE_TOOL, "What is the weather in Berlin?", "Sunny, 21°C", ""},
+		{"agent", genaidemo.Mode_MODE_AGENT, "Plan my day", "Mock response to: Plan my day", ""},
+		{"doc", genaidemo.Mode_MODE_DOC, "How many vacation days do employees get?", "", "handbook.pdf"},
+	}
+	for _, tr := range e2eTransports {
+		for _, tt := range tests {
+			t.Run(tr.name+"/"+tt.name, func(t *testing.T) {
+				// The transport is part of the input so responses aren't served from the cache
+				input := tt.input + " (" + tr.name + ")"
+				resp, err := tr.client(h, client.WithAPIKey(userKey)).Send(context.Background(), tt.mode, &genaidemo.ChatRequest{
+					Messages: userMessage(input),
+				})
+				if err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+				if resp.Content == "" || !strings.Contains(resp.Content, tt.wantContent) {
+					t.Errorf("content = %q, want %q", resp.Content, tt.wantContent)
+				}
+				if resp.TokenUsage.GetTotalTokenNum() <= 0 {
+					t.Errorf("token usage = %v, want tokens", resp.TokenUsage)
+				}
+				if tt.wantSource != "" && !slices.ContainsFunc(resp.Sources, func(d *genaidemo.RetrievedDocument) bool {
+					return d.Filename == tt.wantSource
+				}) {
+					t.Errorf("sources = %v, want %s", resp.Sources, tt.wantSource)
+				}
+			})
+		}
+	}
+	if !slices.Contains(h.search.queries(), "weather berlin") {
+		t.Errorf("searched %v, want weather berlin", h.search.queries())
+	}
+}
+
+func TestE2EChatAutoV2(t *testing.T) {
+	h := newE2EHarness(t)
+
+	resp, err := genaidemo.NewChatServiceV2Client(h.conn).ChatAuto(grpcContext(userKey), &genaidemo.ChatRequest{
+		Messages: userMessage("Hello there (grpc)"),
+	})
+	if err != nil {
+		t.Fatalf("ChatAuto: %v", err)
+	}
+	if resp.Mode != genaidemo.Mode_MODE_CHAT || !strings.Contains(resp.Content, "Hello there") {
+		t.Errorf("response = %s %q, want MODE_CHAT with the mock reply", resp.Mode, resp.Content)
+	}
+
+	var httpResp HTTPChatResponseV2
+	req := &HTTPChatRequest{Messages: []HTTPMessage{{Role: "ROLE_USER", Content: "Hello there (http)"}}}
+	if got := h.doJSON(t, userKey, http.MethodPost, "/api/v2/chat/auto", req, &httpResp); got != http.StatusOK {
+		t.Fatalf("POST /api/v2/chat/auto: status = %d, want 200", got)
+	}
+	if httpResp.Mode != "MODE_CHAT" || httpResp.Timings == nil {
+		t.Errorf("response = %s with timings %v, want MODE_CHAT with timings", httpResp.Mode, httpResp.Timings)
+	}
+}
+
+func TestE2EChatErrors(t *testing.T) {
+	h := newE2EHarness(t)
+
+	tests := []struct {
+		name string
+		key  string
+		req  *genaidemo.ChatRequest
+		want codes.Code
+	}{
+		{"no messages", userKey, &genaidemo.ChatRequest{}, codes.InvalidArgument},
+		{"missing key", "", &genaidemo.ChatRequest{Messages: userMessage("Hello")}, codes.Unauthenticated},
+		{"unknown key", "not-a-key", &genaidemo.ChatRequest{Messages: userMessage("Hello")}, codes.Unauthenticated},
+	}
+	for _, tr := range e2eTransports {
+		for _, tt := range tests {
+			t.Run(tr.name+"/"+tt.name, func(t *testing.T) {
+				_, err := tr.client(h, client.WithAPIKey(tt.key)).Chat(context.Background(), tt.req)
+				if got := status.Code(err); got != tt.want {
+					t.Errorf("code = %v (%v), want %v", got, err, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// signJWT returns an HS256 token with the given claims signed with secret
+func signJWT(t *testing.T, secret string, claims map[string]any) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestE2EBearerTokens(t *testing.T) {
+	const secret = "e2e-jwt-secret"
+	h := newE2EHarness(t, func(cfg *serviceConfig) { cfg.jwtSecret = secret })
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"valid", signJWT(t, secret, map[string]any{"sub": "billing-service", "exp": exp}), codes.OK},
+		{"expired", signJWT(t, secret, map[string]any{"sub": "billing-service", "exp": time.Now().Add(-time.Minute).Unix()}), codes.Unauthenticated},
+		{"no expiry", signJWT(t, secret, map[string]any{"sub": "billing-service"}), codes.Unauthenticated},
+		{"not yet valid", signJWT(t, secret, map[string]any{"sub": "billing-service", "exp": exp, "nbf": exp}), codes.Unauthenticated},
+		{"no subject", signJWT(t, secret, map[string]any{"exp": exp}), codes.Unauthenticated},
+		{"other secret", signJWT(t, "not-the-secret", map[string]any{"sub": "billing-service", "exp": exp}), codes.Unauthenticated},
+		{"malformed", "not-a-jwt", codes.Unauthenticated},
+	}
+	for _, tr := range e2eTransports {
+		for _, tt := range tests {
+			t.Run(tr.name+"/"+tt.name, func(t *testing.T) {
+				_, err := tr.client(h, client.WithBearerToken(tt.token)).Chat(context.Background(), &genaidemo.ChatRequest{
+					Messages: userMessage("Hello with a token (" + tr.name + ")"),
+				})
+				if got := status.Code(err); got != tt.want {
+					t.Errorf("code = %v (%v), want %v", got, err, tt.want)
+				}
+			})
+		}
+	}
+
+	// Valid tokens are billed to their subject
+	usage, err := genaidemo.NewChatServiceClient(h.conn).GetUsage(grpcContext(adminKey), &genaidemo.GetUsageRequest{KeyId: jwtKeyIDPrefix + "billing-service"})
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if len(usage.Records) == 0 {
+		t.Error("no usage recorded for the token's subject")
+	}
+}
+
+func TestE2EChatSizeLimits(t *testing.T) {
+	h := newE2EHarness(t)
+
+	tests := []struct {
+		name    string
+		message HTTPMessage
+		want    int
+	}{
+		{"oversized audio", HTTPMessage{Role: "ROLE_USER", Audio: &HTTPAudio{Data: make([]byte, maxAudioBytes+1), MimeType: "audio/wav"}}, http.StatusBadRequest},
+		{"oversized body", HTTPMessage{Role: "ROLE_USER", Content: strings.Repeat("a", 2*maxAudioBytes)}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &HTTPChatRequest{Messages: []HTTPMessage{tt.message}}
+			if got := h.doJSON(t, userKey, http.MethodPost, "/api/chat", req, nil); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestE2EJobs(t *testing.T) {
+	h := newE2EHarness(t)
+
+	for _, tr := range e2eTransports {
+		t.Run(tr.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := tr.client(h, client.WithAPIKey(userKey), client.WithUserID("alice"))
+			job, err := c.SubmitChat(ctx, genaidemo.Mode_MODE_CHAT, &genaidemo.ChatRequest{
+				Messages:  userMessage("Queued hello (" + tr.name + ")"),
+				SessionId: proto.String("job-" + tr.name),
+			})
+			if err != nil {
+				t.Fatalf("SubmitChat: %v", err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for job.Status != genaidemo.JobStatus_JOB_STATUS_SUCCEEDED {
+				if job.Status == genaidemo.JobStatus_JOB_STATUS_FAILED || time.Now().After(deadline) {
+					t.Fatalf("job = %v, want succeeded", job)
+				}
+				time.Sleep(10 * time.Millisecond)
+				if job, err = c.GetJob(ctx, job.JobId); err != nil {
+					t.Fatalf("GetJob: %v", err)
+				}
+			}
+			if !strings.Contains(job.Response.GetContent(), "Queued hello") {
+				t.Errorf("content = %q, want the mock reply", job.Response.GetContent())
+			}
+
+			_, err = tr.client(h, client.WithAPIKey(otherKey)).GetJob(ctx, job.JobId)
+			if got := status.Code(err); got != codes.NotFound {
+				t.Errorf("GetJob with another key: code = %v, want NotFound", got)
+			}
+
+			// The job ran as its submitter's user
+			userCtx := metadata.AppendToOutgoingContext(grpcContext(userKey), userIDHeader, "alice")
+			sessions, err := genaidemo.NewChatServiceClient(h.conn).ListSessions(userCtx, &genaidemo.ListSessionsRequest{})
+			if err != nil {
+				t.Fatalf("ListSessions: %v", err)
+			}
+			if !slices.ContainsFunc(sessions.Sessions, func(s *genaidemo.ChatSession) bool { return s.SessionId == "job-"+tr.name }) {
+				t.Errorf("sessions of alice = %v, want the job's session", sessions.Sessions)
+			}
+		})
+	}
+}
+
+func TestE2EEvaluateResponse(t *testing.T) {
+	h := newE2EHarness(t)
+
+	for _, tr := range e2eTransports {
+		t.Run(tr.name, func(t *testing.T) {
+			resp, err := tr.client(h, client.WithAPIKey(userKey)).EvaluateResponse(context.Background(), &genaidemo.EvaluateResponseRequest{
+				Messages: userMessage("How many vacation days do employees get?"),
+				Response: "25 days (" + tr.name + ")",
+				Criteria: []string{"relevance"},
+			})
+			if err != nil {
+				t.Fatalf("EvaluateResponse: %v", err)
+			}
+			if len(resp.Scores) != 1 || resp.Scores[0].Criterion != "relevance" || resp.Scores[0].Score != 4 {
+				t.Errorf("scores = %v, want relevance 4", resp.Scores)
+			}
+			if resp.OverallScore != 4 {
+				t.Errorf("overall score = %v, want 4", resp.OverallScore)
+			}
+		})
+	}
+}
+
+func TestE2EEvaluateResponseBudget(t *testing.T) {
+	h := newE2EHarness(t, func(cfg *serviceConfig) {
+		cfg.monthlyBudgets = map[string]float64{apiKeyID(otherKey): 1}
+	})
+	// otherKey already spent its monthly budget
+	h.handler.usage.add(apiKeyID(otherKey), 1000, 0, 100, 2, 0)
+
+	for _, tr := range e2eTransports {
+		t.Run(tr.name, func(t *testing.T) {
+			req := &genaidemo.EvaluateResponseRequest{
+				Messages: userMessage("How many vacation days do employees get?"),
+				Response: "25 days (" + tr.name + ")",
+				Criteria: []string{"relevance"},
+			}
+			_, err := tr.client(h, client.WithAPIKey(otherKey), client.WithRetry(1, 0)).EvaluateResponse(context.Background(), req)
+			if got := status.Code(err); got != codes.ResourceExhausted {
+				t.Fatalf("over budget: code = %v (%v), want ResourceExhausted", got, err)
+			}
+			if _, err := tr.client(h, client.WithAPIKey(userKey)).EvaluateResponse(context.Background(), req); err != nil {
+				t.Errorf("within budget: %v", err)
+			}
+		})
+	}
+}
+
+func TestE2EClassify(t *testing.T) {
+	h := newE2EHarness(t)
+	labels := []*genaidemo.ClassLabel{{Name: "billing"}, {Name: "technical"}}
+
+	tests := []struct {
+		name      string
+		text      string
+		labels    []*genaidemo.ClassLabel
+		wantCode  codes.Code
+		wantHTTP  int
+		wantLabel string
+	}{
+		{"label", "I was charged twice this month", labels, codes.OK, http.StatusOK, "billing"},
+		{"empty text", "", labels, codes.InvalidArgument, http.StatusBadRequest, ""},
+		{"one label", "I was charged twice", labels[:1], codes.InvalidArgument, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run("grpc/"+tt.name, func(t *testing.T) {
+			resp, err := genaidemo.NewChatServiceClient(h.conn).Classify(grpcContext(userKey), &genaidemo.ClassifyRequest{
+				Text:   tt.text,
+				Labels: tt.labels,
+			})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v (%v), want %v", got, err, tt.wantCode)
+			}
+			if err == nil && (resp.Label != tt.wantLabel || resp.Confidence != 0.9) {
+				t.Errorf("classification = %s %v, want %s 0.9", resp.Label, resp.Confidence, tt.wantLabel)
+			}
+		})
+		t.Run("http/"+tt.name, func(t *testing.T) {
+			req := &HTTPClassifyRequest{Text: tt.text}
+			for _, l := range tt.labels {
+				req.Labels = append(req.Labels, &HTTPClassLabel{Name: l.Name})
+			}
+			var resp HTTPClassifyResponse
+			if got := h.doJSON(t, userKey, http.MethodPost, "/api/classify", req, &resp); got != tt.wantHTTP {
+				t.Fatalf("status = %d, want %d", got, tt.wantHTTP)
+			}
+			if tt.wantHTTP == http.StatusOK && resp.Label != tt.wantLabel {
+				t.Errorf("label = %q, want %q", resp.Label, tt.wantLabel)
+			}
+		})
+	}
+}
+
+func TestE2ESummarize(t *testing.T) {
+	h := newE2EHarness(t)
+
+	tests := []struct {
+		name     string
+		text     string
+		wantCode codes.Code
+		wantHTTP int
+	}{
+		{"text", "The quarterly report shows revenue grew by ten percent.", codes.OK, http.StatusOK},
+		{"no input", "", codes.InvalidArgument, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run("grpc/"+tt.name, func(t *testing.T) {
+			resp, err := genaidemo.NewChatServiceClient(h.conn).Summarize(grpcContext(userKey), &genaidemo.SummarizeRequest{Text: tt.text})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v (%v), want %v", got, err, tt.wantCode)
+			}
+			if err == nil && (resp.Summary == "" || resp.TokenUsage.GetTotalTokenNum() <= 0) {
+				t.Errorf("summary = %q, usage %v, want a summary and tokens", resp.Summary, resp.TokenUsage)
+			}
+		})
+		t.Run("http/"+tt.name, func(t *testing.T) {
+			var resp HTTPSummarizeResponse
+			if got := h.doJSON(t, userKey, http.MethodPost, "/api/summarize", &HTTPSummarizeRequest{Text: tt.text}, &resp); got != tt.wantHTTP {
+				t.Fatalf("status = %d, want %d", got, tt.wantHTTP)
+			}
+			if tt.wantHTTP == http.StatusOK && resp.Summary == "" {
+				t.Error("empty summary")
+			}
+		})
+	}
+}
+
+func TestE2EUsage(t *testing.T) {
+	h := newE2EHarness(t)
+	if _, err := h.client(client.WithAPIKey(userKey)).Chat(context.Background(), &genaidemo.ChatRequest{
+		Messages: userMessage("Hello"),
+	}); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	userID := apiKeyID(userKey)
+
+	tests := []struct {
+		name     string
+		key      string
+		keyID    string
+		wantCode codes.Code
+		wantHTTP int
+		// wantUsage is whether the user's chat must be reported
+		wantUsage bool
+	}{
+		{"own usage", userKey, "", codes.OK, http.StatusOK, true},
+		{"other key", otherKey, userID, codes.PermissionDenied, http.StatusForbidden, false},
+		{"admin", adminKey, userID, codes.OK, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		check := func(t *testing.T, records []*genaidemo.UsageRecord) {
+			i := slices.IndexFunc(records, func(r *genaidemo.UsageRecord) bool { return r.KeyId == userID })
+			if !tt.wantUsage {
+				return
+			}
+			if i < 0 {
+				t.Fatalf("records = %v, want %s", records, userID)
+			}
+			if r := records[i]; r.Requests < 1 || r.InputTokens <= 0 || r.OutputTokens <= 0 || r.TotalTokens != r.InputTokens+r.OutputTokens {
+				t.Errorf("usage = %v, want the chat's tokens", r)
+			}
+		}
+		t.Run("grpc/"+tt.name, func(t *testing.T) {
+			resp, err := genaidemo.NewChatServiceClient(h.conn).GetUsage(grpcContext(tt.key), &genaidemo.GetUsageRequest{KeyId: tt.keyID})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v (%v), want %v", got, err, tt.wantCode)
+			}
+			if err == nil {
+				check(t, resp.Records)
+			}
+		})
+		t.Run("http/"+tt.name, func(t *testing.T) {
+			var resp HTTPUsageResponse
+			if got := h.doJSON(t, tt.key, http.MethodGet, "/api/usage?key_id="+tt.keyID, nil, &resp); got != tt.wantHTTP {
+				t.Fatalf("status = %d, want %d", got, tt.wantHTTP)
+			}
+			records := make([]*genaidemo.UsageRecord, len(resp.Usage))
+			for i, r := range resp.Usage {
+				records[i] = &genaidemo.UsageRecord{KeyId: r.KeyID, Requests: r.Requests, InputTokens: r.InputTokens, OutputTokens: r.OutputTokens, TotalTokens: r.TotalTokens}
+			}
+			check(t, records)
+		})
+	}
+}
+
+func TestE2EFewShotExamples(t *testing.T) {
+	h := newE2EHarness(t)
+
+	tests := []struct {
+		name     string
+		key      string
+		wantCode codes.Code
+		wantHTTP int
+	}{
+		{"user", userKey, codes.PermissionDenied, http.StatusForbidden},
+		{"admin", adminKey, codes.OK, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run("grpc/"+tt.name, func(t *testing.T) {
+			_, err := genaidemo.NewChatServiceClient(h.conn).CreateFewShotExample(grpcContext(tt.key), &genaidemo.FewShotExample{
+				Mode:   genaidemo.Mode_MODE_CHAT,
+				Input:  "Hi (grpc)",
+				Output: "Hello! How can I help?",
+			})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("code = %v (%v), want %v", got, err, tt.wantCode)
+			}
+		})
+		t.Run("http/"+tt.name, func(t *testing.T) {
+			req := &HTTPFewShotExample{Mode: "MODE_CHAT", Input: "Hi (http)", Output: "Hello! How can I help?"}
+			if got := h.doJSON(t, tt.key, http.MethodPost, "/api/examples", req, nil); got != tt.wantHTTP {
+				t.Errorf("status = %d, want %d", got, tt.wantHTTP)
+			}
+		})
+	}
+
+	// Reads stay open to every key
+	resp, err := genaidemo.NewChatServiceClient(h.conn).ListFewShotExamples(grpcContext(userKey), &genaidemo.ListFewShotExamplesRequest{})
+	if err != nil {
+		t.Fatalf("ListFewShotExamples: %v", err)
+	}
+	if len(resp.Examples) != 2 {
+		t.Errorf("listed %d examples, want the admin's 2", len(resp.Examples))
+	}
+}
+
+func TestE2EHealth(t *testing.T) {
+	h := newE2EHarness(t)
+
+	// Probes carry no API key
+	resp, err := healthpb.NewHealthClient(h.conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("status = %v, want SERVING", resp.Status)
+	}
+	if got := h.doJSON(t, "", http.MethodGet, "/api/health", nil, nil); got != http.StatusOK {
+		t.Errorf("GET /api/health: status = %d, want 200", got)
+	}
+
+	// Deep checks call the dependencies, so they take an admin key
+	for key, want := range map[string]int{"": http.StatusUnauthorized, userKey: http.StatusForbidden, adminKey: http.StatusOK} {
+		var report healthReport
+		if got := h.doJSON(t, key, http.MethodGet, "/api/health?deep=true", nil, &report); got != want {
+			t.Errorf("GET /api/health?deep=true with %q: status = %d, want %d", key, got, want)
+		}
+		if want == http.StatusOK && len(report.Dependencies) == 0 {
+			t.Error("deep health check reported no dependencies")
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain is the ErrorInfo domain of the errors raised by this service
+const errorDomain = "genai-foundation-demo"
+
+// Error reasons reported in the ErrorInfo detail of gRPC errors and in the
+// reason field of HTTP error responses. Errors without a specific reason use
+// the upper-case name of their status code, e.g. INVALID_ARGUMENT.
+const (
+	reasonRateLimited           = "RATE_LIMITED"
+	reasonBudgetExceeded        = "BUDGET_EXCEEDED"
+	reasonOverloaded            = "OVERLOADED"
+	reasonGuardrailViolation    = "GUARDRAIL_VIOLATION"
+	reasonProviderError         = "PROVIDER_ERROR"
+	reasonProviderRateLimited   = "PROVIDER_RATE_LIMITED"
+	reasonDependencyUnavailable = "DEPENDENCY_UNAVAILABLE"
+	reasonTimeout               = "TIMEOUT"
+	reasonTenantDenied          = "TENANT_DENIED"
+	reasonProviderCredentials   = "PROVIDER_CREDENTIALS_REJECTED"
+	reasonDraining              = "DRAINING"
+	reasonUploadOffsetMismatch  = "UPLOAD_OFFSET_MISMATCH"
+	reasonDelegationDenied      = "DELEGATION_DENIED"
+	reasonModelUnsupported      = "MODEL_UNSUPPORTED"
+)
+
+// errorInfo returns the ErrorInfo detail for reason
+func errorInfo(reason string, metadata map[string]string) *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{Reason: reason, Domain: errorDomain, Metadata: metadata}
+}
+
+// retryInfo returns the RetryInfo detail asking clients to wait delay
+func retryInfo(delay time.Duration) *errdetails.RetryInfo {
+	return &errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}
+}
+
+// statusWithDetails returns s with details attached, or s unchanged when
+// they can't be marshalled
+func statusWithDetails(s *status.Status, details ...protoadapt.MessageV1) *status.Status {
+	if detailed, err := s.WithDetails(details...); err == nil {
+		return detailed
+	}
+	return s
+}
+
+// errorWithReason returns a status error carrying an ErrorInfo with reason
+func errorWithReason(code codes.Code, reason string, metadata map[string]string, message string) error {
+	return statusWithDetails(status.New(code, message), errorInfo(reason, metadata)).Err()
+}
+
+// providerError is a failed Vertex AI call. It keeps the provider's error
+// for the ErrorInfo metadata and maps it to a status code callers can act
+// on instead of a blanket Internal.
+type providerError struct {
+	op  string
+	err error
+	// callerCredentials is set when the call used the caller's own provider
+	// credentials, whose rejection is the caller's to fix
+	callerCredentials bool
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("Vertex AI %s failed: %v", e.op, e.err)
+}
+
+func (e *providerError) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus reports calls abandoned by the caller as Canceled, rate limiting
+// as ResourceExhausted with the provider's retry hint, timeouts as
+// DeadlineExceeded, rejected input as InvalidArgument, rejected caller
+// credentials as PermissionDenied and everything else as Unavailable
+func (e *providerError) GRPCStatus() *status.Status {
+	code, reason := codes.Unavailable, reasonProviderError
+	var apiErr *googleapi.Error
+	switch {
+	case errors.Is(e.err, context.Canceled):
+		code, reason = codes.Canceled, codeName(codes.Canceled)
+	case isRateLimited(e.err):
+		code, reason = codes.ResourceExhausted, reasonProviderRateLimited
+	case errors.Is(e.err, context.DeadlineExceeded):
+		code, reason = codes.DeadlineExceeded, reasonTimeout
+	case status.Code(e.err) == codes.InvalidArgument,
+		errors.As(e.err, &apiErr) && apiErr.Code == http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case e.callerCredentials && (status.Code(e.err) == codes.PermissionDenied ||
+		status.Code(e.err) == codes.Unauthenticated ||
+		errors.As(e.err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden)):
+		code, reason = codes.PermissionDenied, reasonProviderCredentials
+	}
+
+	details := []protoadapt.MessageV1{errorInfo(reason, map[string]string{
+		"provider":       "vertex_ai",
+		"provider_error": e.err.Error(),
+	})}
+	if hint := retryAfterHint(e.err); hint > 0 {
+		details = append(details, retryInfo(hint))
+	}
+	return statusWithDetails(status.New(code, e.Error()), details...)
+}
+
+// codeName returns the upper-case name of a status code, e.g.
+// RESOURCE_EXHAUSTED for codes.ResourceExhausted
+func codeName(code codes.Code) string {
+	if code == codes.OK {
+		return "OK"
+	}
+	var name []rune
+	for i, r := range code.String() {
+		if unicode.IsUpper(r) && i > 0 {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+	return string(name)
+}
+
+// errorCode returns the status code of err like status.Code, except that
+// context errors map to Canceled and DeadlineExceeded instead of Unknown
+func errorCode(err error) codes.Code {
+	if s, ok := status.FromError(err); ok {
+		return s.Code()
+	}
+	return status.FromContextError(err).Code()
+}
+
+// errorStatus returns the status of err with the details of the error
+// taxonomy filled in: an ErrorInfo classifying the error (derived from the
+// status code when the error has none) and a RequestInfo with the request id
+func errorStatus(ctx context.Context, err error) *status.Status {
+	s, ok := status.FromError(err)
+	if !ok {
+		s = status.FromContextError(err)
+	}
+
+	var details []protoadapt.MessageV1
+	hasInfo := false
+	for _, d := range s.Details() {
+		if _, ok := d.(*errdetails.ErrorInfo); ok {
+			hasInfo = true
+		}
+	}
+	if !hasInfo {
+		reason := codeName(s.Code())
+		if s.Code() == codes.DeadlineExceeded {
+			reason = reasonTimeout
+		}
+		details = append(details, errorInfo(reason, nil))
+	}
+	if id := requestIDFromContext(ctx); id != "" {
+		details = append(details, &errdetails.RequestInfo{RequestId: id})
+	}
+	return statusWithDetails(s, details...)
+}
+
+// HTTPError is the body of every failed HTTP API request
+type HTTPError struct {
+	// Error is the error message, kept as a plain string for older clients
+	Error string `json:"error"`
+	// Code is the gRPC status code name, e.g. RESOURCE_EXHAUSTED
+	Code string `json:"code"`
+	// Reason classifies the error, e.g. RATE_LIMITED or PROVIDER_ERROR
+	Reason    string `json:"reason,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// RetryAfterSeconds is also sent as the Retry-After header
+	RetryAfterSeconds int `json:"retry_after_seconds,omitempty"`
+	// ProviderError is the error returned by Vertex AI, if it caused the failure
+	ProviderError string            `json:"provider_error,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	// Violations lists the broken rules when output guardrails rejected the response
+	Violations []guardrailViolation `json:"violations,omitempty"`
+}
+
+// sendError writes err as an HTTPError with the status code mapped from its
+// gRPC code, setting Retry-After when the error carries a retry delay
+func sendError(w http.ResponseWriter, r *http.Request, err error) {
+	s := errorStatus(r.Context(), err)
+	body := HTTPError{
+		Error:      s.Message(),
+		Code:       codeName(s.Code()),
+		Violations: guardrailViolations(err),
+	}
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			body.Reason = d.Reason
+			for k, v := range d.Metadata {
+				if k == "provider_error" {
+					body.ProviderError = v
+					continue
+				}
+				if body.Metadata == nil {
+					body.Metadata = make(map[string]string)
+				}
+				body.Metadata[k] = v
+			}
+		case *errdetails.RetryInfo:
+			body.RetryAfterSeconds = int(math.Ceil(d.RetryDelay.AsDuration().Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(body.RetryAfterSeconds))
+		case *errdetails.RequestInfo:
+			body.RequestID = d.RequestId
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromError(s.Err()))
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/api/pubsub/v1"
+)
+
+// eventMetrics counts emitted, published, dropped and failed events,
+// exported under /api/metrics
+var eventMetrics = expvar.NewMap("events")
+
+// Event bus kinds selected with EVENT_BUS
+const (
+	eventBusPubSub = "pubsub"
+	eventBusKafka  = "kafka"
+	eventBusLog    = "log"
+)
+
+// Event types
+const (
+	eventRequestStarted      = "request.started"
+	eventToolInvoked         = "tool.invoked"
+	eventResponseCompleted   = "response.completed"
+	eventModerationTriggered = "moderation.triggered"
+)
+
+// eventPublishTimeout bounds the publication of one batch
+const eventPublishTimeout = 10 * time.Second
+
+// event is a structured record of something that happened while serving a
+// request, published to the event bus as JSON
+type event struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Time      time.Time      `json:"time"`
+	RequestID string         `json:"request_id,omitempty"`
+	JobID     string         `json:"job_id,omitempty"`
+	KeyID     string         `json:"key_id,omitempty"`
+	Tenant    string         `json:"tenant,omitempty"`
+	Mode      string         `json:"mode,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// newEvent creates an event of the request of ctx
+func newEvent(ctx context.Context, eventType string, mode genaidemo.Mode, data map[string]any) *event {
+	id, _ := newJobID()
+	e := &event{
+		ID:        id,
+		Type:      eventType,
+		Time:      time.Now().UTC(),
+		RequestID: requestIDFromContext(ctx),
+		JobID:     jobIDFromContext(ctx),
+		KeyID:     apiKeyIDFromContext(ctx),
+		Tenant:    tenantID(ctx),
+		Data:      data,
+	}
+	if mode != genaidemo.Mode_MODE_UNKNOWN {
+		e.Mode = mode.String()
+	}
+	return e
+}
+
+// eventPublisher sends a batch of events to an event bus
+type eventPublisher interface {
+	publish(ctx context.Context, events []*event) error
+}
+
+// eventBus publishes events in the background, in batches. Emitting never
+// blocks a request: events are dropped when the buffer is full. A nil bus
+// discards every event.
+type eventBus struct {
+	publisher     eventPublisher
+	batchSize     int
+	flushInterval time.Duration
+
+	events chan *event
+	wg     sync.WaitGroup
+}
+
+// newEventBus creates the event bus of the configuration, nil when EVENT_BUS
+// is unset
+func newEventBus(cfg *serviceConfig) (*eventBus, error) {
+	var publisher eventPublisher
+	switch cfg.eventBus {
+	case "":
+		return nil, nil
+	case eventBusPubSub:
+		p, err := newPubSubPublisher(cfg.projectID, cfg.eventTopic)
+		if err != nil {
+			return nil, err
+		}
+		publisher = p
+	case eventBusKafka:
+		p, err := newKafkaRESTPublisher(cfg.kafkaRESTURL, cfg.eventTopic)
+		if err != nil {
+			return nil, err
+		}
+		publisher = p
+	case eventBusLog:
+		publisher = &logPublisher{w: os.Stdout}
+	default:
+		return nil, fmt.Errorf("unknown event bus %q", cfg.eventBus)
+	}
+
+	b := &eventBus{
+		publisher:     publisher,
+		batchSize:     DefaultEventBatchSize,
+		flushInterval: DefaultEventFlushInterval,
+		events:        make(chan *event, cfg.eventBufferSize),
+	}
+	b.wg.Add(1)
+	go b.run()
+	log.Printf("📣 Publishing events to %s %s", cfg.eventBus, cfg.eventTopic)
+	return b, nil
+}
+
+// emit queues an event for publication
+func (b *eventBus) emit(e *event) {
+	if b == nil {
+		return
+	}
+	select {
+	case b.events <- e:
+		eventMetrics.Add("emitted", 1)
+	default:
+		eventMetrics.Add("dropped", 1)
+	}
+}
+
+// run publishes queued events in batches of up to batchSize, at least every
+// flushInterval, until the bus is closed
+func (b *eventBus) run() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*event, 0, b.batchSize)
+	for {
+		select {
+		case e, ok := <-b.events:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) < b.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		b.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (b *eventBus) flush(batch []*event) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+	defer cancel()
+	if err := b.publisher.publish(ctx, batch); err != nil {
+		eventMetrics.Add("failed", int64(len(batch)))
+		log.Printf("⚠️ [events] Failed to publish %d events: %v", len(batch), err)
+		return
+	}
+	eventMetrics.Add("published", int64(len(batch)))
+}
+
+// close publishes the queued events and stops the bus
+func (b *eventBus) close() {
+	if b == nil {
+		return
+	}
+	close(b.events)
+	b.wg.Wait()
+}
+
+// pubsubPublisher publishes events to a Google Cloud Pub/Sub topic
+type pubsubPublisher struct {
+	topics *pubsub.ProjectsTopicsService
+	topic  string
+}
+
+// newPubSubPublisher creates a publisher for topic, either a full
+// projects/<project>/topics/<name> path or a topic name in project
+func newPubSubPublisher(project, topic string) (*pubsubPublisher, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("EVENT_TOPIC must be set for the pubsub event bus")
+	}
+	if !strings.HasPrefix(topic, "projects/") {
+		topic = "projects/" + project + "/topics/" + topic
+	}
+	service, err := pubsub.NewService(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	return &pubsubPublisher{topics: service.Projects.Topics, topic: topic}, nil
+}
+
+func (p *pubsubPublisher) publish(ctx context.Context, events []*event) error {
+	req := &pubsub.PublishRequest{Messages: make([]*pubsub.PubsubMessage, len(events))}
+	for i, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		// Attributes let subscriptions filter without decoding the data
+		req.Messages[i] = &pubsub.PubsubMessage{
+			Data:       base64.StdEncoding.EncodeToString(data),
+			Attributes: map[string]string{"type": e.Type, "mode": e.Mode, "tenant": e.Tenant},
+		}
+	}
+	_, err := p.topics.Publish(p.topic, req).Context(ctx).Do()
+	return err
+}
+
+// kafkaRESTPublisher produces events to a Kafka topic through a Kafka REST
+// Proxy (v2 API), keyed by request id so a request's events stay in order
+type kafkaRESTPublisher struct {
+	client *http.Client
+	url    string
+}
+
+func newKafkaRESTPublisher(proxyURL, topic string) (*kafkaRESTPublisher, error) {
+	if proxyURL == "" || topic == "" {
+		return nil, fmt.Errorf("KAFKA_REST_URL and EVENT_TOPIC must be set for the kafka event bus")
+	}
+	return &kafkaRESTPublisher{
+		client: &http.Client{},
+		url:    strings.TrimSuffix(proxyURL, "/") + "/topics/" + topic,
+	}, nil
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key,omitempty"`
+	Value *event `json:"value"`
+}
+
+func (p *kafkaRESTPublisher) publish(ctx context.Context, events []*event) error {
+	records := make([]kafkaRecord, len(events))
+	for i, e := range events {
+		records[i] = kafkaRecord{Key: e.RequestID, Value: e}
+	}
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kafka REST proxy returned %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// logPublisher writes events as JSON lines, for development
+type logPublisher struct {
+	w io.Writer
+}
+
+func (p *logPublisher) publish(ctx context.Context, events []*event) error {
+	enc := json.NewEncoder(p.w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitChatEvents publishes the events of a served chat request: the tools it
+// invoked, provider safety blocks and its completion. Cached replies invoked
+// no tools.
+func (h *Handler) emitChatEvents(ctx context.Context, mode genaidemo.Mode, reply *chatReply, err error, elapsed time.Duration) {
+	if h.events == nil {
+		return
+	}
+
+	data := map[string]any{
+		"status":     errorCode(err).String(),
+		"latency_ms": elapsed.Milliseconds(),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+		h.events.emit(newEvent(ctx, eventResponseCompleted, mode, data))
+		return
+	}
+
+	response, result := reply.response, reply.result
+	if !reply.cached {
+		for _, invocation := range result.ToolInvocations {
+			h.events.emit(newEvent(ctx, eventToolInvoked, mode, map[string]any{
+				"tool":        invocation.Name,
+				"duration_ms": invocation.DurationMs,
+				"error":       invocation.Error,
+			}))
+		}
+	}
+	for _, rating := range result.SafetyRatings {
+		if rating.Blocked {
+			h.events.emit(newEvent(ctx, eventModerationTriggered, mode, map[string]any{
+				"source":      "provider_safety",
+				"category":    rating.Category,
+				"probability": rating.Probability,
+			}))
+		}
+	}
+
+	data["model"] = reply.model
+	data["cached"] = reply.cached
+	data["model_version"] = result.ModelVersion
+	data["finish_reason"] = result.FinishReason.String()
+	data["variant"] = response.Variant
+	data["rollout"] = response.Rollout
+	data["degraded_reason"] = response.GetDegradedReason()
+	if usage := response.TokenUsage; usage != nil {
+		data["input_tokens"] = usage.InputTokenNum
+		data["output_tokens"] = usage.OutputTokenNum
+	}
+	if cost := response.EstimatedCost; cost != nil {
+		data["cost"] = cost.Amount
+	}
+	h.events.emit(newEvent(ctx, eventResponseCompleted, mode, data))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/status"
+)
+
+// experimentMetrics counts requests, failures, latency, tokens, cost and user
+// feedback per endpoint and experiment variant, exported under /api/metrics
+var experimentMetrics = expvar.NewMap("experiments")
+
+// controlVariant names the traffic not assigned to any configured variant
+const controlVariant = "control"
+
+// experimentVariant is one arm of a prompt experiment. Unset fields leave
+// the request as it is, so a variant can change the template version, the
+// model or both.
+type experimentVariant struct {
+	Name string `json:"name"`
+	// Weight is the percentage of the endpoint's traffic served by the variant
+	Weight int `json:"weight"`
+	// Template is rendered into requests that don't reference a template
+	Template string `json:"template,omitempty"`
+	// TemplateVersion pins the version of the template, unless the request
+	// pinned one itself
+	TemplateVersion int32  `json:"template_version,omitempty"`
+	Model           string `json:"model,omitempty"`
+}
+
+// experimentRouter splits the traffic of each endpoint between the variants
+// of its experiment. Endpoints without an experiment are served unchanged and
+// untagged.
+type experimentRouter struct {
+	variants map[genaidemo.Mode][]*experimentVariant
+}
+
+// newExperimentRouter loads experiments from a JSON file mapping endpoint
+// modes to variants, e.g.
+//
+//	{"MODE_DOC": [{"name": "concise", "weight": 20, "template": "rag", "template_version": 2}]}
+//
+// Weights of an endpoint may not exceed 100; the remaining traffic is the
+// control group. An empty path disables experiments.
+func newExperimentRouter(path string) (*experimentRouter, error) {
+	r := &experimentRouter{variants: make(map[genaidemo.Mode][]*experimentVariant)}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiments file: %w", err)
+	}
+	var experiments map[string][]*experimentVariant
+	if err := json.Unmarshal(data, &experiments); err != nil {
+		return nil, fmt.Errorf("failed to parse experiments file: %w", err)
+	}
+
+	for name, variants := range experiments {
+		mode := genaidemo.Mode(genaidemo.Mode_value[name])
+		if mode == genaidemo.Mode_MODE_UNKNOWN {
+			return nil, fmt.Errorf("experiments file: unknown mode %q", name)
+		}
+		if err := validateVariants(variants); err != nil {
+			return nil, fmt.Errorf("experiments file: %s: %w", name, err)
+		}
+		r.variants[mode] = variants
+		log.Printf("🧪 Experiment on %s with %d variants", mode, len(variants))
+	}
+	return r, nil
+}
+
+// validateVariants checks variant names and that the weights fit in 100%
+func validateVariants(variants []*experimentVariant) error {
+	seen := make(map[string]bool)
+	total := 0
+	for _, v := range variants {
+		switch {
+		case v.Name == "":
+			return fmt.Errorf("variant name cannot be empty")
+		case v.Name == controlVariant:
+			return fmt.Errorf("variant name %q is reserved", controlVariant)
+		case seen[v.Name]:
+			return fmt.Errorf("duplicate variant %q", v.Name)
+		case v.Weight < 0:
+			return fmt.Errorf("variant %q has a negative weight", v.Name)
+		case v.TemplateVersion < 0:
+			return fmt.Errorf("variant %q has a negative template version", v.Name)
+		}
+		seen[v.Name] = true
+		total += v.Weight
+	}
+	if total > 100 {
+		return fmt.Errorf("variant weights add up to %d%%, more than 100%%", total)
+	}
+	return nil
+}
+
+// assign picks a variant for a request to the given endpoint and applies it
+// to the request, returning the variant name and the context carrying its
+// model. The name is empty when the endpoint runs no experiment.
+func (r *experimentRouter) assign(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (context.Context, string) {
+	variants := r.variants[mode]
+	if len(variants) == 0 {
+		return ctx, ""
+	}
+
+	n := rand.IntN(100)
+	for _, v := range variants {
+		if n >= v.Weight {
+			n -= v.Weight
+			continue
+		}
+
+		if v.Template != "" && req.GetTemplate() == "" {
+			req.Template = &v.Template
+		}
+		if v.TemplateVersion > 0 && req.TemplateVersion == nil && (v.Template == "" || v.Template == req.GetTemplate()) {
+			req.TemplateVersion = &v.TemplateVersion
+		}
+		if v.Model != "" {
+			ctx = withModelOverride(ctx, v.Model)
+		}
+		return ctx, v.Name
+	}
+	return ctx, controlVariant
+}
+
+// record adds a served request to the metrics of its variant
+func (r *experimentRouter) record(ctx context.Context, mode genaidemo.Mode, variant, defaultModel string, elapsed time.Duration, response *genaidemo.ChatResponse, err error) {
+	recordVariant(ctx, experimentMetrics, mode, variant, defaultModel, elapsed, response, err)
+}
+
+// recordFeedback adds feedback on a reply to the metrics of the variant that
+// served it, or withdraws replaced feedback with a negative sign
+func (r *experimentRouter) recordFeedback(f *feedbackRecord, sign int64) {
+	if f.Variant != "" {
+		recordVariantFeedback(experimentMetrics, f.Variant, f, sign)
+	}
+}
+
+// recordVariant adds a served request to the requests, errors, latency,
+// tokens and cost of a traffic variant of the endpoint in metrics
+func recordVariant(ctx context.Context, metrics *expvar.Map, mode genaidemo.Mode, variant, defaultModel string, elapsed time.Duration, response *genaidemo.ChatResponse, err error) {
+	prefix := variantMetricPrefix(mode, variant)
+	metrics.Add(prefix+"requests", 1)
+	if err != nil {
+		metrics.Add(prefix+"errors."+status.Code(err).String(), 1)
+		return
+	}
+	metrics.Add(prefix+"latency_ms", elapsed.Milliseconds())
+
+	usage := response.GetTokenUsage()
+	if usage == nil {
+		return
+	}
+	metrics.Add(prefix+"input_tokens", int64(usage.InputTokenNum))
+	metrics.Add(prefix+"output_tokens", int64(usage.OutputTokenNum))
+	if cost, _, ok := llm.EstimateCostWithCache(modelFromContext(ctx, defaultModel), int64(usage.InputTokenNum), int64(usage.CachedTokenNum), int64(usage.OutputTokenNum)); ok {
+		metrics.AddFloat(prefix+"cost", cost)
+	}
+}
+
+// recordVariantFeedback adds the feedback, thumbs and rating given on a reply
+// to the metrics of the traffic variant of the endpoint that served it
+func recordVariantFeedback(metrics *expvar.Map, variant string, f *feedbackRecord, sign int64) {
+	prefix := variantMetricPrefix(genaidemo.Mode(genaidemo.Mode_value[f.Mode]), variant)
+	metrics.Add(prefix+"feedback", sign)
+	switch f.Thumb {
+	case genaidemo.Thumb_THUMB_UP.String():
+		metrics.Add(prefix+"thumbs_up", sign)
+	case genaidemo.Thumb_THUMB_DOWN.String():
+		metrics.Add(prefix+"thumbs_down", sign)
+	}
+	if f.Rating > 0 {
+		metrics.Add(prefix+"ratings", sign)
+		metrics.Add(prefix+"rating_sum", sign*int64(f.Rating))
+	}
+}
+
+// variantMetricPrefix returns the prefix of the metrics of a traffic variant
+// of the endpoint, e.g. "doc.concise."
+func variantMetricPrefix(mode genaidemo.Mode, variant string) string {
+	return strings.ToLower(strings.TrimPrefix(mode.String(), "MODE_")) + "." + variant + "."
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// feedbackMetrics counts the replies open to feedback, the feedback
+// submitted, replaced and dropped, and its thumbs and ratings, exported under
+// /api/metrics
+var feedbackMetrics = expvar.NewMap("feedback")
+
+// servedReply is a reply users may give feedback on: the last user message
+// and the reply, truncated, with what served them
+type servedReply struct {
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+	Tenant    string    `json:"tenant,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Mode      string    `json:"mode"`
+	Model     string    `json:"model"`
+	Variant   string    `json:"variant,omitempty"`
+	Rollout   string    `json:"rollout,omitempty"`
+	// Template is the prompt template version, e.g. "rag v2"
+	Template string `json:"template,omitempty"`
+	Query    string `json:"query"`
+	Reply    string `json:"reply"`
+}
+
+// feedbackRecord is the feedback on a reply, stored with its transcript
+type feedbackRecord struct {
+	servedReply
+	SubmittedAt time.Time `json:"submitted_at"`
+	// Thumb is THUMB_UP, THUMB_DOWN or empty
+	Thumb   string `json:"thumb,omitempty"`
+	Rating  int32  `json:"rating,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// feedbackStore keeps the replies served within the feedback window, in
+// memory only, and the feedback given on them, optionally persisted to a
+// JSON lines file so it survives restarts. Each submission is appended to the
+// file, which is compacted to the current feedback on close. A reply has at
+// most one feedback; submitting again replaces it. Records are immutable once
+// stored.
+type feedbackStore struct {
+	mu     sync.Mutex
+	served map[string]*servedReply
+	// order is the served replies, oldest first, for expiry
+	order   []*servedReply
+	records map[string]*feedbackRecord
+	path    string
+	// log is the store file opened for appending, nil without a path
+	log    *os.File
+	window time.Duration
+}
+
+// newFeedbackStore creates a feedback store, loading existing feedback from
+// path when it is set. Later lines of the file replace earlier feedback on the
+// same reply. A zero window disables feedback.
+func newFeedbackStore(path string, window time.Duration) (*feedbackStore, error) {
+	s := &feedbackStore{
+		served:  make(map[string]*servedReply),
+		records: make(map[string]*feedbackRecord),
+		path:    path,
+		window:  window,
+	}
+	if !s.enabled() || path == "" {
+		return s, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open feedback: %w", err)
+	}
+	dec := json.NewDecoder(f)
+	for {
+		var r feedbackRecord
+		end := dec.InputOffset()
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// A crash cut the last submission short; it was never
+			// acknowledged, and later ones are appended after the last
+			// complete record
+			log.Printf("⚠️ Dropping the truncated last line of %s", path)
+			if err := f.Truncate(end); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("failed to repair feedback: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to parse feedback: %w", err)
+		}
+		s.records[feedbackKey(r.Tenant, r.KeyID, r.RequestID)] = &r
+	}
+	// Feedback dropped for the limit stays in the file until it is compacted
+	for len(s.records) > MaxFeedbackRecords {
+		oldest := s.oldest()
+		delete(s.records, feedbackKey(oldest.Tenant, oldest.KeyID, oldest.RequestID))
+	}
+	s.log = f
+	log.Printf("👍 Loaded %d feedback records from %s", len(s.records), path)
+	return s, nil
+}
+
+// enabled reports whether replies are open to feedback
+func (s *feedbackStore) enabled() bool {
+	return s.window > 0
+}
+
+// record keeps a served reply for feedback under the request id it was
+// served with
+func (s *feedbackStore) record(ctx context.Context, mode genaidemo.Mode, messages []*genaidemo.Message, response *genaidemo.ChatResponse) {
+	requestID := requestIDFromContext(ctx)
+	if !s.enabled() || requestID == "" {
+		return
+	}
+	var query string
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == genaidemo.Role_ROLE_USER {
+			query = messages[i].Content
+			break
+		}
+	}
+
+	r := &servedReply{
+		RequestID: requestID,
+		Time:      time.Now(),
+		Tenant:    tenantID(ctx),
+		KeyID:     apiKeyIDFromContext(ctx),
+		Mode:      mode.String(),
+		Model:     response.Model,
+		Variant:   response.Variant,
+		Rollout:   response.Rollout,
+		Query:     truncateUTF8(query, MaxAnalyticsTextBytes),
+		Reply:     truncateUTF8(response.Content, MaxAnalyticsTextBytes),
+	}
+	if t := response.PromptTemplate; t != nil {
+		r.Template = fmt.Sprintf("%s v%d", t.Name, t.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.served[feedbackKey(r.Tenant, r.KeyID, r.RequestID)] = r
+	s.order = append(s.order, r)
+	s.expire(r.Time)
+	feedbackMetrics.Add("replies", 1)
+}
+
+// submit stores the feedback on a reply the caller was served within the
+// window, returning it and the feedback it replaced, if any
+func (s *feedbackStore) submit(ctx context.Context, req *genaidemo.SubmitFeedbackRequest) (*feedbackRecord, *feedbackRecord, error) {
+	key := feedbackKey(tenantID(ctx), apiKeyIDFromContext(ctx), req.RequestId)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(now)
+	served := s.served[key]
+	if served == nil {
+		return nil, nil, status.Errorf(codes.NotFound, "no reply to give feedback on for request %s", req.RequestId)
+	}
+	r := &feedbackRecord{
+		servedReply: *served,
+		SubmittedAt: now,
+		Rating:      req.GetRating(),
+		Comment:     strings.TrimSpace(req.GetComment()),
+	}
+	if req.Thumb != genaidemo.Thumb_THUMB_UNSPECIFIED {
+		r.Thumb = req.Thumb.String()
+	}
+
+	previous := s.records[key]
+	var dropped *feedbackRecord
+	s.records[key] = r
+	if previous == nil && len(s.records) > MaxFeedbackRecords {
+		dropped = s.oldest()
+		delete(s.records, feedbackKey(dropped.Tenant, dropped.KeyID, dropped.RequestID))
+	}
+	if err := s.append(r); err != nil {
+		if previous != nil {
+			s.records[key] = previous
+		} else {
+			delete(s.records, key)
+		}
+		if dropped != nil {
+			s.records[feedbackKey(dropped.Tenant, dropped.KeyID, dropped.RequestID)] = dropped
+		}
+		return nil, nil, err
+	}
+
+	if previous != nil {
+		feedbackMetrics.Add("replaced", 1)
+		previous.count(-1)
+	}
+	if dropped != nil {
+		feedbackMetrics.Add("dropped", 1)
+	}
+	r.count(1)
+	return r, previous, nil
+}
+
+// summarize sums up the feedback of a tenant submitted within [from, to),
+// per mode, variant, rollout arm and model; zero times leave the range open
+func (s *feedbackStore) summarize(tenant string, from, to time.Time) *genaidemo.FeedbackSummary {
+	s.mu.Lock()
+	records := slices.Collect(maps.Values(s.records))
+	s.mu.Unlock()
+
+	out := &genaidemo.FeedbackSummary{Total: &genaidemo.FeedbackStats{}}
+	groups := make(map[[4]string]*genaidemo.FeedbackGroup)
+	for _, r := range records {
+		if r.Tenant != tenant || (!from.IsZero() && r.SubmittedAt.Before(from)) || (!to.IsZero() && !r.SubmittedAt.Before(to)) {
+			continue
+		}
+		r.addTo(out.Total)
+
+		key := [4]string{r.Mode, r.Variant, r.Rollout, r.Model}
+		g, ok := groups[key]
+		if !ok {
+			g = &genaidemo.FeedbackGroup{
+				Mode:    genaidemo.Mode(genaidemo.Mode_value[r.Mode]),
+				Variant: r.Variant,
+				Rollout: r.Rollout,
+				Model:   r.Model,
+				Stats:   &genaidemo.FeedbackStats{},
+			}
+			groups[key] = g
+		}
+		r.addTo(g.Stats)
+	}
+
+	finishFeedbackStats(out.Total)
+	for _, g := range groups {
+		finishFeedbackStats(g.Stats)
+		out.Groups = append(out.Groups, g)
+	}
+	slices.SortFunc(out.Groups, func(a, b *genaidemo.FeedbackGroup) int {
+		return cmp.Or(
+			cmp.Compare(a.Mode, b.Mode),
+			strings.Compare(a.Variant, b.Variant),
+			strings.Compare(a.Rollout, b.Rollout),
+			strings.Compare(a.Model, b.Model),
+		)
+	})
+	return out
+}
+
+// list returns the feedback of a tenant, oldest first
+func (s *feedbackStore) list(tenant string) []*feedbackRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []*feedbackRecord
+	for _, r := range s.records {
+		if r.Tenant == tenant {
+			records = append(records, r)
+		}
+	}
+	slices.SortFunc(records, func(a, b *feedbackRecord) int {
+		return a.SubmittedAt.Compare(b.SubmittedAt)
+	})
+	return records
+}
+
+// expire drops the served replies past the window, and the oldest ones
+// beyond MaxFeedbackReplies. Callers must hold the lock.
+func (s *feedbackStore) expire(now time.Time) {
+	cutoff := now.Add(-s.window)
+	n := 0
+	for n < len(s.order) && (s.order[n].Time.Before(cutoff) || len(s.order)-n > MaxFeedbackReplies) {
+		r := s.order[n]
+		key := feedbackKey(r.Tenant, r.KeyID, r.RequestID)
+		// The request id may have been reused by a later request
+		if s.served[key] == r {
+			delete(s.served, key)
+		}
+		n++
+	}
+	if n > 0 {
+		s.order = slices.Clone(s.order[n:])
+	}
+}
+
+// oldest returns the feedback submitted first. Callers must hold the lock.
+func (s *feedbackStore) oldest() *feedbackRecord {
+	var oldest *feedbackRecord
+	for _, r := range s.records {
+		if oldest == nil || r.SubmittedAt.Before(oldest.SubmittedAt) {
+			oldest = r
+		}
+	}
+	return oldest
+}
+
+// append writes a submission to the end of the store file. Callers must
+// hold the lock.
+func (s *feedbackStore) append(r *feedbackRecord) error {
+	if s.log == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal feedback: %w", err)
+	}
+	if _, err := s.log.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save feedback: %w", err)
+	}
+	return nil
+}
+
+// close compacts the store file to the current feedback, one record per
+// line, oldest first
+func (s *feedbackStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return
+	}
+	s.log.Close()
+	s.log = nil
+
+	records := slices.SortedFunc(maps.Values(s.records), func(a, b *feedbackRecord) int {
+		return a.SubmittedAt.Compare(b.SubmittedAt)
+	})
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			log.Printf("⚠️ [feedback] Failed to marshal feedback: %v", err)
+			return
+		}
+	}
+	if err := writeFileAtomic(s.path, buf.Bytes()); err != nil {
+		log.Printf("⚠️ [feedback] Failed to compact feedback: %v", err)
+	}
+}
+
+// count adds the feedback to the feedback metrics, or withdraws feedback
+// that was replaced with a negative sign
+func (r *feedbackRecord) count(sign int64) {
+	feedbackMetrics.Add("submitted", sign)
+	switch r.Thumb {
+	case genaidemo.Thumb_THUMB_UP.String():
+		feedbackMetrics.Add("thumbs_up", sign)
+	case genaidemo.Thumb_THUMB_DOWN.String():
+		feedbackMetrics.Add("thumbs_down", sign)
+	}
+	if r.Rating > 0 {
+		feedbackMetrics.Add("ratings", sign)
+		feedbackMetrics.Add("rating_sum", sign*int64(r.Rating))
+	}
+}
+
+// addTo adds the feedback to stats; finishFeedbackStats computes the average
+// rating once all feedback is added
+func (r *feedbackRecord) addTo(stats *genaidemo.FeedbackStats) {
+	stats.Feedback++
+	switch r.Thumb {
+	case genaidemo.Thumb_THUMB_UP.String():
+		stats.ThumbsUp++
+	case genaidemo.Thumb_THUMB_DOWN.String():
+		stats.ThumbsDown++
+	}
+	if r.Rating > 0 {
+		stats.Ratings++
+		stats.AverageRating += float64(r.Rating)
+	}
+	if r.Comment != "" {
+		stats.Comments++
+	}
+}
+
+// finishFeedbackStats turns the rating sum added up in stats into the average
+func finishFeedbackStats(stats *genaidemo.FeedbackStats) {
+	if stats.Ratings > 0 {
+		stats.AverageRating /= float64(stats.Ratings)
+	}
+}
+
+// toProto converts the feedback to its gRPC message
+func (r *feedbackRecord) toProto() *genaidemo.Feedback {
+	return &genaidemo.Feedback{
+		RequestId:   r.RequestID,
+		Thumb:       genaidemo.Thumb(genaidemo.Thumb_value[r.Thumb]),
+		Rating:      r.Rating,
+		Comment:     r.Comment,
+		SubmittedAt: r.SubmittedAt.Unix(),
+		Mode:        genaidemo.Mode(genaidemo.Mode_value[r.Mode]),
+		Model:       r.Model,
+		Variant:     r.Variant,
+		Rollout:     r.Rollout,
+	}
+}
+
+// validateFeedback rejects feedback without a request id or anything to say,
+// ratings out of range and overlong comments
+func validateFeedback(req *genaidemo.SubmitFeedbackRequest) error {
+	switch {
+	case req.RequestId == "":
+		return status.Error(codes.InvalidArgument, "request_id cannot be empty")
+	case req.Thumb == genaidemo.Thumb_THUMB_UNSPECIFIED && req.Rating == nil && strings.TrimSpace(req.GetComment()) == "":
+		return status.Error(codes.InvalidArgument, "feedback needs a thumb, a rating or a comment")
+	case genaidemo.Thumb_name[int32(req.Thumb)] == "":
+		return status.Error(codes.InvalidArgument, "invalid thumb")
+	case req.Rating != nil && (req.GetRating() < 1 || req.GetRating() > MaxFeedbackRating):
+		return status.Errorf(codes.InvalidArgument, "rating must be between 1 and %d", MaxFeedbackRating)
+	case !utf8.ValidString(req.GetComment()):
+		return status.Error(codes.InvalidArgument, "comment must be valid UTF-8")
+	case utf8.RuneCountInString(req.GetComment()) > MaxFeedbackCommentLength:
+		return status.Errorf(codes.InvalidArgument, "comment cannot be longer than %d characters", MaxFeedbackCommentLength)
+	}
+	return nil
+}
+
+// feedbackKey returns the key of a reply, within the caller's tenant and API
+// key so callers never give feedback on each other's replies
+func feedbackKey(tenant, keyID, requestID string) string {
+	return fmt.Sprintf("%s/%s/%s", tenant, keyID, requestID)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/protobuf/proto"
+)
+
+// submitFeedback serves a reply under requestID and rates it
+func submitFeedback(t *testing.T, s *feedbackStore, requestID string, rating int32) {
+	t.Helper()
+	ctx := withRequestID(context.Background(), requestID)
+	s.record(ctx, genaidemo.Mode_MODE_CHAT, []*genaidemo.Message{{Role: genaidemo.Role_ROLE_USER, Content: "Hi"}}, &genaidemo.ChatResponse{Content: "Hello"})
+	if _, _, err := s.submit(ctx, &genaidemo.SubmitFeedbackRequest{RequestId: requestID, Rating: proto.Int32(rating)}); err != nil {
+		t.Fatalf("submit %s: %v", requestID, err)
+	}
+}
+
+func ratings(s *feedbackStore) map[string]int32 {
+	out := make(map[string]int32)
+	for _, r := range s.list("") {
+		out[r.RequestID] = r.Rating
+	}
+	return out
+}
+
+func TestFeedbackStoreLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	s, err := newFeedbackStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitFeedback(t, s, "req-1", 2)
+	submitFeedback(t, s, "req-2", 5)
+	submitFeedback(t, s, "req-1", 4)
+
+	// Without a clean shutdown the log is replayed, later lines winning
+	reopened, err := newFeedbackStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ratings(reopened); len(got) != 2 || got["req-1"] != 4 || got["req-2"] != 5 {
+		t.Errorf("replayed ratings = %v, want req-1: 4, req-2: 5", got)
+	}
+	reopened.close()
+
+	// A crash in the middle of a submission leaves a truncated last line
+	s.close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("compacted file has %d lines, want 2", lines)
+	}
+	if err := os.WriteFile(path, append(data, `{"request_id": "req-3", "rat`...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	repaired, err := newFeedbackStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("open with a truncated line: %v", err)
+	}
+	defer repaired.close()
+	submitFeedback(t, repaired, "req-3", 3)
+	if got := ratings(repaired); len(got) != 3 || got["req-3"] != 3 {
+		t.Errorf("ratings = %v, want req-3: 3 added", got)
+	}
+
+	final, err := newFeedbackStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("open after repair: %v", err)
+	}
+	defer final.close()
+	if got := ratings(final); len(got) != 3 {
+		t.Errorf("ratings = %v, want 3 records", got)
+	}
+}
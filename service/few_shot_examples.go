@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fewShotExample is a stored input with its ideal output for one endpoint,
+// optionally limited to a persona
+type fewShotExample struct {
+	ID        string         `json:"id"`
+	Mode      genaidemo.Mode `json:"mode"`
+	Persona   string         `json:"persona,omitempty"`
+	Input     string         `json:"input"`
+	Output    string         `json:"output"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// exampleStore keeps few-shot examples in creation order, optionally
+// persisting them to a JSON file so they survive restarts. Examples are
+// immutable once stored; updates replace them.
+type exampleStore struct {
+	mu       sync.RWMutex
+	examples []*fewShotExample
+	path     string
+	// tokenBudget bounds the tokens of the examples injected into a request
+	tokenBudget int
+}
+
+// newExampleStore creates an example store, loading existing examples from
+// path when it is set. A zero tokenBudget disables injection.
+func newExampleStore(path string, tokenBudget int) (*exampleStore, error) {
+	s := &exampleStore{
+		path:        path,
+		tokenBudget: tokenBudget,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read few-shot examples: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.examples); err != nil {
+		return nil, fmt.Errorf("failed to parse few-shot examples: %w", err)
+	}
+
+	log.Printf("🎯 Loaded %d few-shot examples from %s", len(s.examples), path)
+	return s, nil
+}
+
+// create stores a new example under a generated id
+func (s *exampleStore) create(pb *genaidemo.FewShotExample) (*genaidemo.FewShotExample, error) {
+	if err := validateFewShotExample(pb); err != nil {
+		return nil, err
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate example id: %v", err)
+	}
+
+	now := time.Now()
+	e := &fewShotExample{
+		ID:        id,
+		Mode:      pb.Mode,
+		Persona:   pb.Persona,
+		Input:     pb.Input,
+		Output:    pb.Output,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.examples = append(s.examples, e)
+	if err := s.save(); err != nil {
+		s.examples = s.examples[:len(s.examples)-1]
+		return nil, err
+	}
+	return e.toProto(), nil
+}
+
+// update replaces the content of an existing example, keeping its position
+func (s *exampleStore) update(pb *genaidemo.FewShotExample) (*genaidemo.FewShotExample, error) {
+	if err := validateFewShotExample(pb); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(pb.Id)
+	if i < 0 {
+		return nil, status.Errorf(codes.NotFound, "few-shot example %s not found", pb.Id)
+	}
+	old := s.examples[i]
+	e := &fewShotExample{
+		ID:        old.ID,
+		Mode:      pb.Mode,
+		Persona:   pb.Persona,
+		Input:     pb.Input,
+		Output:    pb.Output,
+		CreatedAt: old.CreatedAt,
+		UpdatedAt: time.Now(),
+	}
+	s.examples[i] = e
+	if err := s.save(); err != nil {
+		s.examples[i] = old
+		return nil, err
+	}
+	return e.toProto(), nil
+}
+
+// get returns the example with the given id
+func (s *exampleStore) get(id string) (*genaidemo.FewShotExample, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return nil, status.Errorf(codes.NotFound, "few-shot example %s not found", id)
+	}
+	return s.examples[i].toProto(), nil
+}
+
+// list returns the examples of an endpoint and persona in creation order.
+// An unknown mode or empty persona matches every endpoint or persona.
+func (s *exampleStore) list(mode genaidemo.Mode, persona string) []*genaidemo.FewShotExample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	examples := make([]*genaidemo.FewShotExample, 0, len(s.examples))
+	for _, e := range s.examples {
+		if (mode == genaidemo.Mode_MODE_UNKNOWN || e.Mode == mode) && (persona == "" || e.Persona == persona) {
+			examples = append(examples, e.toProto())
+		}
+	}
+	return examples
+}
+
+// delete removes the example with the given id
+func (s *exampleStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return status.Errorf(codes.NotFound, "few-shot example %s not found", id)
+	}
+	old := s.examples
+	s.examples = slices.Delete(slices.Clone(s.examples), i, i+1)
+	if err := s.save(); err != nil {
+		s.examples = old
+		return err
+	}
+	return nil
+}
+
+// messages returns the examples for a request to the given endpoint as
+// user/assistant message pairs. Examples of the persona come first, then the
+// endpoint's general ones, each in creation order; examples that would
+// exceed the token budget are skipped.
+func (s *exampleStore) messages(mode genaidemo.Mode, persona, model string) []*genaidemo.Message {
+	if s.tokenBudget <= 0 {
+		return nil
+	}
+
+	s.mu.RLock()
+	var candidates []*fewShotExample
+	if persona != "" {
+		for _, e := range s.examples {
+			if e.Mode == mode && e.Persona == persona {
+				candidates = append(candidates, e)
+			}
+		}
+	}
+	for _, e := range s.examples {
+		if e.Mode == mode && e.Persona == "" {
+			candidates = append(candidates, e)
+		}
+	}
+	s.mu.RUnlock()
+
+	var messages []*genaidemo.Message
+	remaining := s.tokenBudget
+	for _, e := range candidates {
+		pair := []*genaidemo.Message{
+			{Role: genaidemo.Role_ROLE_USER, Content: e.Input},
+			{Role: genaidemo.Role_ROLE_ASSISTANT, Content: e.Output},
+		}
+		tokens := llm.CountMessageTokens(model, pair)
+		if tokens > remaining {
+			continue
+		}
+		remaining -= tokens
+		messages = append(messages, pair...)
+	}
+	return messages
+}
+
+// index returns the position of the example with the given id, or -1.
+// Callers must hold the lock.
+func (s *exampleStore) index(id string) int {
+	return slices.IndexFunc(s.examples, func(e *fewShotExample) bool {
+		return e.ID == id
+	})
+}
+
+// save writes all examples to the store file. Callers must hold the lock.
+func (s *exampleStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.examples, "", "  ")
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to marshal few-shot examples: %v", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return status.Errorf(codes.Internal, "failed to save few-shot examples: %v", err)
+	}
+	return nil
+}
+
+func (e *fewShotExample) toProto() *genaidemo.FewShotExample {
+	return &genaidemo.FewShotExample{
+		Id:        e.ID,
+		Mode:      e.Mode,
+		Persona:   e.Persona,
+		Input:     e.Input,
+		Output:    e.Output,
+		CreatedAt: e.CreatedAt.Unix(),
+		UpdatedAt: e.UpdatedAt.Unix(),
+	}
+}
+
+func validateFewShotExample(pb *genaidemo.FewShotExample) error {
+	if _, ok := genaidemo.Mode_name[int32(pb.Mode)]; !ok || pb.Mode == genaidemo.Mode_MODE_UNKNOWN {
+		return status.Error(codes.InvalidArgument, "example mode must be set")
+	}
+	if strings.TrimSpace(pb.Input) == "" {
+		return status.Error(codes.InvalidArgument, "example input cannot be empty")
+	}
+	if strings.TrimSpace(pb.Output) == "" {
+		return status.Error(codes.InvalidArgument, "example output cannot be empty")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"expvar"
+	"log"
+	"time"
+)
+
+// warningStaleIndex marks ChatWithDoc replies answered from a collection
+// that wasn't updated within INDEX_STALENESS_THRESHOLD
+const warningStaleIndex = "stale_index"
+
+// indexFreshnessMetrics reports the freshness of each collection last looked
+// up, exported under /api/metrics
+var indexFreshnessMetrics = expvar.NewMap("index_freshness")
+
+// CollectionFreshness reports when a retrieval collection, the default one
+// when name is empty, last changed and how its connectors last synced
+func (s *chatService) CollectionFreshness(ctx context.Context, name string) (*ChromaDBCollectionFreshness, error) {
+	name = cmp.Or(name, s.chromaCollection)
+	cached, err := s.chromaClient.CollectionFreshness(ctx, name)
+	if err != nil {
+		log.Printf("❌ [CollectionFreshness] Freshness of %s failed: %v", name, err)
+		return nil, chromaDBError(ctx, err, "collection freshness is unavailable")
+	}
+
+	// The client's cached result is shared, Stale depends on the threshold
+	freshness := *cached
+	freshness.Stale = s.indexStalenessThreshold > 0 && freshness.LastUpdatedAt > 0 &&
+		time.Since(time.Unix(freshness.LastUpdatedAt, 0)) > s.indexStalenessThreshold
+	publishFreshness(&freshness)
+	return &freshness, nil
+}
+
+// staleIndexWarning returns the warnings of a ChatWithDoc reply retrieved
+// from collection: stale_index when it is older than the staleness
+// threshold. Failing to tell adds no warning.
+func (s *chatService) staleIndexWarning(ctx context.Context, collection string) []string {
+	if s.indexStalenessThreshold <= 0 {
+		return nil
+	}
+	freshness, err := s.CollectionFreshness(ctx, collection)
+	if err != nil || !freshness.Stale {
+		return nil
+	}
+	log.Printf("⚠️ [ChatWithDoc] Collection %s was last updated %v ago", freshness.Collection,
+		time.Since(time.Unix(freshness.LastUpdatedAt, 0)).Round(time.Minute))
+	return []string{warningStaleIndex}
+}
+
+// publishFreshness exports the freshness of a collection, with its age
+// computed when the metrics are read
+func publishFreshness(f *ChromaDBCollectionFreshness) {
+	failed := 0
+	for _, c := range f.Connectors {
+		if c.Status == "failed" {
+			failed++
+		}
+	}
+	indexFreshnessMetrics.Set(f.Collection, expvar.Func(func() any {
+		metrics := map[string]any{
+			"last_updated_at":   f.LastUpdatedAt,
+			"connectors":        len(f.Connectors),
+			"failed_connectors": failed,
+			"stale":             f.Stale,
+		}
+		if f.LastUpdatedAt > 0 {
+			metrics["age_seconds"] = int64(time.Since(time.Unix(f.LastUpdatedAt, 0)).Seconds())
+		}
+		return metrics
+	}))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// metadataNativeFunctionCalling is the CallOptions.Metadata key that makes a
+// generation call use Gemini's function calling protocol directly instead of
+// langchaingo's tool conversion
+const metadataNativeFunctionCalling = "native_function_calling"
+
+// withNativeFunctionCalling makes a generation call with tools use Gemini's
+// native function calling, keeping the metadata set by other options
+func withNativeFunctionCalling() llms.CallOption {
+	return func(o *llms.CallOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]any)
+		}
+		o.Metadata[metadataNativeFunctionCalling] = true
+	}
+}
+
+// functionCallMessages returns the messages continuing a conversation after
+// the model's function calls: the model turn with its text and calls, and one
+// turn answering every call, which is how Gemini expects the responses to
+// parallel function calls
+func functionCallMessages(choice *llms.ContentChoice, invocations []*genaidemo.ToolInvocation) []llms.MessageContent {
+	call := llms.MessageContent{Role: llms.ChatMessageTypeAI}
+	if choice.Content != "" {
+		call.Parts = append(call.Parts, llms.TextContent{Text: choice.Content})
+	}
+	response := llms.MessageContent{Role: llms.ChatMessageTypeTool}
+	for i, toolCall := range choice.ToolCalls {
+		call.Parts = append(call.Parts, toolCall)
+
+		result := map[string]string{"result": invocations[i].Result}
+		if invocations[i].Error != "" {
+			result = map[string]string{"error": invocations[i].Error}
+		}
+		content, _ := json.Marshal(result)
+		response.Parts = append(response.Parts, llms.ToolCallResponse{
+			ToolCallID: toolCall.ID,
+			Name:       toolCall.FunctionCall.Name,
+			Content:    string(content),
+		})
+	}
+	return []llms.MessageContent{call, response}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// guardrailMetrics counts checked responses, violations, retries and
+// rejections per endpoint, exported under /api/metrics
+var guardrailMetrics = expvar.NewMap("guardrails")
+
+// Actions taken when a response violates its endpoint's guardrails
+const (
+	guardrailActionRetry  = "retry"
+	guardrailActionReject = "reject"
+)
+
+// guardrailViolation is one rule a response broke
+type guardrailViolation struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+}
+
+// guardrailError rejects a response that still violates the guardrails of
+// its endpoint after all retries. It maps to codes.FailedPrecondition and
+// carries the violations as a PreconditionFailure detail.
+type guardrailError struct {
+	mode       genaidemo.Mode
+	attempts   int
+	violations []guardrailViolation
+}
+
+func (e *guardrailError) Error() string {
+	descriptions := make([]string, len(e.violations))
+	for i, v := range e.violations {
+		descriptions[i] = v.Description
+	}
+	return fmt.Sprintf("response rejected by output guardrails after %d attempts: %s", e.attempts, strings.Join(descriptions, "; "))
+}
+
+// GRPCStatus lets status.Code and gRPC clients see the error as
+// FailedPrecondition with the violated rules attached
+func (e *guardrailError) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, e.Error())
+	failure := &errdetails.PreconditionFailure{}
+	for _, v := range e.violations {
+		failure.Violations = append(failure.Violations, &errdetails.PreconditionFailure_Violation{
+			Type:        "GUARDRAIL_" + strings.ToUpper(v.Rule),
+			Subject:     e.mode.String(),
+			Description: v.Description,
+		})
+	}
+	return statusWithDetails(st, errorInfo(reasonGuardrailViolation, map[string]string{"mode": e.mode.String()}), failure)
+}
+
+// guardrailViolations returns the violations of a guardrail rejection, or
+// nil for any other error
+func guardrailViolations(err error) []guardrailViolation {
+	var rejected *guardrailError
+	if errors.As(err, &rejected) {
+		return rejected.violations
+	}
+	return nil
+}
+
+// guardrailPattern is a regular expression the response must match, or must
+// not match when Forbidden is set
+type guardrailPattern struct {
+	Name      string `json:"name"`
+	Regex     string `json:"regex"`
+	Forbidden bool   `json:"forbidden,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// jsonSchema is the subset of JSON Schema supported by the schema rule:
+// type, required, properties, items and enum
+type jsonSchema struct {
+	Type       string                 `json:"type,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Items      *jsonSchema            `json:"items,omitempty"`
+	Enum       []any                  `json:"enum,omitempty"`
+}
+
+// guardrailRules are the output checks of one endpoint. Unset rules are not
+// checked.
+type guardrailRules struct {
+	// MinLength and MaxLength bound the response length in characters
+	MinLength int `json:"min_length,omitempty"`
+	MaxLength int `json:"max_length,omitempty"`
+	// Denylist phrases may not appear in the response (case-insensitive)
+	Denylist []string            `json:"denylist,omitempty"`
+	Patterns []*guardrailPattern `json:"patterns,omitempty"`
+	// Schema requires the response to be a JSON document matching it
+	Schema *jsonSchema `json:"schema,omitempty"`
+	// Action is "retry" (default) to ask the model again with a corrective
+	// instruction, or "reject" to fail the request right away
+	Action string `json:"action,omitempty"`
+	// MaxRetries is the number of corrective retries, 1 when unset
+	MaxRetries *int `json:"max_retries,omitempty"`
+}
+
+// outputGuardrails validates model responses against per-endpoint rules
+// before they are returned, cached or delivered. Endpoints without rules
+// pass responses through unchanged.
+type outputGuardrails struct {
+	rules map[genaidemo.Mode]*guardrailRules
+	// events receives a moderation event for each violating response
+	events *eventBus
+}
+
+// newOutputGuardrails loads guardrails from a JSON file mapping endpoint
+// modes to rules, e.g.
+//
+//	{"MODE_CHAT": {"max_length": 2000, "denylist": ["as an ai language model"], "action": "retry", "max_retries": 2}}
+//
+// An empty path disables guardrails.
+func newOutputGuardrails(path string) (*outputGuardrails, error) {
+	g := &outputGuardrails{rules: make(map[genaidemo.Mode]*guardrailRules)}
+	if path == "" {
+		return g, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guardrails file: %w", err)
+	}
+	var guardrails map[string]*guardrailRules
+	if err := json.Unmarshal(data, &guardrails); err != nil {
+		return nil, fmt.Errorf("failed to parse guardrails file: %w", err)
+	}
+
+	for name, rules := range guardrails {
+		mode := genaidemo.Mode(genaidemo.Mode_value[name])
+		if mode == genaidemo.Mode_MODE_UNKNOWN {
+			return nil, fmt.Errorf("guardrails file: unknown mode %q", name)
+		}
+		if err := rules.compile(); err != nil {
+			return nil, fmt.Errorf("guardrails file: %s: %w", name, err)
+		}
+		g.rules[mode] = rules
+		log.Printf("🛡️ Output guardrails on %s (on violation: %s, max retries %d)", mode, rules.Action, *rules.MaxRetries)
+	}
+	return g, nil
+}
+
+// compile validates the rules, fills in defaults and compiles the patterns
+func (r *guardrailRules) compile() error {
+	switch {
+	case r.MinLength < 0 || r.MaxLength < 0:
+		return fmt.Errorf("lengths cannot be negative")
+	case r.MaxLength > 0 && r.MinLength > r.MaxLength:
+		return fmt.Errorf("min_length %d exceeds max_length %d", r.MinLength, r.MaxLength)
+	case r.MaxRetries != nil && *r.MaxRetries < 0:
+		return fmt.Errorf("max_retries cannot be negative")
+	}
+
+	switch r.Action {
+	case "":
+		r.Action = guardrailActionRetry
+	case guardrailActionRetry, guardrailActionReject:
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if r.MaxRetries == nil {
+		retries := 1
+		r.MaxRetries = &retries
+	}
+
+	for i, p := range r.Patterns {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return fmt.Errorf("pattern %d: %w", i, err)
+		}
+		p.re = re
+		if p.Name == "" {
+			p.Name = p.Regex
+		}
+	}
+	for i, phrase := range r.Denylist {
+		r.Denylist[i] = strings.ToLower(phrase)
+	}
+	return nil
+}
+
+// check returns the rules the content violates
+func (r *guardrailRules) check(content string) []guardrailViolation {
+	var violations []guardrailViolation
+	add := func(rule, format string, args ...any) {
+		violations = append(violations, guardrailViolation{Rule: rule, Description: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(content)
+	if r.MinLength > 0 && length < r.MinLength {
+		add("min_length", "response has %d characters, at least %d required", length, r.MinLength)
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		add("max_length", "response has %d characters, at most %d allowed", length, r.MaxLength)
+	}
+
+	lower := strings.ToLower(content)
+	for _, phrase := range r.Denylist {
+		if strings.Contains(lower, phrase) {
+			add("denylist", "response contains the forbidden phrase %q", phrase)
+		}
+	}
+
+	for _, p := range r.Patterns {
+		matched := p.re.MatchString(content)
+		if p.Forbidden && matched {
+			add("pattern", "response matches the forbidden pattern %s", p.Name)
+		} else if !p.Forbidden && !matched {
+			add("pattern", "response does not match the required pattern %s", p.Name)
+		}
+	}
+
+	if r.Schema != nil {
+		var doc any
+		if err := json.Unmarshal([]byte(stripCodeFence(content)), &doc); err != nil {
+			add("schema", "response is not valid JSON: %v", err)
+		} else {
+			for _, problem := range r.Schema.validate("$", doc) {
+				add("schema", "%s", problem)
+			}
+		}
+	}
+	return violations
+}
+
+// stripCodeFence removes a markdown code fence around a JSON response
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	if i := strings.IndexByte(content, '\n'); i >= 0 {
+		content = content[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+}
+
+// validate checks a decoded JSON value against the schema, returning one
+// problem per mismatch with the path of the offending value
+func (s *jsonSchema) validate(path string, v any) []string {
+	if s.Type != "" && !matchesJSONType(s.Type, v) {
+		return []string{fmt.Sprintf("%s should be of type %s", path, s.Type)}
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(v) }) {
+		return []string{fmt.Sprintf("%s should be one of %v", path, s.Enum)}
+	}
+
+	var problems []string
+	switch value := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s is missing the required property %q", path, name))
+			}
+		}
+		for name, prop := range s.Properties {
+			if field, ok := value[name]; ok {
+				problems = append(problems, prop.validate(path+"."+name, field)...)
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range value {
+				problems = append(problems, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	}
+	return problems
+}
+
+// matchesJSONType reports whether a value decoded by encoding/json has the
+// given JSON Schema type
+func matchesJSONType(typ string, v any) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	default:
+		return true
+	}
+}
+
+// run calls chat and validates the response against the endpoint's rules.
+// On a violation it either retries with a corrective instruction or returns
+// a guardrailError, depending on the configured action. Token usage of all
+// attempts is added up in the returned result.
+func (g *outputGuardrails) run(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest, chat chatFunc) (*ChatResult, error) {
+	result, err := chat(ctx, req.Messages, req.Temperature, req.MaxTokens)
+	rules := g.rules[mode]
+	// Blocked replies are empty, there is nothing to check or correct
+	if err != nil || rules == nil || result.SafetyBlock != nil {
+		return result, err
+	}
+
+	prefix := strings.ToLower(strings.TrimPrefix(mode.String(), "MODE_")) + "."
+	var usage TokenUsageInfo
+	addTokenUsage(&usage, result.TokenUsage)
+	timings := result.Timings
+	for attempt := 1; ; attempt++ {
+		guardrailMetrics.Add(prefix+"checked", 1)
+		// Tool results are checked too, they are part of the v1 content
+		content := contentWithToolResults(result.Content, result.ToolInvocations)
+		violations := rules.check(content)
+		if len(violations) == 0 {
+			// Other candidates aren't corrected, those violating rules are dropped
+			result.Candidates = slices.DeleteFunc(result.Candidates, func(candidate string) bool {
+				return len(rules.check(candidate)) > 0
+			})
+			result.TokenUsage = &usage
+			result.Timings = timings
+			return result, nil
+		}
+		for _, v := range violations {
+			guardrailMetrics.Add(prefix+"violations."+v.Rule, 1)
+		}
+		rejected := rules.Action == guardrailActionReject || attempt > *rules.MaxRetries
+		g.emitViolations(ctx, mode, attempt, violations, rejected)
+
+		if rejected {
+			guardrailMetrics.Add(prefix+"rejected", 1)
+			log.Printf("🛡️ [%s] Response rejected by output guardrails after %d attempts: %d violations", mode, attempt, len(violations))
+			return nil, &guardrailError{mode: mode, attempts: attempt, violations: violations}
+		}
+
+		// Don't pay for a corrective attempt nobody is waiting for
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		guardrailMetrics.Add(prefix+"retries", 1)
+		log.Printf("🛡️ [%s] Response violated %d output guardrails, retrying with a corrective instruction", mode, len(violations))
+		result, err = chat(ctx, correctiveMessages(req.Messages, content, violations), req.Temperature, req.MaxTokens)
+		if err != nil {
+			return nil, err
+		}
+		addTokenUsage(&usage, result.TokenUsage)
+		timings.add(result.Timings)
+	}
+}
+
+// emitViolations publishes a moderation event for the violations of an
+// attempt, which was either rejected or is retried
+func (g *outputGuardrails) emitViolations(ctx context.Context, mode genaidemo.Mode, attempt int, violations []guardrailViolation, rejected bool) {
+	if g.events == nil {
+		return
+	}
+	rules := make([]string, len(violations))
+	for i, v := range violations {
+		rules[i] = v.Rule
+	}
+	action := "retry"
+	if rejected {
+		action = "reject"
+	}
+	g.events.emit(newEvent(ctx, eventModerationTriggered, mode, map[string]any{
+		"source":  "output_guardrails",
+		"rules":   rules,
+		"attempt": attempt,
+		"action":  action,
+	}))
+}
+
+// addTokenUsage adds the token counts of one attempt to a running total
+func addTokenUsage(total, usage *TokenUsageInfo) {
+	if usage == nil {
+		return
+	}
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	total.TotalTokens += usage.TotalTokens
+	total.ContextTokens += usage.ContextTokens
+	total.CachedTokens += usage.CachedTokens
+}
+
+// correctiveMessages inserts a system instruction describing the rejected
+// response and its violations before the last message. The user's message
+// stays last, so endpoints that act on it (such as document retrieval) see
+// the original query.
+func correctiveMessages(messages []*genaidemo.Message, rejected string, violations []guardrailViolation) []*genaidemo.Message {
+	var b strings.Builder
+	b.WriteString("Your previous answer to the following message was rejected because it broke these rules:\n")
+	for _, v := range violations {
+		fmt.Fprintf(&b, "- %s\n", v.Description)
+	}
+	b.WriteString("Answer again and make sure the new answer follows all of them. The rejected answer was:\n")
+	b.WriteString(rejected)
+
+	last := len(messages) - 1
+	corrected := make([]*genaidemo.Message, 0, len(messages)+1)
+	corrected = append(corrected, messages[:last]...)
+	corrected = append(corrected, &genaidemo.Message{Role: genaidemo.Role_ROLE_SYSTEM, Content: b.String()})
+	return append(corrected, messages[last])
+}
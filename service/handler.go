@@ -1,10 +1,19 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -15,184 +24,1566 @@ type Service interface {
 	ChatWithTool(ctx context.Context, messages []*genaidemo.Message, temperature *float32, maxTokens *int32) (*ChatResult, error)
 	ChatWithAgent(ctx context.Context, messages []*genaidemo.Message, temperature *float32, maxTokens *int32) (*ChatResult, error)
 	ChatWithDoc(ctx context.Context, messages []*genaidemo.Message, temperature *float32, maxTokens *int32) (*ChatResult, error)
+	Transcribe(ctx context.Context, data []byte, mimeType string) (string, error)
+	Synthesize(ctx context.Context, text string) ([]byte, string, error)
+	IngestDocument(ctx context.Context, doc ChromaDBIngestRequest, body io.Reader) (*ChromaDBIngestResponse, error)
+	CollectionStats(ctx context.Context, name string) (*ChromaDBCollectionStats, error)
+	CollectionFreshness(ctx context.Context, name string) (*ChromaDBCollectionFreshness, error)
+	CircuitBreakers() []circuitBreakerStatus
+	CheckDependencies(ctx context.Context) []dependencyHealth
+	Evaluate(ctx context.Context, messages []*genaidemo.Message, response string, documents, criteria []string) (*llm.Evaluation, error)
+	ExtractUserFacts(ctx context.Context, messages []*genaidemo.Message, known []llm.UserFact) ([]llm.UserFact, *llm.TokenUsage, error)
+	ExtractGraph(ctx context.Context, text string) (*llm.GraphExtraction, *llm.TokenUsage, error)
+	ExtractSessionEntities(ctx context.Context, messages []*genaidemo.Message, reply string, known []llm.SessionEntity) ([]llm.SessionEntity, *llm.TokenUsage, error)
+	ClassifyConversations(ctx context.Context, samples []llm.ConversationSample, topics []string) ([]llm.ConversationLabels, *llm.TokenUsage, error)
+	GenerateTitle(ctx context.Context, query, reply string) (string, *llm.TokenUsage, error)
+	DocumentChunks(ctx context.Context, doc ChromaDBDocumentRequest) ([]string, error)
+	Summarize(ctx context.Context, texts []string, opts llm.SummaryOptions) (*llm.SummaryResult, error)
+	ClassifyText(ctx context.Context, text string, labels []llm.ClassLabel) (*llm.Classification, *llm.TokenUsage, error)
+	WarmUp(ctx context.Context) error
 	Close() error
 }
 
 // ChatResult represents the result of a chat interaction
 type ChatResult struct {
+	// Content is the model's reply, without tool results
 	Content    string
 	TokenUsage *TokenUsageInfo
+	// Sources are the documents retrieved as context, if any
+	Sources []*genaidemo.RetrievedDocument
+	// ToolInvocations are the tool calls made for the reply, in call order
+	ToolInvocations []*genaidemo.ToolInvocation
+	// DegradedReason is set when a fallback produced the reply
+	DegradedReason string
+	// Warnings flag full replies the caller should treat with care, e.g.
+	// stale_index
+	Warnings []string
+	// Candidates are the other replies when the request asked for several
+	Candidates []string
+	// Completions are every completion of a request with n above 1
+	Completions []*ChatCompletion
+	// Logprobs are the tokens of the reply with their log probabilities,
+	// when requested and offered by the provider
+	Logprobs []*genaidemo.TokenLogprob
+	// SafetyBlock is set when the provider blocked the reply
+	SafetyBlock *genaidemo.SafetyBlock
+	// FinishReason, SafetyRatings and ModelVersion are reported by the model
+	ModelVersion  string
+	FinishReason  genaidemo.FinishReason
+	SafetyRatings []*genaidemo.SafetyRating
+	Timings       ChatTimings
 }
 
+// ChatTimings is the time spent in each stage of producing a reply
+type ChatTimings struct {
+	Retrieval  time.Duration
+	Generation time.Duration
+	Tools      time.Duration
+}
+
+// add adds the timings of another attempt
+func (t *ChatTimings) add(other ChatTimings) {
+	t.Retrieval += other.Retrieval
+	t.Generation += other.Generation
+	t.Tools += other.Tools
+}
+
+// chatReply is the response to a chat request together with the service
+// result it was built from, which carries the details only the v2 API reports
+type chatReply struct {
+	response *genaidemo.ChatResponse
+	result   *ChatResult
+	// model is the model that served the request
+	model  string
+	cached bool
+}
+
+// Degraded reasons reported in ChatResponse.degraded_reason
+const (
+	// degradedRetrievalUnavailable marks ChatWithDoc replies generated
+	// without documents because the vector store could not be queried
+	degradedRetrievalUnavailable = "retrieval_unavailable"
+	// degradedToolFailed marks ChatWithTool replies where a tool call failed
+	degradedToolFailed = "tool_failed"
+	// degradedToolsUnavailable marks ChatWithTool replies generated without
+	// offering tools to the model
+	degradedToolsUnavailable = "tools_unavailable"
+)
+
 // TokenUsageInfo contains token usage statistics
 type TokenUsageInfo struct {
 	InputTokens  int32
 	OutputTokens int32
 	TotalTokens  int32
+	// ContextTokens is the part of InputTokens taken by retrieved documents
+	ContextTokens int32
+	// CachedTokens is the part of InputTokens served from the context cache
+	CachedTokens int32
 }
 
 // Handler is handling incoming gRPC requests
 type Handler struct {
 	genaidemo.UnimplementedChatServiceServer
-	service Service
+	service     Service
+	jobs        *jobQueue
+	webhooks    *webhookNotifier
+	templates   *templateStore
+	examples    *exampleStore
+	memory      *memoryStore
+	graph       *knowledgeGraph
+	sessions    *sessionEntityStore
+	history     *sessionHistory
+	analytics   *conversationAnalytics
+	feedback    *feedbackStore
+	exports     *exportStore
+	experiments *experimentRouter
+	rollouts    *rolloutRouter
+	guardrails  *outputGuardrails
+	safety      *safetyPolicy
+	models      *capabilityRegistry
+	events      *eventBus
+	tenants     *tenantRegistry
+	acl         *aclRegistry
+	jwt         *jwtVerifier
+	cache       *responseCache
+	coalescer   *requestCoalescer
+	limiter     *concurrencyLimiter
+	usage       *usageTracker
+	keyTiers    map[string]genaidemo.Priority
+	budgets     *budgetEnforcer
+	rateLimit   *rateLimiter
+	redis       *redisClient
+	health      *healthChecker
+	model       string
+	judgeModel  string
+
+	// summaryModel writes the summaries of Summarize
+	summaryModel string
+	// classifyModel labels texts in Classify
+	classifyModel string
+	// router picks the modes of ChatAuto requests
+	router modeRouter
+	// intents classify the intents of chat requests, tried in order
+	intents []intentClassifier
+	// systemPrompts are the system prompt templates of the chat modes, to
+	// tell whether replies depend on the intent
+	systemPrompts map[genaidemo.Mode]string
+
+	// inputTokenLimit overrides the model context window when positive
+	inputTokenLimit int
+	truncation      llm.TruncationStrategy
+
+	// maxDocumentBytes limits uploaded documents, spooled to uploadDir
+	maxDocumentBytes int64
+	uploadDir        string
+	uploadTimeout    time.Duration
+	uploads          *uploadStore
+
+	// ready is set once startup warm-up completed, or immediately when disabled
+	ready atomic.Bool
+	// draining is set on shutdown so readiness probes take the instance out
+	// of rotation before the servers stop
+	draining atomic.Bool
+	// maintenance is set by the Drain admin operation, which also rejects new
+	// requests; drainRequested is closed to start the shutdown
+	maintenance    atomic.Bool
+	adminKeys      map[string]bool
+	drainOnce      sync.Once
+	drainRequested chan struct{}
 }
 
 // newHandler creates a new handler with the given service
-func newHandler(service Service) (*Handler, error) {
+func newHandler(service Service, cfg *serviceConfig) (*Handler, error) {
 	if service == nil {
 		return nil, errors.New("service must be set")
 	}
 
-	return &Handler{
-		service: service,
-	}, nil
+	templates, err := newTemplateStore(cfg.promptTemplatesFile)
+	if err != nil {
+		return nil, err
+	}
+	examples, err := newExampleStore(cfg.fewShotExamplesFile, cfg.fewShotTokenBudget)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := newMemoryStore(cfg.userMemoryFile, cfg.memoryTokenBudget)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := newKnowledgeGraph(cfg.knowledgeGraphFile, cfg.graphTokenBudget)
+	if err != nil {
+		return nil, err
+	}
+	analytics, err := newConversationAnalytics(cfg.analyticsFile, cfg.analyticsInterval, cfg.analyticsRetention, cfg.analyticsTopics, service.ClassifyConversations)
+	if err != nil {
+		return nil, err
+	}
+	history, err := newSessionHistory(cfg.sessionsFile, cfg.sessionRetention, cfg.sessionTitleModel)
+	if err != nil {
+		return nil, err
+	}
+	feedback, err := newFeedbackStore(cfg.feedbackFile, cfg.feedbackWindow)
+	if err != nil {
+		return nil, err
+	}
+	exports, err := newExportStore(cfg.exportDir, cfg.exportRetention)
+	if err != nil {
+		return nil, err
+	}
+	experiments, err := newExperimentRouter(cfg.experimentsFile)
+	if err != nil {
+		return nil, err
+	}
+	rollouts, err := newRolloutRouter(cfg.rolloutsFile)
+	if err != nil {
+		return nil, err
+	}
+	guardrails, err := newOutputGuardrails(cfg.guardrailsFile)
+	if err != nil {
+		return nil, err
+	}
+	safety, err := newSafetyPolicy(cfg.safetySettingsFile)
+	if err != nil {
+		return nil, err
+	}
+	models, err := newCapabilityRegistry(cfg.capabilitiesFile)
+	if err != nil {
+		return nil, err
+	}
+	generationModels := []string{cfg.modelName, cfg.judgeModel, cfg.budgetDowngradeModel, cfg.sessionTitleModel, cfg.summaryModel, cfg.classifyModel, cfg.autoRouterModel, cfg.intentModel}
+	if err := models.checkConfigured(generationModels, cfg.embeddingModel); err != nil {
+		return nil, err
+	}
+	redis, err := newRedisClient(cfg.redisURL, DefaultRedisPoolSize, cfg.redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := newTenantRegistry(cfg.tenantsFile, redis)
+	if err != nil {
+		return nil, err
+	}
+	acl, err := newACLRegistry(cfg.aclFile)
+	if err != nil {
+		return nil, err
+	}
+	events, err := newEventBus(cfg)
+	if err != nil {
+		return nil, err
+	}
+	guardrails.events = events
+	uploads, err := newUploadStore(cfg.uploadDir, cfg.uploadExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{
+		service:     service,
+		webhooks:    newWebhookNotifier(cfg.webhookSecret, cfg.webhookMaxAttempts, cfg.webhookTimeout),
+		templates:   templates,
+		examples:    examples,
+		memory:      memory,
+		graph:       graph,
+		sessions:    newSessionEntityStore(cfg.sessionEntityTTL, cfg.sessionEntityTokenBudget),
+		history:     history,
+		analytics:   analytics,
+		feedback:    feedback,
+		exports:     exports,
+		experiments: experiments,
+		rollouts:    rollouts,
+		guardrails:  guardrails,
+		safety:      safety,
+		models:      models,
+		events:      events,
+		tenants:     tenants,
+		acl:         acl,
+		jwt:         newJWTVerifier(cfg.jwtSecret),
+		cache:       newResponseCache(cfg.responseCacheTTL, cfg.responseCacheSize),
+		coalescer:   newRequestCoalescer(cfg.coalesceRequests),
+		limiter:     newConcurrencyLimiter(cfg.maxConcurrentRequests, cfg.shedQueueThreshold, cfg.shedLatencyP95),
+		usage:       newUsageTracker(cfg.usageRetention, redis),
+		keyTiers:    cfg.apiKeyTiers,
+		adminKeys:   cfg.adminKeys,
+		rateLimit:   newRateLimiter(cfg.rateLimitRPS, cfg.rateLimitBurst, redis),
+		redis:       redis,
+		health:      newHealthChecker(service, cfg.healthCheckTimeout, cfg.healthCheckInterval),
+		model:       cfg.modelName,
+		judgeModel:  cmp.Or(cfg.judgeModel, cfg.modelName),
+
+		summaryModel:  cmp.Or(cfg.summaryModel, cfg.modelName),
+		classifyModel: cmp.Or(cfg.classifyModel, cfg.modelName),
+		router:        modeRouter{model: cfg.autoRouterModel, minConfidence: cfg.autoRouterMinConfidence},
+		systemPrompts: cfg.systemPrompts,
+
+		inputTokenLimit: cfg.inputTokenLimit,
+		truncation:      cfg.inputTruncation,
+
+		maxDocumentBytes: cfg.maxDocumentBytes,
+		uploadDir:        cfg.uploadDir,
+		uploadTimeout:    cfg.uploadTimeout,
+		uploads:          uploads,
+
+		drainRequested: make(chan struct{}),
+	}
+	h.intents = newIntentClassifiers(h, cfg)
+	h.budgets = newBudgetEnforcer(h.usage, cfg.defaultMonthlyBudget, cfg.monthlyBudgets, cfg.budgetPolicy, cfg.budgetDowngradeModel)
+	h.jobs = newJobQueue(cfg.jobWorkers, cfg.jobQueueSize, cfg.jobTimeout, cfg.jobRetention, h.chatWithMode)
+
+	return h, nil
 }
 
+// chatFunc is the signature shared by the chat methods of the Service
+type chatFunc func(ctx context.Context, messages []*genaidemo.Message, temperature *float32, maxTokens *int32) (*ChatResult, error)
+
 // Chat handles the Chat gRPC method
 func (h *Handler) Chat(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
-	if req.Messages == nil || len(req.Messages) == 0 {
+	return h.handleChat(ctx, genaidemo.Mode_MODE_CHAT, req, h.service.Chat)
+}
+
+// ChatWithTool handles the ChatWithTool gRPC method
+func (h *Handler) ChatWithTool(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return h.handleChat(ctx, genaidemo.Mode_MODE_TOOL, req, h.service.ChatWithTool)
+}
+
+// ChatWithAgent handles the ChatWithAgent gRPC method
+func (h *Handler) ChatWithAgent(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return h.handleChat(ctx, genaidemo.Mode_MODE_AGENT, req, h.service.ChatWithAgent)
+}
+
+// ChatWithDoc handles the ChatWithDoc gRPC method
+func (h *Handler) ChatWithDoc(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	return h.handleChat(ctx, genaidemo.Mode_MODE_DOC, req, h.service.ChatWithDoc)
+}
+
+// handleChat serves a v1 chat RPC
+func (h *Handler) handleChat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest, chat chatFunc) (*genaidemo.ChatResponse, error) {
+	reply, err := h.chat(ctx, mode, req, chat)
+	if err != nil {
+		return nil, err
+	}
+	return reply.response, nil
+}
+
+// chat validates the request, runs it through the given service method and
+// delivers the completion webhook when a callback URL was supplied
+func (h *Handler) chat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest, chat chatFunc) (*chatReply, error) {
+	// Tenants may have their own default model
+	if t := tenantFromContext(ctx); t != nil && t.model != "" {
+		ctx = withModelOverride(ctx, t.model)
+	}
+	// Canary rollouts, then experiments, may swap the template version or
+	// model before rendering
+	ctx, arm := h.rollouts.assign(ctx, mode, req)
+	ctx, variant := h.experiments.assign(ctx, mode, req)
+	templateRef, err := h.applyTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Messages) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "messages cannot be empty")
 	}
+	if err := validateCallbackURL(ctx, req.GetCallbackUrl()); err != nil {
+		return nil, err
+	}
+	if err := validateSessionID(req.GetSessionId()); err != nil {
+		return nil, err
+	}
+	ctx = h.classifyIntent(ctx, req)
+	// Session entities clear the session id once applied
+	sessionID := req.GetSessionId()
+	req.Priority = h.requestPriority(ctx, req)
+	h.applyExamples(ctx, mode, req)
+	owner, remember := h.applyMemory(ctx, req)
+	session, track := h.applySessionEntities(ctx, req)
 
-	// Validate messages
-	for i, msg := range req.Messages {
-		if msg.Content == "" {
-			return nil, status.Errorf(codes.InvalidArgument, "message content cannot be empty at index %d", i)
+	h.events.emit(newEvent(ctx, eventRequestStarted, mode, map[string]any{
+		"model":    modelFromContext(ctx, h.model),
+		"priority": req.Priority.String(),
+		"variant":  variant,
+		"rollout":  arm,
+		"intent":   intentVariable(ctx),
+	}))
+
+	start := time.Now()
+	reply, err := h.runChat(ctx, mode, req, chat)
+	var response *genaidemo.ChatResponse
+	if reply != nil {
+		response = reply.response
+	}
+	// Cached replies answered the same messages before, so there is nothing
+	// new to learn from them
+	if remember && err == nil && !reply.cached {
+		h.rememberFacts(ctx, owner, req.Messages)
+	}
+	// Cached replies still answered a user, so they count for analytics
+	if err == nil {
+		h.analytics.record(ctx, mode, req.Messages, response.Content)
+	}
+	if track && err == nil && !reply.cached {
+		h.trackEntities(ctx, session, req.Messages, response.Content)
+	}
+	if sessionID != "" && err == nil {
+		h.recordSession(ctx, sessionID, req.Messages, response.Content)
+	}
+	if usesGraph(h.graph, req) && err == nil && !reply.cached {
+		if last := req.Messages[len(req.Messages)-1]; last.Role == genaidemo.Role_ROLE_USER {
+			h.learnGraph(ctx, graphSourceConversation, last.Content)
 		}
-		if msg.Role == genaidemo.Role_ROLE_UNKNOWN {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid message role at index %d", i)
+	}
+	if response != nil && templateRef != nil {
+		// Record which prompt version served the request
+		response.PromptTemplate = templateRef
+		log.Printf("📝 [%s] Served with prompt template %s v%d", mode, templateRef.Name, templateRef.Version)
+	}
+	if variant != "" {
+		if response != nil {
+			response.Variant = variant
+		}
+		h.experiments.record(ctx, mode, variant, h.model, time.Since(start), response, err)
+		log.Printf("🧪 [%s] Served by experiment variant %s", mode, variant)
+	}
+	if arm != "" {
+		if response != nil {
+			response.Rollout = arm
 		}
+		h.rollouts.record(ctx, mode, arm, h.model, time.Since(start), response, err)
+		log.Printf("🐤 [%s] Served by rollout arm %s", mode, arm)
+	}
+	if err == nil {
+		h.feedback.record(ctx, mode, req.Messages, response)
 	}
+	h.emitChatEvents(ctx, mode, reply, err, time.Since(start))
+	if req.GetCallbackUrl() != "" {
+		h.webhooks.notify(req.GetCallbackUrl(), newWebhookPayload(ctx, mode, response, err))
+	}
+	return reply, err
+}
 
-	result, err := h.service.Chat(ctx, req.Messages, req.Temperature, req.MaxTokens)
+func (h *Handler) runChat(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest, chat chatFunc) (*chatReply, error) {
+	// Transcribe audio attachments and validate messages
+	if err := h.prepareMessages(ctx, req.Messages); err != nil {
+		return nil, err
+	}
+
+	// Reply in the requested language, or the one the user wrote in. The
+	// resolved language is kept on the request so it is part of the cache key.
+	language, err := resolveResponseLanguage(req)
 	if err != nil {
 		return nil, err
 	}
+	req.ResponseLanguage = nil
+	if language != "" {
+		req.ResponseLanguage = &language
+		ctx = withResponseLanguage(ctx, language)
+	}
 
-	response := &genaidemo.ChatResponse{
-		Content: result.Content,
+	// Per-request retrieval parameters of ChatWithDoc
+	if err := validateRetrievalOptions(req.Retrieval); err != nil {
+		return nil, err
+	}
+	if err := h.tenants.checkCollection(tenantFromContext(ctx), req.Retrieval.GetCollection()); err != nil {
+		return nil, err
+	}
+	if req.Retrieval != nil {
+		ctx = withRetrievalOptions(ctx, req.Retrieval)
 	}
 
-	if result.TokenUsage != nil {
-		response.TokenUsage = &genaidemo.TokenUsage{
-			InputTokenNum:  result.TokenUsage.InputTokens,
-			OutputTokenNum: result.TokenUsage.OutputTokens,
-			TotalTokenNum:  result.TokenUsage.TotalTokens,
+	// Sampling parameters beyond temperature and max_tokens reach the
+	// provider through the context
+	if err := validateSamplingOptions(req); err != nil {
+		return nil, err
+	}
+	ctx = withSamplingOptions(ctx, req)
+	safetySettings, err := h.safety.settings(mode, req.SafetySettings)
+	if err != nil {
+		return nil, err
+	}
+	if len(safetySettings) > 0 {
+		ctx = llm.WithSafetySettings(ctx, safetySettings)
+	}
+
+	// Provider credentials of the request, or else of the tenant, make the
+	// provider bill the caller's own account. They are taken off the request
+	// so the secret isn't kept with it.
+	creds, err := newProviderCredentials(req.ProviderCredentials)
+	if err != nil {
+		return nil, err
+	}
+	req.ProviderCredentials = nil
+	if creds != nil {
+		ctx = withProviderCredentials(ctx, creds)
+	}
+	creds = providerCredentialsFromContext(ctx)
+
+	// Keys over their monthly budget are downgraded to a cheaper model or
+	// rejected; rejection is deferred so cached responses are still served
+	requested := modelFromContext(ctx, h.model)
+	model, budgetErr := h.budgetModel(ctx, requested)
+	if model != requested {
+		ctx = withModelOverride(ctx, model)
+	}
+
+	// Reject requests the model can't serve, or adapt them, before they
+	// reach the provider
+	ctx, err = h.models.adapt(ctx, mode, model)
+	if err != nil {
+		return nil, err
+	}
+
+	// ChatWithDoc traverses the knowledge graph from the entities the
+	// question mentions, complementing vector recall
+	if mode == genaidemo.Mode_MODE_DOC && usesGraph(h.graph, req) {
+		if last := req.Messages[len(req.Messages)-1]; last.Role == genaidemo.Role_ROLE_USER {
+			if prompt := h.graph.prompt(tenantID(ctx), last.Content, model); prompt != "" {
+				ctx = withGraphContext(ctx, prompt)
+			}
 		}
 	}
 
-	return response, nil
+	// Reject or trim oversized input before it reaches the provider
+	if err := h.fitInputTokens(mode, model, req); err != nil {
+		return nil, err
+	}
+
+	// Only system prompts using {{.intent}} make replies depend on it
+	var intent string
+	if strings.Contains(systemPromptTemplate(ctx, h.systemPrompts, mode), ".intent") {
+		intent = intentVariable(ctx)
+	}
+	key := chatRequestKey(tenantID(ctx), documentAccessFromContext(ctx).fingerprint(), creds.fingerprint(), model, mode, req, graphContextFromContext(ctx), intent)
+	useCache := h.cache.enabled() && !req.GetBypassCache()
+	if useCache {
+		if result, ok := h.cache.get(key); ok {
+			log.Printf("🗄️ [%s] Serving response from cache", mode)
+			return h.buildReply(ctx, mode, req, model, result, true)
+		}
+	}
+	if budgetErr != nil {
+		return nil, budgetErr
+	}
+
+	// Bound concurrent provider calls, shedding low-priority work when overloaded
+	release, err := h.limiter.acquire(ctx, req.Priority)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Identical concurrent requests share a single provider call; responses
+	// are validated by the output guardrails before they can be cached
+	result, err := h.coalescer.do(ctx, key, func(ctx context.Context) (*ChatResult, error) {
+		return h.runCompletions(ctx, mode, req, chat)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Cache hits cost nothing, so only provider results count towards usage
+	h.recordUsage(ctx, model, result.TokenUsage)
+	// Degraded replies are not cached so the full reply is served once the
+	// dependency recovers, nor are blocked ones
+	if useCache && result.DegradedReason == "" && result.SafetyBlock == nil {
+		h.cache.put(key, result)
+	}
+
+	return h.buildReply(ctx, mode, req, model, result, false)
 }
 
-// ChatWithTool handles the ChatWithTool gRPC method
-func (h *Handler) ChatWithTool(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
-	if len(req.Messages) == 0 {
+// budgetModel applies the monthly budgets of the caller's API key and tenant
+// to a provider call with the requested model. It returns the model to call,
+// downgraded once the key's budget is spent, and a ResourceExhausted error
+// when the key or the tenant can't spend more. Calls billed to the caller's
+// own provider account don't count against budgets.
+func (h *Handler) budgetModel(ctx context.Context, requested string) (string, error) {
+	if providerCredentialsFromContext(ctx) != nil {
+		return requested, nil
+	}
+	model, err := h.budgets.modelFor(apiKeyIDFromContext(ctx), requested)
+	if err != nil {
+		return requested, err
+	}
+	return model, h.budgets.checkTenant(tenantFromContext(ctx))
+}
+
+// recordUsage adds a provider call to the usage of the caller's API key and,
+// if it has one, of its tenant. Calls made with the caller's own provider
+// credentials count their tokens at no cost to the service.
+func (h *Handler) recordUsage(ctx context.Context, model string, usage *TokenUsageInfo) {
+	record := h.usage.record
+	if providerCredentialsFromContext(ctx) != nil {
+		record = h.usage.recordUnbilled
+	}
+	record(apiKeyIDFromContext(ctx), model, usage)
+	if t := tenantFromContext(ctx); t != nil {
+		record(t.usageKey(), model, usage)
+	}
+}
+
+// recordLLMUsage records the token usage of a model call made through the
+// llm package, such as a classification, extraction or judge call
+func (h *Handler) recordLLMUsage(ctx context.Context, model string, usage *llm.TokenUsage) {
+	if usage == nil {
+		return
+	}
+	h.recordUsage(ctx, model, &TokenUsageInfo{
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		TotalTokens:  usage.TotalTokens,
+		CachedTokens: usage.CachedTokens,
+	})
+}
+
+// fitInputTokens checks the conversation against the input token limit,
+// applying the configured truncation strategy when it doesn't fit
+func (h *Handler) fitInputTokens(mode genaidemo.Mode, model string, req *genaidemo.ChatRequest) error {
+	limit := h.inputTokenLimit
+	if limit <= 0 {
+		limit = h.models.contextWindow(model)
+	}
+
+	messages, err := llm.FitMessages(model, req.Messages, limit, h.truncation)
+	if err != nil {
+		return err
+	}
+	if dropped := len(req.Messages) - len(messages); dropped > 0 {
+		log.Printf("✂️ [%s] Dropped %d earlier messages to fit the %d token input limit", mode, dropped, limit)
+	}
+	req.Messages = messages
+	return nil
+}
+
+// SubmitChat handles the SubmitChat gRPC method
+func (h *Handler) SubmitChat(ctx context.Context, req *genaidemo.SubmitChatRequest) (*genaidemo.Job, error) {
+	if req.Request == nil || len(req.Request.Messages) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "messages cannot be empty")
 	}
+	if req.Mode == genaidemo.Mode_MODE_UNKNOWN {
+		return nil, status.Error(codes.InvalidArgument, "mode must be set")
+	}
+	if err := validateCallbackURL(ctx, req.Request.GetCallbackUrl()); err != nil {
+		return nil, err
+	}
 
-	// Validate messages
-	for i, msg := range req.Messages {
-		if msg.Content == "" {
-			return nil, status.Errorf(codes.InvalidArgument, "message content cannot be empty at index %d", i)
+	// Background jobs yield to interactive requests unless asked otherwise
+	req.Request.Priority = h.requestPriority(ctx, req.Request)
+	if req.Request.Priority == genaidemo.Priority_PRIORITY_UNSPECIFIED {
+		req.Request.Priority = genaidemo.Priority_PRIORITY_LOW
+	}
+
+	return h.jobs.submit(ctx, req.Mode, req.Request)
+}
+
+// GetJob handles the GetJob gRPC method. Jobs of other API keys or tenants
+// are reported as not found.
+func (h *Handler) GetJob(ctx context.Context, req *genaidemo.GetJobRequest) (*genaidemo.Job, error) {
+	if req.JobId == "" {
+		return nil, status.Error(codes.InvalidArgument, "job id cannot be empty")
+	}
+
+	job, ok := h.jobs.get(req.JobId, apiKeyIDFromContext(ctx), tenantID(ctx))
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.JobId)
+	}
+	return job, nil
+}
+
+// chatWithMode dispatches a chat request to the handler method of the mode
+func (h *Handler) chatWithMode(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	switch mode {
+	case genaidemo.Mode_MODE_CHAT:
+		return h.Chat(ctx, req)
+	case genaidemo.Mode_MODE_TOOL:
+		return h.ChatWithTool(ctx, req)
+	case genaidemo.Mode_MODE_AGENT:
+		return h.ChatWithAgent(ctx, req)
+	case genaidemo.Mode_MODE_DOC:
+		return h.ChatWithDoc(ctx, req)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported mode: %s", mode)
+	}
+}
+
+// requireAdmin fails with PermissionDenied unless the caller's API key is an
+// admin key (ADMIN_API_KEYS)
+func (h *Handler) requireAdmin(ctx context.Context) error {
+	if keyID := apiKeyIDFromContext(ctx); !h.adminKeys[keyID] {
+		return status.Errorf(codes.PermissionDenied, "%s is not an admin API key", keyID)
+	}
+	return nil
+}
+
+// CreatePromptTemplate handles the CreatePromptTemplate gRPC method.
+// Templates are rendered for every tenant's requests, experiments and
+// rollouts, so changing them requires an admin API key.
+func (h *Handler) CreatePromptTemplate(ctx context.Context, req *genaidemo.PromptTemplate) (*genaidemo.PromptTemplate, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return h.templates.create(req)
+}
+
+// GetPromptTemplate handles the GetPromptTemplate gRPC method
+func (h *Handler) GetPromptTemplate(ctx context.Context, req *genaidemo.GetPromptTemplateRequest) (*genaidemo.PromptTemplate, error) {
+	return h.templates.get(req.Name, req.Version)
+}
+
+// ListPromptTemplates handles the ListPromptTemplates gRPC method
+func (h *Handler) ListPromptTemplates(ctx context.Context, req *genaidemo.ListPromptTemplatesRequest) (*genaidemo.ListPromptTemplatesResponse, error) {
+	return &genaidemo.ListPromptTemplatesResponse{
+		Templates: h.templates.list(),
+	}, nil
+}
+
+// UpdatePromptTemplate handles the UpdatePromptTemplate gRPC method, with an
+// admin API key
+func (h *Handler) UpdatePromptTemplate(ctx context.Context, req *genaidemo.PromptTemplate) (*genaidemo.PromptTemplate, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return h.templates.update(req)
+}
+
+// DeletePromptTemplate handles the DeletePromptTemplate gRPC method, with an
+// admin API key
+func (h *Handler) DeletePromptTemplate(ctx context.Context, req *genaidemo.DeletePromptTemplateRequest) (*genaidemo.DeletePromptTemplateResponse, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := h.templates.delete(req.Name); err != nil {
+		return nil, err
+	}
+	return &genaidemo.DeletePromptTemplateResponse{}, nil
+}
+
+// ListPromptTemplateVersions handles the ListPromptTemplateVersions gRPC method
+func (h *Handler) ListPromptTemplateVersions(ctx context.Context, req *genaidemo.ListPromptTemplateVersionsRequest) (*genaidemo.ListPromptTemplatesResponse, error) {
+	versions, err := h.templates.versions(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &genaidemo.ListPromptTemplatesResponse{Templates: versions}, nil
+}
+
+// ActivatePromptTemplateVersion handles the ActivatePromptTemplateVersion
+// gRPC method, with an admin API key
+func (h *Handler) ActivatePromptTemplateVersion(ctx context.Context, req *genaidemo.ActivatePromptTemplateVersionRequest) (*genaidemo.PromptTemplate, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return h.templates.activate(req.Name, req.Version)
+}
+
+// CreateFewShotExample handles the CreateFewShotExample gRPC method.
+// Examples are injected into every tenant's requests, so changing them
+// requires an admin API key.
+func (h *Handler) CreateFewShotExample(ctx context.Context, req *genaidemo.FewShotExample) (*genaidemo.FewShotExample, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return h.examples.create(req)
+}
+
+// GetFewShotExample handles the GetFewShotExample gRPC method
+func (h *Handler) GetFewShotExample(ctx context.Context, req *genaidemo.GetFewShotExampleRequest) (*genaidemo.FewShotExample, error) {
+	return h.examples.get(req.Id)
+}
+
+// ListFewShotExamples handles the ListFewShotExamples gRPC method
+func (h *Handler) ListFewShotExamples(ctx context.Context, req *genaidemo.ListFewShotExamplesRequest) (*genaidemo.ListFewShotExamplesResponse, error) {
+	return &genaidemo.ListFewShotExamplesResponse{
+		Examples: h.examples.list(req.Mode, req.Persona),
+	}, nil
+}
+
+// UpdateFewShotExample handles the UpdateFewShotExample gRPC method, with an
+// admin API key
+func (h *Handler) UpdateFewShotExample(ctx context.Context, req *genaidemo.FewShotExample) (*genaidemo.FewShotExample, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return h.examples.update(req)
+}
+
+// DeleteFewShotExample handles the DeleteFewShotExample gRPC method, with an
+// admin API key
+func (h *Handler) DeleteFewShotExample(ctx context.Context, req *genaidemo.DeleteFewShotExampleRequest) (*genaidemo.DeleteFewShotExampleResponse, error) {
+	if err := h.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := h.examples.delete(req.Id); err != nil {
+		return nil, err
+	}
+	return &genaidemo.DeleteFewShotExampleResponse{}, nil
+}
+
+// insertAfterSystem returns messages with msgs inserted after the leading
+// system messages, leaving messages itself unchanged
+func insertAfterSystem(messages []*genaidemo.Message, msgs ...*genaidemo.Message) []*genaidemo.Message {
+	i := 0
+	for i < len(messages) && messages[i].Role == genaidemo.Role_ROLE_SYSTEM {
+		i++
+	}
+	return slices.Concat(messages[:i], msgs, messages[i:])
+}
+
+// applyExamples injects the few-shot examples of the endpoint and persona
+// after the leading system messages, unless the request opted out
+func (h *Handler) applyExamples(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) {
+	if req.FewShot != nil && !*req.FewShot {
+		return
+	}
+	examples := h.examples.messages(mode, req.GetPersona(), modelFromContext(ctx, h.model))
+	if len(examples) == 0 {
+		return
+	}
+
+	req.Messages = insertAfterSystem(req.Messages, examples...)
+	// Opt out afterwards so retried or re-dispatched requests don't inject twice
+	fewShot := false
+	req.FewShot = &fewShot
+	log.Printf("🎯 [%s] Injected %d few-shot examples", mode, len(examples)/2)
+}
+
+// applyMemory injects what the service remembers about the user named by the
+// request after the leading system messages, and returns the user's memory
+// owner key and whether the reply should update the memory. Requests that
+// don't name a user, or opted out, neither use nor update it.
+func (h *Handler) applyMemory(ctx context.Context, req *genaidemo.ChatRequest) (string, bool) {
+	if !h.memory.enabled() || (req.Memory != nil && !*req.Memory) {
+		return "", false
+	}
+	owner, ok := memoryOwner(ctx)
+	if !ok {
+		return "", false
+	}
+
+	var query string
+	if last := req.Messages[len(req.Messages)-1]; last.Role == genaidemo.Role_ROLE_USER {
+		query = last.Content
+	}
+	if prompt := h.memory.prompt(owner, query, modelFromContext(ctx, h.model)); prompt != "" {
+		memory := &genaidemo.Message{Role: genaidemo.Role_ROLE_SYSTEM, Content: prompt}
+		req.Messages = insertAfterSystem(req.Messages, memory)
+		log.Printf("🧠 Injected the memory of user %s", userIDFromContext(ctx))
+	}
+	// Opt out afterwards so retried or re-dispatched requests don't inject twice
+	useMemory := false
+	req.Memory = &useMemory
+	return owner, true
+}
+
+// rememberFacts extracts facts about the user from the latest message of a
+// conversation in the background and merges them into the user's memory.
+// The extraction call counts towards the caller's usage.
+func (h *Handler) rememberFacts(ctx context.Context, owner string, messages []*genaidemo.Message) {
+	h.memory.pending.Add(1)
+	go func() {
+		defer h.memory.pending.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultMemoryExtractionTimeout)
+		defer cancel()
+
+		userMemoryMetrics.Add("extractions", 1)
+		facts, usage, err := h.service.ExtractUserFacts(ctx, messages, h.memory.known(owner))
+		if err != nil {
+			userMemoryMetrics.Add("errors", 1)
+			log.Printf("⚠️ [memory] Failed to extract facts of user %s: %v", userIDFromContext(ctx), err)
+			return
 		}
-		if msg.Role == genaidemo.Role_ROLE_UNKNOWN {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid message role at index %d", i)
+		h.recordLLMUsage(ctx, modelFromContext(ctx, h.model), usage)
+		changed, err := h.memory.remember(owner, facts)
+		if err != nil {
+			log.Printf("⚠️ [memory] Failed to remember facts of user %s: %v", userIDFromContext(ctx), err)
+			return
+		}
+		if changed > 0 {
+			log.Printf("🧠 [memory] Learned %d facts about user %s", changed, userIDFromContext(ctx))
 		}
+	}()
+}
+
+// GetUserMemory handles the GetUserMemory gRPC method
+func (h *Handler) GetUserMemory(ctx context.Context, req *genaidemo.GetUserMemoryRequest) (*genaidemo.UserMemory, error) {
+	owner, ok := memoryOwner(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "%s must name the user", userIDHeader)
 	}
+	return &genaidemo.UserMemory{
+		UserId: userIDFromContext(ctx),
+		Facts:  h.memory.list(owner),
+	}, nil
+}
 
-	result, err := h.service.ChatWithTool(ctx, req.Messages, req.Temperature, req.MaxTokens)
+// DeleteUserMemory handles the DeleteUserMemory gRPC method
+func (h *Handler) DeleteUserMemory(ctx context.Context, req *genaidemo.DeleteUserMemoryRequest) (*genaidemo.DeleteUserMemoryResponse, error) {
+	owner, ok := memoryOwner(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "%s must name the user", userIDHeader)
+	}
+	deleted, err := h.memory.forget(owner, req.FactId)
 	if err != nil {
 		return nil, err
 	}
+	log.Printf("🧠 Deleted %d facts of user %s", deleted, userIDFromContext(ctx))
+	return &genaidemo.DeleteUserMemoryResponse{Deleted: int32(deleted)}, nil
+}
 
-	response := &genaidemo.ChatResponse{
-		Content: result.Content,
+// applySessionEntities injects the summary of the entities of the session
+// named by the request after the leading system messages, so referents
+// survive when earlier messages are dropped, and returns the session key and
+// whether the reply should update its entities
+func (h *Handler) applySessionEntities(ctx context.Context, req *genaidemo.ChatRequest) (string, bool) {
+	if !h.sessions.enabled() || req.GetSessionId() == "" {
+		return "", false
 	}
+	key := sessionKey(ctx, req.GetSessionId())
 
-	if result.TokenUsage != nil {
-		response.TokenUsage = &genaidemo.TokenUsage{
-			InputTokenNum:  result.TokenUsage.InputTokens,
-			OutputTokenNum: result.TokenUsage.OutputTokens,
-			TotalTokenNum:  result.TokenUsage.TotalTokens,
+	if prompt := h.sessions.prompt(key, modelFromContext(ctx, h.model)); prompt != "" {
+		summary := &genaidemo.Message{Role: genaidemo.Role_ROLE_SYSTEM, Content: prompt}
+		req.Messages = insertAfterSystem(req.Messages, summary)
+		sessionEntityMetrics.Add("summaries_injected", 1)
+		log.Printf("🏷️ Injected the entities of session %s", req.GetSessionId())
+	}
+	// Drop the session afterwards so retried or re-dispatched requests don't
+	// inject twice
+	req.SessionId = nil
+	return key, true
+}
+
+// trackEntities extracts the entities of the latest exchange of a session in
+// the background and merges them into the session's entities. The
+// extraction call counts towards the caller's usage.
+func (h *Handler) trackEntities(ctx context.Context, key string, messages []*genaidemo.Message, reply string) {
+	h.sessions.pending.Add(1)
+	go func() {
+		defer h.sessions.pending.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultMemoryExtractionTimeout)
+		defer cancel()
+
+		sessionEntityMetrics.Add("extractions", 1)
+		entities, usage, err := h.service.ExtractSessionEntities(ctx, messages, reply, h.sessions.known(key))
+		if err != nil {
+			sessionEntityMetrics.Add("errors", 1)
+			log.Printf("⚠️ [entities] Failed to extract the entities of a session: %v", err)
+			return
+		}
+		h.recordLLMUsage(ctx, modelFromContext(ctx, h.model), usage)
+		if changed := h.sessions.update(key, entities); changed > 0 {
+			log.Printf("🏷️ [entities] Updated %d entities of a session", changed)
+		}
+	}()
+}
+
+// recordSession adds an exchange to the caller's session history. After the
+// first exchange of a session, its title is generated in the background with
+// the title model; the call counts towards the caller's usage.
+func (h *Handler) recordSession(ctx context.Context, id string, messages []*genaidemo.Message, reply string) {
+	var query string
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == genaidemo.Role_ROLE_USER {
+			query = messages[i].Content
+			break
 		}
 	}
+	owner := sessionOwner(ctx)
+	needsTitle, err := h.history.touch(owner, id, query)
+	if err != nil {
+		log.Printf("⚠️ [sessions] Failed to save session %s: %v", id, err)
+	}
+	if !needsTitle {
+		return
+	}
 
-	return response, nil
+	h.history.pending.Add(1)
+	go func() {
+		defer h.history.pending.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(withModelOverride(ctx, h.history.titleModel)), DefaultMemoryExtractionTimeout)
+		defer cancel()
+
+		title, usage, err := h.service.GenerateTitle(ctx, query, reply)
+		if err != nil {
+			sessionHistoryMetrics.Add("errors", 1)
+			log.Printf("⚠️ [sessions] Failed to generate the title of session %s: %v", id, err)
+		}
+		h.recordLLMUsage(ctx, h.history.titleModel, usage)
+		if err := h.history.setTitle(owner, id, title); err != nil {
+			log.Printf("⚠️ [sessions] Failed to save the title of session %s: %v", id, err)
+		}
+	}()
 }
 
-// ChatWithAgent handles the ChatWithAgent gRPC method
-func (h *Handler) ChatWithAgent(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
-	if len(req.Messages) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "messages cannot be empty")
+// ListSessions handles the ListSessions gRPC method
+func (h *Handler) ListSessions(ctx context.Context, req *genaidemo.ListSessionsRequest) (*genaidemo.ListSessionsResponse, error) {
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit cannot be negative")
 	}
+	return &genaidemo.ListSessionsResponse{
+		Sessions: h.history.list(sessionOwner(ctx), int(req.Limit)),
+	}, nil
+}
 
-	// Validate messages
-	for i, msg := range req.Messages {
-		if msg.Content == "" {
-			return nil, status.Errorf(codes.InvalidArgument, "message content cannot be empty at index %d", i)
+// usesGraph reports whether a request uses and updates the knowledge graph
+func usesGraph(graph *knowledgeGraph, req *genaidemo.ChatRequest) bool {
+	return graph.enabled() && (req.KnowledgeGraph == nil || *req.KnowledgeGraph)
+}
+
+// learnGraph extracts the entities and relations of a user message or
+// document text in the background and merges them into the knowledge graph
+// of the caller's tenant. The extraction call counts towards the caller's
+// usage.
+func (h *Handler) learnGraph(ctx context.Context, source, text string) {
+	h.graph.pending.Add(1)
+	go func() {
+		defer h.graph.pending.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultGraphExtractionTimeout)
+		defer cancel()
+
+		knowledgeGraphMetrics.Add("extractions", 1)
+		extraction, usage, err := h.service.ExtractGraph(ctx, text)
+		if err != nil {
+			knowledgeGraphMetrics.Add("errors", 1)
+			log.Printf("⚠️ [graph] Failed to extract relations from %s: %v", source, err)
+			return
 		}
-		if msg.Role == genaidemo.Role_ROLE_UNKNOWN {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid message role at index %d", i)
+		h.recordLLMUsage(ctx, modelFromContext(ctx, h.model), usage)
+		learned, err := h.graph.add(tenantID(ctx), extraction, source)
+		if err != nil {
+			log.Printf("⚠️ [graph] Failed to add relations from %s: %v", source, err)
+			return
+		}
+		if learned > 0 {
+			log.Printf("🕸️ [graph] Learned %d relations from %s", learned, source)
 		}
+	}()
+}
+
+// GetGraphEntity handles the GetGraphEntity gRPC method
+func (h *Handler) GetGraphEntity(ctx context.Context, req *genaidemo.GetGraphEntityRequest) (*genaidemo.GraphEntity, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity name cannot be empty")
+	}
+	return h.graph.entity(tenantID(ctx), req.Name)
+}
+
+// DeleteGraphEntity handles the DeleteGraphEntity gRPC method
+func (h *Handler) DeleteGraphEntity(ctx context.Context, req *genaidemo.DeleteGraphEntityRequest) (*genaidemo.DeleteGraphEntityResponse, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, status.Error(codes.InvalidArgument, "entity name cannot be empty")
+	}
+	deleted, err := h.graph.forget(tenantID(ctx), req.Name)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🕸️ Deleted entity %q with %d relations", req.Name, deleted)
+	return &genaidemo.DeleteGraphEntityResponse{DeletedRelations: int32(deleted)}, nil
+}
+
+// applyTemplate renders the requested prompt template into the conversation
+// and returns the template version used, if any. System templates are
+// prepended, user templates appended.
+func (h *Handler) applyTemplate(req *genaidemo.ChatRequest) (*genaidemo.PromptTemplateRef, error) {
+	if req.GetTemplate() == "" {
+		return nil, nil
+	}
+
+	msg, ref, err := h.templates.render(req.GetTemplate(), req.GetTemplateVersion(), req.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Role == genaidemo.Role_ROLE_SYSTEM {
+		req.Messages = append([]*genaidemo.Message{msg}, req.Messages...)
+	} else {
+		req.Messages = append(req.Messages, msg)
+	}
+	// Clear the template so retried or re-dispatched requests don't render twice
+	req.Template = nil
+	req.TemplateVersion = nil
+	return ref, nil
+}
+
+// GetUsage handles the GetUsage gRPC method
+func (h *Handler) GetUsage(ctx context.Context, req *genaidemo.GetUsageRequest) (*genaidemo.GetUsageResponse, error) {
+	var from, to time.Time
+	if req.StartTime > 0 {
+		from = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime > 0 {
+		to = time.Unix(req.EndTime, 0)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, status.Error(codes.InvalidArgument, "start time must be before end time")
+	}
+
+	// Usage reveals other keys' traffic and spend, so only admin keys see
+	// every key; others see their own key and their tenant
+	keyID := req.KeyId
+	if caller := apiKeyIDFromContext(ctx); !h.adminKeys[caller] {
+		t := tenantFromContext(ctx)
+		switch {
+		case keyID == "":
+			keyID = caller
+		case keyID != caller && (t == nil || keyID != t.usageKey()):
+			return nil, status.Errorf(codes.PermissionDenied, "%s may only read its own usage", caller)
+		}
+	}
+
+	records := h.usage.query(keyID, from, to)
+	for _, record := range records {
+		if budget, remaining, ok := h.budgets.remaining(record.KeyId); ok {
+			record.MonthlyBudget = &budget
+			record.BudgetRemaining = &remaining
+		}
+	}
+
+	return &genaidemo.GetUsageResponse{
+		Records:  records,
+		Currency: llm.PricingCurrency,
+	}, nil
+}
+
+// GetConversationAnalytics handles the GetConversationAnalytics gRPC method
+func (h *Handler) GetConversationAnalytics(ctx context.Context, req *genaidemo.GetConversationAnalyticsRequest) (*genaidemo.ConversationAnalytics, error) {
+	if !h.analytics.enabled() {
+		return nil, status.Error(codes.Unavailable, "conversation analytics is not configured")
+	}
+	var from, to time.Time
+	if req.StartTime > 0 {
+		from = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime > 0 {
+		to = time.Unix(req.EndTime, 0)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, status.Error(codes.InvalidArgument, "start time must be before end time")
+	}
+	return h.analytics.aggregate(tenantID(ctx), from, to), nil
+}
+
+// SubmitFeedback handles the SubmitFeedback gRPC method
+func (h *Handler) SubmitFeedback(ctx context.Context, req *genaidemo.SubmitFeedbackRequest) (*genaidemo.Feedback, error) {
+	if !h.feedback.enabled() {
+		return nil, status.Error(codes.Unavailable, "feedback is not configured")
+	}
+	if err := validateFeedback(req); err != nil {
+		return nil, err
+	}
+	feedback, previous, err := h.feedback.submit(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Feedback replaced by the caller no longer counts for the variant
+	if previous != nil {
+		h.experiments.recordFeedback(previous, -1)
+		h.rollouts.recordFeedback(previous, -1)
+	}
+	h.experiments.recordFeedback(feedback, 1)
+	h.rollouts.recordFeedback(feedback, 1)
+	log.Printf("👍 [%s] Feedback on request %s: thumb=%q rating=%d", feedback.Mode, feedback.RequestID, feedback.Thumb, feedback.Rating)
+	return feedback.toProto(), nil
+}
+
+// GetFeedbackSummary handles the GetFeedbackSummary gRPC method
+func (h *Handler) GetFeedbackSummary(ctx context.Context, req *genaidemo.GetFeedbackSummaryRequest) (*genaidemo.FeedbackSummary, error) {
+	if !h.feedback.enabled() {
+		return nil, status.Error(codes.Unavailable, "feedback is not configured")
+	}
+	var from, to time.Time
+	if req.StartTime > 0 {
+		from = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime > 0 {
+		to = time.Unix(req.EndTime, 0)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, status.Error(codes.InvalidArgument, "start time must be before end time")
+	}
+	return h.feedback.summarize(tenantID(ctx), from, to), nil
+}
+
+// Transcribe handles the Transcribe gRPC method
+func (h *Handler) Transcribe(ctx context.Context, req *genaidemo.TranscribeRequest) (*genaidemo.TranscribeResponse, error) {
+	if req.Audio == nil || len(req.Audio.Data) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "audio cannot be empty")
+	}
+	if len(req.Audio.Data) > maxAudioBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "audio exceeds %d bytes", maxAudioBytes)
+	}
+
+	text, err := h.service.Transcribe(ctx, req.Audio.Data, req.Audio.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genaidemo.TranscribeResponse{
+		Text: text,
+	}, nil
+}
+
+// Synthesize handles the Synthesize gRPC method
+func (h *Handler) Synthesize(ctx context.Context, req *genaidemo.SynthesizeRequest) (*genaidemo.SynthesizeResponse, error) {
+	if req.Text == "" {
+		return nil, status.Error(codes.InvalidArgument, "text cannot be empty")
 	}
 
-	result, err := h.service.ChatWithAgent(ctx, req.Messages, req.Temperature, req.MaxTokens)
+	data, mimeType, err := h.service.Synthesize(ctx, req.Text)
 	if err != nil {
 		return nil, err
 	}
 
+	return &genaidemo.SynthesizeResponse{
+		Audio: &genaidemo.Audio{
+			Data:     data,
+			MimeType: mimeType,
+		},
+	}, nil
+}
+
+// buildReply builds the response to a request served by model, keeping the
+// result for the v2 API
+func (h *Handler) buildReply(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest, model string, result *ChatResult, cached bool) (*chatReply, error) {
+	response, err := h.buildResponse(ctx, mode, req, result)
+	if err != nil {
+		return nil, err
+	}
+	return &chatReply{response: response, result: result, model: model, cached: cached}, nil
+}
+
+// buildResponse converts a service result into the gRPC response, synthesizing
+// the reply into audio when requested. v1 responses carry tool results in the
+// content.
+func (h *Handler) buildResponse(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest, result *ChatResult) (*genaidemo.ChatResponse, error) {
 	response := &genaidemo.ChatResponse{
-		Content: result.Content,
+		Content:  contentWithToolResults(result.Content, result.ToolInvocations),
+		Sources:  result.Sources,
+		Language: req.GetResponseLanguage(),
+		Mode:     mode,
+		Warnings: result.Warnings,
+
+		Candidates: result.Candidates,
+		Seed:       req.Seed,
+
+		Model:        modelFromContext(ctx, h.model),
+		ModelVersion: result.ModelVersion,
+		FinishReason: result.FinishReason,
+		SafetyBlock:  result.SafetyBlock,
+	}
+	if result.DegradedReason != "" {
+		response.DegradedReason = &result.DegradedReason
 	}
 
 	if result.TokenUsage != nil {
 		response.TokenUsage = &genaidemo.TokenUsage{
-			InputTokenNum:  result.TokenUsage.InputTokens,
-			OutputTokenNum: result.TokenUsage.OutputTokens,
-			TotalTokenNum:  result.TokenUsage.TotalTokens,
+			InputTokenNum:   result.TokenUsage.InputTokens,
+			OutputTokenNum:  result.TokenUsage.OutputTokens,
+			TotalTokenNum:   result.TokenUsage.TotalTokens,
+			ContextTokenNum: result.TokenUsage.ContextTokens,
+			CachedTokenNum:  result.TokenUsage.CachedTokens,
+		}
+		usage := result.TokenUsage
+		if cost, _, ok := llm.EstimateCostWithCache(modelFromContext(ctx, h.model), int64(usage.InputTokens), int64(usage.CachedTokens), int64(usage.OutputTokens)); ok {
+			response.EstimatedCost = &genaidemo.Cost{
+				Currency: llm.PricingCurrency,
+				Amount:   cost,
+			}
+		}
+	}
+
+	if req.GetAudioResponse() {
+		data, mimeType, err := h.service.Synthesize(ctx, response.Content)
+		if err != nil {
+			return nil, err
+		}
+		response.Audio = &genaidemo.Audio{
+			Data:     data,
+			MimeType: mimeType,
 		}
 	}
 
 	return response, nil
 }
 
-// ChatWithDoc handles the ChatWithDoc gRPC method
-func (h *Handler) ChatWithDoc(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
-	if len(req.Messages) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "messages cannot be empty")
-	}
+// prepareMessages transcribes audio attachments into the message content and
+// validates the resulting messages
+func (h *Handler) prepareMessages(ctx context.Context, messages []*genaidemo.Message) error {
+	for i, msg := range messages {
+		if msg.Audio != nil && len(msg.Audio.Data) > maxAudioBytes {
+			return status.Errorf(codes.InvalidArgument, "audio of message %d exceeds %d bytes", i, maxAudioBytes)
+		}
+		if msg.Audio != nil && len(msg.Audio.Data) > 0 {
+			text, err := h.service.Transcribe(ctx, msg.Audio.Data, msg.Audio.MimeType)
+			if err != nil {
+				return err
+			}
+			if msg.Content != "" {
+				text = msg.Content + "\n\n" + text
+			}
+			msg.Content = text
+			msg.Audio = nil
+		}
 
-	// Validate messages
-	for i, msg := range req.Messages {
 		if msg.Content == "" {
-			return nil, status.Errorf(codes.InvalidArgument, "message content cannot be empty at index %d", i)
+			return status.Errorf(codes.InvalidArgument, "message content cannot be empty at index %d", i)
 		}
 		if msg.Role == genaidemo.Role_ROLE_UNKNOWN {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid message role at index %d", i)
+			return status.Errorf(codes.InvalidArgument, "invalid message role at index %d", i)
+		}
+	}
+	return nil
+}
+
+// Close all resources created by the handler
+func (h *Handler) Close() error {
+	h.jobs.close()
+	h.memory.close()
+	h.sessions.close()
+	h.history.close()
+	h.analytics.close()
+	h.feedback.close()
+	h.exports.close()
+	h.uploads.close()
+	h.graph.close()
+	h.webhooks.close()
+	h.events.close()
+	h.redis.close()
+	return h.service.Close()
+}
+
+// summaryLengths maps the summary lengths of requests to the summarizer's
+var summaryLengths = map[genaidemo.SummaryLength]llm.SummaryLength{
+	genaidemo.SummaryLength_SUMMARY_LENGTH_UNSPECIFIED: llm.SummaryMedium,
+	genaidemo.SummaryLength_SUMMARY_LENGTH_SHORT:       llm.SummaryShort,
+	genaidemo.SummaryLength_SUMMARY_LENGTH_MEDIUM:      llm.SummaryMedium,
+	genaidemo.SummaryLength_SUMMARY_LENGTH_LONG:        llm.SummaryLong,
+}
+
+// summaryStyles maps the summary styles of requests to the summarizer's
+var summaryStyles = map[genaidemo.SummaryStyle]llm.SummaryStyle{
+	genaidemo.SummaryStyle_SUMMARY_STYLE_UNSPECIFIED: llm.SummaryParagraph,
+	genaidemo.SummaryStyle_SUMMARY_STYLE_PARAGRAPH:   llm.SummaryParagraph,
+	genaidemo.SummaryStyle_SUMMARY_STYLE_BULLETS:     llm.SummaryBullets,
+	genaidemo.SummaryStyle_SUMMARY_STYLE_EXECUTIVE:   llm.SummaryExecutive,
+}
+
+// Summarize handles the Summarize gRPC method
+func (h *Handler) Summarize(ctx context.Context, req *genaidemo.SummarizeRequest) (*genaidemo.SummarizeResponse, error) {
+	if (req.Text == "") == (req.DocumentId == "") {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of text and document_id must be set")
+	}
+	if len(req.Text) > MaxSummarizeTextLength {
+		return nil, status.Errorf(codes.InvalidArgument, "text exceeds %d bytes", MaxSummarizeTextLength)
+	}
+	length, ok := summaryLengths[req.Length]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown summary length")
+	}
+	style, ok := summaryStyles[req.Style]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown summary style")
+	}
+	if err := h.tenants.checkCollection(tenantFromContext(ctx), req.Collection); err != nil {
+		return nil, err
+	}
+
+	model, err := h.budgetModel(ctx, cmp.Or(req.GetModel(), h.summaryModel))
+	if err != nil {
+		return nil, err
+	}
+	if model != h.model {
+		ctx = withModelOverride(ctx, model)
+	}
+	if _, err := h.models.adapt(ctx, genaidemo.Mode_MODE_UNKNOWN, model); err != nil {
+		return nil, err
+	}
+
+	texts := []string{req.Text}
+	if req.DocumentId != "" {
+		chunks, err := h.service.DocumentChunks(ctx, ChromaDBDocumentRequest{Source: req.DocumentId, Collection: req.Collection})
+		if err != nil {
+			return nil, err
 		}
+		texts = chunks
 	}
 
-	result, err := h.service.ChatWithDoc(ctx, req.Messages, req.Temperature, req.MaxTokens)
+	// Summary calls are provider calls too, one slot covers all the parts
+	release, err := h.limiter.acquire(ctx, h.requestPriority(ctx, &genaidemo.ChatRequest{}))
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	response := &genaidemo.ChatResponse{
-		Content: result.Content,
+	limit := h.inputTokenLimit
+	if limit <= 0 {
+		limit = h.models.contextWindow(model)
+	}
+	result, err := h.service.Summarize(ctx, texts, llm.SummaryOptions{Length: length, Style: style, MaxInputTokens: limit})
+	if err != nil {
+		return nil, err
 	}
 
-	if result.TokenUsage != nil {
+	response := &genaidemo.SummarizeResponse{
+		Summary: result.Summary,
+		Model:   model,
+		Parts:   int32(result.Parts),
+		Calls:   int32(result.Calls),
+	}
+	if usage := result.Usage; usage != nil {
+		h.recordLLMUsage(ctx, model, usage)
 		response.TokenUsage = &genaidemo.TokenUsage{
-			InputTokenNum:  result.TokenUsage.InputTokens,
-			OutputTokenNum: result.TokenUsage.OutputTokens,
-			TotalTokenNum:  result.TokenUsage.TotalTokens,
+			InputTokenNum:  usage.InputTokens,
+			OutputTokenNum: usage.OutputTokens,
+			TotalTokenNum:  usage.TotalTokens,
+		}
+		if cost, ok := llm.EstimateCost(model, int64(usage.InputTokens), int64(usage.OutputTokens)); ok {
+			response.EstimatedCost = &genaidemo.Cost{
+				Currency: llm.PricingCurrency,
+				Amount:   cost,
+			}
 		}
 	}
+	return response, nil
+}
+
+// Classify handles the Classify gRPC method
+func (h *Handler) Classify(ctx context.Context, req *genaidemo.ClassifyRequest) (*genaidemo.ClassifyResponse, error) {
+	labels, err := validateClassify(req)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := h.budgetModel(ctx, cmp.Or(req.GetModel(), h.classifyModel))
+	if err != nil {
+		return nil, err
+	}
+	if model != h.model {
+		ctx = withModelOverride(ctx, model)
+	}
+	if _, err := h.models.adapt(ctx, genaidemo.Mode_MODE_UNKNOWN, model); err != nil {
+		return nil, err
+	}
+
+	// Classification calls are provider calls too
+	release, err := h.limiter.acquire(ctx, h.requestPriority(ctx, &genaidemo.ChatRequest{}))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	classification, usage, err := h.service.ClassifyText(ctx, req.Text, labels)
+	if err != nil {
+		return nil, err
+	}
 
+	response := &genaidemo.ClassifyResponse{
+		Label:      classification.Label,
+		Confidence: classification.Confidence,
+		Model:      model,
+	}
+	if usage != nil {
+		h.recordLLMUsage(ctx, model, usage)
+		response.TokenUsage = &genaidemo.TokenUsage{
+			InputTokenNum:  usage.InputTokens,
+			OutputTokenNum: usage.OutputTokens,
+			TotalTokenNum:  usage.TotalTokens,
+		}
+		if cost, ok := llm.EstimateCost(model, int64(usage.InputTokens), int64(usage.OutputTokens)); ok {
+			response.EstimatedCost = &genaidemo.Cost{
+				Currency: llm.PricingCurrency,
+				Amount:   cost,
+			}
+		}
+	}
 	return response, nil
 }
 
-// Close all resources created by the handler
-func (h *Handler) Close() error {
-	return h.service.Close()
+// validateClassify checks the text and labels of a classification request
+// and converts the labels for the classifier
+func validateClassify(req *genaidemo.ClassifyRequest) ([]llm.ClassLabel, error) {
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, status.Error(codes.InvalidArgument, "text cannot be empty")
+	}
+	if len(req.Text) > MaxClassifyTextLength {
+		return nil, status.Errorf(codes.InvalidArgument, "text exceeds %d bytes", MaxClassifyTextLength)
+	}
+	if len(req.Labels) < 2 || len(req.Labels) > MaxClassifyLabels {
+		return nil, status.Errorf(codes.InvalidArgument, "between 2 and %d labels are required", MaxClassifyLabels)
+	}
+
+	labels := make([]llm.ClassLabel, len(req.Labels))
+	seen := make(map[string]bool, len(req.Labels))
+	for i, l := range req.Labels {
+		name := strings.TrimSpace(l.Name)
+		if name == "" {
+			return nil, status.Error(codes.InvalidArgument, "label names cannot be empty")
+		}
+		key := strings.ToLower(name)
+		if seen[key] {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate label %q", name)
+		}
+		seen[key] = true
+		if len(l.Examples) > MaxClassifyExamples {
+			return nil, status.Errorf(codes.InvalidArgument, "label %q has more than %d examples", name, MaxClassifyExamples)
+		}
+		labels[i] = llm.ClassLabel{Name: name, Description: l.Description, Examples: l.Examples}
+	}
+	return labels, nil
+}
+
+// EvaluateResponse handles the EvaluateResponse gRPC method
+func (h *Handler) EvaluateResponse(ctx context.Context, req *genaidemo.EvaluateResponseRequest) (*genaidemo.EvaluateResponseResponse, error) {
+	if len(req.Messages) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "messages cannot be empty")
+	}
+	if req.Response == "" {
+		return nil, status.Error(codes.InvalidArgument, "response cannot be empty")
+	}
+
+	judgeModel, err := h.budgetModel(ctx, cmp.Or(req.GetJudgeModel(), h.judgeModel))
+	if err != nil {
+		return nil, err
+	}
+	if judgeModel != h.model {
+		ctx = withModelOverride(ctx, judgeModel)
+	}
+	if _, err := h.models.adapt(ctx, genaidemo.Mode_MODE_UNKNOWN, judgeModel); err != nil {
+		return nil, err
+	}
+
+	// Judge calls are provider calls too
+	release, err := h.limiter.acquire(ctx, h.requestPriority(ctx, &genaidemo.ChatRequest{}))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	evaluation, err := h.service.Evaluate(ctx, req.Messages, req.Response, req.Documents, req.Criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &genaidemo.EvaluateResponseResponse{
+		Scores:       make([]*genaidemo.CriterionScore, len(evaluation.Scores)),
+		OverallScore: evaluation.OverallScore(),
+		JudgeModel:   judgeModel,
+	}
+	for i, s := range evaluation.Scores {
+		response.Scores[i] = &genaidemo.CriterionScore{
+			Criterion: s.Criterion,
+			Score:     int32(s.Score),
+			Reasoning: s.Reasoning,
+		}
+	}
+	if usage := evaluation.TokenUsage; usage != nil {
+		h.recordLLMUsage(ctx, judgeModel, usage)
+		response.TokenUsage = &genaidemo.TokenUsage{
+			InputTokenNum:  usage.InputTokens,
+			OutputTokenNum: usage.OutputTokens,
+			TotalTokenNum:  usage.TotalTokens,
+		}
+		if cost, ok := llm.EstimateCost(judgeModel, int64(usage.InputTokens), int64(usage.OutputTokens)); ok {
+			response.EstimatedCost = &genaidemo.Cost{
+				Currency: llm.PricingCurrency,
+				Amount:   cost,
+			}
+		}
+	}
+	return response, nil
 }
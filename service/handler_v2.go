@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// handlerV2 serves the ChatServiceV2 RPCs. Requests run through the same
+// pipeline as the v1 RPCs; only the response differs, reporting tool
+// invocations, timings, the model used, the finish reason and safety ratings
+// as fields instead of in the content.
+type handlerV2 struct {
+	genaidemo.UnimplementedChatServiceV2Server
+	handler *Handler
+}
+
+// Chat handles the v2 Chat gRPC method
+func (v *handlerV2) Chat(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponseV2, error) {
+	return v.chatWithMode(ctx, genaidemo.Mode_MODE_CHAT, req)
+}
+
+// ChatWithTool handles the v2 ChatWithTool gRPC method
+func (v *handlerV2) ChatWithTool(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponseV2, error) {
+	return v.chatWithMode(ctx, genaidemo.Mode_MODE_TOOL, req)
+}
+
+// ChatWithAgent handles the v2 ChatWithAgent gRPC method
+func (v *handlerV2) ChatWithAgent(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponseV2, error) {
+	return v.chatWithMode(ctx, genaidemo.Mode_MODE_AGENT, req)
+}
+
+// ChatWithDoc handles the v2 ChatWithDoc gRPC method
+func (v *handlerV2) ChatWithDoc(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponseV2, error) {
+	return v.chatWithMode(ctx, genaidemo.Mode_MODE_DOC, req)
+}
+
+// ChatAuto handles the v2 ChatAuto gRPC method, serving the request with the
+// mode the router picks for it
+func (v *handlerV2) ChatAuto(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponseV2, error) {
+	ctx, mode := v.handler.autoMode(ctx, req)
+	return v.chatWithMode(ctx, mode, req)
+}
+
+// chatWithMode runs a chat request with the service method of the mode
+func (v *handlerV2) chatWithMode(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponseV2, error) {
+	h := v.handler
+	var chat chatFunc
+	switch mode {
+	case genaidemo.Mode_MODE_CHAT:
+		chat = h.service.Chat
+	case genaidemo.Mode_MODE_TOOL:
+		chat = h.service.ChatWithTool
+	case genaidemo.Mode_MODE_AGENT:
+		chat = h.service.ChatWithAgent
+	case genaidemo.Mode_MODE_DOC:
+		chat = h.service.ChatWithDoc
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported mode: %s", mode)
+	}
+
+	start := time.Now()
+	reply, err := h.chat(ctx, mode, req, chat)
+	if err != nil {
+		return nil, err
+	}
+	return newChatResponseV2(reply, time.Since(start)), nil
+}
+
+// newChatResponseV2 builds the v2 response of a chat reply that took total.
+// The stage timings of cached replies are those of the original request.
+func newChatResponseV2(reply *chatReply, total time.Duration) *genaidemo.ChatResponseV2 {
+	response, result := reply.response, reply.result
+	var completions []*genaidemo.Completion
+	for _, completion := range result.Completions {
+		completions = append(completions, &genaidemo.Completion{
+			Content:      completion.Content,
+			TokenUsage:   toTokenUsage(completion.TokenUsage),
+			FinishReason: completion.FinishReason,
+			Logprobs:     completion.Logprobs,
+		})
+	}
+	return &genaidemo.ChatResponseV2{
+		Content:         result.Content,
+		Mode:            response.Mode,
+		Model:           reply.model,
+		FinishReason:    result.FinishReason,
+		Sources:         response.Sources,
+		ToolInvocations: result.ToolInvocations,
+		SafetyRatings:   result.SafetyRatings,
+		Timings: &genaidemo.Timings{
+			TotalMs:      total.Milliseconds(),
+			RetrievalMs:  result.Timings.Retrieval.Milliseconds(),
+			GenerationMs: result.Timings.Generation.Milliseconds(),
+			ToolMs:       result.Timings.Tools.Milliseconds(),
+		},
+		TokenUsage:     response.TokenUsage,
+		EstimatedCost:  response.EstimatedCost,
+		Audio:          response.Audio,
+		PromptTemplate: response.PromptTemplate,
+		Variant:        response.Variant,
+		Language:       response.Language,
+		DegradedReason: response.DegradedReason,
+		Cached:         reply.cached,
+		Rollout:        response.Rollout,
+		Warnings:       response.Warnings,
+		Candidates:     response.Candidates,
+		Seed:           response.Seed,
+		Completions:    completions,
+		Logprobs:       result.Logprobs,
+		ModelVersion:   result.ModelVersion,
+		SafetyBlock:    result.SafetyBlock,
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// testHarness runs the full Handler, HTTP API and gRPC server in-process against fakes
+// instead of GCP and ChromaDB: the mock LLM provider, an in-memory document
+// store speaking the ChromaDB query protocol, and a canned web search. It
+// shares the wiring of main, so the end-to-end tests in this package cover
+// the service as deployed.
+type testHarness struct {
+	handler *Handler
+	server  *httptest.Server
+	grpc    *grpc.Server
+	conn    *grpc.ClientConn
+	docs    *memoryDocumentStore
+	search  *fakeWebSearch
+	chroma  *httptest.Server
+}
+
+// newTestHarness starts a harness. The configuration starts from the
+// defaults with the mock provider and fast retries; configure functions may
+// adjust it further, e.g. to set MOCK_LLM_SCRIPT or budgets.
+func newTestHarness(configure ...func(cfg *serviceConfig)) (*testHarness, error) {
+	docs := newMemoryDocumentStore()
+	chroma := httptest.NewServer(docs)
+
+	cfg := defaultServiceConfig()
+	cfg.llmProvider = llmProviderMock
+	cfg.chromaURL = chroma.URL
+	cfg.retryInitialBackoff = time.Millisecond
+	cfg.retryMaxBackoff = 10 * time.Millisecond
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	svc, err := newService(context.Background(), cfg)
+	if err != nil {
+		chroma.Close()
+		return nil, fmt.Errorf("failed to create service: %w", err)
+	}
+	search := newFakeWebSearch()
+	svc.webSearch = search.search
+
+	handler, err := newHandler(svc, cfg)
+	if err != nil {
+		_ = svc.Close()
+		chroma.Close()
+		return nil, fmt.Errorf("failed to create handler: %w", err)
+	}
+	handler.ready.Store(true)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		_ = handler.Close()
+		chroma.Close()
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	grpcServer := newGRPCServer(handler, cfg)
+	go grpcServer.Serve(lis)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		grpcServer.Stop()
+		_ = handler.Close()
+		chroma.Close()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	return &testHarness{
+		handler: handler,
+		server:  httptest.NewServer(newHTTPMux(handler, cfg)),
+		grpc:    grpcServer,
+		conn:    conn,
+		docs:    docs,
+		search:  search,
+		chroma:  chroma,
+	}, nil
+}
+
+// client returns an SDK client talking to the harness HTTP API
+func (h *testHarness) client(opts ...client.Option) *client.Client {
+	return client.NewHTTP(h.server.URL, opts...)
+}
+
+// grpcClient returns an SDK client talking to the harness gRPC server
+func (h *testHarness) grpcClient(opts ...client.Option) *client.Client {
+	return client.NewGRPC(h.conn, opts...)
+}
+
+// Close stops the servers and the handler
+func (h *testHarness) Close() {
+	h.server.Close()
+	_ = h.conn.Close()
+	h.grpc.Stop()
+	_ = h.handler.Close()
+	h.chroma.Close()
+}
+
+// memoryDocumentStore is an in-memory document store serving the ChromaDB
+// query service endpoints (POST /query, POST /documents, GET /stats,
+// GET and PUT /collections/{name}, GET /collections/{name}/stats,
+// GET /collections/{name}/freshness).
+// Documents are ranked by how many query terms they contain, uploaded ones
+// are stored as text in a single chunk.
+type memoryDocumentStore struct {
+	mu   sync.RWMutex
+	docs []memoryDocument
+	// updatedAt is the Unix time documents were last stored
+	updatedAt int64
+}
+
+// memoryCollection is the name the store's single collection reports stats
+// under, the ChromaDB service's default
+const memoryCollection = "pdf_documents"
+
+type memoryDocument struct {
+	id       string
+	filename string
+	text     string
+}
+
+// matches reports whether the document metadata has the values of where
+func (d memoryDocument) matches(where map[string]string) bool {
+	for key, value := range where {
+		if key != "filename" || d.filename != value {
+			return false
+		}
+	}
+	return true
+}
+
+func newMemoryDocumentStore() *memoryDocumentStore {
+	return &memoryDocumentStore{}
+}
+
+// add stores a document under the given id and source filename
+func (s *memoryDocumentStore) add(id, filename, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = append(s.docs, memoryDocument{id: id, filename: filename, text: text})
+	s.updatedAt = time.Now().Unix()
+}
+
+// replace stores a document under the given id, replacing any with that id
+func (s *memoryDocumentStore) replace(id, filename, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = slices.DeleteFunc(s.docs, func(d memoryDocument) bool { return d.id == id })
+	s.docs = append(s.docs, memoryDocument{id: id, filename: filename, text: text})
+	s.updatedAt = time.Now().Unix()
+}
+
+func (s *memoryDocumentStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/query":
+		var req ChromaDBQueryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Collection != "" {
+			// Documents are only stored in the default collection
+			http.Error(w, "collection not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.query(req.Query, req.NResults, req.Where))
+	case r.Method == http.MethodPost && r.URL.Path == "/documents":
+		filename := r.URL.Query().Get("filename")
+		data, err := io.ReadAll(r.Body)
+		if err != nil || filename == "" || len(data) == 0 {
+			http.Error(w, "invalid document", http.StatusBadRequest)
+			return
+		}
+		source := "upload://" + filename
+		s.replace(source, filename, string(data))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&ChromaDBIngestResponse{Source: source, Chunks: 1})
+	case r.Method == http.MethodGet && r.URL.Path == "/stats":
+		s.mu.RLock()
+		count := len(s.docs)
+		s.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": count})
+	case (r.Method == http.MethodGet || r.Method == http.MethodPut) && r.URL.Path == "/collections/"+memoryCollection:
+		// Queries are matched as text, as with a local embedding model. The
+		// collection always exists, so PUT never creates it.
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&ChromaDBCollectionSpec{
+			Name:           memoryCollection,
+			EmbeddingModel: "all-MiniLM-L6-v2",
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/collections/"+memoryCollection+"/stats":
+		s.mu.RLock()
+		count := len(s.docs)
+		s.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&ChromaDBCollectionStats{
+			Collection:    memoryCollection,
+			DocumentCount: count,
+			ChunkCount:    count,
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/collections/"+memoryCollection+"/freshness":
+		s.mu.RLock()
+		updatedAt := s.updatedAt
+		s.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&ChromaDBCollectionFreshness{
+			Collection:    memoryCollection,
+			LastUpdatedAt: updatedAt,
+			Connectors:    []ChromaDBConnectorSync{},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// query returns up to n documents matching the metadata filter where, ordered
+// by the share of query terms they contain, reported as a distance in [0, 1]
+func (s *memoryDocumentStore) query(query string, n int, where map[string]string) *ChromaDBQueryResponse {
+	terms := strings.Fields(strings.ToLower(query))
+
+	type scored struct {
+		doc      memoryDocument
+		distance float64
+	}
+	s.mu.RLock()
+	results := make([]scored, 0, len(s.docs))
+	for _, doc := range s.docs {
+		if !doc.matches(where) {
+			continue
+		}
+		text := strings.ToLower(doc.text)
+		matches := 0
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				matches++
+			}
+		}
+		if matches > 0 {
+			results = append(results, scored{doc: doc, distance: 1 - float64(matches)/float64(len(terms))})
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortStableFunc(results, func(a, b scored) int { return cmp.Compare(a.distance, b.distance) })
+	if n > 0 && len(results) > n {
+		results = results[:n]
+	}
+
+	resp := &ChromaDBQueryResponse{}
+	for _, r := range results {
+		resp.Documents = append(resp.Documents, r.doc.text)
+		resp.Metadatas = append(resp.Metadatas, map[string]interface{}{"filename": r.doc.filename})
+		resp.Distances = append(resp.Distances, r.distance)
+		resp.IDs = append(resp.IDs, r.doc.id)
+	}
+	return resp
+}
+
+// fakeWebSearch is a canned search_web backend that records the queries it
+// receives
+type fakeWebSearch struct {
+	mu      sync.Mutex
+	results map[string]string
+	seen    []string
+}
+
+func newFakeWebSearch() *fakeWebSearch {
+	return &fakeWebSearch{results: make(map[string]string)}
+}
+
+// setResult sets the result returned for a query
+func (f *fakeWebSearch) setResult(query, result string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[query] = result
+}
+
+// queries returns the queries searched so far
+func (f *fakeWebSearch) queries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.seen)
+}
+
+func (f *fakeWebSearch) search(ctx context.Context, query string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seen = append(f.seen, query)
+	if result, ok := f.results[query]; ok {
+		return result, nil
+	}
+	return fmt.Sprintf("No results found for %q", query), nil
+}
+
+func TestHarnessChatWithDoc(t *testing.T) {
+	h, err := newTestHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	h.docs.add("doc-1", "handbook.pdf", "Employees get 25 vacation days per year.")
+	resp, err := h.client().ChatWithDoc(context.Background(), &genaidemo.ChatRequest{
+		Messages: []*genaidemo.Message{{Role: genaidemo.Role_ROLE_USER, Content: "How many vacation days do employees get?"}},
+	})
+	if err != nil {
+		t.Fatalf("ChatWithDoc: %v", err)
+	}
+	if resp.Content == "" {
+		t.Error("empty reply")
+	}
+	if len(resp.Sources) != 1 || resp.Sources[0].Filename != "handbook.pdf" {
+		t.Errorf("sources = %v, want handbook.pdf", resp.Sources)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	dependencyOK   = "ok"
+	dependencyDown = "down"
+
+	healthHealthy  = "healthy"
+	healthDegraded = "degraded"
+)
+
+// dependencyHealth is the outcome of probing one dependency
+type dependencyHealth struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// probeDependency runs check and reports how it went
+func probeDependency(ctx context.Context, name string, check func(ctx context.Context) error) dependencyHealth {
+	start := time.Now()
+	err := check(ctx)
+	result := dependencyHealth{
+		Name:      name,
+		Status:    dependencyOK,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = dependencyDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// CheckDependencies probes Vertex AI with a one-word embedding and ChromaDB
+// with a stats request, concurrently
+func (s *chatService) CheckDependencies(ctx context.Context) []dependencyHealth {
+	results := make([]dependencyHealth, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		results[0] = probeDependency(ctx, "vertex_ai", func(ctx context.Context) error {
+			_, err := s.vertexClient.CreateEmbedding(ctx, []string{"ping"})
+			return err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		results[1] = probeDependency(ctx, "chromadb", func(ctx context.Context) error {
+			_, err := s.chromaClient.Stats(ctx)
+			return err
+		})
+	}()
+	wg.Wait()
+	return results
+}
+
+// healthReport is the body of /api/health
+type healthReport struct {
+	Status          string                 `json:"status"`
+	Service         string                 `json:"service"`
+	Ready           bool                   `json:"ready"`
+	CircuitBreakers []circuitBreakerStatus `json:"circuit_breakers"`
+	// Dependencies is only set for deep checks
+	Dependencies []dependencyHealth `json:"dependencies,omitempty"`
+	// Index is the freshness of the default collection, only set for deep
+	// checks that could look it up
+	Index *ChromaDBCollectionFreshness `json:"index,omitempty"`
+}
+
+// healthChecker probes the service dependencies on demand and in the
+// background. Probe results are reused for interval so frequent health
+// checks don't turn into a stream of Vertex AI calls. The background loop
+// also drives the standard gRPC health service.
+type healthChecker struct {
+	service  Service
+	timeout  time.Duration
+	interval time.Duration
+	grpc     *health.Server
+
+	mu        sync.Mutex
+	last      []dependencyHealth
+	checkedAt time.Time
+}
+
+// newHealthChecker creates a health checker probing each dependency with
+// the given timeout. A zero interval disables caching and the background
+// loop.
+func newHealthChecker(service Service, timeout, interval time.Duration) *healthChecker {
+	return &healthChecker{
+		service:  service,
+		timeout:  timeout,
+		interval: interval,
+		grpc:     health.NewServer(),
+	}
+}
+
+// dependencies returns the latest probe results, probing again when they
+// are older than the interval. Concurrent callers share one probe.
+func (c *healthChecker) dependencies(ctx context.Context) []dependencyHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last != nil && time.Since(c.checkedAt) < c.interval {
+		return c.last
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	c.last = c.service.CheckDependencies(ctx)
+	c.checkedAt = time.Now()
+	return c.last
+}
+
+// report builds the health report. The service is degraded while a circuit
+// breaker is not closed or, for deep checks, a dependency is down. A stale
+// index is reported but doesn't degrade the service.
+func (c *healthChecker) report(ctx context.Context, ready, deep bool) *healthReport {
+	report := &healthReport{
+		Status:          healthHealthy,
+		Service:         "genai-foundation-demo",
+		Ready:           ready,
+		CircuitBreakers: c.service.CircuitBreakers(),
+	}
+	for _, b := range report.CircuitBreakers {
+		if b.State != breakerClosed.String() {
+			report.Status = healthDegraded
+		}
+	}
+	if deep {
+		report.Dependencies = c.dependencies(ctx)
+		for _, d := range report.Dependencies {
+			if d.Status != dependencyOK {
+				report.Status = healthDegraded
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		report.Index, _ = c.service.CollectionFreshness(ctx, "")
+	}
+	return report
+}
+
+// run probes the dependencies every interval until ctx is done, publishing
+// the results to the gRPC health service. The server and the ChatService
+// stop serving while Vertex AI is down, since no chat can succeed; every
+// dependency is also published under its own name (e.g. "chromadb").
+func (c *healthChecker) run(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
+	log.Printf("🩺 Dependency health checks every %v", c.interval)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		serving := healthpb.HealthCheckResponse_SERVING
+		for _, d := range c.dependencies(ctx) {
+			status := healthpb.HealthCheckResponse_SERVING
+			if d.Status != dependencyOK {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+				log.Printf("⚠️ [Health] %s is down: %s", d.Name, d.Error)
+				if d.Name == "vertex_ai" {
+					serving = healthpb.HealthCheckResponse_NOT_SERVING
+				}
+			}
+			c.grpc.SetServingStatus(d.Name, status)
+		}
+		c.grpc.SetServingStatus("", serving)
+		c.grpc.SetServingStatus(genaidemo.ChatService_ServiceDesc.ServiceName, serving)
+
+		select {
+		case <-ctx.Done():
+			c.grpc.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// maxHighlights is the number of sentences highlighted per retrieved chunk
+const maxHighlights = 2
+
+// ChromaDBHighlight is a span of a query result's document the ChromaDB
+// service found relevant, in Unicode code points
+type ChromaDBHighlight struct {
+	Start int     `json:"start"`
+	End   int     `json:"end"`
+	Score float64 `json:"score"`
+}
+
+// toHighlights converts the ChromaDB service's spans of a document
+func toHighlights(spans []ChromaDBHighlight) []*genaidemo.Highlight {
+	highlights := make([]*genaidemo.Highlight, 0, len(spans))
+	for _, span := range spans {
+		highlights = append(highlights, &genaidemo.Highlight{Start: int32(span.Start), End: int32(span.End), Score: span.Score})
+	}
+	return highlights
+}
+
+// keywordHighlights returns the sentences of content containing the largest
+// share of the query's terms, for collections the ChromaDB service can't
+// embed sentences of
+func keywordHighlights(query, content string) []*genaidemo.Highlight {
+	terms := make(map[string]bool)
+	for _, term := range strings.FieldsFunc(strings.ToLower(query), isNotWordRune) {
+		// Short words are mostly stop words, matching them everywhere
+		if utf8.RuneCountInString(term) > 2 {
+			terms[term] = true
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var highlights []*genaidemo.Highlight
+	for _, span := range sentenceSpans(content) {
+		sentence := strings.ToLower(string([]rune(content)[span[0]:span[1]]))
+		matched := 0
+		for _, word := range uniqueWords(sentence) {
+			if terms[word] {
+				matched++
+			}
+		}
+		if matched > 0 {
+			highlights = append(highlights, &genaidemo.Highlight{
+				Start: int32(span[0]),
+				End:   int32(span[1]),
+				Score: float64(matched) / float64(len(terms)),
+			})
+		}
+	}
+	slices.SortStableFunc(highlights, func(a, b *genaidemo.Highlight) int { return cmp.Compare(b.Score, a.Score) })
+	return highlights[:min(len(highlights), maxHighlights)]
+}
+
+// sentenceSpans splits text into sentences at sentence punctuation and line
+// breaks, returning their start and end rune offsets without surrounding
+// whitespace
+func sentenceSpans(text string) [][2]int {
+	runes := []rune(text)
+	var spans [][2]int
+	start := 0
+	flush := func(end int) {
+		for start < end && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		trimmed := end
+		for trimmed > start && unicode.IsSpace(runes[trimmed-1]) {
+			trimmed--
+		}
+		if trimmed > start {
+			spans = append(spans, [2]int{start, trimmed})
+		}
+		start = end
+	}
+	for i, r := range runes {
+		switch r {
+		case '.', '!', '?', '。', '！', '？', '\n':
+			flush(i + 1)
+		}
+	}
+	flush(len(runes))
+	return spans
+}
+
+func uniqueWords(text string) []string {
+	words := strings.FieldsFunc(text, isNotWordRune)
+	slices.Sort(words)
+	return slices.Compact(words)
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
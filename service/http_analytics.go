@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPTopicAnalytics struct {
+	Topic         string           `json:"topic"`
+	Conversations int64            `json:"conversations"`
+	Modes         map[string]int64 `json:"modes"`
+	Sentiments    map[string]int64 `json:"sentiments"`
+	Resolutions   map[string]int64 `json:"resolutions"`
+}
+
+type HTTPAnalyticsResponse struct {
+	Conversations int64 `json:"conversations"`
+	// Classified conversations are the ones the analytics job labelled so far
+	Classified  int64                 `json:"classified"`
+	Modes       map[string]int64      `json:"modes"`
+	Sentiments  map[string]int64      `json:"sentiments"`
+	Resolutions map[string]int64      `json:"resolutions"`
+	Topics      []*HTTPTopicAnalytics `json:"topics"`
+	// Intents counts the conversations whose request intent was classified
+	Intents map[string]int64 `json:"intents"`
+}
+
+// Create HTTP handler for conversation analytics. Supports the optional
+// query parameters from and to (RFC 3339 or Unix seconds).
+func analyticsHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		from, err := parseUsageTime(query.Get("from"))
+		if err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid from parameter"))
+			return
+		}
+		to, err := parseUsageTime(query.Get("to"))
+		if err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid to parameter"))
+			return
+		}
+
+		resp, err := handler.GetConversationAnalytics(r.Context(), &genaidemo.GetConversationAnalyticsRequest{
+			StartTime: from,
+			EndTime:   to,
+		})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+
+		response := &HTTPAnalyticsResponse{
+			Conversations: resp.Conversations,
+			Classified:    resp.Classified,
+			Modes:         resp.Modes,
+			Sentiments:    resp.Sentiments,
+			Resolutions:   resp.Resolutions,
+			Topics:        make([]*HTTPTopicAnalytics, len(resp.Topics)),
+			Intents:       resp.Intents,
+		}
+		for i, t := range resp.Topics {
+			response.Topics[i] = &HTTPTopicAnalytics{
+				Topic:         t.Topic,
+				Conversations: t.Conversations,
+				Modes:         t.Modes,
+				Sentiments:    t.Sentiments,
+				Resolutions:   t.Resolutions,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPClassifyRequest struct {
+	Text   string            `json:"text"`
+	Labels []*HTTPClassLabel `json:"labels"`
+	Model  *string           `json:"model,omitempty"`
+}
+
+type HTTPClassLabel struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Examples are texts of the label, given to the model as few-shot
+	// examples
+	Examples []string `json:"examples,omitempty"`
+}
+
+type HTTPClassifyResponse struct {
+	Label         string          `json:"label"`
+	Confidence    float64         `json:"confidence"`
+	Model         string          `json:"model"`
+	TokenUsage    *HTTPTokenUsage `json:"token_usage,omitempty"`
+	EstimatedCost *HTTPCost       `json:"estimated_cost,omitempty"`
+}
+
+// Create HTTP handler for classifying texts into one of a set of labels
+func classifyHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPClassifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid JSON"))
+			return
+		}
+		grpcReq := &genaidemo.ClassifyRequest{
+			Text:   req.Text,
+			Labels: make([]*genaidemo.ClassLabel, 0, len(req.Labels)),
+			Model:  req.Model,
+		}
+		for _, l := range req.Labels {
+			if l == nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "labels cannot be null"))
+				return
+			}
+			grpcReq.Labels = append(grpcReq.Labels, &genaidemo.ClassLabel{
+				Name:        l.Name,
+				Description: l.Description,
+				Examples:    l.Examples,
+			})
+		}
+
+		resp, err := handler.Classify(r.Context(), grpcReq)
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
+		response := &HTTPClassifyResponse{
+			Label:      resp.Label,
+			Confidence: resp.Confidence,
+			Model:      resp.Model,
+		}
+		if resp.TokenUsage != nil {
+			response.TokenUsage = &HTTPTokenUsage{
+				InputTokens:  resp.TokenUsage.InputTokenNum,
+				OutputTokens: resp.TokenUsage.OutputTokenNum,
+				TotalTokens:  resp.TokenUsage.TotalTokenNum,
+			}
+		}
+		if resp.EstimatedCost != nil {
+			response.EstimatedCost = &HTTPCost{
+				Currency: resp.EstimatedCost.Currency,
+				Amount:   resp.EstimatedCost.Amount,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// CollectionStats reports the corpus state of a retrieval collection. Callers
+// with a tenant only see their own collection and documents.
+func (h *Handler) CollectionStats(ctx context.Context, name string) (*ChromaDBCollectionStats, error) {
+	if err := h.tenants.checkCollection(tenantFromContext(ctx), name); err != nil {
+		return nil, err
+	}
+	return h.service.CollectionStats(ctx, name)
+}
+
+// CollectionFreshness reports when a retrieval collection last changed and
+// how its connectors last synced. Callers with a tenant only see their own
+// collection.
+func (h *Handler) CollectionFreshness(ctx context.Context, name string) (*ChromaDBCollectionFreshness, error) {
+	if err := h.tenants.checkCollection(tenantFromContext(ctx), name); err != nil {
+		return nil, err
+	}
+	return h.service.CollectionFreshness(ctx, name)
+}
+
+// Create HTTP handler for the statistics of a retrieval collection
+func collectionStatsHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		stats, err := handler.CollectionStats(r.Context(), r.PathValue("name"))
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// Create HTTP handler for the freshness of a retrieval collection
+func collectionFreshnessHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		freshness, err := handler.CollectionFreshness(r.Context(), r.PathValue("name"))
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(freshness)
+	}
+}
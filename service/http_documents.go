@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// uploadsPath is the prefix of the resumable upload routes, which follow the
+// core tus 1.0.0 protocol
+const uploadsPath = "/api/documents/uploads"
+
+const tusVersion = "1.0.0"
+
+// HTTPCreateUploadRequest describes the document of a resumable upload
+type HTTPCreateUploadRequest struct {
+	Filename   string            `json:"filename"`
+	MimeType   string            `json:"mime_type,omitempty"`
+	Size       int64             `json:"size"`
+	Sha256     string            `json:"sha256,omitempty"`
+	Collection string            `json:"collection,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+type HTTPUploadResponse struct {
+	UploadID string `json:"upload_id"`
+	Filename string `json:"filename"`
+	// State is uploading, ingesting, done or failed
+	State     string `json:"state"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Source    string `json:"source,omitempty"`
+	Chunks    int    `json:"chunks,omitempty"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// Create HTTP handler for starting resumable uploads. The document is
+// described by a JSON body, or by the Upload-Length and Upload-Metadata
+// headers of tus clients.
+func createUploadHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPCreateUploadRequest
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+				return
+			}
+		} else if err := parseTusCreation(r, &req); err != nil {
+			sendError(w, r, err)
+			return
+		}
+
+		u, err := handler.CreateUpload(r.Context(), &genaidemo.DocumentInfo{
+			Filename:   req.Filename,
+			MimeType:   req.MimeType,
+			Size:       req.Size,
+			Sha256:     req.Sha256,
+			Collection: req.Collection,
+			Metadata:   req.Metadata,
+		})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", uploadsPath+"/"+u.ID)
+		w.Header().Set("Upload-Offset", "0")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(toHTTPUploadResponse(u))
+	}
+}
+
+// Create HTTP handler for a single resumable upload: HEAD and GET report its
+// offset and state, PATCH appends bytes and DELETE aborts it
+func uploadHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		switch r.Method {
+		case "HEAD", "GET":
+			u, err := handler.uploads.get(r.Context(), id)
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+			w.Header().Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(toHTTPUploadResponse(u))
+		case "PATCH":
+			if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+				http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+				return
+			}
+			offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil || offset < 0 {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Upload-Offset must be a byte offset"))
+				return
+			}
+			// The offset is reported on failures too, so clients resume from it
+			u, err := handler.AppendUpload(r.Context(), id, offset, r.Body)
+			if u != nil {
+				w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+			}
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "DELETE":
+			if err := handler.uploads.remove(r.Context(), id); err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// parseTusCreation reads the document of a tus creation request. Upload-Metadata
+// is a comma-separated list of keys with base64 values; filename, filetype,
+// sha256 and collection describe the document and other keys become chunk
+// metadata.
+func parseTusCreation(r *http.Request, req *HTTPCreateUploadRequest) error {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Upload-Length is required")
+	}
+	req.Size = size
+
+	for _, pair := range strings.Split(r.Header.Get("Upload-Metadata"), ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Upload-Metadata value of %s is not base64", key)
+		}
+		switch key {
+		case "filename":
+			req.Filename = string(value)
+		case "filetype":
+			req.MimeType = string(value)
+		case "sha256":
+			req.Sha256 = string(value)
+		case "collection":
+			req.Collection = string(value)
+		default:
+			if req.Metadata == nil {
+				req.Metadata = make(map[string]string)
+			}
+			req.Metadata[key] = string(value)
+		}
+	}
+	return nil
+}
+
+func toHTTPUploadResponse(u *resumableUpload) *HTTPUploadResponse {
+	return &HTTPUploadResponse{
+		UploadID:  u.ID,
+		Filename:  u.Filename,
+		State:     u.State,
+		Offset:    u.Offset,
+		Size:      u.Size,
+		Source:    u.Source,
+		Chunks:    u.Chunks,
+		Error:     u.Error,
+		CreatedAt: u.CreatedAt.Unix(),
+		ExpiresAt: u.ExpiresAt.Unix(),
+	}
+}
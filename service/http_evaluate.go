@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPEvaluateRequest struct {
+	Messages []HTTPMessage `json:"messages"`
+	Response string        `json:"response"`
+	// Documents are the retrieved excerpts the response should be grounded in
+	Documents []string `json:"documents,omitempty"`
+	// Criteria default to helpfulness, groundedness and tone
+	Criteria   []string `json:"criteria,omitempty"`
+	JudgeModel *string  `json:"judge_model,omitempty"`
+}
+
+type HTTPCriterionScore struct {
+	Criterion string `json:"criterion"`
+	Score     int32  `json:"score"`
+	Reasoning string `json:"reasoning"`
+}
+
+type HTTPEvaluateResponse struct {
+	Scores        []*HTTPCriterionScore `json:"scores"`
+	OverallScore  float64               `json:"overall_score"`
+	JudgeModel    string                `json:"judge_model"`
+	TokenUsage    *HTTPTokenUsage       `json:"token_usage,omitempty"`
+	EstimatedCost *HTTPCost             `json:"estimated_cost,omitempty"`
+}
+
+// Create HTTP handler for scoring a response with the judge model
+func evaluateHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPEvaluateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid JSON"))
+			return
+		}
+
+		resp, err := handler.EvaluateResponse(r.Context(), &genaidemo.EvaluateResponseRequest{
+			Messages:   toGRPCMessages(req.Messages),
+			Response:   req.Response,
+			Documents:  req.Documents,
+			Criteria:   req.Criteria,
+			JudgeModel: req.JudgeModel,
+		})
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
+		response := &HTTPEvaluateResponse{
+			Scores:       make([]*HTTPCriterionScore, len(resp.Scores)),
+			OverallScore: resp.OverallScore,
+			JudgeModel:   resp.JudgeModel,
+		}
+		for i, s := range resp.Scores {
+			response.Scores[i] = &HTTPCriterionScore{
+				Criterion: s.Criterion,
+				Score:     s.Score,
+				Reasoning: s.Reasoning,
+			}
+		}
+		if resp.TokenUsage != nil {
+			response.TokenUsage = &HTTPTokenUsage{
+				InputTokens:  resp.TokenUsage.InputTokenNum,
+				OutputTokens: resp.TokenUsage.OutputTokenNum,
+				TotalTokens:  resp.TokenUsage.TotalTokenNum,
+			}
+		}
+		if resp.EstimatedCost != nil {
+			response.EstimatedCost = &HTTPCost{
+				Currency: resp.EstimatedCost.Currency,
+				Amount:   resp.EstimatedCost.Amount,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
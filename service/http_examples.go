@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPFewShotExample struct {
+	ID string `json:"id,omitempty"`
+	// Mode is one of MODE_CHAT, MODE_TOOL, MODE_AGENT, MODE_DOC
+	Mode      string `json:"mode"`
+	Persona   string `json:"persona,omitempty"`
+	Input     string `json:"input"`
+	Output    string `json:"output"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	UpdatedAt int64  `json:"updated_at,omitempty"`
+}
+
+type HTTPFewShotExampleList struct {
+	Examples []*HTTPFewShotExample `json:"examples"`
+}
+
+// Create HTTP handler for the few-shot example collection (list and create)
+func examplesHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			// ?mode=MODE_DOC&persona=support narrows the list
+			query := r.URL.Query()
+			var mode genaidemo.Mode
+			if m := query.Get("mode"); m != "" {
+				v, ok := genaidemo.Mode_value[m]
+				if !ok {
+					sendError(w, r, status.Error(codes.InvalidArgument, "Invalid mode"))
+					return
+				}
+				mode = genaidemo.Mode(v)
+			}
+			resp, err := handler.ListFewShotExamples(r.Context(), &genaidemo.ListFewShotExamplesRequest{
+				Mode:    mode,
+				Persona: query.Get("persona"),
+			})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			list := &HTTPFewShotExampleList{Examples: make([]*HTTPFewShotExample, len(resp.Examples))}
+			for i, e := range resp.Examples {
+				list.Examples[i] = toHTTPFewShotExample(e)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+		case "POST":
+			var req HTTPFewShotExample
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+				return
+			}
+			e, err := handler.CreateFewShotExample(r.Context(), toGRPCFewShotExample(&req))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(toHTTPFewShotExample(e))
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Create HTTP handler for a single few-shot example (get, update and delete)
+func exampleHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		switch r.Method {
+		case "GET":
+			e, err := handler.GetFewShotExample(r.Context(), &genaidemo.GetFewShotExampleRequest{Id: id})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(toHTTPFewShotExample(e))
+		case "PUT":
+			var req HTTPFewShotExample
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+				return
+			}
+			req.ID = id
+			e, err := handler.UpdateFewShotExample(r.Context(), toGRPCFewShotExample(&req))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(toHTTPFewShotExample(e))
+		case "DELETE":
+			if _, err := handler.DeleteFewShotExample(r.Context(), &genaidemo.DeleteFewShotExampleRequest{Id: id}); err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func toGRPCFewShotExample(e *HTTPFewShotExample) *genaidemo.FewShotExample {
+	return &genaidemo.FewShotExample{
+		Id:      e.ID,
+		Mode:    genaidemo.Mode(genaidemo.Mode_value[e.Mode]),
+		Persona: e.Persona,
+		Input:   e.Input,
+		Output:  e.Output,
+	}
+}
+
+func toHTTPFewShotExample(e *genaidemo.FewShotExample) *HTTPFewShotExample {
+	return &HTTPFewShotExample{
+		ID:        e.Id,
+		Mode:      e.Mode.String(),
+		Persona:   e.Persona,
+		Input:     e.Input,
+		Output:    e.Output,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPDatasetExportRequest struct {
+	// Format is "openai" or "gemini"
+	Format string `json:"format"`
+	// Modes are any of MODE_CHAT, MODE_TOOL, MODE_AGENT, MODE_DOC
+	Modes []string `json:"modes,omitempty"`
+	// Thumb is "up" or "down"
+	Thumb     string `json:"thumb,omitempty"`
+	MinRating int32  `json:"min_rating,omitempty"`
+	// From and To are RFC 3339 or Unix seconds
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+type HTTPDatasetExport struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Format     string `json:"format"`
+	Examples   int64  `json:"examples"`
+	Redactions int64  `json:"redactions"`
+	Size       int64  `json:"size"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+}
+
+// httpExportFormats maps the formats of HTTP export requests to their gRPC
+// values
+var httpExportFormats = map[string]genaidemo.ExportFormat{
+	"openai": genaidemo.ExportFormat_EXPORT_FORMAT_OPENAI,
+	"gemini": genaidemo.ExportFormat_EXPORT_FORMAT_GEMINI,
+}
+
+// Create HTTP handler for starting fine-tuning dataset exports
+func createExportHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPDatasetExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+			return
+		}
+		format, ok := httpExportFormats[req.Format]
+		if !ok {
+			sendError(w, r, status.Error(codes.InvalidArgument, "format must be openai or gemini"))
+			return
+		}
+		thumb, ok := httpThumbs[req.Thumb]
+		if !ok {
+			sendError(w, r, status.Error(codes.InvalidArgument, "thumb must be up or down"))
+			return
+		}
+		from, err := parseUsageTime(req.From)
+		if err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid from parameter"))
+			return
+		}
+		to, err := parseUsageTime(req.To)
+		if err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid to parameter"))
+			return
+		}
+		grpcReq := &genaidemo.CreateDatasetExportRequest{
+			Format:    format,
+			Thumb:     thumb,
+			MinRating: req.MinRating,
+			StartTime: from,
+			EndTime:   to,
+		}
+		for _, m := range req.Modes {
+			mode, ok := genaidemo.Mode_value[m]
+			if !ok {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid mode"))
+				return
+			}
+			grpcReq.Modes = append(grpcReq.Modes, genaidemo.Mode(mode))
+		}
+
+		export, err := handler.CreateDatasetExport(r.Context(), grpcReq)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/exports/"+export.Id)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(toHTTPDatasetExport(export))
+	}
+}
+
+// Create HTTP handler for polling fine-tuning dataset exports
+func getExportHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		export, err := handler.GetDatasetExport(r.Context(), &genaidemo.GetDatasetExportRequest{Id: r.PathValue("id")})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toHTTPDatasetExport(export))
+	}
+}
+
+// Create HTTP handler for downloading the JSONL file of a finished export
+func exportDataHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.PathValue("id")
+		f, err := handler.OpenDatasetExport(r.Context(), id)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.jsonl"`)
+		if info, err := f.Stat(); err == nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			log.Printf("⚠️ Failed to send export %s: %v", id, err)
+		}
+	}
+}
+
+func toHTTPDatasetExport(e *genaidemo.DatasetExport) *HTTPDatasetExport {
+	out := &HTTPDatasetExport{
+		ID:         e.Id,
+		Status:     e.Status.String(),
+		Examples:   e.Examples,
+		Redactions: e.Redactions,
+		Size:       e.Size,
+		Error:      e.Error,
+		CreatedAt:  e.CreatedAt,
+		FinishedAt: e.FinishedAt,
+	}
+	for name, format := range httpExportFormats {
+		if format == e.Format {
+			out.Format = name
+		}
+	}
+	return out
+}
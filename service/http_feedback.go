@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPFeedbackRequest struct {
+	// RequestID is the X-Request-ID header of the response the feedback is on
+	RequestID string `json:"request_id"`
+	// Thumb is "up" or "down"
+	Thumb   string  `json:"thumb,omitempty"`
+	Rating  *int32  `json:"rating,omitempty"`
+	Comment *string `json:"comment,omitempty"`
+}
+
+type HTTPFeedback struct {
+	RequestID   string `json:"request_id"`
+	Thumb       string `json:"thumb,omitempty"`
+	Rating      int32  `json:"rating,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	SubmittedAt int64  `json:"submitted_at"`
+	Mode        string `json:"mode"`
+	Model       string `json:"model"`
+	Variant     string `json:"variant,omitempty"`
+	Rollout     string `json:"rollout,omitempty"`
+}
+
+type HTTPFeedbackStats struct {
+	Feedback      int64   `json:"feedback"`
+	ThumbsUp      int64   `json:"thumbs_up"`
+	ThumbsDown    int64   `json:"thumbs_down"`
+	Ratings       int64   `json:"ratings"`
+	AverageRating float64 `json:"average_rating"`
+	Comments      int64   `json:"comments"`
+}
+
+type HTTPFeedbackGroup struct {
+	Mode    string `json:"mode"`
+	Variant string `json:"variant,omitempty"`
+	Rollout string `json:"rollout,omitempty"`
+	Model   string `json:"model"`
+	*HTTPFeedbackStats
+}
+
+type HTTPFeedbackSummary struct {
+	Total  *HTTPFeedbackStats   `json:"total"`
+	Groups []*HTTPFeedbackGroup `json:"groups"`
+}
+
+// httpThumbs maps the thumbs of HTTP feedback to their gRPC values
+var httpThumbs = map[string]genaidemo.Thumb{
+	"":     genaidemo.Thumb_THUMB_UNSPECIFIED,
+	"up":   genaidemo.Thumb_THUMB_UP,
+	"down": genaidemo.Thumb_THUMB_DOWN,
+}
+
+// Create HTTP handler for response feedback: POST submits feedback on a
+// reply, GET summarizes the feedback with the optional query parameters from
+// and to (RFC 3339 or Unix seconds)
+func feedbackHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			query := r.URL.Query()
+			from, err := parseUsageTime(query.Get("from"))
+			if err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid from parameter"))
+				return
+			}
+			to, err := parseUsageTime(query.Get("to"))
+			if err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid to parameter"))
+				return
+			}
+
+			resp, err := handler.GetFeedbackSummary(r.Context(), &genaidemo.GetFeedbackSummaryRequest{
+				StartTime: from,
+				EndTime:   to,
+			})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+
+			summary := &HTTPFeedbackSummary{
+				Total:  toHTTPFeedbackStats(resp.Total),
+				Groups: make([]*HTTPFeedbackGroup, len(resp.Groups)),
+			}
+			for i, g := range resp.Groups {
+				summary.Groups[i] = &HTTPFeedbackGroup{
+					Mode:              g.Mode.String(),
+					Variant:           g.Variant,
+					Rollout:           g.Rollout,
+					Model:             g.Model,
+					HTTPFeedbackStats: toHTTPFeedbackStats(g.Stats),
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(summary)
+		case "POST":
+			var req HTTPFeedbackRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+				return
+			}
+			thumb, ok := httpThumbs[req.Thumb]
+			if !ok {
+				sendError(w, r, status.Error(codes.InvalidArgument, "thumb must be up or down"))
+				return
+			}
+
+			f, err := handler.SubmitFeedback(r.Context(), &genaidemo.SubmitFeedbackRequest{
+				RequestId: req.RequestID,
+				Thumb:     thumb,
+				Rating:    req.Rating,
+				Comment:   req.Comment,
+			})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(toHTTPFeedback(f))
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func toHTTPFeedback(f *genaidemo.Feedback) *HTTPFeedback {
+	out := &HTTPFeedback{
+		RequestID:   f.RequestId,
+		Rating:      f.Rating,
+		Comment:     f.Comment,
+		SubmittedAt: f.SubmittedAt,
+		Mode:        f.Mode.String(),
+		Model:       f.Model,
+		Variant:     f.Variant,
+		Rollout:     f.Rollout,
+	}
+	for name, thumb := range httpThumbs {
+		if thumb == f.Thumb && thumb != genaidemo.Thumb_THUMB_UNSPECIFIED {
+			out.Thumb = name
+		}
+	}
+	return out
+}
+
+func toHTTPFeedbackStats(s *genaidemo.FeedbackStats) *HTTPFeedbackStats {
+	return &HTTPFeedbackStats{
+		Feedback:      s.GetFeedback(),
+		ThumbsUp:      s.GetThumbsUp(),
+		ThumbsDown:    s.GetThumbsDown(),
+		Ratings:       s.GetRatings(),
+		AverageRating: s.GetAverageRating(),
+		Comments:      s.GetComments(),
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+type HTTPGraphRelation struct {
+	ID        string `json:"id"`
+	Subject   string `json:"subject"`
+	Predicate string `json:"predicate"`
+	Object    string `json:"object"`
+	// Date is when the relation happened or was decided (YYYY-MM-DD), if stated
+	Date string `json:"date,omitempty"`
+	// Source is "conversation" or the filename of a document
+	Source    string `json:"source"`
+	LearnedAt int64  `json:"learned_at"`
+}
+
+type HTTPGraphEntity struct {
+	Name      string               `json:"name"`
+	Type      string               `json:"type"`
+	Mentions  int32                `json:"mentions"`
+	FirstSeen int64                `json:"first_seen"`
+	LastSeen  int64                `json:"last_seen"`
+	Relations []*HTTPGraphRelation `json:"relations"`
+}
+
+type HTTPDeleteGraphEntityResponse struct {
+	DeletedRelations int32 `json:"deleted_relations"`
+}
+
+// Create HTTP handler for an entity of the caller's knowledge graph (get and
+// delete)
+func graphEntityHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		switch r.Method {
+		case "GET":
+			entity, err := handler.GetGraphEntity(r.Context(), &genaidemo.GetGraphEntityRequest{Name: name})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			resp := &HTTPGraphEntity{
+				Name:      entity.Name,
+				Type:      entity.Type,
+				Mentions:  entity.Mentions,
+				FirstSeen: entity.FirstSeen,
+				LastSeen:  entity.LastSeen,
+				Relations: make([]*HTTPGraphRelation, len(entity.Relations)),
+			}
+			for i, rel := range entity.Relations {
+				resp.Relations[i] = &HTTPGraphRelation{
+					ID:        rel.Id,
+					Subject:   rel.Subject,
+					Predicate: rel.Predicate,
+					Object:    rel.Object,
+					Date:      rel.Date,
+					Source:    rel.Source,
+					LearnedAt: rel.LearnedAt,
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		case "DELETE":
+			resp, err := handler.DeleteGraphEntity(r.Context(), &genaidemo.DeleteGraphEntityRequest{Name: name})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&HTTPDeleteGraphEntityResponse{DeletedRelations: resp.DeletedRelations})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
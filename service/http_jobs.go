@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+)
+
+// HTTPSubmitChatRequest is a chat request with the mode to run it in
+type HTTPSubmitChatRequest struct {
+	HTTPChatRequest
+	// Mode is one of MODE_CHAT, MODE_TOOL, MODE_AGENT, MODE_DOC
+	Mode string `json:"mode"`
+}
+
+type HTTPJobResponse struct {
+	JobID      string            `json:"job_id"`
+	Status     string            `json:"status"`
+	Response   *HTTPChatResponse `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  int64             `json:"created_at"`
+	StartedAt  int64             `json:"started_at,omitempty"`
+	FinishedAt int64             `json:"finished_at,omitempty"`
+}
+
+// Create HTTP handler for submitting asynchronous chat jobs
+func submitJobHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPSubmitChatRequest
+		if !decodeChatBody(w, r, &req) {
+			return
+		}
+
+		job, err := handler.SubmitChat(r.Context(), &genaidemo.SubmitChatRequest{
+			Mode:    genaidemo.Mode(genaidemo.Mode_value[req.Mode]),
+			Request: toGRPCChatRequest(&req.HTTPChatRequest),
+		})
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(toHTTPJobResponse(job))
+	}
+}
+
+// Create HTTP handler for polling asynchronous chat jobs
+func getJobHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		job, err := handler.GetJob(r.Context(), &genaidemo.GetJobRequest{JobId: r.PathValue("id")})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toHTTPJobResponse(job))
+	}
+}
+
+func toHTTPJobResponse(job *genaidemo.Job) *HTTPJobResponse {
+	response := &HTTPJobResponse{
+		JobID:      job.JobId,
+		Status:     job.Status.String(),
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Response != nil {
+		response.Response = toHTTPChatResponse(job.Response)
+	}
+	return response
+}
+
+// statusClientClosedRequest is the non-standard status (popularized by nginx)
+// logged for requests the client abandoned before the response was ready
+const statusClientClosedRequest = 499
+
+// httpStatusFromError maps gRPC status codes to HTTP status codes
+func httpStatusFromError(err error) int {
+	switch errorCode(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusUnprocessableEntity
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Canceled:
+		return statusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
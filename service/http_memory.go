@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+type HTTPUserFact struct {
+	ID string `json:"id"`
+	// Category is one of name, role, language, project, preference
+	Category  string `json:"category"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type HTTPUserMemory struct {
+	UserID string          `json:"user_id"`
+	Facts  []*HTTPUserFact `json:"facts"`
+}
+
+type HTTPDeleteUserMemoryResponse struct {
+	Deleted int32 `json:"deleted"`
+}
+
+// Create HTTP handler for the memory of the user named by the X-User-ID
+// header (view and delete)
+func memoryHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			memory, err := handler.GetUserMemory(r.Context(), &genaidemo.GetUserMemoryRequest{})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			resp := &HTTPUserMemory{UserID: memory.UserId, Facts: make([]*HTTPUserFact, len(memory.Facts))}
+			for i, f := range memory.Facts {
+				resp.Facts[i] = &HTTPUserFact{
+					ID:        f.Id,
+					Category:  f.Category,
+					Content:   f.Content,
+					CreatedAt: f.CreatedAt,
+					UpdatedAt: f.UpdatedAt,
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		case "DELETE":
+			deleteUserMemory(handler, w, r, "")
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Create HTTP handler for a single fact of the user's memory (delete)
+func memoryFactHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deleteUserMemory(handler, w, r, r.PathValue("id"))
+	}
+}
+
+// deleteUserMemory deletes one fact of the user's memory, or all of them
+// when factID is empty, and reports how many were deleted
+func deleteUserMemory(handler *Handler, w http.ResponseWriter, r *http.Request, factID string) {
+	resp, err := handler.DeleteUserMemory(r.Context(), &genaidemo.DeleteUserMemoryRequest{FactId: factID})
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&HTTPDeleteUserMemoryResponse{Deleted: resp.Deleted})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPChatSession struct {
+	SessionID string `json:"session_id"`
+	Title     string `json:"title"`
+	// TitleGenerated is false while the title is the start of the first
+	// user message
+	TitleGenerated bool  `json:"title_generated"`
+	Exchanges      int32 `json:"exchanges"`
+	CreatedAt      int64 `json:"created_at"`
+	UpdatedAt      int64 `json:"updated_at"`
+}
+
+type HTTPSessionList struct {
+	Sessions []*HTTPChatSession `json:"sessions"`
+}
+
+// Create HTTP handler for the session history of the caller, or of the user
+// named by the X-User-ID header. Supports the optional query parameter limit.
+func sessionsHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var limit int32
+		if l := r.URL.Query().Get("limit"); l != "" {
+			n, err := strconv.ParseInt(l, 10, 32)
+			if err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid limit parameter"))
+				return
+			}
+			limit = int32(n)
+		}
+
+		resp, err := handler.ListSessions(r.Context(), &genaidemo.ListSessionsRequest{Limit: limit})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		list := &HTTPSessionList{Sessions: make([]*HTTPChatSession, len(resp.Sessions))}
+		for i, s := range resp.Sessions {
+			list.Sessions[i] = &HTTPChatSession{
+				SessionID:      s.SessionId,
+				Title:          s.Title,
+				TitleGenerated: s.TitleGenerated,
+				Exchanges:      s.Exchanges,
+				CreatedAt:      s.CreatedAt,
+				UpdatedAt:      s.UpdatedAt,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
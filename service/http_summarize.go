@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPSummarizeRequest sets either Text or DocumentID
+type HTTPSummarizeRequest struct {
+	Text string `json:"text,omitempty"`
+	// DocumentID is the source returned when the document was uploaded
+	DocumentID string `json:"document_id,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	// Length is "short", "medium" (the default) or "long"
+	Length string `json:"length,omitempty"`
+	// Style is "paragraph" (the default), "bullets" or "executive"
+	Style string  `json:"style,omitempty"`
+	Model *string `json:"model,omitempty"`
+}
+
+type HTTPSummarizeResponse struct {
+	Summary       string          `json:"summary"`
+	Model         string          `json:"model"`
+	Parts         int32           `json:"parts"`
+	Calls         int32           `json:"calls"`
+	TokenUsage    *HTTPTokenUsage `json:"token_usage,omitempty"`
+	EstimatedCost *HTTPCost       `json:"estimated_cost,omitempty"`
+}
+
+// httpSummaryLengths and httpSummaryStyles map the options of HTTP summarize
+// requests to their gRPC values
+var (
+	httpSummaryLengths = map[string]genaidemo.SummaryLength{
+		"":       genaidemo.SummaryLength_SUMMARY_LENGTH_UNSPECIFIED,
+		"short":  genaidemo.SummaryLength_SUMMARY_LENGTH_SHORT,
+		"medium": genaidemo.SummaryLength_SUMMARY_LENGTH_MEDIUM,
+		"long":   genaidemo.SummaryLength_SUMMARY_LENGTH_LONG,
+	}
+	httpSummaryStyles = map[string]genaidemo.SummaryStyle{
+		"":          genaidemo.SummaryStyle_SUMMARY_STYLE_UNSPECIFIED,
+		"paragraph": genaidemo.SummaryStyle_SUMMARY_STYLE_PARAGRAPH,
+		"bullets":   genaidemo.SummaryStyle_SUMMARY_STYLE_BULLETS,
+		"executive": genaidemo.SummaryStyle_SUMMARY_STYLE_EXECUTIVE,
+	}
+)
+
+// Create HTTP handler for summarizing texts and documents
+func summarizeHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPSummarizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid JSON"))
+			return
+		}
+		length, ok := httpSummaryLengths[req.Length]
+		if !ok {
+			sendError(w, r, status.Error(codes.InvalidArgument, "length must be short, medium or long"))
+			return
+		}
+		style, ok := httpSummaryStyles[req.Style]
+		if !ok {
+			sendError(w, r, status.Error(codes.InvalidArgument, "style must be paragraph, bullets or executive"))
+			return
+		}
+
+		resp, err := handler.Summarize(r.Context(), &genaidemo.SummarizeRequest{
+			Text:       req.Text,
+			DocumentId: req.DocumentID,
+			Collection: req.Collection,
+			Length:     length,
+			Style:      style,
+			Model:      req.Model,
+		})
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
+		response := &HTTPSummarizeResponse{
+			Summary: resp.Summary,
+			Model:   resp.Model,
+			Parts:   resp.Parts,
+			Calls:   resp.Calls,
+		}
+		if resp.TokenUsage != nil {
+			response.TokenUsage = &HTTPTokenUsage{
+				InputTokens:  resp.TokenUsage.InputTokenNum,
+				OutputTokens: resp.TokenUsage.OutputTokenNum,
+				TotalTokens:  resp.TokenUsage.TotalTokenNum,
+			}
+		}
+		if resp.EstimatedCost != nil {
+			response.EstimatedCost = &HTTPCost{
+				Currency: resp.EstimatedCost.Currency,
+				Amount:   resp.EstimatedCost.Amount,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPPromptTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Role is ROLE_SYSTEM or ROLE_USER
+	Role      string `json:"role"`
+	Template  string `json:"template"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	UpdatedAt int64  `json:"updated_at,omitempty"`
+	Version   int32  `json:"version,omitempty"`
+}
+
+// HTTPPromptTemplateRef identifies a prompt template version
+type HTTPPromptTemplateRef struct {
+	Name    string `json:"name"`
+	Version int32  `json:"version"`
+}
+
+type HTTPPromptTemplateList struct {
+	Templates []*HTTPPromptTemplate `json:"templates"`
+}
+
+// Create HTTP handler for the prompt template collection (list and create)
+func templatesHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			resp, err := handler.ListPromptTemplates(r.Context(), &genaidemo.ListPromptTemplatesRequest{})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			list := &HTTPPromptTemplateList{Templates: make([]*HTTPPromptTemplate, len(resp.Templates))}
+			for i, t := range resp.Templates {
+				list.Templates[i] = toHTTPPromptTemplate(t)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+		case "POST":
+			var req HTTPPromptTemplate
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+				return
+			}
+			t, err := handler.CreatePromptTemplate(r.Context(), toGRPCPromptTemplate(&req))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(toHTTPPromptTemplate(t))
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Create HTTP handler for a single prompt template (get, update and delete)
+func templateHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		switch r.Method {
+		case "GET":
+			// ?version=N returns an earlier version instead of the active one
+			var version int64
+			if v := r.URL.Query().Get("version"); v != "" {
+				var err error
+				if version, err = strconv.ParseInt(v, 10, 32); err != nil {
+					sendError(w, r, status.Error(codes.InvalidArgument, "Invalid version"))
+					return
+				}
+			}
+			t, err := handler.GetPromptTemplate(r.Context(), &genaidemo.GetPromptTemplateRequest{Name: name, Version: int32(version)})
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(toHTTPPromptTemplate(t))
+		case "PUT":
+			var req HTTPPromptTemplate
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+				return
+			}
+			req.Name = name
+			t, err := handler.UpdatePromptTemplate(r.Context(), toGRPCPromptTemplate(&req))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(toHTTPPromptTemplate(t))
+		case "DELETE":
+			if _, err := handler.DeletePromptTemplate(r.Context(), &genaidemo.DeletePromptTemplateRequest{Name: name}); err != nil {
+				sendError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Create HTTP handler listing every version of a prompt template
+func templateVersionsHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp, err := handler.ListPromptTemplateVersions(r.Context(), &genaidemo.ListPromptTemplateVersionsRequest{Name: r.PathValue("name")})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		list := &HTTPPromptTemplateList{Templates: make([]*HTTPPromptTemplate, len(resp.Templates))}
+		for i, t := range resp.Templates {
+			list.Templates[i] = toHTTPPromptTemplate(t)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// Create HTTP handler making a prompt template version the active one
+func activateTemplateVersionHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		version, err := strconv.ParseInt(r.PathValue("version"), 10, 32)
+		if err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid version"))
+			return
+		}
+		t, err := handler.ActivatePromptTemplateVersion(r.Context(), &genaidemo.ActivatePromptTemplateVersionRequest{
+			Name:    r.PathValue("name"),
+			Version: int32(version),
+		})
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toHTTPPromptTemplate(t))
+	}
+}
+
+func toGRPCPromptTemplate(t *HTTPPromptTemplate) *genaidemo.PromptTemplate {
+	return &genaidemo.PromptTemplate{
+		Name:        t.Name,
+		Description: t.Description,
+		Role:        genaidemo.Role(genaidemo.Role_value[t.Role]),
+		Template:    t.Template,
+	}
+}
+
+func toHTTPPromptTemplate(t *genaidemo.PromptTemplate) *HTTPPromptTemplate {
+	return &HTTPPromptTemplate{
+		Name:        t.Name,
+		Description: t.Description,
+		Role:        t.Role.String(),
+		Template:    t.Template,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+		Version:     t.Version,
+	}
+}
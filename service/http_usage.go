@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type HTTPUsageRecord struct {
+	KeyID        string  `json:"key_id"`
+	Requests     int64   `json:"requests"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	TotalTokens  int64   `json:"total_tokens"`
+	Cost         float64 `json:"cost"`
+	// CachedTokens are the input tokens served from the context cache, and
+	// CacheSavings what their discount saved
+	CachedTokens int64   `json:"cached_tokens,omitempty"`
+	CacheSavings float64 `json:"cache_savings,omitempty"`
+	// MonthlyBudget and BudgetRemaining are set for keys with a budget
+	MonthlyBudget   *float64 `json:"monthly_budget,omitempty"`
+	BudgetRemaining *float64 `json:"budget_remaining,omitempty"`
+}
+
+type HTTPUsageResponse struct {
+	Currency string             `json:"currency"`
+	Usage    []*HTTPUsageRecord `json:"usage"`
+}
+
+// Create HTTP handler for per-API-key usage reporting. Supports the optional
+// query parameters from and to (RFC 3339 or Unix seconds) and key_id.
+func usageHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		from, err := parseUsageTime(query.Get("from"))
+		if err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid from parameter"))
+			return
+		}
+		to, err := parseUsageTime(query.Get("to"))
+		if err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid to parameter"))
+			return
+		}
+
+		resp, err := handler.GetUsage(r.Context(), &genaidemo.GetUsageRequest{
+			StartTime: from,
+			EndTime:   to,
+			KeyId:     query.Get("key_id"),
+		})
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
+		response := &HTTPUsageResponse{
+			Currency: resp.Currency,
+			Usage:    make([]*HTTPUsageRecord, len(resp.Records)),
+		}
+		for i, record := range resp.Records {
+			response.Usage[i] = &HTTPUsageRecord{
+				KeyID:        record.KeyId,
+				Requests:     record.Requests,
+				InputTokens:  record.InputTokens,
+				OutputTokens: record.OutputTokens,
+				TotalTokens:  record.TotalTokens,
+				Cost:         record.Cost,
+				CachedTokens: record.CachedTokens,
+				CacheSavings: record.CacheSavings,
+
+				MonthlyBudget:   record.MonthlyBudget,
+				BudgetRemaining: record.BudgetRemaining,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// parseUsageTime parses an RFC 3339 timestamp or Unix seconds into Unix
+// seconds; an empty value returns 0
+func parseUsageTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return seconds, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// HTTPChatResponseV2 is the body of the /api/v2 chat endpoints. It has every
+// field of the v1 response, with tool results moved out of the content.
+type HTTPChatResponseV2 struct {
+	HTTPChatResponse
+	ToolInvocations []*HTTPToolInvocation `json:"tool_invocations,omitempty"`
+	SafetyRatings   []*HTTPSafetyRating   `json:"safety_ratings,omitempty"`
+	Timings         *HTTPTimings          `json:"timings,omitempty"`
+	// Cached is set when the reply was served from the response cache
+	Cached bool `json:"cached,omitempty"`
+	// Completions are every completion when n was above 1
+	Completions []*HTTPCompletion `json:"completions,omitempty"`
+	// Logprobs are the tokens of the reply with their log probabilities
+	Logprobs []*HTTPTokenLogprob `json:"logprobs,omitempty"`
+}
+
+type HTTPCompletion struct {
+	Content      string              `json:"content"`
+	TokenUsage   *HTTPTokenUsage     `json:"token_usage,omitempty"`
+	FinishReason string              `json:"finish_reason,omitempty"`
+	Logprobs     []*HTTPTokenLogprob `json:"logprobs,omitempty"`
+}
+
+type HTTPTokenLogprob struct {
+	Token       string              `json:"token"`
+	Logprob     float64             `json:"logprob"`
+	TopLogprobs []*HTTPTokenLogprob `json:"top_logprobs,omitempty"`
+}
+
+type HTTPToolInvocation struct {
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type HTTPSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability,omitempty"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+type HTTPTimings struct {
+	TotalMs      int64 `json:"total_ms"`
+	RetrievalMs  int64 `json:"retrieval_ms,omitempty"`
+	GenerationMs int64 `json:"generation_ms,omitempty"`
+	ToolMs       int64 `json:"tool_ms,omitempty"`
+}
+
+// Create HTTP handler for a v2 chat RPC
+func createHTTPHandlerV2(rpc func(context.Context, *genaidemo.ChatRequest) (*genaidemo.ChatResponseV2, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPChatRequest
+		if !decodeChatBody(w, r, &req) {
+			return
+		}
+
+		grpcResp, err := rpc(r.Context(), toGRPCChatRequest(&req))
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toHTTPChatResponseV2(grpcResp))
+	}
+}
+
+func toHTTPChatResponseV2(resp *genaidemo.ChatResponseV2) *HTTPChatResponseV2 {
+	response := &HTTPChatResponseV2{
+		HTTPChatResponse: *toHTTPChatResponse(&genaidemo.ChatResponse{
+			Content:        resp.Content,
+			TokenUsage:     resp.TokenUsage,
+			Audio:          resp.Audio,
+			EstimatedCost:  resp.EstimatedCost,
+			PromptTemplate: resp.PromptTemplate,
+			Variant:        resp.Variant,
+			Sources:        resp.Sources,
+			Language:       resp.Language,
+			Mode:           resp.Mode,
+			DegradedReason: resp.DegradedReason,
+			Rollout:        resp.Rollout,
+			Warnings:       resp.Warnings,
+			Candidates:     resp.Candidates,
+			Seed:           resp.Seed,
+			Model:          resp.Model,
+			ModelVersion:   resp.ModelVersion,
+			FinishReason:   resp.FinishReason,
+			SafetyBlock:    resp.SafetyBlock,
+		}),
+		Cached: resp.Cached,
+	}
+	for _, invocation := range resp.ToolInvocations {
+		response.ToolInvocations = append(response.ToolInvocations, &HTTPToolInvocation{
+			Name:       invocation.Name,
+			Arguments:  invocation.Arguments,
+			Result:     invocation.Result,
+			Error:      invocation.Error,
+			DurationMs: invocation.DurationMs,
+		})
+	}
+	for _, rating := range resp.SafetyRatings {
+		response.SafetyRatings = append(response.SafetyRatings, &HTTPSafetyRating{
+			Category:    rating.Category,
+			Probability: rating.Probability,
+			Blocked:     rating.Blocked,
+		})
+	}
+	for _, completion := range resp.Completions {
+		c := &HTTPCompletion{Content: completion.Content, Logprobs: toHTTPLogprobs(completion.Logprobs)}
+		if usage := completion.TokenUsage; usage != nil {
+			c.TokenUsage = &HTTPTokenUsage{
+				InputTokens:   usage.InputTokenNum,
+				OutputTokens:  usage.OutputTokenNum,
+				TotalTokens:   usage.TotalTokenNum,
+				ContextTokens: usage.ContextTokenNum,
+				CachedTokens:  usage.CachedTokenNum,
+			}
+		}
+		if completion.FinishReason != genaidemo.FinishReason_FINISH_REASON_UNSPECIFIED {
+			c.FinishReason = completion.FinishReason.String()
+		}
+		response.Completions = append(response.Completions, c)
+	}
+	response.Logprobs = toHTTPLogprobs(resp.Logprobs)
+	if resp.Timings != nil {
+		response.Timings = &HTTPTimings{
+			TotalMs:      resp.Timings.TotalMs,
+			RetrievalMs:  resp.Timings.RetrievalMs,
+			GenerationMs: resp.Timings.GenerationMs,
+			ToolMs:       resp.Timings.ToolMs,
+		}
+	}
+	return response
+}
+
+// toHTTPLogprobs converts the token log probabilities of a reply
+func toHTTPLogprobs(logprobs []*genaidemo.TokenLogprob) []*HTTPTokenLogprob {
+	var out []*HTTPTokenLogprob
+	for _, logprob := range logprobs {
+		token := &HTTPTokenLogprob{Token: logprob.Token, Logprob: logprob.Logprob}
+		for _, top := range logprob.TopLogprobs {
+			token.TopLogprobs = append(token.TopLogprobs, &HTTPTokenLogprob{Token: top.Token, Logprob: top.Logprob})
+		}
+		out = append(out, token)
+	}
+	return out
+}
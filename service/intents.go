@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"expvar"
+	"log"
+	"regexp"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// intentMetrics counts the intents found per intent, the requests no
+// classifier could tell and the failed classifier calls, exported under
+// /api/metrics
+var intentMetrics = expvar.NewMap("intents")
+
+// Intents of chat requests
+const (
+	intentSmalltalk   = "smalltalk"
+	intentDocQuestion = "doc_question"
+	intentCalculation = "calculation"
+	intentLiveData    = "needs_live_data"
+	intentTask        = "task"
+	intentGeneral     = "general"
+)
+
+// unknownIntent is the {{.intent}} of system prompts when no classifier could
+// tell the intent of a request
+const unknownIntent = "unknown"
+
+// requestIntent is the intent of a chat request
+type requestIntent struct {
+	Name       string
+	Confidence float64
+	// Classifier is the name of the classifier that found it
+	Classifier string
+}
+
+// intentClassifier finds the intent of the latest user message of a chat
+// request, returning nil when it can't tell
+type intentClassifier interface {
+	name() string
+	classify(ctx context.Context, req *genaidemo.ChatRequest) (*requestIntent, error)
+}
+
+// intentClassifiers builds the classifiers that INTENT_CLASSIFIERS can name
+var intentClassifiers = map[string]func(h *Handler, cfg *serviceConfig) intentClassifier{
+	"heuristics": func(*Handler, *serviceConfig) intentClassifier {
+		return heuristicIntents{}
+	},
+	"model": func(h *Handler, cfg *serviceConfig) intentClassifier {
+		return &modelIntents{h: h, model: cmp.Or(cfg.intentModel, cfg.modelName), minConfidence: cfg.intentMinConfidence}
+	},
+}
+
+// newIntentClassifiers builds the classifiers named in the configuration, in
+// order
+func newIntentClassifiers(h *Handler, cfg *serviceConfig) []intentClassifier {
+	classifiers := make([]intentClassifier, len(cfg.intentClassifiers))
+	for i, name := range cfg.intentClassifiers {
+		classifiers[i] = intentClassifiers[name](h, cfg)
+	}
+	return classifiers
+}
+
+// intentRule recognizes an intent in the latest user message
+type intentRule struct {
+	intent string
+	re     *regexp.Regexp
+}
+
+// intentRules are checked in order of precedence: when several match, the
+// first one is the heuristics' guess
+var intentRules = []intentRule{
+	{intent: intentDocQuestion, re: regexp.MustCompile(`(?i)\b(?:documents?|docs|documentation|handbook|manual|polic(?:y|ies)|guidelines?|pdfs?|uploaded|knowledge base|wiki|according to)\b`)},
+	{intent: intentCalculation, re: regexp.MustCompile(`(?i)\d\s*[+*×÷^]\s*\d|\d\s+[-/]\s+\d|\d\s*% of\b|\b(?:calculate|compute|how much is|percent of|square root)\b`)},
+	{intent: intentLiveData, re: regexp.MustCompile(`(?i)\b(?:weather|forecast|news|latest|today|tonight|right now|current(?:ly)?|stock price|exchange rate|search the web|look up)\b`)},
+	{intent: intentTask, re: regexp.MustCompile(`(?i)\b(?:step[- ]by[- ]step|plan (?:out|for|my|a|the)|research|investigate|break (?:it|this) down|first\b.+\bthen)\b`)},
+	{intent: intentSmalltalk, re: regexp.MustCompile(`(?i)^\W*(?:hi|hello|hey|thanks|thank you|good (?:morning|afternoon|evening)|how are you|bye|goodbye)\b`)},
+}
+
+// matchIntent returns the intent of a message by intentRules, and whether
+// the heuristics are sure of it: exactly one rule matches, or none does and
+// the message is too short to need more than small talk. Messages no rule
+// matches are guessed to be general questions.
+func matchIntent(query string) (string, bool) {
+	var matched []string
+	for _, rule := range intentRules {
+		if rule.re.MatchString(query) {
+			matched = append(matched, rule.intent)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		if len(strings.Fields(query)) <= 3 {
+			return intentSmalltalk, true
+		}
+		return intentGeneral, false
+	case 1:
+		return matched[0], true
+	default:
+		return matched[0], false
+	}
+}
+
+// heuristicIntents finds intents with intentRules, without any model call
+type heuristicIntents struct{}
+
+func (heuristicIntents) name() string { return "heuristics" }
+
+func (heuristicIntents) classify(_ context.Context, req *genaidemo.ChatRequest) (*requestIntent, error) {
+	intent, sure := matchIntent(latestUserMessage(req.Messages))
+	if !sure {
+		return nil, nil
+	}
+	return &requestIntent{Name: intent, Confidence: 1, Classifier: "heuristics"}, nil
+}
+
+// intentLabels describe the intents to the intent model
+var intentLabels = []llm.ClassLabel{
+	{Name: intentSmalltalk, Description: "greetings, thanks, chit-chat and other messages that need no information", Examples: []string{"Good morning!", "Thanks, that helped"}},
+	{Name: intentDocQuestion, Description: "asks about the organization's own documents, policies, manuals or uploaded files", Examples: []string{"How many vacation days do new employees get?"}},
+	{Name: intentCalculation, Description: "needs arithmetic or another calculation", Examples: []string{"What is 17.5% of 2,340?"}},
+	{Name: intentLiveData, Description: "needs current information from the web, such as news, weather or prices", Examples: []string{"Will it rain in Berlin tomorrow?"}},
+	{Name: intentTask, Description: "a complex, multi-step task that needs planning, research or several actions", Examples: []string{"Plan a three-day team offsite in Lisbon with a budget"}},
+	{Name: intentGeneral, Description: "any other question or request answerable from general knowledge, e.g. writing or explanations", Examples: []string{"Explain how vaccines work"}},
+}
+
+// modelIntents classifies requests with the intent model, as in Classify
+type modelIntents struct {
+	h     *Handler
+	model string
+	// minConfidence is the confidence below which the model's label is
+	// ignored
+	minConfidence float64
+}
+
+func (m *modelIntents) name() string { return "model" }
+
+func (m *modelIntents) classify(ctx context.Context, req *genaidemo.ChatRequest) (*requestIntent, error) {
+	query := latestUserMessage(req.Messages)
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	// Intent calls are provider calls too, released before the chat call
+	release, err := m.h.limiter.acquire(ctx, m.h.requestPriority(ctx, req))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	classification, usage, err := m.h.service.ClassifyText(withModelOverride(ctx, m.model), truncateUTF8(query, MaxClassifyTextLength), intentLabels)
+	if err != nil {
+		return nil, err
+	}
+	m.h.recordLLMUsage(ctx, m.model, usage)
+	if classification.Confidence < m.minConfidence {
+		return nil, nil
+	}
+	return &requestIntent{Name: classification.Label, Confidence: classification.Confidence, Classifier: "model"}, nil
+}
+
+// classifyIntent runs the configured intent classifiers in order until one
+// tells the intent of a request, and attaches it to the context. Requests
+// whose intent was classified already, e.g. by ChatAuto, are left as they
+// are. Classifier failures only leave the intent to the next classifier.
+func (h *Handler) classifyIntent(ctx context.Context, req *genaidemo.ChatRequest) context.Context {
+	if len(h.intents) == 0 {
+		return ctx
+	}
+	if _, done := ctx.Value(intentKey{}).(*requestIntent); done {
+		return ctx
+	}
+
+	var intent *requestIntent
+	for _, c := range h.intents {
+		found, err := c.classify(ctx, req)
+		if err != nil {
+			intentMetrics.Add("errors", 1)
+			log.Printf("⚠️ [intent] Classifier %s failed: %v", c.name(), err)
+			continue
+		}
+		if found != nil {
+			intent = found
+			break
+		}
+	}
+	if intent != nil {
+		intentMetrics.Add(intent.Name, 1)
+		log.Printf("🎯 [intent] Classified as %s (%.2f) by %s", intent.Name, intent.Confidence, intent.Classifier)
+	} else {
+		intentMetrics.Add(unknownIntent, 1)
+	}
+	return context.WithValue(ctx, intentKey{}, intent)
+}
+
+type intentKey struct{}
+
+// intentFromContext returns the intent of the request, nil when it is
+// unknown or wasn't classified
+func intentFromContext(ctx context.Context) *requestIntent {
+	intent, _ := ctx.Value(intentKey{}).(*requestIntent)
+	return intent
+}
+
+// intentVariable returns the intent of ctx for system prompt templates
+func intentVariable(ctx context.Context) string {
+	if intent := intentFromContext(ctx); intent != nil {
+		return intent.Name
+	}
+	return unknownIntent
+}
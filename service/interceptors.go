@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"log"
+	"path"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMetrics counts requests, errors per status code and latency per gRPC
+// method, exported under /api/metrics
+var grpcMetrics = expvar.NewMap("grpc")
+
+// requestIDHeader carries the request id in gRPC metadata, lower cased like
+// all metadata keys
+const requestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+// withRequestID attaches the id of the current request to the context
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the id of the current request, if any
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random 64-bit hex request id
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// incomingRequestID returns the caller's x-request-id, or a new id
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	return newRequestID()
+}
+
+// newGRPCServer creates the gRPC server with the interceptor chain applied to
+// every method, so handler methods don't deal with cross-cutting concerns.
+// Interceptors run in order: request id, error details, logging, metrics,
+// panic recovery, timeout, authentication and rate limiting, mirroring the
+// HTTP middleware.
+func newGRPCServer(handler *Handler, cfg *serviceConfig) *grpc.Server {
+	auth := &grpcAuth{allowedKeys: cfg.apiKeys, jwt: handler.jwt, limiter: handler.rateLimit, tenants: handler.tenants, acl: handler.acl}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			requestIDUnaryInterceptor,
+			errorDetailsUnaryInterceptor,
+			loggingUnaryInterceptor,
+			metricsUnaryInterceptor,
+			recoveryUnaryInterceptor,
+			timeoutUnaryInterceptor(cfg.requestTimeout),
+			drainUnaryInterceptor(handler),
+			auth.unary,
+		),
+		grpc.ChainStreamInterceptor(
+			requestIDStreamInterceptor,
+			errorDetailsStreamInterceptor,
+			loggingStreamInterceptor,
+			metricsStreamInterceptor,
+			recoveryStreamInterceptor,
+			timeoutStreamInterceptor(cfg.requestTimeout, cfg.uploadTimeout),
+			drainStreamInterceptor(handler),
+			auth.stream,
+		),
+	)
+	genaidemo.RegisterChatServiceServer(server, handler)
+	genaidemo.RegisterChatServiceV2Server(server, &handlerV2{handler: handler})
+	healthpb.RegisterHealthServer(server, handler.health.grpc)
+	return server
+}
+
+// contextStream overrides the context of a server stream, letting stream
+// interceptors pass values on to the handler
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// requestIDUnaryInterceptor tags the request with the caller's request id, or
+// a new one, and returns it in the x-request-id response header
+func requestIDUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id := incomingRequestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	return handler(withRequestID(ctx, id), req)
+}
+
+func requestIDStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id := incomingRequestID(ss.Context())
+	ss.SetHeader(metadata.Pairs(requestIDHeader, id))
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withRequestID(ss.Context(), id)})
+}
+
+// errorDetailsUnaryInterceptor attaches the error taxonomy details (reason,
+// request id) to returned errors, the gRPC counterpart of the HTTP error
+// envelope
+func errorDetailsUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, errorStatus(ctx, err).Err()
+	}
+	return resp, nil
+}
+
+func errorDetailsStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := handler(srv, ss); err != nil {
+		return errorStatus(ss.Context(), err).Err()
+	}
+	return nil
+}
+
+// loggingUnaryInterceptor logs one key=value line per call with its outcome
+func loggingUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logGRPCCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func loggingStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logGRPCCall(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func logGRPCCall(ctx context.Context, fullMethod string, start time.Time, err error) {
+	code := errorCode(err)
+	icon := "📡"
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		icon = "🚫"
+	case err != nil:
+		icon = "❌"
+	}
+	log.Printf("%s grpc method=%s code=%s duration_ms=%d request_id=%s key_id=%s",
+		icon, path.Base(fullMethod), code, time.Since(start).Milliseconds(), requestIDFromContext(ctx), apiKeyIDFromContext(ctx))
+}
+
+// metricsUnaryInterceptor records requests, errors and latency per method.
+// Calls cancelled by the client count as cancelled rather than errors.
+func metricsUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	recordGRPCCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func metricsStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	recordGRPCCall(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func recordGRPCCall(ctx context.Context, fullMethod string, start time.Time, err error) {
+	prefix := path.Base(fullMethod) + "."
+	grpcMetrics.Add(prefix+"requests", 1)
+	grpcMetrics.Add(prefix+"latency_ms", time.Since(start).Milliseconds())
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		grpcMetrics.Add(prefix+"cancelled", 1)
+	case err != nil:
+		grpcMetrics.Add(prefix+"errors."+errorCode(err).String(), 1)
+	}
+}
+
+// recoveryUnaryInterceptor turns a panic in a handler into an Internal error
+// instead of crashing the server
+func recoveryUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(ctx, info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func recoveryStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(ss.Context(), info.FullMethod, r)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+func recoveredError(ctx context.Context, fullMethod string, r any) error {
+	grpcMetrics.Add("panics", 1)
+	log.Printf("💥 grpc method=%s request_id=%s panic: %v\n%s", path.Base(fullMethod), requestIDFromContext(ctx), r, debug.Stack())
+	return status.Error(codes.Internal, "internal error")
+}
+
+// timeoutUnaryInterceptor bounds the call context by timeout, on top of any
+// client deadline. A zero timeout disables it.
+func timeoutUnaryInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// timeoutStreamInterceptor bounds document uploads by uploadTimeout instead,
+// as large documents take longer than a request to send and ingest
+func timeoutStreamInterceptor(timeout, uploadTimeout time.Duration) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		timeout := timeout
+		if info.FullMethod == uploadDocumentMethod {
+			timeout = uploadTimeout
+		}
+		if timeout <= 0 {
+			return handler(srv, ss)
+		}
+		ctx, cancel := context.WithTimeout(ss.Context(), timeout)
+		defer cancel()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// grpcAuth resolves the caller's API key or bearer token, tenant, document
+// access, end user and priority from the x-api-key, authorization,
+// x-tenant-id, x-user-groups, x-user-roles, x-user-id and x-priority
+// metadata, rejecting keys that aren't allowed, invalid bearer tokens and
+// callers over their request rate
+type grpcAuth struct {
+	allowedKeys map[string]bool
+	jwt         *jwtVerifier
+	limiter     *rateLimiter
+	tenants     *tenantRegistry
+	acl         *aclRegistry
+}
+
+// healthServicePrefix prefixes the methods of the standard health service,
+// which probes call without an API key, like /healthz and /readyz on HTTP
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+func (a *grpcAuth) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+		return handler(ctx, req)
+	}
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *grpcAuth) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+		return handler(srv, ss)
+	}
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate returns the context carrying the caller's key id, tenant,
+// document access, end user and requested priority, Unauthenticated when the
+// key isn't allowed or the bearer token invalid, PermissionDenied when it may
+// not act for the requested tenant or end user, InvalidArgument when the user
+// id is malformed or ResourceExhausted when it or its tenant is over its rate
+func (a *grpcAuth) authenticate(ctx context.Context) (context.Context, error) {
+	keyID, err := callerKeyID(a.allowedKeys, a.jwt, apiKeyIDFromContext(ctx), authorizationFromMetadata(ctx))
+	if err != nil {
+		return nil, err
+	}
+	t, err := a.tenants.resolve(keyID, tenantIDFromMetadata(ctx))
+	if err != nil {
+		return nil, err
+	}
+	groups, roles := userFromMetadata(ctx)
+	access, err := a.acl.resolve(keyID, groups, roles)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := parseUserID(userIDFromMetadata(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := a.limiter.allow(keyID); err != nil {
+		return nil, err
+	}
+
+	ctx = withDocumentAccess(withTenant(withAPIKeyID(ctx, keyID), t), access)
+	if userID != "" {
+		ctx = withUserID(ctx, userID)
+	}
+	if p, ok := priorityFromContext(ctx); ok {
+		ctx = withPriority(ctx, p)
+	}
+	return ctx, nil
+}
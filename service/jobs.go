@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// jobRunner executes a chat request in the given mode
+type jobRunner func(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error)
+
+// job is the internal state of an asynchronous chat job
+type job struct {
+	id         string
+	apiKeyID   string
+	tenant     *tenant
+	access     *documentAccess
+	userID     string
+	priority   genaidemo.Priority
+	mode       genaidemo.Mode
+	request    *genaidemo.ChatRequest
+	status     genaidemo.JobStatus
+	response   *genaidemo.ChatResponse
+	err        string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// jobQueue runs submitted chat jobs on a bounded worker pool, higher priority
+// jobs first, and keeps their results around for polling until the retention
+// period expires
+type jobQueue struct {
+	mu     sync.RWMutex
+	jobs   map[string]*job
+	queues [priorityTiers]chan *job
+	// pending holds one token per queued job, workers wait on it
+	pending   chan struct{}
+	run       jobRunner
+	timeout   time.Duration
+	retention time.Duration
+
+	// draining is set once the queue stops accepting jobs for shutdown
+	draining bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newJobQueue creates a job queue and starts its workers
+func newJobQueue(workers, queueSize int, timeout, retention time.Duration, run jobRunner) *jobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &jobQueue{
+		jobs:      make(map[string]*job),
+		pending:   make(chan struct{}, queueSize),
+		run:       run,
+		timeout:   timeout,
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	for i := range q.queues {
+		q.queues[i] = make(chan *job, queueSize)
+	}
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	q.wg.Add(1)
+	go q.janitor()
+
+	log.Printf("📋 Job queue started with %d workers (queue size %d)", workers, queueSize)
+	return q
+}
+
+// submit enqueues a chat request on behalf of the caller of ctx, keeping its
+// API key, tenant, document access and end user for the job to run as, and
+// returns the queued job
+func (q *jobQueue) submit(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (*genaidemo.Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate job id: %v", err)
+	}
+
+	j := &job{
+		id:        id,
+		apiKeyID:  apiKeyIDFromContext(ctx),
+		tenant:    tenantFromContext(ctx),
+		access:    documentAccessFromContext(ctx),
+		userID:    userIDFromContext(ctx),
+		priority:  req.Priority,
+		mode:      mode,
+		request:   req,
+		status:    genaidemo.JobStatus_JOB_STATUS_QUEUED,
+		createdAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.draining {
+		return nil, status.Error(codes.Unavailable, "service is draining, submit the job to another instance")
+	}
+	// Only submit adds tokens, and it holds the lock, so neither send blocks
+	if len(q.pending) == cap(q.pending) {
+		return nil, status.Error(codes.ResourceExhausted, "job queue is full, try again later")
+	}
+	q.queues[priorityTier(req.Priority)] <- j
+	q.pending <- struct{}{}
+	q.jobs[id] = j
+
+	log.Printf("📥 [jobQueue] Job %s queued (%s, %s)", id, mode, req.Priority)
+	return j.toProto(), nil
+}
+
+// get returns a snapshot of the job with the given id, only to the API key
+// and tenant that submitted it
+func (q *jobQueue) get(id, apiKeyID, tenantID string) (*genaidemo.Job, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	j, ok := q.jobs[id]
+	if !ok || j.apiKeyID != apiKeyID || j.tenantID() != tenantID {
+		return nil, false
+	}
+	return j.toProto(), true
+}
+
+// active returns the number of queued and running jobs
+func (q *jobQueue) active() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	n := 0
+	for _, j := range q.jobs {
+		if j.status == genaidemo.JobStatus_JOB_STATUS_QUEUED || j.status == genaidemo.JobStatus_JOB_STATUS_RUNNING {
+			n++
+		}
+	}
+	return n
+}
+
+// drain stops accepting jobs and waits until the queued and running ones
+// have finished or ctx is done
+func (q *jobQueue) drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := q.active()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d jobs unfinished: %w", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// close stops accepting work and waits for running jobs to finish
+func (q *jobQueue) close() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+func (q *jobQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.pending:
+			q.process(q.dequeue())
+		}
+	}
+}
+
+// dequeue takes the next job from the highest priority tier. Each pending
+// token guarantees a queued job.
+func (q *jobQueue) dequeue() *job {
+	for {
+		for _, queue := range q.queues {
+			select {
+			case j := <-queue:
+				return j
+			default:
+			}
+		}
+	}
+}
+
+func (q *jobQueue) process(j *job) {
+	q.mu.Lock()
+	j.status = genaidemo.JobStatus_JOB_STATUS_RUNNING
+	j.startedAt = time.Now()
+	q.mu.Unlock()
+
+	log.Printf("⚙️ [jobQueue] Job %s started", j.id)
+
+	ctx := withTenant(withAPIKeyID(withJobID(q.ctx, j.id), j.apiKeyID), j.tenant)
+	ctx = withUserID(withDocumentAccess(ctx, j.access), j.userID)
+	ctx = withPriority(ctx, j.priority)
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	resp, err := q.run(ctx, j.mode, j.request)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j.finishedAt = time.Now()
+	if err != nil {
+		j.status = genaidemo.JobStatus_JOB_STATUS_FAILED
+		j.err = err.Error()
+		log.Printf("❌ [jobQueue] Job %s failed after %v: %v", j.id, j.finishedAt.Sub(j.startedAt), err)
+		return
+	}
+
+	j.status = genaidemo.JobStatus_JOB_STATUS_SUCCEEDED
+	j.response = resp
+	log.Printf("✅ [jobQueue] Job %s completed in %v", j.id, j.finishedAt.Sub(j.startedAt))
+}
+
+// janitor removes finished jobs once their retention period has expired
+func (q *jobQueue) janitor() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			for id, j := range q.jobs {
+				if !j.finishedAt.IsZero() && now.Sub(j.finishedAt) > q.retention {
+					delete(q.jobs, id)
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// tenantID returns the id of the tenant that submitted the job, empty
+// without tenants
+func (j *job) tenantID() string {
+	if j.tenant != nil {
+		return j.tenant.id
+	}
+	return ""
+}
+
+// toProto converts the job into its API representation. Callers must hold
+// the queue lock.
+func (j *job) toProto() *genaidemo.Job {
+	pb := &genaidemo.Job{
+		JobId:     j.id,
+		Status:    j.status,
+		Error:     j.err,
+		CreatedAt: j.createdAt.Unix(),
+	}
+	if j.response != nil {
+		pb.Response = proto.Clone(j.response).(*genaidemo.ChatResponse)
+	}
+	if !j.startedAt.IsZero() {
+		pb.StartedAt = j.startedAt.Unix()
+	}
+	if !j.finishedAt.IsZero() {
+		pb.FinishedAt = j.finishedAt.Unix()
+	}
+	return pb
+}
+
+// newJobID returns a random 128-bit hex job id
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type jobIDKey struct{}
+
+// withJobID attaches the id of the running job to the context
+func withJobID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobIDKey{}, id)
+}
+
+// jobIDFromContext returns the id of the running job, if any
+func jobIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(jobIDKey{}).(string)
+	return id
+}
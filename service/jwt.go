@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// authorizationHeader carries a bearer token on HTTP requests and, lower
+	// cased, in gRPC metadata
+	authorizationHeader = "Authorization"
+	// jwtKeyIDPrefix prefixes the subject of a bearer token to form the
+	// caller's key id, e.g. jwt:billing-service
+	jwtKeyIDPrefix = "jwt:"
+	// maxJWTSubjectLength limits the subject of a bearer token
+	maxJWTSubjectLength = 128
+)
+
+// jwtVerifier verifies HS256-signed JWTs presented as bearer tokens
+type jwtVerifier struct {
+	secret []byte
+}
+
+// newJWTVerifier creates a verifier for tokens signed with secret, nil when
+// the secret is empty and bearer tokens aren't accepted
+func newJWTVerifier(secret string) *jwtVerifier {
+	if secret == "" {
+		return nil
+	}
+	log.Printf("🔑 Bearer tokens (HS256 JWTs) accepted")
+	return &jwtVerifier{secret: []byte(secret)}
+}
+
+// jwtClaims are the claims of a bearer token the service checks
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt *int64 `json:"exp"`
+	NotBefore *int64 `json:"nbf"`
+}
+
+// verify checks the signature and validity period of a token and returns its
+// subject. Tokens must expire; the subject can't contain the separators of
+// the key id lists in the configuration.
+func (v *jwtVerifier) verify(token string) (string, error) {
+	if v == nil {
+		return "", status.Error(codes.Unauthenticated, "bearer tokens are not accepted")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", status.Error(codes.Unauthenticated, "malformed bearer token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil || header.Algorithm != "HS256" {
+		return "", status.Error(codes.Unauthenticated, "bearer token must be signed with HS256")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "malformed bearer token")
+	}
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", status.Error(codes.Unauthenticated, "invalid bearer token signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return "", status.Error(codes.Unauthenticated, "malformed bearer token")
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt == nil || now >= *claims.ExpiresAt {
+		return "", status.Error(codes.Unauthenticated, "bearer token expired or without expiry")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return "", status.Error(codes.Unauthenticated, "bearer token not valid yet")
+	}
+	if claims.Subject == "" || len(claims.Subject) > maxJWTSubjectLength || strings.ContainsAny(claims.Subject, ",=") {
+		return "", status.Error(codes.Unauthenticated, "invalid bearer token subject")
+	}
+	return claims.Subject, nil
+}
+
+// decodeJWTSegment decodes a base64url-encoded JSON segment of a token
+func decodeJWTSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// callerKeyID returns the key id of a caller with the given API key id and
+// Authorization value. A valid bearer token identifies the caller as
+// jwt:<subject>, whatever the allowed keys; without one, the API key must be
+// allowed.
+func callerKeyID(allowedKeys map[string]bool, verifier *jwtVerifier, keyID, authorization string) (string, error) {
+	if authorization == "" {
+		return keyID, checkAPIKey(allowedKeys, keyID)
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "unsupported authorization scheme")
+	}
+	subject, err := verifier.verify(token)
+	if err != nil {
+		return "", err
+	}
+	return jwtKeyIDPrefix + subject, nil
+}
+
+// authorizationFromMetadata returns the authorization metadata of a gRPC
+// request, "" when none
+func authorizationFromMetadata(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(authorizationHeader); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// knowledgeGraphMetrics counts extraction calls, their failures, the
+// relations learned, and the traversals of ChatWithDoc requests with the
+// relations they injected, exported under /api/metrics
+var knowledgeGraphMetrics = expvar.NewMap("knowledge_graph")
+
+// graphSourceConversation is the source of relations learned from chats
+const graphSourceConversation = "conversation"
+
+// graphPromptHeader introduces the relations appended to the document
+// context of ChatWithDoc requests
+const graphPromptHeader = "--- Knowledge graph (relations from earlier conversations and documents, today is %s) ---"
+
+// graphEntity is an entity of a tenant's knowledge graph
+type graphEntity struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Mentions  int       `json:"mentions"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// graphRelation relates two entities, named by their keys
+type graphRelation struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject"`
+	Predicate string    `json:"predicate"`
+	Object    string    `json:"object"`
+	Date      string    `json:"date,omitempty"`
+	Source    string    `json:"source"`
+	LearnedAt time.Time `json:"learned_at"`
+}
+
+// tenantGraph is the knowledge graph of a tenant. Entities are keyed by
+// their case-folded names and only kept while a relation refers to them.
+type tenantGraph struct {
+	Entities  map[string]*graphEntity `json:"entities"`
+	Relations []*graphRelation        `json:"relations"`
+}
+
+// knowledgeGraph keeps the entities and relations learned from
+// conversations and text documents, per tenant, optionally persisting them
+// to a JSON file so they survive restarts. Graphs, entities and relations
+// are immutable once stored; updates replace them.
+type knowledgeGraph struct {
+	mu     sync.RWMutex
+	graphs map[string]*tenantGraph
+	path   string
+	// tokenBudget bounds the tokens of the relations injected into a request
+	tokenBudget int
+	// pending tracks the extractions running in the background
+	pending sync.WaitGroup
+}
+
+// newKnowledgeGraph creates a knowledge graph, loading existing graphs from
+// path when it is set. A zero tokenBudget disables the knowledge graph.
+func newKnowledgeGraph(path string, tokenBudget int) (*knowledgeGraph, error) {
+	g := &knowledgeGraph{
+		graphs:      make(map[string]*tenantGraph),
+		path:        path,
+		tokenBudget: tokenBudget,
+	}
+	if path == "" {
+		return g, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read knowledge graph: %w", err)
+	}
+	if err := json.Unmarshal(data, &g.graphs); err != nil {
+		return nil, fmt.Errorf("failed to parse knowledge graph: %w", err)
+	}
+
+	relations := 0
+	for _, tg := range g.graphs {
+		relations += len(tg.Relations)
+	}
+	log.Printf("🕸️ Loaded %d relations of %d tenants from %s", relations, len(g.graphs), path)
+	return g, nil
+}
+
+// enabled reports whether requests use and update the knowledge graph
+func (g *knowledgeGraph) enabled() bool {
+	return g.tokenBudget > 0
+}
+
+// add merges the relations extracted from a source into a tenant's graph
+// and returns how many were learned or relearned. Relations already known are
+// relearned, keeping their date unless the source states a new one. Beyond
+// MaxGraphRelations, the relations learned least recently are dropped.
+func (g *knowledgeGraph) add(tenant string, extraction *llm.GraphExtraction, source string) (int, error) {
+	if len(extraction.Relations) == 0 {
+		return 0, nil
+	}
+	types := make(map[string]string, len(extraction.Entities))
+	for _, e := range extraction.Entities {
+		types[graphEntityKey(e.Name)] = e.Type
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	old := g.graphs[tenant]
+	next := &tenantGraph{Entities: make(map[string]*graphEntity)}
+	if old != nil {
+		next.Entities = maps.Clone(old.Entities)
+		next.Relations = slices.Clone(old.Relations)
+	}
+	now := time.Now()
+	mention := func(name string) string {
+		key := graphEntityKey(name)
+		e := &graphEntity{Name: name, Type: cmp.Or(types[key], "concept"), Mentions: 1, FirstSeen: now, LastSeen: now}
+		if known := next.Entities[key]; known != nil {
+			e = &graphEntity{Name: known.Name, Type: cmp.Or(types[key], known.Type), Mentions: known.Mentions + 1, FirstSeen: known.FirstSeen, LastSeen: now}
+		}
+		next.Entities[key] = e
+		return key
+	}
+
+	changed := 0
+	for _, r := range extraction.Relations {
+		subject, object := mention(r.Subject), mention(r.Object)
+		i := slices.IndexFunc(next.Relations, func(known *graphRelation) bool {
+			return known.Subject == subject && known.Object == object && known.Predicate == r.Predicate
+		})
+		if i >= 0 {
+			known := next.Relations[i]
+			next.Relations[i] = &graphRelation{
+				ID:        known.ID,
+				Subject:   subject,
+				Predicate: r.Predicate,
+				Object:    object,
+				Date:      cmp.Or(r.Date, known.Date),
+				Source:    source,
+				LearnedAt: now,
+			}
+			changed++
+			continue
+		}
+		id, err := newJobID()
+		if err != nil {
+			return 0, status.Errorf(codes.Internal, "failed to generate relation id: %v", err)
+		}
+		next.Relations = append(next.Relations, &graphRelation{
+			ID:        id,
+			Subject:   subject,
+			Predicate: r.Predicate,
+			Object:    object,
+			Date:      r.Date,
+			Source:    source,
+			LearnedAt: now,
+		})
+		changed++
+	}
+	if len(next.Relations) > MaxGraphRelations {
+		slices.SortStableFunc(next.Relations, func(a, b *graphRelation) int {
+			return b.LearnedAt.Compare(a.LearnedAt)
+		})
+		next.Relations = next.Relations[:MaxGraphRelations]
+		next.pruneEntities()
+	}
+
+	g.graphs[tenant] = next
+	if err := g.save(); err != nil {
+		g.restore(tenant, old)
+		return 0, err
+	}
+	knowledgeGraphMetrics.Add("relations_learned", int64(changed))
+	return changed, nil
+}
+
+// entity returns an entity of a tenant's graph with its relations, newest
+// first
+func (g *knowledgeGraph) entity(tenant, name string) (*genaidemo.GraphEntity, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	key := graphEntityKey(name)
+	tg := g.graphs[tenant]
+	if tg == nil || tg.Entities[key] == nil {
+		return nil, status.Errorf(codes.NotFound, "entity %q not found", name)
+	}
+	e := tg.Entities[key]
+	entity := &genaidemo.GraphEntity{
+		Name:      e.Name,
+		Type:      e.Type,
+		Mentions:  int32(e.Mentions),
+		FirstSeen: e.FirstSeen.Unix(),
+		LastSeen:  e.LastSeen.Unix(),
+	}
+	for _, r := range tg.Relations {
+		if r.Subject == key || r.Object == key {
+			entity.Relations = append(entity.Relations, tg.toProto(r))
+		}
+	}
+	slices.SortStableFunc(entity.Relations, func(a, b *genaidemo.GraphRelation) int {
+		return cmp.Compare(b.LearnedAt, a.LearnedAt)
+	})
+	return entity, nil
+}
+
+// forget deletes an entity of a tenant's graph with its relations, and
+// returns how many relations were deleted
+func (g *knowledgeGraph) forget(tenant, name string) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := graphEntityKey(name)
+	old := g.graphs[tenant]
+	if old == nil || old.Entities[key] == nil {
+		return 0, status.Errorf(codes.NotFound, "entity %q not found", name)
+	}
+	next := &tenantGraph{
+		Entities: maps.Clone(old.Entities),
+		Relations: slices.DeleteFunc(slices.Clone(old.Relations), func(r *graphRelation) bool {
+			return r.Subject == key || r.Object == key
+		}),
+	}
+	next.pruneEntities()
+	if len(next.Relations) == 0 {
+		delete(g.graphs, tenant)
+	} else {
+		g.graphs[tenant] = next
+	}
+	if err := g.save(); err != nil {
+		g.restore(tenant, old)
+		return 0, err
+	}
+	return len(old.Relations) - len(next.Relations), nil
+}
+
+// prompt returns the relations of a tenant's graph around the entities the
+// query mentions, for the document context of ChatWithDoc, or "" when the
+// query mentions no known entity. The graph is traversed up to MaxGraphHops
+// from the mentioned entities; the closest relations come first, newest
+// first among equally close ones, within the token budget.
+func (g *knowledgeGraph) prompt(tenant, query, model string) string {
+	g.mu.RLock()
+	tg := g.graphs[tenant]
+	g.mu.RUnlock()
+	if tg == nil {
+		return ""
+	}
+
+	query = strings.ToLower(query)
+	hops := make(map[string]int)
+	var frontier []string
+	for key := range tg.Entities {
+		if mentionsEntity(query, key) {
+			hops[key] = 0
+			frontier = append(frontier, key)
+		}
+	}
+	if len(frontier) == 0 {
+		return ""
+	}
+	knowledgeGraphMetrics.Add("traversals", 1)
+
+	// Breadth-first over the relations in both directions; a relation is
+	// as close as the closest entity it relates
+	distance := make(map[*graphRelation]int)
+	for hop := 0; hop < MaxGraphHops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, r := range tg.Relations {
+			if _, seen := distance[r]; seen {
+				continue
+			}
+			subjectHop, subjectSeen := hops[r.Subject]
+			objectHop, objectSeen := hops[r.Object]
+			if !(subjectSeen && subjectHop == hop) && !(objectSeen && objectHop == hop) {
+				continue
+			}
+			distance[r] = hop
+			for _, key := range []string{r.Subject, r.Object} {
+				if _, seen := hops[key]; !seen {
+					hops[key] = hop + 1
+					next = append(next, key)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	relations := slices.Collect(maps.Keys(distance))
+	slices.SortFunc(relations, func(a, b *graphRelation) int {
+		return cmp.Or(cmp.Compare(distance[a], distance[b]), b.LearnedAt.Compare(a.LearnedAt), strings.Compare(a.ID, b.ID))
+	})
+
+	header := fmt.Sprintf(graphPromptHeader, time.Now().Format(time.DateOnly))
+	var b strings.Builder
+	b.WriteString(header)
+	remaining := g.tokenBudget - llm.CountTokens(model, header)
+	included := 0
+	for _, r := range relations {
+		line := "\n- " + tg.describe(r)
+		tokens := llm.CountTokens(model, line)
+		if tokens > remaining {
+			continue
+		}
+		remaining -= tokens
+		b.WriteString(line)
+		included++
+	}
+	if included == 0 {
+		return ""
+	}
+	knowledgeGraphMetrics.Add("relations_injected", int64(included))
+	return b.String()
+}
+
+// close waits for the extractions running in the background
+func (g *knowledgeGraph) close() {
+	g.pending.Wait()
+}
+
+// restore puts back the graph of a tenant after a failed save. Callers must
+// hold the lock.
+func (g *knowledgeGraph) restore(tenant string, old *tenantGraph) {
+	if old == nil {
+		delete(g.graphs, tenant)
+		return
+	}
+	g.graphs[tenant] = old
+}
+
+// save writes all graphs to the store file. Callers must hold the lock.
+func (g *knowledgeGraph) save() error {
+	if g.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(g.graphs, "", "  ")
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to marshal knowledge graph: %v", err)
+	}
+	if err := writeFileAtomic(g.path, data); err != nil {
+		return status.Errorf(codes.Internal, "failed to save knowledge graph: %v", err)
+	}
+	return nil
+}
+
+// pruneEntities drops the entities no relation refers to anymore
+func (tg *tenantGraph) pruneEntities() {
+	referenced := make(map[string]bool, len(tg.Entities))
+	for _, r := range tg.Relations {
+		referenced[r.Subject] = true
+		referenced[r.Object] = true
+	}
+	maps.DeleteFunc(tg.Entities, func(key string, _ *graphEntity) bool {
+		return !referenced[key]
+	})
+}
+
+// describe renders a relation for the model, e.g. "Project Atlas → decided
+// to use → PostgreSQL (on 2026-09-12; learned 2026-09-14 from design.md)"
+func (tg *tenantGraph) describe(r *graphRelation) string {
+	when := "learned " + r.LearnedAt.Format(time.DateOnly) + " from " + r.Source
+	if r.Date != "" {
+		when = "on " + r.Date + "; " + when
+	}
+	return fmt.Sprintf("%s → %s → %s (%s)", tg.Entities[r.Subject].Name, r.Predicate, tg.Entities[r.Object].Name, when)
+}
+
+func (tg *tenantGraph) toProto(r *graphRelation) *genaidemo.GraphRelation {
+	return &genaidemo.GraphRelation{
+		Id:        r.ID,
+		Subject:   tg.Entities[r.Subject].Name,
+		Predicate: r.Predicate,
+		Object:    tg.Entities[r.Object].Name,
+		Date:      r.Date,
+		Source:    r.Source,
+		LearnedAt: r.LearnedAt.Unix(),
+	}
+}
+
+// graphEntityKey case-folds an entity name and collapses its whitespace
+func graphEntityKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// mentionsEntity reports whether a lower-cased query names an entity as
+// whole words. Keys shorter than 3 characters are ignored, they match too
+// much.
+func mentionsEntity(query, key string) bool {
+	if utf8.RuneCountInString(key) < 3 {
+		return false
+	}
+	for offset := 0; ; {
+		i := strings.Index(query[offset:], key)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(key)
+		before, _ := utf8.DecodeLastRuneInString(query[:start])
+		after, _ := utf8.DecodeRuneInString(query[end:])
+		if (start == 0 || isNotWordRune(before)) && (end == len(query) || isNotWordRune(after)) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// graphDocumentText returns the beginning of a document the knowledge graph
+// can learn from, "" unless it is plain text or markdown. Other formats are
+// only extracted by the ChromaDB service.
+func graphDocumentText(filename, contentType string, file io.ReadSeeker) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !strings.HasPrefix(mediaType, "text/") && ext != ".txt" && ext != ".md" && ext != ".markdown" {
+		return ""
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+	data, err := io.ReadAll(io.LimitReader(file, MaxGraphDocumentBytes))
+	if err != nil {
+		return ""
+	}
+	// The limit may cut a character in half
+	return strings.ToValidUTF8(string(data), "")
+}
+
+type graphContextKey struct{}
+
+// withGraphContext makes ChatWithDoc calls made with ctx add the knowledge
+// graph relations of prompt to the retrieved documents
+func withGraphContext(ctx context.Context, prompt string) context.Context {
+	return context.WithValue(ctx, graphContextKey{}, prompt)
+}
+
+// graphContextFromContext returns the knowledge graph relations of ctx, ""
+// when none
+func graphContextFromContext(ctx context.Context) string {
+	prompt, _ := ctx.Value(graphContextKey{}).(string)
+	return prompt
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// languageMetrics counts requests per requested or detected response
+// language, exported under /api/metrics
+var languageMetrics = expvar.NewMap("response_languages")
+
+// autoLanguage asks for the reply in the language of the latest user message
+const autoLanguage = "auto"
+
+// unknownLanguage fills the {{.language}} prompt variable when the language
+// couldn't be detected
+const unknownLanguage = "the same language as the user's question"
+
+type responseLanguageKey struct{}
+
+// withResponseLanguage makes system prompts built with ctx ask for replies
+// in the given language tag
+func withResponseLanguage(ctx context.Context, language string) context.Context {
+	return context.WithValue(ctx, responseLanguageKey{}, language)
+}
+
+// responseLanguageFromContext returns the response language tag of ctx, or
+// an empty string when unknown
+func responseLanguageFromContext(ctx context.Context) string {
+	language, _ := ctx.Value(responseLanguageKey{}).(string)
+	return language
+}
+
+// resolveResponseLanguage returns the language tag the reply should be in:
+// the requested one, or the one detected in the latest user message when the
+// request asks for "auto" or nothing. It is empty when detection fails.
+func resolveResponseLanguage(req *genaidemo.ChatRequest) (string, error) {
+	requested := strings.TrimSpace(req.GetResponseLanguage())
+	if requested != "" && !strings.EqualFold(requested, autoLanguage) {
+		language, ok := llm.NormalizeLanguage(requested)
+		if !ok {
+			return "", status.Errorf(codes.InvalidArgument, "invalid response language %q", requested)
+		}
+		languageMetrics.Add("requested."+language, 1)
+		return language, nil
+	}
+
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role != genaidemo.Role_ROLE_USER {
+			continue
+		}
+		if language := llm.DetectLanguage(req.Messages[i].Content); language != "" {
+			languageMetrics.Add("detected."+language, 1)
+			return language, nil
+		}
+		break
+	}
+	languageMetrics.Add("undetected", 1)
+	return "", nil
+}
+
+// languageVariable returns the value of the {{.language}} prompt variable
+func languageVariable(ctx context.Context) string {
+	if language := responseLanguageFromContext(ctx); language != "" {
+		return llm.LanguageName(language)
+	}
+	return unknownLanguage
+}
+
+// withLanguageInstruction appends an instruction to reply in the response
+// language of ctx to a system prompt, leaving it unchanged when the language
+// is unknown
+func withLanguageInstruction(ctx context.Context, prompt string) string {
+	language := responseLanguageFromContext(ctx)
+	if language == "" {
+		return prompt
+	}
+	instruction := fmt.Sprintf("Always respond in %s, whatever the language of the documents or earlier messages.", llm.LanguageName(language))
+	if prompt == "" {
+		return instruction
+	}
+	return prompt + "\n\n" + instruction
+}
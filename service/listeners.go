@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// unixAddrPrefix marks listen addresses that are Unix domain socket paths,
+// e.g. unix:/run/genai/grpc.sock
+const unixAddrPrefix = "unix:"
+
+// parseListenAddrs splits a comma-separated list of listen addresses, each a
+// TCP host:port (":8080" for every interface) or a unix:<path> socket
+func parseListenAddrs(value string) ([]string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(value, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+			if path == "" {
+				return nil, fmt.Errorf("invalid listen address %q: empty socket path", addr)
+			}
+		} else if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", addr, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no listen address in %q", value)
+	}
+	return addrs, nil
+}
+
+// listenAll opens a listener on each address, closing the ones already open
+// when one fails
+func listenAll(addrs []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		lis, err := listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
+
+// listen opens a TCP or Unix domain socket listener. A socket file left
+// behind by a previous run is removed first, unless a server still accepts
+// connections on it; the socket is removed again when the listener is
+// closed.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		log.Printf("🧹 Removed stale socket %s", path)
+	}
+	return net.Listen("unix", path)
+}
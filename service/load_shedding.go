@@ -0,0 +1,219 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// loadSheddingMetrics counts admitted and shed requests and exposes the
+// current limiter state, exported under /api/metrics
+var loadSheddingMetrics = expvar.NewMap("load_shedding")
+
+// latencyWindowSize is the number of recent provider calls used for the p95
+const latencyWindowSize = 100
+
+// overloadError rejects a request while the service is overloaded. It maps
+// to codes.Unavailable and carries the delay clients should wait before
+// retrying.
+type overloadError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *overloadError) Error() string {
+	return fmt.Sprintf("service overloaded (%s), retry after %v", e.reason, e.retryAfter)
+}
+
+// GRPCStatus lets status.Code and gRPC clients see the error as Unavailable,
+// with a RetryInfo detail carrying the retry delay
+func (e *overloadError) GRPCStatus() *status.Status {
+	return statusWithDetails(status.New(codes.Unavailable, e.Error()),
+		errorInfo(reasonOverloaded, map[string]string{"cause": e.reason}), retryInfo(e.retryAfter))
+}
+
+// concurrencyLimiter bounds the number of in-flight provider calls. Excess
+// requests wait for a slot, and freed slots go to the highest priority
+// waiter first. Low-priority requests are shed up front once the wait queue
+// or the recent p95 latency crosses its threshold, so the backlog drains
+// instead of every request timing out together.
+type concurrencyLimiter struct {
+	maxInFlight    int
+	queueThreshold int
+	latencyLimit   time.Duration
+
+	mu        sync.Mutex
+	inFlight  int
+	waiters   [priorityTiers]*list.List
+	latencies []time.Duration
+	next      int
+}
+
+// newConcurrencyLimiter creates a limiter allowing maxInFlight concurrent
+// calls. A zero maxInFlight disables the limiter; a zero latencyLimit
+// disables latency-based shedding.
+func newConcurrencyLimiter(maxInFlight, queueThreshold int, latencyLimit time.Duration) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		maxInFlight:    maxInFlight,
+		queueThreshold: queueThreshold,
+		latencyLimit:   latencyLimit,
+		latencies:      make([]time.Duration, 0, latencyWindowSize),
+	}
+	for i := range l.waiters {
+		l.waiters[i] = list.New()
+	}
+	if maxInFlight > 0 {
+		log.Printf("🚦 Concurrency limiter enabled (max in-flight %d, shed queue %d, shed p95 %v)",
+			maxInFlight, queueThreshold, latencyLimit)
+	}
+
+	loadSheddingMetrics.Set("in_flight", expvar.Func(func() any {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.inFlight
+	}))
+	loadSheddingMetrics.Set("waiting", expvar.Func(func() any {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.waitingLocked()
+	}))
+	loadSheddingMetrics.Set("p95_ms", expvar.Func(func() any { return l.p95().Milliseconds() }))
+	return l
+}
+
+// acquire waits for a free slot and returns the function releasing it. Low
+// priority requests are rejected with an overloadError instead of waiting
+// when the service is overloaded.
+func (l *concurrencyLimiter) acquire(ctx context.Context, priority genaidemo.Priority) (func(), error) {
+	if l.maxInFlight <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	if priority == genaidemo.Priority_PRIORITY_LOW {
+		if err := l.shouldShed(); err != nil {
+			l.mu.Unlock()
+			loadSheddingMetrics.Add("shed_"+err.reason, 1)
+			log.Printf("🚦 [concurrencyLimiter] Shedding low-priority request: %s", err.reason)
+			return nil, err
+		}
+	}
+
+	if l.inFlight < l.maxInFlight && l.waitingLocked() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+	} else {
+		// Wait in the queue of our tier; release hands the slot over directly
+		ready := make(chan struct{})
+		queue := l.waiters[priorityTier(priority)]
+		elem := queue.PushBack(ready)
+		l.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			l.mu.Lock()
+			select {
+			case <-ready:
+				// The slot was handed over while we gave up, pass it on
+				l.releaseLocked()
+			default:
+				queue.Remove(elem)
+			}
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	loadSheddingMetrics.Add("admitted", 1)
+	start := time.Now()
+	return func() {
+		l.observe(time.Since(start))
+		l.mu.Lock()
+		l.releaseLocked()
+		l.mu.Unlock()
+	}, nil
+}
+
+// releaseLocked hands a finished call's slot to the highest priority waiter,
+// or frees it when nobody waits. Must be called with l.mu held.
+func (l *concurrencyLimiter) releaseLocked() {
+	for _, queue := range l.waiters {
+		if front := queue.Front(); front != nil {
+			queue.Remove(front)
+			close(front.Value.(chan struct{}))
+			return
+		}
+	}
+	l.inFlight--
+}
+
+// waitingLocked returns the number of queued requests. Must be called with
+// l.mu held.
+func (l *concurrencyLimiter) waitingLocked() int {
+	waiting := 0
+	for _, queue := range l.waiters {
+		waiting += queue.Len()
+	}
+	return waiting
+}
+
+// shouldShed reports why a low-priority request should be shed, if at all.
+// Must be called with l.mu held.
+func (l *concurrencyLimiter) shouldShed() *overloadError {
+	if l.queueThreshold > 0 && l.waitingLocked() >= l.queueThreshold {
+		return &overloadError{reason: "queue", retryAfter: l.retryAfterLocked()}
+	}
+	if l.latencyLimit > 0 && len(l.latencies) > 0 {
+		if p95 := l.p95Locked(); p95 > l.latencyLimit {
+			return &overloadError{reason: "latency", retryAfter: l.retryAfterLocked()}
+		}
+	}
+	return nil
+}
+
+// retryAfterLocked suggests waiting roughly one p95 call, at least a second
+func (l *concurrencyLimiter) retryAfterLocked() time.Duration {
+	return max(time.Second, l.p95Locked().Round(time.Second))
+}
+
+// observe records the latency of a completed call
+func (l *concurrencyLimiter) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.latencies) < latencyWindowSize {
+		l.latencies = append(l.latencies, d)
+		return
+	}
+	l.latencies[l.next] = d
+	l.next = (l.next + 1) % latencyWindowSize
+}
+
+func (l *concurrencyLimiter) p95() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.p95Locked()
+}
+
+// p95Locked returns the 95th percentile of the recent call latencies. Must be
+// called with l.mu held.
+func (l *concurrencyLimiter) p95Locked() time.Duration {
+	if len(l.latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(l.latencies)
+	slices.Sort(sorted)
+	idx := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	return sorted[idx]
+}
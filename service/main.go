@@ -2,23 +2,207 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/frontend"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	serviceName = "genai-chat-service"
-	httpPort    = "8080"
+
+	// maxAudioBytes limits the size of audio uploads, raw or in JSON bodies,
+	// and of the audio attached to a chat message
+	maxAudioBytes = 20 << 20
 )
 
 type serviceConfig struct {
-	projectID string
-	location  string
-	modelName string
+	projectID       string
+	location        string
+	modelName       string
+	llmProvider     string
+	mockLLMScript   string
+	llmCassette     string
+	llmCassetteMode string
+	ttsLanguageCode string
+	ttsVoiceName    string
+	jobWorkers      int
+	jobQueueSize    int
+	jobTimeout      time.Duration
+	jobRetention    time.Duration
+
+	webhookSecret      string
+	webhookMaxAttempts int
+	webhookTimeout     time.Duration
+
+	// eventBus is pubsub, kafka or log; empty disables event publishing
+	eventBus        string
+	eventTopic      string
+	kafkaRESTURL    string
+	eventBufferSize int
+
+	promptTemplatesFile string
+	experimentsFile     string
+	rolloutsFile        string
+	fewShotExamplesFile string
+	fewShotTokenBudget  int
+	memoryTokenBudget   int
+	guardrailsFile      string
+	safetySettingsFile  string
+	capabilitiesFile    string
+	userMemoryFile      string
+	graphTokenBudget    int
+	knowledgeGraphFile  string
+	tenantsFile         string
+	aclFile             string
+
+	sessionEntityTokenBudget int
+	sessionEntityTTL         time.Duration
+
+	// sessionTitleModel generates session titles; none are generated when empty
+	sessionTitleModel string
+	sessionRetention  time.Duration
+	sessionsFile      string
+
+	analyticsInterval  time.Duration
+	analyticsRetention time.Duration
+	analyticsTopics    []string
+	analyticsFile      string
+
+	feedbackWindow time.Duration
+	feedbackFile   string
+
+	// exportDir holds dataset exports (the system temp directory when empty)
+	exportDir       string
+	exportRetention time.Duration
+
+	retryMaxAttempts    int
+	retryInitialBackoff time.Duration
+	retryMaxBackoff     time.Duration
+
+	breakerFailureThreshold int
+	breakerOpenTimeout      time.Duration
+
+	responseCacheTTL  time.Duration
+	responseCacheSize int
+	coalesceRequests  bool
+
+	chromaURL             string
+	chromaMaxIdleConns    int
+	chromaMaxConnsPerHost int
+	// chromaCollection is the collection queried when a request names none
+	chromaCollection string
+	// chromaBootstrap creates chromaCollection on warm-up if it is missing
+	chromaBootstrap bool
+	// indexStalenessThreshold is how long a collection may go without
+	// updates before ChatWithDoc replies warn about it, 0 to never warn
+	indexStalenessThreshold time.Duration
+
+	generationTimeout time.Duration
+	embeddingTimeout  time.Duration
+	retrievalTimeout  time.Duration
+	toolTimeout       time.Duration
+	toolTimeouts      map[string]time.Duration
+
+	maxConcurrentRequests int
+	shedQueueThreshold    int
+	shedLatencyP95        time.Duration
+
+	warmUpOnStartup bool
+	warmUpTimeout   time.Duration
+
+	providerMaxConcurrency int
+
+	contextCacheMinTokens int
+	contextCacheTTL       time.Duration
+
+	nativeFunctionCalling bool
+
+	embeddingModel     string
+	embeddingBatchSize int
+	embeddingWorkers   int
+
+	inputTokenLimit int
+	inputTruncation llm.TruncationStrategy
+
+	systemPrompts map[genaidemo.Mode]string
+
+	usageRetention time.Duration
+	apiKeyTiers    map[string]genaidemo.Priority
+	// apiKeys lists the API key ids allowed to call the gRPC server; empty allows everyone
+	apiKeys map[string]bool
+	// adminKeys lists the API key ids allowed to call admin operations; empty allows no one
+	adminKeys map[string]bool
+	// jwtSecret verifies HS256 bearer tokens; empty rejects bearer tokens
+	jwtSecret string
+
+	requestTimeout time.Duration
+	rateLimitRPS   float64
+	rateLimitBurst int
+	// redisURL shares rate limits and budgets across replicas; empty keeps
+	// them per process
+	redisURL     string
+	redisTimeout time.Duration
+
+	// maxDocumentBytes limits uploaded documents, which are spooled to
+	// uploadDir (the system temp directory when empty) before ingestion
+	maxDocumentBytes int64
+	uploadDir        string
+	uploadTimeout    time.Duration
+	// uploadExpiry is how long resumable uploads are kept after creation
+	uploadExpiry time.Duration
+
+	healthCheckTimeout  time.Duration
+	healthCheckInterval time.Duration
+
+	shutdownDrainDelay time.Duration
+	shutdownTimeout    time.Duration
+
+	// httpListenAddrs and grpcListenAddrs are TCP host:port or unix:<path>
+	// addresses, each server listens on all of its addresses
+	httpListenAddrs []string
+	grpcListenAddrs []string
+
+	defaultMonthlyBudget float64
+	monthlyBudgets       map[string]float64
+	budgetPolicy         string
+	budgetDowngradeModel string
+
+	// judgeModel scores responses in EvaluateResponse, the serving model when empty
+	judgeModel string
+
+	// summaryModel writes the summaries of Summarize, the serving model when empty
+	summaryModel string
+
+	// classifyModel labels texts in Classify, the serving model when empty
+	classifyModel string
+
+	// autoRouterModel settles the ambiguous requests of ChatAuto; heuristics
+	// only when empty
+	autoRouterModel         string
+	autoRouterMinConfidence float64
+
+	// intentClassifiers names the intent classifiers tried in order; intents
+	// aren't classified when empty
+	intentClassifiers   []string
+	intentModel         string
+	intentMinConfidence float64
 }
 
 func main() {
@@ -38,36 +222,315 @@ func main() {
 	}
 	defer func() { _ = handler.Close() }()
 
+	// Warm up dependencies in the background; /readyz reports 503 until done
+	if cfg.warmUpOnStartup {
+		go handler.warmUp(ctx, cfg.warmUpTimeout)
+	} else {
+		handler.ready.Store(true)
+	}
+
+	// Probe dependencies in the background for the gRPC health service
+	go handler.health.run(ctx)
+
+	// Reload canary rollouts on SIGHUP
+	go handler.rollouts.watchReloads(ctx)
+
+	// Start gRPC server on each of its listen addresses
+	grpcListeners, err := listenAll(cfg.grpcListenAddrs)
+	if err != nil {
+		log.Fatalf("failed to start gRPC server: %v", err)
+	}
+	grpcServer := newGRPCServer(handler, cfg)
+	for _, lis := range grpcListeners {
+		go func() {
+			log.Printf("🚀 gRPC server starting on %s", lis.Addr())
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatalf("failed to serve gRPC: %v", err)
+			}
+		}()
+	}
+
 	// Start HTTP server
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/chat", createHTTPHandler(handler, "Chat"))
-	mux.HandleFunc("/api/chat-with-tool", createHTTPHandler(handler, "ChatWithTool"))
-	mux.HandleFunc("/api/chat-with-agent", createHTTPHandler(handler, "ChatWithAgent"))
-	mux.HandleFunc("/api/chat-with-doc", createHTTPHandler(handler, "ChatWithDoc"))
-	mux.HandleFunc("/api/health", healthHandler)
+	mux := newHTTPMux(handler, cfg)
 	
-	log.Printf("🌐 HTTP server starting on port %s", httpPort)
+	httpListeners, err := listenAll(cfg.httpListenAddrs)
+	if err != nil {
+		log.Fatalf("failed to start HTTP server: %v", err)
+	}
+	for _, lis := range httpListeners {
+		log.Printf("🌐 HTTP server starting on %s", lis.Addr())
+	}
 	log.Printf("📍 API endpoints:")
 	log.Printf("   - POST /api/chat")
 	log.Printf("   - POST /api/chat-with-tool")
 	log.Printf("   - POST /api/chat-with-agent")
 	log.Printf("   - POST /api/chat-with-doc")
+	log.Printf("   - POST /api/chat/auto")
+	log.Printf("   - POST /api/transcribe")
+	log.Printf("   - POST /api/tts")
+	log.Printf("   - POST /api/jobs")
+	log.Printf("   - GET  /api/jobs/{id}")
+	log.Printf("   - POST /api/documents/uploads")
+	log.Printf("   - HEAD/GET/PATCH/DELETE /api/documents/uploads/{id}")
+	log.Printf("   - GET  /api/collections/{name}/stats")
+	log.Printf("   - GET  /api/collections/{name}/freshness")
+	log.Printf("   - GET/POST /api/templates")
+	log.Printf("   - GET/PUT/DELETE /api/templates/{name}")
+	log.Printf("   - GET  /api/templates/{name}/versions")
+	log.Printf("   - POST /api/templates/{name}/versions/{version}/activate")
+	log.Printf("   - GET/POST /api/examples")
+	log.Printf("   - GET/PUT/DELETE /api/examples/{id}")
+	log.Printf("   - GET  /api/usage")
+	log.Printf("   - POST /api/evaluate")
+	log.Printf("   - POST /api/admin/drain")
 	log.Printf("   - GET  /api/health")
+	log.Printf("   - GET  /api/ready")
+	log.Printf("   - GET  /api/metrics")
+	log.Printf("   - GET  /healthz, /readyz")
+	log.Printf("   - GET  /ui/ (web chat UI)")
 	
-	if err := http.ListenAndServe(":"+httpPort, mux); err != nil {
-		log.Fatalf("failed to serve HTTP: %v", err)
+	httpServer := &http.Server{Handler: mux}
+	for _, lis := range httpListeners {
+		go func() {
+			if err := httpServer.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("failed to serve HTTP: %v", err)
+			}
+		}()
+	}
+
+	// Wait for SIGINT/SIGTERM or the Drain admin operation, then drain:
+	// /readyz and the gRPC health service report not ready while the servers
+	// keep serving, so load balancers stop routing here before in-flight
+	// requests and jobs are finished. After Drain, new requests are already
+	// rejected with 503.
+	stop, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case <-stop.Done():
+	case <-handler.drained():
+	}
+
+	log.Printf("🛑 Shutting down, draining for %v", cfg.shutdownDrainDelay)
+	handler.draining.Store(true)
+	handler.health.grpc.Shutdown()
+	time.Sleep(cfg.shutdownDrainDelay)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancelShutdown()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️  HTTP server shutdown: %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+	}
+	if err := handler.jobs.drain(shutdownCtx); err != nil {
+		log.Printf("⚠️  Jobs still running at shutdown: %v", err)
 	}
 
 	log.Printf("stopped %s service", serviceName)
 }
 
-func getConfigFromEnv() (*serviceConfig, error) {
-	// 使用默认配置 (在 config.go 中定义)
-	config := &serviceConfig{
+// newHTTPMux registers the HTTP API routes of the handler behind the
+// middleware stack: request id, logging, panic recovery, CORS and timeout
+// for every route, plus per-route metrics. API routes also require an
+// allowed API key, are rate limited and are rejected while draining; probes,
+// metrics and the UI are not. Admin routes keep working while draining.
+func newHTTPMux(handler *Handler, cfg *serviceConfig) http.Handler {
+	mux := http.NewServeMux()
+	api := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, chain(h, metricsMiddleware(pattern), drainMiddleware(handler), authMiddleware(cfg.apiKeys, handler.jwt, handler.tenants, handler.acl), rateLimitMiddleware(handler.rateLimit)))
+	}
+	auth := authMiddleware(cfg.apiKeys, handler.jwt, handler.tenants, handler.acl)
+	admin := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, chain(h, metricsMiddleware(pattern), auth))
+	}
+	public := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, chain(h, metricsMiddleware(pattern)))
+	}
+
+	api("/api/chat", createHTTPHandler(handler, "Chat"))
+	api("/api/chat-with-tool", createHTTPHandler(handler, "ChatWithTool"))
+	api("/api/chat-with-agent", createHTTPHandler(handler, "ChatWithAgent"))
+	api("/api/chat-with-doc", createHTTPHandler(handler, "ChatWithDoc"))
+	api("/api/chat/auto", createHTTPHandler(handler, "ChatAuto"))
+	v2 := &handlerV2{handler: handler}
+	api("/api/v2/chat", createHTTPHandlerV2(v2.Chat))
+	api("/api/v2/chat-with-tool", createHTTPHandlerV2(v2.ChatWithTool))
+	api("/api/v2/chat-with-agent", createHTTPHandlerV2(v2.ChatWithAgent))
+	api("/api/v2/chat-with-doc", createHTTPHandlerV2(v2.ChatWithDoc))
+	api("/api/v2/chat/auto", createHTTPHandlerV2(v2.ChatAuto))
+	api("/api/transcribe", transcribeHTTPHandler(handler))
+	api("/api/tts", ttsHTTPHandler(handler))
+	api("/api/jobs", submitJobHTTPHandler(handler))
+	api("/api/jobs/{id}", getJobHTTPHandler(handler))
+	api("/api/documents/uploads", createUploadHTTPHandler(handler))
+	api("/api/documents/uploads/{id}", uploadHTTPHandler(handler))
+	api("/api/collections/{name}/stats", collectionStatsHTTPHandler(handler))
+	api("/api/collections/{name}/freshness", collectionFreshnessHTTPHandler(handler))
+	api("/api/templates", templatesHTTPHandler(handler))
+	api("/api/templates/{name}", templateHTTPHandler(handler))
+	api("/api/templates/{name}/versions", templateVersionsHTTPHandler(handler))
+	api("/api/templates/{name}/versions/{version}/activate", activateTemplateVersionHTTPHandler(handler))
+	api("/api/examples", examplesHTTPHandler(handler))
+	api("/api/examples/{id}", exampleHTTPHandler(handler))
+	api("/api/memory", memoryHTTPHandler(handler))
+	api("/api/memory/facts/{id}", memoryFactHTTPHandler(handler))
+	api("/api/graph/entities/{name}", graphEntityHTTPHandler(handler))
+	api("/api/sessions", sessionsHTTPHandler(handler))
+	api("/api/usage", usageHTTPHandler(handler))
+	api("/api/analytics", analyticsHTTPHandler(handler))
+	api("/api/feedback", feedbackHTTPHandler(handler))
+	api("/api/exports", createExportHTTPHandler(handler))
+	api("/api/exports/{id}", getExportHTTPHandler(handler))
+	api("/api/exports/{id}/data", exportDataHTTPHandler(handler))
+	api("/api/evaluate", evaluateHTTPHandler(handler))
+	api("/api/summarize", summarizeHTTPHandler(handler))
+	api("/api/classify", classifyHTTPHandler(handler))
+	admin("/api/admin/drain", drainHTTPHandler(handler))
+	public("/api/health", healthHandler(handler, auth))
+	public("/api/ready", readyHandler(handler))
+	public("/healthz", livenessHandler())
+	public("/readyz", readyHandler(handler))
+	public("/api/metrics", expvar.Handler())
+	public("/ui/", http.StripPrefix("/ui/", http.FileServerFS(frontend.Files)))
+
+	return chain(mux,
+		requestIDMiddleware,
+		loggingMiddleware,
+		recoveryMiddleware,
+		corsMiddleware,
+		timeoutMiddleware(cfg.requestTimeout, cfg.uploadTimeout),
+	)
+}
+
+// defaultServiceConfig returns the configuration built from the defaults in
+// config.go, before any environment overrides
+func defaultServiceConfig() *serviceConfig {
+	return &serviceConfig{
 		projectID: DefaultProjectID,
 		location:  DefaultLocation,
 		modelName: DefaultModelName,
+
+		llmProvider:     DefaultLLMProvider,
+		llmCassetteMode: DefaultLLMCassetteMode,
+
+		ttsLanguageCode: DefaultTTSLanguageCode,
+		ttsVoiceName:    DefaultTTSVoiceName,
+
+		jobWorkers:   DefaultJobWorkers,
+		jobQueueSize: DefaultJobQueueSize,
+		jobTimeout:   DefaultJobTimeout,
+		jobRetention: DefaultJobRetention,
+
+		webhookMaxAttempts: DefaultWebhookMaxAttempts,
+		webhookTimeout:     DefaultWebhookTimeout,
+
+		eventBufferSize: DefaultEventBufferSize,
+
+		fewShotTokenBudget: DefaultFewShotTokenBudget,
+		memoryTokenBudget:  DefaultMemoryTokenBudget,
+		graphTokenBudget:   DefaultGraphTokenBudget,
+
+		sessionEntityTokenBudget: DefaultSessionEntityTokenBudget,
+		sessionEntityTTL:         DefaultSessionEntityTTL,
+
+		sessionRetention: DefaultSessionRetention,
+
+		analyticsInterval:  DefaultAnalyticsInterval,
+		analyticsRetention: DefaultAnalyticsRetention,
+
+		feedbackWindow: DefaultFeedbackWindow,
+
+		exportRetention: DefaultExportRetention,
+
+		autoRouterMinConfidence: DefaultAutoRouterMinConfidence,
+
+		intentMinConfidence: DefaultIntentMinConfidence,
+
+		retryMaxAttempts:    DefaultRetryMaxAttempts,
+		retryInitialBackoff: DefaultRetryInitialBackoff,
+		retryMaxBackoff:     DefaultRetryMaxBackoff,
+
+		breakerFailureThreshold: DefaultBreakerFailureThreshold,
+		breakerOpenTimeout:      DefaultBreakerOpenTimeout,
+
+		responseCacheTTL:  DefaultResponseCacheTTL,
+		responseCacheSize: DefaultResponseCacheSize,
+		coalesceRequests:  DefaultCoalesceRequests,
+
+		chromaURL:             DefaultChromaDBURL,
+		chromaMaxIdleConns:    DefaultChromaDBMaxIdleConns,
+		chromaMaxConnsPerHost: DefaultChromaDBMaxConns,
+		chromaCollection:      DefaultChromaDBCollection,
+		chromaBootstrap:       DefaultChromaDBBootstrap,
+
+		indexStalenessThreshold: DefaultIndexStalenessThreshold,
+
+		generationTimeout: DefaultGenerationTimeout,
+		embeddingTimeout:  DefaultEmbeddingTimeout,
+		retrievalTimeout:  DefaultRetrievalTimeout,
+		toolTimeout:       DefaultToolTimeout,
+
+		maxConcurrentRequests: DefaultMaxConcurrentRequests,
+		shedQueueThreshold:    DefaultShedQueueThreshold,
+		shedLatencyP95:        DefaultShedLatencyP95,
+
+		requestTimeout: DefaultRequestTimeout,
+		rateLimitRPS:   DefaultRateLimitRPS,
+		rateLimitBurst: DefaultRateLimitBurst,
+		redisTimeout:   DefaultRedisTimeout,
+
+		maxDocumentBytes: DefaultMaxDocumentBytes,
+		uploadTimeout:    DefaultUploadTimeout,
+		uploadExpiry:     DefaultUploadExpiry,
+
+		healthCheckTimeout:  DefaultHealthCheckTimeout,
+		healthCheckInterval: DefaultHealthCheckInterval,
+
+		shutdownDrainDelay: DefaultShutdownDrainDelay,
+		shutdownTimeout:    DefaultShutdownTimeout,
+
+		httpListenAddrs: []string{DefaultHTTPListenAddr},
+		grpcListenAddrs: []string{DefaultGRPCListenAddr},
+
+		warmUpOnStartup: DefaultWarmUpOnStartup,
+		warmUpTimeout:   DefaultWarmUpTimeout,
+
+		providerMaxConcurrency: DefaultProviderMaxConcurrency,
+
+		contextCacheMinTokens: DefaultContextCacheMinTokens,
+		contextCacheTTL:       DefaultContextCacheTTL,
+
+		nativeFunctionCalling: DefaultNativeFunctionCalling,
+
+		embeddingModel:     DefaultEmbeddingModel,
+		embeddingBatchSize: DefaultEmbeddingBatchSize,
+		embeddingWorkers:   DefaultEmbeddingWorkers,
+
+		inputTokenLimit: DefaultInputTokenLimit,
+		inputTruncation: DefaultInputTruncation,
+
+		systemPrompts: map[genaidemo.Mode]string{
+			genaidemo.Mode_MODE_DOC: DefaultDocSystemPrompt,
+		},
+
+		usageRetention: DefaultUsageRetention,
+
+		defaultMonthlyBudget: DefaultMonthlyBudget,
+		budgetPolicy:         DefaultBudgetPolicy,
 	}
+}
+
+func getConfigFromEnv() (*serviceConfig, error) {
+	// 使用默认配置 (在 config.go 中定义)
+	config := defaultServiceConfig()
 	
 	// 如果设置了环境变量，优先使用环境变量
 	if envProjectID := os.Getenv("GCP_PROJECT_ID"); envProjectID != "" {
@@ -82,6 +545,266 @@ func getConfigFromEnv() (*serviceConfig, error) {
 		config.modelName = envModel
 		log.Printf("Using model from environment: %s", envModel)
 	}
+	if envProvider := os.Getenv("LLM_PROVIDER"); envProvider != "" {
+		if envProvider != llmProviderVertex && envProvider != llmProviderMock {
+			return nil, fmt.Errorf("invalid LLM_PROVIDER %q, expected vertex or mock", envProvider)
+		}
+		config.llmProvider = envProvider
+		log.Printf("Using LLM provider from environment: %s", envProvider)
+	}
+	config.mockLLMScript = os.Getenv("MOCK_LLM_SCRIPT")
+	config.llmCassette = os.Getenv("LLM_CASSETTE")
+	if envCassetteMode := os.Getenv("LLM_CASSETTE_MODE"); envCassetteMode != "" {
+		if envCassetteMode != cassetteModeRecord && envCassetteMode != cassetteModeReplay {
+			return nil, fmt.Errorf("invalid LLM_CASSETTE_MODE %q, expected record or replay", envCassetteMode)
+		}
+		config.llmCassetteMode = envCassetteMode
+	}
+	if envTTSLanguage := os.Getenv("TTS_LANGUAGE_CODE"); envTTSLanguage != "" {
+		config.ttsLanguageCode = envTTSLanguage
+	}
+	if envTTSVoice := os.Getenv("TTS_VOICE_NAME"); envTTSVoice != "" {
+		config.ttsVoiceName = envTTSVoice
+	}
+	config.jobWorkers = getEnvInt("JOB_WORKERS", config.jobWorkers)
+	config.jobQueueSize = getEnvInt("JOB_QUEUE_SIZE", config.jobQueueSize)
+	config.jobTimeout = getEnvDuration("JOB_TIMEOUT", config.jobTimeout)
+	config.jobRetention = getEnvDuration("JOB_RETENTION", config.jobRetention)
+	config.webhookSecret = os.Getenv("WEBHOOK_SECRET")
+	config.webhookMaxAttempts = getEnvInt("WEBHOOK_MAX_ATTEMPTS", config.webhookMaxAttempts)
+	config.webhookTimeout = getEnvDuration("WEBHOOK_TIMEOUT", config.webhookTimeout)
+	config.eventBus = os.Getenv("EVENT_BUS")
+	config.eventTopic = os.Getenv("EVENT_TOPIC")
+	config.kafkaRESTURL = os.Getenv("KAFKA_REST_URL")
+	config.eventBufferSize = getEnvInt("EVENT_BUFFER_SIZE", config.eventBufferSize)
+	if envTemplatesFile := os.Getenv("PROMPT_TEMPLATES_FILE"); envTemplatesFile != "" {
+		config.promptTemplatesFile = envTemplatesFile
+		log.Printf("Using prompt templates file from environment: %s", envTemplatesFile)
+	}
+	if envExamplesFile := os.Getenv("FEW_SHOT_EXAMPLES_FILE"); envExamplesFile != "" {
+		config.fewShotExamplesFile = envExamplesFile
+		log.Printf("Using few-shot examples file from environment: %s", envExamplesFile)
+	}
+	config.fewShotTokenBudget = getEnvInt("FEW_SHOT_TOKEN_BUDGET", config.fewShotTokenBudget)
+	if envMemoryFile := os.Getenv("USER_MEMORY_FILE"); envMemoryFile != "" {
+		config.userMemoryFile = envMemoryFile
+		log.Printf("Using user memory file from environment: %s", envMemoryFile)
+	}
+	config.memoryTokenBudget = getEnvInt("USER_MEMORY_TOKEN_BUDGET", config.memoryTokenBudget)
+	if envGraphFile := os.Getenv("KNOWLEDGE_GRAPH_FILE"); envGraphFile != "" {
+		config.knowledgeGraphFile = envGraphFile
+		log.Printf("Using knowledge graph file from environment: %s", envGraphFile)
+	}
+	config.graphTokenBudget = getEnvInt("KNOWLEDGE_GRAPH_TOKEN_BUDGET", config.graphTokenBudget)
+	config.sessionEntityTokenBudget = getEnvInt("SESSION_ENTITY_TOKEN_BUDGET", config.sessionEntityTokenBudget)
+	config.sessionEntityTTL = getEnvDuration("SESSION_ENTITY_TTL", config.sessionEntityTTL)
+	config.sessionTitleModel = os.Getenv("SESSION_TITLE_MODEL")
+	config.sessionRetention = getEnvDuration("SESSION_RETENTION", config.sessionRetention)
+	if envSessionsFile := os.Getenv("SESSIONS_FILE"); envSessionsFile != "" {
+		config.sessionsFile = envSessionsFile
+		log.Printf("Using sessions file from environment: %s", envSessionsFile)
+	}
+	config.analyticsInterval = getEnvDuration("ANALYTICS_INTERVAL", config.analyticsInterval)
+	config.analyticsRetention = getEnvDuration("ANALYTICS_RETENTION", config.analyticsRetention)
+	if envAnalyticsFile := os.Getenv("ANALYTICS_FILE"); envAnalyticsFile != "" {
+		config.analyticsFile = envAnalyticsFile
+		log.Printf("Using analytics file from environment: %s", envAnalyticsFile)
+	}
+	envTopics := os.Getenv("ANALYTICS_TOPICS")
+	if envTopics != "" {
+		log.Printf("Using ANALYTICS_TOPICS from environment: %s", envTopics)
+	} else {
+		envTopics = DefaultAnalyticsTopics
+	}
+	for _, topic := range strings.Split(envTopics, ",") {
+		if topic = strings.ToLower(strings.TrimSpace(topic)); topic != "" && topic != llm.TopicOther {
+			config.analyticsTopics = append(config.analyticsTopics, topic)
+		}
+	}
+	config.feedbackWindow = getEnvDuration("FEEDBACK_WINDOW", config.feedbackWindow)
+	if envFeedbackFile := os.Getenv("FEEDBACK_FILE"); envFeedbackFile != "" {
+		config.feedbackFile = envFeedbackFile
+		log.Printf("Using feedback file from environment: %s", envFeedbackFile)
+	}
+	config.exportDir = os.Getenv("EXPORT_DIR")
+	config.exportRetention = getEnvDuration("EXPORT_RETENTION", config.exportRetention)
+	if envExperimentsFile := os.Getenv("EXPERIMENTS_FILE"); envExperimentsFile != "" {
+		config.experimentsFile = envExperimentsFile
+		log.Printf("Using experiments file from environment: %s", envExperimentsFile)
+	}
+	if envRolloutsFile := os.Getenv("ROLLOUTS_FILE"); envRolloutsFile != "" {
+		config.rolloutsFile = envRolloutsFile
+		log.Printf("Using rollouts file from environment: %s", envRolloutsFile)
+	}
+	if envGuardrailsFile := os.Getenv("GUARDRAILS_FILE"); envGuardrailsFile != "" {
+		config.guardrailsFile = envGuardrailsFile
+		log.Printf("Using guardrails file from environment: %s", envGuardrailsFile)
+	}
+	if envSafetyFile := os.Getenv("SAFETY_SETTINGS_FILE"); envSafetyFile != "" {
+		config.safetySettingsFile = envSafetyFile
+		log.Printf("Using safety settings file from environment: %s", envSafetyFile)
+	}
+	if envCapabilitiesFile := os.Getenv("MODEL_CAPABILITIES_FILE"); envCapabilitiesFile != "" {
+		config.capabilitiesFile = envCapabilitiesFile
+		log.Printf("Using model capabilities file from environment: %s", envCapabilitiesFile)
+	}
+	if envTenantsFile := os.Getenv("TENANTS_FILE"); envTenantsFile != "" {
+		config.tenantsFile = envTenantsFile
+		log.Printf("Using tenants file from environment: %s", envTenantsFile)
+	}
+	if envACLFile := os.Getenv("ACL_FILE"); envACLFile != "" {
+		config.aclFile = envACLFile
+		log.Printf("Using ACL file from environment: %s", envACLFile)
+	}
+	config.retryMaxAttempts = getEnvInt("LLM_RETRY_MAX_ATTEMPTS", config.retryMaxAttempts)
+	config.retryInitialBackoff = getEnvDuration("LLM_RETRY_INITIAL_BACKOFF", config.retryInitialBackoff)
+	config.retryMaxBackoff = getEnvDuration("LLM_RETRY_MAX_BACKOFF", config.retryMaxBackoff)
+	config.breakerFailureThreshold = getEnvInt("CIRCUIT_BREAKER_FAILURE_THRESHOLD", config.breakerFailureThreshold)
+	config.breakerOpenTimeout = getEnvDuration("CIRCUIT_BREAKER_OPEN_TIMEOUT", config.breakerOpenTimeout)
+	config.responseCacheTTL = getEnvDuration("RESPONSE_CACHE_TTL", config.responseCacheTTL)
+	config.responseCacheSize = getEnvInt("RESPONSE_CACHE_SIZE", config.responseCacheSize)
+	config.coalesceRequests = getEnvBool("REQUEST_COALESCING", config.coalesceRequests)
+	if envChromaURL := os.Getenv("CHROMADB_URL"); envChromaURL != "" {
+		config.chromaURL = envChromaURL
+		log.Printf("Using ChromaDB URL from environment: %s", envChromaURL)
+	}
+	config.chromaMaxIdleConns = getEnvInt("CHROMADB_MAX_IDLE_CONNS", config.chromaMaxIdleConns)
+	config.chromaMaxConnsPerHost = getEnvInt("CHROMADB_MAX_CONNS", config.chromaMaxConnsPerHost)
+	if envCollection := os.Getenv("CHROMADB_COLLECTION"); envCollection != "" {
+		config.chromaCollection = envCollection
+	}
+	config.chromaBootstrap = getEnvBool("CHROMADB_BOOTSTRAP", config.chromaBootstrap)
+	config.indexStalenessThreshold = getEnvDuration("INDEX_STALENESS_THRESHOLD", config.indexStalenessThreshold)
+	config.generationTimeout = getEnvDuration("LLM_GENERATION_TIMEOUT", config.generationTimeout)
+	config.embeddingTimeout = getEnvDuration("EMBEDDING_TIMEOUT", config.embeddingTimeout)
+	config.retrievalTimeout = getEnvDuration("RETRIEVAL_TIMEOUT", config.retrievalTimeout)
+	config.toolTimeout = getEnvDuration("TOOL_TIMEOUT", config.toolTimeout)
+	config.toolTimeouts = getEnvDurationMap("TOOL_TIMEOUTS")
+	config.maxConcurrentRequests = getEnvInt("MAX_CONCURRENT_REQUESTS", config.maxConcurrentRequests)
+	config.shedQueueThreshold = getEnvInt("SHED_QUEUE_THRESHOLD", config.shedQueueThreshold)
+	config.shedLatencyP95 = getEnvDuration("SHED_LATENCY_P95", config.shedLatencyP95)
+	config.warmUpOnStartup = getEnvBool("WARMUP_ON_STARTUP", config.warmUpOnStartup)
+	config.warmUpTimeout = getEnvDuration("WARMUP_TIMEOUT", config.warmUpTimeout)
+	config.providerMaxConcurrency = getEnvInt("PROVIDER_MAX_CONCURRENCY", config.providerMaxConcurrency)
+	config.contextCacheMinTokens = getEnvInt("CONTEXT_CACHE_MIN_TOKENS", config.contextCacheMinTokens)
+	config.contextCacheTTL = getEnvDuration("CONTEXT_CACHE_TTL", config.contextCacheTTL)
+	config.nativeFunctionCalling = getEnvBool("NATIVE_FUNCTION_CALLING", config.nativeFunctionCalling)
+	if envEmbeddingModel := os.Getenv("EMBEDDING_MODEL"); envEmbeddingModel != "" {
+		config.embeddingModel = envEmbeddingModel
+	}
+	config.embeddingBatchSize = getEnvInt("EMBEDDING_BATCH_SIZE", config.embeddingBatchSize)
+	config.embeddingWorkers = getEnvInt("EMBEDDING_WORKERS", config.embeddingWorkers)
+	config.inputTokenLimit = getEnvInt("INPUT_TOKEN_LIMIT", config.inputTokenLimit)
+	config.usageRetention = getEnvDuration("USAGE_RETENTION", config.usageRetention)
+	config.apiKeyTiers = make(map[string]genaidemo.Priority)
+	if envTiers := os.Getenv("API_KEY_TIERS"); envTiers != "" {
+		for _, pair := range strings.Split(envTiers, ",") {
+			keyID, tier, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			p, ok := parsePriority(tier)
+			if !ok {
+				return nil, fmt.Errorf("invalid API_KEY_TIERS entry %q, expected key_id=low|normal|high", pair)
+			}
+			config.apiKeyTiers[keyID] = p
+		}
+		log.Printf("Using API_KEY_TIERS from environment: %v", config.apiKeyTiers)
+	}
+	config.apiKeys = make(map[string]bool)
+	if envKeys := os.Getenv("API_KEYS"); envKeys != "" {
+		for _, keyID := range strings.Split(envKeys, ",") {
+			if keyID = strings.TrimSpace(keyID); keyID != "" {
+				config.apiKeys[keyID] = true
+			}
+		}
+		log.Printf("Using API_KEYS from environment: %d keys", len(config.apiKeys))
+	}
+	config.adminKeys = make(map[string]bool)
+	if envKeys := os.Getenv("ADMIN_API_KEYS"); envKeys != "" {
+		for _, keyID := range strings.Split(envKeys, ",") {
+			if keyID = strings.TrimSpace(keyID); keyID != "" {
+				config.adminKeys[keyID] = true
+			}
+		}
+		log.Printf("Using ADMIN_API_KEYS from environment: %d keys", len(config.adminKeys))
+	}
+	config.jwtSecret = os.Getenv("JWT_SECRET")
+	config.requestTimeout = getEnvDuration("REQUEST_TIMEOUT", config.requestTimeout)
+	config.rateLimitRPS = getEnvFloat("RATE_LIMIT_RPS", config.rateLimitRPS)
+	config.rateLimitBurst = getEnvInt("RATE_LIMIT_BURST", config.rateLimitBurst)
+	config.redisURL = os.Getenv("REDIS_URL")
+	config.redisTimeout = getEnvDuration("REDIS_TIMEOUT", config.redisTimeout)
+	config.maxDocumentBytes = int64(getEnvInt("MAX_DOCUMENT_BYTES", int(config.maxDocumentBytes)))
+	config.uploadDir = os.Getenv("UPLOAD_DIR")
+	config.uploadTimeout = getEnvDuration("UPLOAD_TIMEOUT", config.uploadTimeout)
+	config.uploadExpiry = getEnvDuration("UPLOAD_EXPIRY", config.uploadExpiry)
+	config.healthCheckTimeout = getEnvDuration("HEALTH_CHECK_TIMEOUT", config.healthCheckTimeout)
+	config.healthCheckInterval = getEnvDuration("HEALTH_CHECK_INTERVAL", config.healthCheckInterval)
+	config.shutdownDrainDelay = getEnvDuration("SHUTDOWN_DRAIN_DELAY", config.shutdownDrainDelay)
+	config.shutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", config.shutdownTimeout)
+	if envAddrs := os.Getenv("HTTP_LISTEN_ADDRS"); envAddrs != "" {
+		addrs, err := parseListenAddrs(envAddrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP_LISTEN_ADDRS: %w", err)
+		}
+		config.httpListenAddrs = addrs
+		log.Printf("Using HTTP listen addresses from environment: %v", addrs)
+	}
+	if envAddrs := os.Getenv("GRPC_LISTEN_ADDRS"); envAddrs != "" {
+		addrs, err := parseListenAddrs(envAddrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GRPC_LISTEN_ADDRS: %w", err)
+		}
+		config.grpcListenAddrs = addrs
+		log.Printf("Using gRPC listen addresses from environment: %v", addrs)
+	}
+	config.defaultMonthlyBudget = getEnvFloat("MONTHLY_BUDGET", config.defaultMonthlyBudget)
+	config.monthlyBudgets = getEnvFloatMap("MONTHLY_BUDGETS")
+	if envPolicy := os.Getenv("BUDGET_POLICY"); envPolicy != "" {
+		if envPolicy != budgetPolicyReject && envPolicy != budgetPolicyDowngrade {
+			return nil, fmt.Errorf("invalid BUDGET_POLICY %q, expected reject or downgrade", envPolicy)
+		}
+		config.budgetPolicy = envPolicy
+	}
+	config.budgetDowngradeModel = os.Getenv("BUDGET_DOWNGRADE_MODEL")
+	if config.budgetPolicy == budgetPolicyDowngrade && config.budgetDowngradeModel == "" {
+		return nil, fmt.Errorf("BUDGET_POLICY=downgrade requires BUDGET_DOWNGRADE_MODEL")
+	}
+	config.judgeModel = os.Getenv("EVALUATION_JUDGE_MODEL")
+	config.summaryModel = os.Getenv("SUMMARY_MODEL")
+	config.classifyModel = os.Getenv("CLASSIFY_MODEL")
+	config.autoRouterModel = os.Getenv("AUTO_ROUTER_MODEL")
+	config.autoRouterMinConfidence = getEnvFloat("AUTO_ROUTER_MIN_CONFIDENCE", config.autoRouterMinConfidence)
+	if envClassifiers := os.Getenv("INTENT_CLASSIFIERS"); envClassifiers != "" {
+		for _, name := range strings.Split(envClassifiers, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+				continue
+			}
+			if _, ok := intentClassifiers[name]; !ok {
+				return nil, fmt.Errorf("unknown intent classifier %q in INTENT_CLASSIFIERS", name)
+			}
+			config.intentClassifiers = append(config.intentClassifiers, name)
+		}
+		log.Printf("Using INTENT_CLASSIFIERS from environment: %v", config.intentClassifiers)
+	}
+	config.intentModel = os.Getenv("INTENT_MODEL")
+	config.intentMinConfidence = getEnvFloat("INTENT_MIN_CONFIDENCE", config.intentMinConfidence)
+	for mode, key := range systemPromptEnv {
+		prompt, err := getEnvPrompt(key)
+		if err != nil {
+			return nil, err
+		}
+		if prompt != "" {
+			config.systemPrompts[mode] = prompt
+			log.Printf("Using %s from environment", key)
+		}
+	}
+	if envTruncation := os.Getenv("INPUT_TRUNCATION"); envTruncation != "" {
+		strategy, ok := llm.ParseTruncationStrategy(envTruncation)
+		if !ok {
+			return nil, fmt.Errorf("invalid INPUT_TRUNCATION %q, expected reject or drop_oldest", envTruncation)
+		}
+		config.inputTruncation = strategy
+		log.Printf("Using INPUT_TRUNCATION from environment: %s", strategy)
+	}
 	
 	log.Printf("VertexAI Config - Project: %s, Location: %s, Model: %s", 
 		config.projectID, config.location, config.modelName)
@@ -94,13 +817,157 @@ func getConfigFromEnv() (*serviceConfig, error) {
 	return config, nil
 }
 
+// systemPromptEnv maps each chat mode to the environment variable overriding
+// its system prompt
+var systemPromptEnv = map[genaidemo.Mode]string{
+	genaidemo.Mode_MODE_CHAT:  "CHAT_SYSTEM_PROMPT",
+	genaidemo.Mode_MODE_TOOL:  "TOOL_SYSTEM_PROMPT",
+	genaidemo.Mode_MODE_AGENT: "AGENT_SYSTEM_PROMPT",
+	genaidemo.Mode_MODE_DOC:   "DOC_SYSTEM_PROMPT",
+}
+
+// getEnvPrompt reads a prompt template from an environment variable. A value
+// starting with "@" names a file holding the template, which suits long or
+// localized prompts. The template syntax is validated.
+func getEnvPrompt(key string) (string, error) {
+	value := os.Getenv(key)
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		value = string(data)
+	}
+	if value == "" {
+		return "", nil
+	}
+	if err := llm.ValidateTemplate(value); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// getEnvInt reads an integer environment variable, falling back to def when
+// unset or invalid
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️  Warning: invalid %s=%q, using default %d", key, value, def)
+		return def
+	}
+	log.Printf("Using %s from environment: %d", key, n)
+	return n
+}
+
+// getEnvBool reads a boolean environment variable (e.g. "true", "0"),
+// falling back to def when unset or invalid
+func getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("⚠️  Warning: invalid %s=%q, using default %t", key, value, def)
+		return def
+	}
+	log.Printf("Using %s from environment: %t", key, b)
+	return b
+}
+
+// getEnvDuration reads a duration environment variable (e.g. "30s", "5m"),
+// falling back to def when unset or invalid
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️  Warning: invalid %s=%q, using default %v", key, value, def)
+		return def
+	}
+	log.Printf("Using %s from environment: %v", key, d)
+	return d
+}
+
+// getEnvFloat reads a float environment variable, falling back to def when
+// unset or invalid
+func getEnvFloat(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("⚠️  Warning: invalid %s=%q, using default %v", key, value, def)
+		return def
+	}
+	log.Printf("Using %s from environment: %v", key, f)
+	return f
+}
+
+// getEnvFloatMap reads a comma-separated list of name=number pairs
+// (e.g. "key_ab12=100,key_cd34=25.5"), skipping invalid entries
+func getEnvFloatMap(key string) map[string]float64 {
+	result := make(map[string]float64)
+	value := os.Getenv(key)
+	if value == "" {
+		return result
+	}
+	for _, pair := range strings.Split(value, ",") {
+		name, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("⚠️  Warning: invalid %s entry %q, expected name=number", key, pair)
+			continue
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			log.Printf("⚠️  Warning: invalid %s entry %q: %v", key, pair, err)
+			continue
+		}
+		result[name] = f
+	}
+	log.Printf("Using %s from environment: %v", key, result)
+	return result
+}
+
+// getEnvDurationMap reads a comma-separated list of name=duration pairs
+// (e.g. "search_web=20s,calculate=1s"), skipping invalid entries
+func getEnvDurationMap(key string) map[string]time.Duration {
+	result := make(map[string]time.Duration)
+	value := os.Getenv(key)
+	if value == "" {
+		return result
+	}
+	for _, pair := range strings.Split(value, ",") {
+		name, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("⚠️  Warning: invalid %s entry %q, expected name=duration", key, pair)
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Printf("⚠️  Warning: invalid %s entry %q: %v", key, pair, err)
+			continue
+		}
+		result[name] = d
+	}
+	log.Printf("Using %s from environment: %v", key, result)
+	return result
+}
+
 func createHandler(ctx context.Context, cfg *serviceConfig) (*Handler, error) {
 	service, err := newService(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	handler, err := newHandler(service)
+	handler, err := newHandler(service, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -110,60 +977,196 @@ func createHandler(ctx context.Context, cfg *serviceConfig) (*Handler, error) {
 
 // HTTP Handler types
 type HTTPMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string     `json:"role"`
+	Content string     `json:"content"`
+	Audio   *HTTPAudio `json:"audio,omitempty"`
+}
+
+// HTTPAudio carries base64-encoded audio in JSON requests
+type HTTPAudio struct {
+	Data     []byte `json:"data"`
+	MimeType string `json:"mime_type"`
+}
+
+type HTTPTranscribeResponse struct {
+	Text string `json:"text"`
 }
 
 type HTTPChatRequest struct {
 	Messages    []HTTPMessage `json:"messages"`
 	Temperature *float32      `json:"temperature,omitempty"`
 	MaxTokens   *int32        `json:"max_tokens,omitempty"`
+	// AudioResponse requests the reply to be synthesized into audio
+	AudioResponse *bool `json:"audio_response,omitempty"`
+	// CallbackURL receives the result as a signed webhook once completed
+	CallbackURL *string `json:"callback_url,omitempty"`
+	// Template names a server-side prompt template rendered with Variables
+	Template  *string           `json:"template,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	// TemplateVersion pins the template version, the active one when unset
+	TemplateVersion *int32 `json:"template_version,omitempty"`
+	// Persona selects few-shot examples in addition to the endpoint's general ones
+	Persona *string `json:"persona,omitempty"`
+	// FewShot set to false skips the few-shot examples
+	FewShot *bool `json:"few_shot,omitempty"`
+	// Memory set to false neither uses nor updates the X-User-ID user's memory
+	Memory *bool `json:"memory,omitempty"`
+	// KnowledgeGraph set to false neither uses nor updates the knowledge graph
+	KnowledgeGraph *bool `json:"knowledge_graph,omitempty"`
+	// SessionID names the conversation whose entities are tracked across requests
+	SessionID *string `json:"session_id,omitempty"`
+	// ResponseLanguage is the language of the reply, e.g. "fr"; "auto" detects it
+	ResponseLanguage *string `json:"response_language,omitempty"`
+	// BypassCache skips the response cache for this request
+	BypassCache *bool `json:"bypass_cache,omitempty"`
+	// Priority is one of PRIORITY_LOW, PRIORITY_NORMAL, PRIORITY_HIGH
+	Priority string `json:"priority,omitempty"`
+	// Retrieval tunes the document retrieval of ChatWithDoc
+	Retrieval *HTTPRetrievalOptions `json:"retrieval,omitempty"`
+	// ProviderCredentials bills the request's LLM calls to the caller's account
+	ProviderCredentials *HTTPProviderCredentials `json:"provider_credentials,omitempty"`
+	// Sampling parameters, provider defaults when unset
+	TopP             *float32 `json:"top_p,omitempty"`
+	TopK             *int32   `json:"top_k,omitempty"`
+	StopSequences    []string `json:"stop_sequences,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	// CandidateCount above 1 returns the other replies as candidates
+	CandidateCount *int32 `json:"candidate_count,omitempty"`
+	// Seed makes generation reproducible with providers that support it
+	Seed *int32 `json:"seed,omitempty"`
+	// N above 1 generates that many completions, each with its own call
+	N *int32 `json:"n,omitempty"`
+	// Logprobs returns the log probability of each token in v2 responses,
+	// with TopLogprobs alternatives per token
+	Logprobs    *bool  `json:"logprobs,omitempty"`
+	TopLogprobs *int32 `json:"top_logprobs,omitempty"`
+	// SafetySettings override the endpoint's block threshold of harm categories
+	SafetySettings []HTTPSafetySetting `json:"safety_settings,omitempty"`
+}
+
+type HTTPSafetySetting struct {
+	// Category is a harm category, e.g. DANGEROUS_CONTENT
+	Category string `json:"category"`
+	// Threshold is e.g. BLOCK_ONLY_HIGH or BLOCK_NONE
+	Threshold string `json:"threshold"`
+}
+
+type HTTPProviderCredentials struct {
+	APIKey          string `json:"api_key,omitempty"`
+	Project         string `json:"project,omitempty"`
+	Location        string `json:"location,omitempty"`
+	CredentialsJSON string `json:"credentials_json,omitempty"`
+}
+
+type HTTPRetrievalOptions struct {
+	TopK         *int32            `json:"top_k,omitempty"`
+	MinRelevance *float64          `json:"min_relevance,omitempty"`
+	Collection   *string           `json:"collection,omitempty"`
+	Filter       map[string]string `json:"filter,omitempty"`
+	// IncludeChunks set to false omits the document content from sources
+	IncludeChunks *bool `json:"include_chunks,omitempty"`
+	// IncludeHighlights set to false omits the highlights of sources
+	IncludeHighlights *bool `json:"include_highlights,omitempty"`
 }
 
 type HTTPChatResponse struct {
-	Content string `json:"content"`
-	Error   string `json:"error,omitempty"`
+	Content    string          `json:"content"`
+	Audio      *HTTPAudio      `json:"audio,omitempty"`
+	TokenUsage *HTTPTokenUsage `json:"token_usage,omitempty"`
+	// EstimatedCost is computed from the model pricing table and token usage
+	EstimatedCost *HTTPCost `json:"estimated_cost,omitempty"`
+	// PromptTemplate is the template version rendered into the request
+	PromptTemplate *HTTPPromptTemplateRef `json:"prompt_template,omitempty"`
+	// Variant is the experiment variant that served the request
+	Variant string `json:"variant,omitempty"`
+	// Rollout is the rollout arm that served the request, e.g. flash-2:canary
+	Rollout string `json:"rollout,omitempty"`
+	// Sources are the documents retrieved as context (ChatWithDoc)
+	Sources []*HTTPRetrievedDocument `json:"sources,omitempty"`
+	// Language is the language the reply was requested in
+	Language string `json:"language,omitempty"`
+	// Mode is the endpoint that produced the reply, e.g. MODE_DOC
+	Mode string `json:"mode,omitempty"`
+	// DegradedReason is set when a fallback produced the reply, e.g. retrieval_unavailable
+	DegradedReason string `json:"degraded_reason,omitempty"`
+	// Warnings are caveats of a full reply, e.g. stale_index
+	Warnings []string `json:"warnings,omitempty"`
+	// Candidates are the other replies when candidate_count was above 1
+	Candidates []string `json:"candidates,omitempty"`
+	// Seed is the request's seed the reply was generated with
+	Seed *int32 `json:"seed,omitempty"`
+	// Model is the model that generated the reply, ModelVersion the exact
+	// version the provider reports
+	Model        string `json:"model,omitempty"`
+	ModelVersion string `json:"model_version,omitempty"`
+	// FinishReason is e.g. FINISH_REASON_STOP or FINISH_REASON_MAX_TOKENS
+	FinishReason string `json:"finish_reason,omitempty"`
+	// SafetyBlock is set when the provider blocked the reply
+	SafetyBlock *HTTPSafetyBlock `json:"safety_block,omitempty"`
+}
+
+type HTTPSafetyBlock struct {
+	// Reason is e.g. SAFETY or RECITATION
+	Reason string `json:"reason"`
+	// Categories are the harm categories that blocked the reply
+	Categories []string `json:"categories,omitempty"`
+}
+
+type HTTPRetrievedDocument struct {
+	ID        string  `json:"id"`
+	Filename  string  `json:"filename"`
+	Relevance float64 `json:"relevance"`
+	Content   string  `json:"content,omitempty"`
+	// Highlights are the spans of content most relevant to the query, in
+	// Unicode code points
+	Highlights []*HTTPHighlight `json:"highlights,omitempty"`
+}
+
+type HTTPHighlight struct {
+	Start int32   `json:"start"`
+	End   int32   `json:"end"`
+	Score float64 `json:"score"`
+}
+
+type HTTPCost struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+type HTTPTokenUsage struct {
+	InputTokens  int32 `json:"input_tokens"`
+	OutputTokens int32 `json:"output_tokens"`
+	TotalTokens  int32 `json:"total_tokens"`
+	// ContextTokens is the part of InputTokens taken by retrieved documents
+	ContextTokens int32 `json:"context_tokens,omitempty"`
+	// CachedTokens is the part of InputTokens served from the context cache
+	CachedTokens int32 `json:"cached_tokens,omitempty"`
+}
+
+type HTTPSynthesizeRequest struct {
+	Text string `json:"text"`
+}
+
+type HTTPSynthesizeResponse struct {
+	Audio *HTTPAudio `json:"audio,omitempty"`
 }
 
 // Create HTTP handler for gRPC service methods
 func createHTTPHandler(handler *Handler, method string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Enable CORS
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		
 		if r.Method != "POST" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
 		var req HTTPChatRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			sendErrorResponse(w, "Invalid request format", http.StatusBadRequest)
+		if !decodeChatBody(w, r, &req) {
 			return
 		}
 
-		// Convert HTTP messages to gRPC messages
-		grpcMessages := make([]*genaidemo.Message, len(req.Messages))
-		for i, msg := range req.Messages {
-			grpcMessages[i] = &genaidemo.Message{
-				Role:    parseRole(msg.Role),
-				Content: msg.Content,
-			}
-		}
-
-		// Create gRPC request
-		grpcReq := &genaidemo.ChatRequest{
-			Messages:    grpcMessages,
-			Temperature: req.Temperature,
-			MaxTokens:   req.MaxTokens,
-		}
+		grpcReq := toGRPCChatRequest(&req)
+		ctx := r.Context()
 
 		// Call appropriate gRPC method
 		var grpcResp *genaidemo.ChatResponse
@@ -171,31 +1174,295 @@ func createHTTPHandler(handler *Handler, method string) http.HandlerFunc {
 		
 		switch method {
 		case "Chat":
-			grpcResp, err = handler.Chat(r.Context(), grpcReq)
+			grpcResp, err = handler.Chat(ctx, grpcReq)
 		case "ChatWithTool":
-			grpcResp, err = handler.ChatWithTool(r.Context(), grpcReq)
+			grpcResp, err = handler.ChatWithTool(ctx, grpcReq)
 		case "ChatWithAgent":
-			grpcResp, err = handler.ChatWithAgent(r.Context(), grpcReq)
+			grpcResp, err = handler.ChatWithAgent(ctx, grpcReq)
 		case "ChatWithDoc":
-			grpcResp, err = handler.ChatWithDoc(r.Context(), grpcReq)
+			grpcResp, err = handler.ChatWithDoc(ctx, grpcReq)
+		case "ChatAuto":
+			grpcResp, err = handler.ChatAuto(ctx, grpcReq)
 		default:
-			sendErrorResponse(w, "Unknown method", http.StatusBadRequest)
+			sendError(w, r, status.Error(codes.InvalidArgument, "Unknown method"))
 			return
 		}
 
 		if err != nil {
 			log.Printf("❌ gRPC call failed: %v", err)
-			sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			sendError(w, r, err)
 			return
 		}
 
 		// Send response
-		response := HTTPChatResponse{
-			Content: grpcResp.Content,
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toHTTPChatResponse(grpcResp))
+	}
+}
+
+// decodeChatBody decodes the JSON chat request of r into v. Bodies are
+// limited to a message's audio, base64-encoded, with room for the messages
+// and other fields; larger ones are answered with 413, invalid ones with 400.
+func decodeChatBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	limit := int64(base64.StdEncoding.EncodedLen(maxAudioBytes)) + 1<<20
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return false
 		}
-		
+		sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+		return false
+	}
+	return true
+}
+
+// toGRPCChatRequest converts an HTTP chat request into the gRPC request
+func toGRPCChatRequest(req *HTTPChatRequest) *genaidemo.ChatRequest {
+	return &genaidemo.ChatRequest{
+		Messages:         toGRPCMessages(req.Messages),
+		Temperature:      req.Temperature,
+		MaxTokens:        req.MaxTokens,
+		AudioResponse:    req.AudioResponse,
+		CallbackUrl:      req.CallbackURL,
+		Template:         req.Template,
+		TemplateVersion:  req.TemplateVersion,
+		Persona:          req.Persona,
+		FewShot:          req.FewShot,
+		Memory:           req.Memory,
+		KnowledgeGraph:   req.KnowledgeGraph,
+		SessionId:        req.SessionID,
+		ResponseLanguage: req.ResponseLanguage,
+		Variables:        req.Variables,
+		BypassCache:      req.BypassCache,
+		Priority:         genaidemo.Priority(genaidemo.Priority_value[req.Priority]),
+		Retrieval:        toGRPCRetrievalOptions(req.Retrieval),
+		TopP:             req.TopP,
+		TopK:             req.TopK,
+		StopSequences:    req.StopSequences,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		CandidateCount:   req.CandidateCount,
+		Seed:             req.Seed,
+		N:                req.N,
+		Logprobs:         req.Logprobs,
+		TopLogprobs:      req.TopLogprobs,
+		SafetySettings:   toGRPCSafetySettings(req.SafetySettings),
+
+		ProviderCredentials: toGRPCProviderCredentials(req.ProviderCredentials),
+	}
+}
+
+// toGRPCProviderCredentials converts HTTP provider credentials into gRPC ones
+func toGRPCProviderCredentials(creds *HTTPProviderCredentials) *genaidemo.ProviderCredentials {
+	if creds == nil {
+		return nil
+	}
+	return &genaidemo.ProviderCredentials{
+		ApiKey:          creds.APIKey,
+		Project:         creds.Project,
+		Location:        creds.Location,
+		CredentialsJson: creds.CredentialsJSON,
+	}
+}
+
+// toGRPCRetrievalOptions converts HTTP retrieval options into gRPC options
+func toGRPCRetrievalOptions(opts *HTTPRetrievalOptions) *genaidemo.RetrievalOptions {
+	if opts == nil {
+		return nil
+	}
+	return &genaidemo.RetrievalOptions{
+		TopK:              opts.TopK,
+		MinRelevance:      opts.MinRelevance,
+		Collection:        opts.Collection,
+		Filter:            opts.Filter,
+		IncludeChunks:     opts.IncludeChunks,
+		IncludeHighlights: opts.IncludeHighlights,
+	}
+}
+
+// toGRPCSafetySettings converts HTTP safety settings into gRPC settings
+func toGRPCSafetySettings(settings []HTTPSafetySetting) []*genaidemo.SafetySetting {
+	var grpcSettings []*genaidemo.SafetySetting
+	for _, s := range settings {
+		grpcSettings = append(grpcSettings, &genaidemo.SafetySetting{
+			Category:  s.Category,
+			Threshold: s.Threshold,
+		})
+	}
+	return grpcSettings
+}
+
+// toGRPCMessages converts HTTP messages into gRPC messages
+func toGRPCMessages(messages []HTTPMessage) []*genaidemo.Message {
+	grpcMessages := make([]*genaidemo.Message, len(messages))
+	for i, msg := range messages {
+		grpcMessages[i] = &genaidemo.Message{
+			Role:    parseRole(msg.Role),
+			Content: msg.Content,
+		}
+		if msg.Audio != nil {
+			grpcMessages[i].Audio = &genaidemo.Audio{
+				Data:     msg.Audio.Data,
+				MimeType: msg.Audio.MimeType,
+			}
+		}
+	}
+	return grpcMessages
+}
+
+// toHTTPChatResponse converts a gRPC chat response into the HTTP response
+func toHTTPChatResponse(resp *genaidemo.ChatResponse) *HTTPChatResponse {
+	response := &HTTPChatResponse{
+		Content: resp.Content,
+	}
+	if resp.Audio != nil {
+		response.Audio = &HTTPAudio{
+			Data:     resp.Audio.Data,
+			MimeType: resp.Audio.MimeType,
+		}
+	}
+	if resp.TokenUsage != nil {
+		response.TokenUsage = &HTTPTokenUsage{
+			InputTokens:   resp.TokenUsage.InputTokenNum,
+			OutputTokens:  resp.TokenUsage.OutputTokenNum,
+			TotalTokens:   resp.TokenUsage.TotalTokenNum,
+			ContextTokens: resp.TokenUsage.ContextTokenNum,
+			CachedTokens:  resp.TokenUsage.CachedTokenNum,
+		}
+	}
+	if resp.EstimatedCost != nil {
+		response.EstimatedCost = &HTTPCost{
+			Currency: resp.EstimatedCost.Currency,
+			Amount:   resp.EstimatedCost.Amount,
+		}
+	}
+	if resp.PromptTemplate != nil {
+		response.PromptTemplate = &HTTPPromptTemplateRef{
+			Name:    resp.PromptTemplate.Name,
+			Version: resp.PromptTemplate.Version,
+		}
+	}
+	response.Variant = resp.Variant
+	response.Rollout = resp.Rollout
+	response.Language = resp.Language
+	if resp.Mode != genaidemo.Mode_MODE_UNKNOWN {
+		response.Mode = resp.Mode.String()
+	}
+	response.DegradedReason = resp.GetDegradedReason()
+	response.Warnings = resp.Warnings
+	response.Candidates = resp.Candidates
+	response.Seed = resp.Seed
+	response.Model = resp.Model
+	response.ModelVersion = resp.ModelVersion
+	if resp.FinishReason != genaidemo.FinishReason_FINISH_REASON_UNSPECIFIED {
+		response.FinishReason = resp.FinishReason.String()
+	}
+	if resp.SafetyBlock != nil {
+		response.SafetyBlock = &HTTPSafetyBlock{
+			Reason:     resp.SafetyBlock.Reason,
+			Categories: resp.SafetyBlock.Categories,
+		}
+	}
+	for _, source := range resp.Sources {
+		document := &HTTPRetrievedDocument{
+			ID:        source.Id,
+			Filename:  source.Filename,
+			Relevance: source.Relevance,
+			Content:   source.Content,
+		}
+		for _, h := range source.Highlights {
+			document.Highlights = append(document.Highlights, &HTTPHighlight{Start: h.Start, End: h.End, Score: h.Score})
+		}
+		response.Sources = append(response.Sources, document)
+	}
+	return response
+}
+
+// Create HTTP handler for audio transcription. Accepts either a JSON body
+// ({"data": "<base64>", "mime_type": "audio/wav"}) or raw audio bytes with an
+// audio/* Content-Type.
+func transcribeHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var audio HTTPAudio
+		contentType := r.Header.Get("Content-Type")
+		rawAudio := strings.HasPrefix(contentType, "audio/")
+		// JSON bodies carry the audio base64-encoded, with room for the
+		// other fields
+		limit := int64(maxAudioBytes)
+		if !rawAudio {
+			limit = int64(base64.StdEncoding.EncodedLen(maxAudioBytes)) + 4<<10
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+		var err error
+		if rawAudio {
+			audio.MimeType = contentType
+			audio.Data, err = io.ReadAll(r.Body)
+		} else {
+			err = json.NewDecoder(r.Body).Decode(&audio)
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("Audio exceeds %d bytes", maxAudioBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+			return
+		}
+
+		grpcResp, err := handler.Transcribe(r.Context(), &genaidemo.TranscribeRequest{
+			Audio: &genaidemo.Audio{
+				Data:     audio.Data,
+				MimeType: audio.MimeType,
+			},
+		})
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HTTPTranscribeResponse{Text: grpcResp.Text})
+	}
+}
+
+// Create HTTP handler for text-to-speech synthesis
+func ttsHTTPHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req HTTPSynthesizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, r, status.Error(codes.InvalidArgument, "Invalid request format"))
+			return
+		}
+
+		grpcResp, err := handler.Synthesize(r.Context(), &genaidemo.SynthesizeRequest{Text: req.Text})
+		if err != nil {
+			log.Printf("❌ gRPC call failed: %v", err)
+			sendError(w, r, err)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		json.NewEncoder(w).Encode(HTTPSynthesizeResponse{
+			Audio: &HTTPAudio{
+				Data:     grpcResp.Audio.Data,
+				MimeType: grpcResp.Audio.MimeType,
+			},
+		})
 	}
 }
 
@@ -212,21 +1479,53 @@ func parseRole(role string) genaidemo.Role {
 	}
 }
 
-func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	response := HTTPChatResponse{
-		Error: message,
+// Health check, reports degraded while any dependency circuit breaker is
+// not closed. ?deep=true also probes Vertex AI and ChromaDB; those probes
+// are provider calls, so deep checks go through auth and need an admin key.
+func healthHandler(handler *Handler, auth middleware) http.HandlerFunc {
+	deepHealth := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := handler.requireAdmin(r.Context()); err != nil {
+			sendError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handler.health.report(r.Context(), handler.ready.Load(), true))
+	}))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if deep, _ := strconv.ParseBool(r.URL.Query().Get("deep")); deep {
+			deepHealth.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handler.health.report(r.Context(), handler.ready.Load(), false))
 	}
-	json.NewEncoder(w).Encode(response)
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
-		"status": "healthy",
-		"service": "genai-foundation-demo",
+// Liveness probe, returns 200 while the process can serve HTTP at all. It
+// checks no dependencies, so a dependency outage never restarts the pod.
+func livenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "alive"})
+	}
+}
+
+// Readiness probe, returns 503 until startup warm-up has completed and again
+// once the service is draining for shutdown
+func readyHandler(handler *Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		warmedUp := handler.ready.Load()
+		draining := handler.draining.Load()
+		ready := warmedUp && !draining
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]bool{
+			"ready":     ready,
+			"warmed_up": warmedUp,
+			"draining":  draining,
+		})
 	}
-	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
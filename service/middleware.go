@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpMetrics counts requests, errors per status code and latency per HTTP
+// route, exported under /api/metrics
+var httpMetrics = expvar.NewMap("http")
+
+// middleware wraps an HTTP handler with a cross-cutting concern
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h so that middlewares run in the given order, the first one
+// outermost
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestIDMiddleware tags the request with the caller's X-Request-ID, or a
+// new one, and returns it in the X-Request-ID response header
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// loggingMiddleware logs one key=value line per request with its outcome
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		icon := "🌐"
+		switch {
+		case errors.Is(r.Context().Err(), context.Canceled):
+			icon = "🚫"
+		case rec.status >= http.StatusBadRequest:
+			icon = "❌"
+		}
+		log.Printf("%s http method=%s path=%s status=%d duration_ms=%d request_id=%s key_id=%s",
+			icon, r.Method, r.URL.Path, rec.status, time.Since(start).Milliseconds(),
+			requestIDFromContext(r.Context()), apiKeyID(r.Header.Get(apiKeyHeader)))
+	})
+}
+
+// metricsMiddleware records requests, errors and latency of one route. It
+// is applied per route so the metrics are keyed by pattern, not by path.
+// Requests abandoned by the client count as cancelled rather than errors.
+func metricsMiddleware(pattern string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			prefix := pattern + "."
+			httpMetrics.Add(prefix+"requests", 1)
+			httpMetrics.Add(prefix+"latency_ms", time.Since(start).Milliseconds())
+			switch {
+			case errors.Is(r.Context().Err(), context.Canceled):
+				httpMetrics.Add(prefix+"cancelled", 1)
+			case rec.status >= http.StatusBadRequest:
+				httpMetrics.Add(prefix+"errors."+http.StatusText(rec.status), 1)
+			}
+		})
+	}
+}
+
+// recoveryMiddleware turns a panic in a handler into a 500 response instead
+// of dropping the connection
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				httpMetrics.Add("panics", 1)
+				log.Printf("💥 http method=%s path=%s request_id=%s panic: %v\n%s",
+					r.Method, r.URL.Path, requestIDFromContext(r.Context()), err, debug.Stack())
+				sendError(w, r, status.Error(codes.Internal, "internal error"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsMiddleware allows browser clients from any origin and answers
+// preflight requests, so handlers only deal with their own methods
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, X-Tenant-ID, X-User-Groups, X-User-Roles, X-User-ID, X-Priority, X-Request-ID, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After, Location, Tus-Resumable, Upload-Length, Upload-Offset")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeoutMiddleware bounds the request context by timeout, so model calls
+// of a slow request are cancelled, and document uploads by uploadTimeout
+// instead. A zero timeout disables it.
+func timeoutMiddleware(timeout, uploadTimeout time.Duration) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := timeout
+			if strings.HasPrefix(r.URL.Path, uploadsPath) {
+				timeout = uploadTimeout
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authMiddleware resolves the caller's API key id, tenant, document access,
+// end user and requested priority from the X-API-Key, Authorization,
+// X-Tenant-ID, X-User-Groups, X-User-Roles, X-User-ID and X-Priority headers
+// into the request context, rejecting keys missing from allowedKeys and
+// invalid bearer tokens with 401, tenants or end users the key may not act
+// for with 403 and malformed user ids with 400. An empty allowedKeys allows
+// every API key; bearer tokens are only checked by verifier.
+func authMiddleware(allowedKeys map[string]bool, verifier *jwtVerifier, tenants *tenantRegistry, acl *aclRegistry) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyID, err := callerKeyID(allowedKeys, verifier, apiKeyID(r.Header.Get(apiKeyHeader)), r.Header.Get(authorizationHeader))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			t, err := tenants.resolve(keyID, r.Header.Get(tenantHeader))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+			access, err := acl.resolve(keyID, r.Header.Get(userGroupsHeader), r.Header.Get(userRolesHeader))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+
+			userID, err := parseUserID(r.Header.Get(userIDHeader))
+			if err != nil {
+				sendError(w, r, err)
+				return
+			}
+
+			ctx := withDocumentAccess(withTenant(withAPIKeyID(r.Context(), keyID), t), access)
+			if userID != "" {
+				ctx = withUserID(ctx, userID)
+			}
+			if p, ok := parsePriority(r.Header.Get(priorityHeader)); ok {
+				ctx = withPriority(ctx, p)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// rateLimitMiddleware rejects requests of API keys over their rate with 429
+// and a Retry-After header. It must run after authMiddleware.
+func rateLimitMiddleware(limiter *rateLimiter) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := limiter.allow(apiKeyIDFromContext(r.Context())); err != nil {
+				sendError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkAPIKey returns Unauthenticated when allowedKeys is set and does not
+// contain keyID
+func checkAPIKey(allowedKeys map[string]bool, keyID string) error {
+	if len(allowedKeys) > 0 && !allowedKeys[keyID] {
+		return status.Error(codes.Unauthenticated, "missing or invalid API key")
+	}
+	return nil
+}
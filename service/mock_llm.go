@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// LLM provider 名称
+const (
+	llmProviderVertex = "vertex"
+	llmProviderMock   = "mock"
+)
+
+// mockEmbeddingDimensions 模拟嵌入向量的维度 (与 textembedding-gecko 一致)
+const mockEmbeddingDimensions = 768
+
+// 默认回复模板，脚本中没有匹配的规则时使用
+const (
+	mockDefaultResponse     = "Mock response to: {{.Input}}"
+	mockDefaultToolResponse = "Mock response using {{.ToolName}} result: {{.ToolResult}}"
+)
+
+// mockLLM 确定性的模拟 LLM，实现 IVertexAI，无需 GCP 凭据即可运行和测试整个服务。
+// 回复由脚本规则决定：最后一条用户消息匹配规则的正则时返回规则的模板回复，
+// 规则带 tool_call 且请求提供了该工具时先返回工具调用，拿到工具结果后再回复。
+type mockLLM struct {
+	model string
+	rules []*mockRule
+	calls atomic.Int64
+	// cached 缓存内容名称到其 token 数
+	cached sync.Map
+}
+
+// mockRule 一条脚本规则
+type mockRule struct {
+	// Match 匹配最后一条用户消息的正则，为空时匹配所有消息
+	Match string `json:"match"`
+	// Response 回复模板 (Go template)，可用 .Input .Model .ToolName .ToolResult
+	Response string `json:"response"`
+	// ToolCall 先返回的工具调用，可选
+	ToolCall *mockToolCall `json:"tool_call,omitempty"`
+	// Block 以该危害类别 (如 DANGEROUS_CONTENT) 拦截回复，可选。
+	// 请求将该类别设为 BLOCK_NONE 时不拦截
+	Block string `json:"block,omitempty"`
+
+	re       *regexp.Regexp
+	response *template.Template
+}
+
+// mockToolCall 模拟的工具调用
+type mockToolCall struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// mockTemplateData 回复模板的数据
+type mockTemplateData struct {
+	Input      string
+	Model      string
+	ToolName   string
+	ToolResult string
+}
+
+// newMockLLM 创建模拟 LLM，scriptPath 为空时所有请求都使用默认回复
+func newMockLLM(model, scriptPath string) (*mockLLM, error) {
+	m := &mockLLM{model: model}
+
+	if scriptPath != "" {
+		data, err := os.ReadFile(scriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mock LLM script: %w", err)
+		}
+		if err := json.Unmarshal(data, &m.rules); err != nil {
+			return nil, fmt.Errorf("failed to parse mock LLM script: %w", err)
+		}
+	}
+	// 兜底规则放在最后
+	m.rules = append(m.rules, &mockRule{})
+
+	for i, rule := range m.rules {
+		var err error
+		if rule.re, err = regexp.Compile(rule.Match); err != nil {
+			return nil, fmt.Errorf("mock LLM rule %d: invalid match: %w", i, err)
+		}
+		if rule.response, err = template.New("response").Parse(rule.Response); err != nil {
+			return nil, fmt.Errorf("mock LLM rule %d: invalid response template: %w", i, err)
+		}
+		if rule.Block != "" && !slices.Contains(safetyCategories, rule.Block) {
+			return nil, fmt.Errorf("mock LLM rule %d: unknown harm category %q", i, rule.Block)
+		}
+	}
+
+	log.Printf("🧪 [mockLLM] Mock LLM provider enabled (%d scripted rules)", len(m.rules)-1)
+	return m, nil
+}
+
+// GenerateContent 根据脚本生成确定性的回复，并在 GenerationInfo 中返回 token 用量
+func (m *mockLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var opts llms.CallOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	data := mockTemplateData{Model: cmp.Or(opts.Model, m.model)}
+	data.Input, data.ToolName, data.ToolResult = lastTurn(messages)
+	rule := m.match(data.Input)
+
+	settings, _ := opts.Metadata[llm.MetadataSafetySettings].(map[string]string)
+
+	choice := &llms.ContentChoice{}
+	var output string
+	if rule.Block != "" && settings[rule.Block] != "BLOCK_NONE" {
+		// 与 Gemini 一致，被拦截的回复没有内容
+		choice.StopReason = "SAFETY"
+	} else if rule.ToolCall != nil && data.ToolResult == "" && hasTool(opts.Tools, rule.ToolCall.Name) {
+		args, err := json.Marshal(rule.ToolCall.Arguments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode mock tool arguments: %w", err)
+		}
+		choice.ToolCalls = []llms.ToolCall{{
+			ID:   fmt.Sprintf("mock-call-%d", m.calls.Add(1)),
+			Type: "function",
+			FunctionCall: &llms.FunctionCall{
+				Name:      rule.ToolCall.Name,
+				Arguments: string(args),
+			},
+		}}
+		output = string(args)
+	} else {
+		content, err := m.render(rule, data)
+		if err != nil {
+			return nil, err
+		}
+		choice.Content = content
+		output = content
+	}
+
+	// 与 Gemini 一致，输入 token 数包括缓存内容的 token
+	inputTokens, cachedTokens := 0, 0
+	if name, _ := opts.Metadata[metadataCachedContent].(string); name != "" {
+		tokens, ok := m.cached.Load(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown cached content %s", name)
+		}
+		cachedTokens = tokens.(int)
+		inputTokens = cachedTokens
+	}
+	for _, msg := range messages {
+		for _, part := range msg.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				inputTokens += llm.CountTokens(data.Model, text.Text)
+			}
+		}
+	}
+	outputTokens := llm.CountTokens(data.Model, output)
+	choice.GenerationInfo = map[string]any{
+		"input_tokens":  inputTokens,
+		"output_tokens": outputTokens,
+		"total_tokens":  inputTokens + outputTokens,
+		"model_version": data.Model,
+	}
+	if cachedTokens > 0 {
+		choice.GenerationInfo["cached_tokens"] = cachedTokens
+	}
+	if choice.StopReason == "SAFETY" {
+		choice.GenerationInfo["safety"] = []map[string]any{{"category": rule.Block, "probability": "HIGH", "blocked": true}}
+	}
+	if logprobs, _ := opts.Metadata[llm.MetadataLogprobs].(bool); logprobs && choice.Content != "" {
+		top, _ := opts.Metadata[llm.MetadataTopLogprobs].(int)
+		choice.GenerationInfo["logprobs"] = mockLogprobs(choice.Content, top)
+	}
+
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{choice}}, nil
+}
+
+// CreateCachedContent 模拟创建缓存内容，记录其 token 数
+func (m *mockLLM) CreateCachedContent(ctx context.Context, model string, messages []llms.MessageContent, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	tokens := 0
+	for _, msg := range messages {
+		for _, part := range msg.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				tokens += llm.CountTokens(model, text.Text)
+			}
+		}
+	}
+	name := fmt.Sprintf("cachedContents/mock-%d", m.calls.Add(1))
+	m.cached.Store(name, tokens)
+	return name, nil
+}
+
+// mockLogprobs 按空格切分回复为 token，对数概率由 token 的哈希决定，
+// top 个候选 token 的概率依次递减
+func mockLogprobs(content string, top int) []map[string]any {
+	var logprobs []map[string]any
+	for _, token := range strings.SplitAfter(content, " ") {
+		if token == "" {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(token))
+		logprob := -float64(h.Sum64()%1000) / 1000
+		alternatives := make([]map[string]any, 0, top)
+		for i := range top {
+			alternatives = append(alternatives, map[string]any{
+				"token":   fmt.Sprintf("%s#%d", strings.TrimSpace(token), i),
+				"logprob": logprob - float64(i+1),
+			})
+		}
+		logprobs = append(logprobs, map[string]any{"token": token, "logprob": logprob, "top_logprobs": alternatives})
+	}
+	return logprobs
+}
+
+// Call 对单条提示生成回复
+func (m *mockLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	resp, err := m.GenerateContent(ctx, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, prompt),
+	}, options...)
+	if err != nil {
+		return "", err
+	}
+	return resp.Choices[0].Content, nil
+}
+
+// CreateEmbedding 生成由文本哈希决定的单位向量，相同文本得到相同向量
+func (m *mockLLM) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		h := fnv.New64a()
+		h.Write([]byte(text))
+		rng := rand.New(rand.NewPCG(h.Sum64(), 0))
+
+		vec := make([]float32, mockEmbeddingDimensions)
+		var norm float64
+		for j := range vec {
+			vec[j] = rng.Float32()*2 - 1
+			norm += float64(vec[j] * vec[j])
+		}
+		norm = math.Sqrt(norm)
+		for j := range vec {
+			vec[j] = float32(float64(vec[j]) / norm)
+		}
+		embeddings[i] = vec
+	}
+	return embeddings, nil
+}
+
+// match 返回第一条匹配输入的规则，兜底规则总会匹配
+func (m *mockLLM) match(input string) *mockRule {
+	for _, rule := range m.rules {
+		if rule.re.MatchString(input) {
+			return rule
+		}
+	}
+	return m.rules[len(m.rules)-1]
+}
+
+// render 渲染规则的回复模板，模板为空时使用默认回复
+func (m *mockLLM) render(rule *mockRule, data mockTemplateData) (string, error) {
+	tmpl := rule.response
+	if rule.Response == "" {
+		text := mockDefaultResponse
+		if data.ToolResult != "" {
+			text = mockDefaultToolResponse
+		}
+		tmpl = template.Must(template.New("default").Parse(text))
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render mock response: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// lastTurn 返回最后一条用户消息的文本，以及其后的工具调用结果 (如果有)
+func lastTurn(messages []llms.MessageContent) (input, toolName, toolResult string) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		for _, part := range msg.Parts {
+			switch p := part.(type) {
+			case llms.ToolCallResponse:
+				if toolResult == "" {
+					toolName, toolResult = p.Name, p.Content
+				}
+			case llms.TextContent:
+				if msg.Role == llms.ChatMessageTypeHuman {
+					input = p.Text
+				}
+			}
+		}
+		if msg.Role == llms.ChatMessageTypeHuman && input != "" {
+			return strings.TrimSpace(input), toolName, toolResult
+		}
+	}
+	return strings.TrimSpace(input), toolName, toolResult
+}
+
+// hasTool 判断请求是否提供了指定名称的工具
+func hasTool(tools []llms.Tool, name string) bool {
+	for _, tool := range tools {
+		if tool.Function != nil && tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
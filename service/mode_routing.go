@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// modeRoutingMetrics counts the modes auto requests were routed to, per
+// mode and per source of the decision, exported under /api/metrics
+var modeRoutingMetrics = expvar.NewMap("mode_routing")
+
+// Sources of routing decisions, reported in the logs and metrics
+const (
+	routedByHeuristics = "heuristics"
+	routedByModel      = "model"
+	// routedByIntent is a request routed by the intent the intent
+	// classifiers attached to it
+	routedByIntent = "intent"
+	// routedByDefault is an ambiguous request the model didn't settle,
+	// served by the heuristics' best guess
+	routedByDefault = "default"
+)
+
+// intentModes maps intents to the modes that serve them
+var intentModes = map[string]genaidemo.Mode{
+	intentSmalltalk:   genaidemo.Mode_MODE_CHAT,
+	intentGeneral:     genaidemo.Mode_MODE_CHAT,
+	intentDocQuestion: genaidemo.Mode_MODE_DOC,
+	intentCalculation: genaidemo.Mode_MODE_TOOL,
+	intentLiveData:    genaidemo.Mode_MODE_TOOL,
+	intentTask:        genaidemo.Mode_MODE_AGENT,
+}
+
+// modeLabels describe the modes to the routing model
+var modeLabels = []llm.ClassLabel{
+	{Name: "chat", Description: "general conversation, writing, explanations and questions answerable from general knowledge", Examples: []string{"Write a haiku about autumn", "What is the difference between TCP and UDP?"}},
+	{Name: "tool", Description: "needs a calculation or live information from the web, such as news, weather or prices", Examples: []string{"What is 17.5% of 2,340?", "Will it rain in Berlin tomorrow?"}},
+	{Name: "doc", Description: "asks about the organization's own documents, policies, manuals or uploaded files", Examples: []string{"How many vacation days do new employees get?", "What does the handbook say about expense reports?"}},
+	{Name: "agent", Description: "a complex, multi-step task that needs planning, research or several actions", Examples: []string{"Plan a three-day team offsite in Lisbon with a budget", "Research our competitors' pricing and draft a comparison"}},
+}
+
+// modeLabelModes maps the labels of the routing model to modes
+var modeLabelModes = map[string]genaidemo.Mode{
+	"chat":  genaidemo.Mode_MODE_CHAT,
+	"tool":  genaidemo.Mode_MODE_TOOL,
+	"doc":   genaidemo.Mode_MODE_DOC,
+	"agent": genaidemo.Mode_MODE_AGENT,
+}
+
+// modeRouter picks the mode of auto requests: heuristics settle the clear
+// cases, and the routing model, when configured, the ambiguous ones
+type modeRouter struct {
+	// model classifies ambiguous requests; heuristics only when empty
+	model string
+	// minConfidence is the confidence below which the model's label is
+	// ignored in favor of the heuristics' guess
+	minConfidence float64
+}
+
+// routeByHeuristics returns the mode of a request, and whether the
+// heuristics are sure of it: the request sets retrieval options, or the
+// intent rules are sure of the intent of its latest user message
+func routeByHeuristics(req *genaidemo.ChatRequest) (genaidemo.Mode, bool) {
+	if req.Retrieval != nil {
+		return genaidemo.Mode_MODE_DOC, true
+	}
+	intent, sure := matchIntent(latestUserMessage(req.Messages))
+	return intentModes[intent], sure
+}
+
+// routeMode picks the mode of an auto request, returning it with the source
+// of the decision. Failures of the routing model fall back to the
+// heuristics' guess rather than failing the request.
+func (h *Handler) routeMode(ctx context.Context, req *genaidemo.ChatRequest) (genaidemo.Mode, string) {
+	if intent := intentFromContext(ctx); intent != nil && req.Retrieval == nil {
+		if mode, ok := intentModes[intent.Name]; ok {
+			return mode, routedByIntent
+		}
+	}
+	mode, sure := routeByHeuristics(req)
+	if sure {
+		return mode, routedByHeuristics
+	}
+	if h.router.model == "" {
+		return mode, routedByDefault
+	}
+
+	query := latestUserMessage(req.Messages)
+	if strings.TrimSpace(query) == "" {
+		return mode, routedByDefault
+	}
+
+	// Routing calls are provider calls too, released before the chat call
+	release, err := h.limiter.acquire(ctx, h.requestPriority(ctx, req))
+	if err != nil {
+		return mode, routedByDefault
+	}
+	defer release()
+
+	routeCtx := withModelOverride(ctx, h.router.model)
+	classification, usage, err := h.service.ClassifyText(routeCtx, truncateUTF8(query, MaxClassifyTextLength), modeLabels)
+	if err != nil {
+		modeRoutingMetrics.Add("errors", 1)
+		log.Printf("⚠️ [auto] Routing model failed, using %s: %v", mode, err)
+		return mode, routedByDefault
+	}
+	h.recordLLMUsage(ctx, h.router.model, usage)
+	if classification.Confidence < h.router.minConfidence {
+		return mode, routedByDefault
+	}
+	return modeLabelModes[classification.Label], routedByModel
+}
+
+// latestUserMessage returns the content of the last user message, empty
+// when there is none
+func latestUserMessage(messages []*genaidemo.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == genaidemo.Role_ROLE_USER {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// autoMode picks the mode of an auto request, returning it with the context
+// carrying the request's intent
+func (h *Handler) autoMode(ctx context.Context, req *genaidemo.ChatRequest) (context.Context, genaidemo.Mode) {
+	ctx = h.classifyIntent(ctx, req)
+	mode, source := h.routeMode(ctx, req)
+	modeRoutingMetrics.Add(mode.String(), 1)
+	modeRoutingMetrics.Add(source, 1)
+	log.Printf("🧭 [auto] Routed to %s by %s", mode, source)
+	return ctx, mode
+}
+
+// ChatAuto handles the ChatAuto gRPC method, serving the request with the
+// mode the router picks for it
+func (h *Handler) ChatAuto(ctx context.Context, req *genaidemo.ChatRequest) (*genaidemo.ChatResponse, error) {
+	ctx, mode := h.autoMode(ctx, req)
+	return h.chatWithMode(ctx, mode, req)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"regexp"
+)
+
+// piiPattern finds one kind of personal data, replaced by its label
+type piiPattern struct {
+	label string
+	re    *regexp.Regexp
+	// valid, when set, rejects matches that only look like personal data
+	valid func(match string) bool
+}
+
+// piiPatterns are applied in order, most specific first, so e.g. card
+// numbers and IP addresses aren't taken for phone numbers
+var piiPatterns = []piiPattern{
+	{label: "[EMAIL]", re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{label: "[CARD]", re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhnValid},
+	{label: "[IBAN]", re: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`)},
+	{label: "[SSN]", re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{label: "[IP]", re: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)},
+	{label: "[PHONE]", re: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?\d{3,4}[ .-]\d{3,4}|\b\d{2,4}[ .-]\d{3,4}[ .-]?\d{3,4})\b`)},
+}
+
+// redactPII replaces email addresses, card numbers, IBANs, social security
+// numbers, IP addresses and phone numbers in text with placeholders such as
+// [EMAIL], returning the redacted text and the number of replacements
+func redactPII(text string) (string, int) {
+	redactions := 0
+	for _, p := range piiPatterns {
+		text = p.re.ReplaceAllStringFunc(text, func(match string) string {
+			if p.valid != nil && !p.valid(match) {
+				return match
+			}
+			redactions++
+			return p.label
+		})
+	}
+	return text, redactions
+}
+
+// luhnValid reports whether the digits of a number pass the Luhn checksum
+// of card numbers
+func luhnValid(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
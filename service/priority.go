@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/metadata"
+)
+
+// priorityHeader carries an explicit request priority on HTTP requests and,
+// lower cased, in gRPC metadata
+const priorityHeader = "X-Priority"
+
+// priorityTiers is the number of scheduling tiers: high, normal and low
+const priorityTiers = 3
+
+// priorityTier maps a priority to its scheduling tier, 0 being served first.
+// Unspecified priorities are treated as normal.
+func priorityTier(p genaidemo.Priority) int {
+	switch p {
+	case genaidemo.Priority_PRIORITY_HIGH:
+		return 0
+	case genaidemo.Priority_PRIORITY_LOW:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// parsePriority parses "low", "normal", "high" or the PRIORITY_* enum names
+func parsePriority(s string) (genaidemo.Priority, bool) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	if !strings.HasPrefix(name, "PRIORITY_") {
+		name = "PRIORITY_" + name
+	}
+	p, ok := genaidemo.Priority_value[name]
+	if !ok || p == int32(genaidemo.Priority_PRIORITY_UNSPECIFIED) {
+		return genaidemo.Priority_PRIORITY_UNSPECIFIED, false
+	}
+	return genaidemo.Priority(p), true
+}
+
+type priorityKey struct{}
+
+// withPriority attaches an explicitly requested priority to the context
+func withPriority(ctx context.Context, p genaidemo.Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFromContext returns the priority requested through the X-Priority
+// header or gRPC metadata
+func priorityFromContext(ctx context.Context) (genaidemo.Priority, bool) {
+	if p, ok := ctx.Value(priorityKey{}).(genaidemo.Priority); ok {
+		return p, true
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(priorityHeader); len(values) > 0 {
+			return parsePriority(values[0])
+		}
+	}
+	return genaidemo.Priority_PRIORITY_UNSPECIFIED, false
+}
+
+// requestPriority resolves the priority of a request: the request field
+// wins over the X-Priority header, which wins over the API key's tier.
+// Explicit priorities can only lower the key's tier, normal for keys without
+// one, so callers can't jump the queue. Returns unspecified when none applies.
+func (h *Handler) requestPriority(ctx context.Context, req *genaidemo.ChatRequest) genaidemo.Priority {
+	requested := req.Priority
+	if requested == genaidemo.Priority_PRIORITY_UNSPECIFIED {
+		requested, _ = priorityFromContext(ctx)
+	}
+	tier, hasTier := h.keyTiers[apiKeyIDFromContext(ctx)]
+	if requested == genaidemo.Priority_PRIORITY_UNSPECIFIED {
+		if hasTier {
+			return tier
+		}
+		return genaidemo.Priority_PRIORITY_UNSPECIFIED
+	}
+
+	ceiling := genaidemo.Priority_PRIORITY_NORMAL
+	if hasTier {
+		ceiling = tier
+	}
+	if priorityTier(requested) < priorityTier(ceiling) {
+		return ceiling
+	}
+	return requested
+}
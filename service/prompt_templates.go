@@ -0,0 +1,391 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// promptTemplate is a stored named template. Every update adds a version;
+// the top-level fields mirror the active version, which serves requests that
+// don't pin one.
+type promptTemplate struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Role        genaidemo.Role `json:"role"`
+	Template    string         `json:"template"`
+	Version     int32          `json:"version"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+
+	Versions []*promptTemplateVersion `json:"versions"`
+}
+
+// promptTemplateVersion is an immutable version of a template
+type promptTemplateVersion struct {
+	Version     int32          `json:"version"`
+	Description string         `json:"description,omitempty"`
+	Role        genaidemo.Role `json:"role"`
+	Template    string         `json:"template"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// templateStore keeps named prompt templates in memory, optionally persisting
+// them to a JSON file so they survive restarts
+type templateStore struct {
+	mu        sync.RWMutex
+	templates map[string]*promptTemplate
+	path      string
+}
+
+// newTemplateStore creates a template store, loading existing templates from
+// path when it is set
+func newTemplateStore(path string) (*templateStore, error) {
+	s := &templateStore{
+		templates: make(map[string]*promptTemplate),
+		path:      path,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt templates: %w", err)
+	}
+
+	var templates []*promptTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt templates: %w", err)
+	}
+	for _, t := range templates {
+		// Templates saved before versioning become their first version
+		if len(t.Versions) == 0 {
+			t.Version = 1
+			t.Versions = []*promptTemplateVersion{{
+				Version:     1,
+				Description: t.Description,
+				Role:        t.Role,
+				Template:    t.Template,
+				CreatedAt:   t.UpdatedAt,
+			}}
+		}
+		s.templates[t.Name] = t
+	}
+
+	log.Printf("📝 Loaded %d prompt templates from %s", len(templates), path)
+	return s, nil
+}
+
+// create stores a new template, failing if the name is taken
+func (s *templateStore) create(pb *genaidemo.PromptTemplate) (*genaidemo.PromptTemplate, error) {
+	if err := validatePromptTemplate(pb); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.templates[pb.Name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "prompt template %s already exists", pb.Name)
+	}
+
+	now := time.Now()
+	t := &promptTemplate{Name: pb.Name, CreatedAt: now}
+	t.addVersion(pb, now)
+	s.templates[t.Name] = t
+	if err := s.save(); err != nil {
+		delete(s.templates, t.Name)
+		return nil, err
+	}
+	return t.toProto(), nil
+}
+
+// update saves a new version of an existing template and makes it active
+func (s *templateStore) update(pb *genaidemo.PromptTemplate) (*genaidemo.PromptTemplate, error) {
+	if err := validatePromptTemplate(pb); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.templates[pb.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "prompt template %s not found", pb.Name)
+	}
+
+	t := old.clone()
+	t.addVersion(pb, time.Now())
+	s.templates[t.Name] = t
+	if err := s.save(); err != nil {
+		s.templates[t.Name] = old
+		return nil, err
+	}
+	log.Printf("📝 Prompt template %s updated to version %d", t.Name, t.Version)
+	return t.toProto(), nil
+}
+
+// activate makes an earlier (or later) version of a template the active one,
+// e.g. to roll back a prompt change
+func (s *templateStore) activate(name string, version int32) (*genaidemo.PromptTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.templates[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "prompt template %s not found", name)
+	}
+	v, err := old.version(version)
+	if err != nil {
+		return nil, err
+	}
+
+	t := old.clone()
+	t.setActive(v, time.Now())
+	s.templates[name] = t
+	if err := s.save(); err != nil {
+		s.templates[name] = old
+		return nil, err
+	}
+	log.Printf("📝 Prompt template %s switched to version %d", name, version)
+	return t.toProto(), nil
+}
+
+// get returns the template with the given name at the given version, or at
+// its active version when version is 0
+func (s *templateStore) get(name string, version int32) (*genaidemo.PromptTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.templates[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "prompt template %s not found", name)
+	}
+	if version == 0 {
+		return t.toProto(), nil
+	}
+	v, err := t.version(version)
+	if err != nil {
+		return nil, err
+	}
+	return t.versionProto(v), nil
+}
+
+// versions returns every version of a template, oldest first
+func (s *templateStore) versions(name string) ([]*genaidemo.PromptTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.templates[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "prompt template %s not found", name)
+	}
+	versions := make([]*genaidemo.PromptTemplate, len(t.Versions))
+	for i, v := range t.Versions {
+		versions[i] = t.versionProto(v)
+	}
+	return versions, nil
+}
+
+// list returns all templates sorted by name
+func (s *templateStore) list() []*genaidemo.PromptTemplate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := make([]*genaidemo.PromptTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, t.toProto())
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates
+}
+
+// delete removes the template with the given name
+func (s *templateStore) delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.templates[name]
+	if !ok {
+		return status.Errorf(codes.NotFound, "prompt template %s not found", name)
+	}
+	delete(s.templates, name)
+	if err := s.save(); err != nil {
+		s.templates[name] = old
+		return err
+	}
+	return nil
+}
+
+// render renders the named template into a message with the template's
+// role, using the given version or the active one when version is 0. It
+// returns which version was rendered.
+func (s *templateStore) render(name string, version int32, variables map[string]string) (*genaidemo.Message, *genaidemo.PromptTemplateRef, error) {
+	s.mu.RLock()
+	t, ok := s.templates[name]
+	var v *promptTemplateVersion
+	var err error
+	if ok {
+		v, err = t.version(cmp.Or(version, t.Version))
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return nil, nil, status.Errorf(codes.NotFound, "prompt template %s not found", name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := llm.RenderTemplate(v.Template, variables)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := &genaidemo.Message{
+		Role:    v.Role,
+		Content: content,
+	}
+	return msg, &genaidemo.PromptTemplateRef{Name: name, Version: v.Version}, nil
+}
+
+// save writes all templates to the store file. Callers must hold the lock.
+func (s *templateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	templates := make([]*promptTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	data, err := json.MarshalIndent(templates, "", "  ")
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to marshal prompt templates: %v", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return status.Errorf(codes.Internal, "failed to save prompt templates: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, readable by the owner
+// only. It writes a temporary file in the same directory and renames it over
+// path, so a crash never leaves a truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// addVersion appends a new version with the content of pb and activates it
+func (t *promptTemplate) addVersion(pb *genaidemo.PromptTemplate, now time.Time) {
+	v := &promptTemplateVersion{
+		Version:     int32(len(t.Versions)) + 1,
+		Description: pb.Description,
+		Role:        pb.Role,
+		Template:    pb.Template,
+		CreatedAt:   now,
+	}
+	t.Versions = append(t.Versions, v)
+	t.setActive(v, now)
+}
+
+// setActive makes v the active version
+func (t *promptTemplate) setActive(v *promptTemplateVersion, now time.Time) {
+	t.Version = v.Version
+	t.Description = v.Description
+	t.Role = v.Role
+	t.Template = v.Template
+	t.UpdatedAt = now
+}
+
+// version returns the given version of the template
+func (t *promptTemplate) version(version int32) (*promptTemplateVersion, error) {
+	if version < 1 || int(version) > len(t.Versions) {
+		return nil, status.Errorf(codes.NotFound, "prompt template %s has no version %d", t.Name, version)
+	}
+	return t.Versions[version-1], nil
+}
+
+// clone copies the template so updates can be rolled back when saving fails.
+// Versions are immutable and shared.
+func (t *promptTemplate) clone() *promptTemplate {
+	c := *t
+	c.Versions = slices.Clone(t.Versions)
+	return &c
+}
+
+func (t *promptTemplate) toProto() *genaidemo.PromptTemplate {
+	return &genaidemo.PromptTemplate{
+		Name:        t.Name,
+		Description: t.Description,
+		Role:        t.Role,
+		Template:    t.Template,
+		CreatedAt:   t.CreatedAt.Unix(),
+		UpdatedAt:   t.UpdatedAt.Unix(),
+		Version:     t.Version,
+	}
+}
+
+// versionProto returns a version of the template in its API representation
+func (t *promptTemplate) versionProto(v *promptTemplateVersion) *genaidemo.PromptTemplate {
+	return &genaidemo.PromptTemplate{
+		Name:        t.Name,
+		Description: v.Description,
+		Role:        v.Role,
+		Template:    v.Template,
+		CreatedAt:   t.CreatedAt.Unix(),
+		UpdatedAt:   v.CreatedAt.Unix(),
+		Version:     v.Version,
+	}
+}
+
+func validatePromptTemplate(pb *genaidemo.PromptTemplate) error {
+	if pb.Name == "" {
+		return status.Error(codes.InvalidArgument, "template name cannot be empty")
+	}
+	if pb.Template == "" {
+		return status.Error(codes.InvalidArgument, "template cannot be empty")
+	}
+	if pb.Role != genaidemo.Role_ROLE_SYSTEM && pb.Role != genaidemo.Role_ROLE_USER {
+		return status.Error(codes.InvalidArgument, "template role must be ROLE_SYSTEM or ROLE_USER")
+	}
+	return llm.ValidateTemplate(pb.Template)
+}
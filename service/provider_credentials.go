@@ -0,0 +1,230 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// providerPoolMetrics counts clients created and evicted for callers' own
+// provider credentials, exported under /api/metrics
+var providerPoolMetrics = expvar.NewMap("provider_credentials")
+
+// providerCredentials are the credentials of a caller's own provider account.
+// Calls made with them are billed to that account instead of the service's.
+type providerCredentials struct {
+	// apiKey is a Gemini API key
+	apiKey string
+	// project, location and credentialsJSON select a Vertex AI project and
+	// the service account key used to call it
+	project         string
+	location        string
+	credentialsJSON []byte
+}
+
+// newProviderCredentials validates the provider credentials of a request,
+// nil when it has none
+func newProviderCredentials(pc *genaidemo.ProviderCredentials) (*providerCredentials, error) {
+	if pc == nil {
+		return nil, nil
+	}
+	creds := &providerCredentials{
+		apiKey:          pc.GetApiKey(),
+		project:         pc.GetProject(),
+		location:        pc.GetLocation(),
+		credentialsJSON: []byte(pc.GetCredentialsJson()),
+	}
+	if err := creds.validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid provider_credentials: %v", err)
+	}
+	return creds, nil
+}
+
+// validate checks that exactly one kind of credentials is set
+func (c *providerCredentials) validate() error {
+	switch {
+	case c.apiKey != "" && (c.project != "" || len(c.credentialsJSON) > 0):
+		return fmt.Errorf("set either api_key or project and credentials_json")
+	case c.apiKey != "":
+		return nil
+	case c.project == "" || len(c.credentialsJSON) == 0:
+		return fmt.Errorf("api_key, or project and credentials_json, are required")
+	case !json.Valid(c.credentialsJSON):
+		return fmt.Errorf("credentials_json is not valid JSON")
+	}
+	return nil
+}
+
+// fingerprint identifies the credentials without revealing them. Clients,
+// cached responses and coalesced calls are never shared across fingerprints.
+func (c *providerCredentials) fingerprint() string {
+	if c == nil {
+		return ""
+	}
+	h := sha256.New()
+	writeString(h, c.apiKey)
+	writeString(h, c.project)
+	writeString(h, c.location)
+	writeString(h, string(c.credentialsJSON))
+	return "creds_" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// tenantProviderConfig are the provider credentials of a tenant in the
+// tenants file. Secrets are read from the environment or a file rather than
+// stored inline.
+type tenantProviderConfig struct {
+	// APIKeyEnv names the environment variable holding a Gemini API key
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+	// Project and Location select the Vertex AI project billed for calls,
+	// authenticated with the service account key in CredentialsFile
+	Project         string `json:"project,omitempty"`
+	Location        string `json:"location,omitempty"`
+	CredentialsFile string `json:"credentials_file,omitempty"`
+}
+
+// load reads the tenant's secrets and validates the resulting credentials
+func (cfg *tenantProviderConfig) load() (*providerCredentials, error) {
+	creds := &providerCredentials{project: cfg.Project, location: cfg.Location}
+	if cfg.APIKeyEnv != "" {
+		creds.apiKey = os.Getenv(cfg.APIKeyEnv)
+		if creds.apiKey == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", cfg.APIKeyEnv)
+		}
+	}
+	if cfg.CredentialsFile != "" {
+		data, err := os.ReadFile(cfg.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials file: %w", err)
+		}
+		creds.credentialsJSON = data
+	}
+	if err := creds.validate(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+type providerCredentialsKey struct{}
+
+// withProviderCredentials makes the LLM calls made with ctx use the caller's
+// own provider credentials
+func withProviderCredentials(ctx context.Context, creds *providerCredentials) context.Context {
+	return context.WithValue(ctx, providerCredentialsKey{}, creds)
+}
+
+// providerCredentialsFromContext returns the provider credentials of the
+// request, or else of the caller's tenant; nil when calls use the service's
+// own account
+func providerCredentialsFromContext(ctx context.Context) *providerCredentials {
+	if creds, ok := ctx.Value(providerCredentialsKey{}).(*providerCredentials); ok && creds != nil {
+		return creds
+	}
+	if t := tenantFromContext(ctx); t != nil {
+		return t.provider
+	}
+	return nil
+}
+
+// providerPool keeps provider clients for callers' own credentials, keyed by
+// their fingerprint, so repeat callers reuse connections. The least recently
+// used client is evicted when the pool is full, and closed once the calls
+// still using it are done.
+type providerPool struct {
+	newClient func(creds *providerCredentials) (IVertexAI, error)
+	maxSize   int
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	lru     *list.List
+}
+
+type providerPoolEntry struct {
+	fingerprint string
+	client      IVertexAI
+	// refs counts the calls using the client
+	refs    int
+	evicted bool
+}
+
+// newProviderPool creates a pool of at most maxSize clients made by newClient
+func newProviderPool(maxSize int, newClient func(creds *providerCredentials) (IVertexAI, error)) *providerPool {
+	return &providerPool{
+		newClient: newClient,
+		maxSize:   maxSize,
+		clients:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// acquire returns the client of the credentials, creating it on first use.
+// The caller must call release once its call is done.
+func (p *providerPool) acquire(creds *providerCredentials) (IVertexAI, func(), error) {
+	fingerprint := creds.fingerprint()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var entry *providerPoolEntry
+	if elem, ok := p.clients[fingerprint]; ok {
+		p.lru.MoveToFront(elem)
+		entry = elem.Value.(*providerPoolEntry)
+	} else {
+		client, err := p.newClient(creds)
+		if err != nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "failed to create a client for the provider credentials: %v", err)
+		}
+		providerPoolMetrics.Add("created", 1)
+		log.Printf("🔑 Created provider client for caller credentials %s", fingerprint)
+
+		entry = &providerPoolEntry{fingerprint: fingerprint, client: client}
+		p.clients[fingerprint] = p.lru.PushFront(entry)
+		p.evictLocked()
+	}
+
+	entry.refs++
+	return entry.client, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		entry.refs--
+		if entry.evicted && entry.refs == 0 {
+			closeProviderClient(entry.client)
+		}
+	}, nil
+}
+
+// evictLocked drops the least recently used clients over the pool size.
+// Must be called with p.mu held.
+func (p *providerPool) evictLocked() {
+	for p.lru.Len() > p.maxSize {
+		oldest := p.lru.Back()
+		entry := oldest.Value.(*providerPoolEntry)
+		p.lru.Remove(oldest)
+		delete(p.clients, entry.fingerprint)
+		entry.evicted = true
+		if entry.refs == 0 {
+			closeProviderClient(entry.client)
+		}
+		providerPoolMetrics.Add("evicted", 1)
+	}
+}
+
+// closeProviderClient closes the connections of a client, if it has any
+func closeProviderClient(client IVertexAI) {
+	if closer, ok := client.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("⚠️ Failed to close provider client: %v", err)
+		}
+	}
+}
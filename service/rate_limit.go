@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rateLimitMetrics counts allowed and rejected requests, exported under
+// /api/metrics
+var rateLimitMetrics = expvar.NewMap("rate_limit")
+
+// rateLimitError rejects a request of an API key that exceeded its request
+// rate. It maps to codes.ResourceExhausted and carries the delay after which
+// the key may call again.
+type rateLimitError struct {
+	keyID      string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s, retry after %v", e.keyID, e.retryAfter)
+}
+
+// GRPCStatus reports the error as ResourceExhausted with a RetryInfo detail
+// carrying the retry delay
+func (e *rateLimitError) GRPCStatus() *status.Status {
+	return statusWithDetails(status.New(codes.ResourceExhausted, e.Error()),
+		errorInfo(reasonRateLimited, map[string]string{"key_id": e.keyID}), retryInfo(e.retryAfter))
+}
+
+// rateLimitScript takes a token from the bucket of KEYS[1], refilled at
+// ARGV[1] tokens per second up to ARGV[2] tokens. It returns 0 when a token
+// was taken, otherwise the milliseconds until one is available. The Redis
+// clock is used so every replica refills buckets alike.
+const rateLimitScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+else
+  wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return wait
+`
+
+// rateLimiter gives every API key id its own token bucket refilled at rps
+// requests per second, allowing bursts of up to burst requests. It guards
+// both the HTTP and the gRPC server. With Redis, buckets are shared by all
+// replicas; the in-process buckets take over while Redis is unavailable.
+type rateLimiter struct {
+	limit  rate.Limit
+	burst  int
+	shared *redisClient
+
+	mu   sync.Mutex
+	keys map[string]*rate.Limiter
+}
+
+// newRateLimiter creates a per-key rate limiter, sharing its buckets through
+// Redis unless shared is nil. A zero rps disables it.
+func newRateLimiter(rps float64, burst int, shared *redisClient) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	log.Printf("⏱️  Rate limit enabled (%.2f requests/s per API key, burst %d)", rps, burst)
+	return &rateLimiter{
+		limit:  rate.Limit(rps),
+		burst:  burst,
+		shared: shared,
+		keys:   make(map[string]*rate.Limiter),
+	}
+}
+
+// allow takes a token from the key's bucket, returning a rateLimitError when
+// it is empty. A nil limiter allows everything.
+func (l *rateLimiter) allow(keyID string) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.shared != nil {
+		if delay, err := l.sharedDelay(keyID); err == nil {
+			if delay > 0 {
+				rateLimitMetrics.Add("rejected", 1)
+				return &rateLimitError{keyID: keyID, retryAfter: delay}
+			}
+			rateLimitMetrics.Add("allowed", 1)
+			return nil
+		}
+		rateLimitMetrics.Add("local_fallback", 1)
+	}
+
+	l.mu.Lock()
+	limiter, ok := l.keys[keyID]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.keys[keyID] = limiter
+	}
+	l.mu.Unlock()
+
+	r := limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		// Give the token back, the request is rejected rather than delayed
+		r.Cancel()
+		rateLimitMetrics.Add("rejected", 1)
+		return &rateLimitError{keyID: keyID, retryAfter: delay}
+	}
+	rateLimitMetrics.Add("allowed", 1)
+	return nil
+}
+
+// sharedDelay takes a token from the key's bucket in Redis, returning how
+// long the key must wait when it is empty
+func (l *rateLimiter) sharedDelay(keyID string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.shared.timeout)
+	defer cancel()
+	reply, err := l.shared.eval(ctx, rateLimitScript, []string{redisKeyPrefix + "ratelimit:" + keyID},
+		float64(l.limit), l.burst)
+	if err != nil {
+		return 0, err
+	}
+	ms, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
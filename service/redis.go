@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// redisMetrics counts Redis commands and failures, exported under
+// /api/metrics
+var redisMetrics = expvar.NewMap("redis")
+
+// redisKeyPrefix namespaces the keys the service stores in Redis
+const redisKeyPrefix = "genai:"
+
+// redisError is an error reply of the Redis server. Unlike connection
+// errors, it leaves the connection usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisClient is a minimal Redis client speaking RESP over a small pool of
+// connections. It holds the state shared by the replicas of the service:
+// rate limit buckets and monthly spend.
+type redisClient struct {
+	addr     string
+	password string
+	username string
+	db       int
+	tls      *tls.Config
+	timeout  time.Duration
+
+	// idle holds the connections not in use
+	idle chan *redisConn
+	// healthy tracks reachability, so failures are logged once per outage
+	healthy atomic.Bool
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newRedisClient creates a client for a redis:// or rediss:// URL, e.g.
+// redis://:password@redis:6379/0. An empty URL returns nil: state then stays
+// in each process.
+func newRedisClient(rawURL string, poolSize int, timeout time.Duration) (*redisClient, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	c := &redisClient{
+		addr:    u.Host,
+		timeout: timeout,
+		idle:    make(chan *redisConn, max(poolSize, 1)),
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid REDIS_URL: unsupported scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: database %q is not a number", db)
+		}
+	}
+
+	c.healthy.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// An unreachable Redis is logged by do: limits fall back to each
+	// process until it is reachable
+	if _, err := c.do(ctx, "PING"); err == nil {
+		log.Printf("🧮 Sharing rate limits and budgets through Redis at %s", c.addr)
+	}
+	return c, nil
+}
+
+// do sends a command and returns its reply: a string, an int64, nil, or a
+// []any of those. Error replies are returned as redisError.
+func (c *redisClient) do(ctx context.Context, args ...any) (any, error) {
+	redisMetrics.Add("commands", 1)
+	conn, err := c.get(ctx)
+	if err == nil {
+		var reply any
+		if reply, err = conn.command(ctx, c.timeout, args...); err == nil || isRedisError(err) {
+			c.put(conn)
+			c.markHealthy()
+			return reply, err
+		}
+		conn.Close()
+	}
+
+	redisMetrics.Add("failures", 1)
+	if c.healthy.Swap(false) {
+		log.Printf("⚠️ Redis at %s is unavailable, falling back to per-process limits: %v", c.addr, err)
+	}
+	return nil, err
+}
+
+func (c *redisClient) markHealthy() {
+	if !c.healthy.Swap(true) {
+		log.Printf("🧮 Redis at %s is reachable again", c.addr)
+	}
+}
+
+// eval runs a Lua script by its SHA1, loading it on first use
+func (c *redisClient) eval(ctx context.Context, script string, keys []string, args ...any) (any, error) {
+	sum := sha1.Sum([]byte(script))
+	cmd := append([]any{"EVALSHA", hex.EncodeToString(sum[:]), len(keys)}, toAny(keys)...)
+	cmd = append(cmd, args...)
+	reply, err := c.do(ctx, cmd...)
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", script
+		reply, err = c.do(ctx, cmd...)
+	}
+	return reply, err
+}
+
+func toAny(values []string) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+// get returns an idle connection, or dials a new one
+func (c *redisClient) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.tls}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		args := []any{"AUTH", c.password}
+		if c.username != "" {
+			args = []any{"AUTH", c.username, c.password}
+		}
+		if _, err := rc.command(ctx, c.timeout, args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis authentication failed: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.command(ctx, c.timeout, "SELECT", c.db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (c *redisClient) put(conn *redisConn) {
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// close closes the idle connections
+func (c *redisClient) close() {
+	if c == nil {
+		return
+	}
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// command writes a command and reads its reply, within timeout or the
+// deadline of ctx, whichever comes first
+func (conn *redisConn) command(ctx context.Context, timeout time.Duration, args ...any) (any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			s = fmt.Sprint(v)
+		}
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(s), s)
+	}
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		return nil, err
+	}
+	return conn.readReply()
+}
+
+func (conn *redisConn) readReply() (any, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]any, n)
+		for i := range values {
+			// Keep reading after an error element so the connection stays
+			// in sync
+			values[i], err = conn.readReply()
+			if err != nil && !isRedisError(err) {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func isRedisError(err error) bool {
+	var re redisError
+	return errors.As(err, &re)
+}
+
+// redisFloat converts a bulk string reply, e.g. of INCRBYFLOAT or GET, to a
+// float. A missing key reads as zero.
+func redisFloat(reply any) (float64, error) {
+	switch v := reply.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("redis: unexpected reply %v", reply)
+}
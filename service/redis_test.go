@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a RESP server answering each command with the raw reply of
+// its reply function, recording the commands and connections it receives
+type fakeRedis struct {
+	ln    net.Listener
+	reply func(args []string) string
+
+	mu       sync.Mutex
+	commands [][]string
+	conns    int
+}
+
+func newFakeRedis(t *testing.T, reply func(args []string) string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, reply: reply}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		f.mu.Unlock()
+
+		reply := "+PONG\r\n"
+		if args[0] != "PING" {
+			reply = f.reply(args)
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "*"), "\r\n"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid command header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "$"), "\r\n"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk header %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// received returns the commands received so far, without the PING of
+// newRedisClient
+func (f *fakeRedis) received() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out [][]string
+	for _, cmd := range f.commands {
+		if cmd[0] != "PING" {
+			out = append(out, cmd)
+		}
+	}
+	return out
+}
+
+// connections returns the number of connections accepted so far
+func (f *fakeRedis) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conns
+}
+
+func newFakeRedisClient(t *testing.T, f *fakeRedis, path string) *redisClient {
+	t.Helper()
+	c, err := newRedisClient("redis://"+path+f.ln.Addr().String(), 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.close)
+	return c
+}
+
+func TestRedisReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    any
+		wantErr string
+	}{
+		{"simple string", "+OK\r\n", "OK", ""},
+		{"integer", ":42\r\n", int64(42), ""},
+		{"bulk string", "$5\r\nhello\r\n", "hello", ""},
+		{"binary bulk string", "$4\r\na\r\nb\r\n", "a\r\nb", ""},
+		{"empty bulk string", "$0\r\n\r\n", "", ""},
+		{"nil bulk string", "$-1\r\n", nil, ""},
+		{"array", "*3\r\n$1\r\na\r\n:2\r\n$-1\r\n", []any{"a", int64(2), nil}, ""},
+		{"nested array", "*2\r\n*1\r\n:1\r\n+x\r\n", []any{[]any{int64(1)}, "x"}, ""},
+		// Error elements are read past so the next reply stays in sync
+		{"array with an error element", "*2\r\n-ERR bad element\r\n:7\r\n", []any{nil, int64(7)}, ""},
+		{"error", "-ERR unknown command\r\n", nil, "ERR unknown command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRedis(t, func(args []string) string {
+				if args[0] == "ECHO" {
+					return fmt.Sprintf("$%d\r\n%s\r\n", len(args[1]), args[1])
+				}
+				return tt.reply
+			})
+			c := newFakeRedisClient(t, f, "")
+
+			got, err := c.do(context.Background(), "GET", "k")
+			if tt.wantErr != "" {
+				if !isRedisError(err) || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want redis error %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("do: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reply = %#v, want %#v", got, tt.want)
+			}
+
+			// The connection is reused for the next command and still in sync
+			got, err = c.do(context.Background(), "ECHO", "next")
+			if err != nil || got != "next" {
+				t.Errorf("next reply = %#v, %v, want next", got, err)
+			}
+			if n := f.connections(); n != 1 {
+				t.Errorf("%d connections, want 1", n)
+			}
+		})
+	}
+}
+
+func TestRedisCommandEncoding(t *testing.T) {
+	f := newFakeRedis(t, func(args []string) string { return "+OK\r\n" })
+	c := newFakeRedisClient(t, f, "user:secret@")
+	c.db = 3
+	c.close()
+
+	if _, err := c.do(context.Background(), "SET", "a b\r\nc", 7, int64(-2), 1.5); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	want := [][]string{
+		{"AUTH", "user", "secret"},
+		{"SELECT", "3"},
+		{"SET", "a b\r\nc", "7", "-2", "1.5"},
+	}
+	// The first connection of newRedisClient authenticated too
+	if got := f.received(); !reflect.DeepEqual(got[len(got)-3:], want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestRedisEvalNoScript(t *testing.T) {
+	const script = "return redis.call('INCR', KEYS[1])"
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+
+	var mu sync.Mutex
+	loaded := false
+	f := newFakeRedis(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case args[0] == "EVALSHA" && !loaded:
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		case args[0] == "EVAL":
+			loaded = true
+		}
+		return ":1\r\n"
+	})
+	c := newFakeRedisClient(t, f, "")
+
+	for range 2 {
+		reply, err := c.eval(context.Background(), script, []string{"counter"}, 5)
+		if err != nil || reply != int64(1) {
+			t.Fatalf("eval = %#v, %v, want 1", reply, err)
+		}
+	}
+	want := [][]string{
+		{"EVALSHA", sha, "1", "counter", "5"},
+		{"EVAL", script, "1", "counter", "5"},
+		{"EVALSHA", sha, "1", "counter", "5"},
+	}
+	if got := f.received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	f := newFakeRedis(t, func(args []string) string { return "+OK\r\n" })
+	c := newFakeRedisClient(t, f, "")
+	c.close()
+	f.ln.Close()
+
+	if _, err := c.do(context.Background(), "GET", "k"); err == nil || isRedisError(err) {
+		t.Errorf("err = %v, want a connection error", err)
+	}
+	if c.healthy.Load() {
+		t.Error("client still healthy after a failed command")
+	}
+}
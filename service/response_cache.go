@@ -0,0 +1,226 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"expvar"
+	"hash"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// responseCacheMetrics counts cache hits, misses and evictions, exported
+// under /api/metrics
+var responseCacheMetrics = expvar.NewMap("response_cache")
+
+// responseCache is an exact-match LRU cache of chat results keyed by
+// chatRequestKey
+type responseCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type responseCacheEntry struct {
+	key       string
+	result    *ChatResult
+	expiresAt time.Time
+}
+
+// newResponseCache creates a response cache. A zero ttl or size disables it.
+func newResponseCache(ttl time.Duration, maxSize int) *responseCache {
+	if ttl > 0 && maxSize > 0 {
+		log.Printf("🗄️ Response cache enabled (ttl %v, size %d)", ttl, maxSize)
+	}
+	return &responseCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *responseCache) enabled() bool {
+	return c.ttl > 0 && c.maxSize > 0
+}
+
+// chatRequestKey hashes the caller's tenant, the fingerprint of its document
+// access and provider credentials and the model, mode, messages and generation
+// parameters of a request, along with the knowledge graph relations and the
+// intent added to its prompt. Requests with equal keys are expected to
+// produce equivalent responses, which the response cache and request
+// coalescing rely on; tenants, callers with different document access and
+// provider accounts never share responses, replies stop being served once the
+// relations they were built on change, and replies rendered for one intent
+// aren't served for another.
+func chatRequestKey(tenant, access, credentials, model string, mode genaidemo.Mode, req *genaidemo.ChatRequest, graph, intent string) string {
+	h := sha256.New()
+	writeString(h, tenant)
+	writeString(h, access)
+	writeString(h, credentials)
+	writeString(h, model)
+	writeString(h, mode.String())
+	for _, msg := range req.Messages {
+		writeString(h, msg.Role.String())
+		writeString(h, msg.Content)
+	}
+	if req.Temperature != nil {
+		writeString(h, "temperature")
+		binary.Write(h, binary.BigEndian, math.Float32bits(*req.Temperature))
+	}
+	if req.MaxTokens != nil {
+		writeString(h, "max_tokens")
+		binary.Write(h, binary.BigEndian, *req.MaxTokens)
+	}
+	if req.TopP != nil {
+		writeString(h, "top_p")
+		binary.Write(h, binary.BigEndian, math.Float32bits(*req.TopP))
+	}
+	if req.TopK != nil {
+		writeString(h, "top_k")
+		binary.Write(h, binary.BigEndian, *req.TopK)
+	}
+	for _, stop := range req.StopSequences {
+		writeString(h, "stop_sequence")
+		writeString(h, stop)
+	}
+	if req.PresencePenalty != nil {
+		writeString(h, "presence_penalty")
+		binary.Write(h, binary.BigEndian, math.Float32bits(*req.PresencePenalty))
+	}
+	if req.FrequencyPenalty != nil {
+		writeString(h, "frequency_penalty")
+		binary.Write(h, binary.BigEndian, math.Float32bits(*req.FrequencyPenalty))
+	}
+	if req.CandidateCount != nil {
+		writeString(h, "candidate_count")
+		binary.Write(h, binary.BigEndian, *req.CandidateCount)
+	}
+	if req.Seed != nil {
+		writeString(h, "seed")
+		binary.Write(h, binary.BigEndian, *req.Seed)
+	}
+	if req.GetLogprobs() {
+		writeString(h, "logprobs")
+		binary.Write(h, binary.BigEndian, req.GetTopLogprobs())
+	}
+	if req.GetN() > 1 {
+		writeString(h, "n")
+		binary.Write(h, binary.BigEndian, *req.N)
+	}
+	for _, s := range req.SafetySettings {
+		writeString(h, "safety_setting")
+		writeString(h, s.GetCategory())
+		writeString(h, s.GetThreshold())
+	}
+	if req.ResponseLanguage != nil {
+		writeString(h, "response_language")
+		writeString(h, *req.ResponseLanguage)
+	}
+	if opts := req.Retrieval; opts != nil {
+		writeString(h, "retrieval")
+		if opts.TopK != nil {
+			writeString(h, "top_k")
+			binary.Write(h, binary.BigEndian, *opts.TopK)
+		}
+		if opts.MinRelevance != nil {
+			writeString(h, "min_relevance")
+			binary.Write(h, binary.BigEndian, math.Float64bits(*opts.MinRelevance))
+		}
+		if opts.Collection != nil {
+			writeString(h, "collection")
+			writeString(h, *opts.Collection)
+		}
+		for _, k := range slices.Sorted(maps.Keys(opts.Filter)) {
+			writeString(h, "filter")
+			writeString(h, k)
+			writeString(h, opts.Filter[k])
+		}
+		if opts.IncludeChunks != nil {
+			writeString(h, "include_chunks")
+			binary.Write(h, binary.BigEndian, *opts.IncludeChunks)
+		}
+		if opts.IncludeHighlights != nil {
+			writeString(h, "include_highlights")
+			binary.Write(h, binary.BigEndian, *opts.IncludeHighlights)
+		}
+	}
+	if graph != "" {
+		writeString(h, "graph")
+		writeString(h, graph)
+	}
+	if intent != "" {
+		writeString(h, "intent")
+		writeString(h, intent)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeString writes a length-prefixed string so adjacent fields can't collide
+func writeString(h hash.Hash, s string) {
+	binary.Write(h, binary.BigEndian, uint64(len(s)))
+	h.Write([]byte(s))
+}
+
+// get returns the cached result for key if present and not expired
+func (c *responseCache) get(key string) (*ChatResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		responseCacheMetrics.Add("misses", 1)
+		return nil, false
+	}
+
+	entry := elem.Value.(*responseCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		responseCacheMetrics.Add("expired", 1)
+		responseCacheMetrics.Add("misses", 1)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	responseCacheMetrics.Add("hits", 1)
+	return entry.result, true
+}
+
+// put stores a result, evicting the least recently used entry when full
+func (c *responseCache) put(key string, result *ChatResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*responseCacheEntry)
+		entry.result = result
+		entry.expiresAt = time.Now().Add(c.ttl)
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&responseCacheEntry{
+		key:       key,
+		result:    result,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+
+	for c.lru.Len() > c.maxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*responseCacheEntry).key)
+		responseCacheMetrics.Add("evictions", 1)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// collectionNamePattern matches ChromaDB collection names: 3 to 63
+// characters, starting and ending with a letter or digit
+var collectionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{1,61}[a-zA-Z0-9]$`)
+
+// retrievalOptions are the resolved retrieval parameters of a ChatWithDoc
+// request
+type retrievalOptions struct {
+	topK          int
+	minRelevance  float64
+	collection    string
+	filter        map[string]string
+	includeChunks bool
+	// includeHighlights only applies when chunks are included
+	includeHighlights bool
+}
+
+// validateRetrievalOptions rejects retrieval parameters out of range
+func validateRetrievalOptions(opts *genaidemo.RetrievalOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.TopK != nil && (opts.GetTopK() < 1 || opts.GetTopK() > MaxRetrievalTopK) {
+		return status.Errorf(codes.InvalidArgument, "retrieval top_k must be between 1 and %d", MaxRetrievalTopK)
+	}
+	if opts.MinRelevance != nil && (opts.GetMinRelevance() < 0 || opts.GetMinRelevance() > 1) {
+		return status.Error(codes.InvalidArgument, "retrieval min_relevance must be between 0 and 1")
+	}
+	if opts.Collection != nil && !collectionNamePattern.MatchString(opts.GetCollection()) {
+		return status.Errorf(codes.InvalidArgument, "invalid retrieval collection %q", opts.GetCollection())
+	}
+	for key := range opts.Filter {
+		// Filters are plain metadata equalities: no operators, and the tenant
+		// key is set by the server only
+		if key == "" || strings.HasPrefix(key, "$") || key == tenantMetadataKey {
+			return status.Errorf(codes.InvalidArgument, "invalid retrieval filter key %q", key)
+		}
+	}
+	return nil
+}
+
+type retrievalOptionsKey struct{}
+
+// withRetrievalOptions makes ChatWithDoc calls made with ctx retrieve
+// documents with the given request options
+func withRetrievalOptions(ctx context.Context, opts *genaidemo.RetrievalOptions) context.Context {
+	return context.WithValue(ctx, retrievalOptionsKey{}, opts)
+}
+
+// retrievalOptionsFromContext returns the retrieval parameters of ctx, with
+// tenant or server defaults for the ones the request didn't set
+func retrievalOptionsFromContext(ctx context.Context) retrievalOptions {
+	opts, _ := ctx.Value(retrievalOptionsKey{}).(*genaidemo.RetrievalOptions)
+	resolved := retrievalOptions{
+		topK:          DefaultRetrievalTopK,
+		minRelevance:  opts.GetMinRelevance(),
+		collection:    opts.GetCollection(),
+		filter:        opts.GetFilter(),
+		includeChunks: true,
+	}
+	if t := tenantFromContext(ctx); t != nil {
+		resolved.collection = t.collection
+	}
+	if opts.GetTopK() > 0 {
+		resolved.topK = int(opts.GetTopK())
+	}
+	if opts != nil && opts.IncludeChunks != nil {
+		resolved.includeChunks = opts.GetIncludeChunks()
+	}
+	resolved.includeHighlights = resolved.includeChunks
+	if opts != nil && opts.IncludeHighlights != nil {
+		resolved.includeHighlights = resolved.includeChunks && opts.GetIncludeHighlights()
+	}
+	return resolved
+}
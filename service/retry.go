@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryPolicy retries transient provider failures with jittered exponential
+// backoff
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// defaultRetryPolicy is used by clients not created from service config
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts:    DefaultRetryMaxAttempts,
+	initialBackoff: DefaultRetryInitialBackoff,
+	maxBackoff:     DefaultRetryMaxBackoff,
+}
+
+// newRetryPolicyFromConfig creates the provider retry policy from config
+func newRetryPolicyFromConfig(cfg *serviceConfig) retryPolicy {
+	return retryPolicy{
+		maxAttempts:    max(cfg.retryMaxAttempts, 1),
+		initialBackoff: cfg.retryInitialBackoff,
+		maxBackoff:     cfg.retryMaxBackoff,
+	}
+}
+
+// do runs fn until it succeeds, fails with a non-retryable error, the
+// attempts are exhausted or ctx is done
+func (p retryPolicy) do(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	backoff := p.initialBackoff
+	var err error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		err = fn(ctx)
+		if err == nil || !isRetryable(ctx, err) || attempt == p.maxAttempts {
+			return err
+		}
+
+		// Full jitter spreads retries of concurrent requests apart
+		wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+		log.Printf("🔁 [%s] Attempt %d/%d failed, retrying in %v: %v", op, attempt, p.maxAttempts, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, p.maxBackoff)
+	}
+	return err
+}
+
+// isRetryable reports whether err is a transient provider failure such as a
+// rate limit (429), an unavailable backend (503) or a timeout
+func isRetryable(ctx context.Context, err error) bool {
+	// The caller gave up, retrying cannot help
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	return classifyProviderError(err) != providerFailurePermanent
+}
+
+// providerFailure is the kind of a provider error, shared by retries and the
+// adaptive throttle
+type providerFailure int
+
+const (
+	// providerFailurePermanent errors fail the same way when retried
+	providerFailurePermanent providerFailure = iota
+	// providerFailureTransient errors are server-side failures or timeouts
+	providerFailureTransient
+	// providerFailureRateLimited errors are quota or rate-limit errors,
+	// retryable too
+	providerFailureRateLimited
+)
+
+// rateLimitMarkers and transientMarkers recognize provider errors by their
+// message when langchaingo doesn't wrap them
+var (
+	rateLimitMarkers = []string{"429", "RESOURCE_EXHAUSTED", "Quota exceeded"}
+	transientMarkers = []string{"500", "502", "503", "504", "UNAVAILABLE", "DEADLINE_EXCEEDED"}
+)
+
+// classifyProviderError returns the kind of a provider error. HTTP and gRPC
+// status errors are classified by their code alone; only errors without one
+// fall back to their message.
+func classifyProviderError(err error) providerFailure {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests:
+			return providerFailureRateLimited
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return providerFailureTransient
+		}
+		return providerFailurePermanent
+	}
+
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		switch s.Code() {
+		case codes.ResourceExhausted:
+			return providerFailureRateLimited
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+			return providerFailureTransient
+		}
+		return providerFailurePermanent
+	}
+
+	// langchaingo does not always wrap provider errors, fall back to the message
+	msg := err.Error()
+	for _, marker := range rateLimitMarkers {
+		if strings.Contains(msg, marker) {
+			return providerFailureRateLimited
+		}
+	}
+	for _, marker := range transientMarkers {
+		if strings.Contains(msg, marker) {
+			return providerFailureTransient
+		}
+	}
+	return providerFailurePermanent
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// rolloutMetrics counts requests, failures, latency, tokens, cost and user
+// feedback per endpoint and rollout arm, exported under /api/metrics
+var rolloutMetrics = expvar.NewMap("rollouts")
+
+// The arms of a rollout: the canary serves the new model or prompt version,
+// the stable arm everything else
+const (
+	canaryArm = "canary"
+	stableArm = "stable"
+)
+
+// rolloutBuckets is the resolution of rollout percentages, 0.01%
+const rolloutBuckets = 10000
+
+// rollout gradually moves an endpoint's traffic to a new model or prompt
+// version. Unset fields leave canary requests as they are.
+type rollout struct {
+	Name string `json:"name"`
+	// Percent of the endpoint's traffic served by the canary, 0 to 100.
+	// Setting it to 0 rolls the canary back.
+	Percent float64 `json:"percent"`
+	// Template is rendered into canary requests that don't reference a
+	// template
+	Template string `json:"template,omitempty"`
+	// TemplateVersion pins the version of the template, unless the request
+	// pinned one itself
+	TemplateVersion int32  `json:"template_version,omitempty"`
+	Model           string `json:"model,omitempty"`
+}
+
+// rolloutRouter sends a percentage of each endpoint's traffic to the canary
+// of its rollout. The rollouts file is re-read on SIGHUP, so a rollout can be
+// ramped up or rolled back without a restart.
+type rolloutRouter struct {
+	path     string
+	rollouts atomic.Pointer[map[genaidemo.Mode]*rollout]
+}
+
+// newRolloutRouter loads rollouts from a JSON file mapping endpoint modes to
+// a rollout, e.g.
+//
+//	{"MODE_CHAT": {"name": "flash-2", "percent": 5, "model": "gemini-2.0-flash"}}
+//
+// An empty path disables rollouts.
+func newRolloutRouter(path string) (*rolloutRouter, error) {
+	r := &rolloutRouter{path: path}
+	rollouts, err := loadRollouts(path)
+	if err != nil {
+		return nil, err
+	}
+	r.rollouts.Store(&rollouts)
+	return r, nil
+}
+
+// loadRollouts reads and validates a rollouts file
+func loadRollouts(path string) (map[genaidemo.Mode]*rollout, error) {
+	rollouts := make(map[genaidemo.Mode]*rollout)
+	if path == "" {
+		return rollouts, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rollouts file: %w", err)
+	}
+	var configs map[string]*rollout
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse rollouts file: %w", err)
+	}
+
+	for name, ro := range configs {
+		mode := genaidemo.Mode(genaidemo.Mode_value[name])
+		if mode == genaidemo.Mode_MODE_UNKNOWN {
+			return nil, fmt.Errorf("rollouts file: unknown mode %q", name)
+		}
+		switch {
+		case ro == nil || ro.Name == "":
+			return nil, fmt.Errorf("rollouts file: %s: rollout name cannot be empty", name)
+		case ro.Percent < 0 || ro.Percent > 100:
+			return nil, fmt.Errorf("rollouts file: %s: percent must be between 0 and 100", name)
+		case ro.TemplateVersion < 0:
+			return nil, fmt.Errorf("rollouts file: %s: negative template version", name)
+		}
+		rollouts[mode] = ro
+		log.Printf("🐤 Rollout %s on %s at %g%%", ro.Name, mode, ro.Percent)
+	}
+	return rollouts, nil
+}
+
+// reload re-reads the rollouts file. An invalid file leaves the current
+// rollouts in place.
+func (r *rolloutRouter) reload() error {
+	rollouts, err := loadRollouts(r.path)
+	if err != nil {
+		return err
+	}
+	r.rollouts.Store(&rollouts)
+	return nil
+}
+
+// watchReloads reloads the rollouts file on SIGHUP until ctx is done
+func (r *rolloutRouter) watchReloads(ctx context.Context) {
+	if r.path == "" {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := r.reload(); err != nil {
+				log.Printf("⚠️ Keeping current rollouts: %v", err)
+				continue
+			}
+			log.Printf("🐤 Reloaded rollouts from %s", r.path)
+		}
+	}
+}
+
+// assign routes a request to the given endpoint to an arm of its rollout and
+// applies the canary to the request, returning the context carrying its model
+// and the arm, e.g. "flash-2:canary". The arm is empty when the endpoint has
+// no rollout.
+func (r *rolloutRouter) assign(ctx context.Context, mode genaidemo.Mode, req *genaidemo.ChatRequest) (context.Context, string) {
+	ro := (*r.rollouts.Load())[mode]
+	if ro == nil {
+		return ctx, ""
+	}
+	if !ro.inCanary(rolloutCaller(ctx)) {
+		return ctx, ro.Name + ":" + stableArm
+	}
+
+	if ro.Template != "" && req.GetTemplate() == "" {
+		req.Template = &ro.Template
+	}
+	if ro.TemplateVersion > 0 && req.TemplateVersion == nil && (ro.Template == "" || ro.Template == req.GetTemplate()) {
+		req.TemplateVersion = &ro.TemplateVersion
+	}
+	if ro.Model != "" {
+		ctx = withModelOverride(ctx, ro.Model)
+	}
+	return ctx, ro.Name + ":" + canaryArm
+}
+
+// inCanary reports whether a caller is served by the canary. Callers are
+// placed by a hash of their identity, so each stays on the same arm and
+// raising the percentage only moves stable callers to the canary. Anonymous
+// callers are placed per request.
+func (ro *rollout) inCanary(caller string) bool {
+	var bucket uint64
+	if caller == "" {
+		bucket = rand.Uint64N(rolloutBuckets)
+	} else {
+		sum := sha256.Sum256([]byte(ro.Name + "\x00" + caller))
+		bucket = binary.BigEndian.Uint64(sum[:8]) % rolloutBuckets
+	}
+	return float64(bucket) < ro.Percent*rolloutBuckets/100
+}
+
+// rolloutCaller identifies the caller for canary placement, empty for
+// anonymous callers
+func rolloutCaller(ctx context.Context) string {
+	keyID := apiKeyIDFromContext(ctx)
+	if keyID == anonymousKeyID {
+		keyID = ""
+	}
+	if id := tenantID(ctx); id != "" {
+		return id + "/" + keyID
+	}
+	return keyID
+}
+
+// record adds a served request to the metrics of its rollout arm
+func (r *rolloutRouter) record(ctx context.Context, mode genaidemo.Mode, arm, defaultModel string, elapsed time.Duration, response *genaidemo.ChatResponse, err error) {
+	recordVariant(ctx, rolloutMetrics, mode, arm, defaultModel, elapsed, response, err)
+}
+
+// recordFeedback adds feedback on a reply to the metrics of the rollout arm
+// that served it, or withdraws replaced feedback with a negative sign
+func (r *rolloutRouter) recordFeedback(f *feedbackRecord, sign int64) {
+	if f.Rollout != "" {
+		recordVariantFeedback(rolloutMetrics, f.Rollout, f, sign)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// safetyCategories are the harm categories safety settings may name
+var safetyCategories = []string{"HARASSMENT", "HATE_SPEECH", "SEXUALLY_EXPLICIT", "DANGEROUS_CONTENT", "CIVIC_INTEGRITY"}
+
+// safetyThresholds are the block thresholds, strictest first
+var safetyThresholds = []string{"BLOCK_LOW_AND_ABOVE", "BLOCK_MEDIUM_AND_ABOVE", "BLOCK_ONLY_HIGH", "BLOCK_NONE"}
+
+// safetyPolicy holds the safety settings configured per endpoint, applied
+// to the provider calls of requests unless they override them
+type safetyPolicy struct {
+	endpoints map[genaidemo.Mode]map[string]string
+}
+
+// newSafetyPolicy loads the safety settings file, a JSON object mapping
+// endpoint modes to the block thresholds of harm categories, e.g.
+//
+//	{"MODE_DOC": {"DANGEROUS_CONTENT": "BLOCK_ONLY_HIGH"}, "MODE_CHAT": {"HARASSMENT": "BLOCK_LOW_AND_ABOVE"}}
+//
+// Categories not configured use the provider's defaults. An empty path
+// configures none.
+func newSafetyPolicy(path string) (*safetyPolicy, error) {
+	p := &safetyPolicy{endpoints: make(map[genaidemo.Mode]map[string]string)}
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read safety settings file: %w", err)
+	}
+	var endpoints map[string]map[string]string
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse safety settings file: %w", err)
+	}
+
+	for name, thresholds := range endpoints {
+		mode := genaidemo.Mode(genaidemo.Mode_value[name])
+		if mode == genaidemo.Mode_MODE_UNKNOWN {
+			return nil, fmt.Errorf("safety settings file: unknown mode %q", name)
+		}
+		settings := make(map[string]string, len(thresholds))
+		for category, threshold := range thresholds {
+			category, threshold, err := normalizeSafetySetting(category, threshold)
+			if err != nil {
+				return nil, fmt.Errorf("safety settings file: %s: %w", name, err)
+			}
+			settings[category] = threshold
+		}
+		p.endpoints[mode] = settings
+		log.Printf("🦺 Safety settings on %s for %s", mode, strings.Join(slices.Sorted(maps.Keys(settings)), ", "))
+	}
+	return p, nil
+}
+
+// settings returns the safety settings of a request to an endpoint: the
+// endpoint's, overridden per category by the request's
+func (p *safetyPolicy) settings(mode genaidemo.Mode, requested []*genaidemo.SafetySetting) (map[string]string, error) {
+	if len(requested) == 0 {
+		return p.endpoints[mode], nil
+	}
+	settings := maps.Clone(p.endpoints[mode])
+	if settings == nil {
+		settings = make(map[string]string, len(requested))
+	}
+	for _, s := range requested {
+		category, threshold, err := normalizeSafetySetting(s.GetCategory(), s.GetThreshold())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid safety setting: %v", err)
+		}
+		settings[category] = threshold
+	}
+	return settings, nil
+}
+
+// normalizeSafetySetting validates a harm category and block threshold and
+// returns them without the enum prefixes, e.g. HARM_CATEGORY_HATE_SPEECH
+// becomes HATE_SPEECH
+func normalizeSafetySetting(category, threshold string) (string, string, error) {
+	category = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(category)), "HARM_CATEGORY_")
+	threshold = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(threshold)), "HARM_BLOCK_THRESHOLD_")
+	if !slices.Contains(safetyCategories, category) {
+		return "", "", fmt.Errorf("unknown harm category %q", category)
+	}
+	if !slices.Contains(safetyThresholds, threshold) {
+		return "", "", fmt.Errorf("unknown block threshold %q for %s", threshold, category)
+	}
+	return category, threshold, nil
+}
+
+// strictestSafetyThreshold returns the strictest threshold of settings, for
+// providers that apply one threshold to every category
+func strictestSafetyThreshold(settings map[string]string) (string, bool) {
+	strictest := -1
+	for _, threshold := range settings {
+		if i := slices.Index(safetyThresholds, threshold); i >= 0 && (strictest < 0 || i < strictest) {
+			strictest = i
+		}
+	}
+	if strictest < 0 {
+		return "", false
+	}
+	return safetyThresholds[strictest], true
+}
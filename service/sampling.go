@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validateSamplingOptions rejects sampling parameters out of the range
+// providers accept
+func validateSamplingOptions(req *genaidemo.ChatRequest) error {
+	if req.TopP != nil && (req.GetTopP() < 0 || req.GetTopP() > 1) {
+		return status.Error(codes.InvalidArgument, "top_p must be between 0 and 1")
+	}
+	if req.TopK != nil && req.GetTopK() < 1 {
+		return status.Error(codes.InvalidArgument, "top_k must be at least 1")
+	}
+	if len(req.StopSequences) > MaxStopSequences {
+		return status.Errorf(codes.InvalidArgument, "at most %d stop_sequences are allowed", MaxStopSequences)
+	}
+	if slices.Contains(req.StopSequences, "") {
+		return status.Error(codes.InvalidArgument, "stop_sequences cannot be empty")
+	}
+	for name, penalty := range map[string]*float32{"presence_penalty": req.PresencePenalty, "frequency_penalty": req.FrequencyPenalty} {
+		if penalty != nil && (*penalty < -2 || *penalty >= 2) {
+			return status.Errorf(codes.InvalidArgument, "%s must be at least -2 and below 2", name)
+		}
+	}
+	if req.CandidateCount != nil && (req.GetCandidateCount() < 1 || req.GetCandidateCount() > MaxCandidateCount) {
+		return status.Errorf(codes.InvalidArgument, "candidate_count must be between 1 and %d", MaxCandidateCount)
+	}
+	if req.N != nil && (req.GetN() < 1 || req.GetN() > MaxCompletions) {
+		return status.Errorf(codes.InvalidArgument, "n must be between 1 and %d", MaxCompletions)
+	}
+	if req.GetN() > 1 && req.GetCandidateCount() > 1 {
+		return status.Error(codes.InvalidArgument, "n cannot be combined with candidate_count")
+	}
+	if req.TopLogprobs != nil && (req.GetTopLogprobs() < 0 || req.GetTopLogprobs() > MaxTopLogprobs) {
+		return status.Errorf(codes.InvalidArgument, "top_logprobs must be between 0 and %d", MaxTopLogprobs)
+	}
+	if req.GetTopLogprobs() > 0 && !req.GetLogprobs() {
+		return status.Error(codes.InvalidArgument, "top_logprobs requires logprobs")
+	}
+	return nil
+}
+
+// withSamplingOptions makes the generation calls made with ctx use the
+// sampling parameters of req
+func withSamplingOptions(ctx context.Context, req *genaidemo.ChatRequest) context.Context {
+	if req.TopP == nil && req.TopK == nil && len(req.StopSequences) == 0 && req.PresencePenalty == nil &&
+		req.FrequencyPenalty == nil && req.CandidateCount == nil && req.Seed == nil && !req.GetLogprobs() {
+		return ctx
+	}
+	return llm.WithSamplingOptions(ctx, &llm.SamplingOptions{
+		TopP:             req.TopP,
+		TopK:             req.TopK,
+		StopSequences:    req.StopSequences,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		CandidateCount:   req.CandidateCount,
+		Seed:             req.Seed,
+		Logprobs:         req.GetLogprobs(),
+		TopLogprobs:      req.GetTopLogprobs(),
+	})
+}
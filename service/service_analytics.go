@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// ClassifyConversations labels a batch of served conversations with their
+// topic, the user's sentiment and whether the reply resolved the request
+func (s *chatService) ClassifyConversations(ctx context.Context, samples []llm.ConversationSample, topics []string) ([]llm.ConversationLabels, *llm.TokenUsage, error) {
+	startTime := time.Now()
+
+	labels, usage, err := s.llmProcessor.ClassifyConversations(ctx, samples, topics)
+	if err != nil {
+		log.Printf("❌ [ClassifyConversations] Classification failed: %v", err)
+		return nil, nil, err
+	}
+
+	log.Printf("📈 [ClassifyConversations] Classified %d conversations in %v", len(samples), time.Since(startTime))
+	return labels, usage, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"maps"
+	"strings"
 	"time"
 
 	genaidemo "github.com/example/genai-foundation-demo"
@@ -15,7 +17,35 @@ import (
 // chatService implements the Service interface for LLM interactions
 type chatService struct {
 	vertexClient *VertexAIClient
+	ttsClient    *TextToSpeechClient
+	chromaClient *ChromaDBClient
 	llmProcessor *llm.Processor
+	embedder     *llm.BatchEmbedder
+	modelName    string
+
+	// chromaCollection is the collection queried when a request names none
+	chromaCollection string
+	// chromaBootstrap creates chromaCollection on warm-up if it is missing
+	chromaBootstrap bool
+	// indexStalenessThreshold is how long a collection may go without
+	// updates before ChatWithDoc replies warn about it, 0 to never warn
+	indexStalenessThreshold time.Duration
+
+	chromaBreaker *circuitBreaker
+	searchBreaker *circuitBreaker
+
+	toolTimeout  time.Duration
+	toolTimeouts map[string]time.Duration
+
+	// nativeFunctionCalling makes ChatWithTool use Gemini's function calling
+	// protocol and send tool results back to the model
+	nativeFunctionCalling bool
+
+	// systemPrompts are the prompt templates prepended per chat mode
+	systemPrompts map[genaidemo.Mode]string
+
+	// webSearch backs the search_web tool, replaceable with a fake in tests
+	webSearch func(ctx context.Context, query string) (string, error)
 }
 
 // newService creates a new chat service with VertexAI
@@ -33,15 +63,94 @@ func newService(ctx context.Context, cfg *serviceConfig) (*chatService, error) {
 
 	fmt.Printf("✅ VertexAI client initialized successfully\n")
 
+	// 创建 Text-to-Speech 客户端 (可选，失败时禁用语音输出)
+	ttsClient, err := NewTextToSpeechClientFromConfig(ctx, cfg)
+	if err != nil {
+		fmt.Printf("⚠️  Text-to-Speech unavailable, audio responses disabled: %v\n", err)
+		ttsClient = nil
+	}
+
 	// 创建 LLM 处理器
-	llmProcessor := llm.NewProcessor(vertexClient)
+	llmProcessor := llm.NewProcessor(vertexClient, cfg.modelName)
 
 	return &chatService{
 		vertexClient: vertexClient,
+		ttsClient:    ttsClient,
+		chromaClient: NewChromaDBClientFromConfig(cfg),
 		llmProcessor: llmProcessor,
+		embedder:     llm.NewBatchEmbedder(vertexClient, cfg.embeddingBatchSize, cfg.embeddingWorkers),
+		modelName:    cfg.modelName,
+
+		chromaCollection:        cfg.chromaCollection,
+		chromaBootstrap:         cfg.chromaBootstrap,
+		indexStalenessThreshold: cfg.indexStalenessThreshold,
+
+		chromaBreaker: newCircuitBreakerFromConfig("chromadb", cfg),
+		searchBreaker: newCircuitBreakerFromConfig("tool_search_web", cfg),
+
+		toolTimeout:  cfg.toolTimeout,
+		toolTimeouts: cfg.toolTimeouts,
+
+		nativeFunctionCalling: cfg.nativeFunctionCalling,
+
+		systemPrompts: cfg.systemPrompts,
+		webSearch:     searchDuckDuckGo,
+	}, nil
+}
+
+// systemPromptTemplate returns the system prompt template of a chat mode,
+// the tenant's own when it has one
+func systemPromptTemplate(ctx context.Context, prompts map[genaidemo.Mode]string, mode genaidemo.Mode) string {
+	if t := tenantFromContext(ctx); t != nil && t.systemPrompts[mode] != "" {
+		return t.systemPrompts[mode]
+	}
+	return prompts[mode]
+}
+
+// systemPrompt renders the configured system prompt of a chat mode with the
+// given variables, returning nil when the mode has none. The response
+// language of ctx is available as {{.language}}; prompts that don't use it
+// get an instruction to reply in that language appended. The intent of the
+// request is available as {{.intent}}.
+func (s *chatService) systemPrompt(ctx context.Context, mode genaidemo.Mode, variables map[string]string) (*genaidemo.Message, error) {
+	tmpl := systemPromptTemplate(ctx, s.systemPrompts, mode)
+	if tmpl == "" {
+		return nil, nil
+	}
+	variables = maps.Clone(variables)
+	variables["language"] = languageVariable(ctx)
+	variables["intent"] = intentVariable(ctx)
+	content, err := llm.RenderTemplate(tmpl, variables)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to render %s system prompt: %v", mode, err)
+	}
+	if !strings.Contains(tmpl, ".language") {
+		content = withLanguageInstruction(ctx, content)
+	}
+	return &genaidemo.Message{
+		Role:    genaidemo.Role_ROLE_SYSTEM,
+		Content: content,
 	}, nil
 }
 
+// withSystemPrompt prepends the configured system prompt of a chat mode, if
+// any, to the conversation. Modes without one still get the response
+// language instruction.
+func (s *chatService) withSystemPrompt(ctx context.Context, mode genaidemo.Mode, messages []*genaidemo.Message) ([]*genaidemo.Message, error) {
+	prompt, err := s.systemPrompt(ctx, mode, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	if prompt == nil {
+		instruction := withLanguageInstruction(ctx, "")
+		if instruction == "" {
+			return messages, nil
+		}
+		prompt = &genaidemo.Message{Role: genaidemo.Role_ROLE_SYSTEM, Content: instruction}
+	}
+	return append([]*genaidemo.Message{prompt}, messages...), nil
+}
+
 // Chat handles chat interactions with the LLM
 func (s *chatService) Chat(ctx context.Context, messages []*genaidemo.Message, temperature *float32, maxTokens *int32) (*ChatResult, error) {
 	startTime := time.Now()
@@ -50,6 +159,11 @@ func (s *chatService) Chat(ctx context.Context, messages []*genaidemo.Message, t
 		return nil, status.Error(codes.InvalidArgument, "messages cannot be empty")
 	}
 
+	messages, err := s.withSystemPrompt(ctx, genaidemo.Mode_MODE_CHAT, messages)
+	if err != nil {
+		return nil, err
+	}
+
 	// 使用 LLM 处理器生成响应
 	result, err := s.llmProcessor.ProcessMessages(ctx, messages, temperature, maxTokens)
 	if err != nil {
@@ -57,20 +171,40 @@ func (s *chatService) Chat(ctx context.Context, messages []*genaidemo.Message, t
 	}
 
 	// 转换为服务层的结果格式
-	tokenUsage := &TokenUsageInfo{
-		InputTokens:  result.TokenUsage.InputTokens,
-		OutputTokens: result.TokenUsage.OutputTokens,
-		TotalTokens:  result.TokenUsage.TotalTokens,
-	}
+	return newChatResult(result), nil
+}
 
+// newChatResult converts the result of an LLM processor call
+func newChatResult(result *llm.ProcessResult) *ChatResult {
 	return &ChatResult{
-		Content:    result.Content,
-		TokenUsage: tokenUsage,
-	}, nil
+		Content: result.Content,
+		TokenUsage: &TokenUsageInfo{
+			InputTokens:  result.TokenUsage.InputTokens,
+			OutputTokens: result.TokenUsage.OutputTokens,
+			TotalTokens:  result.TokenUsage.TotalTokens,
+			CachedTokens: result.TokenUsage.CachedTokens,
+		},
+		FinishReason:  result.FinishReason,
+		SafetyRatings: result.SafetyRatings,
+		Timings:       ChatTimings{Generation: result.Latency},
+		Candidates:    result.Candidates,
+		Logprobs:      result.Logprobs,
+		ModelVersion:  result.ModelVersion,
+		SafetyBlock:   result.SafetyBlock,
+	}
 }
 
+// CircuitBreakers returns the state of the dependency circuit breakers
+func (s *chatService) CircuitBreakers() []circuitBreakerStatus {
+	return []circuitBreakerStatus{
+		s.vertexClient.breaker.snapshot(),
+		s.chromaBreaker.snapshot(),
+		s.searchBreaker.snapshot(),
+	}
+}
 
 // Close closes the service and cleans up resources
 func (s *chatService) Close() error {
+	s.chromaClient.Close()
 	return s.vertexClient.Close()
 }
@@ -18,23 +18,16 @@ func (s *chatService) ChatWithAgent(ctx context.Context, messages []*genaidemo.M
 		return nil, status.Error(codes.InvalidArgument, "messages cannot be empty")
 	}
 
-	// Use LLM processor to generate response with agent context
-	result, err := s.llmProcessor.ProcessMessages(ctx, messages, temperature, maxTokens)
+	messages, err := s.withSystemPrompt(ctx, genaidemo.Mode_MODE_AGENT, messages)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add agent context to response
-	enhancedContent := "[Agent Mode] " + result.Content
-
-	tokenUsage := &TokenUsageInfo{
-		InputTokens:  result.TokenUsage.InputTokens,
-		OutputTokens: result.TokenUsage.OutputTokens,
-		TotalTokens:  result.TokenUsage.TotalTokens,
+	// Use LLM processor to generate response with agent context
+	result, err := s.llmProcessor.ProcessMessages(ctx, messages, temperature, maxTokens)
+	if err != nil {
+		return nil, err
 	}
 
-	return &ChatResult{
-		Content:    enhancedContent,
-		TokenUsage: tokenUsage,
-	}, nil
+	return newChatResult(result), nil
 }
\ No newline at end of file
@@ -1,24 +1,40 @@
 package main
 
 import (
-	"bytes"
+	"cmp"
 	"context"
-	"encoding/json"
+	"expvar"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"time"
 
 	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// ragTokenMetrics splits ChatWithDoc input tokens into injected document
+// context and user conversation, exported under /api/metrics
+var ragTokenMetrics = expvar.NewMap("rag_tokens")
+
 // ChromaDBQueryRequest represents the request structure for ChromaDB queries
 type ChromaDBQueryRequest struct {
 	Query    string `json:"query"`
 	NResults int    `json:"n_results"`
+	// Collection overrides the service's default collection
+	Collection string `json:"collection,omitempty"`
+	// Where restricts results to documents whose metadata has these values
+	Where map[string]string `json:"where,omitempty"`
+	// Access restricts results to documents the caller may see, all
+	// documents when nil
+	Access *documentAccess `json:"access,omitempty"`
+	// Highlights asks for the sentences of each document most relevant to
+	// the query
+	Highlights bool `json:"highlights,omitempty"`
+	// QueryEmbedding is the query embedded with the collection's model,
+	// sent instead of having the ChromaDB service embed Query
+	QueryEmbedding []float32 `json:"query_embedding,omitempty"`
 }
 
 // ChromaDBQueryResponse represents the response structure from ChromaDB
@@ -27,50 +43,34 @@ type ChromaDBQueryResponse struct {
 	Metadatas []map[string]interface{} `json:"metadatas"`
 	Distances []float64                `json:"distances"`
 	IDs       []string                 `json:"ids"`
+	// Highlights are the relevant spans of each document, when requested
+	// and the service could embed the collection's sentences
+	Highlights [][]ChromaDBHighlight `json:"highlights,omitempty"`
 }
 
-// queryChromaDB searches ChromaDB for relevant documents
-func (s *chatService) queryChromaDB(ctx context.Context, query string, nResults int) (*ChromaDBQueryResponse, error) {
-	chromaDBURL := "http://localhost:8000/query"
-
-	reqBody := ChromaDBQueryRequest{
-		Query:    query,
-		NResults: nResults,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", chromaDBURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query ChromaDB: %w", err)
+// queryChromaDB searches ChromaDB for relevant documents. While the ChromaDB
+// circuit breaker is open it fails immediately so callers fall back right away.
+func (s *chatService) queryChromaDB(ctx context.Context, query string, opts retrievalOptions) (*ChromaDBQueryResponse, error) {
+	req := ChromaDBQueryRequest{
+		Query:      query,
+		NResults:   opts.topK,
+		Collection: opts.collection,
+		Where:      opts.filter,
+		Highlights: opts.includeHighlights,
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ChromaDB query failed with status: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+	embedding, err := s.embedQuery(ctx, cmp.Or(scopeQuery(ctx, req).Collection, s.chromaCollection), query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	var queryResp ChromaDBQueryResponse
-	if err := json.Unmarshal(body, &queryResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, err
 	}
-
-	return &queryResp, nil
+	req.QueryEmbedding = embedding
+
+	var queryResp *ChromaDBQueryResponse
+	err = s.chromaBreaker.execute(ctx, func(ctx context.Context) error {
+		var err error
+		queryResp, err = s.chromaClient.Query(ctx, req)
+		return err
+	})
+	return queryResp, err
 }
 
 // ChatWithDoc handles chat interactions with document capabilities using RAG
@@ -87,8 +87,20 @@ func (s *chatService) ChatWithDoc(ctx context.Context, messages []*genaidemo.Mes
 	log.Printf("📝 [ChatWithDoc] User query: %s", userQuery)
 
 	// 2. Search ChromaDB for relevant documents
-	log.Printf("🔍 [ChatWithDoc] Searching ChromaDB for relevant documents...")
-	chromaResp, err := s.queryChromaDB(ctx, userQuery, 3)
+	opts := retrievalOptionsFromContext(ctx)
+	log.Printf("🔍 [ChatWithDoc] Searching ChromaDB for up to %d relevant documents...", opts.topK)
+	retrievalStart := time.Now()
+	chromaResp, err := s.queryChromaDB(ctx, userQuery, opts)
+	retrieval := time.Since(retrievalStart)
+	if err != nil && ctx.Err() != nil {
+		// The caller is gone, don't spend tokens on a fallback answer
+		return nil, ctx.Err()
+	}
+	if code := status.Code(err); code == codes.InvalidArgument || code == codes.FailedPrecondition {
+		// Bad retrieval options, e.g. an unknown collection, or a collection
+		// whose embedding model doesn't match its dimension
+		return nil, err
+	}
 	if err != nil {
 		log.Printf("⚠️ [ChatWithDoc] ChromaDB query failed: %v", err)
 		// Fallback to normal chat without RAG
@@ -96,21 +108,17 @@ func (s *chatService) ChatWithDoc(ctx context.Context, messages []*genaidemo.Mes
 		if err != nil {
 			return nil, err
 		}
-		enhancedContent := "[Doc Mode - ChromaDB unavailable] " + result.Content
-		return &ChatResult{
-			Content: enhancedContent,
-			TokenUsage: &TokenUsageInfo{
-				InputTokens:  result.TokenUsage.InputTokens,
-				OutputTokens: result.TokenUsage.OutputTokens,
-				TotalTokens:  result.TokenUsage.TotalTokens,
-			},
-		}, nil
+		chatResult := newChatResult(result)
+		chatResult.DegradedReason = degradedRetrievalUnavailable
+		chatResult.Timings.Retrieval = retrieval
+		return chatResult, nil
 	}
 
 	log.Printf("📚 [ChatWithDoc] Found %d relevant documents", len(chromaResp.Documents))
 
 	// 3. Enhance prompt with retrieved context
 	contextDocs := ""
+	sources := make([]*genaidemo.RetrievedDocument, 0, len(chromaResp.Documents))
 	for i, doc := range chromaResp.Documents {
 		filename := "unknown"
 		if len(chromaResp.Metadatas) > i {
@@ -122,16 +130,53 @@ func (s *chatService) ChatWithDoc(ctx context.Context, messages []*genaidemo.Mes
 		if len(chromaResp.Distances) > i {
 			distance = chromaResp.Distances[i]
 		}
-		contextDocs += fmt.Sprintf("\n\n--- Document %d (from: %s, relevance: %.3f) ---\n%s", i+1, filename, 1.0-distance, doc)
+		if 1.0-distance < opts.minRelevance {
+			continue
+		}
+		contextDocs += fmt.Sprintf("\n\n--- Document %d (from: %s, relevance: %.3f) ---\n%s", len(sources)+1, filename, 1.0-distance, doc)
+
+		source := &genaidemo.RetrievedDocument{
+			Filename:  filename,
+			Relevance: 1.0 - distance,
+		}
+		if opts.includeChunks {
+			source.Content = doc
+		}
+		if opts.includeHighlights {
+			if len(chromaResp.Highlights) > i && chromaResp.Highlights[i] != nil {
+				source.Highlights = toHighlights(chromaResp.Highlights[i])
+			} else {
+				source.Highlights = keywordHighlights(userQuery, doc)
+			}
+		}
+		if len(chromaResp.IDs) > i {
+			source.Id = chromaResp.IDs[i]
+		}
+		sources = append(sources, source)
+	}
+	if dropped := len(chromaResp.Documents) - len(sources); dropped > 0 {
+		log.Printf("🔍 [ChatWithDoc] Dropped %d documents below relevance %.3f", dropped, opts.minRelevance)
+	}
+	// Relations of the knowledge graph around the entities of the query
+	if graph := graphContextFromContext(ctx); graph != "" {
+		contextDocs += "\n\n" + graph
+		log.Printf("🕸️ [ChatWithDoc] Added knowledge graph relations to the document context")
 	}
 
 	// Create enhanced messages with document context
 	enhancedMessages := make([]*genaidemo.Message, 0, len(messages)+1)
 
-	// Add system message with document context
-	systemMessage := &genaidemo.Message{
-		Role:    genaidemo.Role_ROLE_SYSTEM,
-		Content: fmt.Sprintf("You are a helpful AI assistant with access to relevant documents. Use the following document excerpts to help answer the user's question:\n\n=== RELEVANT DOCUMENTS ===%s\n\n=== END DOCUMENTS ===\n\nWhen answering, reference specific information from the documents when relevant. If the documents don't contain information to answer the question, say so clearly.", contextDocs),
+	// Add system message with document context, rendered from the configured
+	// DOC_SYSTEM_PROMPT template
+	systemMessage, err := s.systemPrompt(ctx, genaidemo.Mode_MODE_DOC, map[string]string{
+		"documents": contextDocs,
+		"query":     userQuery,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if systemMessage == nil {
+		systemMessage = &genaidemo.Message{Role: genaidemo.Role_ROLE_SYSTEM, Content: withLanguageInstruction(ctx, contextDocs)}
 	}
 	enhancedMessages = append(enhancedMessages, systemMessage)
 
@@ -146,18 +191,19 @@ func (s *chatService) ChatWithDoc(ctx context.Context, messages []*genaidemo.Mes
 		return nil, err
 	}
 
-	// Add RAG indicator to response
-	enhancedContent := "[RAG-Enhanced] " + result.Content
-
-	tokenUsage := &TokenUsageInfo{
-		InputTokens:  result.TokenUsage.InputTokens,
-		OutputTokens: result.TokenUsage.OutputTokens,
-		TotalTokens:  result.TokenUsage.TotalTokens,
-	}
+	// Attribute input tokens to the injected documents, capped at the total
+	// input in case the estimate exceeds the provider-reported count
+	contextTokens := min(int32(llm.CountMessageTokens(s.modelName, []*genaidemo.Message{systemMessage})), result.TokenUsage.InputTokens)
+	ragTokenMetrics.Add("requests", 1)
+	ragTokenMetrics.Add("context_tokens", int64(contextTokens))
+	ragTokenMetrics.Add("conversation_tokens", int64(result.TokenUsage.InputTokens-contextTokens))
+	log.Printf("📊 [ChatWithDoc] %d of %d input tokens from document context", contextTokens, result.TokenUsage.InputTokens)
 
 	log.Printf("✅ [ChatWithDoc] RAG response generated successfully in %v", time.Since(startTime))
-	return &ChatResult{
-		Content:    enhancedContent,
-		TokenUsage: tokenUsage,
-	}, nil
+	chatResult := newChatResult(result)
+	chatResult.TokenUsage.ContextTokens = contextTokens
+	chatResult.Sources = sources
+	chatResult.Warnings = s.staleIndexWarning(ctx, cmp.Or(opts.collection, s.chromaCollection))
+	chatResult.Timings.Retrieval = retrieval
+	return chatResult, nil
 }
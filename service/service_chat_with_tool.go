@@ -7,6 +7,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
@@ -33,6 +34,11 @@ func (s *chatService) ChatWithTool(ctx context.Context, messages []*genaidemo.Me
 	userQuery := lastMessage.Content
 	log.Printf("🔍 [ChatWithTool] Processing query: '%s'", userQuery)
 
+	// Models without function calling answer without tools
+	if toolsDisabled(ctx) {
+		return s.fallbackToBasicChat(ctx, messages, temperature, maxTokens)
+	}
+
 	// Let LLM decide whether to use tools automatically
 	return s.processWithLLMTools(ctx, messages, temperature, maxTokens, startTime)
 }
@@ -40,8 +46,19 @@ func (s *chatService) ChatWithTool(ctx context.Context, messages []*genaidemo.Me
 func (s *chatService) processWithLLMTools(ctx context.Context, messages []*genaidemo.Message, temperature *float32, maxTokens *int32, startTime time.Time) (*ChatResult, error) {
 	log.Printf("🔧 [processWithLLMTools] Starting LLM tool processing...")
 
-	// Create tool definitions for LLM
-	tools := s.createLLMTools()
+	// Create tool definitions for LLM, limited to the tenant's allowlist
+	var tools []llms.Tool
+	t := tenantFromContext(ctx)
+	for _, tool := range s.createLLMTools() {
+		if t.allowsTool(tool.Function.Name) {
+			tools = append(tools, tool)
+		}
+	}
+
+	messages, err := s.withSystemPrompt(ctx, genaidemo.Mode_MODE_TOOL, messages)
+	if err != nil {
+		return nil, err
+	}
 
 	// Convert messages to langchain format
 	llmMessages := llm.ConvertToLangchainMessages(messages)
@@ -57,36 +74,77 @@ func (s *chatService) processWithLLMTools(ctx context.Context, messages []*genai
 	if maxTokens != nil {
 		callOptions = append(callOptions, llms.WithMaxTokens(int(*maxTokens)))
 	}
-
-	// Call LLM with tools
-	response, err := s.vertexClient.client.GenerateContent(ctx, llmMessages, callOptions...)
-	if err != nil {
-		log.Printf("❌ [processWithLLMTools] LLM call failed: %v", err)
-		return nil, status.Error(codes.Internal, "LLM tool processing failed")
+	callOptions = append(callOptions, llm.SamplingCallOptions(ctx)...)
+	callOptions = append(callOptions, llm.SafetyCallOptions(ctx)...)
+	if s.nativeFunctionCalling {
+		callOptions = append(callOptions, withNativeFunctionCalling())
 	}
 
-	// Process tool calls if any
-	content, err := s.processToolCalls(ctx, response)
-	if err != nil {
-		log.Printf("❌ [processWithLLMTools] Tool call processing failed: %v", err)
-		return nil, status.Error(codes.Internal, "tool call processing failed")
-	}
+	// Accumulate token usage and timings over every LLM call made in tool mode
+	usage := &llm.TokenUsage{}
+	var generation, toolTime time.Duration
+	var invocations []*genaidemo.ToolInvocation
+	var choice *llms.ContentChoice
+	for round := 1; ; round++ {
+		// Call LLM with tools
+		generationStart := time.Now()
+		response, err := s.vertexClient.GenerateContent(ctx, llmMessages, callOptions...)
+		generation += time.Since(generationStart)
+		if err != nil {
+			log.Printf("❌ [processWithLLMTools] LLM call failed: %v", err)
+			return nil, fmt.Errorf("LLM tool processing failed: %w", err)
+		}
+		usage.Add(s.llmProcessor.ResponseUsage(messages, response))
 
-	enhancedContent := fmt.Sprintf("[Tool Mode] %s", content)
+		// Process tool calls if any
+		choice = response.Choices[0]
+		toolStart := time.Now()
+		roundInvocations, err := s.processToolCalls(ctx, choice)
+		toolTime += time.Since(toolStart)
+		if err != nil {
+			log.Printf("❌ [processWithLLMTools] Tool call processing failed: %v", err)
+			return nil, fmt.Errorf("tool call processing failed: %w", err)
+		}
+		invocations = append(invocations, roundInvocations...)
+
+		// With native function calling the results go back to the model,
+		// which answers from them or calls more functions
+		if !s.nativeFunctionCalling || len(roundInvocations) == 0 {
+			break
+		}
+		if round == MaxFunctionCallingRounds {
+			log.Printf("⚠️ [processWithLLMTools] Stopping after %d function calling rounds", round)
+			break
+		}
+		llmMessages = append(llmMessages, functionCallMessages(choice, roundInvocations)...)
+	}
 
-	// Estimate token usage (not available in ContentChoice, set to zero)
 	tokenUsage := &TokenUsageInfo{
-		InputTokens:  0,
-		OutputTokens: 0,
-		TotalTokens:  0,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		TotalTokens:  usage.TotalTokens,
+		CachedTokens: usage.CachedTokens,
 	}
 
 	log.Printf("✅ [processWithLLMTools] Completed in %v", time.Since(startTime))
 
-	return &ChatResult{
-		Content:    enhancedContent,
-		TokenUsage: tokenUsage,
-	}, nil
+	result := &ChatResult{
+		Content:         choice.Content,
+		TokenUsage:      tokenUsage,
+		ToolInvocations: invocations,
+		FinishReason:    llm.FinishReasonFromChoice(choice),
+		SafetyRatings:   llm.SafetyRatingsFromChoice(choice),
+		Logprobs:        llm.LogprobsFromChoice(choice),
+		ModelVersion:    llm.ModelVersionFromChoice(choice),
+		SafetyBlock:     llm.SafetyBlockFromChoice(choice),
+		Timings:         ChatTimings{Generation: generation, Tools: toolTime},
+	}
+	for _, invocation := range invocations {
+		if invocation.Error != "" {
+			result.DegradedReason = degradedToolFailed
+		}
+	}
+	return result, nil
 }
 
 func (s *chatService) createLLMTools() []llms.Tool {
@@ -128,41 +186,67 @@ func (s *chatService) createLLMTools() []llms.Tool {
 	}
 }
 
-func (s *chatService) processToolCalls(ctx context.Context, response *llms.ContentResponse) (string, error) {
-	// Check if there are tool calls in the response
-	if len(response.Choices) == 0 {
-		return "No response from LLM", nil
+// processToolCalls runs the tool calls the model requested concurrently, as
+// the model may request several at once, and returns their invocations in the
+// order of the calls. A failed call is recorded in its invocation rather than
+// failing the request.
+func (s *chatService) processToolCalls(ctx context.Context, choice *llms.ContentChoice) ([]*genaidemo.ToolInvocation, error) {
+	invocations := make([]*genaidemo.ToolInvocation, len(choice.ToolCalls))
+	var wg sync.WaitGroup
+	for i, toolCall := range choice.ToolCalls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			result, err := s.executeToolCall(ctx, toolCall)
+
+			invocation := &genaidemo.ToolInvocation{
+				Name:       toolCall.FunctionCall.Name,
+				Arguments:  toolCall.FunctionCall.Arguments,
+				Result:     result,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				log.Printf("❌ [processToolCalls] Tool call failed: %v", err)
+				invocation.Error = err.Error()
+			}
+			invocations[i] = invocation
+		}()
 	}
+	wg.Wait()
 
-	choice := response.Choices[0]
-
-	// If there are no tool calls, return the text content
-	if len(choice.ToolCalls) == 0 {
-		return choice.Content, nil
+	// Don't report results once the caller is gone
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	return invocations, nil
+}
 
-	// Process tool calls
-	var results []string
-	for _, toolCall := range choice.ToolCalls {
-		result, err := s.executeToolCall(ctx, toolCall)
-		if err != nil {
-			log.Printf("❌ [processToolCalls] Tool call failed: %v", err)
-			results = append(results, fmt.Sprintf("Tool call failed: %v", err))
-		} else {
-			results = append(results, result)
+// contentWithToolResults appends the tool results to the model's reply, the
+// way v1 responses report them
+func contentWithToolResults(content string, invocations []*genaidemo.ToolInvocation) string {
+	if len(invocations) == 0 {
+		return content
+	}
+	results := make([]string, len(invocations))
+	for i, invocation := range invocations {
+		results[i] = invocation.Result
+		if invocation.Error != "" {
+			results[i] = "Tool call failed: " + invocation.Error
 		}
 	}
+	return content + "\n\nTool Results:\n" + strings.Join(results, "\n")
+}
 
-	// Combine text content and tool results
-	finalContent := choice.Content
-	if len(results) > 0 {
-		finalContent += "\n\nTool Results:\n" + strings.Join(results, "\n")
+func (s *chatService) executeToolCall(ctx context.Context, toolCall llms.ToolCall) (string, error) {
+	// The model may only call tools it was offered
+	if !tenantFromContext(ctx).allowsTool(toolCall.FunctionCall.Name) {
+		return "", fmt.Errorf("tool not allowed: %s", toolCall.FunctionCall.Name)
 	}
 
-	return finalContent, nil
-}
+	ctx, cancel := context.WithTimeout(ctx, s.timeoutForTool(toolCall.FunctionCall.Name))
+	defer cancel()
 
-func (s *chatService) executeToolCall(ctx context.Context, toolCall llms.ToolCall) (string, error) {
 	switch toolCall.FunctionCall.Name {
 	case "search_web":
 		return s.executeSearchTool(ctx, toolCall.FunctionCall.Arguments)
@@ -173,6 +257,15 @@ func (s *chatService) executeToolCall(ctx context.Context, toolCall llms.ToolCal
 	}
 }
 
+// timeoutForTool returns the configured timeout of a tool, falling back to the
+// default tool timeout
+func (s *chatService) timeoutForTool(name string) time.Duration {
+	if timeout, ok := s.toolTimeouts[name]; ok {
+		return timeout
+	}
+	return s.toolTimeout
+}
+
 func (s *chatService) executeSearchTool(ctx context.Context, arguments string) (string, error) {
 	var args map[string]interface{}
 	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
@@ -186,12 +279,12 @@ func (s *chatService) executeSearchTool(ctx context.Context, arguments string) (
 
 	log.Printf("🔍 [executeSearchTool] Performing search for: %s", query)
 
-	duckduckgoTool, err := duckduckgo.New(5, "Mozilla/5.0 (compatible; GenAI-Service/1.0)")
-	if err != nil {
-		return "", fmt.Errorf("failed to initialize DuckDuckGo tool: %v", err)
-	}
-
-	result, err := duckduckgoTool.Call(ctx, query)
+	var result string
+	err := s.searchBreaker.execute(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = s.webSearch(ctx, query)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("search failed: %v", err)
 	}
@@ -200,6 +293,15 @@ func (s *chatService) executeSearchTool(ctx context.Context, arguments string) (
 	return result, nil
 }
 
+// searchDuckDuckGo is the default web search backend of the search_web tool
+func searchDuckDuckGo(ctx context.Context, query string) (string, error) {
+	duckduckgoTool, err := duckduckgo.New(5, "Mozilla/5.0 (compatible; GenAI-Service/1.0)")
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize DuckDuckGo tool: %v", err)
+	}
+	return duckduckgoTool.Call(ctx, query)
+}
+
 func (s *chatService) executeCalculatorTool(arguments string) (string, error) {
 	var args map[string]interface{}
 	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
@@ -271,19 +373,16 @@ func (s *chatService) evaluateExpression(expression string) (string, error) {
 func (s *chatService) fallbackToBasicChat(ctx context.Context, messages []*genaidemo.Message, temperature *float32, maxTokens *int32) (*ChatResult, error) {
 	log.Printf("💬 [fallbackToBasicChat] Using basic LLM processing...")
 
+	messages, err := s.withSystemPrompt(ctx, genaidemo.Mode_MODE_TOOL, messages)
+	if err != nil {
+		return nil, err
+	}
 	result, err := s.llmProcessor.ProcessMessages(ctx, messages, temperature, maxTokens)
 	if err != nil {
 		return nil, err
 	}
 
-	enhancedContent := "[Tool Mode] " + result.Content
-
-	return &ChatResult{
-		Content: enhancedContent,
-		TokenUsage: &TokenUsageInfo{
-			InputTokens:  result.TokenUsage.InputTokens,
-			OutputTokens: result.TokenUsage.OutputTokens,
-			TotalTokens:  result.TokenUsage.TotalTokens,
-		},
-	}, nil
+	chatResult := newChatResult(result)
+	chatResult.DegradedReason = degradedToolsUnavailable
+	return chatResult, nil
 }
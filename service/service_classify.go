@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// ClassifyText picks the label of a text among labels, for routing and
+// moderation without the chat pipeline
+func (s *chatService) ClassifyText(ctx context.Context, text string, labels []llm.ClassLabel) (*llm.Classification, *llm.TokenUsage, error) {
+	startTime := time.Now()
+
+	classification, usage, err := s.llmProcessor.ClassifyText(ctx, text, labels)
+	if err != nil {
+		log.Printf("❌ [Classify] Classification failed: %v", err)
+		return nil, nil, err
+	}
+
+	log.Printf("🏷️ [Classify] Classified a %d character text as %s (%.2f) with %s in %v",
+		len(text), classification.Label, classification.Confidence, modelFromContext(ctx, s.modelName), time.Since(startTime))
+	return classification, usage, nil
+}
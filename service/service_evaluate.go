@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// Evaluate scores a response with the judge model (LLM-as-judge)
+func (s *chatService) Evaluate(ctx context.Context, messages []*genaidemo.Message, response string, documents, criteria []string) (*llm.Evaluation, error) {
+	startTime := time.Now()
+	log.Printf("⚖️ [Evaluate] Judging a %d character response with %s", len(response), modelFromContext(ctx, s.modelName))
+
+	evaluation, err := s.llmProcessor.EvaluateResponse(ctx, messages, response, documents, criteria)
+	if err != nil {
+		log.Printf("❌ [Evaluate] Evaluation failed: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ [Evaluate] Completed in %v (overall score %.2f)", time.Since(startTime), evaluation.OverallScore())
+	return evaluation, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// ExtractGraph extracts the entities and relations of a conversation or
+// document text for the knowledge graph
+func (s *chatService) ExtractGraph(ctx context.Context, text string) (*llm.GraphExtraction, *llm.TokenUsage, error) {
+	startTime := time.Now()
+
+	graph, usage, err := s.llmProcessor.ExtractGraph(ctx, text, startTime)
+	if err != nil {
+		log.Printf("❌ [ExtractGraph] Extraction failed: %v", err)
+		return nil, nil, err
+	}
+
+	log.Printf("🕸️ [ExtractGraph] Extracted %d entities and %d relations in %v", len(graph.Entities), len(graph.Relations), time.Since(startTime))
+	return graph, usage, nil
+}
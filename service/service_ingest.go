@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IngestDocument extracts, chunks and embeds a document into the retrieval
+// collection through the ChromaDB service
+func (s *chatService) IngestDocument(ctx context.Context, doc ChromaDBIngestRequest, body io.Reader) (*ChromaDBIngestResponse, error) {
+	startTime := time.Now()
+	log.Printf("📥 [IngestDocument] Ingesting %s", doc.Filename)
+
+	result, err := s.chromaClient.Ingest(ctx, doc, body)
+	if err != nil {
+		log.Printf("❌ [IngestDocument] Ingesting %s failed: %v", doc.Filename, err)
+		return nil, chromaDBError(ctx, err, "document ingestion is unavailable")
+	}
+
+	log.Printf("✅ [IngestDocument] Stored %d chunks of %s in %v", result.Chunks, doc.Filename, time.Since(startTime))
+	return result, nil
+}
+
+// CollectionStats reports the size and freshness of a retrieval collection,
+// counting only the caller's documents when it has a tenant
+func (s *chatService) CollectionStats(ctx context.Context, name string) (*ChromaDBCollectionStats, error) {
+	stats, err := s.chromaClient.CollectionStats(ctx, name)
+	if err != nil {
+		log.Printf("❌ [CollectionStats] Stats of %s failed: %v", name, err)
+		return nil, chromaDBError(ctx, err, "collection stats are unavailable")
+	}
+	return stats, nil
+}
+
+// chromaDBError keeps context and gRPC status errors of a ChromaDB service
+// call, and reports any other failure as the service being unavailable
+func chromaDBError(ctx context.Context, err error, message string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return errorWithReason(codes.Unavailable, reasonDependencyUnavailable, map[string]string{"dependency": "chromadb"}, message)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// ExtractUserFacts extracts facts about the user from the latest user message
+// of a conversation, given the facts already known
+func (s *chatService) ExtractUserFacts(ctx context.Context, messages []*genaidemo.Message, known []llm.UserFact) ([]llm.UserFact, *llm.TokenUsage, error) {
+	startTime := time.Now()
+
+	facts, usage, err := s.llmProcessor.ExtractUserFacts(ctx, messages, known)
+	if err != nil {
+		log.Printf("❌ [ExtractUserFacts] Extraction failed: %v", err)
+		return nil, nil, err
+	}
+
+	log.Printf("🧠 [ExtractUserFacts] Extracted %d facts in %v", len(facts), time.Since(startTime))
+	return facts, usage, nil
+}
+
+// ExtractSessionEntities extracts the entities of the latest exchange of a
+// session and their attributes, given the entities already known
+func (s *chatService) ExtractSessionEntities(ctx context.Context, messages []*genaidemo.Message, reply string, known []llm.SessionEntity) ([]llm.SessionEntity, *llm.TokenUsage, error) {
+	startTime := time.Now()
+
+	entities, usage, err := s.llmProcessor.ExtractSessionEntities(ctx, messages, reply, known)
+	if err != nil {
+		log.Printf("❌ [ExtractSessionEntities] Extraction failed: %v", err)
+		return nil, nil, err
+	}
+
+	log.Printf("🏷️ [ExtractSessionEntities] Extracted %d entities in %v", len(entities), time.Since(startTime))
+	return entities, usage, nil
+}
+
+// GenerateTitle names a session after its first exchange
+func (s *chatService) GenerateTitle(ctx context.Context, query, reply string) (string, *llm.TokenUsage, error) {
+	startTime := time.Now()
+
+	title, usage, err := s.llmProcessor.GenerateTitle(ctx, query, reply)
+	if err != nil {
+		log.Printf("❌ [GenerateTitle] Title generation failed: %v", err)
+		return "", nil, err
+	}
+
+	log.Printf("🏷️ [GenerateTitle] Generated a title with %s in %v", modelFromContext(ctx, s.modelName), time.Since(startTime))
+	return title, usage, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/example/genai-foundation-demo/pkg/llm"
+)
+
+// DocumentChunks reads the chunks of an ingested document in order, only the
+// caller's own when it has a tenant
+func (s *chatService) DocumentChunks(ctx context.Context, doc ChromaDBDocumentRequest) ([]string, error) {
+	chunks, err := s.chromaClient.DocumentChunks(ctx, doc)
+	if err != nil {
+		log.Printf("❌ [DocumentChunks] Reading %s failed: %v", doc.Source, err)
+		return nil, chromaDBError(ctx, err, "document reads are unavailable")
+	}
+	return chunks, nil
+}
+
+// Summarize summarizes texts in order, summarizing them in parts first when
+// they exceed the context window (map-reduce)
+func (s *chatService) Summarize(ctx context.Context, texts []string, opts llm.SummaryOptions) (*llm.SummaryResult, error) {
+	startTime := time.Now()
+	log.Printf("📝 [Summarize] Summarizing %d texts with %s (%s, %s)", len(texts), modelFromContext(ctx, s.modelName), opts.Length, opts.Style)
+
+	result, err := s.llmProcessor.Summarize(ctx, texts, opts)
+	if err != nil {
+		log.Printf("❌ [Summarize] Summarization failed: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ [Summarize] Summarized %d parts in %d calls in %v", result.Parts, result.Calls, time.Since(startTime))
+	return result, nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Transcribe converts audio into text using the multimodal model
+func (s *chatService) Transcribe(ctx context.Context, data []byte, mimeType string) (string, error) {
+	startTime := time.Now()
+	log.Printf("🎙️ [Transcribe] Transcribing %d bytes of %s audio", len(data), mimeType)
+
+	text, err := s.llmProcessor.TranscribeAudio(ctx, data, mimeType)
+	if err != nil {
+		log.Printf("❌ [Transcribe] Transcription failed: %v", err)
+		return "", err
+	}
+
+	log.Printf("✅ [Transcribe] Completed in %v (%d characters)", time.Since(startTime), len(text))
+	return text, nil
+}
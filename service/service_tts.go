@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxTTSInputBytes is the Cloud Text-to-Speech limit for a single request
+const maxTTSInputBytes = 5000
+
+// Synthesize converts text into speech audio, returning the audio bytes and
+// their MIME type
+func (s *chatService) Synthesize(ctx context.Context, text string) ([]byte, string, error) {
+	if s.ttsClient == nil {
+		return nil, "", status.Error(codes.Unavailable, "text-to-speech is not configured")
+	}
+	if text == "" {
+		return nil, "", status.Error(codes.InvalidArgument, "text cannot be empty")
+	}
+
+	startTime := time.Now()
+	if len(text) > maxTTSInputBytes {
+		log.Printf("⚠️ [Synthesize] Truncating %d bytes of text to %d bytes", len(text), maxTTSInputBytes)
+		text = truncateUTF8(text, maxTTSInputBytes)
+	}
+
+	audio, mimeType, err := s.ttsClient.Synthesize(ctx, text)
+	if err != nil {
+		log.Printf("❌ [Synthesize] Synthesis failed: %v", err)
+		return nil, "", err
+	}
+
+	log.Printf("✅ [Synthesize] Generated %d bytes of %s in %v", len(audio), mimeType, time.Since(startTime))
+	return audio, mimeType, nil
+}
+
+// truncateUTF8 shortens text to at most maxBytes without splitting a rune
+func truncateUTF8(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	for maxBytes > 0 && !utf8.RuneStart(text[maxBytes]) {
+		maxBytes--
+	}
+	return text[:maxBytes]
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"expvar"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sessionIDPattern matches session ids, which are part of session keys
+var sessionIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_.:@-]{1,128}$`)
+
+// sessionEntityMetrics counts extraction calls, their failures, the entities
+// updated, the summaries injected and the sessions expired, exported under
+// /api/metrics
+var sessionEntityMetrics = expvar.NewMap("session_entities")
+
+// sessionEntityHeader introduces the entity summary injected into requests
+const sessionEntityHeader = "Entities of this conversation with their latest known attributes. They hold even if the messages that mention them are no longer shown:"
+
+// sessionEntity is an entity mentioned in a session
+type sessionEntity struct {
+	Name       string
+	Type       string
+	Attributes map[string]string
+	UpdatedAt  time.Time
+}
+
+// entitySession is the entities of a session, most recently updated last
+type entitySession struct {
+	entities []*sessionEntity
+	lastUsed time.Time
+}
+
+// sessionEntityStore keeps the entities mentioned in each session and
+// their latest attributes, in memory only: sessions are dropped once idle
+// for the TTL, or the least recently used ones beyond MaxEntitySessions.
+// Entities are immutable once stored; updates replace them.
+type sessionEntityStore struct {
+	mu       sync.Mutex
+	sessions map[string]*entitySession
+	ttl      time.Duration
+	// tokenBudget bounds the tokens of the entity summary of a request
+	tokenBudget int
+	// pending tracks the extractions running in the background
+	pending sync.WaitGroup
+}
+
+// newSessionEntityStore creates a session entity store. A zero tokenBudget
+// disables session entity memory.
+func newSessionEntityStore(ttl time.Duration, tokenBudget int) *sessionEntityStore {
+	return &sessionEntityStore{
+		sessions:    make(map[string]*entitySession),
+		ttl:         ttl,
+		tokenBudget: tokenBudget,
+	}
+}
+
+// enabled reports whether requests naming a session use and update its
+// entities
+func (s *sessionEntityStore) enabled() bool {
+	return s.tokenBudget > 0
+}
+
+// known returns the entities of a session as given to the extraction call
+func (s *sessionEntityStore) known(key string) []llm.SessionEntity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.session(key, time.Now())
+	if session == nil {
+		return nil
+	}
+	entities := make([]llm.SessionEntity, len(session.entities))
+	for i, e := range session.entities {
+		entities[i] = llm.SessionEntity{Name: e.Name, Type: e.Type, Attributes: e.Attributes}
+	}
+	return entities
+}
+
+// update merges newly extracted entities into a session and returns how many
+// were added or changed. Attributes of known entities are overwritten one by
+// one, keeping the ones not mentioned again. Beyond MaxSessionEntities, the
+// entities updated least recently are dropped.
+func (s *sessionEntityStore) update(key string, learned []llm.SessionEntity) int {
+	if len(learned) == 0 {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	session := s.session(key, now)
+	if session == nil {
+		s.evict(now)
+		session = &entitySession{lastUsed: now}
+		s.sessions[key] = session
+	}
+
+	changed := 0
+	for _, l := range learned {
+		i := slices.IndexFunc(session.entities, func(e *sessionEntity) bool {
+			return strings.EqualFold(e.Name, l.Name)
+		})
+		entity := &sessionEntity{Name: l.Name, Type: l.Type, Attributes: l.Attributes, UpdatedAt: now}
+		if i >= 0 {
+			known := session.entities[i]
+			attributes := maps.Clone(known.Attributes)
+			if attributes == nil {
+				attributes = make(map[string]string)
+			}
+			for k, v := range l.Attributes {
+				if _, ok := attributes[k]; ok || len(attributes) < llm.MaxEntityAttributes {
+					attributes[k] = v
+				}
+			}
+			if l.Type == "" || l.Type == known.Type {
+				if maps.Equal(attributes, known.Attributes) {
+					continue
+				}
+			}
+			entity = &sessionEntity{Name: known.Name, Type: cmp.Or(l.Type, known.Type), Attributes: attributes, UpdatedAt: now}
+			session.entities = slices.Delete(slices.Clone(session.entities), i, i+1)
+		}
+		// Keep the most recently updated entities last
+		session.entities = append(session.entities, entity)
+		changed++
+	}
+	if len(session.entities) > MaxSessionEntities {
+		session.entities = slices.Clone(session.entities[len(session.entities)-MaxSessionEntities:])
+	}
+	sessionEntityMetrics.Add("entities_updated", int64(changed))
+	return changed
+}
+
+// prompt returns the system message summarizing the entities of a session,
+// most recently updated first within the token budget, or "" when the
+// session has none
+func (s *sessionEntityStore) prompt(key, model string) string {
+	s.mu.Lock()
+	session := s.session(key, time.Now())
+	var entities []*sessionEntity
+	if session != nil {
+		entities = session.entities
+	}
+	s.mu.Unlock()
+	if len(entities) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(sessionEntityHeader)
+	remaining := s.tokenBudget - llm.CountTokens(model, sessionEntityHeader)
+	included := 0
+	for i := len(entities) - 1; i >= 0; i-- {
+		line := "\n- " + entities[i].describe()
+		tokens := llm.CountTokens(model, line)
+		if tokens > remaining {
+			continue
+		}
+		remaining -= tokens
+		b.WriteString(line)
+		included++
+	}
+	if included == 0 {
+		return ""
+	}
+	return b.String()
+}
+
+// close waits for the extractions running in the background
+func (s *sessionEntityStore) close() {
+	s.pending.Wait()
+}
+
+// session returns a session that hasn't expired, marking it used, or nil.
+// Callers must hold the lock.
+func (s *sessionEntityStore) session(key string, now time.Time) *entitySession {
+	session := s.sessions[key]
+	if session == nil {
+		return nil
+	}
+	if now.Sub(session.lastUsed) > s.ttl {
+		delete(s.sessions, key)
+		sessionEntityMetrics.Add("sessions_expired", 1)
+		return nil
+	}
+	session.lastUsed = now
+	return session
+}
+
+// evict makes room for a new session: expired sessions are dropped, then
+// the least recently used one if the store is still full. Callers must hold
+// the lock.
+func (s *sessionEntityStore) evict(now time.Time) {
+	if len(s.sessions) < MaxEntitySessions {
+		return
+	}
+	for key, session := range s.sessions {
+		if now.Sub(session.lastUsed) > s.ttl {
+			delete(s.sessions, key)
+			sessionEntityMetrics.Add("sessions_expired", 1)
+		}
+	}
+	if len(s.sessions) < MaxEntitySessions {
+		return
+	}
+	var oldest string
+	for key, session := range s.sessions {
+		if oldest == "" || session.lastUsed.Before(s.sessions[oldest].lastUsed) {
+			oldest = key
+		}
+	}
+	delete(s.sessions, oldest)
+}
+
+// describe renders an entity for the summary, e.g. "INC-4821 (ticket):
+// assignee: Maria Chen; status: escalated"
+func (e *sessionEntity) describe() string {
+	name := e.Name
+	if e.Type != "" {
+		name += " (" + e.Type + ")"
+	}
+	if len(e.Attributes) == 0 {
+		return name
+	}
+	attributes := make([]string, 0, len(e.Attributes))
+	for _, k := range slices.Sorted(maps.Keys(e.Attributes)) {
+		attributes = append(attributes, k+": "+e.Attributes[k])
+	}
+	return name + ": " + strings.Join(attributes, "; ")
+}
+
+// validateSessionID rejects malformed session ids
+func validateSessionID(id string) error {
+	if id != "" && !sessionIDPattern.MatchString(id) {
+		return status.Error(codes.InvalidArgument, "session_id must be 1 to 128 letters, digits or _.:@- characters")
+	}
+	return nil
+}
+
+// sessionKey returns the key of the entities of a session, within the
+// caller's tenant and API key so callers never reach each other's sessions
+func sessionKey(ctx context.Context, id string) string {
+	return fmt.Sprintf("%s/%s/%s", tenantID(ctx), apiKeyIDFromContext(ctx), id)
+}
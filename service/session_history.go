@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+)
+
+// sessionHistoryMetrics counts the sessions started, the titles generated
+// and the failed title calls, exported under /api/metrics
+var sessionHistoryMetrics = expvar.NewMap("session_history")
+
+// chatSession is a session in a user's history
+type chatSession struct {
+	ID string `json:"id"`
+	// Title is generated after the first exchange; until then Preview, the
+	// start of the first user message, stands in for it
+	Title     string    `json:"title,omitempty"`
+	Preview   string    `json:"preview"`
+	Exchanges int32     `json:"exchanges"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// sessionHistory keeps the sessions of each user, named by the session_id of
+// their requests, for client history sidebars, optionally persisting them to
+// a JSON file when a session starts or gets its title, and on shutdown.
+// Sessions idle for the retention period are dropped, as are the least
+// recently used ones beyond MaxSessionsPerUser. Sessions are immutable once
+// stored; updates replace them.
+type sessionHistory struct {
+	mu sync.Mutex
+	// users maps session owners to their sessions by id
+	users     map[string]map[string]*chatSession
+	path      string
+	retention time.Duration
+	// titleModel generates session titles; none are generated when empty
+	titleModel string
+	// titling is the sessions whose title is being generated
+	titling map[string]bool
+	// pending tracks the title calls running in the background
+	pending sync.WaitGroup
+}
+
+// newSessionHistory creates the session history, loading existing sessions
+// from path when it is set
+func newSessionHistory(path string, retention time.Duration, titleModel string) (*sessionHistory, error) {
+	s := &sessionHistory{
+		users:      make(map[string]map[string]*chatSession),
+		path:       path,
+		retention:  retention,
+		titleModel: titleModel,
+		titling:    make(map[string]bool),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.users); err != nil {
+		return nil, fmt.Errorf("failed to parse sessions: %w", err)
+	}
+	log.Printf("🗂️ Loaded the sessions of %d users from %s", len(s.users), path)
+	return s, nil
+}
+
+// touch records an exchange of a session, starting it with query as its
+// preview if it is new. It reports whether the session needs a title, in
+// which case the caller generates one and hands it to setTitle.
+func (s *sessionHistory) touch(owner, id, query string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	sessions := s.users[owner]
+	if sessions == nil {
+		sessions = make(map[string]*chatSession)
+		s.users[owner] = sessions
+	}
+	session := sessions[id]
+	if session != nil && now.Sub(session.UpdatedAt) > s.retention {
+		session = nil
+	}
+
+	var updated chatSession
+	if session != nil {
+		updated = *session
+	} else {
+		updated = chatSession{ID: id, Preview: sessionPreview(query), CreatedAt: now}
+	}
+	updated.Exchanges++
+	updated.UpdatedAt = now
+	sessions[id] = &updated
+
+	if session == nil {
+		s.evict(sessions)
+		sessionHistoryMetrics.Add("started", 1)
+		if err := s.save(); err != nil {
+			return false, err
+		}
+	}
+
+	key := owner + "/" + id
+	if s.titleModel == "" || updated.Title != "" || s.titling[key] {
+		return false, nil
+	}
+	s.titling[key] = true
+	return true, nil
+}
+
+// setTitle stores the title generated for a session, or gives up on it for
+// now when title is empty so the next exchange tries again
+func (s *sessionHistory) setTitle(owner, id, title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.titling, owner+"/"+id)
+	session := s.users[owner][id]
+	if title == "" || session == nil {
+		return nil
+	}
+	updated := *session
+	updated.Title = title
+	s.users[owner][id] = &updated
+	sessionHistoryMetrics.Add("titled", 1)
+	return s.save()
+}
+
+// list returns the sessions of an owner, most recently used first, at most
+// limit of them when it is positive
+func (s *sessionHistory) list(owner string, limit int) []*genaidemo.ChatSession {
+	s.mu.Lock()
+	cutoff := time.Now().Add(-s.retention)
+	var sessions []*chatSession
+	for _, session := range s.users[owner] {
+		if session.UpdatedAt.After(cutoff) {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(sessions, func(a, b *chatSession) int {
+		return cmp.Or(b.UpdatedAt.Compare(a.UpdatedAt), strings.Compare(a.ID, b.ID))
+	})
+	if limit > 0 && len(sessions) > limit {
+		sessions = sessions[:limit]
+	}
+	out := make([]*genaidemo.ChatSession, len(sessions))
+	for i, session := range sessions {
+		out[i] = session.toProto()
+	}
+	return out
+}
+
+// close waits for the title calls running in the background and saves the
+// sessions
+func (s *sessionHistory) close() {
+	s.pending.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(); err != nil {
+		log.Printf("⚠️ [sessions] Failed to save sessions: %v", err)
+	}
+}
+
+// evict drops the least recently used sessions of an owner beyond
+// MaxSessionsPerUser. Callers must hold the lock.
+func (s *sessionHistory) evict(sessions map[string]*chatSession) {
+	for len(sessions) > MaxSessionsPerUser {
+		var oldest *chatSession
+		for _, session := range sessions {
+			if oldest == nil || session.UpdatedAt.Before(oldest.UpdatedAt) {
+				oldest = session
+			}
+		}
+		delete(sessions, oldest.ID)
+	}
+}
+
+// save drops the sessions past retention and writes the others to the store
+// file. Callers must hold the lock.
+func (s *sessionHistory) save() error {
+	cutoff := time.Now().Add(-s.retention)
+	for owner, sessions := range s.users {
+		maps.DeleteFunc(sessions, func(_ string, session *chatSession) bool {
+			return session.UpdatedAt.Before(cutoff)
+		})
+		if len(sessions) == 0 {
+			delete(s.users, owner)
+		}
+	}
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.users)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sessions: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to save sessions: %w", err)
+	}
+	return nil
+}
+
+// toProto converts the session to its gRPC message
+func (c *chatSession) toProto() *genaidemo.ChatSession {
+	return &genaidemo.ChatSession{
+		SessionId:      c.ID,
+		Title:          cmp.Or(c.Title, c.Preview),
+		TitleGenerated: c.Title != "",
+		Exchanges:      c.Exchanges,
+		CreatedAt:      c.CreatedAt.Unix(),
+		UpdatedAt:      c.UpdatedAt.Unix(),
+	}
+}
+
+// sessionPreview returns the start of a message, on one line, to stand in
+// for the title of its session
+func sessionPreview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > MaxSessionPreviewLength {
+		return strings.TrimSpace(string(runes[:MaxSessionPreviewLength-1])) + "…"
+	}
+	return text
+}
+
+// sessionOwner returns the key of the session history of a request: its
+// user, if named, within the caller's tenant and API key so callers never
+// reach each other's sessions
+func sessionOwner(ctx context.Context) string {
+	return tenantID(ctx) + "/" + apiKeyIDFromContext(ctx) + "/" + userIDFromContext(ctx)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// tenantHeader names the caller's tenant on HTTP requests and, lower cased,
+// in gRPC metadata
+const tenantHeader = "X-Tenant-ID"
+
+// tenantMetrics counts requests per tenant, exported under /api/metrics
+var tenantMetrics = expvar.NewMap("tenants")
+
+// tenantIDPattern matches tenant ids, which also appear in usage keys and
+// default collection names
+var tenantIDPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_-]{0,54}[a-zA-Z0-9])?$`)
+
+// tenantMetadataKey is the document metadata holding the tenant a document
+// was ingested for. Retrieval of a tenant only matches its own documents.
+const tenantMetadataKey = "tenant"
+
+// tenantConfig is the configuration of one tenant in the tenants file
+type tenantConfig struct {
+	// APIKeys are the API key ids of the tenant. Requests with one of them
+	// always belong to the tenant; a tenant without keys is selected by the
+	// X-Tenant-ID header alone.
+	APIKeys []string `json:"api_keys,omitempty"`
+	// Model is the tenant's default model, the service's model when unset
+	Model string `json:"model,omitempty"`
+	// SystemPrompts override the system prompt templates per endpoint mode
+	SystemPrompts map[string]string `json:"system_prompts,omitempty"`
+	// Tools lists the tools ChatWithTool may call, every tool when unset
+	Tools []string `json:"tools,omitempty"`
+	// RateLimitRPS and RateLimitBurst limit the requests of all the tenant's
+	// callers together, unlimited when zero
+	RateLimitRPS   float64 `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst int     `json:"rate_limit_burst,omitempty"`
+	// MonthlyBudget caps the tenant's monthly spend (USD), unlimited when zero
+	MonthlyBudget float64 `json:"monthly_budget,omitempty"`
+	// Collection is the ChromaDB collection holding the tenant's documents,
+	// tenant_<id> when unset. No two tenants can share a collection.
+	Collection string `json:"collection,omitempty"`
+	// Provider are the tenant's own provider credentials. The tenant's calls
+	// are billed to its account rather than the service's.
+	Provider *tenantProviderConfig `json:"provider,omitempty"`
+}
+
+// tenant is the resolved configuration of a tenant
+type tenant struct {
+	id            string
+	hasKeys       bool
+	model         string
+	systemPrompts map[genaidemo.Mode]string
+	// tools is nil when every tool is allowed
+	tools         map[string]bool
+	monthlyBudget float64
+	collection    string
+	limiter       *rateLimiter
+	// provider is nil when the tenant uses the service's provider account
+	provider *providerCredentials
+}
+
+// usageKey is the key the tenant's usage is recorded under, next to the
+// usage of each of its API keys
+func (t *tenant) usageKey() string {
+	return "tenant:" + t.id
+}
+
+// allowsTool reports whether the tenant may call the named tool
+func (t *tenant) allowsTool(name string) bool {
+	return t == nil || t.tools == nil || t.tools[name]
+}
+
+// tenantRegistry resolves the tenant of a request from its API key or the
+// X-Tenant-ID header
+type tenantRegistry struct {
+	tenants map[string]*tenant
+	// keys maps API key ids to their tenant
+	keys map[string]*tenant
+	// collections maps vector collections to the tenant owning them
+	collections map[string]*tenant
+}
+
+// newTenantRegistry loads tenants from a JSON file mapping tenant ids to
+// their configuration, e.g.
+//
+//	{"team-a": {"api_keys": ["key_ab12cd34ef56ab78"], "model": "gemini-1.5-pro", "tools": ["calculate"], "collection": "team_a_docs"}}
+//
+// An empty path disables multi-tenancy.
+func newTenantRegistry(path string, shared *redisClient) (*tenantRegistry, error) {
+	r := &tenantRegistry{
+		tenants:     make(map[string]*tenant),
+		keys:        make(map[string]*tenant),
+		collections: make(map[string]*tenant),
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+	var configs map[string]*tenantConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %w", err)
+	}
+
+	for id, cfg := range configs {
+		t, err := newTenant(id, cfg, shared)
+		if err != nil {
+			return nil, fmt.Errorf("tenants file: %s: %w", id, err)
+		}
+		for _, keyID := range cfg.APIKeys {
+			if other, ok := r.keys[keyID]; ok {
+				return nil, fmt.Errorf("tenants file: API key %s belongs to both %s and %s", keyID, other.id, id)
+			}
+			r.keys[keyID] = t
+		}
+		if other, ok := r.collections[t.collection]; ok {
+			return nil, fmt.Errorf("tenants file: collection %s belongs to both %s and %s", t.collection, other.id, id)
+		}
+		r.collections[t.collection] = t
+		r.tenants[id] = t
+		log.Printf("🏢 Tenant %s configured (%d API keys, model %q, collection %q, own provider credentials: %t)", id, len(cfg.APIKeys), cfg.Model, t.collection, t.provider != nil)
+	}
+	return r, nil
+}
+
+// newTenant validates a tenant configuration. The tenant's rate limit is
+// shared through Redis unless shared is nil.
+func newTenant(id string, cfg *tenantConfig, shared *redisClient) (*tenant, error) {
+	if !tenantIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid tenant id")
+	}
+	if cfg.Collection != "" && !collectionNamePattern.MatchString(cfg.Collection) {
+		return nil, fmt.Errorf("invalid collection %q", cfg.Collection)
+	}
+	if cfg.MonthlyBudget < 0 {
+		return nil, fmt.Errorf("monthly_budget cannot be negative")
+	}
+
+	t := &tenant{
+		id:            id,
+		hasKeys:       len(cfg.APIKeys) > 0,
+		model:         cfg.Model,
+		systemPrompts: make(map[genaidemo.Mode]string),
+		monthlyBudget: cfg.MonthlyBudget,
+		collection:    cmp.Or(cfg.Collection, "tenant_"+id),
+		limiter:       newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, shared),
+	}
+	for name, prompt := range cfg.SystemPrompts {
+		mode := genaidemo.Mode(genaidemo.Mode_value[name])
+		if mode == genaidemo.Mode_MODE_UNKNOWN {
+			return nil, fmt.Errorf("unknown mode %q", name)
+		}
+		if err := llm.ValidateTemplate(prompt); err != nil {
+			return nil, fmt.Errorf("invalid %s system prompt: %w", name, err)
+		}
+		t.systemPrompts[mode] = prompt
+	}
+	if cfg.Provider != nil {
+		provider, err := cfg.Provider.load()
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider: %w", err)
+		}
+		t.provider = provider
+	}
+	if cfg.Tools != nil {
+		t.tools = make(map[string]bool, len(cfg.Tools))
+		for _, name := range cfg.Tools {
+			t.tools[name] = true
+		}
+	}
+	return t, nil
+}
+
+// resolve returns the tenant of a request with the given API key id and
+// requested tenant id, nil when the request has none. A key belonging to a
+// tenant can't act for another one, and tenants with API keys can only be
+// selected with one of them.
+func (r *tenantRegistry) resolve(keyID, requested string) (*tenant, error) {
+	t := r.keys[keyID]
+	if requested != "" {
+		selected, ok := r.tenants[requested]
+		if !ok || (t != nil && t != selected) || (t == nil && selected.hasKeys) {
+			return nil, errorWithReason(codes.PermissionDenied, reasonTenantDenied, map[string]string{"key_id": keyID},
+				fmt.Sprintf("%s may not act for tenant %q", keyID, requested))
+		}
+		t = selected
+	}
+	if t == nil {
+		return nil, nil
+	}
+
+	tenantMetrics.Add(t.id, 1)
+	if err := t.limiter.allow(t.usageKey()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// checkCollection returns PermissionDenied when a caller of tenant t, nil for
+// callers without a tenant, names a collection other than its own
+func (r *tenantRegistry) checkCollection(t *tenant, collection string) error {
+	if collection == "" {
+		return nil
+	}
+	owner := r.collections[collection]
+	if (t != nil && collection != t.collection) || (t == nil && owner != nil) {
+		return errorWithReason(codes.PermissionDenied, reasonTenantDenied, map[string]string{"collection": collection},
+			fmt.Sprintf("collection %q is outside the caller's tenant", collection))
+	}
+	return nil
+}
+
+// scopeQuery confines a vector store query to the tenant of ctx: its own
+// collection, and documents ingested for it. The tenant condition replaces
+// any the caller supplied, so filters can't reach other tenants' documents.
+// Documents the caller isn't entitled to are excluded too.
+func scopeQuery(ctx context.Context, req ChromaDBQueryRequest) ChromaDBQueryRequest {
+	req.Access = documentAccessFromContext(ctx)
+	t := tenantFromContext(ctx)
+	if t == nil {
+		return req
+	}
+	where := maps.Clone(req.Where)
+	if where == nil {
+		where = make(map[string]string, 1)
+	}
+	where[tenantMetadataKey] = t.id
+	req.Collection = t.collection
+	req.Where = where
+	return req
+}
+
+// scopeIngest restricts a tenant's document to its collection, tagged with
+// its id so its queries find it
+func scopeIngest(ctx context.Context, doc ChromaDBIngestRequest) ChromaDBIngestRequest {
+	if t := tenantFromContext(ctx); t != nil {
+		doc.Collection = t.collection
+		doc.Tenant = t.id
+	}
+	return doc
+}
+
+// tenantIDFromMetadata returns the tenant requested in the x-tenant-id gRPC
+// metadata, if any
+func tenantIDFromMetadata(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(tenantHeader); len(ids) > 0 {
+			return ids[0]
+		}
+	}
+	return ""
+}
+
+type tenantKey struct{}
+
+// withTenant attaches the caller's tenant to the context
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// tenantFromContext returns the caller's tenant, nil when it has none
+func tenantFromContext(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantKey{}).(*tenant)
+	return t
+}
+
+// tenantID returns the id of the caller's tenant, empty when it has none
+func tenantID(ctx context.Context) string {
+	if t := tenantFromContext(ctx); t != nil {
+		return t.id
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// providerThrottleMetrics exposes the adaptive outbound concurrency limit,
+// exported under /api/metrics
+var providerThrottleMetrics = expvar.NewMap("provider_throttle")
+
+// maxRetryAfterHint caps provider retry hints so a bogus value can't stall
+// every request
+const maxRetryAfterHint = time.Minute
+
+// adaptiveThrottle limits outbound provider concurrency with AIMD: the limit
+// halves on every rate-limit error and grows by roughly one per window of
+// successful calls. Retry-After hints from the provider pause all calls.
+type adaptiveThrottle struct {
+	name     string
+	maxLimit float64
+
+	mu          sync.Mutex
+	limit       float64
+	inFlight    int
+	pausedUntil time.Time
+	// changed is closed and replaced whenever a slot frees up or the limit
+	// changes, waking up waiting callers
+	changed chan struct{}
+}
+
+// newAdaptiveThrottle creates a throttle starting at maxLimit concurrent
+// calls. A zero maxLimit disables throttling.
+func newAdaptiveThrottle(name string, maxLimit int) *adaptiveThrottle {
+	t := &adaptiveThrottle{
+		name:     name,
+		maxLimit: float64(maxLimit),
+		limit:    float64(maxLimit),
+		changed:  make(chan struct{}),
+	}
+
+	providerThrottleMetrics.Set(name+"_limit", expvar.Func(func() any {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return int(t.limit)
+	}))
+	providerThrottleMetrics.Set(name+"_in_flight", expvar.Func(func() any {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.inFlight
+	}))
+	return t
+}
+
+// acquire waits until a call may be made: the throttle isn't paused by a
+// provider hint and fewer calls than the current limit are in flight
+func (t *adaptiveThrottle) acquire(ctx context.Context) error {
+	if t.maxLimit <= 0 {
+		return nil
+	}
+
+	for {
+		t.mu.Lock()
+		wait := time.Until(t.pausedUntil)
+		if wait <= 0 && t.inFlight < max(int(t.limit), 1) {
+			t.inFlight++
+			t.mu.Unlock()
+			return nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-timer:
+		}
+	}
+}
+
+// release frees the slot of a finished call and adapts the limit to its
+// outcome
+func (t *adaptiveThrottle) release(err error) {
+	if t.maxLimit <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inFlight--
+	switch {
+	case isRateLimited(err):
+		t.limit = max(t.limit/2, 1)
+		providerThrottleMetrics.Add(t.name+"_throttled", 1)
+		if hint := retryAfterHint(err); hint > 0 {
+			t.pausedUntil = time.Now().Add(hint)
+			log.Printf("🐢 [adaptiveThrottle] %s rate limited, limit %d, pausing %v", t.name, int(t.limit), hint)
+		} else {
+			log.Printf("🐢 [adaptiveThrottle] %s rate limited, limit %d", t.name, int(t.limit))
+		}
+	case err == nil && t.limit < t.maxLimit:
+		t.limit = math.Min(t.limit+1/t.limit, t.maxLimit)
+	}
+
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// isRateLimited reports whether err is a provider quota or rate-limit error
+func isRateLimited(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return classifyProviderError(err) == providerFailureRateLimited
+}
+
+// retryAfterHint extracts the provider's suggested delay from a Retry-After
+// header or a gRPC RetryInfo detail, returning 0 when there is none
+func retryAfterHint(err error) time.Duration {
+	var hint time.Duration
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Header != nil {
+		if seconds, convErr := strconv.Atoi(apiErr.Header.Get("Retry-After")); convErr == nil {
+			hint = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if s, ok := status.FromError(err); ok {
+		for _, detail := range s.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+				hint = info.RetryDelay.AsDuration()
+			}
+		}
+	}
+
+	return min(hint, maxRetryAfterHint)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+
+	"google.golang.org/api/texttospeech/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ttsAudioEncoding 合成音频的编码格式，MP3 在浏览器中可以直接播放
+const (
+	ttsAudioEncoding = "MP3"
+	ttsMimeType      = "audio/mpeg"
+)
+
+// TextToSpeechClient Cloud Text-to-Speech 客户端包装器
+type TextToSpeechClient struct {
+	service      *texttospeech.Service
+	languageCode string
+	voiceName    string
+}
+
+// NewTextToSpeechClientFromConfig 从配置创建 Text-to-Speech 客户端
+func NewTextToSpeechClientFromConfig(ctx context.Context, cfg *serviceConfig) (*TextToSpeechClient, error) {
+	service, err := texttospeech.NewService(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Text-to-Speech client creation failed: %v", err)
+	}
+
+	return &TextToSpeechClient{
+		service:      service,
+		languageCode: cfg.ttsLanguageCode,
+		voiceName:    cfg.ttsVoiceName,
+	}, nil
+}
+
+// Synthesize 将文本合成为音频，返回音频数据和 MIME 类型
+func (t *TextToSpeechClient) Synthesize(ctx context.Context, text string) ([]byte, string, error) {
+	req := &texttospeech.SynthesizeSpeechRequest{
+		Input: &texttospeech.SynthesisInput{Text: text},
+		Voice: &texttospeech.VoiceSelectionParams{
+			LanguageCode: t.languageCode,
+			Name:         t.voiceName,
+		},
+		AudioConfig: &texttospeech.AudioConfig{AudioEncoding: ttsAudioEncoding},
+	}
+
+	resp, err := t.service.Text.Synthesize(req).Context(ctx).Do()
+	if err != nil {
+		return nil, "", status.Errorf(codes.Internal, "Text-to-Speech synthesis failed: %v", err)
+	}
+
+	// REST 接口以 base64 形式返回音频内容
+	audio, err := base64.StdEncoding.DecodeString(resp.AudioContent)
+	if err != nil {
+		return nil, "", status.Errorf(codes.Internal, "invalid Text-to-Speech audio content: %v", err)
+	}
+
+	return audio, ttsMimeType, nil
+}
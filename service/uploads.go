@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// States of a resumable upload
+const (
+	uploadStateUploading = "uploading"
+	uploadStateIngesting = "ingesting"
+	uploadStateDone      = "done"
+	uploadStateFailed    = "failed"
+)
+
+// uploadJanitorInterval is how often expired resumable uploads are removed
+const uploadJanitorInterval = 10 * time.Minute
+
+// resumableUpload is a document uploaded over several HTTP requests. Its
+// state is persisted next to the received bytes, so uploads survive restarts.
+type resumableUpload struct {
+	ID         string            `json:"id"`
+	KeyID      string            `json:"key_id,omitempty"`
+	TenantID   string            `json:"tenant_id,omitempty"`
+	Filename   string            `json:"filename"`
+	MimeType   string            `json:"mime_type,omitempty"`
+	Size       int64             `json:"size"`
+	Sha256     string            `json:"sha256,omitempty"`
+	Collection string            `json:"collection,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Offset     int64             `json:"offset"`
+	State      string            `json:"state"`
+	Error      string            `json:"error,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Chunks     int               `json:"chunks,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+
+	// writing is set while a request appends to the upload
+	writing atomic.Bool
+}
+
+// uploadStore keeps resumable uploads in a directory, <id>.json for the state
+// of an upload and <id>.part for its bytes. Uploads expire after expiry,
+// whether complete or not.
+type uploadStore struct {
+	dir    string
+	expiry time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	uploads map[string]*resumableUpload
+}
+
+// newUploadStore opens the resumable uploads under dir, the system temp
+// directory when empty. Uploads that were being ingested when the service
+// stopped are marked failed.
+func newUploadStore(dir string, expiry time.Duration) (*uploadStore, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, "resumable")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &uploadStore{
+		dir:     dir,
+		expiry:  expiry,
+		ctx:     ctx,
+		cancel:  cancel,
+		uploads: make(map[string]*resumableUpload),
+	}
+	if err := s.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if len(s.uploads) > 0 {
+		log.Printf("📦 Resumed %d uploads from %s", len(s.uploads), dir)
+	}
+
+	s.wg.Add(1)
+	go s.janitor()
+	return s, nil
+}
+
+func (s *uploadStore) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read upload %s: %w", path, err)
+		}
+		u := &resumableUpload{}
+		if err := json.Unmarshal(data, u); err != nil {
+			log.Printf("⚠️ Skipping unreadable upload %s: %v", path, err)
+			continue
+		}
+		switch u.State {
+		case uploadStateUploading:
+			// Bytes written after the last saved offset may be incomplete,
+			// and a lost part file restarts the upload
+			info, err := os.Stat(s.partPath(u.ID))
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				u.Offset = 0
+				err = os.WriteFile(s.partPath(u.ID), nil, 0o644)
+			case err != nil:
+			case info.Size() < u.Offset:
+				u.Offset = info.Size()
+			default:
+				err = os.Truncate(s.partPath(u.ID), u.Offset)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to restore upload %s: %w", u.ID, err)
+			}
+		case uploadStateIngesting:
+			u.State = uploadStateFailed
+			u.Error = "ingestion was interrupted by a restart"
+			os.Remove(s.partPath(u.ID))
+		}
+		s.uploads[u.ID] = u
+		if err := s.save(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *uploadStore) statePath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *uploadStore) partPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+// save writes the state of an upload. Callers must hold the lock or own the
+// upload exclusively.
+func (s *uploadStore) save(u *resumableUpload) error {
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload: %w", err)
+	}
+	if err := writeFileAtomic(s.statePath(u.ID), data); err != nil {
+		return fmt.Errorf("failed to save upload: %w", err)
+	}
+	return nil
+}
+
+// create registers a new upload of the caller
+func (s *uploadStore) create(ctx context.Context, info *genaidemo.DocumentInfo) (*resumableUpload, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	now := time.Now()
+	u := &resumableUpload{
+		ID:         id,
+		KeyID:      apiKeyIDFromContext(ctx),
+		TenantID:   tenantID(ctx),
+		Filename:   info.Filename,
+		MimeType:   info.MimeType,
+		Size:       info.Size,
+		Sha256:     strings.ToLower(info.Sha256),
+		Collection: info.Collection,
+		Metadata:   info.Metadata,
+		State:      uploadStateUploading,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.expiry),
+	}
+	if err := os.WriteFile(s.partPath(id), nil, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(u); err != nil {
+		os.Remove(s.partPath(id))
+		return nil, err
+	}
+	s.uploads[id] = u
+	uploadMetrics.Add("resumable_created", 1)
+	return u, nil
+}
+
+// get returns a copy of an upload of the caller. Uploads of other API keys or
+// tenants are reported as not found.
+func (s *uploadStore) get(ctx context.Context, id string) (*resumableUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.lookup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return u.snapshot(), nil
+}
+
+// view returns a copy of an upload, taken under the lock
+func (s *uploadStore) view(u *resumableUpload) *resumableUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return u.snapshot()
+}
+
+// lookup finds an upload of the caller. Callers must hold the lock.
+func (s *uploadStore) lookup(ctx context.Context, id string) (*resumableUpload, error) {
+	u, ok := s.uploads[id]
+	if !ok || u.KeyID != apiKeyIDFromContext(ctx) || u.TenantID != tenantID(ctx) || time.Now().After(u.ExpiresAt) {
+		return nil, status.Errorf(codes.NotFound, "upload %s not found", id)
+	}
+	return u, nil
+}
+
+// update changes an upload under the lock and saves it
+func (s *uploadStore) update(u *resumableUpload, change func(u *resumableUpload)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(u)
+	if err := s.save(u); err != nil {
+		log.Printf("⚠️ Failed to save upload %s: %v", u.ID, err)
+	}
+}
+
+// remove deletes an upload and its bytes
+func (s *uploadStore) remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.lookup(ctx, id)
+	if err != nil {
+		return err
+	}
+	if u.State == uploadStateIngesting || u.writing.Load() {
+		return status.Error(codes.FailedPrecondition, "upload is being written or ingested")
+	}
+	s.delete(u)
+	return nil
+}
+
+// delete removes an upload. Callers must hold the lock.
+func (s *uploadStore) delete(u *resumableUpload) {
+	delete(s.uploads, u.ID)
+	os.Remove(s.partPath(u.ID))
+	os.Remove(s.statePath(u.ID))
+}
+
+// janitor removes expired uploads until the store is closed
+func (s *uploadStore) janitor() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(uploadJanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for _, u := range s.uploads {
+				if now.After(u.ExpiresAt) && u.State != uploadStateIngesting && !u.writing.Load() {
+					s.delete(u)
+					uploadMetrics.Add("resumable_expired", 1)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// close cancels running ingestions and waits for them
+func (s *uploadStore) close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// snapshot copies an upload. Callers must hold the lock.
+func (u *resumableUpload) snapshot() *resumableUpload {
+	return &resumableUpload{
+		ID:         u.ID,
+		KeyID:      u.KeyID,
+		TenantID:   u.TenantID,
+		Filename:   u.Filename,
+		MimeType:   u.MimeType,
+		Size:       u.Size,
+		Sha256:     u.Sha256,
+		Collection: u.Collection,
+		Metadata:   u.Metadata,
+		Offset:     u.Offset,
+		State:      u.State,
+		Error:      u.Error,
+		Source:     u.Source,
+		Chunks:     u.Chunks,
+		CreatedAt:  u.CreatedAt,
+		ExpiresAt:  u.ExpiresAt,
+	}
+}
+
+// CreateUpload starts a resumable upload of a document whose size is known
+func (h *Handler) CreateUpload(ctx context.Context, info *genaidemo.DocumentInfo) (*resumableUpload, error) {
+	if info.Size <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "size is required for resumable uploads")
+	}
+	if err := h.checkDocumentInfo(info); err != nil {
+		uploadMetrics.Add("rejected", 1)
+		return nil, err
+	}
+	if err := h.tenants.checkCollection(tenantFromContext(ctx), info.Collection); err != nil {
+		return nil, err
+	}
+	u, err := h.uploads.create(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("📦 Started upload %s of %s (%d bytes)", u.ID, u.Filename, u.Size)
+	return u, nil
+}
+
+// AppendUpload writes the bytes of body to an upload at offset, which must be
+// the number of bytes received so far. Bytes received before body fails are
+// kept, so the client resumes from the returned offset. The completed
+// document is checked against its checksum and ingested in the background.
+func (h *Handler) AppendUpload(ctx context.Context, id string, offset int64, body io.Reader) (*resumableUpload, error) {
+	h.uploads.mu.Lock()
+	u, err := h.uploads.lookup(ctx, id)
+	h.uploads.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !u.writing.CompareAndSwap(false, true) {
+		return h.uploads.view(u), status.Error(codes.Aborted, "another request is writing this upload")
+	}
+	defer u.writing.Store(false)
+
+	current := h.uploads.view(u)
+	if current.State != uploadStateUploading {
+		return current, status.Errorf(codes.FailedPrecondition, "upload is %s", current.State)
+	}
+	if offset != current.Offset {
+		return current, errorWithReason(codes.Aborted, reasonUploadOffsetMismatch,
+			map[string]string{"offset": strconv.FormatInt(current.Offset, 10)},
+			fmt.Sprintf("upload offset is %d, not %d", current.Offset, offset))
+	}
+
+	file, err := os.OpenFile(h.uploads.partPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return current, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	written, copyErr := io.Copy(file, io.LimitReader(body, current.Size-current.Offset))
+	// Persist the received bytes before recording them
+	syncErr := file.Sync()
+	file.Close()
+	if syncErr != nil {
+		return current, fmt.Errorf("failed to write upload file: %w", syncErr)
+	}
+	h.uploads.update(u, func(u *resumableUpload) { u.Offset += written })
+	if copyErr != nil {
+		log.Printf("⚠️ Upload %s interrupted at %d bytes: %v", id, current.Offset+written, copyErr)
+		return h.uploads.view(u), status.Errorf(codes.Canceled, "upload interrupted at %d bytes", current.Offset+written)
+	}
+	if n, _ := body.Read(make([]byte, 1)); n > 0 {
+		return h.uploads.view(u), status.Errorf(codes.InvalidArgument, "upload exceeds its size of %d bytes", current.Size)
+	}
+
+	if current.Offset+written == current.Size {
+		if err := h.completeUpload(ctx, u); err != nil {
+			return h.uploads.view(u), err
+		}
+	}
+	return h.uploads.view(u), nil
+}
+
+// completeUpload checks the checksum of a fully received upload and ingests
+// it in the background, bounded by the upload timeout
+func (h *Handler) completeUpload(ctx context.Context, u *resumableUpload) error {
+	path := h.uploads.partPath(u.ID)
+	if u.Sha256 != "" {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if sum != u.Sha256 {
+			uploadMetrics.Add("rejected", 1)
+			h.uploads.update(u, func(u *resumableUpload) {
+				u.State = uploadStateFailed
+				u.Error = "document checksum does not match sha256"
+			})
+			os.Remove(path)
+			return status.Error(codes.DataLoss, "document checksum does not match sha256")
+		}
+	}
+	h.uploads.update(u, func(u *resumableUpload) { u.State = uploadStateIngesting })
+	log.Printf("📄 Received %s (%d bytes) through upload %s", u.Filename, u.Size, u.ID)
+
+	// Ingestion outlives the request but keeps its tenant and API key
+	ingestCtx := context.WithoutCancel(ctx)
+	var cancel context.CancelFunc
+	if h.uploadTimeout > 0 {
+		ingestCtx, cancel = context.WithTimeout(ingestCtx, h.uploadTimeout)
+	} else {
+		ingestCtx, cancel = context.WithCancel(ingestCtx)
+	}
+	stop := context.AfterFunc(h.uploads.ctx, cancel)
+	h.uploads.wg.Add(1)
+	go func() {
+		defer h.uploads.wg.Done()
+		defer stop()
+		defer cancel()
+		h.ingestUpload(ingestCtx, u)
+	}()
+	return nil
+}
+
+func (h *Handler) ingestUpload(ctx context.Context, u *resumableUpload) {
+	path := h.uploads.partPath(u.ID)
+	defer os.Remove(path)
+
+	file, err := os.Open(path)
+	var result *ChromaDBIngestResponse
+	if err == nil {
+		result, err = h.service.IngestDocument(ctx, ChromaDBIngestRequest{
+			Filename:    u.Filename,
+			ContentType: u.MimeType,
+			Collection:  u.Collection,
+			Metadata:    u.Metadata,
+		}, file)
+		if err == nil && h.graph.enabled() {
+			if text := graphDocumentText(u.Filename, u.MimeType, file); text != "" {
+				h.learnGraph(ctx, u.Filename, text)
+			}
+		}
+		file.Close()
+	}
+	if err != nil {
+		log.Printf("❌ Ingesting upload %s failed: %v", u.ID, err)
+		h.uploads.update(u, func(u *resumableUpload) {
+			u.State = uploadStateFailed
+			u.Error = status.Convert(err).Message()
+		})
+		return
+	}
+
+	uploadMetrics.Add("documents", 1)
+	uploadMetrics.Add("bytes", u.Size)
+	h.uploads.update(u, func(u *resumableUpload) {
+		u.State = uploadStateDone
+		u.Source = result.Source
+		u.Chunks = result.Chunks
+	})
+}
+
+// fileSHA256 returns the hex SHA-256 digest of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read upload file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
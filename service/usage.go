@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// apiKeyHeader carries the caller's API key on HTTP requests and, lower
+	// cased, in gRPC metadata
+	apiKeyHeader = "X-API-Key"
+	// anonymousKeyID is used for requests without an API key
+	anonymousKeyID = "anonymous"
+	// usageBucket is the granularity of the usage time-range filters
+	usageBucket = time.Hour
+	// sharedSpendRetention is how long monthly spend is kept in Redis
+	sharedSpendRetention = 62 * 24 * time.Hour
+)
+
+type apiKeyIDKey struct{}
+
+// apiKeyID derives a stable identifier from an API key so raw keys are never
+// stored or reported
+func apiKeyID(key string) string {
+	if key == "" {
+		return anonymousKeyID
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key_" + hex.EncodeToString(sum[:8])
+}
+
+// withAPIKeyID attaches the caller's API key id to the context
+func withAPIKeyID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, apiKeyIDKey{}, id)
+}
+
+// apiKeyIDFromContext returns the caller's API key id, read from the context
+// or from the x-api-key gRPC metadata
+func apiKeyIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(apiKeyIDKey{}).(string); ok {
+		return id
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(apiKeyHeader); len(keys) > 0 {
+			return apiKeyID(keys[0])
+		}
+	}
+	return anonymousKeyID
+}
+
+// usageTotals is the usage of one API key within one bucket
+type usageTotals struct {
+	requests     int64
+	inputTokens  int64
+	outputTokens int64
+	cost         float64
+	// cachedTokens are the input tokens served from the context cache, and
+	// cacheSavings what their discount saved
+	cachedTokens int64
+	cacheSavings float64
+}
+
+// usageTracker aggregates requests, tokens and cost per API key in hourly
+// buckets, kept in memory for the retention period. With Redis, the monthly
+// cost is also totalled across replicas for budget enforcement.
+type usageTracker struct {
+	retention time.Duration
+	shared    *redisClient
+
+	mu        sync.Mutex
+	buckets   map[string]map[int64]*usageTotals
+	lastPrune time.Time
+}
+
+// newUsageTracker creates a usage tracker keeping usage for retention,
+// sharing monthly spend through Redis unless shared is nil
+func newUsageTracker(retention time.Duration, shared *redisClient) *usageTracker {
+	return &usageTracker{
+		retention: retention,
+		shared:    shared,
+		buckets:   make(map[string]map[int64]*usageTotals),
+		lastPrune: time.Now(),
+	}
+}
+
+// record adds a completed provider call to the usage of the API key, priced
+// at the rates of the model that served it
+func (t *usageTracker) record(keyID, model string, usage *TokenUsageInfo) {
+	input, cached, output := usageTokens(usage)
+	cost, savings, _ := llm.EstimateCostWithCache(model, input, cached, output)
+	t.add(keyID, input, cached, output, cost, savings)
+}
+
+// recordUnbilled adds a completed provider call billed to the caller's own
+// provider account: its tokens count, its cost doesn't
+func (t *usageTracker) recordUnbilled(keyID, _ string, usage *TokenUsageInfo) {
+	input, cached, output := usageTokens(usage)
+	t.add(keyID, input, cached, output, 0, 0)
+}
+
+func usageTokens(usage *TokenUsageInfo) (input, cached, output int64) {
+	if usage != nil {
+		input, cached, output = int64(usage.InputTokens), int64(usage.CachedTokens), int64(usage.OutputTokens)
+	}
+	return input, cached, output
+}
+
+// add adds a call with the given tokens and cost to the usage of the API key
+func (t *usageTracker) add(keyID string, input, cached, output int64, cost, savings float64) {
+	now := time.Now()
+	bucket := now.Truncate(usageBucket).Unix()
+	if t.shared != nil && cost > 0 {
+		t.addSharedSpend(keyID, now, cost)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastPrune) > usageBucket {
+		t.pruneLocked(now)
+	}
+
+	keyBuckets, ok := t.buckets[keyID]
+	if !ok {
+		keyBuckets = make(map[int64]*usageTotals)
+		t.buckets[keyID] = keyBuckets
+	}
+	totals, ok := keyBuckets[bucket]
+	if !ok {
+		totals = &usageTotals{}
+		keyBuckets[bucket] = totals
+	}
+	totals.requests++
+	totals.inputTokens += input
+	totals.outputTokens += output
+	totals.cost += cost
+	totals.cachedTokens += cached
+	totals.cacheSavings += savings
+}
+
+// pruneLocked drops buckets older than the retention period. Must be called
+// with t.mu held.
+func (t *usageTracker) pruneLocked(now time.Time) {
+	cutoff := now.Add(-t.retention).Unix()
+	for keyID, keyBuckets := range t.buckets {
+		for bucket := range keyBuckets {
+			if bucket < cutoff {
+				delete(keyBuckets, bucket)
+			}
+		}
+		if len(keyBuckets) == 0 {
+			delete(t.buckets, keyID)
+		}
+	}
+	t.lastPrune = now
+	log.Printf("🧹 [usageTracker] Pruned usage older than %v", t.retention)
+}
+
+// query sums the usage per API key over the buckets overlapping [from, to).
+// An empty keyID returns all keys; a zero from or to leaves that end open.
+func (t *usageTracker) query(keyID string, from, to time.Time) []*genaidemo.UsageRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var records []*genaidemo.UsageRecord
+	for id, keyBuckets := range t.buckets {
+		if keyID != "" && id != keyID {
+			continue
+		}
+
+		record := &genaidemo.UsageRecord{KeyId: id}
+		for bucket, totals := range keyBuckets {
+			start := time.Unix(bucket, 0)
+			if !from.IsZero() && !start.Add(usageBucket).After(from) {
+				continue
+			}
+			if !to.IsZero() && !start.Before(to) {
+				continue
+			}
+			record.Requests += totals.requests
+			record.InputTokens += totals.inputTokens
+			record.OutputTokens += totals.outputTokens
+			record.Cost += totals.cost
+			record.CachedTokens += totals.cachedTokens
+			record.CacheSavings += totals.cacheSavings
+		}
+		if record.Requests == 0 {
+			continue
+		}
+		record.TotalTokens = record.InputTokens + record.OutputTokens
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].KeyId < records[j].KeyId })
+	return records
+}
+
+// sharedSpendKey is the Redis key of the cost recorded under a usage key in
+// the calendar month (UTC) of now
+func sharedSpendKey(keyID string, now time.Time) string {
+	return redisKeyPrefix + "spend:" + now.UTC().Format("2006-01") + ":" + keyID
+}
+
+// addSharedSpend adds a cost to the monthly spend shared by all replicas.
+// Costs recorded while Redis is unavailable only count in this process.
+func (t *usageTracker) addSharedSpend(keyID string, now time.Time, cost float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.shared.timeout)
+	defer cancel()
+	key := sharedSpendKey(keyID, now)
+	if _, err := t.shared.do(ctx, "INCRBYFLOAT", key, cost); err != nil {
+		return
+	}
+	t.shared.do(ctx, "EXPIRE", key, int64(sharedSpendRetention.Seconds()))
+}
+
+// sharedMonthlySpend returns the cost recorded under a usage key by all
+// replicas in the current calendar month (UTC), false when spend isn't shared
+// or Redis is unavailable
+func (t *usageTracker) sharedMonthlySpend(keyID string) (float64, bool) {
+	if t.shared == nil {
+		return 0, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.shared.timeout)
+	defer cancel()
+	reply, err := t.shared.do(ctx, "GET", sharedSpendKey(keyID, time.Now()))
+	if err != nil {
+		return 0, false
+	}
+	spent, err := redisFloat(reply)
+	if err != nil {
+		return 0, false
+	}
+	return spent, true
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// userIDHeader names the end user whose memory a request uses. Lower cased,
+// it is the gRPC metadata key.
+const userIDHeader = "X-User-ID"
+
+// userIDPattern matches user ids, which are part of memory owner keys
+var userIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_.@-]{1,128}$`)
+
+// userMemoryMetrics counts extraction calls, their failures and the facts
+// learned and forgotten, exported under /api/metrics
+var userMemoryMetrics = expvar.NewMap("user_memory")
+
+// memoryPromptHeader introduces the remembered facts in the system message
+// injected into requests
+const memoryPromptHeader = "What you know about the user from earlier conversations. Use it when relevant, without mentioning that you remember it:"
+
+// memoryFactLabels label the facts of each category in the injected prompt
+var memoryFactLabels = map[string]string{
+	llm.UserFactName:       "Name",
+	llm.UserFactRole:       "Role",
+	llm.UserFactLanguage:   "Preferred language",
+	llm.UserFactProject:    "Project",
+	llm.UserFactPreference: "Preference",
+}
+
+// userFact is a fact learned about a user
+type userFact struct {
+	ID        string    `json:"id"`
+	Category  string    `json:"category"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// memoryStore keeps the facts learned about users, per tenant, API key and
+// user id, optionally persisting them to a JSON file so they survive
+// restarts. Facts are immutable once stored; updates replace them.
+type memoryStore struct {
+	mu       sync.RWMutex
+	profiles map[string][]*userFact
+	path     string
+	// tokenBudget bounds the tokens of the facts injected into a request
+	tokenBudget int
+	// pending tracks the extractions running in the background
+	pending sync.WaitGroup
+}
+
+// newMemoryStore creates a memory store, loading existing profiles from path
+// when it is set. A zero tokenBudget disables memory.
+func newMemoryStore(path string, tokenBudget int) (*memoryStore, error) {
+	s := &memoryStore{
+		profiles:    make(map[string][]*userFact),
+		path:        path,
+		tokenBudget: tokenBudget,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user memory: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse user memory: %w", err)
+	}
+
+	log.Printf("🧠 Loaded the memory of %d users from %s", len(s.profiles), path)
+	return s, nil
+}
+
+// enabled reports whether requests use and update user memory
+func (s *memoryStore) enabled() bool {
+	return s.tokenBudget > 0
+}
+
+// list returns the facts of a user, oldest first
+func (s *memoryStore) list(owner string) []*genaidemo.UserFact {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	facts := make([]*genaidemo.UserFact, len(s.profiles[owner]))
+	for i, f := range s.profiles[owner] {
+		facts[i] = f.toProto()
+	}
+	return facts
+}
+
+// known returns the facts of a user as given to the extraction call
+func (s *memoryStore) known(owner string) []llm.UserFact {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	facts := make([]llm.UserFact, len(s.profiles[owner]))
+	for i, f := range s.profiles[owner] {
+		facts[i] = llm.UserFact{Category: f.Category, Content: f.Content}
+	}
+	return facts
+}
+
+// remember merges newly extracted facts into the memory of a user. Name,
+// role and language replace the user's previous fact of the category, other
+// facts are added unless already known. Beyond MaxUserFacts, the facts
+// updated least recently are dropped.
+func (s *memoryStore) remember(owner string, learned []llm.UserFact) (int, error) {
+	if len(learned) == 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.profiles[owner]
+	facts := slices.Clone(old)
+	now := time.Now()
+	changed := 0
+	for _, l := range learned {
+		i := slices.IndexFunc(facts, func(f *userFact) bool {
+			if singleValuedFact(l.Category) {
+				return f.Category == l.Category
+			}
+			return f.Category == l.Category && strings.EqualFold(f.Content, l.Content)
+		})
+		if i >= 0 {
+			if facts[i].Content == l.Content {
+				continue
+			}
+			facts[i] = &userFact{ID: facts[i].ID, Category: l.Category, Content: l.Content, CreatedAt: facts[i].CreatedAt, UpdatedAt: now}
+			changed++
+			continue
+		}
+		id, err := newJobID()
+		if err != nil {
+			return 0, status.Errorf(codes.Internal, "failed to generate fact id: %v", err)
+		}
+		facts = append(facts, &userFact{ID: id, Category: l.Category, Content: l.Content, CreatedAt: now, UpdatedAt: now})
+		changed++
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	for len(facts) > MaxUserFacts {
+		oldest := 0
+		for i, f := range facts {
+			if f.UpdatedAt.Before(facts[oldest].UpdatedAt) {
+				oldest = i
+			}
+		}
+		facts = slices.Delete(facts, oldest, oldest+1)
+	}
+
+	s.profiles[owner] = facts
+	if err := s.save(); err != nil {
+		s.profiles[owner] = old
+		return 0, err
+	}
+	userMemoryMetrics.Add("facts_learned", int64(changed))
+	return changed, nil
+}
+
+// forget deletes one fact of a user, or all of them when factID is empty,
+// and returns how many were deleted
+func (s *memoryStore) forget(owner, factID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.profiles[owner]
+	if factID == "" {
+		if !ok {
+			return 0, nil
+		}
+		delete(s.profiles, owner)
+	} else {
+		i := slices.IndexFunc(old, func(f *userFact) bool { return f.ID == factID })
+		if i < 0 {
+			return 0, status.Errorf(codes.NotFound, "fact %s not found", factID)
+		}
+		if len(old) == 1 {
+			delete(s.profiles, owner)
+		} else {
+			s.profiles[owner] = slices.Delete(slices.Clone(old), i, i+1)
+		}
+	}
+	if err := s.save(); err != nil {
+		s.profiles[owner] = old
+		return 0, err
+	}
+	deleted := len(old) - len(s.profiles[owner])
+	userMemoryMetrics.Add("facts_forgotten", int64(deleted))
+	return deleted, nil
+}
+
+// prompt returns the system message telling the model what it remembers
+// about a user, or "" when nothing is remembered. Projects are only included
+// when the query mentions one of their words, other facts always are, in
+// category order and within the token budget.
+func (s *memoryStore) prompt(owner, query, model string) string {
+	s.mu.RLock()
+	facts := slices.Clone(s.profiles[owner])
+	s.mu.RUnlock()
+	if len(facts) == 0 {
+		return ""
+	}
+
+	queryWords := uniqueWords(strings.ToLower(query))
+	slices.SortStableFunc(facts, func(a, b *userFact) int {
+		return slices.Index(llm.UserFactCategories, a.Category) - slices.Index(llm.UserFactCategories, b.Category)
+	})
+
+	var b strings.Builder
+	b.WriteString(memoryPromptHeader)
+	remaining := s.tokenBudget - llm.CountTokens(model, memoryPromptHeader)
+	included := 0
+	for _, f := range facts {
+		if f.Category == llm.UserFactProject && !sharesWord(queryWords, f.Content) {
+			continue
+		}
+		line := fmt.Sprintf("\n- %s: %s", memoryFactLabels[f.Category], f.Content)
+		tokens := llm.CountTokens(model, line)
+		if tokens > remaining {
+			continue
+		}
+		remaining -= tokens
+		b.WriteString(line)
+		included++
+	}
+	if included == 0 {
+		return ""
+	}
+	return b.String()
+}
+
+// close waits for the extractions running in the background
+func (s *memoryStore) close() {
+	s.pending.Wait()
+}
+
+// save writes all profiles to the store file. Callers must hold the lock.
+func (s *memoryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.profiles, "", "  ")
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to marshal user memory: %v", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return status.Errorf(codes.Internal, "failed to save user memory: %v", err)
+	}
+	return nil
+}
+
+func (f *userFact) toProto() *genaidemo.UserFact {
+	return &genaidemo.UserFact{
+		Id:        f.ID,
+		Category:  f.Category,
+		Content:   f.Content,
+		CreatedAt: f.CreatedAt.Unix(),
+		UpdatedAt: f.UpdatedAt.Unix(),
+	}
+}
+
+// singleValuedFact reports whether a user has at most one fact of category
+func singleValuedFact(category string) bool {
+	switch category {
+	case llm.UserFactName, llm.UserFactRole, llm.UserFactLanguage:
+		return true
+	}
+	return false
+}
+
+// sharesWord reports whether text contains one of words, ignoring case and
+// words shorter than 4 characters
+func sharesWord(words []string, text string) bool {
+	for _, w := range uniqueWords(strings.ToLower(text)) {
+		if utf8.RuneCountInString(w) >= 4 {
+			if _, found := slices.BinarySearch(words, w); found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseUserID validates the user id named by a request, "" when it names none
+func parseUserID(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value != "" && !userIDPattern.MatchString(value) {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s", userIDHeader)
+	}
+	return value, nil
+}
+
+// userIDFromMetadata returns the user id named in the x-user-id gRPC metadata
+func userIDFromMetadata(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(userIDHeader); len(ids) > 0 {
+			return ids[0]
+		}
+	}
+	return ""
+}
+
+type userIDKey struct{}
+
+// withUserID attaches the end user named by the request to the context
+func withUserID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, id)
+}
+
+// userIDFromContext returns the end user named by the request, "" when none
+func userIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(userIDKey{}).(string)
+	return id
+}
+
+// memoryOwner returns the key of the memory a request uses: its user, within
+// the caller's tenant and API key so callers never reach each other's users
+func memoryOwner(ctx context.Context) (string, bool) {
+	user := userIDFromContext(ctx)
+	if user == "" {
+		return "", false
+	}
+	return tenantID(ctx) + "/" + apiKeyIDFromContext(ctx) + "/" + user, true
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/example/genai-foundation-demo/pkg/llm"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// genaiHarmCategories 安全设置的危害类别对应的 Vertex AI SDK 类别
+var genaiHarmCategories = map[string]genai.HarmCategory{
+	"HARASSMENT":        genai.HarmCategoryHarassment,
+	"HATE_SPEECH":       genai.HarmCategoryHateSpeech,
+	"SEXUALLY_EXPLICIT": genai.HarmCategorySexuallyExplicit,
+	"DANGEROUS_CONTENT": genai.HarmCategoryDangerousContent,
+}
+
+// genaiHarmThresholds 安全设置的拦截阈值对应的 Vertex AI SDK 阈值
+var genaiHarmThresholds = map[string]genai.HarmBlockThreshold{
+	"BLOCK_LOW_AND_ABOVE":    genai.HarmBlockLowAndAbove,
+	"BLOCK_MEDIUM_AND_ABOVE": genai.HarmBlockMediumAndAbove,
+	"BLOCK_ONLY_HIGH":        genai.HarmBlockOnlyHigh,
+	"BLOCK_NONE":             genai.HarmBlockNone,
+}
+
+// genaiSchemaTypes JSON Schema 类型对应的 Vertex AI SDK 类型
+var genaiSchemaTypes = map[string]genai.Type{
+	"object":  genai.TypeObject,
+	"array":   genai.TypeArray,
+	"string":  genai.TypeString,
+	"number":  genai.TypeNumber,
+	"integer": genai.TypeInteger,
+	"boolean": genai.TypeBoolean,
+}
+
+// vertexSDKLLM 用 Vertex AI SDK 处理 langchaingo 客户端不支持的调用: 上下文缓存
+// (创建缓存内容并从中继续生成) 和原生函数调用，其他调用仍由 langchaingo 客户端处理
+type vertexSDKLLM struct {
+	IVertexAI
+	client     *genai.Client
+	model      string
+	chatParams VertexAIChatParams
+
+	mu     sync.Mutex
+	cached map[string]*genai.CachedContent
+}
+
+// newVertexSDKLLM 用 modelParams 的项目和区域创建 Vertex AI SDK 客户端
+func newVertexSDKLLM(inner IVertexAI, modelParams VertexAIModelParams, chatParams VertexAIChatParams) (*vertexSDKLLM, error) {
+	var opts []option.ClientOption
+	if modelParams.Location == GlobalRegion {
+		opts = append(opts, option.WithEndpoint(GlobalEndpoint))
+	}
+	client, err := genai.NewClient(context.Background(), modelParams.Project, modelParams.Location, opts...)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Vertex AI SDK client creation failed: %v", err)
+	}
+	return &vertexSDKLLM{
+		IVertexAI:  inner,
+		client:     client,
+		model:      modelParams.LLMName,
+		chatParams: chatParams,
+		cached:     make(map[string]*genai.CachedContent),
+	}, nil
+}
+
+// CreateCachedContent 在 Vertex AI 创建缓存内容，系统消息作为其系统指令
+func (v *vertexSDKLLM) CreateCachedContent(ctx context.Context, model string, messages []llms.MessageContent, ttl time.Duration) (string, error) {
+	system, contents := toGenaiContents(messages)
+	cc, err := v.client.CreateCachedContent(ctx, &genai.CachedContent{
+		Model:             model,
+		SystemInstruction: system,
+		Contents:          contents,
+		Expiration:        genai.ExpireTimeOrTTL{TTL: ttl},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for name, c := range v.cached {
+		if expiry := c.Expiration.ExpireTime; !expiry.IsZero() && expiry.Before(now) {
+			delete(v.cached, name)
+		}
+	}
+	v.cached[cc.Name] = cc
+	return cc.Name, nil
+}
+
+// GenerateContent 处理从缓存内容继续的调用和原生函数调用，其他调用交给 langchaingo 客户端
+func (v *vertexSDKLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var opts llms.CallOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+	name, _ := opts.Metadata[metadataCachedContent].(string)
+	native, _ := opts.Metadata[metadataNativeFunctionCalling].(bool)
+	if name == "" && !native {
+		return v.IVertexAI.GenerateContent(ctx, messages, options...)
+	}
+
+	system, contents := toGenaiContents(messages)
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("no messages to send")
+	}
+	var model *genai.GenerativeModel
+	if name != "" {
+		v.mu.Lock()
+		cc := v.cached[name]
+		v.mu.Unlock()
+		if cc == nil {
+			return nil, fmt.Errorf("unknown cached content %s", name)
+		}
+		model = v.client.GenerativeModelFromCachedContent(cc)
+	} else {
+		model = v.client.GenerativeModel(cmp.Or(opts.Model, v.model))
+		model.SystemInstruction = system
+	}
+	if native {
+		model.Tools = toGenaiTools(opts.Tools)
+	}
+	v.applyCallOptions(model, &opts)
+
+	session := model.StartChat()
+	session.History = contents[:len(contents)-1]
+	resp, err := session.SendMessage(ctx, contents[len(contents)-1].Parts...)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		log.Printf("🗄️ [vertexSDKLLM] Generated from cached content %s", name)
+	}
+	return fromGenaiResponse(resp), nil
+}
+
+// applyCallOptions 将调用选项应用到模型。与 langchaingo 客户端一样，未设置的
+// 温度和最大 token 数使用客户端的默认值；安全设置按类别应用
+func (v *vertexSDKLLM) applyCallOptions(model *genai.GenerativeModel, opts *llms.CallOptions) {
+	model.SetTemperature(float32(cmp.Or(opts.Temperature, v.chatParams.Temperature)))
+	model.SetMaxOutputTokens(int32(cmp.Or(opts.MaxTokens, v.chatParams.MaxToken)))
+	if opts.TopP > 0 {
+		model.SetTopP(float32(opts.TopP))
+	}
+	if opts.TopK > 0 {
+		model.SetTopK(int32(opts.TopK))
+	}
+	if opts.CandidateCount > 0 {
+		model.SetCandidateCount(int32(opts.CandidateCount))
+	}
+	model.StopSequences = opts.StopWords
+
+	settings, _ := opts.Metadata[llm.MetadataSafetySettings].(map[string]string)
+	for category, threshold := range settings {
+		if c, ok := genaiHarmCategories[category]; ok {
+			model.SafetySettings = append(model.SafetySettings, &genai.SafetySetting{
+				Category:  c,
+				Threshold: genaiHarmThresholds[threshold],
+			})
+		}
+	}
+}
+
+// toGenaiTools 将工具定义转换为 Gemini 的函数声明
+func toGenaiTools(tools []llms.Tool) []*genai.Tool {
+	var declarations []*genai.FunctionDeclaration
+	for _, tool := range tools {
+		if tool.Function == nil {
+			continue
+		}
+		declarations = append(declarations, &genai.FunctionDeclaration{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  toGenaiSchema(tool.Function.Parameters),
+		})
+	}
+	if len(declarations) == 0 {
+		return nil
+	}
+	return []*genai.Tool{{FunctionDeclarations: declarations}}
+}
+
+// toGenaiSchema 将 JSON Schema 转换为 Gemini 的 Schema，支持 type、description、
+// properties、required、items 和 enum
+func toGenaiSchema(v any) *genai.Schema {
+	var schema map[string]any
+	switch s := v.(type) {
+	case map[string]any:
+		schema = s
+	default:
+		// 其他类型 (例如结构体) 经 JSON 转换
+		data, err := json.Marshal(v)
+		if err != nil || json.Unmarshal(data, &schema) != nil || schema == nil {
+			return nil
+		}
+	}
+
+	out := &genai.Schema{}
+	if typ, ok := schema["type"].(string); ok {
+		out.Type = genaiSchemaTypes[typ]
+	}
+	out.Description, _ = schema["description"].(string)
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		out.Properties = make(map[string]*genai.Schema, len(properties))
+		for name, property := range properties {
+			out.Properties[name] = toGenaiSchema(property)
+		}
+	}
+	out.Required = toStrings(schema["required"])
+	out.Enum = toStrings(schema["enum"])
+	if items, ok := schema["items"]; ok {
+		out.Items = toGenaiSchema(items)
+	}
+	return out
+}
+
+// toStrings 读取 []string 或 JSON 解码后的 []any
+func toStrings(v any) []string {
+	switch values := v.(type) {
+	case []string:
+		return values
+	case []any:
+		out := make([]string, 0, len(values))
+		for _, value := range values {
+			out = append(out, fmt.Sprint(value))
+		}
+		return out
+	}
+	return nil
+}
+
+// toGenaiContents 将消息转换为 Vertex AI SDK 的系统指令和对话内容。模型的工具调用
+// 转换为函数调用，同一条消息中的多个工具结果转换为同一个内容中的函数响应，
+// 与 Gemini 的并行函数调用协议一致
+func toGenaiContents(messages []llms.MessageContent) (system *genai.Content, contents []*genai.Content) {
+	for _, msg := range messages {
+		var parts []genai.Part
+		for _, part := range msg.Parts {
+			switch p := part.(type) {
+			case llms.TextContent:
+				parts = append(parts, genai.Text(p.Text))
+			case llms.ToolCall:
+				if p.FunctionCall == nil {
+					continue
+				}
+				var args map[string]any
+				json.Unmarshal([]byte(p.FunctionCall.Arguments), &args)
+				parts = append(parts, genai.FunctionCall{Name: p.FunctionCall.Name, Args: args})
+			case llms.ToolCallResponse:
+				parts = append(parts, genai.FunctionResponse{Name: p.Name, Response: toFunctionResponse(p.Content)})
+			}
+		}
+		switch msg.Role {
+		case llms.ChatMessageTypeSystem:
+			if system == nil {
+				system = &genai.Content{}
+			}
+			system.Parts = append(system.Parts, parts...)
+		case llms.ChatMessageTypeAI:
+			contents = append(contents, &genai.Content{Role: "model", Parts: parts})
+		default:
+			contents = append(contents, &genai.Content{Role: "user", Parts: parts})
+		}
+	}
+	return system, contents
+}
+
+// toFunctionResponse 读取 JSON 对象形式的工具结果，其他结果放在 result 字段中
+func toFunctionResponse(content string) map[string]any {
+	var response map[string]any
+	if err := json.Unmarshal([]byte(content), &response); err != nil || response == nil {
+		response = map[string]any{"result": content}
+	}
+	return response
+}
+
+// fromGenaiResponse 将 Vertex AI SDK 的响应转换为 langchaingo 的响应，函数调用转换为
+// 工具调用。GenerationInfo 与 langchaingo 客户端的字段一致，另外返回缓存的 token 数
+func fromGenaiResponse(resp *genai.GenerateContentResponse) *llms.ContentResponse {
+	out := &llms.ContentResponse{}
+	for _, candidate := range resp.Candidates {
+		choice := &llms.ContentChoice{
+			StopReason:     candidate.FinishReason.String(),
+			GenerationInfo: map[string]any{"safety": candidate.SafetyRatings},
+		}
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				switch p := part.(type) {
+				case genai.Text:
+					choice.Content += string(p)
+				case genai.FunctionCall:
+					args, _ := json.Marshal(p.Args)
+					choice.ToolCalls = append(choice.ToolCalls, llms.ToolCall{
+						// Gemini 的函数调用没有 ID，按名称和顺序生成
+						ID:   fmt.Sprintf("%s-%d", p.Name, len(choice.ToolCalls)),
+						Type: "function",
+						FunctionCall: &llms.FunctionCall{
+							Name:      p.Name,
+							Arguments: string(args),
+						},
+					})
+				}
+			}
+		}
+		if usage := resp.UsageMetadata; usage != nil {
+			choice.GenerationInfo["input_tokens"] = usage.PromptTokenCount
+			choice.GenerationInfo["output_tokens"] = usage.CandidatesTokenCount
+			choice.GenerationInfo["total_tokens"] = usage.TotalTokenCount
+			// The SDK's UsageMetadata has no cached token count, so native
+			// tool calls report their whole input as uncached
+		}
+		out.Choices = append(out.Choices, choice)
+	}
+	return out
+}
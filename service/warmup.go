@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bitbucket.dentsplysirona.com/mirrors/langchaingo/llms"
+)
+
+// warmUpRetryInterval is the delay between failed warm-up attempts
+const warmUpRetryInterval = 5 * time.Second
+
+// WarmUp runs cheap generation and embedding calls and resolves the ChromaDB
+// collection so the first real request doesn't pay for connection setup and
+// auth token fetches. The default collection is created empty if it is
+// missing, unless bootstrapping is disabled. ChromaDB is optional: doc mode
+// falls back without it, so a ChromaDB failure is only logged.
+func (s *chatService) WarmUp(ctx context.Context) error {
+	startTime := time.Now()
+
+	_, err := s.vertexClient.GenerateContent(ctx, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, "ping"),
+	}, llms.WithMaxTokens(1))
+	if err != nil {
+		return fmt.Errorf("warm-up generation failed: %w", err)
+	}
+
+	if _, err := s.vertexClient.CreateEmbedding(ctx, []string{"ping"}); err != nil {
+		return fmt.Errorf("warm-up embedding failed: %w", err)
+	}
+
+	if stats, err := s.chromaClient.Stats(ctx); err != nil {
+		log.Printf("⚠️ [WarmUp] ChromaDB not available, doc mode will fall back: %v", err)
+	} else {
+		log.Printf("📚 [WarmUp] ChromaDB collection ready: %v", stats)
+		if s.chromaBootstrap {
+			if _, created, err := s.chromaClient.EnsureCollection(ctx, s.chromaCollection); err != nil {
+				log.Printf("⚠️ [WarmUp] Could not create collection %s: %v", s.chromaCollection, err)
+			} else if created {
+				log.Printf("📚 [WarmUp] Created empty collection %s", s.chromaCollection)
+			}
+		}
+	}
+
+	log.Printf("✅ [WarmUp] Dependencies warmed up in %v", time.Since(startTime))
+	return nil
+}
+
+// warmUp warms up the service dependencies, retrying until they respond or
+// ctx is done, and marks the handler ready afterwards
+func (h *Handler) warmUp(ctx context.Context, timeout time.Duration) {
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := h.service.WarmUp(attemptCtx)
+		cancel()
+		if err == nil {
+			h.ready.Store(true)
+			return
+		}
+
+		log.Printf("⚠️ [WarmUp] %v, retrying in %v", err, warmUpRetryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(warmUpRetryInterval):
+		}
+	}
+}
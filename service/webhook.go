@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	genaidemo "github.com/example/genai-foundation-demo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// webhookSignatureHeader carries the hex HMAC-SHA256 of "<timestamp>.<body>"
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+
+	webhookInitialBackoff = time.Second
+	webhookMaxBackoff     = time.Minute
+)
+
+// webhookPayload is the JSON body POSTed to callback URLs
+type webhookPayload struct {
+	Event     string            `json:"event"`
+	JobID     string            `json:"job_id,omitempty"`
+	Mode      string            `json:"mode"`
+	Response  *HTTPChatResponse `json:"response,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+// newWebhookPayload builds the completion payload for a chat result
+func newWebhookPayload(ctx context.Context, mode genaidemo.Mode, response *genaidemo.ChatResponse, err error) *webhookPayload {
+	payload := &webhookPayload{
+		Event:     "chat.completed",
+		JobID:     jobIDFromContext(ctx),
+		Mode:      mode.String(),
+		Timestamp: time.Now().Unix(),
+	}
+	if err != nil {
+		payload.Event = "chat.failed"
+		payload.Error = err.Error()
+	} else if response != nil {
+		payload.Response = toHTTPChatResponse(response)
+	}
+	return payload
+}
+
+// webhookNotifier delivers signed completion callbacks in the background,
+// retrying failed deliveries with exponential backoff
+type webhookNotifier struct {
+	client      *http.Client
+	secret      []byte
+	maxAttempts int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newWebhookNotifier creates a notifier signing payloads with secret. An empty
+// secret disables signing.
+func newWebhookNotifier(secret string, maxAttempts int, timeout time.Duration) *webhookNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	if secret == "" {
+		log.Printf("⚠️  Warning: WEBHOOK_SECRET is not set, webhook payloads will not be signed")
+	}
+	// Callback hosts are checked again when dialing, so a DNS answer changed
+	// after validateCallbackURL can't point deliveries into the network
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedCallbackIP(ip) {
+				return fmt.Errorf("callback address %s is not allowed", address)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &webhookNotifier{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			// Redirects could lead deliveries to internal hosts, and count
+			// as failed deliveries instead
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// notify delivers the payload to callbackURL asynchronously
+func (n *webhookNotifier) notify(callbackURL string, payload *webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("❌ [webhook] Failed to marshal payload: %v", err)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.deliver(callbackURL, body)
+	}()
+}
+
+func (n *webhookNotifier) deliver(callbackURL string, body []byte) {
+	backoff := webhookInitialBackoff
+	for attempt := 1; attempt <= n.maxAttempts; attempt++ {
+		err := n.post(callbackURL, body)
+		if err == nil {
+			log.Printf("✅ [webhook] Delivered to %s (attempt %d)", callbackURL, attempt)
+			return
+		}
+		log.Printf("⚠️ [webhook] Delivery to %s failed (attempt %d/%d): %v", callbackURL, attempt, n.maxAttempts, err)
+
+		if attempt == n.maxAttempts {
+			break
+		}
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+	log.Printf("❌ [webhook] Giving up on %s after %d attempts", callbackURL, n.maxAttempts)
+}
+
+func (n *webhookNotifier) post(callbackURL string, body []byte) error {
+	// In-flight attempts are bounded by the client timeout rather than n.ctx,
+	// so deliveries started before shutdown still complete
+	req, err := http.NewRequest("POST", callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	if len(n.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+n.sign(timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sign computes the HMAC-SHA256 signature over "<timestamp>.<body>", so
+// receivers can reject replayed deliveries
+func (n *webhookNotifier) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// close waits for in-flight deliveries, abandoning any pending retries
+func (n *webhookNotifier) close() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+// validateCallbackURL checks that a callback URL, if set, is an absolute
+// http(s) URL whose host resolves to public addresses only, so callbacks
+// can't reach the service itself, the metadata server or the private network
+func validateCallbackURL(ctx context.Context, callbackURL string) error {
+	if callbackURL == "" {
+		return nil
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return status.Errorf(codes.InvalidArgument, "invalid callback_url: %q", callbackURL)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "callback_url host %q cannot be resolved", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if blockedCallbackIP(addr.IP) {
+			return status.Errorf(codes.InvalidArgument, "callback_url host %q is not a public address", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// blockedCallbackIP reports whether callbacks may not be delivered to ip:
+// loopback, link-local (including the metadata server), private,
+// unspecified and multicast addresses
+func blockedCallbackIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}